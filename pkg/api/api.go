@@ -108,6 +108,7 @@ func (s *Server) registerAPIs() {
 	group.Entries = append(group.Entries, s.profileAPIEntries()...)
 	group.Entries = append(group.Entries, s.prometheusMetricsAPIEntries()...)
 	group.Entries = append(group.Entries, s.logsAPIEntries()...)
+	group.Entries = append(group.Entries, s.blueGreenAPIEntries()...)
 
 	for _, fn := range appendAddonAPIs {
 		fn(s, group)
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/megaease/easegress/v2/pkg/filters/bluegreen"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+// BlueGreenPrefix is the URL prefix of APIs for the flags of BlueGreen filters.
+const BlueGreenPrefix = "/bluegreen/{pipeline}/{filter}"
+
+// BlueGreenFlag is the flag of a BlueGreen filter.
+type BlueGreenFlag struct {
+	// Color is the active color, it is empty if the flag is not set.
+	Color string `json:"color"`
+}
+
+func (s *Server) blueGreenAPIEntries() []*Entry {
+	return []*Entry{
+		{
+			Path:    BlueGreenPrefix,
+			Method:  http.MethodGet,
+			Handler: s.getBlueGreenFlag,
+		},
+		{
+			Path:    BlueGreenPrefix,
+			Method:  http.MethodPut,
+			Handler: s.setBlueGreenFlag,
+		},
+		{
+			Path:    BlueGreenPrefix,
+			Method:  http.MethodDelete,
+			Handler: s.deleteBlueGreenFlag,
+		},
+	}
+}
+
+// blueGreenKey returns the key of the flag, it returns an empty string if
+// the filter does not exist.
+func (s *Server) blueGreenKey(r *http.Request) string {
+	pipeline := chi.URLParam(r, "pipeline")
+	filter := chi.URLParam(r, "filter")
+	if !s.isFilterExist(pipeline, filter, bluegreen.Kind) {
+		return ""
+	}
+	return s.cluster.Layout().BlueGreenDataKey(pipeline, filter)
+}
+
+func (s *Server) getBlueGreenFlag(w http.ResponseWriter, r *http.Request) {
+	key := s.blueGreenKey(r)
+	if key == "" {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	value, err := s.cluster.Get(key)
+	if err != nil {
+		ClusterPanic(err)
+	}
+
+	flag := &BlueGreenFlag{}
+	if value != nil {
+		flag.Color = *value
+	}
+	WriteBody(w, r, flag)
+}
+
+func (s *Server) setBlueGreenFlag(w http.ResponseWriter, r *http.Request) {
+	key := s.blueGreenKey(r)
+	if key == "" {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	flag := &BlueGreenFlag{}
+	if err := codectool.Decode(r.Body, flag); err != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	color, err := bluegreen.ParseColor(flag.Color)
+	if err != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = s.cluster.Put(key, color); err != nil {
+		ClusterPanic(err)
+	}
+}
+
+func (s *Server) deleteBlueGreenFlag(w http.ResponseWriter, r *http.Request) {
+	key := s.blueGreenKey(r)
+	if key == "" {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	if err := s.cluster.Delete(key); err != nil {
+		ClusterPanic(err)
+	}
+}
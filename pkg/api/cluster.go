@@ -83,6 +83,42 @@ func (s *Server) _getObject(name string) *supervisor.Spec {
 	return spec
 }
 
+// isFilterExist returns whether the pipeline has a filter of the name and kind.
+func (s *Server) isFilterExist(pipeline, filter, kind string) bool {
+	spec := s._getObject(pipeline)
+	if spec == nil {
+		return false
+	}
+
+	rawSpec := spec.RawSpec()
+	var filters []interface{}
+	if f := rawSpec["filters"]; f != nil {
+		filters, _ = f.([]interface{})
+	}
+	if filters == nil {
+		return false
+	}
+
+	for i := range filters {
+		f, _ := filters[i].(map[string]interface{})
+		if f == nil {
+			continue
+		}
+
+		if n := f["name"]; n == nil || n != filter {
+			continue
+		}
+
+		if k := f["kind"]; k == nil || k != kind {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
 func (s *Server) _listObjects() []*supervisor.Spec {
 	kvs, err := s.cluster.GetPrefix(s.cluster.Layout().ConfigObjectPrefix())
 	if err != nil {
@@ -20,13 +20,11 @@ package api
 import (
 	"fmt"
 	"net/http"
-	"reflect"
 	"sort"
 
 	"github.com/go-chi/chi/v5"
 
 	"github.com/megaease/easegress/v2/pkg/filters"
-	"github.com/megaease/easegress/v2/pkg/v"
 )
 
 const (
@@ -47,6 +45,11 @@ func (s *Server) metadataAPIEntries() []*Entry {
 			Method:  "GET",
 			Handler: s.listFilters,
 		},
+		{
+			Path:    FilterMetaPrefix + "/schemas",
+			Method:  "GET",
+			Handler: s.listFilterSchemas,
+		},
 		{
 			Path:    FilterMetaPrefix + "/{kind}" + "/description",
 			Method:  "GET",
@@ -76,6 +79,27 @@ func (s *Server) listFilters(w http.ResponseWriter, r *http.Request) {
 	WriteBody(w, r, kinds)
 }
 
+// listFilterSchemas returns the JSON schemas of all filter kinds, keyed by
+// kind name, for tools to discover the specs of filters.
+func (s *Server) listFilterSchemas(w http.ResponseWriter, r *http.Request) {
+	schemas := map[string]interface{}{}
+	var err error
+	filters.WalkKind(func(k *filters.Kind) bool {
+		schemas[k.Name], err = k.Schema()
+		if err != nil {
+			err = fmt.Errorf("get schema for %v failed: %v", k.Name, err)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		HandleAPIError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	WriteBody(w, r, schemas)
+}
+
 func (s *Server) getFilterDescription(w http.ResponseWriter, r *http.Request) {
 	kind := chi.URLParam(r, "kind")
 
@@ -95,9 +119,7 @@ func (s *Server) getFilterSchema(w http.ResponseWriter, r *http.Request) {
 		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
 		return
 	}
-	specType := reflect.TypeOf(k.DefaultSpec())
-
-	schema, err := v.GetSchema(specType)
+	schema, err := k.Schema()
 	if err != nil {
 		panic(fmt.Errorf("get schema for %v failed: %v", kind, err))
 	}
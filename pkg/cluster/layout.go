@@ -44,6 +44,12 @@ const (
 	wasmDataPrefixFormat      = "/wasm/data/%s/%s/" // + pipelineName + filterName
 	customDataKindPrefix      = "/custom-data-kinds/"
 	customDataPrefix          = "/custom-data/"
+	sessionDataPrefixFormat   = "/session/data/%s/"     // +storeName
+	quotaDataPrefixFormat     = "/quota/data/%s/%s/"    // +pipelineName +filterName
+	nonceDataPrefixFormat     = "/nonce/data/%s/%s/"    // +pipelineName +filterName
+	rampDataFormat            = "/ramp/data/%s/%s/%s"   // +pipelineName +poolName +memberName
+	blueGreenDataFormat       = "/bluegreen/data/%s/%s" // +pipelineName +filterName
+	cachePurgeFormat          = "/cache/purge/%s"       // +storeName
 
 	// the cluster name of this eg group will be registered under this path in etcd
 	// any new member(primary or secondary ) will be rejected if it is configured a different cluster name
@@ -157,3 +163,34 @@ func (l *Layout) CustomDataPrefix() string {
 func (l *Layout) CustomDataKindPrefix() string {
 	return customDataKindPrefix
 }
+
+// SessionDataPrefix returns the prefix of the session data of a session store.
+func (l *Layout) SessionDataPrefix(storeName string) string {
+	return fmt.Sprintf(sessionDataPrefixFormat, storeName)
+}
+
+// QuotaDataPrefix returns the prefix of the quota counters of a filter.
+func (l *Layout) QuotaDataPrefix(pipeline string, name string) string {
+	return fmt.Sprintf(quotaDataPrefixFormat, pipeline, name)
+}
+
+// NonceDataPrefix returns the prefix of the nonces tracked by a filter.
+func (l *Layout) NonceDataPrefix(pipeline string, name string) string {
+	return fmt.Sprintf(nonceDataPrefixFormat, pipeline, name)
+}
+
+// RampDataKey returns the key of the ramp state of a server pool, the ramp
+// state is per member, because every member ramps by its own traffic.
+func (l *Layout) RampDataKey(pipeline string, pool string) string {
+	return fmt.Sprintf(rampDataFormat, pipeline, pool, l.memberName)
+}
+
+// BlueGreenDataKey returns the key of the active color of a BlueGreen filter.
+func (l *Layout) BlueGreenDataKey(pipeline string, name string) string {
+	return fmt.Sprintf(blueGreenDataFormat, pipeline, name)
+}
+
+// CachePurgeKey returns the key of the purge events of a cache store.
+func (l *Layout) CachePurgeKey(store string) string {
+	return fmt.Sprintf(cachePurgeFormat, store)
+}
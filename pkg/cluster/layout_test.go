@@ -67,6 +67,10 @@ func TestLayout(t *testing.T) {
 		t.Error("WasmDataPrefix empty")
 	}
 
+	assert.Equal("/session/data/store/", l.SessionDataPrefix("store"))
+	assert.Equal("/quota/data/pipeline/quota/", l.QuotaDataPrefix("pipeline", "quota"))
+	assert.Equal("/nonce/data/pipeline/guard/", l.NonceDataPrefix("pipeline", "guard"))
+	assert.Equal("/ramp/data/pipeline/pool/member-1", (&Layout{memberName: "member-1"}).RampDataKey("pipeline", "pool"))
 	assert.Equal(customDataPrefix, l.CustomDataPrefix())
 	assert.Equal(customDataKindPrefix, l.CustomDataKindPrefix())
 
@@ -36,6 +36,13 @@ type Handler interface {
 	Handle(ctx *Context) string
 }
 
+// RequestBufferer is implemented by handlers which choose the strategy to
+// read the body of requests, the strategy is one of the buffering strategies
+// of the protocol, and threshold is only used by the strategies requiring it.
+type RequestBufferer interface {
+	RequestBuffering() (strategy string, threshold int64)
+}
+
 // MuxMapper gets the traffic handler by name.
 type MuxMapper interface {
 	GetHandler(name string) (Handler, bool)
@@ -78,6 +85,8 @@ type Context struct {
 	responses map[string]*responseRef
 
 	data        map[string]interface{}
+	result      string
+	err         error
 	finishFuncs []func()
 }
 
@@ -354,6 +363,28 @@ func (ctx *Context) GetData(key string) interface{} {
 	return ctx.data[key]
 }
 
+// SetResult records the result of the latest filter returning a non-empty
+// result, it is called by the pipeline.
+func (ctx *Context) SetResult(result string) {
+	ctx.result = result
+}
+
+// GetResult returns the result recorded by SetResult.
+func (ctx *Context) GetResult() string {
+	return ctx.result
+}
+
+// SetError records the detail of the error occurred while handling the
+// request, so that the filters after could report it.
+func (ctx *Context) SetError(err error) {
+	ctx.err = err
+}
+
+// GetError returns the error recorded by SetError.
+func (ctx *Context) GetError() error {
+	return ctx.err
+}
+
 // Tags joins all tags into a string and returns it.
 func (ctx *Context) Tags() string {
 	buf := bytes.Buffer{}
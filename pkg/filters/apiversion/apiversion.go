@@ -0,0 +1,305 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package apiversion implements a filter which resolves and validates the
+// API version of requests.
+package apiversion
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+const (
+	// Kind is the kind of APIVersion.
+	Kind = "APIVersion"
+
+	// StrategyHeader resolves the version from a request header.
+	StrategyHeader = "Header"
+	// StrategyPathPrefix resolves the version from the first path segment,
+	// e.g. /v1/users.
+	StrategyPathPrefix = "PathPrefix"
+	// StrategyMediaType resolves the version from a parameter of the media
+	// types in the Accept header, e.g. application/json; version=1.
+	StrategyMediaType = "MediaType"
+
+	defaultHeader             = "X-Api-Version"
+	defaultMediaTypeParameter = "version"
+
+	resultUnsupportedVersion = "unsupportedVersion"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "APIVersion resolves, validates and normalizes the API version of requests.",
+	Results:     []string{resultUnsupportedVersion},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{
+			Header:             defaultHeader,
+			MediaTypeParameter: defaultMediaTypeParameter,
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &APIVersion{spec: spec.(*Spec)}
+	},
+}
+
+var pathVersionRegexp = regexp.MustCompile(`^[vV]\d+(\.\d+)*$`)
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// APIVersion is the filter APIVersion.
+	//
+	// When there's no response in the context, APIVersion resolves the
+	// version of the request, otherwise, it adds the deprecation headers to
+	// the response if the version is deprecated. So it should be put before
+	// the proxy, and optionally in the response flow to warn clients.
+	APIVersion struct {
+		spec     *Spec
+		versions map[string]*Version
+		names    []string
+	}
+
+	// Spec is the spec of APIVersion.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Strategies are tried in order until a version is resolved.
+		Strategies         []string   `json:"strategies" jsonschema:"required,minItems=1,uniqueItems=true"`
+		Header             string     `json:"header,omitempty"`
+		MediaTypeParameter string     `json:"mediaTypeParameter,omitempty"`
+		StripPathPrefix    bool       `json:"stripPathPrefix,omitempty"`
+		Versions           []*Version `json:"versions" jsonschema:"required,minItems=1"`
+		// DefaultVersion is used if no version is resolved, a request
+		// without version is rejected if it is empty.
+		DefaultVersion string `json:"defaultVersion,omitempty"`
+	}
+
+	// Version is a supported version.
+	Version struct {
+		Name       string `json:"name" jsonschema:"required"`
+		Deprecated bool   `json:"deprecated,omitempty"`
+		// Sunset is the HTTP-date when the version will be removed.
+		Sunset string `json:"sunset,omitempty"`
+	}
+
+	// Err is the error of APIVersion.
+	Err struct {
+		Err               string   `json:"err"`
+		SupportedVersions []string `json:"supportedVersions"`
+	}
+)
+
+// normalize normalizes the version for comparison, so that "v1", "V1" and
+// "1" are the same version.
+func normalize(version string) string {
+	version = strings.ToLower(strings.TrimSpace(version))
+	return strings.TrimPrefix(version, "v")
+}
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	for _, s := range spec.Strategies {
+		switch s {
+		case StrategyHeader, StrategyPathPrefix, StrategyMediaType:
+		default:
+			return fmt.Errorf("unknown strategy %s", s)
+		}
+	}
+
+	versions := map[string]bool{}
+	for _, v := range spec.Versions {
+		n := normalize(v.Name)
+		if n == "" {
+			return fmt.Errorf("empty version name")
+		}
+		if versions[n] {
+			return fmt.Errorf("duplicated version %s", v.Name)
+		}
+		versions[n] = true
+
+		if v.Sunset != "" {
+			if _, err := http.ParseTime(v.Sunset); err != nil {
+				return fmt.Errorf("version %s: invalid sunset: %v", v.Name, err)
+			}
+		}
+	}
+
+	if spec.DefaultVersion != "" && !versions[normalize(spec.DefaultVersion)] {
+		return fmt.Errorf("default version %s is not supported", spec.DefaultVersion)
+	}
+
+	return nil
+}
+
+// Name returns the name of the APIVersion filter instance.
+func (av *APIVersion) Name() string {
+	return av.spec.Name()
+}
+
+// Kind returns the kind of APIVersion.
+func (av *APIVersion) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the APIVersion
+func (av *APIVersion) Spec() filters.Spec {
+	return av.spec
+}
+
+// Init initializes APIVersion.
+func (av *APIVersion) Init() {
+	av.reload()
+}
+
+// Inherit inherits previous generation of APIVersion.
+func (av *APIVersion) Inherit(previousGeneration filters.Filter) {
+	av.Init()
+}
+
+func (av *APIVersion) reload() {
+	if av.spec.Header == "" {
+		av.spec.Header = defaultHeader
+	}
+	if av.spec.MediaTypeParameter == "" {
+		av.spec.MediaTypeParameter = defaultMediaTypeParameter
+	}
+
+	av.versions = make(map[string]*Version, len(av.spec.Versions))
+	for _, v := range av.spec.Versions {
+		av.versions[normalize(v.Name)] = v
+		av.names = append(av.names, v.Name)
+	}
+}
+
+func (av *APIVersion) dataKey() string {
+	return "API_VERSION_" + av.Name()
+}
+
+// resolve resolves the version of the request, it returns the raw version
+// and the strategy which resolves it.
+func (av *APIVersion) resolve(req *httpprot.Request) (string, string) {
+	for _, s := range av.spec.Strategies {
+		switch s {
+		case StrategyHeader:
+			if v := req.HTTPHeader().Get(av.spec.Header); v != "" {
+				return v, s
+			}
+
+		case StrategyPathPrefix:
+			segment := strings.TrimPrefix(req.Path(), "/")
+			segment, _, _ = strings.Cut(segment, "/")
+			if pathVersionRegexp.MatchString(segment) {
+				return segment, s
+			}
+
+		case StrategyMediaType:
+			for _, accept := range req.HTTPHeader().Values("Accept") {
+				for _, mt := range strings.Split(accept, ",") {
+					_, params, err := mime.ParseMediaType(mt)
+					if err != nil {
+						continue
+					}
+					if v := params[av.spec.MediaTypeParameter]; v != "" {
+						return v, s
+					}
+				}
+			}
+		}
+	}
+
+	return "", ""
+}
+
+func (av *APIVersion) reject(ctx *context.Context, msg string) string {
+	ctx.AddTag("apiVersion: " + msg)
+
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(http.StatusBadRequest)
+	resp.HTTPHeader().Set("Content-Type", "application/json")
+	body, _ := codectool.MarshalJSON(&Err{Err: msg, SupportedVersions: av.names})
+	resp.SetPayload(body)
+	ctx.SetOutputResponse(resp)
+	return resultUnsupportedVersion
+}
+
+// Handle resolves and validates the version of the request, or adds the
+// deprecation headers to the response.
+func (av *APIVersion) Handle(ctx *context.Context) string {
+	if resp := ctx.GetInputResponse(); resp != nil {
+		if v, ok := ctx.GetData(av.dataKey()).(*Version); ok && v.Deprecated {
+			h := resp.(*httpprot.Response).HTTPHeader()
+			h.Set("Deprecation", "true")
+			if v.Sunset != "" {
+				h.Set("Sunset", v.Sunset)
+			}
+			h.Set("Warning", fmt.Sprintf(`299 - "API version %s is deprecated"`, v.Name))
+		}
+		return ""
+	}
+
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	raw, strategy := av.resolve(req)
+	if raw == "" {
+		if av.spec.DefaultVersion == "" {
+			return av.reject(ctx, "API version is required")
+		}
+		raw = av.spec.DefaultVersion
+	}
+
+	v := av.versions[normalize(raw)]
+	if v == nil {
+		return av.reject(ctx, fmt.Sprintf("unsupported API version %s", raw))
+	}
+
+	if strategy == StrategyPathPrefix && av.spec.StripPathPrefix {
+		path := strings.TrimPrefix(req.Path(), "/"+raw)
+		if path == "" {
+			path = "/"
+		}
+		req.SetPath(path)
+	}
+
+	// set the normalized version to the header for routing, this also
+	// overwrites the version in other forms sent by the client.
+	req.HTTPHeader().Set(av.spec.Header, v.Name)
+	ctx.SetData(av.dataKey(), v)
+	return ""
+}
+
+// Status returns status.
+func (av *APIVersion) Status() interface{} {
+	return nil
+}
+
+// Close closes APIVersion.
+func (av *APIVersion) Close() {
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package apiversion
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestAPIVersion(yamlConfig string) (*APIVersion, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	av := kind.CreateInstance(spec).(*APIVersion)
+	av.Init()
+	return av, nil
+}
+
+func newContext(uri string, header http.Header) (*context.Context, *httpprot.Request) {
+	stdReq := httptest.NewRequest(http.MethodGet, uri, nil)
+	for k, v := range header {
+		stdReq.Header[k] = v
+	}
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, req
+}
+
+const testConfig = `
+kind: APIVersion
+name: api-version
+strategies: [Header, PathPrefix, MediaType]
+stripPathPrefix: true
+versions:
+- name: v1
+  deprecated: true
+  sunset: "Sat, 01 Nov 2025 00:00:00 GMT"
+- name: v2
+`
+
+func TestResolve(t *testing.T) {
+	assert := assert.New(t)
+
+	av, err := newTestAPIVersion(testConfig)
+	assert.Nil(err)
+	assert.Equal(kind, av.Kind())
+	assert.Nil(av.Status())
+
+	cases := []struct {
+		uri     string
+		header  http.Header
+		version string
+		path    string
+	}{
+		{"/users", http.Header{"X-Api-Version": {"2"}}, "v2", "/users"},
+		{"/v1/users", nil, "v1", "/users"},
+		{"/V2", nil, "v2", "/"},
+		{"/users", http.Header{"Accept": {"text/html, application/json; version=1"}}, "v1", "/users"},
+		// the header has a higher priority than the path.
+		{"/v1/users", http.Header{"X-Api-Version": {"v2"}}, "v2", "/v1/users"},
+	}
+	for _, c := range cases {
+		ctx, req := newContext(c.uri, c.header)
+		assert.Equal("", av.Handle(ctx), c.uri)
+		assert.Equal(c.version, req.HTTPHeader().Get("X-Api-Version"), c.uri)
+		assert.Equal(c.path, req.Path(), c.uri)
+	}
+
+	newAv := kind.CreateInstance(av.spec).(*APIVersion)
+	newAv.Inherit(av)
+	av.Close()
+}
+
+func TestReject(t *testing.T) {
+	assert := assert.New(t)
+
+	av, err := newTestAPIVersion(testConfig)
+	assert.Nil(err)
+
+	ctx, _ := newContext("/v3/users", nil)
+	assert.Equal(resultUnsupportedVersion, av.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode())
+	e := &Err{}
+	codectool.MustUnmarshal(resp.RawPayload(), e)
+	assert.Equal("unsupported API version v3", e.Err)
+	assert.Equal([]string{"v1", "v2"}, e.SupportedVersions)
+
+	ctx, _ = newContext("/users", nil)
+	assert.Equal(resultUnsupportedVersion, av.Handle(ctx))
+
+	av.spec.DefaultVersion = "v2"
+	ctx, req := newContext("/users", nil)
+	assert.Equal("", av.Handle(ctx))
+	assert.Equal("v2", req.HTTPHeader().Get("X-Api-Version"))
+}
+
+func TestDeprecation(t *testing.T) {
+	assert := assert.New(t)
+
+	av, err := newTestAPIVersion(testConfig)
+	assert.Nil(err)
+
+	for _, c := range []struct {
+		uri        string
+		deprecated bool
+	}{{"/v1/users", true}, {"/v2/users", false}} {
+		ctx, _ := newContext(c.uri, nil)
+		assert.Equal("", av.Handle(ctx))
+
+		resp, _ := httpprot.NewResponse(nil)
+		ctx.SetOutputResponse(resp)
+		assert.Equal("", av.Handle(ctx))
+
+		h := resp.HTTPHeader()
+		if c.deprecated {
+			assert.Equal("true", h.Get("Deprecation"))
+			assert.Equal("Sat, 01 Nov 2025 00:00:00 GMT", h.Get("Sunset"))
+			assert.Equal(`299 - "API version v1 is deprecated"`, h.Get("Warning"))
+		} else {
+			assert.Empty(h.Get("Deprecation"))
+			assert.Empty(h.Get("Warning"))
+		}
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []string{`
+kind: APIVersion
+name: api-version
+strategies: [Query]
+versions: [{name: v1}]
+`, `
+kind: APIVersion
+name: api-version
+strategies: [Header]
+versions: [{name: v1}, {name: "1"}]
+`, `
+kind: APIVersion
+name: api-version
+strategies: [Header]
+versions: [{name: v1, sunset: tomorrow}]
+`, `
+kind: APIVersion
+name: api-version
+strategies: [Header]
+defaultVersion: v2
+versions: [{name: v1}]
+`} {
+		_, err := newTestAPIVersion(c)
+		assert.NotNil(err, c)
+	}
+}
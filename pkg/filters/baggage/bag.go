@@ -0,0 +1,241 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baggage
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// HeaderBaggage is the header of W3C Baggage.
+	HeaderBaggage = "Baggage"
+
+	// DataKey is the key of the Bag in the context data, templates could
+	// read the baggage by {{.data.BAGGAGE.Value "key"}}.
+	DataKey = "BAGGAGE"
+)
+
+type (
+	// Bag is the baggage of a request, changes to the bag are propagated
+	// to the upstream by the Baggage header of the request.
+	Bag struct {
+		req        *httpprot.Request
+		maxMembers int
+		maxBytes   int
+		members    []*Member
+	}
+
+	// Member is a list-member of the baggage.
+	Member struct {
+		Key   string
+		Value string
+		// Properties are the raw metadata of the member, they are kept as
+		// is when the baggage is propagated.
+		Properties string
+	}
+)
+
+// FromContext returns the baggage of the request, it returns nil if there's
+// no Baggage filter before the caller in the pipeline.
+func FromContext(ctx *context.Context) *Bag {
+	b, _ := ctx.GetData(DataKey).(*Bag)
+	return b
+}
+
+// isToken returns whether s is a token defined in RFC 7230, which is the
+// syntax of the keys.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeValue percent-encodes the characters not allowed in values.
+func encodeValue(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' || c == '%' {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+func (m *Member) String() string {
+	s := m.Key + "=" + encodeValue(m.Value)
+	if m.Properties != "" {
+		s += ";" + m.Properties
+	}
+	return s
+}
+
+// parseMember parses a list-member, it returns nil if the member is
+// malformed.
+func parseMember(s string) *Member {
+	kv, props, _ := strings.Cut(s, ";")
+	k, v, ok := strings.Cut(kv, "=")
+	if !ok {
+		return nil
+	}
+
+	m := &Member{Key: strings.TrimSpace(k), Properties: strings.TrimSpace(props)}
+	if !isToken(m.Key) {
+		return nil
+	}
+
+	value, err := url.PathUnescape(strings.TrimSpace(v))
+	if err != nil {
+		return nil
+	}
+	m.Value = value
+	return m
+}
+
+// newBag creates the bag from the Baggage headers of the request, malformed
+// members and members beyond the limits are dropped, duplicated members
+// are resolved by last-one-wins.
+func newBag(req *httpprot.Request, maxMembers, maxBytes int) *Bag {
+	b := &Bag{req: req, maxMembers: maxMembers, maxBytes: maxBytes}
+
+	for _, value := range req.HTTPHeader().Values(HeaderBaggage) {
+		for _, s := range strings.Split(value, ",") {
+			if strings.TrimSpace(s) == "" {
+				continue
+			}
+			if m := parseMember(s); m != nil {
+				b.put(m)
+			}
+		}
+	}
+
+	for len(b.members) > b.maxMembers || len(b.String()) > b.maxBytes {
+		b.members = b.members[:len(b.members)-1]
+	}
+	return b
+}
+
+func (b *Bag) index(key string) int {
+	for i, m := range b.members {
+		if m.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *Bag) put(m *Member) {
+	if i := b.index(m.Key); i >= 0 {
+		b.members = append(b.members[:i], b.members[i+1:]...)
+	}
+	b.members = append(b.members, m)
+}
+
+// sync writes the bag to the Baggage header of the request.
+func (b *Bag) sync() {
+	h := b.req.HTTPHeader()
+	if len(b.members) == 0 {
+		h.Del(HeaderBaggage)
+		return
+	}
+	h.Set(HeaderBaggage, b.String())
+}
+
+// Lookup returns the value of the key, and whether the key exists.
+func (b *Bag) Lookup(key string) (string, bool) {
+	if i := b.index(key); i >= 0 {
+		return b.members[i].Value, true
+	}
+	return "", false
+}
+
+// Value returns the value of the key, or an empty string if the key does
+// not exist.
+func (b *Bag) Value(key string) string {
+	v, _ := b.Lookup(key)
+	return v
+}
+
+// Set adds or updates a member of the baggage, it returns an error if the
+// key is invalid or the baggage would exceed the limits.
+func (b *Bag) Set(key, value string) error {
+	if !isToken(key) {
+		return fmt.Errorf("invalid baggage key %q", key)
+	}
+
+	old := b.members
+	b.members = append([]*Member(nil), b.members...)
+	b.put(&Member{Key: key, Value: value})
+
+	if len(b.members) > b.maxMembers {
+		b.members = old
+		return fmt.Errorf("baggage exceeds %d members", b.maxMembers)
+	}
+	if n := len(b.String()); n > b.maxBytes {
+		b.members = old
+		return fmt.Errorf("baggage exceeds %d bytes", b.maxBytes)
+	}
+
+	b.sync()
+	return nil
+}
+
+// Delete deletes a member of the baggage.
+func (b *Bag) Delete(key string) {
+	if i := b.index(key); i >= 0 {
+		b.members = append(b.members[:i:i], b.members[i+1:]...)
+		b.sync()
+	}
+}
+
+// Members returns a copy of the members.
+func (b *Bag) Members() []Member {
+	members := make([]Member, len(b.members))
+	for i, m := range b.members {
+		members[i] = *m
+	}
+	return members
+}
+
+// Len returns the number of members.
+func (b *Bag) Len() int {
+	return len(b.members)
+}
+
+// String encodes the bag in the format of the Baggage header.
+func (b *Bag) String() string {
+	members := make([]string, len(b.members))
+	for i, m := range b.members {
+		members[i] = m.String()
+	}
+	return strings.Join(members, ",")
+}
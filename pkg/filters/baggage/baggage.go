@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package baggage implements a filter which reads and propagates the W3C
+// Baggage of requests.
+package baggage
+
+import (
+	"fmt"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/builder"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of Baggage.
+	Kind = "Baggage"
+
+	// limits recommended by the W3C Baggage specification.
+	defaultMaxMembers = 64
+	defaultMaxBytes   = 8192
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "Baggage reads the W3C Baggage of requests and propagates it to the upstream.",
+	Results:     []string{},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{
+			MaxMembers: defaultMaxMembers,
+			MaxBytes:   defaultMaxBytes,
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &Baggage{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// Baggage is the filter Baggage.
+	//
+	// It puts the baggage of the request into the context as a Bag, filters
+	// after it could get the Bag by FromContext to read it or add members
+	// to it, and the members are propagated to the upstream.
+	Baggage struct {
+		spec    *Spec
+		entries []*entry
+	}
+
+	// Spec is the spec of Baggage.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Entries are members added to the baggage.
+		Entries []*Entry `json:"entries,omitempty"`
+		// Headers exposes members as request headers, the key is the key
+		// of the member, and the value is the name of the header. Proxy
+		// pools could select requests by these headers.
+		Headers    map[string]string `json:"headers,omitempty"`
+		MaxMembers int               `json:"maxMembers,omitempty" jsonschema:"minimum=1"`
+		MaxBytes   int               `json:"maxBytes,omitempty" jsonschema:"minimum=1"`
+	}
+
+	// Entry is a member added to the baggage.
+	Entry struct {
+		Key string `json:"key" jsonschema:"required"`
+		// Value is a template, the member is not added if the result is
+		// empty.
+		Value string `json:"value" jsonschema:"required"`
+	}
+
+	entry struct {
+		key   string
+		value *builder.Template
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	for _, e := range spec.Entries {
+		if !isToken(e.Key) {
+			return fmt.Errorf("invalid key %q", e.Key)
+		}
+		if _, err := builder.NewTemplate(e.Value); err != nil {
+			return fmt.Errorf("invalid value of %s: %v", e.Key, err)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the Baggage filter instance.
+func (b *Baggage) Name() string {
+	return b.spec.Name()
+}
+
+// Kind returns the kind of Baggage.
+func (b *Baggage) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the Baggage
+func (b *Baggage) Spec() filters.Spec {
+	return b.spec
+}
+
+// Init initializes Baggage.
+func (b *Baggage) Init() {
+	b.reload()
+}
+
+// Inherit inherits previous generation of Baggage.
+func (b *Baggage) Inherit(previousGeneration filters.Filter) {
+	b.Init()
+}
+
+func (b *Baggage) reload() {
+	if b.spec.MaxMembers <= 0 {
+		b.spec.MaxMembers = defaultMaxMembers
+	}
+	if b.spec.MaxBytes <= 0 {
+		b.spec.MaxBytes = defaultMaxBytes
+	}
+
+	b.entries = nil
+	for _, e := range b.spec.Entries {
+		b.entries = append(b.entries, &entry{
+			key:   e.Key,
+			value: builder.MustNewTemplate(e.Value),
+		})
+	}
+}
+
+// Handle puts the baggage of the request into the context.
+func (b *Baggage) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	bag := newBag(req, b.spec.MaxMembers, b.spec.MaxBytes)
+	ctx.SetData(DataKey, bag)
+
+	for _, e := range b.entries {
+		value, err := e.value.Render(ctx)
+		if err != nil {
+			logger.Warnf("%s: failed to render value of %s: %v", b.Name(), e.key, err)
+			continue
+		}
+		if value == "" {
+			continue
+		}
+		if err = bag.Set(e.key, value); err != nil {
+			logger.Warnf("%s: %v", b.Name(), err)
+		}
+	}
+
+	// headers not from the baggage are removed, so that they can't be
+	// forged by clients.
+	for key, header := range b.spec.Headers {
+		if v, ok := bag.Lookup(key); ok {
+			req.HTTPHeader().Set(header, v)
+		} else {
+			req.HTTPHeader().Del(header)
+		}
+	}
+
+	// normalize the header, malformed members are dropped.
+	bag.sync()
+	return ""
+}
+
+// Status returns status.
+func (b *Baggage) Status() interface{} {
+	return nil
+}
+
+// Close closes Baggage.
+func (b *Baggage) Close() {
+}
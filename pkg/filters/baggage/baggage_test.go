@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package baggage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/builder"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestBaggage(yamlConfig string) (*Baggage, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	b := kind.CreateInstance(spec).(*Baggage)
+	b.Init()
+	return b, nil
+}
+
+func newContext(header http.Header) (*context.Context, *httpprot.Request) {
+	stdReq := httptest.NewRequest(http.MethodGet, "/", nil)
+	for k, v := range header {
+		stdReq.Header[k] = v
+	}
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, req
+}
+
+func TestBaggage(t *testing.T) {
+	assert := assert.New(t)
+
+	b, err := newTestBaggage(`
+kind: Baggage
+name: baggage
+entries:
+- key: tenant
+  value: '{{.req.Header.Get "X-Tenant"}}'
+- key: empty
+  value: ''
+headers:
+  experiment: X-Experiment
+`)
+	assert.Nil(err)
+	assert.Equal(kind, b.Kind())
+	assert.Nil(b.Status())
+
+	ctx, req := newContext(http.Header{
+		"Baggage":      {"user=alice, experiment=blue;ttl=10", "note=hello%20world"},
+		"X-Tenant":     {"acme corp"},
+		"X-Experiment": {"forged"},
+	})
+	assert.Equal("", b.Handle(ctx))
+
+	bag := FromContext(ctx)
+	assert.NotNil(bag)
+	assert.Equal("alice", bag.Value("user"))
+	assert.Equal("hello world", bag.Value("note"))
+	assert.Equal("acme corp", bag.Value("tenant"))
+	_, ok := bag.Lookup("empty")
+	assert.False(ok)
+	assert.Equal("blue", req.HTTPHeader().Get("X-Experiment"))
+	assert.Equal("user=alice,experiment=blue;ttl=10,note=hello%20world,tenant=acme%20corp",
+		req.HTTPHeader().Get(HeaderBaggage))
+
+	// filters after Baggage could add members, and templates could read them.
+	assert.Nil(bag.Set("region", "us-east"))
+	bag.Delete("user")
+	assert.Equal("experiment=blue;ttl=10,note=hello%20world,tenant=acme%20corp,region=us-east",
+		req.HTTPHeader().Get(HeaderBaggage))
+	tmpl := builder.MustNewTemplate(`{{.data.BAGGAGE.Value "region"}}`)
+	region, err := tmpl.Render(ctx)
+	assert.Nil(err)
+	assert.Equal("us-east", region)
+	assert.Len(bag.Members(), 4)
+
+	// no baggage, the forged header is removed.
+	ctx, req = newContext(http.Header{"X-Experiment": {"forged"}})
+	assert.Equal("", b.Handle(ctx))
+	assert.Empty(req.HTTPHeader().Get("X-Experiment"))
+	assert.Empty(req.HTTPHeader().Get(HeaderBaggage))
+	assert.Equal(0, FromContext(ctx).Len())
+
+	newB := kind.CreateInstance(b.spec).(*Baggage)
+	newB.Inherit(b)
+	b.Close()
+}
+
+func TestMalformedBaggage(t *testing.T) {
+	assert := assert.New(t)
+
+	b, err := newTestBaggage(`
+kind: Baggage
+name: baggage
+maxMembers: 3
+maxBytes: 40
+`)
+	assert.Nil(err)
+
+	ctx, req := newContext(http.Header{
+		"Baggage": {"novalue, =empty, bad key=1, k1=%zz, k1=v1, , k2=v2;p, k1=v3, k3=v3, k4=v4"},
+	})
+	assert.Equal("", b.Handle(ctx))
+	bag := FromContext(ctx)
+
+	// malformed members are dropped, duplicated members are resolved by
+	// last-one-wins, and members beyond the limits are dropped.
+	assert.Equal("k2=v2;p,k1=v3,k3=v3", req.HTTPHeader().Get(HeaderBaggage))
+
+	assert.NotNil(bag.Set("k4", "v4"))
+	assert.NotNil(bag.Set("bad key", "v"))
+	assert.NotNil(bag.Set("k1", strings.Repeat("v", 40)))
+	assert.Nil(bag.Set("k1", `a,b;c"d`))
+	assert.Equal(`a,b;c"d`, bag.Value("k1"))
+	assert.Equal("k2=v2;p,k3=v3,k1=a%2Cb%3Bc%22d", req.HTTPHeader().Get(HeaderBaggage))
+	assert.Nil(FromContext(context.New(nil)))
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []string{`
+kind: Baggage
+name: baggage
+entries:
+- key: bad key
+  value: v
+`, `
+kind: Baggage
+name: baggage
+entries:
+- key: k
+  value: '{{.req'
+`} {
+		_, err := newTestBaggage(c)
+		assert.NotNil(err, c)
+	}
+}
@@ -0,0 +1,298 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bluegreen implements a filter which routes all traffic to either
+// the blue or the green pool by a flag stored in the cluster.
+package bluegreen
+
+import (
+	stdcontext "context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/cluster"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of BlueGreen.
+	Kind = "BlueGreen"
+
+	// ColorBlue is the blue color.
+	ColorBlue = "blue"
+	// ColorGreen is the green color.
+	ColorGreen = "green"
+
+	syncPullInterval = 30 * time.Minute
+	syncRetryDelay   = 10 * time.Second
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "BlueGreen routes all traffic to either the blue or the green pool by a flag stored in the cluster.",
+	Results:     []string{},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{DefaultColor: ColorBlue}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &BlueGreen{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// BlueGreen is the filter BlueGreen.
+	BlueGreen struct {
+		spec *Spec
+
+		// active is the active color from the flag, it is empty if the
+		// flag is not set or is invalid.
+		active    atomic.Value // string
+		updatedAt atomic.Value // time.Time
+		requests  [2]uint64
+
+		cls    cluster.Cluster
+		key    string
+		cancel stdcontext.CancelFunc
+	}
+
+	// Spec is the spec of BlueGreen.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Header is the request header to carry the active color, Proxy
+		// pools select requests by it.
+		Header string `json:"header" jsonschema:"required"`
+		// Blue and Green are the values of the header of the colors,
+		// default are blue and green.
+		Blue  string `json:"blue,omitempty"`
+		Green string `json:"green,omitempty"`
+		// DefaultColor is the active color if the flag is not set.
+		DefaultColor string `json:"defaultColor,omitempty" jsonschema:"enum=,enum=blue,enum=green"`
+	}
+
+	// Status is the status of BlueGreen.
+	Status struct {
+		Active string `json:"active"`
+		// Flag is the color of the flag in the cluster, it is empty if
+		// the flag is not set and the default color is active.
+		Flag      string     `json:"flag,omitempty"`
+		UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+		// Requests are the number of requests routed to every color.
+		Requests map[string]uint64 `json:"requests"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if spec.Blue != "" && spec.Blue == spec.Green {
+		return fmt.Errorf("blue and green must be different")
+	}
+	return nil
+}
+
+// ParseColor parses the value of the flag, it returns an error if the value
+// is not a color.
+func ParseColor(value string) (string, error) {
+	switch color := strings.ToLower(strings.TrimSpace(value)); color {
+	case ColorBlue, ColorGreen:
+		return color, nil
+	default:
+		return "", fmt.Errorf("invalid color %q, must be blue or green", value)
+	}
+}
+
+// Name returns the name of the BlueGreen filter instance.
+func (bg *BlueGreen) Name() string {
+	return bg.spec.Name()
+}
+
+// Kind returns the kind of BlueGreen.
+func (bg *BlueGreen) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the BlueGreen
+func (bg *BlueGreen) Spec() filters.Spec {
+	return bg.spec
+}
+
+// Init initializes BlueGreen.
+func (bg *BlueGreen) Init() {
+	bg.reload(nil)
+}
+
+// Inherit inherits previous generation of BlueGreen.
+func (bg *BlueGreen) Inherit(previousGeneration filters.Filter) {
+	bg.reload(previousGeneration.(*BlueGreen))
+}
+
+func (bg *BlueGreen) reload(prev *BlueGreen) {
+	if bg.spec.Blue == "" {
+		bg.spec.Blue = ColorBlue
+	}
+	if bg.spec.Green == "" {
+		bg.spec.Green = ColorGreen
+	}
+	if bg.spec.DefaultColor == "" {
+		bg.spec.DefaultColor = ColorBlue
+	}
+
+	bg.active.Store("")
+	bg.updatedAt.Store(time.Time{})
+	// keep the active color of the previous generation until the flag is
+	// loaded, so an update of the spec never flips the traffic.
+	if prev != nil {
+		bg.active.Store(prev.active.Load())
+		bg.updatedAt.Store(prev.updatedAt.Load())
+	}
+
+	super := bg.spec.Super()
+	if super == nil || super.Cluster() == nil {
+		return
+	}
+	bg.cls = super.Cluster()
+	bg.key = bg.cls.Layout().BlueGreenDataKey(bg.spec.Pipeline(), bg.spec.Name())
+
+	if value, err := bg.cls.Get(bg.key); err != nil {
+		logger.Errorf("%s: failed to load the flag: %v", bg.Name(), err)
+	} else {
+		bg.setFlag(value)
+	}
+
+	var ctx stdcontext.Context
+	ctx, bg.cancel = stdcontext.WithCancel(stdcontext.Background())
+	go bg.watch(ctx)
+}
+
+// setFlag updates the active color by the value of the flag, value is nil
+// if the flag is deleted.
+func (bg *BlueGreen) setFlag(value *string) {
+	color := ""
+	if value != nil {
+		var err error
+		if color, err = ParseColor(*value); err != nil {
+			logger.Errorf("%s: %v, use default color %s", bg.Name(), err, bg.spec.DefaultColor)
+		}
+	}
+
+	if old := bg.active.Load().(string); old != color {
+		logger.Infof("%s: active color changed from %q to %q", bg.Name(), old, color)
+		bg.active.Store(color)
+		bg.updatedAt.Store(time.Now())
+	}
+}
+
+// watch keeps the active color in sync with the flag, so a flip of the
+// flag takes effect immediately, and reading the flag is only an atomic
+// load in the path of requests.
+func (bg *BlueGreen) watch(ctx stdcontext.Context) {
+	var (
+		syncer cluster.Syncer
+		ch     <-chan *string
+		err    error
+	)
+
+	for {
+		syncer, err = bg.cls.Syncer(syncPullInterval)
+		if err != nil {
+			logger.Errorf("%s: failed to create syncer: %v", bg.Name(), err)
+		} else if ch, err = syncer.Sync(bg.key); err != nil {
+			logger.Errorf("%s: failed to sync the flag: %v", bg.Name(), err)
+			syncer.Close()
+		} else {
+			break
+		}
+
+		select {
+		case <-time.After(syncRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	defer syncer.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case value := <-ch:
+			bg.setFlag(value)
+		}
+	}
+}
+
+// Active returns the active color.
+func (bg *BlueGreen) Active() string {
+	if color := bg.active.Load().(string); color != "" {
+		return color
+	}
+	return bg.spec.DefaultColor
+}
+
+// Handle sets the active color to the request header.
+func (bg *BlueGreen) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	color, value := bg.Active(), bg.spec.Blue
+	if color == ColorGreen {
+		value = bg.spec.Green
+		atomic.AddUint64(&bg.requests[1], 1)
+	} else {
+		atomic.AddUint64(&bg.requests[0], 1)
+	}
+
+	// set overwrites the header sent by the client, so the color can't be
+	// forged.
+	req.HTTPHeader().Set(bg.spec.Header, value)
+	ctx.LazyAddTag(func() string {
+		return "blueGreen: " + color
+	})
+	return ""
+}
+
+// Status returns status.
+func (bg *BlueGreen) Status() interface{} {
+	s := &Status{
+		Active: bg.Active(),
+		Flag:   bg.active.Load().(string),
+		Requests: map[string]uint64{
+			ColorBlue:  atomic.LoadUint64(&bg.requests[0]),
+			ColorGreen: atomic.LoadUint64(&bg.requests[1]),
+		},
+	}
+	if t := bg.updatedAt.Load().(time.Time); !t.IsZero() {
+		s.UpdatedAt = &t
+	}
+	return s
+}
+
+// Close closes BlueGreen.
+func (bg *BlueGreen) Close() {
+	if bg.cancel != nil {
+		bg.cancel()
+	}
+}
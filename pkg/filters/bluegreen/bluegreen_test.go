@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bluegreen
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/v2/pkg/cluster"
+	"github.com/megaease/easegress/v2/pkg/cluster/clustertest"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newBlueGreen(t *testing.T, super *supervisor.Supervisor, yamlConfig string) *BlueGreen {
+	rawSpec := make(map[string]interface{})
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(super, "pipeline", rawSpec)
+	assert.Nil(t, err)
+	bg := kind.CreateInstance(spec).(*BlueGreen)
+	bg.Init()
+	return bg
+}
+
+func handle(t *testing.T, bg *BlueGreen, header string) string {
+	stdReq, err := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	assert.Nil(t, err)
+	stdReq.Header.Set("X-Color", header)
+	req, err := httpprot.NewRequest(stdReq)
+	assert.Nil(t, err)
+
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	assert.Equal(t, "", bg.Handle(ctx))
+	return req.HTTPHeader().Get("X-Color")
+}
+
+func TestBlueGreenWithoutCluster(t *testing.T) {
+	assert := assert.New(t)
+
+	bg := newBlueGreen(t, nil, `
+kind: BlueGreen
+name: bg
+header: X-Color
+defaultColor: green
+green: v2
+`)
+	defer bg.Close()
+
+	// the header sent by the client is overwritten.
+	assert.Equal("v2", handle(t, bg, "blue"))
+	status := bg.Status().(*Status)
+	assert.Equal(ColorGreen, status.Active)
+	assert.Equal("", status.Flag)
+	assert.Nil(status.UpdatedAt)
+	assert.Equal(uint64(1), status.Requests[ColorGreen])
+}
+
+func TestBlueGreen(t *testing.T) {
+	assert := assert.New(t)
+
+	flag := "green"
+	cls := clustertest.NewMockedCluster()
+	cls.MockedGet = func(key string) (*string, error) {
+		assert.Equal("/bluegreen/data/pipeline/bg", key)
+		return &flag, nil
+	}
+	syncer := clustertest.NewMockedSyncer()
+	ch := make(chan *string)
+	syncer.MockedSync = func(key string) (<-chan *string, error) {
+		return ch, nil
+	}
+	cls.MockedSyncer = func(time.Duration) (cluster.Syncer, error) {
+		return syncer, nil
+	}
+	super := supervisor.NewMock(nil, cls, nil, nil, false, nil, nil)
+
+	bg := newBlueGreen(t, super, `
+kind: BlueGreen
+name: bg
+header: X-Color
+`)
+	assert.Equal("green", handle(t, bg, ""))
+	assert.NotNil(bg.Status().(*Status).UpdatedAt)
+
+	// flip to blue.
+	blue := "Blue"
+	ch <- &blue
+	assert.Eventually(func() bool { return bg.Active() == ColorBlue }, time.Second, 10*time.Millisecond)
+	assert.Equal("blue", handle(t, bg, "green"))
+
+	// invalid flag falls back to the default color.
+	invalid := "red"
+	ch <- &invalid
+	assert.Eventually(func() bool { return bg.Status().(*Status).Flag == "" }, time.Second, 10*time.Millisecond)
+	assert.Equal(ColorBlue, bg.Active())
+
+	ch <- &flag
+	assert.Eventually(func() bool { return bg.Active() == ColorGreen }, time.Second, 10*time.Millisecond)
+
+	// the new generation keeps the color until the flag is loaded.
+	cls.MockedGet = func(key string) (*string, error) {
+		return nil, nil
+	}
+	rawSpec := map[string]interface{}{"kind": Kind, "name": "bg", "header": "X-Color"}
+	spec, err := filters.NewSpec(super, "pipeline", rawSpec)
+	assert.Nil(err)
+	bg2 := kind.CreateInstance(spec).(*BlueGreen)
+	bg2.Inherit(bg)
+	bg.Close()
+	// the flag is deleted.
+	assert.Equal(ColorBlue, bg2.Active())
+	bg2.Close()
+
+	assert.Error((&Spec{Blue: "v1", Green: "v1"}).Validate())
+	_, err = ParseColor("yellow")
+	assert.Error(err)
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bodyaggregator implements a filter which buffers stream request
+// bodies, so that they are forwarded with Content-Length instead of chunked.
+package bodyaggregator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of BodyAggregator.
+	Kind = "BodyAggregator"
+
+	// OverflowReject rejects requests whose body exceeds the max size.
+	OverflowReject = "reject"
+	// OverflowStream forwards requests whose body exceeds the max size
+	// as they are.
+	OverflowStream = "stream"
+
+	resultTooLarge   = "tooLarge"
+	resultReadFailed = "readFailed"
+
+	defaultMaxBodySize = 4 * 1024 * 1024
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "BodyAggregator buffers stream request bodies to forward them with Content-Length.",
+	Results:     []string{resultTooLarge, resultReadFailed},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{
+			MaxBodySize: defaultMaxBodySize,
+			Overflow:    OverflowReject,
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &BodyAggregator{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// BodyAggregator is the filter BodyAggregator.
+	BodyAggregator struct {
+		spec        *Spec
+		maxBodySize int64
+
+		aggregated uint64
+		streamed   uint64
+		rejected   uint64
+	}
+
+	// Spec is the spec of BodyAggregator.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// MaxBodySize is the max size of the body to buffer, in bytes.
+		MaxBodySize int64 `json:"maxBodySize,omitempty" jsonschema:"minimum=1"`
+		// Overflow is what to do if the body exceeds MaxBodySize.
+		Overflow string `json:"overflow,omitempty" jsonschema:"enum=reject,enum=stream"`
+	}
+
+	// Status is the status of BodyAggregator.
+	Status struct {
+		Aggregated uint64 `json:"aggregated"`
+		Streamed   uint64 `json:"streamed"`
+		Rejected   uint64 `json:"rejected"`
+	}
+)
+
+// Name returns the name of the BodyAggregator filter instance.
+func (ba *BodyAggregator) Name() string {
+	return ba.spec.Name()
+}
+
+// Kind returns the kind of BodyAggregator.
+func (ba *BodyAggregator) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the BodyAggregator
+func (ba *BodyAggregator) Spec() filters.Spec {
+	return ba.spec
+}
+
+// Init initializes BodyAggregator.
+func (ba *BodyAggregator) Init() {
+	ba.maxBodySize = ba.spec.MaxBodySize
+	if ba.maxBodySize <= 0 {
+		ba.maxBodySize = defaultMaxBodySize
+	}
+}
+
+// Inherit inherits previous generation of BodyAggregator.
+func (ba *BodyAggregator) Inherit(previousGeneration filters.Filter) {
+	ba.Init()
+}
+
+func (ba *BodyAggregator) reject(ctx *context.Context, result string, statusCode int, reason string) string {
+	ctx.AddTag(fmt.Sprintf("bodyAggregator: %s", reason))
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(statusCode)
+	ctx.SetOutputResponse(resp)
+	return result
+}
+
+// Handle buffers the stream body of the request.
+func (ba *BodyAggregator) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	stdr, header := req.Std(), req.HTTPHeader()
+	chunked := len(stdr.TransferEncoding) > 0 || header.Get("Transfer-Encoding") != ""
+	if !req.IsStream() && !chunked {
+		return ""
+	}
+
+	if req.IsStream() {
+		// read one more byte to know whether the body exceeds the limit.
+		stream := req.GetPayload()
+		body, err := io.ReadAll(io.LimitReader(stream, ba.maxBodySize+1))
+		if err != nil {
+			atomic.AddUint64(&ba.rejected, 1)
+			return ba.reject(ctx, resultReadFailed, http.StatusBadRequest, fmt.Sprintf("failed to read body: %v", err))
+		}
+
+		if int64(len(body)) > ba.maxBodySize {
+			if ba.spec.Overflow != OverflowStream {
+				atomic.AddUint64(&ba.rejected, 1)
+				return ba.reject(ctx, resultTooLarge, http.StatusRequestEntityTooLarge, "body too large")
+			}
+			// put back the bytes read, and forward the body as a stream.
+			atomic.AddUint64(&ba.streamed, 1)
+			req.SetPayload(io.MultiReader(bytes.NewReader(body), stream))
+			return ""
+		}
+
+		req.SetPayload(body)
+	}
+
+	if !chunked && req.PayloadSize() == 0 {
+		return ""
+	}
+
+	// the body is forwarded with Content-Length, strip Transfer-Encoding.
+	stdr.TransferEncoding = nil
+	stdr.ContentLength = req.PayloadSize()
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.FormatInt(stdr.ContentLength, 10))
+
+	atomic.AddUint64(&ba.aggregated, 1)
+	return ""
+}
+
+// Status returns status.
+func (ba *BodyAggregator) Status() interface{} {
+	return &Status{
+		Aggregated: atomic.LoadUint64(&ba.aggregated),
+		Streamed:   atomic.LoadUint64(&ba.streamed),
+		Rejected:   atomic.LoadUint64(&ba.rejected),
+	}
+}
+
+// Close closes BodyAggregator.
+func (ba *BodyAggregator) Close() {
+}
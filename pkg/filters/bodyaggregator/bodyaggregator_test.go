@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bodyaggregator
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func createBodyAggregator(yamlConfig string) *BodyAggregator {
+	rawSpec := make(map[string]interface{})
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		panic(err.Error())
+	}
+	ba := kind.CreateInstance(spec).(*BodyAggregator)
+	ba.Init()
+	return ba
+}
+
+// newChunkedContext creates a context whose request has a chunked stream
+// body.
+func newChunkedContext(body io.Reader) (*context.Context, *httpprot.Request) {
+	stdReq, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1", nil)
+	stdReq.ContentLength = -1
+	stdReq.TransferEncoding = []string{"chunked"}
+	req, _ := httpprot.NewRequest(stdReq)
+	req.SetPayload(body)
+
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, req
+}
+
+type errReader struct{}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("connection reset")
+}
+
+func TestAggregate(t *testing.T) {
+	assert := assert.New(t)
+
+	ba := createBodyAggregator(`
+kind: BodyAggregator
+name: ba
+maxBodySize: 10
+`)
+
+	ctx, req := newChunkedContext(strings.NewReader("0123456789"))
+	assert.Equal("", ba.Handle(ctx))
+	assert.False(req.IsStream())
+	assert.Equal("0123456789", string(req.RawPayload()))
+	assert.Equal(int64(10), req.Std().ContentLength)
+	assert.Empty(req.Std().TransferEncoding)
+	assert.Equal("10", req.HTTPHeader().Get("Content-Length"))
+
+	// the outbound request is sent with Content-Length.
+	outReq, err := http.NewRequest(http.MethodPost, "http://127.0.0.1", req.GetPayload())
+	assert.Nil(err)
+	assert.Equal(int64(10), outReq.ContentLength)
+
+	ctx, _ = newChunkedContext(strings.NewReader("01234567890"))
+	assert.Equal(resultTooLarge, ba.Handle(ctx))
+	assert.Equal(http.StatusRequestEntityTooLarge, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	ctx, _ = newChunkedContext(errReader{})
+	assert.Equal(resultReadFailed, ba.Handle(ctx))
+	assert.Equal(http.StatusBadRequest, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	// requests without body are untouched.
+	stdReq, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1", nil)
+	req, _ = httpprot.NewRequest(stdReq)
+	ctx = context.New(nil)
+	ctx.SetInputRequest(req)
+	assert.Equal("", ba.Handle(ctx))
+	assert.Empty(req.HTTPHeader().Get("Content-Length"))
+
+	status := ba.Status().(*Status)
+	assert.Equal(uint64(1), status.Aggregated)
+	assert.Equal(uint64(2), status.Rejected)
+}
+
+func TestOverflowStream(t *testing.T) {
+	assert := assert.New(t)
+
+	ba := createBodyAggregator(`
+kind: BodyAggregator
+name: ba
+maxBodySize: 4
+overflow: stream
+`)
+
+	ctx, req := newChunkedContext(strings.NewReader("0123456789"))
+	assert.Equal("", ba.Handle(ctx))
+	assert.True(req.IsStream())
+	assert.Equal([]string{"chunked"}, req.Std().TransferEncoding)
+
+	// the bytes already read are put back.
+	data, err := io.ReadAll(req.GetPayload())
+	assert.Nil(err)
+	assert.Equal("0123456789", string(data))
+
+	assert.Equal(uint64(1), ba.Status().(*Status).Streamed)
+
+	newBa := kind.CreateInstance(ba.spec).(*BodyAggregator)
+	newBa.Inherit(ba)
+	assert.Equal(int64(4), newBa.maxBodySize)
+	newBa.Close()
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bodyguard implements a filter which enforces the line count and
+// structural limits of request bodies.
+package bodyguard
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of BodyGuard.
+	Kind = "BodyGuard"
+
+	resultInvalid = "invalid"
+
+	defaultMaxDepth        = 32
+	defaultMaxArrayLength  = 10000
+	defaultMaxKeys         = 1000
+	defaultMaxStringLength = 1024 * 1024
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "BodyGuard rejects request bodies exceeding the line count or JSON structural limits.",
+	Results:     []string{resultInvalid},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{
+			MaxDepth:        defaultMaxDepth,
+			MaxArrayLength:  defaultMaxArrayLength,
+			MaxKeys:         defaultMaxKeys,
+			MaxStringLength: defaultMaxStringLength,
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &BodyGuard{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// BodyGuard is the filter BodyGuard.
+	BodyGuard struct {
+		spec   *Spec
+		limits *limits
+
+		checked  uint64
+		rejected uint64
+	}
+
+	// Spec is the spec of BodyGuard, a zero limit means unlimited.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// MaxLines applies to all bodies, other limits only apply to JSON
+		// bodies.
+		MaxLines        int64 `json:"maxLines,omitempty" jsonschema:"minimum=0"`
+		MaxDepth        int   `json:"maxDepth,omitempty" jsonschema:"minimum=0"`
+		MaxArrayLength  int   `json:"maxArrayLength,omitempty" jsonschema:"minimum=0"`
+		MaxKeys         int   `json:"maxKeys,omitempty" jsonschema:"minimum=0"`
+		MaxStringLength int   `json:"maxStringLength,omitempty" jsonschema:"minimum=0"`
+	}
+
+	// Status is the status of BodyGuard.
+	Status struct {
+		Checked  uint64 `json:"checked"`
+		Rejected uint64 `json:"rejected"`
+	}
+)
+
+// Name returns the name of the BodyGuard filter instance.
+func (bg *BodyGuard) Name() string {
+	return bg.spec.Name()
+}
+
+// Kind returns the kind of BodyGuard.
+func (bg *BodyGuard) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the BodyGuard
+func (bg *BodyGuard) Spec() filters.Spec {
+	return bg.spec
+}
+
+// Init initializes BodyGuard.
+func (bg *BodyGuard) Init() {
+	bg.limits = &limits{
+		maxLines:        bg.spec.MaxLines,
+		maxDepth:        bg.spec.MaxDepth,
+		maxArrayLength:  bg.spec.MaxArrayLength,
+		maxKeys:         bg.spec.MaxKeys,
+		maxStringLength: bg.spec.MaxStringLength,
+	}
+}
+
+// Inherit inherits previous generation of BodyGuard.
+func (bg *BodyGuard) Inherit(previousGeneration filters.Filter) {
+	bg.Init()
+}
+
+// isJSON returns whether the media type is a JSON one.
+func isJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// Handle checks the body of the request against the limits.
+func (bg *BodyGuard) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	json := isJSON(req.HTTPHeader().Get("Content-Type"))
+	if !json && bg.limits.maxLines <= 0 {
+		return ""
+	}
+
+	atomic.AddUint64(&bg.checked, 1)
+	s := newScanner(bg.limits, json)
+
+	// the stream body is checked while it is being sent to the backend,
+	// the request is aborted if a limit is exceeded.
+	if req.IsStream() {
+		req.SetPayload(&guardReader{
+			r: req.GetPayload(),
+			s: s,
+			onError: func(err error) {
+				atomic.AddUint64(&bg.rejected, 1)
+				logger.Debugf("%s: stream body rejected: %v", bg.Name(), err)
+			},
+		})
+		return ""
+	}
+
+	err := s.write(req.RawPayload())
+	if err == nil {
+		return ""
+	}
+
+	atomic.AddUint64(&bg.rejected, 1)
+	ctx.AddTag(fmt.Sprintf("bodyGuard: %v", err))
+
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(http.StatusBadRequest)
+	ctx.SetOutputResponse(resp)
+	return resultInvalid
+}
+
+// Status returns status.
+func (bg *BodyGuard) Status() interface{} {
+	return &Status{
+		Checked:  atomic.LoadUint64(&bg.checked),
+		Rejected: atomic.LoadUint64(&bg.rejected),
+	}
+}
+
+// Close closes BodyGuard.
+func (bg *BodyGuard) Close() {
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bodyguard
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestFilter(yamlConfig string) *BodyGuard {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		panic(err)
+	}
+	bg := kind.CreateInstance(spec).(*BodyGuard)
+	bg.Init()
+	return bg
+}
+
+func newContext(contentType, body string) *context.Context {
+	stdr, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/", strings.NewReader(body))
+	stdr.Header.Set("Content-Type", contentType)
+	req, _ := httpprot.NewRequest(stdr)
+	req.FetchPayload(1024 * 1024)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestScanner(t *testing.T) {
+	assert := assert.New(t)
+
+	l := &limits{
+		maxLines:        3,
+		maxDepth:        3,
+		maxArrayLength:  3,
+		maxKeys:         2,
+		maxStringLength: 5,
+	}
+
+	tests := []struct {
+		body  string
+		valid bool
+	}{
+		{`{"a": [1, 2, 3], "b": {"c": []}}`, true},
+		{`[[[[]]]]`, false},
+		{`[1, 2, 3, 4]`, false},
+		{`[[], [], [], []]`, false},
+		{`[[1, 2, 3], [1, 2, 3]]`, true},
+		{`{"a": 1, "b": 2, "c": 3}`, false},
+		{`{"a": {"b": 1, "c": 2}, "d": 3}`, true},
+		{`["12345"]`, true},
+		{`["123456"]`, false},
+		{`{"123456": 1}`, false},
+		// escapes and multi-byte characters are counted as one character.
+		{`["\"\\éé1"]`, true},
+		{`["\"\\éé12"]`, false},
+		// brackets in strings are not counted.
+		{`["[[[[", "]]]]"]`, true},
+		{`{"a": [}`, false},
+		{`]`, false},
+		{"[1,\n2,\n3]", true},
+		{"[1,\n2,\n3\n]", false},
+		{"", true},
+	}
+
+	for _, test := range tests {
+		s := newScanner(l, true)
+		err := s.write([]byte(test.body))
+		assert.Equal(test.valid, err == nil, test.body)
+
+		// feed the body byte by byte.
+		s = newScanner(l, true)
+		err = nil
+		for i := 0; i < len(test.body) && err == nil; i++ {
+			err = s.write([]byte{test.body[i]})
+		}
+		assert.Equal(test.valid, err == nil, test.body)
+	}
+
+	// only lines are counted for non-JSON bodies.
+	s := newScanner(l, false)
+	assert.NoError(s.write([]byte("[[[[\n\n")))
+	assert.NoError(s.write([]byte("a\n")))
+	assert.Error(s.write([]byte("b")))
+}
+
+func TestBodyGuard(t *testing.T) {
+	assert := assert.New(t)
+
+	bg := newTestFilter(`
+kind: BodyGuard
+name: bg
+maxLines: 2
+maxDepth: 2
+`)
+	assert.Equal(kind, bg.Kind())
+	assert.Equal("bg", bg.Name())
+	assert.Equal(defaultMaxKeys, bg.spec.MaxKeys)
+
+	ctx := newContext("application/json", `{"a": [1]}`)
+	assert.Empty(bg.Handle(ctx))
+	assert.Nil(ctx.GetOutputResponse())
+
+	ctx = newContext("application/vnd.api+json; charset=utf-8", `{"a": [[1]]}`)
+	assert.Equal(resultInvalid, bg.Handle(ctx))
+	assert.Equal(http.StatusBadRequest, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	ctx = newContext("text/plain", "[[[\n")
+	assert.Empty(bg.Handle(ctx))
+	ctx = newContext("text/plain", "1\n2\n3")
+	assert.Equal(resultInvalid, bg.Handle(ctx))
+
+	status := bg.Status().(*Status)
+	assert.Equal(uint64(4), status.Checked)
+	assert.Equal(uint64(2), status.Rejected)
+
+	bg = newTestFilter(`
+kind: BodyGuard
+name: bg
+`)
+	ctx = newContext("text/plain", "[[[\n")
+	assert.Empty(bg.Handle(ctx))
+	assert.Equal(uint64(0), bg.Status().(*Status).Checked)
+	bg.Close()
+}
+
+func TestBodyGuardStream(t *testing.T) {
+	assert := assert.New(t)
+
+	bg := newTestFilter(`
+kind: BodyGuard
+name: bg
+maxArrayLength: 2
+`)
+
+	newStreamContext := func(body string) (*context.Context, *httpprot.Request) {
+		stdr, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/", strings.NewReader(body))
+		stdr.Header.Set("Content-Type", "application/json")
+		req, _ := httpprot.NewRequest(stdr)
+		req.FetchPayload(-1)
+		ctx := context.New(nil)
+		ctx.SetInputRequest(req)
+		return ctx, req
+	}
+
+	ctx, req := newStreamContext(`[1, 2]`)
+	assert.Empty(bg.Handle(ctx))
+	data, err := io.ReadAll(req.GetPayload())
+	assert.NoError(err)
+	assert.Equal(`[1, 2]`, string(data))
+
+	ctx, req = newStreamContext(`[1, 2, 3]`)
+	assert.Empty(bg.Handle(ctx))
+	_, err = io.ReadAll(req.GetPayload())
+	assert.Error(err)
+	assert.Equal(uint64(1), bg.Status().(*Status).Rejected)
+}
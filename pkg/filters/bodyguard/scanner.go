@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bodyguard
+
+import (
+	"fmt"
+	"io"
+)
+
+type (
+	// limits is the structural limits of bodies, a zero limit means
+	// unlimited.
+	limits struct {
+		maxLines        int64
+		maxDepth        int
+		maxArrayLength  int
+		maxKeys         int
+		maxStringLength int
+	}
+
+	// scanner checks the structure of a body against the limits. It is a
+	// streaming scanner: the body could be fed in chunks, and nothing but
+	// a stack of the open containers is kept, so pathological bodies are
+	// never buffered. It is NOT a JSON validator, syntax errors other than
+	// unbalanced brackets are left to the backend.
+	scanner struct {
+		limits *limits
+		json   bool
+
+		lines       int64
+		atLineStart bool
+
+		stack    []frame
+		inString bool
+		escape   bool
+		// hexDigits is the number of hex digits of a \uXXXX escape to skip.
+		hexDigits int
+		strLen    int
+	}
+
+	// frame is an open object or array, count is the number of its keys
+	// or elements.
+	frame struct {
+		object bool
+		count  int
+	}
+
+	// guardReader checks the body while it is being read.
+	guardReader struct {
+		r   io.Reader
+		s   *scanner
+		err error
+		// onError is called once when the body exceeds a limit.
+		onError func(err error)
+	}
+)
+
+func newScanner(l *limits, json bool) *scanner {
+	return &scanner{limits: l, json: json, atLineStart: true}
+}
+
+// write scans the next chunk of the body.
+func (s *scanner) write(p []byte) error {
+	for _, b := range p {
+		if s.limits.maxLines > 0 {
+			if s.atLineStart {
+				s.lines++
+				if s.lines > s.limits.maxLines {
+					return fmt.Errorf("more than %d lines", s.limits.maxLines)
+				}
+			}
+			s.atLineStart = b == '\n'
+		}
+
+		if !s.json {
+			continue
+		}
+		if s.inString {
+			if err := s.scanString(b); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.scanValue(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *scanner) scanString(b byte) error {
+	switch {
+	case s.hexDigits > 0:
+		s.hexDigits--
+		return nil
+	case s.escape:
+		s.escape = false
+		if b == 'u' {
+			s.hexDigits = 4
+		}
+	case b == '\\':
+		// an escape sequence is counted as one character.
+		s.escape = true
+		return nil
+	case b == '"':
+		s.inString = false
+		return nil
+	case b&0xC0 == 0x80:
+		// continuation bytes of UTF-8 sequences.
+		return nil
+	}
+
+	s.strLen++
+	if max := s.limits.maxStringLength; max > 0 && s.strLen > max {
+		return fmt.Errorf("string longer than %d characters", max)
+	}
+	return nil
+}
+
+func (s *scanner) scanValue(b byte) error {
+	switch b {
+	case ' ', '\t', '\r', '\n', ':':
+		return nil
+	case ',':
+		if len(s.stack) == 0 {
+			return nil
+		}
+		s.stack[len(s.stack)-1].count++
+		return s.checkCount()
+	case '}', ']':
+		if len(s.stack) == 0 || s.stack[len(s.stack)-1].object != (b == '}') {
+			return fmt.Errorf("unbalanced %c", b)
+		}
+		s.stack = s.stack[:len(s.stack)-1]
+		return nil
+	}
+
+	if err := s.startItem(); err != nil {
+		return err
+	}
+
+	switch b {
+	case '"':
+		s.inString, s.strLen = true, 0
+	case '{', '[':
+		s.stack = append(s.stack, frame{object: b == '{'})
+		if max := s.limits.maxDepth; max > 0 && len(s.stack) > max {
+			return fmt.Errorf("nesting deeper than %d", max)
+		}
+	}
+	return nil
+}
+
+// startItem counts the first item of the current container, the following
+// items are counted by the commas.
+func (s *scanner) startItem() error {
+	if len(s.stack) == 0 {
+		return nil
+	}
+	if f := &s.stack[len(s.stack)-1]; f.count == 0 {
+		f.count = 1
+		return s.checkCount()
+	}
+	return nil
+}
+
+func (s *scanner) checkCount() error {
+	f := s.stack[len(s.stack)-1]
+	if f.object {
+		if max := s.limits.maxKeys; max > 0 && f.count > max {
+			return fmt.Errorf("object with more than %d keys", max)
+		}
+	} else if max := s.limits.maxArrayLength; max > 0 && f.count > max {
+		return fmt.Errorf("array with more than %d elements", max)
+	}
+	return nil
+}
+
+func (gr *guardReader) Read(p []byte) (int, error) {
+	if gr.err != nil {
+		return 0, gr.err
+	}
+
+	n, err := gr.r.Read(p)
+	if e := gr.s.write(p[:n]); e != nil {
+		gr.err = e
+		gr.onError(e)
+		return 0, e
+	}
+	return n, err
+}
@@ -0,0 +1,212 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bodypatcher implements a filter which applies a JSON Merge Patch
+// or a JSON Patch to the request body.
+package bodypatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/builder"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of BodyPatcher.
+	Kind = "BodyPatcher"
+
+	// PatchTypeMerge is the JSON Merge Patch defined by RFC 7386.
+	PatchTypeMerge = "mergePatch"
+	// PatchTypeJSON is the JSON Patch defined by RFC 6902.
+	PatchTypeJSON = "jsonPatch"
+
+	resultInvalidBody  = "invalidBody"
+	resultInvalidPatch = "invalidPatch"
+)
+
+var kind = &filters.Kind{
+	Name:              Kind,
+	Description:       "BodyPatcher applies a JSON Merge Patch or a JSON Patch to the request body.",
+	Results:           []string{resultInvalidBody, resultInvalidPatch},
+	RequestOnly:       true,
+	RequiresBuffering: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{PatchType: PatchTypeMerge}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &BodyPatcher{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// BodyPatcher is the filter BodyPatcher.
+	BodyPatcher struct {
+		spec *Spec
+
+		patch     []byte
+		jsonPatch jsonpatch.Patch
+		template  *builder.Template
+	}
+
+	// Spec is the spec of BodyPatcher.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		PatchType string `json:"patchType,omitempty" jsonschema:"enum=mergePatch,enum=jsonPatch"`
+		// Patch is the patch document in JSON.
+		Patch string `json:"patch,omitempty"`
+		// Template is a template which renders the patch document, it
+		// is executed with the same data as the builder filters.
+		Template string `json:"template,omitempty"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if (spec.Patch == "") == (spec.Template == "") {
+		return fmt.Errorf("one and only one of patch and template must be specified")
+	}
+
+	if spec.Template != "" {
+		if _, err := builder.NewTemplate(spec.Template); err != nil {
+			return fmt.Errorf("invalid template: %v", err)
+		}
+		return nil
+	}
+
+	_, _, err := spec.decodePatch([]byte(spec.Patch))
+	return err
+}
+
+// decodePatch validates the patch document, and decodes it if it is a JSON
+// Patch.
+func (spec *Spec) decodePatch(patch []byte) ([]byte, jsonpatch.Patch, error) {
+	if spec.PatchType == PatchTypeJSON {
+		p, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON Patch: %v", err)
+		}
+		return patch, p, nil
+	}
+
+	if !json.Valid(patch) {
+		return nil, nil, fmt.Errorf("invalid JSON Merge Patch: not a JSON document")
+	}
+	return patch, nil, nil
+}
+
+// Name returns the name of the BodyPatcher filter instance.
+func (bp *BodyPatcher) Name() string {
+	return bp.spec.Name()
+}
+
+// Kind returns the kind of BodyPatcher.
+func (bp *BodyPatcher) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the BodyPatcher
+func (bp *BodyPatcher) Spec() filters.Spec {
+	return bp.spec
+}
+
+// Init initializes BodyPatcher.
+func (bp *BodyPatcher) Init() {
+	if bp.spec.Template != "" {
+		bp.template = builder.MustNewTemplate(bp.spec.Template)
+		return
+	}
+	// the patch is validated, so there's no error.
+	bp.patch, bp.jsonPatch, _ = bp.spec.decodePatch([]byte(bp.spec.Patch))
+}
+
+// Inherit inherits previous generation of BodyPatcher.
+func (bp *BodyPatcher) Inherit(previousGeneration filters.Filter) {
+	bp.Init()
+}
+
+func (bp *BodyPatcher) reject(ctx *context.Context, result string, statusCode int, reason string) string {
+	ctx.AddTag("bodyPatcher: " + reason)
+
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(statusCode)
+	ctx.SetOutputResponse(resp)
+	return result
+}
+
+// Handle applies the patch to the request body.
+func (bp *BodyPatcher) Handle(ctx *context.Context) string {
+	patch, jsonPatch := bp.patch, bp.jsonPatch
+	if bp.template != nil {
+		rendered, err := bp.template.Render(ctx)
+		if err != nil {
+			return bp.reject(ctx, resultInvalidPatch, http.StatusInternalServerError, fmt.Sprintf("failed to render patch: %v", err))
+		}
+		patch, jsonPatch, err = bp.spec.decodePatch([]byte(rendered))
+		if err != nil {
+			return bp.reject(ctx, resultInvalidPatch, http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	if req.IsStream() {
+		return bp.reject(ctx, resultInvalidBody, http.StatusRequestEntityTooLarge, "body too large")
+	}
+	body := req.RawPayload()
+	if !json.Valid(body) {
+		return bp.reject(ctx, resultInvalidBody, http.StatusBadRequest, "body is not JSON")
+	}
+
+	var err error
+	if jsonPatch != nil {
+		body, err = jsonPatch.Apply(body)
+	} else {
+		body, err = jsonpatch.MergePatch(body, patch)
+	}
+	if err != nil {
+		return bp.reject(ctx, resultInvalidBody, http.StatusBadRequest, fmt.Sprintf("failed to apply patch: %v", err))
+	}
+
+	req.SetPayload(body)
+	req.Std().ContentLength = int64(len(body))
+	req.HTTPHeader().Set("Content-Length", strconv.Itoa(len(body)))
+	return ""
+}
+
+// Status returns status.
+func (bp *BodyPatcher) Status() interface{} {
+	return nil
+}
+
+// Close closes BodyPatcher.
+func (bp *BodyPatcher) Close() {
+}
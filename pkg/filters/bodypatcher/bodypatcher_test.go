@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bodypatcher
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func createBodyPatcher(yamlConfig string) (*BodyPatcher, error) {
+	rawSpec := make(map[string]interface{})
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	bp := kind.CreateInstance(spec).(*BodyPatcher)
+	bp.Init()
+	return bp, nil
+}
+
+func handle(bp *BodyPatcher, body string) (string, *httpprot.Request, *context.Context) {
+	stdReq, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/users?role=admin", strings.NewReader(body))
+	req, _ := httpprot.NewRequest(stdReq)
+	req.FetchPayload(1024)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return bp.Handle(ctx), req, ctx
+}
+
+func TestMergePatch(t *testing.T) {
+	assert := assert.New(t)
+
+	bp, err := createBodyPatcher(`
+kind: BodyPatcher
+name: bp
+patch: '{"role": "user", "password": null}'
+`)
+	assert.Nil(err)
+
+	result, req, _ := handle(bp, `{"name": "alice", "password": "secret"}`)
+	assert.Equal("", result)
+	assert.JSONEq(`{"name": "alice", "role": "user"}`, string(req.RawPayload()))
+	assert.Equal(int64(len(req.RawPayload())), req.Std().ContentLength)
+	assert.Equal(strconv.Itoa(len(req.RawPayload())), req.HTTPHeader().Get("Content-Length"))
+
+	result, _, ctx := handle(bp, `not json`)
+	assert.Equal(resultInvalidBody, result)
+	assert.Equal(http.StatusBadRequest, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+}
+
+func TestJSONPatch(t *testing.T) {
+	assert := assert.New(t)
+
+	bp, err := createBodyPatcher(`
+kind: BodyPatcher
+name: bp
+patchType: jsonPatch
+patch: '[{"op": "add", "path": "/tags/-", "value": "new"}, {"op": "remove", "path": "/password"}]'
+`)
+	assert.Nil(err)
+
+	result, req, _ := handle(bp, `{"tags": ["a"], "password": "secret"}`)
+	assert.Equal("", result)
+	assert.JSONEq(`{"tags": ["a", "new"]}`, string(req.RawPayload()))
+
+	// the path to remove doesn't exist.
+	result, _, _ = handle(bp, `{"tags": ["a"]}`)
+	assert.Equal(resultInvalidBody, result)
+}
+
+func TestTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	bp, err := createBodyPatcher(`
+kind: BodyPatcher
+name: bp
+template: '{"role": "{{index .req.URL.Query.role 0}}"}'
+`)
+	assert.Nil(err)
+
+	result, req, _ := handle(bp, `{"name": "alice"}`)
+	assert.Equal("", result)
+	assert.JSONEq(`{"name": "alice", "role": "admin"}`, string(req.RawPayload()))
+
+	bp, err = createBodyPatcher(`
+kind: BodyPatcher
+name: bp
+patchType: jsonPatch
+template: '{"op": "add"}'
+`)
+	assert.Nil(err)
+	result, _, ctx := handle(bp, `{"name": "alice"}`)
+	assert.Equal(resultInvalidPatch, result)
+	assert.Equal(http.StatusInternalServerError, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []string{`
+kind: BodyPatcher
+name: bp
+`, `
+kind: BodyPatcher
+name: bp
+patch: '{}'
+template: '{}'
+`, `
+kind: BodyPatcher
+name: bp
+patch: '{'
+`, `
+kind: BodyPatcher
+name: bp
+patchType: jsonPatch
+patch: '{"op": "add"}'
+`, `
+kind: BodyPatcher
+name: bp
+template: '{{'
+`} {
+		_, err := createBodyPatcher(c)
+		assert.Error(err, c)
+	}
+}
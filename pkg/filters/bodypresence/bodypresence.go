@@ -0,0 +1,275 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bodypresence implements a filter which enforces the presence or
+// absence of the request body per method and route.
+package bodypresence
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of BodyPresence.
+	Kind = "BodyPresence"
+
+	// HeaderBodyRule is the response header carrying the name of the rule
+	// violated by the request.
+	HeaderBodyRule = "X-EG-Body-Rule"
+
+	resultBodyViolation = "bodyViolation"
+
+	// BodyRequired requires a non-empty body.
+	BodyRequired = "required"
+	// BodyForbidden requires an empty body.
+	BodyForbidden = "forbidden"
+	// BodyOptional allows both empty and non-empty bodies.
+	BodyOptional = "optional"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "BodyPresence enforces the presence or absence of the request body per method and route.",
+	Results:     []string{resultBodyViolation},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &BodyPresence{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// BodyPresence is the filter BodyPresence.
+	BodyPresence struct {
+		spec *Spec
+
+		lock       sync.Mutex
+		violations map[string]uint64
+	}
+
+	// Spec is the spec of BodyPresence.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Rules are matched in order, the first matching rule applies,
+		// requests matching no rule are allowed.
+		Rules []*Rule `json:"rules" jsonschema:"required,minItems=1"`
+	}
+
+	// Rule is a body rule of requests.
+	Rule struct {
+		// Name identifies the rule in the rejections.
+		Name       string   `json:"name" jsonschema:"required"`
+		Methods    []string `json:"methods" jsonschema:"required,minItems=1"`
+		Path       string   `json:"path,omitempty" jsonschema:"pattern=^/"`
+		PathPrefix string   `json:"pathPrefix,omitempty" jsonschema:"pattern=^/"`
+		Body       string   `json:"body" jsonschema:"required,enum=required,enum=forbidden,enum=optional"`
+		// ContentTypes are the allowed media types of non-empty bodies,
+		// like application/json or text/*, any type is allowed if empty.
+		ContentTypes []string `json:"contentTypes,omitempty"`
+	}
+
+	// Status is the status of BodyPresence.
+	Status struct {
+		// Violations are the number of requests violating each rule.
+		Violations map[string]uint64 `json:"violations"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	names := map[string]bool{}
+	for _, r := range spec.Rules {
+		if r.Name == "" {
+			return fmt.Errorf("name of rule is required")
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicated rule %s", r.Name)
+		}
+		names[r.Name] = true
+
+		if r.Body == BodyForbidden && len(r.ContentTypes) > 0 {
+			return fmt.Errorf("rule %s: contentTypes can't be used with a forbidden body", r.Name)
+		}
+		for _, ct := range r.ContentTypes {
+			if !strings.Contains(ct, "/") {
+				return fmt.Errorf("rule %s: invalid content type %s", r.Name, ct)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Rule) match(req *httpprot.Request) bool {
+	methodMatched := false
+	for _, m := range r.Methods {
+		if strings.EqualFold(m, req.Method()) {
+			methodMatched = true
+			break
+		}
+	}
+	if !methodMatched {
+		return false
+	}
+
+	if r.Path == "" && r.PathPrefix == "" {
+		return true
+	}
+	path := req.Path()
+	if r.Path == path {
+		return true
+	}
+	return r.PathPrefix != "" && strings.HasPrefix(path, r.PathPrefix)
+}
+
+// check returns the violation of the request, it returns an empty string
+// if the request complies with the rule.
+func (r *Rule) check(req *httpprot.Request) string {
+	present := hasBody(req)
+
+	switch {
+	case r.Body == BodyRequired && !present:
+		return "request body is required"
+	case r.Body == BodyForbidden && present:
+		return "request body is not allowed"
+	case !present || len(r.ContentTypes) == 0:
+		return ""
+	}
+
+	ct := req.HTTPHeader().Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err == nil {
+		for _, allowed := range r.ContentTypes {
+			if mediaTypeMatch(strings.ToLower(allowed), mediaType) {
+				return ""
+			}
+		}
+	}
+	return fmt.Sprintf("content type %q is not allowed", ct)
+}
+
+// hasBody reports whether the request has a non-empty body, a stream body
+// is non-empty unless its length is known to be zero.
+func hasBody(req *httpprot.Request) bool {
+	if req.IsStream() {
+		return req.Std().ContentLength != 0
+	}
+	return len(req.RawPayload()) > 0
+}
+
+// mediaTypeMatch reports whether mediaType matches pattern, the subtype
+// of pattern could be a wildcard, like text/*.
+func mediaTypeMatch(pattern, mediaType string) bool {
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern {
+		return strings.HasPrefix(mediaType, prefix)
+	}
+	return false
+}
+
+// Name returns the name of the BodyPresence filter instance.
+func (bp *BodyPresence) Name() string {
+	return bp.spec.Name()
+}
+
+// Kind returns the kind of BodyPresence.
+func (bp *BodyPresence) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the BodyPresence
+func (bp *BodyPresence) Spec() filters.Spec {
+	return bp.spec
+}
+
+// Init initializes BodyPresence.
+func (bp *BodyPresence) Init() {
+	bp.violations = map[string]uint64{}
+}
+
+// Inherit inherits previous generation of BodyPresence.
+func (bp *BodyPresence) Inherit(previousGeneration filters.Filter) {
+	bp.Init()
+}
+
+// Handle checks the body of the request against the first matching rule.
+func (bp *BodyPresence) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	for _, r := range bp.spec.Rules {
+		if !r.match(req) {
+			continue
+		}
+		if violation := r.check(req); violation != "" {
+			return bp.reject(ctx, r, violation)
+		}
+		return ""
+	}
+	return ""
+}
+
+func (bp *BodyPresence) reject(ctx *context.Context, r *Rule, violation string) string {
+	bp.lock.Lock()
+	bp.violations[r.Name]++
+	bp.lock.Unlock()
+
+	msg := fmt.Sprintf("body rule %s: %s", r.Name, violation)
+	ctx.AddTag("bodyPresence: " + msg)
+
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(http.StatusBadRequest)
+	resp.HTTPHeader().Set(HeaderBodyRule, r.Name)
+	resp.HTTPHeader().Set("Content-Type", "text/plain; charset=utf-8")
+	resp.SetPayload([]byte(msg))
+	ctx.SetOutputResponse(resp)
+	return resultBodyViolation
+}
+
+// Status returns status.
+func (bp *BodyPresence) Status() interface{} {
+	s := &Status{Violations: map[string]uint64{}}
+
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	for k, v := range bp.violations {
+		s.Violations[k] = v
+	}
+	return s
+}
+
+// Close closes BodyPresence.
+func (bp *BodyPresence) Close() {
+}
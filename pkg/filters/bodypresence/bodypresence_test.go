@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bodypresence
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newSpec(yamlConfig string) (*Spec, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	return spec.(*Spec), nil
+}
+
+func newContext(t *testing.T, method, path, contentType, body string) *context.Context {
+	ctx := context.New(nil)
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	stdr, _ := http.NewRequest(method, "http://127.0.0.1"+path, r)
+	if contentType != "" {
+		stdr.Header.Set("Content-Type", contentType)
+	}
+	req, err := httpprot.NewRequest(stdr)
+	assert.Nil(t, err)
+	assert.Nil(t, req.FetchPayload(0))
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, rules := range []string{
+		`[{name: r, methods: [POST], body: unknown}]`,
+		`[{methods: [POST], body: required}]`,
+		`[{name: r, body: required}]`,
+		`[{name: r, methods: [POST], body: required}, {name: r, methods: [PUT], body: required}]`,
+		`[{name: r, methods: [GET], body: forbidden, contentTypes: [application/json]}]`,
+		`[{name: r, methods: [POST], body: required, contentTypes: [json]}]`,
+	} {
+		_, err := newSpec("kind: BodyPresence\nname: bp\nrules: " + rules)
+		assert.NotNil(err, rules)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	assert := assert.New(t)
+
+	spec, err := newSpec(`
+kind: BodyPresence
+name: bp
+rules:
+- name: upload
+  methods: [POST]
+  pathPrefix: /upload
+  body: optional
+- name: write
+  methods: [POST, PUT]
+  body: required
+  contentTypes: [application/json, text/*]
+- name: read
+  methods: [GET, DELETE]
+  body: forbidden
+`)
+	assert.Nil(err)
+	bp := kind.CreateInstance(spec).(*BodyPresence)
+	bp.Init()
+	defer bp.Close()
+
+	cases := []struct {
+		method, path, contentType, body string
+		rule                            string
+	}{
+		{http.MethodPost, "/users", "application/json", `{"a":1}`, ""},
+		{http.MethodPut, "/users", "text/plain; charset=utf-8", "a", ""},
+		{http.MethodPost, "/users", "", "", "write"},
+		{http.MethodPost, "/users", "application/xml", "<a/>", "write"},
+		{http.MethodPost, "/upload/1", "", "", ""},
+		{http.MethodGet, "/users", "", "", ""},
+		{http.MethodDelete, "/users", "application/json", "{}", "read"},
+		{http.MethodPatch, "/users", "", "", ""},
+	}
+
+	for _, c := range cases {
+		ctx := newContext(t, c.method, c.path, c.contentType, c.body)
+		result := bp.Handle(ctx)
+		if c.rule == "" {
+			assert.Equal("", result, c)
+			continue
+		}
+		assert.Equal(resultBodyViolation, result, c)
+		resp := ctx.GetOutputResponse().(*httpprot.Response)
+		assert.Equal(http.StatusBadRequest, resp.StatusCode())
+		assert.Equal(c.rule, resp.HTTPHeader().Get(HeaderBodyRule))
+		assert.Contains(string(resp.RawPayload()), "body rule "+c.rule)
+	}
+
+	status := bp.Status().(*Status)
+	assert.Equal(uint64(2), status.Violations["write"])
+	assert.Equal(uint64(1), status.Violations["read"])
+
+	newBP := kind.CreateInstance(spec).(*BodyPresence)
+	newBP.Inherit(bp)
+	assert.Empty(newBP.Status().(*Status).Violations)
+}
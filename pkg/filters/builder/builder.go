@@ -20,10 +20,12 @@ package builder
 
 import (
 	"bytes"
+	"fmt"
 	"text/template"
 
 	sprig "github.com/go-task/slim-sprig"
 	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/util/bodycodec"
 	"github.com/megaease/easegress/v2/pkg/util/codectool"
 )
 
@@ -34,7 +36,8 @@ const (
 type (
 	// Builder is the base HTTP builder.
 	Builder struct {
-		template *template.Template
+		template   *template.Template
+		bodyFormat string
 	}
 
 	// Spec is the spec of Builder.
@@ -42,11 +45,19 @@ type (
 		LeftDelim  string `json:"leftDelim,omitempty"`
 		RightDelim string `json:"rightDelim,omitempty"`
 		Template   string `json:"template,omitempty"`
+		// BodyFormat is the format of the bodies of the default request
+		// and response, if it is not empty, the bodies are decoded by the
+		// codec of the format, and could be accessed with .reqBody and
+		// .respBody in the template.
+		BodyFormat string `json:"bodyFormat,omitempty"`
 	}
 )
 
 // Validate validates the Builder Spec.
 func (spec *Spec) Validate() error {
+	if spec.BodyFormat != "" {
+		return bodycodec.Validate(spec.BodyFormat)
+	}
 	return nil
 }
 
@@ -54,6 +65,29 @@ func (b *Builder) reload(spec *Spec) {
 	t := template.New("").Delims(spec.LeftDelim, spec.RightDelim)
 	t.Funcs(sprig.TxtFuncMap()).Funcs(extraFuncs)
 	b.template = template.Must(t.Parse(spec.Template))
+	b.bodyFormat = spec.BodyFormat
+}
+
+// prepareData prepares the data of the template, the bodies of the default
+// request and response are decoded if the body format is specified.
+func (b *Builder) prepareData(ctx *context.Context) (map[string]interface{}, error) {
+	data, err := prepareBuilderData(ctx)
+	if err != nil || b.bodyFormat == "" {
+		return data, err
+	}
+
+	codec := bodycodec.Get(b.bodyFormat)
+	if req := ctx.GetRequest(context.DefaultNamespace); req != nil && !req.IsStream() {
+		if data["reqBody"], err = codec.Decode(req.RawPayload()); err != nil {
+			return nil, fmt.Errorf("decode request body as %s: %v", b.bodyFormat, err)
+		}
+	}
+	if resp := ctx.GetResponse(context.DefaultNamespace); resp != nil && !resp.IsStream() {
+		if data["respBody"], err = codec.Decode(resp.RawPayload()); err != nil {
+			return nil, fmt.Errorf("decode response body as %s: %v", b.bodyFormat, err)
+		}
+	}
+	return data, nil
 }
 
 func (b *Builder) build(data map[string]interface{}, v interface{}) error {
@@ -94,6 +128,11 @@ func prepareBuilderData(ctx *context.Context) (map[string]interface{}, error) {
 		}
 	}
 
+	errDetail := ""
+	if err := ctx.GetError(); err != nil {
+		errDetail = err.Error()
+	}
+
 	return map[string]interface{}{
 		"req":       defaultReq,
 		"resp":      defaultResp,
@@ -101,5 +140,7 @@ func prepareBuilderData(ctx *context.Context) (map[string]interface{}, error) {
 		"responses": responses,
 		"data":      ctx.Data(),
 		"namespace": ctx.Namespace(),
+		"result":    ctx.GetResult(),
+		"error":     errDetail,
 	}, nil
 }
@@ -105,7 +105,7 @@ func (db *DataBuilder) reload() {
 
 // Handle builds request.
 func (db *DataBuilder) Handle(ctx *context.Context) (result string) {
-	data, err := prepareBuilderData(ctx)
+	data, err := db.prepareData(ctx)
 	if err != nil {
 		logger.Warnf("prepareBuilderData failed: %v", err)
 		return resultBuildErr
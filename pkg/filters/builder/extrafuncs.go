@@ -28,6 +28,7 @@ import (
 	"text/template"
 
 	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/util/bodycodec"
 )
 
 func toFloat64(val interface{}) float64 {
@@ -141,6 +142,21 @@ var extraFuncs = template.FuncMap{
 		return string(b[1 : len(b)-1])
 	},
 
+	"decodeBody": func(format string, body interface{}) (interface{}, error) {
+		switch b := body.(type) {
+		case []byte:
+			return bodycodec.Decode(format, b)
+		case string:
+			return bodycodec.Decode(format, []byte(b))
+		}
+		return nil, fmt.Errorf("decodeBody: body must be a string or []byte, but got %T", body)
+	},
+
+	"encodeBody": func(format string, v interface{}) (string, error) {
+		b, err := bodycodec.Encode(format, v)
+		return string(b), err
+	},
+
 	"panic": func(v interface{}) interface{} {
 		panic(v)
 	},
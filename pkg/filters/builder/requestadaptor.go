@@ -54,6 +54,7 @@ var requestAdaptorKind = &filters.Kind{
 		resultDecompressFailed,
 		resultCompressFailed,
 	},
+	RequestOnly: true,
 	DefaultSpec: func() filters.Spec {
 		return &RequestAdaptorSpec{}
 	},
@@ -260,7 +261,7 @@ func (ra *RequestAdaptor) Handle(ctx *context.Context) string {
 
 	templateSpec := &RequestAdaptorTemplate{}
 	if ra.spec.Template != "" {
-		data, err := prepareBuilderData(ctx)
+		data, err := ra.prepareData(ctx)
 		if err != nil {
 			logger.Warnf("prepareBuilderData failed: %v", err)
 			return resultBuildErr
@@ -22,6 +22,7 @@ import (
 	"compress/gzip"
 	"io"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/megaease/easegress/v2/pkg/util/codectool"
@@ -404,3 +405,68 @@ func TestRequestAdaptorProcessCompressDecompress(t *testing.T) {
 
 	assert.Empty(ra.processDecompress(req))
 }
+
+func TestRequestAdaptorJSONNumber(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlConfig := `template: |
+      body: '{{.requests.DEFAULT.JSONBody | toJson}}'
+`
+	templateSpec := &RequestAdaptorSpec{}
+	codectool.MustUnmarshal([]byte(yamlConfig), templateSpec)
+	spec := defaultFilterSpec(&RequestAdaptorSpec{
+		Spec: Spec{Template: templateSpec.Template},
+	})
+	ra := requestAdaptorKind.CreateInstance(spec)
+	ra.Init()
+
+	// numbers should be kept as they are, 9007199254740993 is 2^53+1, which
+	// can't be represented exactly by a float64.
+	body := `{"id":9007199254740993,"price":1.10,"ratio":1e3,"uid":18446744073709551615}`
+	req, err := http.NewRequest(http.MethodPost, "127.0.0.1", strings.NewReader(body))
+	assert.Nil(err)
+
+	ctx := context.New(nil)
+	setRequest(t, ctx, "DEFAULT", req)
+	assert.Equal("", ra.Handle(ctx))
+
+	httpreq := ctx.GetInputRequest().(*httpprot.Request)
+	assert.Equal(body, string(httpreq.RawPayload()))
+}
+
+func TestRequestAdaptorBodyFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	// convert a form body to a SOAP request.
+	yamlConfig := `bodyFormat: form
+template: |
+  {{- $env := dict "soap:Envelope" (dict "-xmlns:soap" "http://schemas.xmlsoap.org/soap/envelope/" "soap:Body" (dict "GetUser" (dict "id" .reqBody.id))) }}
+  body: {{ encodeBody "xml" $env | quote }}
+`
+	templateSpec := &RequestAdaptorSpec{}
+	codectool.MustUnmarshal([]byte(yamlConfig), templateSpec)
+	assert.Nil(templateSpec.Spec.Validate())
+	spec := defaultFilterSpec(&RequestAdaptorSpec{Spec: templateSpec.Spec})
+	ra := requestAdaptorKind.CreateInstance(spec)
+	ra.Init()
+
+	req, err := http.NewRequest(http.MethodPost, "127.0.0.1", strings.NewReader("id=42&name=Tom"))
+	assert.Nil(err)
+	ctx := context.New(nil)
+	setRequest(t, ctx, "DEFAULT", req)
+	assert.Equal("", ra.Handle(ctx))
+
+	expected := `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><GetUser><id>42</id></GetUser></soap:Body></soap:Envelope>`
+	httpreq := ctx.GetInputRequest().(*httpprot.Request)
+	assert.Equal(expected, string(httpreq.RawPayload()))
+
+	// the body can't be decoded.
+	req, err = http.NewRequest(http.MethodPost, "127.0.0.1", strings.NewReader("%zz"))
+	assert.Nil(err)
+	ctx = context.New(nil)
+	setRequest(t, ctx, "DEFAULT", req)
+	assert.Equal(resultBuildErr, ra.Handle(ctx))
+
+	// unknown format.
+	assert.NotNil((&Spec{BodyFormat: "unknown"}).Validate())
+}
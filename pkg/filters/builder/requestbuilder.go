@@ -35,6 +35,7 @@ var requestBuilderKind = &filters.Kind{
 	Name:        RequestBuilderKind,
 	Description: "RequestBuilder builds a request",
 	Results:     []string{resultBuildErr},
+	RequestOnly: true,
 	DefaultSpec: func() filters.Spec {
 		return &RequestBuilderSpec{Protocol: "http"}
 	},
@@ -118,7 +119,7 @@ func (rb *RequestBuilder) Handle(ctx *context.Context) (result string) {
 		return ""
 	}
 
-	data, err := prepareBuilderData(ctx)
+	data, err := rb.prepareData(ctx)
 	if err != nil {
 		logger.Warnf("prepareBuilderData failed: %v", err)
 		return resultBuildErr
@@ -18,9 +18,15 @@
 package builder
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
+	"text/template"
+
+	sprig "github.com/go-task/slim-sprig"
 
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters"
@@ -62,6 +68,7 @@ type (
 	ResponseAdaptor struct {
 		spec *ResponseAdaptorSpec
 		Builder
+		elementTemplate *template.Template
 	}
 
 	// ResponseAdaptorSpec is HTTPAdaptor ResponseAdaptorSpec.
@@ -70,8 +77,30 @@ type (
 		Spec             `json:",inline"`
 
 		ResponseAdaptorTemplate `json:",inline"`
-		Compress                string `json:"compress,omitempty"`
-		Decompress              string `json:"decompress,omitempty"`
+		Compress                string           `json:"compress,omitempty"`
+		Decompress              string           `json:"decompress,omitempty"`
+		StreamArray             *StreamArraySpec `json:"streamArray,omitempty"`
+		StatusRules             []*StatusRule    `json:"statusRules,omitempty"`
+	}
+
+	// StatusRule adapts responses whose status codes match any of the
+	// StatusCodes, which could be exact codes like "404", or wildcards
+	// like "5xx".
+	StatusRule struct {
+		StatusCodes []string `json:"statusCodes" jsonschema:"required,minItems=1"`
+
+		ResponseAdaptorTemplate `json:",inline"`
+	}
+
+	// StreamArraySpec transforms the elements of a top-level JSON array in
+	// the response body one by one, without buffering the whole body if it
+	// is a stream.
+	StreamArraySpec struct {
+		// Template is executed for every element, and it should produce
+		// the JSON of the new element, the element is dropped if the
+		// result is empty. Besides the data of the builder template, the
+		// element and its index are available as .element and .index.
+		Template string `json:"template" jsonschema:"required"`
 	}
 
 	// ResponseAdaptorTemplate is the template of ResponseAdaptor.
@@ -81,6 +110,41 @@ type (
 	}
 )
 
+// Validate validates the status rule.
+func (rule *StatusRule) Validate() error {
+	for _, code := range rule.StatusCodes {
+		if !validStatusPattern(code) {
+			return fmt.Errorf("invalid status code %q, it should be like 404 or 5xx", code)
+		}
+	}
+	return nil
+}
+
+func validStatusPattern(pattern string) bool {
+	if len(pattern) != 3 || pattern[0] < '1' || pattern[0] > '5' {
+		return false
+	}
+	rest := strings.ToLower(pattern[1:])
+	if rest == "xx" {
+		return true
+	}
+	return rest[0] >= '0' && rest[0] <= '9' && rest[1] >= '0' && rest[1] <= '9'
+}
+
+// match returns whether the status code matches the rule.
+func (rule *StatusRule) match(code int) bool {
+	s := strconv.Itoa(code)
+	for _, pattern := range rule.StatusCodes {
+		if strings.EqualFold(pattern, s) {
+			return true
+		}
+		if strings.EqualFold(pattern[1:], "xx") && len(s) == 3 && s[0] == pattern[0] {
+			return true
+		}
+	}
+	return false
+}
+
 // Name returns the name of the ResponseAdaptor filter instance.
 func (ra *ResponseAdaptor) Name() string {
 	return ra.spec.Name()
@@ -110,6 +174,9 @@ func (ra *ResponseAdaptor) Init() {
 	if ra.spec.Body != "" && ra.spec.Decompress != "" {
 		panic("No need to decompress when body is specified in ResponseAdaptor spec")
 	}
+	if ra.spec.Body != "" && ra.spec.StreamArray != nil {
+		panic("ResponseAdaptor can't transform array elements when body is specified")
+	}
 	ra.reload()
 }
 
@@ -122,6 +189,11 @@ func (ra *ResponseAdaptor) reload() {
 	if ra.spec.Template != "" {
 		ra.Builder.reload(&ra.spec.Spec)
 	}
+	if ra.spec.StreamArray != nil {
+		t := template.New("").Delims(ra.spec.LeftDelim, ra.spec.RightDelim)
+		t.Funcs(sprig.TxtFuncMap()).Funcs(extraFuncs)
+		ra.elementTemplate = template.Must(t.Parse(ra.spec.StreamArray.Template))
+	}
 }
 
 // Handle adapts response.
@@ -134,7 +206,7 @@ func (ra *ResponseAdaptor) Handle(ctx *context.Context) string {
 
 	templateSpec := &ResponseAdaptorTemplate{}
 	if ra.spec.Template != "" {
-		data, err := prepareBuilderData(ctx)
+		data, err := ra.prepareData(ctx)
 		if err != nil {
 			logger.Warnf("prepareBuilderData failed: %v", err)
 			return resultBuildErr
@@ -164,18 +236,101 @@ func (ra *ResponseAdaptor) Handle(ctx *context.Context) string {
 		egresp.HTTPHeader().Del("Content-Encoding")
 	}
 
-	if ra.spec.Compress != "" {
-		if res := ra.compress(egresp); res != "" {
-			return res
+	// the first rule matching the status code applies.
+	for _, rule := range ra.spec.StatusRules {
+		if !rule.match(egresp.StatusCode()) {
+			continue
+		}
+		if rule.Header != nil {
+			adaptHeader(egresp.Std().Header, rule.Header)
+		}
+		if rule.Body != "" {
+			egresp.SetPayload([]byte(rule.Body))
+			egresp.HTTPHeader().Del("Content-Encoding")
 		}
+		break
 	}
 
+	// decompress before transforming the array elements, and compress
+	// after it.
 	if ra.spec.Decompress != "" {
 		if res := ra.decompress(egresp); res != "" {
 			return res
 		}
 	}
 
+	if ra.spec.StreamArray != nil {
+		if res := ra.transformArray(ctx, egresp); res != "" {
+			return res
+		}
+	}
+
+	if ra.spec.Compress != "" {
+		if res := ra.compress(egresp); res != "" {
+			return res
+		}
+	}
+
+	return ""
+}
+
+// transformArray transforms the elements of the top-level JSON array in the
+// body. Bodies which are not JSON arrays are passed through.
+func (ra *ResponseAdaptor) transformArray(ctx *context.Context, resp *httpprot.Response) string {
+	if ce := resp.HTTPHeader().Get(keyContentEncoding); ce != "" && ce != "identity" {
+		return ""
+	}
+	if ct := resp.HTTPHeader().Get("Content-Type"); ct != "" && !strings.Contains(ct, "json") {
+		return ""
+	}
+
+	data, err := ra.prepareData(ctx)
+	if err != nil {
+		logger.Warnf("prepareBuilderData failed: %v", err)
+		return resultBuildErr
+	}
+
+	// the elements are transformed one by one by the reader, so data is
+	// never used concurrently.
+	fn := func(index int, element interface{}) ([]byte, error) {
+		data["index"] = index
+		data["element"] = element
+
+		var buf bytes.Buffer
+		if err := ra.elementTemplate.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		out := bytes.TrimSpace(buf.Bytes())
+		if len(out) > 0 && !json.Valid(out) {
+			return nil, fmt.Errorf("element %d: invalid JSON: %s", index, out)
+		}
+		return out, nil
+	}
+
+	r, ok := readers.NewJSONArrayTransformReader(resp.GetPayload(), fn)
+	if resp.IsStream() {
+		// the beginning of the stream has been read by the reader, so the
+		// payload is replaced even if it is not an array.
+		resp.SetPayload(r)
+		if ok {
+			resp.ContentLength = -1
+			resp.HTTPHeader().Del(keyContentLength)
+		}
+		return ""
+	}
+	if !ok {
+		return ""
+	}
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		msgFmt := "ResponseAdaptor(%s): failed to transform array elements: %v"
+		logger.Warnf(msgFmt, ra.Name(), err)
+		return resultBuildErr
+	}
+	resp.SetPayload(body)
+	resp.ContentLength = int64(len(body))
+	resp.HTTPHeader().Set(keyContentLength, strconv.Itoa(len(body)))
 	return ""
 }
 
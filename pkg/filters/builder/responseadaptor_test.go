@@ -285,3 +285,136 @@ func TestResponseAdaptorCompressDecompress(t *testing.T) {
 
 	assert.Empty(ra.decompress(resp))
 }
+
+func TestResponseAdaptorStreamArray(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: ResponseAdaptor
+name: ra
+streamArray:
+  template: |
+    {{if gt .element.age.Int64 20}}{"id": {{.index}}, "name": "{{.element.name | upper}}"}{{end}}
+`
+	rawSpec := make(map[string]interface{})
+	codectool.MustUnmarshal([]byte(yamlSpec), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	assert.Nil(err)
+	ra := responseAdaptorKind.CreateInstance(spec).(*ResponseAdaptor)
+	ra.Init()
+
+	const body = `[{"name": "alice", "age": 30}, {"name": "bob", "age": 10}, {"name": "carol", "age": 25}]`
+	const expected = `[{"id": 0, "name": "ALICE"},{"id": 2, "name": "CAROL"}]`
+
+	newCtx := func(payload interface{}, contentType string) (*context.Context, *httpprot.Response) {
+		ctx := context.New(nil)
+		resp, _ := httpprot.NewResponse(nil)
+		resp.SetPayload(payload)
+		if contentType != "" {
+			resp.HTTPHeader().Set("Content-Type", contentType)
+		}
+		ctx.SetInputResponse(resp)
+		return ctx, resp
+	}
+
+	// buffered body.
+	ctx, resp := newCtx([]byte(body), "application/json")
+	assert.Equal("", ra.Handle(ctx))
+	assert.Equal(expected, string(resp.RawPayload()))
+	assert.Equal(int64(len(expected)), resp.ContentLength)
+
+	// stream body.
+	ctx, resp = newCtx(io.NopCloser(strings.NewReader(body)), "")
+	assert.Equal("", ra.Handle(ctx))
+	assert.True(resp.IsStream())
+	data, err := io.ReadAll(resp.GetPayload())
+	assert.Nil(err)
+	assert.Equal(expected, string(data))
+	assert.Equal(int64(-1), resp.ContentLength)
+
+	// not an array, or not JSON.
+	ctx, resp = newCtx([]byte(`{"name": "alice"}`), "application/json")
+	assert.Equal("", ra.Handle(ctx))
+	assert.Equal(`{"name": "alice"}`, string(resp.RawPayload()))
+
+	ctx, resp = newCtx(io.NopCloser(strings.NewReader(`hello`)), "")
+	assert.Equal("", ra.Handle(ctx))
+	data, _ = io.ReadAll(resp.GetPayload())
+	assert.Equal(`hello`, string(data))
+
+	ctx, resp = newCtx([]byte(body), "text/plain")
+	assert.Equal("", ra.Handle(ctx))
+	assert.Equal(body, string(resp.RawPayload()))
+
+	// the template produces invalid JSON.
+	rawSpec["streamArray"] = map[string]interface{}{"template": "{{.element.name}}"}
+	spec, _ = filters.NewSpec(nil, "", rawSpec)
+	ra = responseAdaptorKind.CreateInstance(spec).(*ResponseAdaptor)
+	ra.Init()
+	ctx, resp = newCtx([]byte(body), "")
+	assert.Equal(resultBuildErr, ra.Handle(ctx))
+	assert.Equal(body, string(resp.RawPayload()))
+
+	// body and streamArray can't be used together.
+	rawSpec["body"] = "hello"
+	spec, _ = filters.NewSpec(nil, "", rawSpec)
+	ra = responseAdaptorKind.CreateInstance(spec).(*ResponseAdaptor)
+	assert.Panics(func() { ra.Init() })
+}
+
+func TestResponseAdaptorStatusRules(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: ResponseAdaptor
+name: ra
+header:
+  set:
+    X-Gateway: easegress
+statusRules:
+- statusCodes: ["404"]
+  body: '{"error":"not found"}'
+- statusCodes: ["5xx", "429"]
+  header:
+    set:
+      Retry-After: "10"
+`
+	rawSpec := make(map[string]interface{})
+	codectool.MustUnmarshal([]byte(yamlSpec), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	assert.NoError(err)
+	ra := responseAdaptorKind.CreateInstance(spec).(*ResponseAdaptor)
+	ra.Init()
+
+	handle := func(code int) *httpprot.Response {
+		w := httptest.NewRecorder()
+		w.WriteHeader(code)
+		w.WriteString("backend")
+		ctx := getCtx(t, w.Result())
+		assert.Equal("", ra.Handle(ctx))
+		return ctx.GetInputResponse().(*httpprot.Response)
+	}
+
+	resp := handle(http.StatusNotFound)
+	assert.Equal(`{"error":"not found"}`, string(resp.RawPayload()))
+	assert.Equal("", resp.Header().Get("Retry-After"))
+	assert.Equal("easegress", resp.Header().Get("X-Gateway"))
+
+	for _, code := range []int{http.StatusBadGateway, http.StatusTooManyRequests} {
+		resp = handle(code)
+		assert.Equal("backend", string(resp.RawPayload()))
+		assert.Equal("10", resp.Header().Get("Retry-After"))
+	}
+
+	resp = handle(http.StatusOK)
+	assert.Equal("backend", string(resp.RawPayload()))
+	assert.Equal("", resp.Header().Get("Retry-After"))
+	assert.Equal("easegress", resp.Header().Get("X-Gateway"))
+
+	for _, code := range []string{"40", "6xx", "4x1", "abc", "0xx"} {
+		rule := &StatusRule{StatusCodes: []string{code}}
+		assert.Error(rule.Validate(), code)
+	}
+	rule := &StatusRule{StatusCodes: []string{"4XX", "200"}}
+	assert.NoError(rule.Validate())
+}
@@ -52,6 +52,7 @@ type (
 	ResponseBuilder struct {
 		spec *ResponseBuilderSpec
 		Builder
+		results map[string]*Builder
 	}
 
 	// ResponseBuilderSpec is ResponseBuilder Spec.
@@ -60,6 +61,10 @@ type (
 		Spec             `json:",inline"`
 		SourceNamespace  string `json:"sourceNamespace,omitempty"`
 		Protocol         string `json:"protocol,omitempty"`
+		// Results maps the results of the pipeline to the templates of
+		// the responses, the template of the latest non-empty result is
+		// used, and Template is used if no result matches.
+		Results map[string]string `json:"results,omitempty"`
 	}
 )
 
@@ -69,12 +74,18 @@ func (spec *ResponseBuilderSpec) Validate() error {
 		return fmt.Errorf("unknown protocol: %s", spec.Protocol)
 	}
 
-	if spec.SourceNamespace == "" && spec.Template == "" {
-		return fmt.Errorf("sourceNamespace or template must be specified")
+	if spec.SourceNamespace == "" && spec.Template == "" && len(spec.Results) == 0 {
+		return fmt.Errorf("sourceNamespace, template or results must be specified")
 	}
 
-	if spec.SourceNamespace != "" && spec.Template != "" {
-		return fmt.Errorf("sourceNamespace and template cannot be specified at the same time")
+	if spec.SourceNamespace != "" && (spec.Template != "" || len(spec.Results) > 0) {
+		return fmt.Errorf("sourceNamespace and template/results cannot be specified at the same time")
+	}
+
+	for result, tmpl := range spec.Results {
+		if result == "" || tmpl == "" {
+			return fmt.Errorf("result and its template must not be empty")
+		}
 	}
 
 	return spec.Spec.Validate()
@@ -106,9 +117,35 @@ func (rb *ResponseBuilder) Inherit(previousGeneration filters.Filter) {
 }
 
 func (rb *ResponseBuilder) reload() {
-	if rb.spec.SourceNamespace == "" {
+	if rb.spec.SourceNamespace != "" {
+		return
+	}
+
+	if rb.spec.Template != "" {
 		rb.Builder.reload(&rb.spec.Spec)
 	}
+
+	rb.results = make(map[string]*Builder, len(rb.spec.Results))
+	for result, tmpl := range rb.spec.Results {
+		spec := rb.spec.Spec
+		spec.Template = tmpl
+		b := &Builder{}
+		b.reload(&spec)
+		rb.results[result] = b
+	}
+}
+
+// builder returns the builder of the latest result of the pipeline, or
+// the default builder if no result matches, it returns nil if there's no
+// default builder either.
+func (rb *ResponseBuilder) builder(ctx *context.Context) *Builder {
+	if b := rb.results[ctx.GetResult()]; b != nil {
+		return b
+	}
+	if rb.spec.Template != "" {
+		return &rb.Builder
+	}
+	return nil
 }
 
 // Handle builds request.
@@ -118,7 +155,12 @@ func (rb *ResponseBuilder) Handle(ctx *context.Context) (result string) {
 		return ""
 	}
 
-	data, err := prepareBuilderData(ctx)
+	b := rb.builder(ctx)
+	if b == nil {
+		return ""
+	}
+
+	data, err := b.prepareData(ctx)
 	if err != nil {
 		logger.Warnf("prepareBuilderData failed: %v", err)
 		return resultBuildErr
@@ -126,7 +168,7 @@ func (rb *ResponseBuilder) Handle(ctx *context.Context) (result string) {
 
 	p := protocols.Get(rb.spec.Protocol)
 	ri := p.NewResponseInfo()
-	if err = rb.build(data, ri); err != nil {
+	if err = b.build(data, ri); err != nil {
 		msgFmt := "ResponseBuilder(%s): failed to build response info: %v"
 		logger.Warnf(msgFmt, rb.Name(), err)
 		return resultBuildErr
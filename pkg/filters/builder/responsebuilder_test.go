@@ -18,6 +18,7 @@
 package builder
 
 import (
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -335,4 +336,90 @@ template: |
 	spec = &ResponseBuilderSpec{}
 	codectool.MustUnmarshal([]byte(yamlConfig), spec)
 	assert.Error(spec.Validate())
+	// source namespace and results are both specified
+	yamlConfig = `
+name: responseBuilder
+kind: ResponseBuilder
+protocol: http
+sourceNamespace: request1
+results:
+  invalid: |
+    statusCode: 400
+`
+	spec = &ResponseBuilderSpec{}
+	codectool.MustUnmarshal([]byte(yamlConfig), spec)
+	assert.Error(spec.Validate())
+
+	// only results
+	yamlConfig = `
+name: responseBuilder
+kind: ResponseBuilder
+protocol: http
+results:
+  invalid: |
+    statusCode: 400
+`
+	spec = &ResponseBuilderSpec{}
+	codectool.MustUnmarshal([]byte(yamlConfig), spec)
+	assert.NoError(spec.Validate())
+}
+
+func TestResponseBuilderResults(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlConfig := `
+results:
+  invalid: |
+    statusCode: 400
+    headers:
+      X-Result: [{{.result}}]
+    body: 'invalid request {{.req.URL.Path}}'
+  serverError: |
+    statusCode: 502
+    body: '{{.error}}'
+`
+	spec := &ResponseBuilderSpec{}
+	codectool.MustUnmarshal([]byte(yamlConfig), spec)
+	rb := getResponseBuilder(spec)
+	defer rb.Close()
+
+	newContext := func(result string, err error) *context.Context {
+		ctx := context.New(nil)
+		stdReq := httptest.NewRequest(http.MethodGet, "http://127.0.0.1/orders", nil)
+		req, _ := httpprot.NewRequest(stdReq)
+		ctx.SetInputRequest(req)
+		ctx.SetResult(result)
+		ctx.SetError(err)
+		return ctx
+	}
+
+	ctx := newContext("invalid", nil)
+	assert.Empty(rb.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(400, resp.StatusCode())
+	assert.Equal("invalid", resp.HTTPHeader().Get("X-Result"))
+	assert.Equal("invalid request /orders", string(resp.RawPayload()))
+
+	ctx = newContext("serverError", fmt.Errorf("pool1: no available server"))
+	assert.Empty(rb.Handle(ctx))
+	resp = ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(502, resp.StatusCode())
+	assert.Equal("pool1: no available server", string(resp.RawPayload()))
+
+	// no result matches and there's no default template, the response is
+	// not changed.
+	ctx = newContext("unknown", nil)
+	assert.Empty(rb.Handle(ctx))
+	assert.Nil(ctx.GetOutputResponse())
+
+	// the default template.
+	spec.Template = "statusCode: 500"
+	rb = getResponseBuilder(spec)
+	ctx = newContext("unknown", nil)
+	assert.Empty(rb.Handle(ctx))
+	assert.Equal(500, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	ctx = newContext("invalid", nil)
+	assert.Empty(rb.Handle(ctx))
+	assert.Equal(400, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
 }
@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"text/template"
+
+	sprig "github.com/go-task/slim-sprig"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+const (
+	// ResponseComposerKind is the kind of ResponseComposer.
+	ResponseComposerKind = "ResponseComposer"
+
+	resultComposeErr = "composeErr"
+)
+
+var responseComposerKind = &filters.Kind{
+	Name:        ResponseComposerKind,
+	Description: "ResponseComposer composes the response body from multiple upstream responses",
+	Results:     []string{resultComposeErr},
+	DefaultSpec: func() filters.Spec {
+		return &ResponseComposerSpec{
+			StatusCode:  http.StatusOK,
+			ContentType: "application/json",
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &ResponseComposer{spec: spec.(*ResponseComposerSpec)}
+	},
+}
+
+func init() {
+	filters.Register(responseComposerKind)
+}
+
+type (
+	// ResponseComposer is filter ResponseComposer.
+	ResponseComposer struct {
+		spec     *ResponseComposerSpec
+		template *template.Template
+	}
+
+	// ResponseComposerSpec is ResponseComposer Spec.
+	ResponseComposerSpec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Upstreams are the namespaces of the upstream responses, they
+		// could be accessed with .upstreams.<namespace> in the template.
+		Upstreams  []string `json:"upstreams" jsonschema:"required,minItems=1"`
+		LeftDelim  string   `json:"leftDelim,omitempty"`
+		RightDelim string   `json:"rightDelim,omitempty"`
+		// Template is the template of the response body.
+		Template    string `json:"template" jsonschema:"required"`
+		StatusCode  int    `json:"statusCode,omitempty" jsonschema:"minimum=200,maximum=599"`
+		ContentType string `json:"contentType,omitempty"`
+	}
+
+	// ComposedUpstream is an upstream response in the template of the
+	// ResponseComposer, it is always available, so that the template
+	// could check whether the upstream response is present or succeeded.
+	ComposedUpstream struct {
+		// Present is whether the upstream response is captured.
+		Present bool
+		// OK is whether the upstream response is present and its status
+		// code is less than 400.
+		OK         bool
+		StatusCode int
+		Header     http.Header
+		Body       string
+
+		json       interface{}
+		jsonParsed bool
+	}
+)
+
+// Validate validates the ResponseComposer Spec.
+func (spec *ResponseComposerSpec) Validate() error {
+	if spec.Template == "" {
+		return fmt.Errorf("template must be specified")
+	}
+	if _, err := spec.parseTemplate(); err != nil {
+		return fmt.Errorf("invalid template: %v", err)
+	}
+	return nil
+}
+
+func (spec *ResponseComposerSpec) parseTemplate() (*template.Template, error) {
+	t := template.New("").Delims(spec.LeftDelim, spec.RightDelim)
+	t.Funcs(sprig.TxtFuncMap()).Funcs(extraFuncs)
+	return t.Parse(spec.Template)
+}
+
+// JSON returns the body parsed as JSON, it returns nil if the body is not
+// a valid JSON, so that the template doesn't fail on bad upstreams.
+func (u *ComposedUpstream) JSON() interface{} {
+	if !u.jsonParsed {
+		u.jsonParsed = true
+		var v interface{}
+		if codectool.UnmarshalJSONNumber([]byte(u.Body), &v) == nil {
+			u.json = v
+		}
+	}
+	return u.json
+}
+
+// Name returns the name of the ResponseComposer filter instance.
+func (rc *ResponseComposer) Name() string {
+	return rc.spec.Name()
+}
+
+// Kind returns the kind of ResponseComposer.
+func (rc *ResponseComposer) Kind() *filters.Kind {
+	return responseComposerKind
+}
+
+// Spec returns the spec used by the ResponseComposer
+func (rc *ResponseComposer) Spec() filters.Spec {
+	return rc.spec
+}
+
+// Init initializes ResponseComposer.
+func (rc *ResponseComposer) Init() {
+	rc.reload()
+}
+
+// Inherit inherits previous generation of ResponseComposer.
+func (rc *ResponseComposer) Inherit(previousGeneration filters.Filter) {
+	rc.Init()
+}
+
+func (rc *ResponseComposer) reload() {
+	rc.template = template.Must(rc.spec.parseTemplate())
+}
+
+// Handle composes the response.
+func (rc *ResponseComposer) Handle(ctx *context.Context) (result string) {
+	data, err := prepareBuilderData(ctx)
+	if err != nil {
+		logger.Warnf("prepareBuilderData failed: %v", err)
+		return resultComposeErr
+	}
+
+	upstreams := make(map[string]*ComposedUpstream, len(rc.spec.Upstreams))
+	for _, ns := range rc.spec.Upstreams {
+		upstreams[ns] = composedUpstream(ctx, ns)
+	}
+	data["upstreams"] = upstreams
+
+	var body bytes.Buffer
+	if err = rc.template.Execute(&body, data); err != nil {
+		msgFmt := "ResponseComposer(%s): failed to compose response: %v"
+		logger.Warnf(msgFmt, rc.Name(), err)
+		return resultComposeErr
+	}
+
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(rc.spec.StatusCode)
+	if rc.spec.ContentType != "" {
+		resp.Header().Set("Content-Type", rc.spec.ContentType)
+	}
+	resp.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	resp.SetPayload(body.Bytes())
+
+	ctx.SetOutputResponse(resp)
+	return ""
+}
+
+func composedUpstream(ctx *context.Context, ns string) *ComposedUpstream {
+	u := &ComposedUpstream{Header: http.Header{}}
+
+	resp, ok := ctx.GetResponse(ns).(*httpprot.Response)
+	if !ok || resp == nil {
+		return u
+	}
+
+	u.Present = true
+	u.StatusCode = resp.StatusCode()
+	u.OK = u.StatusCode < 400
+	u.Header = resp.HTTPHeader()
+	if resp.IsStream() {
+		u.Body = fmt.Sprintf("the body of response %q is a stream", ns)
+	} else {
+		u.Body = string(resp.RawPayload())
+	}
+	return u
+}
+
+// Status returns status.
+func (rc *ResponseComposer) Status() interface{} {
+	return nil
+}
+
+// Close closes ResponseComposer.
+func (rc *ResponseComposer) Close() {
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package builder
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func newResponseComposer(t *testing.T, yamlConfig string) (*ResponseComposer, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	rc := responseComposerKind.CreateInstance(spec).(*ResponseComposer)
+	rc.Init()
+	return rc, nil
+}
+
+func TestResponseComposerValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newResponseComposer(t, `
+kind: ResponseComposer
+name: rc
+upstreams: [users]
+`)
+	assert.NotNil(err)
+
+	_, err = newResponseComposer(t, `
+kind: ResponseComposer
+name: rc
+upstreams: [users]
+template: '{{.upstreams.users.Body'
+`)
+	assert.NotNil(err)
+
+	_, err = newResponseComposer(t, `
+kind: ResponseComposer
+name: rc
+template: '{{.upstreams.users.Body}}'
+`)
+	assert.NotNil(err)
+}
+
+func TestResponseComposer(t *testing.T) {
+	assert := assert.New(t)
+
+	rc, err := newResponseComposer(t, `
+kind: ResponseComposer
+name: rc
+upstreams: [users, orders]
+template: |
+  {"user": {{.upstreams.users.Body}},
+  {{- if .upstreams.orders.OK}}
+  "orders": {{toJson (index .upstreams.orders.JSON "items")}}
+  {{- else}}
+  "orders": null, "ordersStatus": {{.upstreams.orders.StatusCode}}
+  {{- end}}}
+`)
+	assert.Nil(err)
+	defer rc.Close()
+
+	newUpstream := func(code int, body string) *httpprot.Response {
+		resp, _ := httpprot.NewResponse(nil)
+		resp.SetStatusCode(code)
+		resp.SetPayload([]byte(body))
+		return resp
+	}
+
+	ctx := context.New(nil)
+	ctx.SetResponse("users", newUpstream(200, `{"name":"alice"}`))
+	ctx.SetResponse("orders", newUpstream(200, `{"items":[1,2]}`))
+	assert.Equal("", rc.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusOK, resp.StatusCode())
+	assert.Equal("application/json", resp.HTTPHeader().Get("Content-Type"))
+	assert.JSONEq(`{"user":{"name":"alice"},"orders":[1,2]}`, string(resp.RawPayload()))
+
+	// failed upstream.
+	ctx = context.New(nil)
+	ctx.SetResponse("users", newUpstream(200, `{"name":"alice"}`))
+	ctx.SetResponse("orders", newUpstream(503, `unavailable`))
+	assert.Equal("", rc.Handle(ctx))
+	resp = ctx.GetOutputResponse().(*httpprot.Response)
+	assert.JSONEq(`{"user":{"name":"alice"},"orders":null,"ordersStatus":503}`, string(resp.RawPayload()))
+
+	// missing upstream.
+	ctx = context.New(nil)
+	ctx.SetResponse("users", newUpstream(200, `{"name":"alice"}`))
+	assert.Equal("", rc.Handle(ctx))
+	resp = ctx.GetOutputResponse().(*httpprot.Response)
+	assert.JSONEq(`{"user":{"name":"alice"},"orders":null,"ordersStatus":0}`, string(resp.RawPayload()))
+
+	// status code and template error.
+	rc, err = newResponseComposer(t, `
+kind: ResponseComposer
+name: rc
+upstreams: [users]
+statusCode: 201
+template: '{{index .upstreams.users.JSON "name"}}'
+`)
+	assert.Nil(err)
+
+	ctx = context.New(nil)
+	ctx.SetResponse("users", newUpstream(200, `{"name":"alice"}`))
+	assert.Equal("", rc.Handle(ctx))
+	resp = ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusCreated, resp.StatusCode())
+	assert.Equal("alice", string(resp.RawPayload()))
+
+	ctx = context.New(nil)
+	ctx.SetResponse("users", newUpstream(200, `[1]`))
+	assert.Equal(resultComposeErr, rc.Handle(ctx))
+}
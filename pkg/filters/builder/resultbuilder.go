@@ -108,7 +108,7 @@ func (rb *ResultBuilder) reload() {
 
 // Handle builds result.
 func (rb *ResultBuilder) Handle(ctx *context.Context) (result string) {
-	data, err := prepareBuilderData(ctx)
+	data, err := rb.prepareData(ctx)
 	if err != nil {
 		logger.Warnf("prepareBuilderData failed: %v", err)
 		return resultBuildErr
@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package builder
+
+import (
+	"strings"
+	"text/template"
+
+	sprig "github.com/go-task/slim-sprig"
+	"github.com/megaease/easegress/v2/pkg/context"
+)
+
+// Template is a text template which is executed with the same data and
+// functions as the templates of the builder filters, it is used by other
+// filters to extract strings like keys from the context.
+type Template struct {
+	template *template.Template
+}
+
+// NewTemplate parses text and creates a Template.
+func NewTemplate(text string) (*Template, error) {
+	t := template.New("").Funcs(sprig.TxtFuncMap()).Funcs(extraFuncs)
+	t, err := t.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{template: t}, nil
+}
+
+// MustNewTemplate is like NewTemplate but panics on error.
+func MustNewTemplate(text string) *Template {
+	t, err := NewTemplate(text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Render executes the template against the context and returns the result.
+func (t *Template) Render(ctx *context.Context) (string, error) {
+	data, err := t.Data(ctx)
+	if err != nil {
+		return "", err
+	}
+	return t.Execute(data)
+}
+
+// Data prepares the data of the context for the template, the data could
+// be extended, and reused by multiple executions of the template.
+func (t *Template) Data(ctx *context.Context) (map[string]interface{}, error) {
+	return prepareBuilderData(ctx)
+}
+
+// Execute executes the template against data and returns the result.
+func (t *Template) Execute(data map[string]interface{}) (string, error) {
+	var sb strings.Builder
+	if err := t.template.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
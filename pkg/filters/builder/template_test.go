@@ -0,0 +1,45 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package builder
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewTemplate("{{.req.Header")
+	assert.NotNil(err)
+	assert.Panics(func() { MustNewTemplate("{{end}}") })
+
+	tmpl := MustNewTemplate(`{{.req.Header.Get "X-Api-Key"}}-{{.req.Host}}`)
+
+	stdReq, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	stdReq.Header.Set("X-Api-Key", "abc")
+	ctx := context.New(nil)
+	setRequest(t, ctx, context.DefaultNamespace, stdReq)
+
+	s, err := tmpl.Render(ctx)
+	assert.Nil(err)
+	assert.Equal("abc-example.com", s)
+}
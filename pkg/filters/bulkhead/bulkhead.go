@@ -0,0 +1,433 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bulkhead implements a filter which isolates the concurrency of
+// requests to different backends.
+package bulkhead
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/builder"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of Bulkhead.
+	Kind = "Bulkhead"
+
+	resultRejected = "rejected"
+
+	defaultMaxWait = 100 * time.Millisecond
+	defaultMaxKeys = 10000
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "Bulkhead isolates the concurrency of requests per backend.",
+	Results:     []string{resultRejected},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{
+			DefaultPolicy: &Policy{MaxConcurrency: 100},
+			MaxKeys:       defaultMaxKeys,
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &Bulkhead{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// Bulkhead is the Bulkhead filter, it keeps a bulkhead for every key,
+	// so that requests to a slow backend can't exhaust the capacity of
+	// the whole server.
+	Bulkhead struct {
+		spec *Spec
+
+		keyTemplate *builder.Template
+		policies    map[string]*Policy
+		bulkheads   *bulkheadStore
+	}
+
+	// Spec is the spec of Bulkhead.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Key is a template to generate the bulkhead key, usually the name
+		// of the backend, it could also be a literal string like a pool name.
+		Key           string    `json:"key" jsonschema:"required"`
+		Policies      []*Policy `json:"policies,omitempty"`
+		DefaultPolicy *Policy   `json:"defaultPolicy,omitempty"`
+		// MaxKeys is the max number of bulkheads to keep, the least
+		// recently used idle ones are evicted beyond it.
+		MaxKeys int `json:"maxKeys,omitempty" jsonschema:"minimum=1"`
+	}
+
+	// Policy is the limits of a bulkhead, Name is the key of the bulkhead
+	// it applies to.
+	Policy struct {
+		Name           string `json:"name,omitempty"`
+		MaxConcurrency int    `json:"maxConcurrency" jsonschema:"required,minimum=1"`
+		MaxQueue       int    `json:"maxQueue,omitempty" jsonschema:"minimum=0"`
+		MaxWait        string `json:"maxWait,omitempty" jsonschema:"format=duration"`
+	}
+
+	// Status is the status of Bulkhead.
+	Status struct {
+		Bulkheads map[string]*BulkheadStatus `json:"bulkheads"`
+	}
+
+	// BulkheadStatus is the status of a single bulkhead.
+	BulkheadStatus struct {
+		MaxConcurrency int     `json:"maxConcurrency"`
+		MaxQueue       int     `json:"maxQueue"`
+		Active         int32   `json:"active"`
+		Queued         int32   `json:"queued"`
+		Rejected       uint64  `json:"rejected"`
+		Utilization    float64 `json:"utilization"`
+	}
+
+	// bulkhead limits the concurrency of requests with the same key, its
+	// policy could be updated in place, so that requests holding slots
+	// are still counted after the filter is updated.
+	bulkhead struct {
+		mutex    sync.Mutex
+		policy   *Policy
+		maxWait  time.Duration
+		active   int
+		waiters  list.List
+		rejected uint64
+
+		// refs is the number of requests using the bulkhead, including
+		// the ones which are active, queued or about to acquire a slot.
+		refs int64
+	}
+
+	// bulkheadStore keeps the bulkheads in least recently used order, a
+	// bulkhead is only evicted when no request is using it, so the store
+	// may exceed maxKeys temporarily when all bulkheads are busy.
+	bulkheadStore struct {
+		mutex     sync.Mutex
+		maxKeys   int
+		bulkheads map[string]*list.Element
+		lru       list.List
+	}
+
+	bulkheadEntry struct {
+		key      string
+		bulkhead *bulkhead
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if _, err := builder.NewTemplate(spec.Key); err != nil {
+		return fmt.Errorf("invalid key: %v", err)
+	}
+
+	names := map[string]struct{}{}
+	for _, p := range spec.Policies {
+		if p.Name == "" {
+			return fmt.Errorf("name of policy is required")
+		}
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("duplicated policy: %s", p.Name)
+		}
+		names[p.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+func newBulkhead(policy *Policy) *bulkhead {
+	b := &bulkhead{}
+	b.setPolicy(policy)
+	return b
+}
+
+func (b *bulkhead) setPolicy(policy *Policy) {
+	maxWait, _ := time.ParseDuration(policy.MaxWait)
+	if maxWait <= 0 {
+		maxWait = defaultMaxWait
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.policy, b.maxWait = policy, maxWait
+	b.grant()
+}
+
+// grant hands the free slots over to the waiters, in the order of their
+// arrival, the caller must hold the mutex.
+func (b *bulkhead) grant() {
+	for b.active < b.policy.MaxConcurrency && b.waiters.Len() > 0 {
+		ready := b.waiters.Remove(b.waiters.Front()).(chan struct{})
+		close(ready)
+		b.active++
+	}
+}
+
+// acquire acquires a slot of the bulkhead, it waits in the queue if all
+// slots are in use, and returns false immediately if the queue is full.
+func (b *bulkhead) acquire(done <-chan struct{}) bool {
+	b.mutex.Lock()
+	if b.active < b.policy.MaxConcurrency && b.waiters.Len() == 0 {
+		b.active++
+		b.mutex.Unlock()
+		return true
+	}
+
+	if b.waiters.Len() >= b.policy.MaxQueue {
+		b.mutex.Unlock()
+		atomic.AddUint64(&b.rejected, 1)
+		return false
+	}
+
+	ready := make(chan struct{})
+	elem := b.waiters.PushBack(ready)
+	maxWait := b.maxWait
+	b.mutex.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return true
+	case <-timer.C:
+	case <-done:
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// the slot may be granted after the timeout.
+	select {
+	case <-ready:
+		return true
+	default:
+	}
+
+	b.waiters.Remove(elem)
+	atomic.AddUint64(&b.rejected, 1)
+	return false
+}
+
+func (b *bulkhead) release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.active--
+	b.grant()
+}
+
+func (b *bulkhead) unref() {
+	atomic.AddInt64(&b.refs, -1)
+}
+
+func (b *bulkhead) status() *BulkheadStatus {
+	b.mutex.Lock()
+	s := &BulkheadStatus{
+		MaxConcurrency: b.policy.MaxConcurrency,
+		MaxQueue:       b.policy.MaxQueue,
+		Active:         int32(b.active),
+		Queued:         int32(b.waiters.Len()),
+	}
+	b.mutex.Unlock()
+
+	s.Rejected = atomic.LoadUint64(&b.rejected)
+	s.Utilization = float64(s.Active) / float64(s.MaxConcurrency)
+	return s
+}
+
+func newBulkheadStore(maxKeys int) *bulkheadStore {
+	return &bulkheadStore{
+		maxKeys:   maxKeys,
+		bulkheads: map[string]*list.Element{},
+	}
+}
+
+// get returns the bulkhead of the key and increases its reference count,
+// newPolicy is called to create the bulkhead if it doesn't exist.
+func (bs *bulkheadStore) get(key string, newPolicy func(string) *Policy) *bulkhead {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	if elem, ok := bs.bulkheads[key]; ok {
+		bs.lru.MoveToFront(elem)
+		b := elem.Value.(*bulkheadEntry).bulkhead
+		atomic.AddInt64(&b.refs, 1)
+		return b
+	}
+
+	bs.evict(bs.maxKeys - 1)
+
+	b := newBulkhead(newPolicy(key))
+	b.refs = 1
+	bs.bulkheads[key] = bs.lru.PushFront(&bulkheadEntry{key: key, bulkhead: b})
+	return b
+}
+
+// evict evicts the least recently used idle bulkheads until there are
+// at most n bulkheads, the caller must hold the mutex. References are
+// only increased with the mutex held, so an idle bulkhead can't be
+// picked up by a request while it is being evicted.
+func (bs *bulkheadStore) evict(n int) {
+	for elem := bs.lru.Back(); elem != nil && bs.lru.Len() > n; {
+		prev := elem.Prev()
+		entry := elem.Value.(*bulkheadEntry)
+		if atomic.LoadInt64(&entry.bulkhead.refs) == 0 {
+			bs.lru.Remove(elem)
+			delete(bs.bulkheads, entry.key)
+		}
+		elem = prev
+	}
+}
+
+// update updates the max number of keys and the policies of the existing
+// bulkheads.
+func (bs *bulkheadStore) update(maxKeys int, newPolicy func(string) *Policy) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	bs.maxKeys = maxKeys
+	for elem := bs.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*bulkheadEntry)
+		entry.bulkhead.setPolicy(newPolicy(entry.key))
+	}
+	bs.evict(maxKeys)
+}
+
+func (bs *bulkheadStore) status() map[string]*BulkheadStatus {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	m := make(map[string]*BulkheadStatus, len(bs.bulkheads))
+	for key, elem := range bs.bulkheads {
+		m[key] = elem.Value.(*bulkheadEntry).bulkhead.status()
+	}
+	return m
+}
+
+// Name returns the name of the Bulkhead filter instance.
+func (bh *Bulkhead) Name() string {
+	return bh.spec.Name()
+}
+
+// Kind returns the kind of Bulkhead.
+func (bh *Bulkhead) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the Bulkhead
+func (bh *Bulkhead) Spec() filters.Spec {
+	return bh.spec
+}
+
+// Init initializes Bulkhead.
+func (bh *Bulkhead) Init() {
+	bh.reload(nil)
+}
+
+// Inherit inherits previous generation of Bulkhead, the bulkheads are
+// kept, so the requests holding slots are still counted.
+func (bh *Bulkhead) Inherit(previousGeneration filters.Filter) {
+	bh.reload(previousGeneration.(*Bulkhead))
+}
+
+func (bh *Bulkhead) reload(previousGeneration *Bulkhead) {
+	bh.keyTemplate = builder.MustNewTemplate(bh.spec.Key)
+	bh.policies = map[string]*Policy{}
+	for _, p := range bh.spec.Policies {
+		bh.policies[p.Name] = p
+	}
+	if bh.spec.DefaultPolicy == nil {
+		bh.spec.DefaultPolicy = &Policy{MaxConcurrency: 100}
+	}
+	if bh.spec.MaxKeys <= 0 {
+		bh.spec.MaxKeys = defaultMaxKeys
+	}
+
+	if previousGeneration != nil {
+		bh.bulkheads = previousGeneration.bulkheads
+		bh.bulkheads.update(bh.spec.MaxKeys, bh.policyOf)
+		return
+	}
+	bh.bulkheads = newBulkheadStore(bh.spec.MaxKeys)
+}
+
+func (bh *Bulkhead) policyOf(key string) *Policy {
+	if policy := bh.policies[key]; policy != nil {
+		return policy
+	}
+	return bh.spec.DefaultPolicy
+}
+
+// Handle acquires a slot from the bulkhead of the request, the slot is
+// released when the request finishes.
+func (bh *Bulkhead) Handle(ctx *context.Context) string {
+	key, err := bh.keyTemplate.Render(ctx)
+	if err != nil {
+		logger.Warnf("%s: failed to render key: %v", bh.Name(), err)
+	}
+
+	var done <-chan struct{}
+	if req, ok := ctx.GetInputRequest().(*httpprot.Request); ok {
+		done = req.Context().Done()
+	}
+
+	b := bh.bulkheads.get(key, bh.policyOf)
+	if !b.acquire(done) {
+		b.unref()
+		ctx.AddTag(fmt.Sprintf("bulkhead: %s rejected", key))
+
+		resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+		if resp == nil {
+			resp, _ = httpprot.NewResponse(nil)
+		}
+		resp.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.SetOutputResponse(resp)
+		return resultRejected
+	}
+
+	ctx.OnFinish(func() {
+		b.release()
+		b.unref()
+	})
+	return ""
+}
+
+// Status returns the status of all bulkheads.
+func (bh *Bulkhead) Status() interface{} {
+	return &Status{Bulkheads: bh.bulkheads.status()}
+}
+
+// Close closes Bulkhead.
+func (bh *Bulkhead) Close() {
+}
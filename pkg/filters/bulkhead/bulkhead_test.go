@@ -0,0 +1,236 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bulkhead
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestBulkhead(t *testing.T, yamlConfig string) *Bulkhead {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	assert.Nil(t, err)
+	bh := kind.CreateInstance(spec).(*Bulkhead)
+	bh.Init()
+	return bh
+}
+
+func newContext(t *testing.T, host string) *context.Context {
+	stdReq, _ := http.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+	req, err := httpprot.NewRequest(stdReq)
+	assert.Nil(t, err)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &Spec{Key: "{{.req.Host"}
+	assert.NotNil(spec.Validate())
+
+	spec.Key = "pool"
+	spec.Policies = []*Policy{{MaxConcurrency: 1}}
+	assert.NotNil(spec.Validate())
+
+	spec.Policies = []*Policy{{Name: "a", MaxConcurrency: 1}, {Name: "a", MaxConcurrency: 1}}
+	assert.NotNil(spec.Validate())
+
+	spec.Policies = spec.Policies[:1]
+	assert.Nil(spec.Validate())
+}
+
+func TestBulkhead(t *testing.T) {
+	assert := assert.New(t)
+
+	const yamlConfig = `
+kind: Bulkhead
+name: bulkhead
+key: "{{.req.Host}}"
+policies:
+- name: slow.example.com
+  maxConcurrency: 1
+  maxQueue: 1
+  maxWait: 20ms
+defaultPolicy:
+  maxConcurrency: 2
+`
+	bh := newTestBulkhead(t, yamlConfig)
+	assert.Equal(kind, bh.Kind())
+	defer bh.Close()
+
+	ctx1 := newContext(t, "slow.example.com")
+	assert.Equal("", bh.Handle(ctx1))
+
+	// the second request waits in the queue and times out.
+	ctx2 := newContext(t, "slow.example.com")
+	start := time.Now()
+	assert.Equal(resultRejected, bh.Handle(ctx2))
+	assert.GreaterOrEqual(time.Since(start), 20*time.Millisecond)
+	assert.Equal(http.StatusServiceUnavailable, ctx2.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	// other backends are not affected.
+	ctx3 := newContext(t, "fast.example.com")
+	assert.Equal("", bh.Handle(ctx3))
+
+	status := bh.Status().(*Status)
+	assert.Equal(int32(1), status.Bulkheads["slow.example.com"].Active)
+	assert.Equal(uint64(1), status.Bulkheads["slow.example.com"].Rejected)
+	assert.Equal(1.0, status.Bulkheads["slow.example.com"].Utilization)
+	assert.Equal(0.5, status.Bulkheads["fast.example.com"].Utilization)
+
+	// the queued request gets the slot after the first one finishes.
+	done := make(chan string)
+	go func() {
+		done <- bh.Handle(newContext(t, "slow.example.com"))
+	}()
+	time.Sleep(5 * time.Millisecond)
+	ctx1.Finish()
+	assert.Equal("", <-done)
+
+	// the slots held by requests are kept after the filter is updated.
+	newBh := kind.CreateInstance(bh.spec).(*Bulkhead)
+	newBh.Inherit(bh)
+	status = newBh.Status().(*Status)
+	assert.Equal(int32(1), status.Bulkheads["slow.example.com"].Active)
+	assert.Equal(int32(1), status.Bulkheads["fast.example.com"].Active)
+}
+
+func TestBulkheadQueueFull(t *testing.T) {
+	assert := assert.New(t)
+
+	const yamlConfig = `
+kind: Bulkhead
+name: bulkhead
+key: pool
+defaultPolicy:
+  maxConcurrency: 1
+`
+	bh := newTestBulkhead(t, yamlConfig)
+
+	assert.Equal("", bh.Handle(newContext(t, "a")))
+	start := time.Now()
+	assert.Equal(resultRejected, bh.Handle(newContext(t, "b")))
+	assert.Less(time.Since(start), defaultMaxWait)
+}
+
+func TestBulkheadMaxKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	bh := newTestBulkhead(t, `
+kind: Bulkhead
+name: bulkhead
+key: "{{.req.Host}}"
+maxKeys: 2
+`)
+	defer bh.Close()
+
+	for _, host := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		ctx := newContext(t, host)
+		assert.Equal("", bh.Handle(ctx))
+		ctx.Finish()
+	}
+
+	status := bh.Status().(*Status)
+	assert.Len(status.Bulkheads, 2)
+	assert.NotContains(status.Bulkheads, "a.example.com")
+}
+
+func TestBulkheadMaxKeysBusy(t *testing.T) {
+	assert := assert.New(t)
+
+	bh := newTestBulkhead(t, `
+kind: Bulkhead
+name: bulkhead
+key: "{{.req.Host}}"
+maxKeys: 1
+defaultPolicy:
+  maxConcurrency: 1
+`)
+	defer bh.Close()
+
+	ctx := newContext(t, "a.example.com")
+	assert.Equal("", bh.Handle(ctx))
+
+	// the bulkhead of a.example.com is busy, so it is not evicted.
+	ctxB := newContext(t, "b.example.com")
+	assert.Equal("", bh.Handle(ctxB))
+	ctxB.Finish()
+	assert.Contains(bh.Status().(*Status).Bulkheads, "a.example.com")
+
+	// the limit still holds.
+	assert.Equal(resultRejected, bh.Handle(newContext(t, "a.example.com")))
+
+	// the idle bulkhead is evicted once the limit is exceeded.
+	ctx.Finish()
+	ctxC := newContext(t, "c.example.com")
+	assert.Equal("", bh.Handle(ctxC))
+	ctxC.Finish()
+	status := bh.Status().(*Status)
+	assert.Len(status.Bulkheads, 1)
+	assert.Contains(status.Bulkheads, "c.example.com")
+}
+
+func TestBulkheadInheritPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	bh := newTestBulkhead(t, `
+kind: Bulkhead
+name: bulkhead
+key: pool
+defaultPolicy:
+  maxConcurrency: 2
+`)
+	ctx1 := newContext(t, "a")
+	assert.Equal("", bh.Handle(ctx1))
+	ctx2 := newContext(t, "b")
+	assert.Equal("", bh.Handle(ctx2))
+
+	newBh := newTestBulkhead(t, `
+kind: Bulkhead
+name: bulkhead
+key: pool
+defaultPolicy:
+  maxConcurrency: 1
+`)
+	newBh.Inherit(bh)
+
+	// two slots are still held, the new limit is 1.
+	assert.Equal(resultRejected, newBh.Handle(newContext(t, "c")))
+	ctx1.Finish()
+	assert.Equal(resultRejected, newBh.Handle(newContext(t, "c")))
+	ctx2.Finish()
+	ctx3 := newContext(t, "c")
+	assert.Equal("", newBh.Handle(ctx3))
+	ctx3.Finish()
+}
@@ -0,0 +1,338 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package cacheinvalidator implements a filter which purges the entries of
+// a cache store by the invalidation signals of backend responses.
+package cacheinvalidator
+
+import (
+	stdcontext "context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/cluster"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/proxies/httpproxy"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+const (
+	// Kind is the kind of CacheInvalidator.
+	Kind = "CacheInvalidator"
+
+	keyPlaceholder = "{key}"
+
+	defaultHeader    = "X-Cache-Invalidate"
+	defaultSeparator = ","
+
+	watchRetryDelay = 10 * time.Second
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "CacheInvalidator purges the entries of a cache store by the invalidation signals of backend responses.",
+	Results:     []string{},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{
+			Header:       defaultHeader,
+			Separator:    defaultSeparator,
+			PathTemplate: keyPlaceholder,
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &CacheInvalidator{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// CacheInvalidator is the filter CacheInvalidator.
+	CacheInvalidator struct {
+		spec *Spec
+
+		node   string
+		cls    cluster.Cluster
+		key    string
+		cancel stdcontext.CancelFunc
+
+		signals   uint64
+		purged    uint64
+		broadcast uint64
+		received  uint64
+	}
+
+	// Spec is the spec of CacheInvalidator.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Store is the name of the cache store, which is the store of
+		// the memory caches of Proxy pools.
+		Store string `json:"store" jsonschema:"required"`
+		// Header is the response header listing the keys to purge.
+		Header string `json:"header,omitempty"`
+		// Separator separates the keys in the header.
+		Separator string `json:"separator,omitempty"`
+		// PathTemplate maps a key to the path of the cache entries, "{key}"
+		// in it is replaced by the key, e.g. /api/users/{key}. Entries
+		// whose paths start with the path are purged if the path ends
+		// with '*'.
+		PathTemplate string `json:"pathTemplate,omitempty"`
+		// KeepHeader keeps the header in the response to the client.
+		KeepHeader bool `json:"keepHeader,omitempty"`
+	}
+
+	// Status is the status of CacheInvalidator.
+	Status struct {
+		// Signals is the number of responses with the header.
+		Signals uint64 `json:"signals"`
+		// Purged is the number of entries purged on this node.
+		Purged uint64 `json:"purged"`
+		// Broadcast is the number of purges sent to other nodes.
+		Broadcast uint64 `json:"broadcast"`
+		// Received is the number of purges received from other nodes.
+		Received uint64 `json:"received"`
+	}
+
+	// purge is the purge broadcast to all nodes of the cluster.
+	purge struct {
+		Node  string   `json:"node"`
+		Paths []string `json:"paths"`
+		// Time makes every purge a different value.
+		Time time.Time `json:"time"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if spec.PathTemplate != "" && !strings.Contains(spec.PathTemplate, keyPlaceholder) {
+		return fmt.Errorf("pathTemplate must contain %s", keyPlaceholder)
+	}
+	return nil
+}
+
+// Name returns the name of the CacheInvalidator filter instance.
+func (ci *CacheInvalidator) Name() string {
+	return ci.spec.Name()
+}
+
+// Kind returns the kind of CacheInvalidator.
+func (ci *CacheInvalidator) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the CacheInvalidator
+func (ci *CacheInvalidator) Spec() filters.Spec {
+	return ci.spec
+}
+
+// Init initializes CacheInvalidator.
+func (ci *CacheInvalidator) Init() {
+	ci.reload()
+}
+
+// Inherit inherits previous generation of CacheInvalidator.
+func (ci *CacheInvalidator) Inherit(previousGeneration filters.Filter) {
+	ci.reload()
+}
+
+func (ci *CacheInvalidator) reload() {
+	if ci.spec.Header == "" {
+		ci.spec.Header = defaultHeader
+	}
+	if ci.spec.Separator == "" {
+		ci.spec.Separator = defaultSeparator
+	}
+	if ci.spec.PathTemplate == "" {
+		ci.spec.PathTemplate = keyPlaceholder
+	}
+
+	super := ci.spec.Super()
+	if super == nil || super.Cluster() == nil {
+		return
+	}
+	ci.cls = super.Cluster()
+	ci.key = ci.cls.Layout().CachePurgeKey(ci.spec.Store)
+	if opt := super.Options(); opt != nil {
+		ci.node = opt.Name
+	}
+
+	var ctx stdcontext.Context
+	ctx, ci.cancel = stdcontext.WithCancel(stdcontext.Background())
+	go ci.watch(ctx)
+}
+
+// paths returns the paths of the keys in the header values.
+func (ci *CacheInvalidator) paths(values []string) []string {
+	var paths []string
+	for _, value := range values {
+		for _, key := range strings.Split(value, ci.spec.Separator) {
+			if key = strings.TrimSpace(key); key != "" {
+				paths = append(paths, strings.ReplaceAll(ci.spec.PathTemplate, keyPlaceholder, key))
+			}
+		}
+	}
+	return paths
+}
+
+// purgeLocal purges the entries of the paths from the caches of the store
+// on this node.
+func (ci *CacheInvalidator) purgeLocal(paths []string) int {
+	exact := map[string]bool{}
+	var prefixes []string
+	for _, p := range paths {
+		if strings.HasSuffix(p, "*") {
+			prefixes = append(prefixes, strings.TrimSuffix(p, "*"))
+		} else {
+			exact[p] = true
+		}
+	}
+
+	n := httpproxy.PurgeCacheStore(ci.spec.Store, func(path string) bool {
+		if exact[path] {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		}
+		return false
+	})
+	atomic.AddUint64(&ci.purged, uint64(n))
+	return n
+}
+
+// broadcastPurge sends the purge to the other nodes of the cluster.
+func (ci *CacheInvalidator) broadcastPurge(paths []string) {
+	p := &purge{Node: ci.node, Paths: paths, Time: time.Now()}
+	if err := ci.cls.Put(ci.key, string(codectool.MustMarshalJSON(p))); err != nil {
+		logger.Errorf("%s: failed to broadcast purge of store %s: %v", ci.Name(), ci.spec.Store, err)
+		return
+	}
+	atomic.AddUint64(&ci.broadcast, 1)
+}
+
+// watch receives the purges from the other nodes of the cluster.
+func (ci *CacheInvalidator) watch(ctx stdcontext.Context) {
+	for {
+		ci.watchOnce(ctx)
+
+		select {
+		case <-time.After(watchRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (ci *CacheInvalidator) watchOnce(ctx stdcontext.Context) {
+	watcher, err := ci.cls.Watcher()
+	if err != nil {
+		logger.Errorf("%s: failed to create watcher: %v", ci.Name(), err)
+		return
+	}
+	defer watcher.Close()
+
+	ch, err := watcher.Watch(ci.key)
+	if err != nil {
+		logger.Errorf("%s: failed to watch purges of store %s: %v", ci.Name(), ci.spec.Store, err)
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case value, ok := <-ch:
+			if !ok {
+				return
+			}
+			if value == nil {
+				continue
+			}
+
+			p := &purge{}
+			if err := codectool.UnmarshalJSON([]byte(*value), p); err != nil {
+				logger.Errorf("%s: invalid purge %s: %v", ci.Name(), *value, err)
+				continue
+			}
+			// the purge has been done by this node.
+			if p.Node == ci.node {
+				continue
+			}
+			atomic.AddUint64(&ci.received, 1)
+			ci.purgeLocal(p.Paths)
+		}
+	}
+}
+
+// Handle purges the cache entries listed in the response header.
+func (ci *CacheInvalidator) Handle(ctx *context.Context) string {
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		return ""
+	}
+
+	header := resp.HTTPHeader()
+	values := header.Values(ci.spec.Header)
+	if len(values) == 0 {
+		return ""
+	}
+	if !ci.spec.KeepHeader {
+		header.Del(ci.spec.Header)
+	}
+
+	paths := ci.paths(values)
+	if len(paths) == 0 {
+		return ""
+	}
+	atomic.AddUint64(&ci.signals, 1)
+
+	n := ci.purgeLocal(paths)
+	if ci.cls != nil {
+		ci.broadcastPurge(paths)
+	}
+	ctx.LazyAddTag(func() string {
+		return fmt.Sprintf("cacheInvalidator: %d entries purged", n)
+	})
+	return ""
+}
+
+// Status returns status.
+func (ci *CacheInvalidator) Status() interface{} {
+	return &Status{
+		Signals:   atomic.LoadUint64(&ci.signals),
+		Purged:    atomic.LoadUint64(&ci.purged),
+		Broadcast: atomic.LoadUint64(&ci.broadcast),
+		Received:  atomic.LoadUint64(&ci.received),
+	}
+}
+
+// Close closes CacheInvalidator.
+func (ci *CacheInvalidator) Close() {
+	if ci.cancel != nil {
+		ci.cancel()
+	}
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacheinvalidator
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/v2/pkg/cluster"
+	"github.com/megaease/easegress/v2/pkg/cluster/clustertest"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/proxies/httpproxy"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/option"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newCacheInvalidator(t *testing.T, super *supervisor.Supervisor, yamlConfig string) *CacheInvalidator {
+	rawSpec := make(map[string]interface{})
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(super, "pipeline", rawSpec)
+	assert.Nil(t, err)
+	ci := kind.CreateInstance(spec).(*CacheInvalidator)
+	ci.Init()
+	return ci
+}
+
+func newCache(store string) *httpproxy.MemoryCache {
+	return httpproxy.NewMemoryCache(&httpproxy.MemoryCacheSpec{
+		Expiration:    "1m",
+		MaxEntryBytes: 100,
+		Methods:       []string{http.MethodGet},
+		Codes:         []int{http.StatusOK},
+		Store:         store,
+	})
+}
+
+func cacheRequest(mc *httpproxy.MemoryCache, path string) *httpprot.Request {
+	stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1"+path, nil)
+	req, _ := httpprot.NewRequest(stdr)
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetPayload([]byte("data"))
+	mc.Store(req, resp)
+	return req
+}
+
+func handle(ci *CacheInvalidator, header string) http.Header {
+	ctx := context.New(nil)
+	resp, _ := httpprot.NewResponse(nil)
+	if header != "" {
+		resp.HTTPHeader().Set("X-Purge", header)
+	}
+	ctx.SetOutputResponse(resp)
+	ci.Handle(ctx)
+	return resp.HTTPHeader()
+}
+
+func TestCacheInvalidator(t *testing.T) {
+	assert := assert.New(t)
+
+	mc := newCache("users")
+	defer mc.Close()
+	req1 := cacheRequest(mc, "/api/users/1")
+	req2 := cacheRequest(mc, "/api/users/2")
+	req3 := cacheRequest(mc, "/api/users/3/orders/1")
+	req4 := cacheRequest(mc, "/api/users/4")
+
+	ci := newCacheInvalidator(t, nil, `
+kind: CacheInvalidator
+name: ci
+store: users
+header: X-Purge
+separator: ";"
+pathTemplate: /api/users/{key}
+`)
+	defer ci.Close()
+
+	// no signal.
+	handle(ci, "")
+	assert.NotNil(mc.Load(req1))
+
+	header := handle(ci, " 1 ; 3/* ;")
+	assert.Equal("", header.Get("X-Purge"))
+	assert.Nil(mc.Load(req1))
+	assert.NotNil(mc.Load(req2))
+	assert.Nil(mc.Load(req3))
+	assert.NotNil(mc.Load(req4))
+
+	// a context without a response.
+	ci.Handle(context.New(nil))
+
+	status := ci.Status().(*Status)
+	assert.Equal(uint64(1), status.Signals)
+	assert.Equal(uint64(2), status.Purged)
+	assert.Equal(uint64(0), status.Broadcast)
+
+	assert.Error((&Spec{PathTemplate: "/api/users"}).Validate())
+}
+
+func TestCacheInvalidatorCluster(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	var puts []string
+	cls := clustertest.NewMockedCluster()
+	cls.MockedPut = func(key, value string) error {
+		assert.Equal("/cache/purge/users", key)
+		mu.Lock()
+		puts = append(puts, value)
+		mu.Unlock()
+		return nil
+	}
+	ch := make(chan *string)
+	watcher := clustertest.NewMockedWatcher()
+	watcher.MockedWatch = func(key string) (<-chan *string, error) {
+		return ch, nil
+	}
+	cls.MockedWatcher = func() (cluster.Watcher, error) {
+		return watcher, nil
+	}
+	opt := option.New()
+	opt.Name = "node-1"
+	super := supervisor.NewMock(opt, cls, nil, nil, false, nil, nil)
+
+	mc := newCache("users")
+	defer mc.Close()
+	req1 := cacheRequest(mc, "/users/1")
+	req2 := cacheRequest(mc, "/users/2")
+
+	ci := newCacheInvalidator(t, super, `
+kind: CacheInvalidator
+name: ci
+store: users
+header: X-Purge
+keepHeader: true
+`)
+	defer ci.Close()
+
+	// the purge is broadcast.
+	header := handle(ci, "/users/1")
+	assert.Equal("/users/1", header.Get("X-Purge"))
+	assert.Nil(mc.Load(req1))
+	mu.Lock()
+	assert.Len(puts, 1)
+	p := &purge{}
+	codectool.MustUnmarshal([]byte(puts[0]), p)
+	mu.Unlock()
+	assert.Equal("node-1", p.Node)
+	assert.Equal([]string{"/users/1"}, p.Paths)
+
+	// the purge of this node is ignored.
+	value := string(codectool.MustMarshalJSON(&purge{Node: "node-1", Paths: []string{"/users/2"}}))
+	ch <- &value
+	// the purge of other nodes.
+	value2 := string(codectool.MustMarshalJSON(&purge{Node: "node-2", Paths: []string{"/users/2"}}))
+	ch <- &value2
+	assert.Eventually(func() bool { return mc.Load(req2) == nil }, time.Second, 10*time.Millisecond)
+
+	status := ci.Status().(*Status)
+	assert.Equal(uint64(1), status.Broadcast)
+	assert.Equal(uint64(1), status.Received)
+}
@@ -36,6 +36,7 @@ var kind = &filters.Kind{
 	Name:        Kind,
 	Description: "CertExtractor extracts given field from TLS certificates and sets it to request headers.",
 	Results:     []string{},
+	RequestOnly: true,
 	DefaultSpec: func() filters.Spec {
 		return &Spec{}
 	},
@@ -0,0 +1,249 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package charsetnormalizer implements a filter which transcodes request
+// bodies to UTF-8.
+package charsetnormalizer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of CharsetNormalizer.
+	Kind = "CharsetNormalizer"
+
+	resultInvalid     = "invalid"
+	resultUnsupported = "unsupported"
+
+	charsetUTF8 = "utf-8"
+)
+
+var kind = &filters.Kind{
+	Name:              Kind,
+	Description:       "CharsetNormalizer transcodes request bodies to UTF-8 and rejects invalid byte sequences.",
+	Results:           []string{resultInvalid, resultUnsupported},
+	RequestOnly:       true,
+	RequiresBuffering: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &CharsetNormalizer{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+// boms are the byte order marks and the charsets they indicate.
+var boms = []struct {
+	bom     []byte
+	charset string
+}{
+	{[]byte{0xEF, 0xBB, 0xBF}, charsetUTF8},
+	{[]byte{0xFE, 0xFF}, "utf-16be"},
+	{[]byte{0xFF, 0xFE}, "utf-16le"},
+}
+
+type (
+	// CharsetNormalizer is the filter CharsetNormalizer.
+	CharsetNormalizer struct {
+		spec *Spec
+	}
+
+	// Spec is the spec of CharsetNormalizer.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// DefaultCharset is the charset of textual bodies which have
+		// neither a charset parameter in Content-Type nor a BOM, it is
+		// UTF-8 if empty.
+		DefaultCharset string `json:"defaultCharset,omitempty"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if spec.DefaultCharset == "" {
+		return nil
+	}
+	if _, err := lookupEncoding(spec.DefaultCharset); err != nil {
+		return err
+	}
+	return nil
+}
+
+// lookupEncoding returns the encoding of the charset, the encoding is nil
+// for UTF-8.
+func lookupEncoding(charset string) (encoding.Encoding, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8":
+		return nil, nil
+	case "utf-16be":
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), nil
+	case "utf-16le":
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), nil
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %s", charset)
+	}
+	return enc, nil
+}
+
+// isText returns whether the media type is a textual one, whose charset is
+// the default charset if not specified.
+func isText(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
+
+// Name returns the name of the CharsetNormalizer filter instance.
+func (cn *CharsetNormalizer) Name() string {
+	return cn.spec.Name()
+}
+
+// Kind returns the kind of CharsetNormalizer.
+func (cn *CharsetNormalizer) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the CharsetNormalizer
+func (cn *CharsetNormalizer) Spec() filters.Spec {
+	return cn.spec
+}
+
+// Init initializes CharsetNormalizer.
+func (cn *CharsetNormalizer) Init() {
+}
+
+// Inherit inherits previous generation of CharsetNormalizer.
+func (cn *CharsetNormalizer) Inherit(previousGeneration filters.Filter) {
+	cn.Init()
+}
+
+func (cn *CharsetNormalizer) reject(ctx *context.Context, result string, statusCode int, reason string) string {
+	ctx.AddTag(fmt.Sprintf("charsetNormalizer: %s", reason))
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(statusCode)
+	ctx.SetOutputResponse(resp)
+	return result
+}
+
+// Handle transcodes the request body to UTF-8.
+func (cn *CharsetNormalizer) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	if req.IsStream() {
+		logger.Debugf("%s: cannot transcode stream body", cn.Name())
+		return ""
+	}
+
+	body := req.RawPayload()
+	if len(body) == 0 {
+		return ""
+	}
+
+	contentType := req.HTTPHeader().Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "", map[string]string{}
+	}
+
+	// binary bodies are left as they are, bodies without Content-Type are
+	// treated as textual ones only if they have a BOM.
+	charset := params["charset"]
+	textual := charset != "" || isText(mediaType)
+	if textual || mediaType == "" {
+		// the BOM has a higher priority than the Content-Type.
+		for _, b := range boms {
+			if bytes.HasPrefix(body, b.bom) {
+				charset, body, textual = b.charset, body[len(b.bom):], true
+				break
+			}
+		}
+	}
+	if !textual {
+		return ""
+	}
+	if charset == "" {
+		charset = cn.spec.DefaultCharset
+	}
+	if charset == "" {
+		charset = charsetUTF8
+	}
+
+	enc, err := lookupEncoding(charset)
+	if err != nil {
+		return cn.reject(ctx, resultUnsupported, http.StatusUnsupportedMediaType, err.Error())
+	}
+
+	if enc != nil {
+		// decoders replace invalid byte sequences with U+FFFD.
+		body, err = enc.NewDecoder().Bytes(body)
+		if err != nil || bytes.ContainsRune(body, utf8.RuneError) {
+			return cn.reject(ctx, resultInvalid, http.StatusBadRequest, "invalid "+charset+" body")
+		}
+	} else if !utf8.Valid(body) {
+		return cn.reject(ctx, resultInvalid, http.StatusBadRequest, "invalid utf-8 body")
+	}
+
+	req.SetPayload(body)
+	if mediaType != "" && (params["charset"] != "" || enc != nil) {
+		params["charset"] = charsetUTF8
+		req.HTTPHeader().Set("Content-Type", mime.FormatMediaType(mediaType, params))
+	}
+	return ""
+}
+
+// Status returns status.
+func (cn *CharsetNormalizer) Status() interface{} {
+	return nil
+}
+
+// Close closes CharsetNormalizer.
+func (cn *CharsetNormalizer) Close() {
+}
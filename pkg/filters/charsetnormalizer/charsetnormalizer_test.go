@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package charsetnormalizer
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestNormalizer(yamlConfig string) (*CharsetNormalizer, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	cn := kind.CreateInstance(spec).(*CharsetNormalizer)
+	cn.Init()
+	return cn, nil
+}
+
+func newContext(contentType string, body []byte) (*context.Context, *httpprot.Request) {
+	stdReq, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/", bytes.NewReader(body))
+	if contentType != "" {
+		stdReq.Header.Set("Content-Type", contentType)
+	}
+	req, _ := httpprot.NewRequest(stdReq)
+	req.FetchPayload(0)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, req
+}
+
+func TestCharsetNormalizer(t *testing.T) {
+	assert := assert.New(t)
+
+	cn, err := newTestNormalizer("kind: CharsetNormalizer\nname: cn\ndefaultCharset: windows-1252")
+	assert.Nil(err)
+	assert.Equal(kind, cn.Kind())
+	assert.Nil(cn.Status())
+
+	cases := []struct {
+		contentType     string
+		body            []byte
+		result          string
+		wantBody        string
+		wantContentType string
+	}{
+		// legacy charsets in Content-Type.
+		{"text/plain; charset=ISO-8859-1", []byte("caf\xe9"), "", "café", "text/plain; charset=utf-8"},
+		{"text/plain; charset=gbk", []byte("\xc4\xe3\xba\xc3"), "", "你好", "text/plain; charset=utf-8"},
+		{"text/plain; charset=shift_jis", []byte("\x82\xb1\x82\xf1"), "", "こん", "text/plain; charset=utf-8"},
+		// the BOM wins over the Content-Type.
+		{"application/json; charset=iso-8859-1", []byte("\xef\xbb\xbf\"é\""), "", `"é"`, "application/json; charset=utf-8"},
+		{"", []byte("\xff\xfeh\x00i\x00"), "", "hi", ""},
+		{"text/plain", []byte("\xfe\xff\x00h\x00i"), "", "hi", "text/plain; charset=utf-8"},
+		// the default charset of textual bodies.
+		{"text/plain", []byte("caf\xe9"), "", "café", "text/plain; charset=utf-8"},
+		// UTF-8 bodies are validated.
+		{"application/json; charset=UTF-8", []byte(`"é"`), "", `"é"`, "application/json; charset=utf-8"},
+		{"text/plain; charset=utf-8", []byte("caf\xe9"), resultInvalid, "", ""},
+		// malformed input.
+		{"text/plain; charset=shift_jis", []byte("\x82"), resultInvalid, "", ""},
+		{"text/plain; charset=utf-16le", []byte("\x00\xd8"), resultInvalid, "", ""},
+		// unknown charsets.
+		{"text/plain; charset=not-exist", []byte("abc"), resultUnsupported, "", ""},
+		// binary bodies are not touched.
+		{"application/octet-stream", []byte("\xfe\xff\xe9"), "", "\xfe\xff\xe9", "application/octet-stream"},
+		{"", []byte("caf\xe9"), "", "caf\xe9", ""},
+	}
+	for i, c := range cases {
+		ctx, req := newContext(c.contentType, c.body)
+		assert.Equal(c.result, cn.Handle(ctx), i)
+		if c.result != "" {
+			resp := ctx.GetOutputResponse().(*httpprot.Response)
+			assert.Contains([]int{http.StatusBadRequest, http.StatusUnsupportedMediaType}, resp.StatusCode(), i)
+			continue
+		}
+		assert.Equal(c.wantBody, string(req.RawPayload()), i)
+		assert.Equal(c.wantContentType, req.HTTPHeader().Get("Content-Type"), i)
+	}
+
+	// empty body.
+	ctx, _ := newContext("text/plain; charset=gbk", nil)
+	assert.Equal("", cn.Handle(ctx))
+
+	newCN := kind.CreateInstance(cn.spec).(*CharsetNormalizer)
+	newCN.Inherit(cn)
+	cn.Close()
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newTestNormalizer("kind: CharsetNormalizer\nname: cn\ndefaultCharset: not-exist")
+	assert.NotNil(err)
+	_, err = newTestNormalizer("kind: CharsetNormalizer\nname: cn\ndefaultCharset: utf-8")
+	assert.Nil(err)
+}
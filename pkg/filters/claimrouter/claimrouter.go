@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package claimrouter implements a filter which makes routing decisions by
+// the claims of the validated JWT.
+package claimrouter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/validator"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/jsonpath"
+	"github.com/megaease/easegress/v2/pkg/util/routeheader"
+)
+
+const (
+	// Kind is the kind of ClaimRouter.
+	Kind = "ClaimRouter"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "ClaimRouter sets the routing decision by a claim of the validated JWT.",
+	Results:     []string{},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{DataKey: validator.JWTClaimsDataKey}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &ClaimRouter{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// ClaimRouter is the filter ClaimRouter.
+	ClaimRouter struct {
+		spec   *Spec
+		path   jsonpath.Path
+		routes map[string]string
+	}
+
+	// Spec is the spec of ClaimRouter.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Claim is the JSONPath of the claim, e.g. $.plan.
+		Claim string `json:"claim" jsonschema:"required"`
+		// Header is the request header to carry the routing decision.
+		Header       routeheader.Header `json:"header" jsonschema:"required,minLength=1"`
+		Routes       []*Route           `json:"routes,omitempty"`
+		DefaultRoute string             `json:"defaultRoute,omitempty"`
+		// DataKey is the key of the claims in the context data, the
+		// claims are set by a prior filter, default is the key used by
+		// the Validator.
+		DataKey string `json:"dataKey,omitempty"`
+	}
+
+	// Route maps claim values to a route.
+	Route struct {
+		Name   string   `json:"name" jsonschema:"required"`
+		Values []string `json:"values" jsonschema:"required,minItems=1"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if _, err := jsonpath.Parse(spec.Claim); err != nil {
+		return err
+	}
+
+	values := map[string]bool{}
+	for _, r := range spec.Routes {
+		for _, v := range r.Values {
+			if values[v] {
+				return fmt.Errorf("duplicated value %s in routes", v)
+			}
+			values[v] = true
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the ClaimRouter filter instance.
+func (cr *ClaimRouter) Name() string {
+	return cr.spec.Name()
+}
+
+// Kind returns the kind of ClaimRouter.
+func (cr *ClaimRouter) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the ClaimRouter
+func (cr *ClaimRouter) Spec() filters.Spec {
+	return cr.spec
+}
+
+// Init initializes ClaimRouter.
+func (cr *ClaimRouter) Init() {
+	cr.reload()
+}
+
+// Inherit inherits previous generation of ClaimRouter.
+func (cr *ClaimRouter) Inherit(previousGeneration filters.Filter) {
+	cr.Init()
+}
+
+func (cr *ClaimRouter) reload() {
+	// the path has been verified in Validate, so no error here.
+	cr.path, _ = jsonpath.Parse(cr.spec.Claim)
+
+	cr.routes = map[string]string{}
+	for _, r := range cr.spec.Routes {
+		for _, v := range r.Values {
+			cr.routes[v] = r.Name
+		}
+	}
+
+	if cr.spec.DataKey == "" {
+		cr.spec.DataKey = validator.JWTClaimsDataKey
+	}
+}
+
+// toString converts a scalar claim value to a string.
+func toString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// route returns the routing decision of the claims. For an array claim,
+// like roles, the first route in the spec matching any of its elements is
+// selected.
+func (cr *ClaimRouter) route(claims map[string]interface{}) string {
+	if claims == nil {
+		return cr.spec.DefaultRoute
+	}
+
+	v, ok := cr.path.Lookup(claims)
+	if !ok {
+		return cr.spec.DefaultRoute
+	}
+
+	if a, ok := v.([]interface{}); ok {
+		values := map[string]bool{}
+		for _, e := range a {
+			if s, ok := toString(e); ok {
+				values[s] = true
+			}
+		}
+		for _, r := range cr.spec.Routes {
+			for _, rv := range r.Values {
+				if values[rv] {
+					return r.Name
+				}
+			}
+		}
+		return cr.spec.DefaultRoute
+	}
+
+	value, ok := toString(v)
+	if !ok {
+		return cr.spec.DefaultRoute
+	}
+
+	if len(cr.routes) == 0 {
+		return value
+	}
+	if route, ok := cr.routes[value]; ok {
+		return route
+	}
+	return cr.spec.DefaultRoute
+}
+
+// Handle sets the routing decision to the request header.
+func (cr *ClaimRouter) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	cr.spec.Header.Reset(req.HTTPHeader())
+
+	claims, _ := ctx.GetData(cr.spec.DataKey).(map[string]interface{})
+	if route := cr.route(claims); route != "" {
+		cr.spec.Header.Set(req.HTTPHeader(), route)
+		ctx.LazyAddTag(func() string {
+			return "claimRouter: " + route
+		})
+	}
+	return ""
+}
+
+// Status returns status.
+func (cr *ClaimRouter) Status() interface{} {
+	return nil
+}
+
+// Close closes ClaimRouter.
+func (cr *ClaimRouter) Close() {
+}
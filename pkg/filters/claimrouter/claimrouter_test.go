@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package claimrouter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/validator"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestClaimRouter(yamlConfig string) (*ClaimRouter, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	cr := kind.CreateInstance(spec).(*ClaimRouter)
+	cr.Init()
+	return cr, nil
+}
+
+func newContext(claims map[string]interface{}) (*context.Context, *httpprot.Request) {
+	stdReq := httptest.NewRequest(http.MethodGet, "http://localhost/orders", nil)
+	stdReq.Header.Set("X-Route", "forged")
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	if claims != nil {
+		ctx.SetData(validator.JWTClaimsDataKey, claims)
+	}
+	return ctx, req
+}
+
+func TestClaimRouter(t *testing.T) {
+	assert := assert.New(t)
+
+	cr, err := newTestClaimRouter(`
+kind: ClaimRouter
+name: router
+claim: $.plan
+header: X-Route
+defaultRoute: standard
+routes:
+- name: premium
+  values: ["gold", "platinum"]
+`)
+	assert.Nil(err)
+	assert.Equal(kind, cr.Kind())
+	assert.Equal("router", cr.Name())
+	assert.NotNil(cr.Spec())
+
+	cases := []struct {
+		claims map[string]interface{}
+		route  string
+	}{
+		{map[string]interface{}{"plan": "gold"}, "premium"},
+		{map[string]interface{}{"plan": "platinum"}, "premium"},
+		{map[string]interface{}{"plan": "free"}, "standard"},
+		{map[string]interface{}{"plan": map[string]interface{}{}}, "standard"},
+		{map[string]interface{}{"sub": "alice"}, "standard"},
+		{nil, "standard"},
+	}
+	for _, c := range cases {
+		ctx, req := newContext(c.claims)
+		assert.Equal("", cr.Handle(ctx))
+		assert.Equal(c.route, req.HTTPHeader().Get("X-Route"), c.claims)
+	}
+
+	cr.Inherit(cr)
+	assert.Nil(cr.Status())
+	cr.Close()
+}
+
+func TestArrayClaim(t *testing.T) {
+	assert := assert.New(t)
+
+	cr, err := newTestClaimRouter(`
+kind: ClaimRouter
+name: router
+claim: $['https://example.com/roles']
+header: X-Route
+routes:
+- name: admin
+  values: ["admin"]
+- name: beta
+  values: ["beta-tester", "employee"]
+`)
+	assert.Nil(err)
+
+	cases := []struct {
+		roles []interface{}
+		route string
+	}{
+		{[]interface{}{"employee", "admin"}, "admin"},
+		{[]interface{}{"user", "employee"}, "beta"},
+		{[]interface{}{"user"}, ""},
+		{[]interface{}{}, ""},
+	}
+	for _, c := range cases {
+		ctx, req := newContext(map[string]interface{}{"https://example.com/roles": c.roles})
+		cr.Handle(ctx)
+		assert.Equal(c.route, req.HTTPHeader().Get("X-Route"), c.roles)
+	}
+}
+
+func TestClaimValue(t *testing.T) {
+	assert := assert.New(t)
+
+	cr, err := newTestClaimRouter(`
+kind: ClaimRouter
+name: router
+claim: $.org.tier
+header: X-Route
+dataKey: CLAIMS
+`)
+	assert.Nil(err)
+
+	for _, c := range []struct {
+		tier  interface{}
+		route string
+	}{{"gold", "gold"}, {float64(2), "2"}, {true, "true"}, {nil, ""}} {
+		ctx, req := newContext(nil)
+		ctx.SetData("CLAIMS", map[string]interface{}{
+			"org": map[string]interface{}{"tier": c.tier},
+		})
+		cr.Handle(ctx)
+		assert.Equal(c.route, req.HTTPHeader().Get("X-Route"))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, yamlConfig := range []string{`
+kind: ClaimRouter
+name: router
+claim: plan
+header: X-Route
+`, `
+kind: ClaimRouter
+name: router
+claim: $.plan
+`, `
+kind: ClaimRouter
+name: router
+claim: $.plan
+header: X-Route
+routes:
+- name: a
+  values: ["x"]
+- name: b
+  values: ["x"]
+`} {
+		_, err := newTestClaimRouter(yamlConfig)
+		assert.NotNil(err, yamlConfig)
+	}
+}
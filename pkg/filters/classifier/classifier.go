@@ -0,0 +1,449 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package classifier implements a filter which classifies requests by rules
+// and attaches labels to them.
+package classifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/jsonpath"
+	"github.com/megaease/easegress/v2/pkg/util/prometheushelper"
+	"github.com/megaease/easegress/v2/pkg/util/routeheader"
+	"github.com/megaease/easegress/v2/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of Classifier.
+	Kind = "Classifier"
+
+	// DataKey is the key of the labels in the context data, the value
+	// is a []string.
+	DataKey = "LABELS"
+
+	modeFirst = "first"
+	modeAll   = "all"
+
+	defaultMaxBufferSize = 10 * 1024 * 1024
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "Classifier attaches labels to requests by ordered rules.",
+	Results:     []string{},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{Mode: modeFirst}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &Classifier{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// Classifier is the filter Classifier.
+	Classifier struct {
+		spec *Spec
+
+		mutex        sync.Mutex
+		labelCounts  map[string]uint64
+		unclassified uint64
+		classified   *prometheus.CounterVec
+	}
+
+	// Spec is the spec of Classifier.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Mode is first or all, the labels of the first matched rule are
+		// attached in first mode, while the labels of all matched rules
+		// are attached in all mode.
+		Mode  string  `json:"mode,omitempty" jsonschema:"enum=,enum=first,enum=all"`
+		Rules []*Rule `json:"rules" jsonschema:"required,minItems=1"`
+		// DefaultLabels are attached if no rule matches.
+		DefaultLabels []string `json:"defaultLabels,omitempty"`
+		// Header is the request header to carry the labels, proxy pools
+		// could select requests by it.
+		Header routeheader.Header `json:"header,omitempty"`
+		// MaxBufferSize is the max size of streamed bodies to buffer for
+		// the body matchers, larger ones never match, default is 10MiB.
+		MaxBufferSize int64 `json:"maxBufferSize,omitempty" jsonschema:"minimum=1"`
+	}
+
+	// Rule is a classification rule.
+	Rule struct {
+		Name   string     `json:"name,omitempty"`
+		Labels []string   `json:"labels" jsonschema:"required,minItems=1"`
+		Match  *MatchRule `json:"match,omitempty"`
+	}
+
+	// MatchRule is the conditions of a rule, all the conditions must be
+	// met for the rule to match, and an empty MatchRule matches all
+	// requests.
+	MatchRule struct {
+		Methods    []string `json:"methods,omitempty" jsonschema:"uniqueItems=true,format=httpmethod-array"`
+		Path       string   `json:"path,omitempty" jsonschema:"pattern=^/"`
+		PathPrefix string   `json:"pathPrefix,omitempty" jsonschema:"pattern=^/"`
+		PathRegexp string   `json:"pathRegexp,omitempty" jsonschema:"format=regexp"`
+		// Headers must all be matched.
+		Headers map[string]*stringtool.StringMatcher `json:"headers,omitempty"`
+		Body    *BodyMatcher                         `json:"body,omitempty"`
+
+		pathRe *regexp.Regexp
+	}
+
+	// BodyMatcher matches the request body. A stream body is buffered
+	// if it is not larger than MaxBufferSize, and never matches otherwise.
+	BodyMatcher struct {
+		// JSONPath is the path of a field in a JSON body, e.g. $.type.
+		JSONPath string `json:"jsonPath,omitempty"`
+		// Value matches the field at JSONPath, the field only needs to
+		// exist if it is not set.
+		Value *stringtool.StringMatcher `json:"value,omitempty"`
+		// RegEx matches the raw body.
+		RegEx string `json:"regex,omitempty" jsonschema:"format=regexp"`
+
+		path jsonpath.Path
+		re   *regexp.Regexp
+	}
+
+	// Status is the status of Classifier.
+	Status struct {
+		Labels       map[string]uint64 `json:"labels"`
+		Unclassified uint64            `json:"unclassified"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if spec.Mode != "" && spec.Mode != modeFirst && spec.Mode != modeAll {
+		return fmt.Errorf("invalid mode %s", spec.Mode)
+	}
+	for i, r := range spec.Rules {
+		if len(r.Labels) == 0 {
+			return fmt.Errorf("rule %d: labels are required", i)
+		}
+		for _, l := range r.Labels {
+			if l == "" {
+				return fmt.Errorf("rule %d: empty label", i)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate validates the body matcher.
+func (bm *BodyMatcher) Validate() error {
+	if bm.JSONPath == "" && bm.RegEx == "" {
+		return fmt.Errorf("one of jsonPath and regex is required")
+	}
+	if bm.JSONPath != "" {
+		if _, err := jsonpath.Parse(bm.JSONPath); err != nil {
+			return err
+		}
+	} else if bm.Value != nil {
+		return fmt.Errorf("value requires jsonPath")
+	}
+	return nil
+}
+
+// Name returns the name of the Classifier filter instance.
+func (c *Classifier) Name() string {
+	return c.spec.Name()
+}
+
+// Kind returns the kind of Classifier.
+func (c *Classifier) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the Classifier
+func (c *Classifier) Spec() filters.Spec {
+	return c.spec
+}
+
+// Init initializes Classifier.
+func (c *Classifier) Init() {
+	c.reload()
+}
+
+// Inherit inherits previous generation of Classifier.
+func (c *Classifier) Inherit(previousGeneration filters.Filter) {
+	c.Init()
+}
+
+func (c *Classifier) reload() {
+	if c.spec.Mode == "" {
+		c.spec.Mode = modeFirst
+	}
+	if c.spec.MaxBufferSize <= 0 {
+		c.spec.MaxBufferSize = defaultMaxBufferSize
+	}
+
+	for _, r := range c.spec.Rules {
+		m := r.Match
+		if m == nil {
+			continue
+		}
+		if m.PathRegexp != "" {
+			m.pathRe = regexp.MustCompile(m.PathRegexp)
+		}
+		for _, h := range m.Headers {
+			h.Init()
+		}
+		if b := m.Body; b != nil {
+			// the path has been verified in Validate, so no error here.
+			b.path, _ = jsonpath.Parse(b.JSONPath)
+			if b.Value != nil {
+				b.Value.Init()
+			}
+			if b.RegEx != "" {
+				b.re = regexp.MustCompile(b.RegEx)
+			}
+		}
+	}
+
+	c.labelCounts = map[string]uint64{}
+	c.classified = c.newClassifiedCounter()
+}
+
+func (c *Classifier) newClassifiedCounter() *prometheus.CounterVec {
+	labels := prometheus.Labels{
+		"filterName":   c.Name(),
+		"kind":         Kind,
+		"clusterName":  "",
+		"clusterRole":  "",
+		"instanceName": "",
+	}
+	if super := c.spec.Super(); super != nil && super.Options() != nil {
+		labels["clusterName"] = super.Options().ClusterName
+		labels["clusterRole"] = super.Options().ClusterRole
+		labels["instanceName"] = super.Options().Name
+	}
+	return prometheushelper.NewCounter("classifier_labels",
+		"the total count of requests attached with a label",
+		[]string{"clusterName", "clusterRole", "instanceName", "filterName", "kind", "label"},
+	).MustCurryWith(labels)
+}
+
+// request wraps the request to buffer and decode the body at most once.
+type request struct {
+	*httpprot.Request
+	maxBufferSize int64
+	buffered      bool
+	decoded       bool
+	body          interface{}
+}
+
+// inMemory reads a stream body into memory if it is not larger than
+// maxBufferSize, it returns whether the body is in memory. The body is
+// only buffered when a body matcher needs it.
+func (r *request) inMemory() bool {
+	// a stream which has been partially read can't be buffered.
+	if !r.buffered && r.IsStream() && r.PayloadSize() == 0 {
+		r.buffered = true
+		if err := r.BufferPayload(r.maxBufferSize); err != nil {
+			return false
+		}
+	}
+	return !r.IsStream()
+}
+
+func (r *request) jsonBody() interface{} {
+	if !r.decoded {
+		r.decoded = true
+		if json.Unmarshal(r.RawPayload(), &r.body) != nil {
+			r.body = nil
+		}
+	}
+	return r.body
+}
+
+// toString converts a scalar JSON value to a string.
+func toString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+func (bm *BodyMatcher) match(req *request) bool {
+	if !req.inMemory() {
+		return false
+	}
+
+	if bm.re != nil && !bm.re.Match(req.RawPayload()) {
+		return false
+	}
+
+	if bm.path == nil {
+		return true
+	}
+	v, ok := bm.path.Lookup(req.jsonBody())
+	if !ok {
+		return false
+	}
+	if bm.Value == nil {
+		return true
+	}
+	s, ok := toString(v)
+	return ok && bm.Value.Match(s)
+}
+
+func (m *MatchRule) match(req *request) bool {
+	if len(m.Methods) > 0 && !stringtool.StrInSlice(req.Method(), m.Methods) {
+		return false
+	}
+
+	path := req.Path()
+	if m.Path != "" && m.Path != path {
+		return false
+	}
+	if m.PathPrefix != "" && !strings.HasPrefix(path, m.PathPrefix) {
+		return false
+	}
+	if m.pathRe != nil && !m.pathRe.MatchString(path) {
+		return false
+	}
+
+	header := req.HTTPHeader()
+	for key, sm := range m.Headers {
+		values := header.Values(key)
+		if len(values) == 0 {
+			if !sm.Empty {
+				return false
+			}
+			continue
+		}
+		if !sm.MatchAny(values) {
+			return false
+		}
+	}
+
+	if m.Body != nil && !m.Body.match(req) {
+		return false
+	}
+	return true
+}
+
+// classify returns the labels of the request, the labels are unique and
+// in the order of the rules.
+func (c *Classifier) classify(req *request) []string {
+	var labels []string
+	seen := map[string]bool{}
+
+	for _, r := range c.spec.Rules {
+		if r.Match != nil && !r.Match.match(req) {
+			continue
+		}
+		for _, l := range r.Labels {
+			if !seen[l] {
+				seen[l] = true
+				labels = append(labels, l)
+			}
+		}
+		if c.spec.Mode == modeFirst {
+			break
+		}
+	}
+	return labels
+}
+
+// Handle classifies the request and attaches the labels to the context.
+func (c *Classifier) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	c.spec.Header.Reset(req.HTTPHeader())
+
+	labels := c.classify(&request{Request: req, maxBufferSize: c.spec.MaxBufferSize})
+	if len(labels) == 0 {
+		c.mutex.Lock()
+		c.unclassified++
+		c.mutex.Unlock()
+		labels = c.spec.DefaultLabels
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+
+	c.mutex.Lock()
+	for _, l := range labels {
+		c.labelCounts[l]++
+	}
+	c.mutex.Unlock()
+	for _, l := range labels {
+		c.classified.WithLabelValues(l).Inc()
+	}
+
+	// labels attached by a prior Classifier are kept.
+	if prev, ok := ctx.GetData(DataKey).([]string); ok {
+		merged := append([]string{}, prev...)
+		for _, l := range labels {
+			if !stringtool.StrInSlice(l, merged) {
+				merged = append(merged, l)
+			}
+		}
+		labels = merged
+	}
+	ctx.SetData(DataKey, labels)
+
+	joined := strings.Join(labels, ",")
+	c.spec.Header.Set(req.HTTPHeader(), joined)
+	ctx.LazyAddTag(func() string {
+		return "classifier: " + joined
+	})
+	return ""
+}
+
+// Status returns status.
+func (c *Classifier) Status() interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s := &Status{
+		Labels:       make(map[string]uint64, len(c.labelCounts)),
+		Unclassified: c.unclassified,
+	}
+	for k, v := range c.labelCounts {
+		s.Labels[k] = v
+	}
+	return s
+}
+
+// Close closes Classifier.
+func (c *Classifier) Close() {
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package classifier
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestClassifier(yamlConfig string) (*Classifier, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	c := kind.CreateInstance(spec).(*Classifier)
+	c.Init()
+	return c, nil
+}
+
+func newContext(method, path, body string, header map[string]string) (*context.Context, *httpprot.Request) {
+	stdReq := httptest.NewRequest(method, "http://localhost"+path, strings.NewReader(body))
+	for k, v := range header {
+		stdReq.Header.Set(k, v)
+	}
+	req, _ := httpprot.NewRequest(stdReq)
+	req.FetchPayload(1024)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, req
+}
+
+const rules = `
+rules:
+- name: mobile
+  labels: ["mobile"]
+  match:
+    headers:
+      User-Agent:
+        regex: "(?i)android|iphone"
+- name: bulk
+  labels: ["bulk", "heavy"]
+  match:
+    methods: ["POST"]
+    pathPrefix: /orders
+    body:
+      jsonPath: $.items[0]
+- name: vip
+  labels: ["vip"]
+  match:
+    body:
+      jsonPath: $.customer.tier
+      value:
+        exact: gold
+- name: reports
+  labels: ["heavy"]
+  match:
+    pathRegexp: ^/reports/
+`
+
+func TestClassifier(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := newTestClassifier(`
+kind: Classifier
+name: classifier
+header: X-Labels
+defaultLabels: ["standard"]
+` + rules)
+	assert.Nil(err)
+	assert.Equal(kind, c.Kind())
+	assert.Equal("classifier", c.Name())
+	assert.NotNil(c.Spec())
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		header map[string]string
+		labels []string
+	}{
+		{http.MethodGet, "/orders", "", map[string]string{"User-Agent": "Mozilla (iPhone)"}, []string{"mobile"}},
+		{http.MethodPost, "/orders", `{"items": [1], "customer": {"tier": "gold"}}`, nil, []string{"bulk", "heavy"}},
+		{http.MethodPost, "/orders", `{"items": [], "customer": {"tier": "gold"}}`, nil, []string{"vip"}},
+		{http.MethodGet, "/reports/daily", "", nil, []string{"heavy"}},
+		{http.MethodGet, "/users", "", map[string]string{"X-Labels": "vip"}, []string{"standard"}},
+	}
+
+	for _, tc := range cases {
+		ctx, req := newContext(tc.method, tc.path, tc.body, tc.header)
+		assert.Equal("", c.Handle(ctx))
+		assert.Equal(tc.labels, ctx.GetData(DataKey), tc.path)
+		assert.Equal(strings.Join(tc.labels, ","), req.HTTPHeader().Get("X-Labels"))
+		assert.Contains(ctx.Tags(), "classifier: "+strings.Join(tc.labels, ","))
+	}
+
+	status := c.Status().(*Status)
+	assert.Equal(uint64(1), status.Unclassified)
+	assert.Equal(uint64(2), status.Labels["heavy"])
+	assert.Equal(uint64(1), status.Labels["standard"])
+	c.Close()
+}
+
+func TestClassifierAllMode(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := newTestClassifier(`
+kind: Classifier
+name: classifier
+mode: all
+` + rules)
+	assert.Nil(err)
+
+	ctx, req := newContext(http.MethodPost, "/orders", `{"items": [1], "customer": {"tier": "gold"}}`,
+		map[string]string{"User-Agent": "Android"})
+	ctx.SetData(DataKey, []string{"internal", "vip"})
+	c.Handle(ctx)
+	assert.Equal([]string{"internal", "vip", "mobile", "bulk", "heavy"}, ctx.GetData(DataKey))
+	assert.Equal("", req.HTTPHeader().Get("X-Labels"))
+
+	// no rule matches and no default labels.
+	ctx, _ = newContext(http.MethodGet, "/users", "", nil)
+	c.Handle(ctx)
+	assert.Nil(ctx.GetData(DataKey))
+	assert.Equal("", ctx.Tags())
+}
+
+func TestClassifierStream(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := newTestClassifier(`
+kind: Classifier
+name: classifier
+maxBufferSize: 64
+` + rules)
+	assert.Nil(err)
+
+	newStreamContext := func(body string) (*context.Context, *httpprot.Request) {
+		stdReq := httptest.NewRequest(http.MethodPost, "http://localhost/orders", strings.NewReader(body))
+		stdReq.ContentLength = -1
+		req, _ := httpprot.NewRequest(stdReq)
+		req.FetchPayload(-1)
+		ctx := context.New(nil)
+		ctx.SetInputRequest(req)
+		return ctx, req
+	}
+
+	// the stream body is buffered for the body matchers.
+	ctx, req := newStreamContext(`{"items": [1]}`)
+	c.Handle(ctx)
+	assert.Equal([]string{"bulk", "heavy"}, ctx.GetData(DataKey))
+	assert.False(req.IsStream())
+
+	// a body larger than maxBufferSize is kept as a stream and never
+	// matches.
+	body := `{"items": [1], "padding": "` + strings.Repeat("x", 64) + `"}`
+	ctx, req = newStreamContext(body)
+	c.Handle(ctx)
+	assert.Nil(ctx.GetData(DataKey))
+	assert.True(req.IsStream())
+	data, _ := io.ReadAll(req.GetPayload())
+	assert.Equal(body, string(data))
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	invalid := []string{`
+kind: Classifier
+name: classifier
+mode: any
+rules:
+- labels: ["a"]
+`, `
+kind: Classifier
+name: classifier
+rules:
+- labels: [""]
+`, `
+kind: Classifier
+name: classifier
+rules:
+- labels: ["a"]
+  match:
+    body:
+      jsonPath: items
+`, `
+kind: Classifier
+name: classifier
+rules:
+- labels: ["a"]
+  match:
+    body:
+      value:
+        exact: a
+`}
+	for _, s := range invalid {
+		_, err := newTestClassifier(s)
+		assert.NotNil(err, s)
+	}
+}
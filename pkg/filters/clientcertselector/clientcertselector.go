@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package clientcertselector implements a filter which selects the client
+// certificate presented to backends per request.
+package clientcertselector
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/builder"
+	"github.com/megaease/easegress/v2/pkg/filters/proxies/httpproxy"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of ClientCertSelector.
+	Kind = "ClientCertSelector"
+
+	resultUnknownSelector = "unknownSelector"
+
+	// OnUnknownDefault uses the default certificate for unknown selectors.
+	OnUnknownDefault = "default"
+	// OnUnknownFail rejects requests of unknown selectors.
+	OnUnknownFail = "fail"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "ClientCertSelector selects the client certificate presented to backends by the Proxy per request.",
+	Results:     []string{resultUnknownSelector},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{OnUnknown: OnUnknownDefault}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &ClientCertSelector{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// ClientCertSelector is the filter ClientCertSelector.
+	ClientCertSelector struct {
+		spec *Spec
+
+		selector    *builder.Template
+		certs       map[string]*httpproxy.ClientCert
+		defaultCert *httpproxy.ClientCert
+	}
+
+	// Spec is the spec of ClientCertSelector.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Selector is a template rendering the selector of the request,
+		// e.g. '{{.data.TENANT_ID}}'.
+		Selector string  `json:"selector" jsonschema:"required"`
+		Certs    []*Cert `json:"certs" jsonschema:"required,minItems=1"`
+		// DefaultCert is the name of the certificate for unknown
+		// selectors, the certificate in the mtls of the Proxy is used
+		// if it is empty.
+		DefaultCert string `json:"defaultCert,omitempty"`
+		OnUnknown   string `json:"onUnknown,omitempty" jsonschema:"enum=default,enum=fail"`
+	}
+
+	// Cert is a client certificate, it is referenced by name, so that it
+	// could be loaded from other sources in the future.
+	Cert struct {
+		Name       string `json:"name" jsonschema:"required"`
+		CertBase64 string `json:"certBase64" jsonschema:"required,format=base64"`
+		KeyBase64  string `json:"keyBase64" jsonschema:"required,format=base64"`
+		// Selectors are the selectors of the certificate, default is the
+		// name of the certificate.
+		Selectors []string `json:"selectors,omitempty"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if _, err := builder.NewTemplate(spec.Selector); err != nil {
+		return fmt.Errorf("invalid selector: %v", err)
+	}
+
+	names := map[string]bool{}
+	selectors := map[string]bool{}
+	for _, c := range spec.Certs {
+		if names[c.Name] {
+			return fmt.Errorf("duplicated cert %s", c.Name)
+		}
+		names[c.Name] = true
+
+		for _, s := range c.selectors() {
+			if selectors[s] {
+				return fmt.Errorf("duplicated selector %s", s)
+			}
+			selectors[s] = true
+		}
+
+		if _, err := c.clientCert(); err != nil {
+			return fmt.Errorf("cert %s: %v", c.Name, err)
+		}
+	}
+
+	if spec.DefaultCert != "" && !names[spec.DefaultCert] {
+		return fmt.Errorf("default cert %s not found", spec.DefaultCert)
+	}
+	return nil
+}
+
+func (c *Cert) selectors() []string {
+	if len(c.Selectors) == 0 {
+		return []string{c.Name}
+	}
+	return c.Selectors
+}
+
+func (c *Cert) clientCert() (*httpproxy.ClientCert, error) {
+	certPEM, _ := base64.StdEncoding.DecodeString(c.CertBase64)
+	keyPEM, _ := base64.StdEncoding.DecodeString(c.KeyBase64)
+	return httpproxy.NewClientCert(c.Name, certPEM, keyPEM)
+}
+
+// Name returns the name of the ClientCertSelector filter instance.
+func (ccs *ClientCertSelector) Name() string {
+	return ccs.spec.Name()
+}
+
+// Kind returns the kind of ClientCertSelector.
+func (ccs *ClientCertSelector) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the ClientCertSelector
+func (ccs *ClientCertSelector) Spec() filters.Spec {
+	return ccs.spec
+}
+
+// Init initializes ClientCertSelector.
+func (ccs *ClientCertSelector) Init() {
+	ccs.reload()
+}
+
+// Inherit inherits previous generation of ClientCertSelector.
+func (ccs *ClientCertSelector) Inherit(previousGeneration filters.Filter) {
+	ccs.Init()
+}
+
+func (ccs *ClientCertSelector) reload() {
+	ccs.selector = builder.MustNewTemplate(ccs.spec.Selector)
+
+	ccs.certs = map[string]*httpproxy.ClientCert{}
+	for _, c := range ccs.spec.Certs {
+		// certs are validated, so there's no error.
+		cc, _ := c.clientCert()
+		for _, s := range c.selectors() {
+			ccs.certs[s] = cc
+		}
+		if c.Name == ccs.spec.DefaultCert {
+			ccs.defaultCert = cc
+		}
+	}
+}
+
+// Handle selects the client certificate of the request.
+func (ccs *ClientCertSelector) Handle(ctx *context.Context) string {
+	selector, err := ccs.selector.Render(ctx)
+	if err != nil {
+		logger.Debugf("%s: failed to render selector: %v", ccs.Name(), err)
+	}
+
+	cc := ccs.certs[selector]
+	if cc == nil {
+		if ccs.spec.OnUnknown == OnUnknownFail {
+			return ccs.reject(ctx, selector)
+		}
+		cc = ccs.defaultCert
+	}
+	if cc == nil {
+		return ""
+	}
+
+	ctx.SetData(httpproxy.ClientCertDataKey, cc)
+	ctx.LazyAddTag(func() string {
+		return "clientCertSelector: " + cc.Name
+	})
+	return ""
+}
+
+func (ccs *ClientCertSelector) reject(ctx *context.Context, selector string) string {
+	ctx.AddTag(fmt.Sprintf("clientCertSelector: unknown selector %q", selector))
+
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(http.StatusForbidden)
+	ctx.SetOutputResponse(resp)
+	return resultUnknownSelector
+}
+
+// Status returns status.
+func (ccs *ClientCertSelector) Status() interface{} {
+	return nil
+}
+
+// Close closes ClientCertSelector.
+func (ccs *ClientCertSelector) Close() {
+}
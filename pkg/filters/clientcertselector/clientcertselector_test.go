@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clientcertselector
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/proxies/httpproxy"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func genCert(t *testing.T, cn string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return base64.StdEncoding.EncodeToString(certPEM), base64.StdEncoding.EncodeToString(keyPEM)
+}
+
+func newSpec(t *testing.T, yamlConfig string) (*Spec, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	return spec.(*Spec), nil
+}
+
+func certsYAML(t *testing.T) string {
+	cert1, key1 := genCert(t, "tenant1")
+	cert2, key2 := genCert(t, "tenant2")
+	return fmt.Sprintf(`
+certs:
+- name: tenant1
+  certBase64: %s
+  keyBase64: %s
+- name: tenant2
+  certBase64: %s
+  keyBase64: %s
+  selectors: [tenant2, tenant3]
+`, cert1, key1, cert2, key2)
+}
+
+func newContext(t *testing.T, tenant string) *context.Context {
+	ctx := context.New(nil)
+	stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	stdr.Header.Set("X-Tenant", tenant)
+	req, err := httpprot.NewRequest(stdr)
+	assert.Nil(t, err)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func selected(ctx *context.Context) string {
+	cc, _ := ctx.GetData(httpproxy.ClientCertDataKey).(*httpproxy.ClientCert)
+	if cc == nil {
+		return ""
+	}
+	return cc.Name
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+	certs := certsYAML(t)
+
+	_, err := newSpec(t, `
+kind: ClientCertSelector
+name: ccs
+selector: '{{index .req.Header "X-Tenant" 0}}'
+`+certs)
+	assert.Nil(err)
+
+	_, err = newSpec(t, `
+kind: ClientCertSelector
+name: ccs
+selector: '{{index .req.Header'
+`+certs)
+	assert.NotNil(err)
+
+	_, err = newSpec(t, `
+kind: ClientCertSelector
+name: ccs
+selector: '{{index .req.Header "X-Tenant" 0}}'
+defaultCert: unknown
+`+certs)
+	assert.NotNil(err)
+
+	_, err = newSpec(t, `
+kind: ClientCertSelector
+name: ccs
+selector: '{{index .req.Header "X-Tenant" 0}}'
+certs:
+- name: bad
+  certBase64: YWJj
+  keyBase64: YWJj
+`)
+	assert.NotNil(err)
+
+	cert, key := genCert(t, "dup")
+	_, err = newSpec(t, fmt.Sprintf(`
+kind: ClientCertSelector
+name: ccs
+selector: '{{index .req.Header "X-Tenant" 0}}'
+certs:
+- name: a
+  certBase64: %[1]s
+  keyBase64: %[2]s
+- name: b
+  certBase64: %[1]s
+  keyBase64: %[2]s
+  selectors: [a]
+`, cert, key))
+	assert.NotNil(err)
+}
+
+func TestHandle(t *testing.T) {
+	assert := assert.New(t)
+	certs := certsYAML(t)
+
+	spec, err := newSpec(t, `
+kind: ClientCertSelector
+name: ccs
+selector: '{{index .req.Header "X-Tenant" 0}}'
+defaultCert: tenant1
+`+certs)
+	assert.Nil(err)
+	ccs := kind.CreateInstance(spec).(*ClientCertSelector)
+	ccs.Init()
+	defer ccs.Close()
+
+	ctx := newContext(t, "tenant1")
+	assert.Equal("", ccs.Handle(ctx))
+	assert.Equal("tenant1", selected(ctx))
+
+	ctx = newContext(t, "tenant3")
+	assert.Equal("", ccs.Handle(ctx))
+	assert.Equal("tenant2", selected(ctx))
+
+	ctx = newContext(t, "unknown")
+	assert.Equal("", ccs.Handle(ctx))
+	assert.Equal("tenant1", selected(ctx))
+
+	// no default cert
+	spec, err = newSpec(t, `
+kind: ClientCertSelector
+name: ccs
+selector: '{{index .req.Header "X-Tenant" 0}}'
+`+certs)
+	assert.Nil(err)
+	newCCS := kind.CreateInstance(spec).(*ClientCertSelector)
+	newCCS.Inherit(ccs)
+
+	ctx = newContext(t, "unknown")
+	assert.Equal("", newCCS.Handle(ctx))
+	assert.Equal("", selected(ctx))
+
+	// fail on unknown selectors
+	spec, err = newSpec(t, `
+kind: ClientCertSelector
+name: ccs
+selector: '{{index .req.Header "X-Tenant" 0}}'
+onUnknown: fail
+`+certs)
+	assert.Nil(err)
+	ccs = kind.CreateInstance(spec).(*ClientCertSelector)
+	ccs.Init()
+
+	ctx = newContext(t, "unknown")
+	assert.Equal(resultUnknownSelector, ccs.Handle(ctx))
+	assert.Equal(http.StatusForbidden, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	ctx = newContext(t, "tenant2")
+	assert.Equal("", ccs.Handle(ctx))
+	assert.Equal("tenant2", selected(ctx))
+}
@@ -0,0 +1,443 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package cloudsigner implements a filter which signs requests to the APIs
+// of cloud providers, with the credentials of the gateway.
+package cloudsigner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/oauth2client"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/signer"
+)
+
+const (
+	// Kind is the kind of CloudSigner.
+	Kind = "CloudSigner"
+
+	// ProviderAWS signs requests with AWS Signature Version 4.
+	ProviderAWS = "aws"
+	// ProviderGCP signs requests with the HMAC keys of Google Cloud
+	// Storage, which is compatible with AWS Signature Version 4.
+	ProviderGCP = "gcp"
+
+	defaultMaxBufferSize = 10 * 1024 * 1024
+	unsignedPayload      = "UNSIGNED-PAYLOAD"
+
+	resultSignFailed = "signFailed"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "CloudSigner signs requests to the APIs of cloud providers.",
+	Results:     []string{resultSignFailed},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{Provider: ProviderAWS}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &CloudSigner{spec: spec.(*Spec)}
+	},
+}
+
+// now is replaced in tests.
+var now = time.Now
+
+// providers are the literals of the signatures of the providers.
+var providers = map[string]*provider{
+	ProviderAWS: {
+		literal: &signer.Literal{
+			ScopeSuffix:      "aws4_request",
+			AlgorithmName:    "X-Amz-Algorithm",
+			AlgorithmValue:   "AWS4-HMAC-SHA256",
+			SignedHeaders:    "X-Amz-SignedHeaders",
+			Signature:        "X-Amz-Signature",
+			Date:             "X-Amz-Date",
+			Expires:          "X-Amz-Expires",
+			Credential:       "X-Amz-Credential",
+			ContentSHA256:    "X-Amz-Content-Sha256",
+			SigningKeyPrefix: "AWS4",
+		},
+		headerPrefix:  "X-Amz-",
+		securityToken: "X-Amz-Security-Token",
+	},
+	ProviderGCP: {
+		literal: &signer.Literal{
+			ScopeSuffix:      "goog4_request",
+			AlgorithmName:    "X-Goog-Algorithm",
+			AlgorithmValue:   "GOOG4-HMAC-SHA256",
+			SignedHeaders:    "X-Goog-SignedHeaders",
+			Signature:        "X-Goog-Signature",
+			Date:             "X-Goog-Date",
+			Expires:          "X-Goog-Expires",
+			Credential:       "X-Goog-Credential",
+			ContentSHA256:    "X-Goog-Content-Sha256",
+			SigningKeyPrefix: "GOOG4",
+		},
+		headerPrefix: "X-Goog-",
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// CloudSigner is the filter CloudSigner.
+	CloudSigner struct {
+		spec          *Spec
+		provider      *provider
+		host          string
+		signedHeaders []string
+		maxBufferSize int64
+		// contentHashHeader is whether the service requires the hash of
+		// the payload in a header.
+		contentHashHeader bool
+
+		mutex sync.Mutex
+		creds *credentials
+
+		signed   uint64
+		unsigned uint64
+		failures uint64
+	}
+
+	// Spec is the spec of CloudSigner.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		Provider string `json:"provider,omitempty" jsonschema:"enum=aws,enum=gcp"`
+		Region   string `json:"region" jsonschema:"required"`
+		Service  string `json:"service" jsonschema:"required"`
+		// Host is the host of the backend, which is signed, so it must be
+		// the host the backend sees. The default is the endpoint of the
+		// service in the region.
+		Host string `json:"host,omitempty"`
+
+		// The credentials could be set directly or referenced, exactly one
+		// of a field and its reference must be set, except the optional
+		// session token.
+		AccessKeyID        string                  `json:"accessKeyId,omitempty"`
+		AccessKeyIDRef     *oauth2client.SecretRef `json:"accessKeyIdRef,omitempty"`
+		SecretAccessKey    string                  `json:"secretAccessKey,omitempty"`
+		SecretAccessKeyRef *oauth2client.SecretRef `json:"secretAccessKeyRef,omitempty"`
+		SessionToken       string                  `json:"sessionToken,omitempty"`
+		SessionTokenRef    *oauth2client.SecretRef `json:"sessionTokenRef,omitempty"`
+
+		// SignedHeaders are the headers to sign in addition to the host,
+		// Content-Type, Content-MD5 and the headers of the provider, like
+		// X-Amz-*.
+		SignedHeaders []string `json:"signedHeaders,omitempty"`
+		// UnsignedPayload signs all requests with UNSIGNED-PAYLOAD instead
+		// of the hash of the body, it is supported by S3 compatible services
+		// only.
+		UnsignedPayload bool `json:"unsignedPayload,omitempty"`
+		// MaxBufferSize is the max size of streamed bodies to buffer for
+		// hashing, larger ones are signed with UNSIGNED-PAYLOAD, default
+		// is 10MiB.
+		MaxBufferSize int64 `json:"maxBufferSize,omitempty" jsonschema:"minimum=1"`
+	}
+
+	// Status is the status of CloudSigner.
+	Status struct {
+		Signed   uint64 `json:"signed"`
+		Unsigned uint64 `json:"unsigned"`
+		Failures uint64 `json:"failures"`
+	}
+
+	provider struct {
+		literal       *signer.Literal
+		headerPrefix  string
+		securityToken string
+	}
+
+	credentials struct {
+		signer       *signer.Signer
+		sessionToken string
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	p := providers[spec.Provider]
+	if p == nil {
+		return fmt.Errorf("unknown provider %q", spec.Provider)
+	}
+
+	if (spec.AccessKeyID == "") == (spec.AccessKeyIDRef == nil) {
+		return fmt.Errorf("exactly one of accessKeyId and accessKeyIdRef must be set")
+	}
+	if (spec.SecretAccessKey == "") == (spec.SecretAccessKeyRef == nil) {
+		return fmt.Errorf("exactly one of secretAccessKey and secretAccessKeyRef must be set")
+	}
+	if spec.SessionToken != "" && spec.SessionTokenRef != nil {
+		return fmt.Errorf("sessionToken and sessionTokenRef cannot be set at the same time")
+	}
+	if p.securityToken == "" && (spec.SessionToken != "" || spec.SessionTokenRef != nil) {
+		return fmt.Errorf("provider %s doesn't support session token", spec.Provider)
+	}
+
+	for _, h := range spec.SignedHeaders {
+		if strings.TrimSpace(h) == "" {
+			return fmt.Errorf("empty signed header")
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the CloudSigner filter instance.
+func (cs *CloudSigner) Name() string {
+	return cs.spec.Name()
+}
+
+// Kind returns the kind of CloudSigner.
+func (cs *CloudSigner) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the CloudSigner
+func (cs *CloudSigner) Spec() filters.Spec {
+	return cs.spec
+}
+
+// Init initializes CloudSigner.
+func (cs *CloudSigner) Init() {
+	cs.reload()
+}
+
+// Inherit inherits previous generation of CloudSigner.
+func (cs *CloudSigner) Inherit(previousGeneration filters.Filter) {
+	cs.Init()
+}
+
+func (cs *CloudSigner) reload() {
+	spec := cs.spec
+	cs.provider = providers[spec.Provider]
+
+	cs.host = spec.Host
+	if cs.host == "" {
+		cs.host = defaultHost(spec.Provider, spec.Region, spec.Service)
+	}
+
+	cs.signedHeaders = []string{"Content-Type", "Content-Md5"}
+	for _, h := range spec.SignedHeaders {
+		cs.signedHeaders = append(cs.signedHeaders, textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(h)))
+	}
+
+	cs.contentHashHeader = cs.s3Compatible()
+
+	cs.maxBufferSize = spec.MaxBufferSize
+	if cs.maxBufferSize <= 0 {
+		cs.maxBufferSize = defaultMaxBufferSize
+	}
+
+	// the credentials are resolved again on the first request if they
+	// can't be resolved now.
+	if creds, err := cs.resolveCredentials(); err != nil {
+		logger.Errorf("%s: failed to resolve credentials: %v", cs.Name(), err)
+	} else {
+		cs.creds = creds
+	}
+}
+
+// s3Compatible returns whether the service is Amazon S3 or the storage of
+// GCP, which escape the path only once and require the hash of the payload
+// in a header.
+func (cs *CloudSigner) s3Compatible() bool {
+	return cs.spec.Provider == ProviderGCP || cs.spec.Service == "s3"
+}
+
+func defaultHost(provider, region, service string) string {
+	if provider == ProviderGCP {
+		return "storage.googleapis.com"
+	}
+	return service + "." + region + ".amazonaws.com"
+}
+
+func resolve(value string, ref *oauth2client.SecretRef) (string, error) {
+	if ref == nil {
+		return value, nil
+	}
+	return ref.Resolve()
+}
+
+func (cs *CloudSigner) resolveCredentials() (*credentials, error) {
+	spec := cs.spec
+
+	id, err := resolve(spec.AccessKeyID, spec.AccessKeyIDRef)
+	if err != nil {
+		return nil, fmt.Errorf("access key id: %v", err)
+	}
+	secret, err := resolve(spec.SecretAccessKey, spec.SecretAccessKeyRef)
+	if err != nil {
+		return nil, fmt.Errorf("secret access key: %v", err)
+	}
+	token, err := resolve(spec.SessionToken, spec.SessionTokenRef)
+	if err != nil {
+		return nil, fmt.Errorf("session token: %v", err)
+	}
+
+	s := signer.New().SetLiteral(cs.provider.literal).
+		SetCredential(id, secret).
+		EscapePathOnce(cs.s3Compatible())
+	return &credentials{signer: s, sessionToken: token}, nil
+}
+
+func (cs *CloudSigner) getCredentials() (*credentials, error) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	if cs.creds != nil {
+		return cs.creds, nil
+	}
+	creds, err := cs.resolveCredentials()
+	if err != nil {
+		return nil, err
+	}
+	cs.creds = creds
+	return creds, nil
+}
+
+// bufferPayload reads a stream into memory if it is not larger than
+// maxBufferSize, it returns whether the payload is in memory, so that it
+// could be hashed.
+func (cs *CloudSigner) bufferPayload(req *httpprot.Request) bool {
+	// a stream which has been partially read can't be hashed.
+	if req.IsStream() && req.PayloadSize() == 0 {
+		if err := req.BufferPayload(cs.maxBufferSize); err != nil {
+			return false
+		}
+	}
+	return !req.IsStream()
+}
+
+// sign signs the request, only the host and the signed headers are
+// included in the signature, so that the changes to other headers after
+// the filter, by the Proxy for example, don't break the signature.
+func (cs *CloudSigner) sign(req *httpprot.Request, creds *credentials) error {
+	p := cs.provider
+	h := req.HTTPHeader()
+
+	// the hash of the payload is sent in a header only if it is unsigned
+	// or required by the service, otherwise, the signer hashes the payload.
+	switch {
+	case cs.spec.UnsignedPayload || !cs.bufferPayload(req):
+		h.Set(p.literal.ContentSHA256, unsignedPayload)
+	case cs.contentHashHeader:
+		sum := sha256.Sum256(req.RawPayload())
+		h.Set(p.literal.ContentSHA256, hex.EncodeToString(sum[:]))
+	default:
+		h.Del(p.literal.ContentSHA256)
+	}
+
+	// the headers sent by the client must not be signed by the gateway.
+	if p.securityToken != "" {
+		h.Del(p.securityToken)
+	}
+	if creds.sessionToken != "" {
+		h.Set(p.securityToken, creds.sessionToken)
+	}
+	h.Del(p.literal.Date)
+
+	toSign := &http.Request{
+		Method: req.Method(),
+		URL: &url.URL{
+			Scheme:   "https",
+			Path:     req.Std().URL.Path,
+			RawPath:  req.Std().URL.RawPath,
+			RawQuery: req.Std().URL.RawQuery,
+		},
+		Host:   cs.host,
+		Header: http.Header{},
+	}
+	for k, v := range h {
+		if strings.HasPrefix(k, p.headerPrefix) {
+			toSign.Header[k] = v
+		}
+	}
+	for _, k := range cs.signedHeaders {
+		if v, ok := h[k]; ok {
+			toSign.Header[k] = v
+		}
+	}
+
+	sctx := creds.signer.NewSigningContext(now(), cs.spec.Region, cs.spec.Service)
+	if err := sctx.Sign(toSign, req.GetPayload); err != nil {
+		return err
+	}
+
+	h.Set(p.literal.Date, toSign.Header.Get(p.literal.Date))
+	h.Set("Authorization", toSign.Header.Get("Authorization"))
+	return nil
+}
+
+// Handle signs the request.
+func (cs *CloudSigner) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	creds, err := cs.getCredentials()
+	if err == nil {
+		err = cs.sign(req, creds)
+	}
+	if err != nil {
+		atomic.AddUint64(&cs.failures, 1)
+		logger.Errorf("%s: failed to sign request: %v", cs.Name(), err)
+		ctx.SetError(fmt.Errorf("%s: %v", cs.Name(), err))
+
+		resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+		if resp == nil {
+			resp, _ = httpprot.NewResponse(nil)
+		}
+		resp.SetStatusCode(http.StatusInternalServerError)
+		ctx.SetOutputResponse(resp)
+		return resultSignFailed
+	}
+
+	if req.HTTPHeader().Get(cs.provider.literal.ContentSHA256) == unsignedPayload {
+		atomic.AddUint64(&cs.unsigned, 1)
+	} else {
+		atomic.AddUint64(&cs.signed, 1)
+	}
+	return ""
+}
+
+// Status returns status.
+func (cs *CloudSigner) Status() interface{} {
+	return &Status{
+		Signed:   atomic.LoadUint64(&cs.signed),
+		Unsigned: atomic.LoadUint64(&cs.unsigned),
+		Failures: atomic.LoadUint64(&cs.failures),
+	}
+}
+
+// Close closes CloudSigner.
+func (cs *CloudSigner) Close() {
+}
@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package contentlengthguard implements a filter which enforces the
+// consistency of the Content-Length header and the body.
+package contentlengthguard
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/prometheushelper"
+)
+
+const (
+	// Kind is the kind of ContentLengthGuard.
+	Kind = "ContentLengthGuard"
+
+	resultRequestMismatch  = "requestMismatch"
+	resultResponseMismatch = "responseMismatch"
+
+	directionRequest  = "request"
+	directionResponse = "response"
+
+	actionRejected   = "rejected"
+	actionRecomputed = "recomputed"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "ContentLengthGuard checks the Content-Length header matches the length of the body.",
+	Results:     []string{resultRequestMismatch, resultResponseMismatch},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &ContentLengthGuard{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// ContentLengthGuard is the filter ContentLengthGuard.
+	ContentLengthGuard struct {
+		spec *Spec
+
+		requestMismatches  uint64
+		responseMismatches uint64
+		recomputed         uint64
+		mismatches         *prometheus.CounterVec
+	}
+
+	// Spec is the spec of ContentLengthGuard.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// RecomputeResponse sets the Content-Length of the response to
+		// the length of its body, instead of failing the response, if
+		// they are mismatched.
+		RecomputeResponse bool `json:"recomputeResponse,omitempty"`
+	}
+
+	// Status is the status of ContentLengthGuard.
+	Status struct {
+		RequestMismatches  uint64 `json:"requestMismatches"`
+		ResponseMismatches uint64 `json:"responseMismatches"`
+		Recomputed         uint64 `json:"recomputed"`
+	}
+)
+
+// Name returns the name of the ContentLengthGuard filter instance.
+func (g *ContentLengthGuard) Name() string {
+	return g.spec.Name()
+}
+
+// Kind returns the kind of ContentLengthGuard.
+func (g *ContentLengthGuard) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the ContentLengthGuard
+func (g *ContentLengthGuard) Spec() filters.Spec {
+	return g.spec
+}
+
+// Init initializes ContentLengthGuard.
+func (g *ContentLengthGuard) Init() {
+	g.mismatches = g.newMismatchCounter()
+}
+
+// Inherit inherits previous generation of ContentLengthGuard.
+func (g *ContentLengthGuard) Inherit(previousGeneration filters.Filter) {
+	g.Init()
+}
+
+func (g *ContentLengthGuard) newMismatchCounter() *prometheus.CounterVec {
+	labels := prometheus.Labels{
+		"filterName":   g.Name(),
+		"kind":         Kind,
+		"clusterName":  "",
+		"clusterRole":  "",
+		"instanceName": "",
+	}
+	if super := g.spec.Super(); super != nil && super.Options() != nil {
+		labels["clusterName"] = super.Options().ClusterName
+		labels["clusterRole"] = super.Options().ClusterRole
+		labels["instanceName"] = super.Options().Name
+	}
+	return prometheushelper.NewCounter("contentlengthguard_mismatches",
+		"the total count of mismatches between the Content-Length and the body",
+		[]string{"clusterName", "clusterRole", "instanceName", "filterName", "kind", "direction", "action"},
+	).MustCurryWith(labels)
+}
+
+// mismatched returns whether the declared Content-Length mismatches the
+// length of the body, a missing Content-Length is not a mismatch.
+func mismatched(header http.Header, bodyLen int) (string, bool) {
+	declared := header.Get("Content-Length")
+	if declared == "" {
+		return "", false
+	}
+	n, err := strconv.ParseInt(declared, 10, 64)
+	return declared, err != nil || n != int64(bodyLen)
+}
+
+// Handle checks the Content-Length of the request, and also the response
+// if there's one.
+func (g *ContentLengthGuard) Handle(ctx *context.Context) string {
+	if resp, _ := ctx.GetOutputResponse().(*httpprot.Response); resp != nil {
+		return g.handleResponse(ctx, resp)
+	}
+	return g.handleRequest(ctx)
+}
+
+func (g *ContentLengthGuard) handleRequest(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	// the length of a stream body is checked by the HTTP server when the
+	// body is read.
+	if req.IsStream() {
+		return ""
+	}
+
+	body := req.RawPayload()
+	declared, ok := mismatched(req.HTTPHeader(), len(body))
+	if !ok {
+		return ""
+	}
+
+	atomic.AddUint64(&g.requestMismatches, 1)
+	g.mismatches.WithLabelValues(directionRequest, actionRejected).Inc()
+	ctx.AddTag(fmt.Sprintf("contentLengthGuard: request Content-Length %s mismatches body length %d", declared, len(body)))
+
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(http.StatusBadRequest)
+	ctx.SetOutputResponse(resp)
+	return resultRequestMismatch
+}
+
+func (g *ContentLengthGuard) handleResponse(ctx *context.Context, resp *httpprot.Response) string {
+	if resp.IsStream() {
+		return ""
+	}
+
+	body := resp.RawPayload()
+	declared, ok := mismatched(resp.HTTPHeader(), len(body))
+	if !ok {
+		return ""
+	}
+
+	if g.spec.RecomputeResponse {
+		atomic.AddUint64(&g.recomputed, 1)
+		g.mismatches.WithLabelValues(directionResponse, actionRecomputed).Inc()
+		resp.HTTPHeader().Set("Content-Length", strconv.Itoa(len(body)))
+		resp.Std().ContentLength = int64(len(body))
+		return ""
+	}
+
+	atomic.AddUint64(&g.responseMismatches, 1)
+	g.mismatches.WithLabelValues(directionResponse, actionRejected).Inc()
+	ctx.AddTag(fmt.Sprintf("contentLengthGuard: response Content-Length %s mismatches body length %d", declared, len(body)))
+
+	// the body of the response is corrupted, so it is dropped.
+	badResp, _ := httpprot.NewResponse(nil)
+	badResp.SetStatusCode(http.StatusBadGateway)
+	ctx.SetOutputResponse(badResp)
+	return resultResponseMismatch
+}
+
+// Status returns status.
+func (g *ContentLengthGuard) Status() interface{} {
+	return &Status{
+		RequestMismatches:  atomic.LoadUint64(&g.requestMismatches),
+		ResponseMismatches: atomic.LoadUint64(&g.responseMismatches),
+		Recomputed:         atomic.LoadUint64(&g.recomputed),
+	}
+}
+
+// Close closes ContentLengthGuard.
+func (g *ContentLengthGuard) Close() {
+}
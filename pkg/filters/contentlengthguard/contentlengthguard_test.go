@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package contentlengthguard
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestGuard(yamlConfig string) *ContentLengthGuard {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		panic(err)
+	}
+	g := kind.CreateInstance(spec).(*ContentLengthGuard)
+	g.Init()
+	return g
+}
+
+func newContext(body, contentLength string) *context.Context {
+	stdReq, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/", strings.NewReader(body))
+	if contentLength != "" {
+		stdReq.Header.Set("Content-Length", contentLength)
+	}
+	req, _ := httpprot.NewRequest(stdReq)
+	req.FetchPayload(1024)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func setResponse(ctx *context.Context, body, contentLength string) *httpprot.Response {
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetPayload([]byte(body))
+	resp.HTTPHeader().Set("Content-Length", contentLength)
+	ctx.SetOutputResponse(resp)
+	return resp
+}
+
+func TestRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	g := newTestGuard(`
+kind: ContentLengthGuard
+name: guard
+`)
+	assert.Equal(kind, g.Kind())
+	assert.Equal("guard", g.Name())
+
+	assert.Equal("", g.Handle(newContext("hello", "5")))
+	assert.Equal("", g.Handle(newContext("hello", "")))
+
+	ctx := newContext("hello", "10")
+	assert.Equal(resultRequestMismatch, g.Handle(ctx))
+	assert.Equal(http.StatusBadRequest, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	ctx = newContext("hello", "abc")
+	assert.Equal(resultRequestMismatch, g.Handle(ctx))
+
+	assert.Equal(uint64(2), g.Status().(*Status).RequestMismatches)
+	g.Inherit(g)
+	g.Close()
+}
+
+func TestResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	g := newTestGuard(`
+kind: ContentLengthGuard
+name: guard
+`)
+
+	ctx := newContext("", "")
+	setResponse(ctx, "hello", "5")
+	assert.Equal("", g.Handle(ctx))
+
+	ctx = newContext("", "")
+	setResponse(ctx, "hello world", "5")
+	assert.Equal(resultResponseMismatch, g.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusBadGateway, resp.StatusCode())
+	assert.Equal(0, len(resp.RawPayload()))
+
+	g = newTestGuard(`
+kind: ContentLengthGuard
+name: guard
+recomputeResponse: true
+`)
+	ctx = newContext("", "")
+	resp = setResponse(ctx, "hello world", "5")
+	assert.Equal("", g.Handle(ctx))
+	assert.Equal(resp, ctx.GetOutputResponse())
+	assert.Equal("11", resp.HTTPHeader().Get("Content-Length"))
+	assert.Equal(int64(11), resp.Std().ContentLength)
+
+	status := g.Status().(*Status)
+	assert.Equal(uint64(1), status.Recomputed)
+	assert.Equal(uint64(0), status.ResponseMismatches)
+}
@@ -0,0 +1,282 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package contentnegotiation implements a filter which negotiates the
+// content type of the response with the Accept header of requests.
+package contentnegotiation
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+const (
+	// Kind is the kind of ContentNegotiation.
+	Kind = "ContentNegotiation"
+
+	defaultHeader = "X-Negotiated-Content-Type"
+
+	resultNotAcceptable = "notAcceptable"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "ContentNegotiation negotiates the content type with the Accept header of requests.",
+	Results:     []string{resultNotAcceptable},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{Header: defaultHeader}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &ContentNegotiation{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// ContentNegotiation is the filter ContentNegotiation.
+	ContentNegotiation struct {
+		spec  *Spec
+		types []*mediaType
+	}
+
+	// Spec is the spec of ContentNegotiation.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Types are the media types the backend could produce, in the
+		// order of preference.
+		Types []string `json:"types" jsonschema:"required,minItems=1,uniqueItems=true"`
+		// Header is the request header to carry the negotiated type.
+		Header string `json:"header,omitempty"`
+	}
+
+	// Err is the error of ContentNegotiation.
+	Err struct {
+		Err            string   `json:"err"`
+		SupportedTypes []string `json:"supportedTypes"`
+	}
+
+	mediaType struct {
+		raw     string
+		typ     string
+		subtype string
+		params  map[string]string
+	}
+
+	// acceptRange is a media range in the Accept header.
+	acceptRange struct {
+		mediaType
+		q float64
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	for _, t := range spec.Types {
+		mt, err := parseMediaType(t)
+		if err != nil {
+			return fmt.Errorf("invalid type %s: %v", t, err)
+		}
+		if mt.typ == "*" || mt.subtype == "*" {
+			return fmt.Errorf("invalid type %s: wildcards are not allowed", t)
+		}
+	}
+	return nil
+}
+
+func parseMediaType(s string) (*mediaType, error) {
+	full, params, err := mime.ParseMediaType(s)
+	if err != nil {
+		return nil, err
+	}
+
+	typ, subtype, ok := strings.Cut(full, "/")
+	if !ok || typ == "" || subtype == "" || (typ == "*" && subtype != "*") {
+		return nil, fmt.Errorf("invalid media type %s", s)
+	}
+
+	return &mediaType{
+		raw:     strings.TrimSpace(s),
+		typ:     typ,
+		subtype: subtype,
+		params:  params,
+	}, nil
+}
+
+// parseAccept parses the Accept header, invalid media ranges are ignored.
+func parseAccept(values []string) []*acceptRange {
+	var ranges []*acceptRange
+	for _, value := range values {
+		for _, s := range strings.Split(value, ",") {
+			if strings.TrimSpace(s) == "" {
+				continue
+			}
+
+			mt, err := parseMediaType(s)
+			if err != nil {
+				continue
+			}
+
+			r := &acceptRange{mediaType: *mt, q: 1}
+			if q, ok := mt.params["q"]; ok {
+				r.q, err = strconv.ParseFloat(q, 64)
+				if err != nil || r.q < 0 || r.q > 1 {
+					continue
+				}
+				delete(mt.params, "q")
+			}
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// match returns the specificity of the media range to the media type, it
+// returns -1 if they don't match. Parameters of the media range must all
+// present in the media type.
+func (r *acceptRange) match(mt *mediaType) int {
+	if r.typ == "*" {
+		return 0
+	}
+	if r.typ != mt.typ {
+		return -1
+	}
+	if r.subtype == "*" {
+		return 1
+	}
+	if r.subtype != mt.subtype {
+		return -1
+	}
+	for k, v := range r.params {
+		if !strings.EqualFold(mt.params[k], v) {
+			return -1
+		}
+	}
+	return 2 + len(r.params)
+}
+
+// negotiate returns the acceptable type with the highest quality, types
+// with the same quality are preferred in the order of configuration. The
+// quality of a type is the quality of the most specific media range which
+// matches it.
+func (cn *ContentNegotiation) negotiate(ranges []*acceptRange) *mediaType {
+	// no Accept header means any type is acceptable.
+	if len(ranges) == 0 {
+		return cn.types[0]
+	}
+
+	var best *mediaType
+	bestQ := 0.0
+	for _, mt := range cn.types {
+		specificity, q := -1, 0.0
+		for _, r := range ranges {
+			if s := r.match(mt); s > specificity {
+				specificity, q = s, r.q
+			}
+		}
+		if q > bestQ {
+			best, bestQ = mt, q
+		}
+	}
+	return best
+}
+
+// Name returns the name of the ContentNegotiation filter instance.
+func (cn *ContentNegotiation) Name() string {
+	return cn.spec.Name()
+}
+
+// Kind returns the kind of ContentNegotiation.
+func (cn *ContentNegotiation) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the ContentNegotiation
+func (cn *ContentNegotiation) Spec() filters.Spec {
+	return cn.spec
+}
+
+// Init initializes ContentNegotiation.
+func (cn *ContentNegotiation) Init() {
+	cn.reload()
+}
+
+// Inherit inherits previous generation of ContentNegotiation.
+func (cn *ContentNegotiation) Inherit(previousGeneration filters.Filter) {
+	cn.Init()
+}
+
+func (cn *ContentNegotiation) reload() {
+	if cn.spec.Header == "" {
+		cn.spec.Header = defaultHeader
+	}
+
+	// the types have been verified in Validate, so no error here.
+	cn.types = nil
+	for _, t := range cn.spec.Types {
+		mt, _ := parseMediaType(t)
+		cn.types = append(cn.types, mt)
+	}
+}
+
+// Handle negotiates the content type of the request, and rejects the
+// request if there's no acceptable type.
+func (cn *ContentNegotiation) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	mt := cn.negotiate(parseAccept(req.HTTPHeader().Values("Accept")))
+	if mt != nil {
+		req.HTTPHeader().Set(cn.spec.Header, mt.raw)
+		return ""
+	}
+
+	ctx.AddTag("contentNegotiation: not acceptable")
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(http.StatusNotAcceptable)
+	resp.HTTPHeader().Set("Content-Type", "application/json")
+	body, _ := codectool.MarshalJSON(&Err{
+		Err:            "no acceptable content type",
+		SupportedTypes: cn.spec.Types,
+	})
+	resp.SetPayload(body)
+	ctx.SetOutputResponse(resp)
+	return resultNotAcceptable
+}
+
+// Status returns status.
+func (cn *ContentNegotiation) Status() interface{} {
+	return nil
+}
+
+// Close closes ContentNegotiation.
+func (cn *ContentNegotiation) Close() {
+}
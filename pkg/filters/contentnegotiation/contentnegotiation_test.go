@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package contentnegotiation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestContentNegotiation(yamlConfig string) (*ContentNegotiation, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	cn := kind.CreateInstance(spec).(*ContentNegotiation)
+	cn.Init()
+	return cn, nil
+}
+
+func newContext(accept ...string) (*context.Context, *httpprot.Request) {
+	stdReq := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, a := range accept {
+		stdReq.Header.Add("Accept", a)
+	}
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, req
+}
+
+const testConfig = `
+kind: ContentNegotiation
+name: negotiation
+types:
+- application/json
+- application/xml
+- text/html; level=1
+- text/plain
+`
+
+func TestNegotiate(t *testing.T) {
+	assert := assert.New(t)
+
+	cn, err := newTestContentNegotiation(testConfig)
+	assert.Nil(err)
+	assert.Equal(kind, cn.Kind())
+	assert.Nil(cn.Status())
+
+	cases := []struct {
+		accept []string
+		want   string
+	}{
+		// no Accept header, the most preferred type is chosen.
+		{nil, "application/json"},
+		{[]string{"*/*"}, "application/json"},
+		{[]string{"application/xml"}, "application/xml"},
+		// quality values.
+		{[]string{"application/json;q=0.5, application/xml"}, "application/xml"},
+		{[]string{"application/json; q=0.8, text/*; q=0.9"}, "text/html; level=1"},
+		// the most specific range decides the quality.
+		{[]string{"text/*, text/plain;q=0.2, application/*;q=0.1"}, "text/html; level=1"},
+		{[]string{"*/*;q=0.1, application/json;q=0"}, "application/xml"},
+		// parameters of media ranges must match.
+		{[]string{"text/html;level=2, text/plain;q=0.5"}, "text/plain"},
+		{[]string{"TEXT/HTML; Level=1"}, "text/html; level=1"},
+		// same quality, the order of configuration is preferred.
+		{[]string{"text/plain, application/xml"}, "application/xml"},
+		// multiple headers, invalid ranges are ignored.
+		{[]string{"invalid, image/png", "text/plain;q=abc, application/json;q=2, text/plain;q=0.3"}, "text/plain"},
+		// browsers.
+		{[]string{"text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8"}, "text/html; level=1"},
+		{[]string{"application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"}, "application/xml"},
+	}
+	for _, c := range cases {
+		ctx, req := newContext(c.accept...)
+		assert.Equal("", cn.Handle(ctx), c.accept)
+		assert.Equal(c.want, req.HTTPHeader().Get(defaultHeader), c.accept)
+	}
+
+	newCn := kind.CreateInstance(cn.spec).(*ContentNegotiation)
+	newCn.Inherit(cn)
+	cn.Close()
+}
+
+func TestNotAcceptable(t *testing.T) {
+	assert := assert.New(t)
+
+	cn, err := newTestContentNegotiation(testConfig)
+	assert.Nil(err)
+
+	for _, accept := range []string{
+		"image/png",
+		"image/*, application/json;q=0",
+		"*/*;q=0",
+		"text/html;level=2",
+	} {
+		ctx, _ := newContext(accept)
+		assert.Equal(resultNotAcceptable, cn.Handle(ctx), accept)
+
+		resp := ctx.GetOutputResponse().(*httpprot.Response)
+		assert.Equal(http.StatusNotAcceptable, resp.StatusCode())
+		e := &Err{}
+		codectool.MustUnmarshal(resp.RawPayload(), e)
+		assert.Equal(cn.spec.Types, e.SupportedTypes)
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, types := range []string{"[application/*]", "[invalid]", "['*/*']"} {
+		_, err := newTestContentNegotiation(`
+kind: ContentNegotiation
+name: negotiation
+types: ` + types)
+		assert.NotNil(err, types)
+	}
+
+	cn, err := newTestContentNegotiation(`
+kind: ContentNegotiation
+name: negotiation
+header: X-Content-Type
+types: [application/json]
+`)
+	assert.Nil(err)
+	ctx, req := newContext()
+	assert.Equal("", cn.Handle(ctx))
+	assert.Equal("application/json", req.HTTPHeader().Get("X-Content-Type"))
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package contentrouter implements a filter which makes routing decisions
+// by fields of the request body.
+package contentrouter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/bodycodec"
+	"github.com/megaease/easegress/v2/pkg/util/jsonpath"
+	"github.com/megaease/easegress/v2/pkg/util/routeheader"
+)
+
+const (
+	// Kind is the kind of ContentRouter.
+	Kind = "ContentRouter"
+
+	defaultMaxBodySize = 64 * 1024
+	defaultBodyFormat  = "json"
+)
+
+var kind = &filters.Kind{
+	Name:              Kind,
+	Description:       "ContentRouter sets the routing decision by a field of the JSON body.",
+	Results:           []string{},
+	RequestOnly:       true,
+	RequiresBuffering: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{MaxBodySize: defaultMaxBodySize, BodyFormat: defaultBodyFormat}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &ContentRouter{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// ContentRouter is the filter ContentRouter.
+	ContentRouter struct {
+		spec   *Spec
+		path   jsonpath.Path
+		routes map[string]string
+		codec  bodycodec.Codec
+	}
+
+	// Spec is the spec of ContentRouter.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Field is the JSONPath of the field, e.g. $.tenant.id.
+		Field string `json:"field" jsonschema:"required"`
+		// Header is the request header to carry the routing decision.
+		Header       routeheader.Header `json:"header" jsonschema:"required,minLength=1"`
+		Routes       []*Route           `json:"routes,omitempty"`
+		DefaultRoute string             `json:"defaultRoute,omitempty"`
+		MaxBodySize  int64              `json:"maxBodySize,omitempty" jsonschema:"minimum=1"`
+		// BodyFormat is the format of the body, the field is looked up
+		// after the body is decoded by the codec of the format, default
+		// is json.
+		BodyFormat string `json:"bodyFormat,omitempty"`
+	}
+
+	// Route maps field values to a route.
+	Route struct {
+		Name   string   `json:"name" jsonschema:"required"`
+		Values []string `json:"values" jsonschema:"required,minItems=1"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if _, err := jsonpath.Parse(spec.Field); err != nil {
+		return err
+	}
+	if spec.BodyFormat != "" {
+		if err := bodycodec.Validate(spec.BodyFormat); err != nil {
+			return err
+		}
+	}
+
+	values := map[string]bool{}
+	for _, r := range spec.Routes {
+		for _, v := range r.Values {
+			if values[v] {
+				return fmt.Errorf("duplicated value %s in routes", v)
+			}
+			values[v] = true
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the ContentRouter filter instance.
+func (cr *ContentRouter) Name() string {
+	return cr.spec.Name()
+}
+
+// Kind returns the kind of ContentRouter.
+func (cr *ContentRouter) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the ContentRouter
+func (cr *ContentRouter) Spec() filters.Spec {
+	return cr.spec
+}
+
+// Init initializes ContentRouter.
+func (cr *ContentRouter) Init() {
+	cr.reload()
+}
+
+// Inherit inherits previous generation of ContentRouter.
+func (cr *ContentRouter) Inherit(previousGeneration filters.Filter) {
+	cr.Init()
+}
+
+func (cr *ContentRouter) reload() {
+	// the path has been verified in Validate, so no error here.
+	cr.path, _ = jsonpath.Parse(cr.spec.Field)
+
+	cr.routes = map[string]string{}
+	for _, r := range cr.spec.Routes {
+		for _, v := range r.Values {
+			cr.routes[v] = r.Name
+		}
+	}
+
+	if cr.spec.MaxBodySize <= 0 {
+		cr.spec.MaxBodySize = defaultMaxBodySize
+	}
+	if cr.spec.BodyFormat == "" {
+		cr.spec.BodyFormat = defaultBodyFormat
+	}
+	cr.codec = bodycodec.Get(cr.spec.BodyFormat)
+}
+
+// lookup returns the value of the field in body as a string, it returns
+// false if the body can't be decoded, or the field is not found or not a
+// scalar.
+func (cr *ContentRouter) lookup(body []byte) (string, bool) {
+	v, err := cr.codec.Decode(body)
+	if err != nil {
+		return "", false
+	}
+
+	v, ok := cr.path.Lookup(v)
+	if !ok {
+		return "", false
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// route returns the routing decision of the request.
+func (cr *ContentRouter) route(req *httpprot.Request) string {
+	// a stream body can't be inspected without consuming it.
+	if req.IsStream() {
+		return cr.spec.DefaultRoute
+	}
+
+	body := req.RawPayload()
+	if len(body) == 0 || int64(len(body)) > cr.spec.MaxBodySize {
+		return cr.spec.DefaultRoute
+	}
+
+	value, ok := cr.lookup(body)
+	if !ok {
+		return cr.spec.DefaultRoute
+	}
+
+	if len(cr.routes) == 0 {
+		return value
+	}
+	if route, ok := cr.routes[value]; ok {
+		return route
+	}
+	return cr.spec.DefaultRoute
+}
+
+// Handle sets the routing decision to the request header.
+func (cr *ContentRouter) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	cr.spec.Header.Reset(req.HTTPHeader())
+
+	if route := cr.route(req); route != "" {
+		cr.spec.Header.Set(req.HTTPHeader(), route)
+		ctx.LazyAddTag(func() string {
+			return "contentRouter: " + route
+		})
+	}
+	return ""
+}
+
+// Status returns status.
+func (cr *ContentRouter) Status() interface{} {
+	return nil
+}
+
+// Close closes ContentRouter.
+func (cr *ContentRouter) Close() {
+}
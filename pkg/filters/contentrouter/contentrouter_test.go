@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package contentrouter
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestContentRouter(yamlConfig string) (*ContentRouter, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	cr := kind.CreateInstance(spec).(*ContentRouter)
+	cr.Init()
+	return cr, nil
+}
+
+func newContext(body string) (*context.Context, *httpprot.Request) {
+	stdReq := httptest.NewRequest(http.MethodPost, "http://localhost/orders", strings.NewReader(body))
+	stdReq.Header.Set("X-Route", "forged")
+	req, _ := httpprot.NewRequest(stdReq)
+	req.FetchPayload(0)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, req
+}
+
+func TestContentRouter(t *testing.T) {
+	assert := assert.New(t)
+
+	cr, err := newTestContentRouter(`
+kind: ContentRouter
+name: router
+field: $.tenant.id
+header: X-Route
+defaultRoute: shared
+routes:
+- name: dedicated
+  values: ["acme", "42", "true"]
+`)
+	assert.Nil(err)
+	assert.Equal(kind, cr.Kind())
+	assert.Nil(cr.Status())
+
+	cases := []struct {
+		body  string
+		route string
+	}{
+		{`{"tenant": {"id": "acme"}}`, "dedicated"},
+		{`{"tenant": {"id": 42}}`, "dedicated"},
+		{`{"tenant": {"id": true}}`, "dedicated"},
+		{`{"tenant": {"id": "other"}}`, "shared"},
+		{`{"tenant": {"id": {"x": 1}}}`, "shared"},
+		{`{"tenant": "acme"}`, "shared"},
+		{`{"other": 1}`, "shared"},
+		{`not json`, "shared"},
+		{``, "shared"},
+	}
+	for _, c := range cases {
+		ctx, req := newContext(c.body)
+		assert.Equal("", cr.Handle(ctx))
+		assert.Equal(c.route, req.HTTPHeader().Get("X-Route"), c.body)
+
+		// the body is not consumed.
+		data, _ := io.ReadAll(req.GetPayload())
+		assert.Equal(c.body, string(data))
+	}
+
+	// body exceeds the limit.
+	cr.spec.MaxBodySize = 10
+	ctx, req := newContext(`{"tenant": {"id": "acme"}}`)
+	cr.Handle(ctx)
+	assert.Equal("shared", req.HTTPHeader().Get("X-Route"))
+
+	newCr := kind.CreateInstance(cr.spec).(*ContentRouter)
+	newCr.Inherit(cr)
+	cr.Close()
+}
+
+func TestContentRouterValue(t *testing.T) {
+	assert := assert.New(t)
+
+	cr, err := newTestContentRouter(`
+kind: ContentRouter
+name: router
+field: $.items[1]['tenant']
+header: X-Route
+`)
+	assert.Nil(err)
+
+	ctx, req := newContext(`{"items": [{"tenant": "a"}, {"tenant": "b"}]}`)
+	cr.Handle(ctx)
+	assert.Equal("b", req.HTTPHeader().Get("X-Route"))
+
+	// no decision, the header is removed.
+	ctx, req = newContext(`{"items": []}`)
+	cr.Handle(ctx)
+	assert.Equal("", req.HTTPHeader().Get("X-Route"))
+
+	// stream body is not inspected.
+	stdReq := httptest.NewRequest(http.MethodPost, "http://localhost/", strings.NewReader(`{"items": [1, 2]}`))
+	req, _ = httpprot.NewRequest(stdReq)
+	req.FetchPayload(-1)
+	ctx = context.New(nil)
+	ctx.SetInputRequest(req)
+	cr.Handle(ctx)
+	assert.Equal("", req.HTTPHeader().Get("X-Route"))
+}
+
+func TestContentRouterBodyFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	cr, err := newTestContentRouter(`
+kind: ContentRouter
+name: router
+field: $['soap:Envelope']['soap:Body'].Order['-tenant']
+header: X-Route
+bodyFormat: xml
+`)
+	assert.Nil(err)
+
+	ctx, req := newContext(`<soap:Envelope><soap:Body><Order tenant="a"><id>1</id></Order></soap:Body></soap:Envelope>`)
+	cr.Handle(ctx)
+	assert.Equal("a", req.HTTPHeader().Get("X-Route"))
+
+	cr, err = newTestContentRouter(`
+kind: ContentRouter
+name: router
+field: $.tenant
+header: X-Route
+bodyFormat: form
+`)
+	assert.Nil(err)
+
+	ctx, req = newContext(`tenant=b&id=1`)
+	cr.Handle(ctx)
+	assert.Equal("b", req.HTTPHeader().Get("X-Route"))
+
+	_, err = newTestContentRouter(`
+kind: ContentRouter
+name: router
+field: $.tenant
+header: X-Route
+bodyFormat: msgpack
+`)
+	assert.NotNil(err)
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newTestContentRouter(`
+kind: ContentRouter
+name: router
+field: tenant
+header: X-Route
+`)
+	assert.NotNil(err)
+
+	_, err = newTestContentRouter(`
+kind: ContentRouter
+name: router
+field: $.tenant
+header: X-Route
+routes:
+- name: a
+  values: ["x"]
+- name: b
+  values: ["x"]
+`)
+	assert.NotNil(err)
+}
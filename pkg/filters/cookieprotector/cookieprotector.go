@@ -0,0 +1,406 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package cookieprotector implements a filter which signs or encrypts
+// cookies, so that clients can't read or tamper with them.
+package cookieprotector
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of CookieProtector.
+	Kind = "CookieProtector"
+
+	resultTampered = "tampered"
+
+	// ModeSign signs cookies, their values are still readable by clients.
+	ModeSign = "sign"
+	// ModeEncrypt encrypts and authenticates cookies.
+	ModeEncrypt = "encrypt"
+
+	// OnTamperedStrip removes tampered cookies from requests.
+	OnTamperedStrip = "strip"
+	// OnTamperedReject rejects requests with tampered cookies.
+	OnTamperedReject = "reject"
+
+	keySize = 32
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "CookieProtector signs or encrypts cookies, and verifies or decrypts them in subsequent requests.",
+	Results:     []string{resultTampered},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{Mode: ModeEncrypt, OnTampered: OnTamperedStrip}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &CookieProtector{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// CookieProtector is the filter CookieProtector.
+	CookieProtector struct {
+		spec *Spec
+
+		activeKey *key
+		keys      map[string]*key
+		cookies   map[string]bool
+
+		protected uint64
+		verified  uint64
+		tampered  uint64
+	}
+
+	// Spec is the spec of CookieProtector.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		Mode       string   `json:"mode,omitempty" jsonschema:"enum=sign,enum=encrypt"`
+		Cookies    []string `json:"cookies" jsonschema:"required,minItems=1"`
+		Keys       []*Key   `json:"keys" jsonschema:"required,minItems=1"`
+		OnTampered string   `json:"onTampered,omitempty" jsonschema:"enum=strip,enum=reject"`
+		// ActiveKeyID is the ID of the key to protect cookies, the first
+		// key is used if it is empty. All keys are used to verify cookies,
+		// so keys are rotated by adding the new key, switching ActiveKeyID
+		// to it, and removing the old key after cookies protected by it
+		// expire.
+		ActiveKeyID string `json:"activeKeyID,omitempty"`
+	}
+
+	// Key is a secret key to protect cookies.
+	Key struct {
+		ID string `json:"id" jsonschema:"required,pattern=^[A-Za-z0-9_-]+$"`
+		// Secret is the base64 encoded 32 bytes secret.
+		Secret string `json:"secret" jsonschema:"required"`
+	}
+
+	// Status is the status of CookieProtector.
+	Status struct {
+		Protected uint64 `json:"protected"`
+		Verified  uint64 `json:"verified"`
+		Tampered  uint64 `json:"tampered"`
+	}
+
+	key struct {
+		id     string
+		secret []byte
+		aead   cipher.AEAD
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	ids := map[string]bool{}
+	for _, k := range spec.Keys {
+		if ids[k.ID] {
+			return fmt.Errorf("duplicated key id %s", k.ID)
+		}
+		ids[k.ID] = true
+
+		if _, err := newKey(k); err != nil {
+			return fmt.Errorf("key %s: %v", k.ID, err)
+		}
+	}
+
+	if spec.ActiveKeyID != "" && !ids[spec.ActiveKeyID] {
+		return fmt.Errorf("active key %s not found", spec.ActiveKeyID)
+	}
+
+	for _, c := range spec.Cookies {
+		if strings.TrimSpace(c) == "" {
+			return fmt.Errorf("empty cookie name")
+		}
+	}
+
+	return nil
+}
+
+func newKey(k *Key) (*key, error) {
+	secret, err := base64.StdEncoding.DecodeString(k.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret: %v", err)
+	}
+	if len(secret) != keySize {
+		return nil, fmt.Errorf("secret must be %d bytes", keySize)
+	}
+
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key{id: k.ID, secret: secret, aead: aead}, nil
+}
+
+// Name returns the name of the CookieProtector filter instance.
+func (cp *CookieProtector) Name() string {
+	return cp.spec.Name()
+}
+
+// Kind returns the kind of CookieProtector.
+func (cp *CookieProtector) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the CookieProtector
+func (cp *CookieProtector) Spec() filters.Spec {
+	return cp.spec
+}
+
+// Init initializes CookieProtector.
+func (cp *CookieProtector) Init() {
+	cp.reload()
+}
+
+// Inherit inherits previous generation of CookieProtector.
+func (cp *CookieProtector) Inherit(previousGeneration filters.Filter) {
+	cp.Init()
+}
+
+func (cp *CookieProtector) reload() {
+	cp.keys = map[string]*key{}
+	for _, k := range cp.spec.Keys {
+		// keys are validated, so there's no error.
+		key, _ := newKey(k)
+		cp.keys[k.ID] = key
+		if cp.activeKey == nil || k.ID == cp.spec.ActiveKeyID {
+			cp.activeKey = key
+		}
+	}
+
+	cp.cookies = map[string]bool{}
+	for _, c := range cp.spec.Cookies {
+		cp.cookies[c] = true
+	}
+}
+
+// sign returns the HMAC of the value, the cookie name is included so that
+// the value of a cookie can't be used as another one.
+func (k *key) sign(name, value string) string {
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// protect signs or encrypts the value of a cookie. A signed value is in
+// format 'value.keyID.signature', and an encrypted value is in format
+// 'keyID.ciphertext', where the ciphertext includes the nonce.
+func (cp *CookieProtector) protect(name, value string) string {
+	k := cp.activeKey
+	if cp.spec.Mode == ModeSign {
+		return value + "." + k.id + "." + k.sign(name, value)
+	}
+
+	nonce := make([]byte, k.aead.NonceSize())
+	rand.Read(nonce)
+	sealed := k.aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return k.id + "." + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// unprotect verifies or decrypts the value of a cookie, it returns false
+// if the value is tampered.
+func (cp *CookieProtector) unprotect(name, value string) (string, bool) {
+	if cp.spec.Mode == ModeSign {
+		i := strings.LastIndexByte(value, '.')
+		if i < 0 {
+			return "", false
+		}
+		j := strings.LastIndexByte(value[:i], '.')
+		if j < 0 {
+			return "", false
+		}
+		k := cp.keys[value[j+1:i]]
+		if k == nil {
+			return "", false
+		}
+		v := value[:j]
+		if !hmac.Equal([]byte(k.sign(name, v)), []byte(value[i+1:])) {
+			return "", false
+		}
+		return v, true
+	}
+
+	id, data, ok := strings.Cut(value, ".")
+	if !ok {
+		return "", false
+	}
+	k := cp.keys[id]
+	if k == nil {
+		return "", false
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil || len(sealed) < k.aead.NonceSize() {
+		return "", false
+	}
+	n := k.aead.NonceSize()
+	plain, err := k.aead.Open(nil, sealed[:n], sealed[n:], []byte(name))
+	if err != nil {
+		return "", false
+	}
+	return string(plain), true
+}
+
+// Handle verifies or decrypts the cookies of the request, or protects the
+// cookies set by the response.
+func (cp *CookieProtector) Handle(ctx *context.Context) string {
+	if resp := ctx.GetInputResponse(); resp != nil {
+		cp.handleResponse(resp.(*httpprot.Response))
+		return ""
+	}
+	return cp.handleRequest(ctx)
+}
+
+func (cp *CookieProtector) handleRequest(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	found := false
+	for _, c := range req.Cookies() {
+		if cp.cookies[c.Name] {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ""
+	}
+
+	var cookies []string
+	for _, c := range req.Cookies() {
+		if !cp.cookies[c.Name] {
+			cookies = append(cookies, c.Name+"="+c.Value)
+			continue
+		}
+
+		v, ok := cp.unprotect(c.Name, c.Value)
+		if ok {
+			atomic.AddUint64(&cp.verified, 1)
+			cookies = append(cookies, c.Name+"="+v)
+			continue
+		}
+
+		atomic.AddUint64(&cp.tampered, 1)
+		if cp.spec.OnTampered == OnTamperedReject {
+			return cp.reject(ctx, c.Name)
+		}
+		logger.Debugf("%s: strip tampered cookie %s", cp.Name(), c.Name)
+		ctx.AddTag("cookieProtector: stripped tampered cookie " + c.Name)
+	}
+
+	h := req.HTTPHeader()
+	h.Del("Cookie")
+	if len(cookies) > 0 {
+		h.Set("Cookie", strings.Join(cookies, "; "))
+	}
+	return ""
+}
+
+func (cp *CookieProtector) reject(ctx *context.Context, name string) string {
+	ctx.AddTag("cookieProtector: tampered cookie " + name)
+
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(http.StatusBadRequest)
+	ctx.SetOutputResponse(resp)
+	return resultTampered
+}
+
+// handleResponse protects the values of the Set-Cookie headers, the
+// attributes of the cookies are kept as is.
+func (cp *CookieProtector) handleResponse(resp *httpprot.Response) {
+	h := resp.HTTPHeader()
+	values := h.Values("Set-Cookie")
+	changed := false
+
+	for i, sc := range values {
+		pair, attrs, _ := strings.Cut(sc, ";")
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if !cp.cookies[name] {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		quoted := len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"'
+		if quoted {
+			value = value[1 : len(value)-1]
+		}
+		// a cookie is deleted by setting its value to empty, which
+		// should be kept as is.
+		if value == "" {
+			continue
+		}
+
+		values[i] = name + "=" + cp.protect(name, value)
+		if strings.Contains(sc, ";") {
+			values[i] += ";" + attrs
+		}
+		atomic.AddUint64(&cp.protected, 1)
+		changed = true
+	}
+
+	if changed {
+		h.Del("Set-Cookie")
+		for _, v := range values {
+			h.Add("Set-Cookie", v)
+		}
+	}
+}
+
+// Status returns status.
+func (cp *CookieProtector) Status() interface{} {
+	return &Status{
+		Protected: atomic.LoadUint64(&cp.protected),
+		Verified:  atomic.LoadUint64(&cp.verified),
+		Tampered:  atomic.LoadUint64(&cp.tampered),
+	}
+}
+
+// Close closes CookieProtector.
+func (cp *CookieProtector) Close() {
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookieprotector
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	secret1 = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	secret2 = "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func createCookieProtector(t *testing.T, yamlConfig string) *CookieProtector {
+	rawSpec := make(map[string]interface{})
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		t.Fatalf("failed to create spec: %v", err)
+	}
+	cp := kind.CreateInstance(spec).(*CookieProtector)
+	cp.Init()
+	return cp
+}
+
+// protectResponse runs the filter on a response setting the cookies, and
+// returns the protected Set-Cookie headers.
+func protectResponse(t *testing.T, cp *CookieProtector, setCookies ...string) []string {
+	ctx := context.New(nil)
+	stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	req, _ := httpprot.NewRequest(stdr)
+	ctx.SetInputRequest(req)
+
+	resp, _ := httpprot.NewResponse(nil)
+	for _, sc := range setCookies {
+		resp.HTTPHeader().Add("Set-Cookie", sc)
+	}
+	ctx.SetOutputResponse(resp)
+
+	assert.Equal(t, "", cp.Handle(ctx))
+	return resp.HTTPHeader().Values("Set-Cookie")
+}
+
+// handleRequest runs the filter on a request with the cookies.
+func handleRequest(cp *CookieProtector, cookie string) (*context.Context, string) {
+	ctx := context.New(nil)
+	stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	stdr.Header.Set("Cookie", cookie)
+	req, _ := httpprot.NewRequest(stdr)
+	ctx.SetInputRequest(req)
+	return ctx, cp.Handle(ctx)
+}
+
+// cookieValue returns the value of the protected cookie in the Set-Cookie
+// header.
+func cookieValue(setCookie string) string {
+	pair, _, _ := strings.Cut(setCookie, ";")
+	_, v, _ := strings.Cut(pair, "=")
+	return v
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	invalid := []string{
+		"kind: CookieProtector\nname: cp\ncookies: [session]",
+		"kind: CookieProtector\nname: cp\nkeys:\n- id: k1\n  secret: " + secret1,
+		"kind: CookieProtector\nname: cp\ncookies: [session]\nkeys:\n- id: k1\n  secret: abc",
+		"kind: CookieProtector\nname: cp\ncookies: [session]\nkeys:\n- id: k1\n  secret: YWJj",
+		"kind: CookieProtector\nname: cp\ncookies: [session]\nkeys:\n- id: k.1\n  secret: " + secret1,
+		"kind: CookieProtector\nname: cp\ncookies: [session]\nkeys:\n- id: k1\n  secret: " + secret1 + "\n- id: k1\n  secret: " + secret2,
+		"kind: CookieProtector\nname: cp\ncookies: [session]\nactiveKeyID: k2\nkeys:\n- id: k1\n  secret: " + secret1,
+		"kind: CookieProtector\nname: cp\ncookies: [' ']\nkeys:\n- id: k1\n  secret: " + secret1,
+	}
+	for _, c := range invalid {
+		rawSpec := make(map[string]interface{})
+		codectool.MustUnmarshal([]byte(c), &rawSpec)
+		_, err := filters.NewSpec(nil, "", rawSpec)
+		assert.Error(err, c)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	assert := assert.New(t)
+
+	cp := createCookieProtector(t, `
+kind: CookieProtector
+name: cp
+cookies: [session]
+keys:
+- id: k1
+  secret: `+secret1)
+	assert.Equal(kind, cp.Kind())
+	assert.Equal("cp", cp.Name())
+
+	setCookies := protectResponse(t, cp,
+		"session=alice; Path=/; HttpOnly",
+		"theme=dark",
+		"session=; Max-Age=0",
+	)
+	assert.Len(setCookies, 3)
+	assert.True(strings.HasPrefix(setCookies[0], "session=k1."))
+	assert.True(strings.HasSuffix(setCookies[0], "; Path=/; HttpOnly"))
+	assert.NotContains(setCookies[0], "alice")
+	assert.Equal("theme=dark", setCookies[1])
+	assert.Equal("session=; Max-Age=0", setCookies[2])
+
+	value := cookieValue(setCookies[0])
+	ctx, result := handleRequest(cp, "theme=dark; session="+value)
+	assert.Equal("", result)
+	assert.Equal("theme=dark; session=alice", ctx.GetInputRequest().(*httpprot.Request).HTTPHeader().Get("Cookie"))
+
+	// the value of a cookie can't be used as another one.
+	cp2 := createCookieProtector(t, `
+kind: CookieProtector
+name: cp
+cookies: [session, user]
+keys:
+- id: k1
+  secret: `+secret1)
+	ctx, result = handleRequest(cp2, "user="+value)
+	assert.Equal("", result)
+	assert.Equal("", ctx.GetInputRequest().(*httpprot.Request).HTTPHeader().Get("Cookie"))
+
+	// tampered
+	tampered := value[:len(value)-2] + "AA"
+	if tampered == value {
+		tampered = value[:len(value)-2] + "BB"
+	}
+	ctx, result = handleRequest(cp, "session="+tampered+"; theme=dark")
+	assert.Equal("", result)
+	assert.Equal("theme=dark", ctx.GetInputRequest().(*httpprot.Request).HTTPHeader().Get("Cookie"))
+
+	for _, v := range []string{"alice", "k2." + value[3:], "k1.!!!", "k1.AA"} {
+		ctx, result = handleRequest(cp, "session="+v)
+		assert.Equal("", result)
+		assert.Equal("", ctx.GetInputRequest().(*httpprot.Request).HTTPHeader().Get("Cookie"))
+	}
+
+	status := cp.Status().(*Status)
+	assert.Equal(uint64(1), status.Protected)
+	assert.Equal(uint64(1), status.Verified)
+	assert.Equal(uint64(5), status.Tampered)
+
+	// requests without protected cookies are not changed.
+	ctx, _ = handleRequest(cp, "theme=dark;  lang=en")
+	assert.Equal("theme=dark;  lang=en", ctx.GetInputRequest().(*httpprot.Request).HTTPHeader().Get("Cookie"))
+
+	cp.Inherit(cp)
+	cp.Close()
+}
+
+func TestSign(t *testing.T) {
+	assert := assert.New(t)
+
+	cp := createCookieProtector(t, `
+kind: CookieProtector
+name: cp
+mode: sign
+onTampered: reject
+cookies: [session]
+keys:
+- id: k1
+  secret: `+secret1)
+
+	setCookies := protectResponse(t, cp, `session="a.b"; Secure`)
+	value := cookieValue(setCookies[0])
+	assert.True(strings.HasPrefix(value, "a.b.k1."))
+	assert.True(strings.HasSuffix(setCookies[0], "; Secure"))
+
+	ctx, result := handleRequest(cp, "session="+value)
+	assert.Equal("", result)
+	assert.Equal("session=a.b", ctx.GetInputRequest().(*httpprot.Request).HTTPHeader().Get("Cookie"))
+
+	for _, v := range []string{"a.c" + value[3:], "a.b", "nodot", "a.b.k2" + value[6:]} {
+		ctx, result = handleRequest(cp, "session="+v)
+		assert.Equal(resultTampered, result, v)
+		assert.Equal(http.StatusBadRequest, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	assert := assert.New(t)
+
+	old := createCookieProtector(t, `
+kind: CookieProtector
+name: cp
+cookies: [session]
+keys:
+- id: k1
+  secret: `+secret1)
+	oldValue := cookieValue(protectResponse(t, old, "session=alice")[0])
+
+	cp := createCookieProtector(t, `
+kind: CookieProtector
+name: cp
+cookies: [session]
+activeKeyID: k2
+keys:
+- id: k1
+  secret: `+secret1+`
+- id: k2
+  secret: `+secret2)
+	newValue := cookieValue(protectResponse(t, cp, "session=bob")[0])
+	assert.True(strings.HasPrefix(newValue, "k2."))
+
+	ctx, _ := handleRequest(cp, "session="+oldValue)
+	assert.Equal("session=alice", ctx.GetInputRequest().(*httpprot.Request).HTTPHeader().Get("Cookie"))
+	ctx, _ = handleRequest(cp, "session="+newValue)
+	assert.Equal("session=bob", ctx.GetInputRequest().(*httpprot.Request).HTTPHeader().Get("Cookie"))
+
+	// the old key is removed
+	ctx, _ = handleRequest(old, "session="+newValue)
+	assert.Equal("", ctx.GetInputRequest().(*httpprot.Request).HTTPHeader().Get("Cookie"))
+}
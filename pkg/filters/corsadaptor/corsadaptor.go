@@ -19,13 +19,17 @@
 package corsadaptor
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 
 	"github.com/rs/cors"
 
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/builder"
+	"github.com/megaease/easegress/v2/pkg/logger"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
 )
 
@@ -41,6 +45,7 @@ var kind = &filters.Kind{
 	Name:        Kind,
 	Description: "CORSAdaptor adapts CORS stuff.",
 	Results:     []string{resultPreflighted, resultRejected},
+	RequestOnly: true,
 	DefaultSpec: func() filters.Spec {
 		return &Spec{}
 	},
@@ -58,6 +63,9 @@ type (
 	CORSAdaptor struct {
 		spec *Spec
 		cors *cors.Cors
+
+		key      *builder.Template
+		policies *policyCache
 	}
 
 	// Spec describes of CORSAdaptor.
@@ -70,9 +78,28 @@ type (
 		AllowCredentials bool     `json:"allowCredentials,omitempty"`
 		ExposedHeaders   []string `json:"exposedHeaders,omitempty"`
 		MaxAge           int      `json:"maxAge,omitempty"`
+		// Dynamic computes the policy of requests at request time, the
+		// static fields above are the default policy.
+		Dynamic *DynamicSpec `json:"dynamic,omitempty"`
+	}
+
+	// Status is the status of CORSAdaptor.
+	Status struct {
+		Dynamic *DynamicStatus `json:"dynamic,omitempty"`
 	}
 )
 
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if spec.Dynamic == nil {
+		return nil
+	}
+	if _, err := builder.NewTemplate(spec.Dynamic.Key); err != nil {
+		return fmt.Errorf("invalid key of dynamic: %v", err)
+	}
+	return nil
+}
+
 // Name returns the name of the CORSAdaptor filter instance.
 func (a *CORSAdaptor) Name() string {
 	return a.spec.Name()
@@ -107,6 +134,37 @@ func (a *CORSAdaptor) reload() {
 		ExposedHeaders:   a.spec.ExposedHeaders,
 		MaxAge:           a.spec.MaxAge,
 	})
+
+	if d := a.spec.Dynamic; d != nil {
+		a.key = builder.MustNewTemplate(d.Key)
+		a.policies = newPolicyCache(d)
+	}
+}
+
+// getCors returns the CORS handler of the request, it falls back to the
+// default policy if the request has no key or the key has no policy.
+func (a *CORSAdaptor) getCors(ctx *context.Context) *cors.Cors {
+	if a.policies == nil {
+		return a.cors
+	}
+
+	key, err := a.key.Render(ctx)
+	if err != nil {
+		logger.Debugf("%s: failed to render key: %v", a.Name(), err)
+	}
+
+	var c *cors.Cors
+	if key != "" {
+		c, err = a.policies.get(key)
+		if err != nil {
+			logger.Warnf("%s: failed to look up policy of %s: %v", a.Name(), key, err)
+		}
+	}
+	if c == nil {
+		atomic.AddUint64(&a.policies.defaulted, 1)
+		return a.cors
+	}
+	return c
 }
 
 // Handle handles cross-origin requests.
@@ -122,7 +180,7 @@ func (a *CORSAdaptor) Handle(ctx *context.Context) string {
 	isPreflight = isPreflight && (req.Method() == http.MethodOptions)
 
 	rw := httptest.NewRecorder()
-	a.cors.HandlerFunc(rw, req.Std())
+	a.getCors(ctx).HandlerFunc(rw, req.Std())
 
 	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
 	if resp == nil {
@@ -153,7 +211,10 @@ func (a *CORSAdaptor) Handle(ctx *context.Context) string {
 
 // Status return status.
 func (a *CORSAdaptor) Status() interface{} {
-	return nil
+	if a.policies == nil {
+		return nil
+	}
+	return &Status{Dynamic: a.policies.status()}
 }
 
 // Close closes CORSAdaptor.
@@ -19,15 +19,21 @@ package corsadaptor
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
 	"github.com/megaease/easegress/v2/pkg/util/codectool"
 	"github.com/stretchr/testify/assert"
 )
 
+func init() {
+	logger.InitNop()
+}
+
 func setRequest(t *testing.T, ctx *context.Context, stdReq *http.Request) {
 	req, err := httpprot.NewRequest(stdReq)
 	assert.Nil(t, err)
@@ -131,3 +137,91 @@ allowedOrigins:
 		}
 	})
 }
+
+func TestCORSAdaptorDynamic(t *testing.T) {
+	assert := assert.New(t)
+
+	lookups := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		switch r.URL.Path {
+		case "/tenants/t2/cors":
+			w.Write([]byte(`{"allowedOrigins": ["https://t2.example.com"]}`))
+		case "/tenants/broken/cors":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	yamlConfig := `
+kind: CORSAdaptor
+name: cors
+allowedOrigins:
+- https://default.example.com
+dynamic:
+  key: '{{header .req.Header "X-Tenant"}}'
+  policies:
+  - keys: [t1]
+    allowedOrigins: [https://t1.example.com]
+    allowedMethods: [GET, PUT]
+  lookup:
+    url: ` + server.URL + `/tenants/{key}/cors
+`
+	rawSpec := make(map[string]interface{})
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	assert.NoError(err)
+	cors := kind.CreateInstance(spec)
+	cors.Init()
+	defer cors.Close()
+
+	handle := func(method, tenant, origin string) (string, http.Header) {
+		req, _ := http.NewRequest(method, "http://example.com/", nil)
+		req.Header.Set("Origin", origin)
+		if tenant != "" {
+			req.Header.Set("X-Tenant", tenant)
+		}
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+		}
+		ctx := context.New(nil)
+		setRequest(t, ctx, req)
+		result := cors.Handle(ctx)
+		return result, ctx.GetOutputResponse().(*httpprot.Response).HTTPHeader()
+	}
+
+	// static policy of the tenant.
+	result, h := handle(http.MethodOptions, "t1", "https://t1.example.com")
+	assert.Equal(resultPreflighted, result)
+	assert.Equal("https://t1.example.com", h.Get("Access-Control-Allow-Origin"))
+	assert.Equal(http.MethodPut, h.Get("Access-Control-Allow-Methods"))
+	result, _ = handle(http.MethodGet, "t1", "https://default.example.com")
+	assert.Equal(resultRejected, result)
+
+	// policy from the lookup, it is cached.
+	result, _ = handle(http.MethodGet, "t2", "https://t2.example.com")
+	assert.Equal("", result)
+	result, _ = handle(http.MethodGet, "t2", "https://t1.example.com")
+	assert.Equal(resultRejected, result)
+	assert.Equal(1, lookups)
+
+	// the default policy.
+	result, _ = handle(http.MethodGet, "", "https://default.example.com")
+	assert.Equal("", result)
+	result, _ = handle(http.MethodGet, "t3", "https://default.example.com")
+	assert.Equal("", result)
+	result, _ = handle(http.MethodGet, "broken", "https://default.example.com")
+	assert.Equal("", result)
+	assert.Equal(3, lookups)
+
+	status := cors.Status().(*Status).Dynamic
+	assert.Equal(3, status.CachedKeys)
+	assert.Equal(uint64(1), status.LookupErrors)
+	assert.Equal(uint64(3), status.Defaulted)
+
+	rawSpec["dynamic"] = map[string]interface{}{"key": "{{.data.TENANT_ID}}", "policies": []interface{}{map[string]interface{}{}}}
+	_, err = filters.NewSpec(nil, "", rawSpec)
+	assert.Error(err)
+}
@@ -0,0 +1,359 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package corsadaptor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/cors"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/megaease/easegress/v2/pkg/util/fasttime"
+)
+
+const (
+	keyPlaceholder = "{key}"
+
+	defaultLookupTimeout = 3 * time.Second
+	defaultCacheTTL      = time.Minute
+	defaultMaxCacheSize  = 10000
+)
+
+// errPolicyNotFound is returned by a PolicySource if there's no policy of
+// the key, the default policy is used for the key.
+var errPolicyNotFound = errors.New("policy not found")
+
+type (
+	// DynamicSpec describes how to get the CORS policy of a request at
+	// request time.
+	DynamicSpec struct {
+		// Key is a template rendering the key of the policy, e.g.
+		// '{{.data.TENANT_ID}}'. The default policy is used if the key
+		// is empty.
+		Key string `json:"key" jsonschema:"required"`
+		// Policies are the policies of known keys.
+		Policies []*Policy `json:"policies,omitempty"`
+		// Lookup looks up the policies of keys not in Policies from an
+		// external service.
+		Lookup *LookupSpec `json:"lookup,omitempty"`
+		// CacheTTL is the time to cache the policies from the lookup.
+		CacheTTL string `json:"cacheTTL,omitempty" jsonschema:"format=duration"`
+		// MaxCacheSize is the max number of keys to cache.
+		MaxCacheSize int `json:"maxCacheSize,omitempty" jsonschema:"minimum=0"`
+	}
+
+	// Policy is the CORS policy of a key.
+	Policy struct {
+		// Keys are the keys of the policy, they are required for policies
+		// in the spec, and ignored for policies from the lookup.
+		Keys             []string `json:"keys,omitempty"`
+		AllowedOrigins   []string `json:"allowedOrigins,omitempty"`
+		AllowedMethods   []string `json:"allowedMethods,omitempty" jsonschema:"uniqueItems=true,format=httpmethod-array"`
+		AllowedHeaders   []string `json:"allowedHeaders,omitempty"`
+		AllowCredentials bool     `json:"allowCredentials,omitempty"`
+		ExposedHeaders   []string `json:"exposedHeaders,omitempty"`
+		MaxAge           int      `json:"maxAge,omitempty"`
+	}
+
+	// LookupSpec describes the service to look up the policies. The
+	// service responds a Policy in JSON with status code 200, or 404 if
+	// there's no policy of the key.
+	LookupSpec struct {
+		// URL is the URL of the service, "{key}" in it is replaced by
+		// the escaped key.
+		URL     string            `json:"url" jsonschema:"required"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Timeout string            `json:"timeout,omitempty" jsonschema:"format=duration"`
+	}
+
+	// PolicySource is the source of the policies.
+	PolicySource interface {
+		// Policy returns the policy of the key, it returns
+		// errPolicyNotFound if there's no policy of the key.
+		Policy(key string) (*Policy, error)
+	}
+
+	// DynamicStatus is the status of the dynamic policies.
+	DynamicStatus struct {
+		CachedKeys   int    `json:"cachedKeys"`
+		CacheHits    uint64 `json:"cacheHits"`
+		Lookups      uint64 `json:"lookups"`
+		LookupErrors uint64 `json:"lookupErrors"`
+		Defaulted    uint64 `json:"defaulted"`
+	}
+
+	staticSource map[string]*Policy
+
+	httpSource struct {
+		spec   *LookupSpec
+		client *http.Client
+	}
+
+	// policyCache caches the CORS handlers of keys, so that a preflight
+	// request needs no lookup in most cases.
+	policyCache struct {
+		source  PolicySource
+		ttl     time.Duration
+		maxSize int
+		group   singleflight.Group
+
+		mu      sync.RWMutex
+		entries map[string]*cacheEntry
+
+		cacheHits    uint64
+		lookups      uint64
+		lookupErrors uint64
+		defaulted    uint64
+	}
+
+	cacheEntry struct {
+		// cors is nil if there's no policy of the key.
+		cors    *cors.Cors
+		expires time.Time
+	}
+)
+
+// Validate validates the DynamicSpec.
+func (spec *DynamicSpec) Validate() error {
+	if spec.CacheTTL != "" {
+		if d, err := time.ParseDuration(spec.CacheTTL); err != nil || d <= 0 {
+			return fmt.Errorf("invalid cacheTTL %q", spec.CacheTTL)
+		}
+	}
+
+	keys := map[string]bool{}
+	for _, p := range spec.Policies {
+		if len(p.Keys) == 0 {
+			return fmt.Errorf("keys of policy are required")
+		}
+		for _, k := range p.Keys {
+			if keys[k] {
+				return fmt.Errorf("duplicated key %s", k)
+			}
+			keys[k] = true
+		}
+	}
+	return nil
+}
+
+// Validate validates the LookupSpec.
+func (spec *LookupSpec) Validate() error {
+	u, err := url.Parse(spec.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid url %q", spec.URL)
+	}
+	if spec.Timeout != "" {
+		if d, err := time.ParseDuration(spec.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q", spec.Timeout)
+		}
+	}
+	return nil
+}
+
+func (p *Policy) newCors() *cors.Cors {
+	return cors.New(cors.Options{
+		AllowedOrigins:   p.AllowedOrigins,
+		AllowedMethods:   p.AllowedMethods,
+		AllowedHeaders:   p.AllowedHeaders,
+		AllowCredentials: p.AllowCredentials,
+		ExposedHeaders:   p.ExposedHeaders,
+		MaxAge:           p.MaxAge,
+	})
+}
+
+func newStaticSource(policies []*Policy) staticSource {
+	s := staticSource{}
+	for _, p := range policies {
+		for _, k := range p.Keys {
+			s[k] = p
+		}
+	}
+	return s
+}
+
+// Policy implements PolicySource.
+func (s staticSource) Policy(key string) (*Policy, error) {
+	if p := s[key]; p != nil {
+		return p, nil
+	}
+	return nil, errPolicyNotFound
+}
+
+func newHTTPSource(spec *LookupSpec) *httpSource {
+	timeout := defaultLookupTimeout
+	if spec.Timeout != "" {
+		timeout, _ = time.ParseDuration(spec.Timeout)
+	}
+	return &httpSource{spec: spec, client: &http.Client{Timeout: timeout}}
+}
+
+// Policy implements PolicySource.
+func (s *httpSource) Policy(key string) (*Policy, error) {
+	u := strings.ReplaceAll(s.spec.URL, keyPlaceholder, url.PathEscape(key))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range s.spec.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, errPolicyNotFound
+	default:
+		return nil, fmt.Errorf("status code %d: %s", resp.StatusCode, body)
+	}
+
+	p := &Policy{}
+	if err = codectool.UnmarshalJSON(body, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// chainSource looks up the policy from the sources in order.
+type chainSource []PolicySource
+
+// Policy implements PolicySource.
+func (cs chainSource) Policy(key string) (*Policy, error) {
+	for _, s := range cs {
+		p, err := s.Policy(key)
+		if err != errPolicyNotFound {
+			return p, err
+		}
+	}
+	return nil, errPolicyNotFound
+}
+
+func newPolicyCache(spec *DynamicSpec) *policyCache {
+	source := chainSource{newStaticSource(spec.Policies)}
+	if spec.Lookup != nil {
+		source = append(source, newHTTPSource(spec.Lookup))
+	}
+
+	pc := &policyCache{
+		source:  source,
+		ttl:     defaultCacheTTL,
+		maxSize: spec.MaxCacheSize,
+		entries: map[string]*cacheEntry{},
+	}
+	if spec.CacheTTL != "" {
+		pc.ttl, _ = time.ParseDuration(spec.CacheTTL)
+	}
+	if pc.maxSize == 0 {
+		pc.maxSize = defaultMaxCacheSize
+	}
+	return pc
+}
+
+// get returns the CORS handler of the key, it returns nil if the default
+// policy should be used.
+func (pc *policyCache) get(key string) (*cors.Cors, error) {
+	now := fasttime.Now()
+
+	pc.mu.RLock()
+	entry := pc.entries[key]
+	pc.mu.RUnlock()
+	if entry != nil && now.Before(entry.expires) {
+		atomic.AddUint64(&pc.cacheHits, 1)
+		return entry.cors, nil
+	}
+
+	v, err, _ := pc.group.Do(key, func() (interface{}, error) {
+		atomic.AddUint64(&pc.lookups, 1)
+		p, err := pc.source.Policy(key)
+		if err != nil && err != errPolicyNotFound {
+			return nil, err
+		}
+
+		e := &cacheEntry{expires: fasttime.Now().Add(pc.ttl)}
+		if p != nil {
+			e.cors = p.newCors()
+		}
+		pc.put(key, e)
+		return e, nil
+	})
+
+	if err != nil {
+		atomic.AddUint64(&pc.lookupErrors, 1)
+		// a stale policy is better than the default one.
+		if entry != nil {
+			return entry.cors, err
+		}
+		return nil, err
+	}
+	return v.(*cacheEntry).cors, nil
+}
+
+func (pc *policyCache) put(key string, e *cacheEntry) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if _, ok := pc.entries[key]; !ok && len(pc.entries) >= pc.maxSize {
+		now := fasttime.Now()
+		for k, v := range pc.entries {
+			if now.After(v.expires) {
+				delete(pc.entries, k)
+			}
+		}
+		// still full, evict a random one.
+		for k := range pc.entries {
+			if len(pc.entries) < pc.maxSize {
+				break
+			}
+			delete(pc.entries, k)
+		}
+	}
+	pc.entries[key] = e
+}
+
+func (pc *policyCache) status() *DynamicStatus {
+	pc.mu.RLock()
+	n := len(pc.entries)
+	pc.mu.RUnlock()
+
+	return &DynamicStatus{
+		CachedKeys:   n,
+		CacheHits:    atomic.LoadUint64(&pc.cacheHits),
+		Lookups:      atomic.LoadUint64(&pc.lookups),
+		LookupErrors: atomic.LoadUint64(&pc.lookupErrors),
+		Defaulted:    atomic.LoadUint64(&pc.defaulted),
+	}
+}
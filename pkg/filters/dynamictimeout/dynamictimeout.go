@@ -0,0 +1,304 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package dynamictimeout implements a filter which sets the timeout of
+// requests to backends by request attributes.
+package dynamictimeout
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/builder"
+	"github.com/megaease/easegress/v2/pkg/filters/proxies"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of DynamicTimeout.
+	Kind = "DynamicTimeout"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "DynamicTimeout sets the timeout of requests to backends by request attributes.",
+	Results:     []string{},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &DynamicTimeout{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// DynamicTimeout is the filter DynamicTimeout.
+	DynamicTimeout struct {
+		spec *Spec
+
+		template       *builder.Template
+		rules          []*rule
+		defaultTimeout time.Duration
+		minTimeout     time.Duration
+		maxTimeout     time.Duration
+
+		applied uint64
+		clamped uint64
+		invalid uint64
+	}
+
+	// Spec is the spec of DynamicTimeout.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Template renders the timeout in the format of Go durations,
+		// like 1.5s, it has a higher priority than the rules.
+		Template       string  `json:"template,omitempty"`
+		Rules          []*Rule `json:"rules,omitempty"`
+		DefaultTimeout string  `json:"defaultTimeout,omitempty" jsonschema:"format=duration"`
+		MinTimeout     string  `json:"minTimeout,omitempty" jsonschema:"format=duration"`
+		MaxTimeout     string  `json:"maxTimeout,omitempty" jsonschema:"format=duration"`
+	}
+
+	// Rule is the timeout of the requests matching all its conditions.
+	Rule struct {
+		Methods []string                             `json:"methods,omitempty"`
+		Paths   []*stringtool.StringMatcher          `json:"paths,omitempty"`
+		Headers map[string]*stringtool.StringMatcher `json:"headers,omitempty"`
+		Timeout string                               `json:"timeout" jsonschema:"required,format=duration"`
+	}
+
+	// Status is the status of DynamicTimeout.
+	Status struct {
+		Applied uint64 `json:"applied"`
+		Clamped uint64 `json:"clamped"`
+		Invalid uint64 `json:"invalid"`
+	}
+
+	rule struct {
+		spec    *Rule
+		timeout time.Duration
+	}
+)
+
+// parsePositiveDuration parses a positive duration, an empty string is
+// parsed to 0.
+func parsePositiveDuration(name, s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", name, s, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s %q must be positive", name, s)
+	}
+	return d, nil
+}
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if spec.Template == "" && len(spec.Rules) == 0 && spec.DefaultTimeout == "" {
+		return fmt.Errorf("at least one of template, rules and defaultTimeout must be specified")
+	}
+	if spec.Template != "" {
+		if _, err := builder.NewTemplate(spec.Template); err != nil {
+			return fmt.Errorf("invalid template: %v", err)
+		}
+	}
+
+	if _, err := parsePositiveDuration("defaultTimeout", spec.DefaultTimeout); err != nil {
+		return err
+	}
+	min, err := parsePositiveDuration("minTimeout", spec.MinTimeout)
+	if err != nil {
+		return err
+	}
+	max, err := parsePositiveDuration("maxTimeout", spec.MaxTimeout)
+	if err != nil {
+		return err
+	}
+	if min > 0 && max > 0 && min > max {
+		return fmt.Errorf("minTimeout %s is larger than maxTimeout %s", spec.MinTimeout, spec.MaxTimeout)
+	}
+	return nil
+}
+
+// Validate validates the rule.
+func (r *Rule) Validate() error {
+	_, err := parsePositiveDuration("timeout", r.Timeout)
+	return err
+}
+
+// Name returns the name of the DynamicTimeout filter instance.
+func (dt *DynamicTimeout) Name() string {
+	return dt.spec.Name()
+}
+
+// Kind returns the kind of DynamicTimeout.
+func (dt *DynamicTimeout) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the DynamicTimeout
+func (dt *DynamicTimeout) Spec() filters.Spec {
+	return dt.spec
+}
+
+// Init initializes DynamicTimeout.
+func (dt *DynamicTimeout) Init() {
+	dt.reload()
+}
+
+// Inherit inherits previous generation of DynamicTimeout.
+func (dt *DynamicTimeout) Inherit(previousGeneration filters.Filter) {
+	dt.reload()
+}
+
+func (dt *DynamicTimeout) reload() {
+	if dt.spec.Template != "" {
+		dt.template = builder.MustNewTemplate(dt.spec.Template)
+	}
+
+	dt.rules = nil
+	for _, spec := range dt.spec.Rules {
+		for _, p := range spec.Paths {
+			p.Init()
+		}
+		for _, h := range spec.Headers {
+			h.Init()
+		}
+		timeout, _ := time.ParseDuration(spec.Timeout)
+		dt.rules = append(dt.rules, &rule{spec: spec, timeout: timeout})
+	}
+
+	// the durations are validated.
+	dt.defaultTimeout, _ = parsePositiveDuration("defaultTimeout", dt.spec.DefaultTimeout)
+	dt.minTimeout, _ = parsePositiveDuration("minTimeout", dt.spec.MinTimeout)
+	dt.maxTimeout, _ = parsePositiveDuration("maxTimeout", dt.spec.MaxTimeout)
+}
+
+func (r *rule) match(req *httpprot.Request) bool {
+	if len(r.spec.Methods) > 0 && !stringtool.StrInSlice(req.Method(), r.spec.Methods) {
+		return false
+	}
+
+	for name, m := range r.spec.Headers {
+		if !m.Match(req.HTTPHeader().Get(name)) {
+			return false
+		}
+	}
+
+	if len(r.spec.Paths) == 0 {
+		return true
+	}
+	for _, p := range r.spec.Paths {
+		if p.Match(req.Path()) {
+			return true
+		}
+	}
+	return false
+}
+
+// renderTimeout renders the timeout by the template, it returns 0 if the
+// result is empty or invalid.
+func (dt *DynamicTimeout) renderTimeout(ctx *context.Context) time.Duration {
+	s, err := dt.template.Render(ctx)
+	if err != nil {
+		logger.Warnf("%s: failed to render timeout: %v", dt.Name(), err)
+		atomic.AddUint64(&dt.invalid, 1)
+		return 0
+	}
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		ctx.AddTag(fmt.Sprintf("dynamicTimeout: invalid timeout %q", s))
+		atomic.AddUint64(&dt.invalid, 1)
+		return 0
+	}
+	return d
+}
+
+// timeout returns the timeout of the request, it returns 0 if there's no
+// timeout for the request.
+func (dt *DynamicTimeout) timeout(ctx *context.Context) time.Duration {
+	if dt.template != nil {
+		if d := dt.renderTimeout(ctx); d > 0 {
+			return d
+		}
+	}
+
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	for _, r := range dt.rules {
+		if r.match(req) {
+			return r.timeout
+		}
+	}
+
+	return dt.defaultTimeout
+}
+
+// Handle sets the timeout of the request to the context data, which is
+// honored by the proxies.
+func (dt *DynamicTimeout) Handle(ctx *context.Context) string {
+	d := dt.timeout(ctx)
+	if d <= 0 {
+		return ""
+	}
+
+	if dt.minTimeout > 0 && d < dt.minTimeout {
+		d = dt.minTimeout
+		atomic.AddUint64(&dt.clamped, 1)
+	} else if dt.maxTimeout > 0 && d > dt.maxTimeout {
+		d = dt.maxTimeout
+		atomic.AddUint64(&dt.clamped, 1)
+	}
+
+	atomic.AddUint64(&dt.applied, 1)
+	ctx.SetData(proxies.TimeoutKey, d)
+	return ""
+}
+
+// Status returns status.
+func (dt *DynamicTimeout) Status() interface{} {
+	return &Status{
+		Applied: atomic.LoadUint64(&dt.applied),
+		Clamped: atomic.LoadUint64(&dt.clamped),
+		Invalid: atomic.LoadUint64(&dt.invalid),
+	}
+}
+
+// Close closes DynamicTimeout.
+func (dt *DynamicTimeout) Close() {
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dynamictimeout
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/proxies"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newSpec(yamlConfig string) (filters.Spec, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	return filters.NewSpec(nil, "", rawSpec)
+}
+
+func newTestFilter(yamlConfig string) *DynamicTimeout {
+	spec, err := newSpec(yamlConfig)
+	if err != nil {
+		panic(err)
+	}
+	dt := kind.CreateInstance(spec).(*DynamicTimeout)
+	dt.Init()
+	return dt
+}
+
+func newContext(method, path string, header map[string]string) *context.Context {
+	stdr, _ := http.NewRequest(method, "http://127.0.0.1"+path, nil)
+	for k, v := range header {
+		stdr.Header.Set(k, v)
+	}
+	req, _ := httpprot.NewRequest(stdr)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func timeoutOf(ctx *context.Context) time.Duration {
+	d, _ := ctx.GetData(proxies.TimeoutKey).(time.Duration)
+	return d
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, yamlConfig := range []string{`
+kind: DynamicTimeout
+name: dt
+`, `
+kind: DynamicTimeout
+name: dt
+defaultTimeout: -1s
+`, `
+kind: DynamicTimeout
+name: dt
+defaultTimeout: 1s
+minTimeout: 10s
+maxTimeout: 5s
+`, `
+kind: DynamicTimeout
+name: dt
+rules:
+- timeout: 0s
+`, `
+kind: DynamicTimeout
+name: dt
+template: '{{.req'
+`} {
+		_, err := newSpec(yamlConfig)
+		assert.Error(err, yamlConfig)
+	}
+}
+
+func TestDynamicTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	dt := newTestFilter(`
+kind: DynamicTimeout
+name: dt
+template: '{{.req.Header.Get "X-Timeout"}}'
+rules:
+- methods: [GET]
+  paths:
+  - prefix: /search
+  timeout: 30s
+- paths:
+  - exact: /healthz
+  timeout: 1s
+- headers:
+    X-Priority:
+      exact: low
+  timeout: 2m
+defaultTimeout: 5s
+minTimeout: 500ms
+maxTimeout: 60s
+`)
+	assert.Equal(kind, dt.Kind())
+	assert.Equal("dt", dt.Name())
+
+	for _, c := range []struct {
+		method  string
+		path    string
+		header  map[string]string
+		timeout time.Duration
+	}{
+		{http.MethodGet, "/search/books", nil, 30 * time.Second},
+		{http.MethodPost, "/search/books", nil, 5 * time.Second},
+		{http.MethodGet, "/healthz", nil, time.Second},
+		{http.MethodGet, "/", map[string]string{"X-Timeout": "1.5s"}, 1500 * time.Millisecond},
+		{http.MethodGet, "/healthz", map[string]string{"X-Timeout": "100ms"}, 500 * time.Millisecond},
+		{http.MethodGet, "/", map[string]string{"X-Priority": "low"}, 60 * time.Second},
+		{http.MethodGet, "/search", map[string]string{"X-Timeout": "abc"}, 30 * time.Second},
+	} {
+		ctx := newContext(c.method, c.path, c.header)
+		assert.Empty(dt.Handle(ctx))
+		assert.Equal(c.timeout, timeoutOf(ctx), "%+v", c)
+	}
+
+	status := dt.Status().(*Status)
+	assert.Equal(uint64(7), status.Applied)
+	assert.Equal(uint64(2), status.Clamped)
+	assert.Equal(uint64(1), status.Invalid)
+
+	// no timeout is set if nothing matches.
+	dt = newTestFilter(`
+kind: DynamicTimeout
+name: dt
+rules:
+- paths:
+  - exact: /healthz
+  timeout: 1s
+`)
+	ctx := newContext(http.MethodGet, "/", nil)
+	dt.Handle(ctx)
+	assert.Nil(ctx.GetData(proxies.TimeoutKey))
+}
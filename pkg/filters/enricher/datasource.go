@@ -0,0 +1,226 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package enricher
+
+import (
+	stdcontext "context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/cluster/customdata"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+const (
+	// DataSourceStatic looks up the keys in the spec.
+	DataSourceStatic = "static"
+	// DataSourceCustomData looks up the keys in the custom data of the
+	// cluster, the key is the ID of the custom data.
+	DataSourceCustomData = "customData"
+	// DataSourceHTTP looks up the keys from an external HTTP service.
+	DataSourceHTTP = "http"
+
+	defaultHTTPTimeout = time.Second
+	maxHTTPBodySize    = 1024 * 1024
+)
+
+type (
+	// DataSource looks up the values of keys.
+	DataSource interface {
+		// Lookup returns the values of the key, it returns nil if the key
+		// is not found.
+		Lookup(ctx stdcontext.Context, key string) (map[string]interface{}, error)
+	}
+
+	// DataSourceCreator creates a DataSource from the spec, super is nil
+	// if the filter is not running in a supervisor.
+	DataSourceCreator func(spec *DataSourceSpec, super *supervisor.Supervisor) (DataSource, error)
+
+	// DataSourceSpec is the spec of the data source.
+	DataSourceSpec struct {
+		// Kind is the kind of the data source, which is static,
+		// customData, http or a kind registered by RegisterDataSource.
+		Kind string `json:"kind" jsonschema:"required"`
+		// Static is the values of the keys for kind static.
+		Static map[string]map[string]interface{} `json:"static,omitempty"`
+		// CustomDataKind is the kind of the custom data for kind customData.
+		CustomDataKind string `json:"customDataKind,omitempty"`
+		// URL is the URL of the service for kind http, "{key}" in it is
+		// replaced by the escaped key. The service responds a JSON object
+		// of the values, or 404 if the key is not found.
+		URL string `json:"url,omitempty"`
+		// Headers are the headers of the requests to the service.
+		Headers map[string]string `json:"headers,omitempty"`
+		Timeout string            `json:"timeout,omitempty" jsonschema:"format=duration"`
+		// Options are the options of the registered kinds.
+		Options map[string]string `json:"options,omitempty"`
+	}
+
+	staticDataSource struct {
+		data map[string]map[string]interface{}
+	}
+
+	customDataSource struct {
+		store *customdata.Store
+		kind  string
+	}
+
+	httpDataSource struct {
+		spec   *DataSourceSpec
+		client *http.Client
+	}
+)
+
+var (
+	dataSourcesMutex sync.RWMutex
+	dataSources      = map[string]DataSourceCreator{
+		DataSourceStatic:     newStaticDataSource,
+		DataSourceCustomData: newCustomDataSource,
+		DataSourceHTTP:       newHTTPDataSource,
+	}
+)
+
+// RegisterDataSource registers a kind of data source, it panics if the kind
+// has been registered.
+func RegisterDataSource(kind string, creator DataSourceCreator) {
+	dataSourcesMutex.Lock()
+	defer dataSourcesMutex.Unlock()
+
+	if _, ok := dataSources[kind]; ok {
+		panic(fmt.Errorf("data source %s has been registered", kind))
+	}
+	dataSources[kind] = creator
+}
+
+func getDataSourceCreator(kind string) DataSourceCreator {
+	dataSourcesMutex.RLock()
+	defer dataSourcesMutex.RUnlock()
+	return dataSources[kind]
+}
+
+// Validate validates the DataSourceSpec.
+func (spec *DataSourceSpec) Validate() error {
+	if getDataSourceCreator(spec.Kind) == nil {
+		return fmt.Errorf("unknown data source %q", spec.Kind)
+	}
+
+	switch spec.Kind {
+	case DataSourceCustomData:
+		if spec.CustomDataKind == "" {
+			return fmt.Errorf("customDataKind is required by data source customData")
+		}
+	case DataSourceHTTP:
+		if !strings.Contains(spec.URL, keyPlaceholder) {
+			return fmt.Errorf("url of data source http must contain %s", keyPlaceholder)
+		}
+		if _, err := url.Parse(strings.ReplaceAll(spec.URL, keyPlaceholder, "key")); err != nil {
+			return fmt.Errorf("invalid url %q: %v", spec.URL, err)
+		}
+	}
+
+	if spec.Timeout != "" {
+		if d, err := time.ParseDuration(spec.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q", spec.Timeout)
+		}
+	}
+	return nil
+}
+
+func newStaticDataSource(spec *DataSourceSpec, super *supervisor.Supervisor) (DataSource, error) {
+	return &staticDataSource{data: spec.Static}, nil
+}
+
+func (s *staticDataSource) Lookup(ctx stdcontext.Context, key string) (map[string]interface{}, error) {
+	return s.data[key], nil
+}
+
+func newCustomDataSource(spec *DataSourceSpec, super *supervisor.Supervisor) (DataSource, error) {
+	if super == nil || super.Cluster() == nil {
+		return nil, fmt.Errorf("cluster is unavailable")
+	}
+	cls := super.Cluster()
+	return &customDataSource{
+		store: customdata.NewStore(cls, cls.Layout().CustomDataKindPrefix(), cls.Layout().CustomDataPrefix()),
+		kind:  spec.CustomDataKind,
+	}, nil
+}
+
+func (s *customDataSource) Lookup(ctx stdcontext.Context, key string) (map[string]interface{}, error) {
+	data, err := s.store.GetData(s.kind, key)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func newHTTPDataSource(spec *DataSourceSpec, super *supervisor.Supervisor) (DataSource, error) {
+	timeout := defaultHTTPTimeout
+	if spec.Timeout != "" {
+		timeout, _ = time.ParseDuration(spec.Timeout)
+	}
+	return &httpDataSource{
+		spec:   spec,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (s *httpDataSource) Lookup(ctx stdcontext.Context, key string) (map[string]interface{}, error) {
+	u := strings.ReplaceAll(s.spec.URL, keyPlaceholder, url.PathEscape(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.spec.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxHTTPBodySize {
+		return nil, fmt.Errorf("response body exceeds %d bytes", maxHTTPBodySize)
+	}
+
+	values := map[string]interface{}{}
+	if err = codectool.UnmarshalJSONNumber(body, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
@@ -0,0 +1,499 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package enricher implements a filter which looks up a key of the request
+// in a data source, and enriches the request with the values of the key.
+package enricher
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/megaease/easegress/v2/pkg/util/jsonpath"
+)
+
+const (
+	// Kind is the kind of Enricher.
+	Kind = "Enricher"
+
+	// KeySourceHeader reads the key from a header.
+	KeySourceHeader = "header"
+	// KeySourcePath reads the key from the path.
+	KeySourcePath = "path"
+	// KeySourceBody reads the key from a field of the JSON body.
+	KeySourceBody = "body"
+
+	// OnMissingSkip leaves the request unchanged if the key is missing.
+	OnMissingSkip = "skip"
+	// OnMissingDefault enriches the request with the default values if
+	// the key is missing.
+	OnMissingDefault = "default"
+	// OnMissingReject rejects the request if the key is missing.
+	OnMissingReject = "reject"
+
+	resultNotFound = "notFound"
+
+	keyPlaceholder = "{key}"
+
+	defaultCacheTTL    = time.Minute
+	defaultCacheSize   = 1024
+	defaultMaxBodySize = 64 * 1024
+)
+
+var kind = &filters.Kind{
+	Name:              Kind,
+	Description:       "Enricher looks up a key of the request in a data source, and enriches the request with the values of the key.",
+	Results:           []string{resultNotFound},
+	RequestOnly:       true,
+	RequiresBuffering: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{
+			OnMissing:   OnMissingSkip,
+			CacheSize:   defaultCacheSize,
+			MaxBodySize: defaultMaxBodySize,
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &Enricher{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// Enricher is the filter Enricher.
+	Enricher struct {
+		spec *Spec
+
+		source     DataSource
+		pathRegexp *regexp.Regexp
+		bodyPath   jsonpath.Path
+		cacheTTL   time.Duration
+		cache      *lru.Cache
+
+		lookups  uint64
+		hits     uint64
+		notFound uint64
+		errors   uint64
+		rejected uint64
+	}
+
+	// Spec is the spec of Enricher.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		Key        *KeySpec        `json:"key" jsonschema:"required"`
+		DataSource *DataSourceSpec `json:"dataSource" jsonschema:"required"`
+		Values     []*ValueSpec    `json:"values" jsonschema:"required,minItems=1"`
+		// OnMissing is the handling of requests whose key is missing, not
+		// found or failed to look up.
+		OnMissing string `json:"onMissing,omitempty" jsonschema:"enum=skip,enum=default,enum=reject"`
+		// CacheTTL is the time to cache the result of a lookup, including
+		// keys not found, it is 1m by default.
+		CacheTTL  string `json:"cacheTTL,omitempty" jsonschema:"format=duration"`
+		CacheSize int    `json:"cacheSize,omitempty" jsonschema:"minimum=1"`
+		// MaxBodySize is the max size of the body to read the key from or
+		// to enrich, larger bodies are not changed.
+		MaxBodySize int64 `json:"maxBodySize,omitempty" jsonschema:"minimum=1"`
+	}
+
+	// KeySpec is where to read the key from the request.
+	KeySpec struct {
+		Source string `json:"source" jsonschema:"required,enum=header,enum=path,enum=body"`
+		// Header is the header of the key for source header.
+		Header string `json:"header,omitempty"`
+		// PathRegexp matches the path for source path, the key is the
+		// first captured group, or the whole match if there's no group.
+		PathRegexp string `json:"pathRegexp,omitempty"`
+		// BodyPath is the JSONPath of the key for source body, e.g. $.apiKey.
+		BodyPath string `json:"bodyPath,omitempty"`
+	}
+
+	// ValueSpec maps a value of the key to a header or a body field.
+	ValueSpec struct {
+		// Field is the field of the values looked up.
+		Field  string `json:"field" jsonschema:"required"`
+		Header string `json:"header,omitempty"`
+		// BodyField is the field of the JSON body, nested fields are
+		// separated by '.', e.g. customer.tier.
+		BodyField string `json:"bodyField,omitempty"`
+		// Default is the value if the field is missing, or the key is
+		// missing and onMissing is default.
+		Default string `json:"default,omitempty"`
+	}
+
+	// Status is the status of Enricher.
+	Status struct {
+		// Lookups is the number of requests with a key.
+		Lookups uint64 `json:"lookups"`
+		// Hits is the number of lookups served by the cache.
+		Hits     uint64 `json:"hits"`
+		NotFound uint64 `json:"notFound"`
+		Errors   uint64 `json:"errors"`
+		Rejected uint64 `json:"rejected"`
+	}
+
+	cacheEntry struct {
+		values  map[string]interface{}
+		expires time.Time
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	switch spec.Key.Source {
+	case KeySourceHeader:
+		if !httpguts.ValidHeaderFieldName(spec.Key.Header) {
+			return fmt.Errorf("invalid key header %q", spec.Key.Header)
+		}
+	case KeySourcePath:
+		if spec.Key.PathRegexp == "" {
+			return fmt.Errorf("pathRegexp is required by key source path")
+		}
+		if _, err := regexp.Compile(spec.Key.PathRegexp); err != nil {
+			return err
+		}
+	case KeySourceBody:
+		if _, err := jsonpath.Parse(spec.Key.BodyPath); err != nil {
+			return err
+		}
+	}
+
+	for _, v := range spec.Values {
+		if v.Header == "" && v.BodyField == "" {
+			return fmt.Errorf("value %s: header or bodyField is required", v.Field)
+		}
+		if v.Header != "" && !httpguts.ValidHeaderFieldName(v.Header) {
+			return fmt.Errorf("value %s: invalid header %q", v.Field, v.Header)
+		}
+		if v.BodyField != "" {
+			for _, name := range strings.Split(v.BodyField, ".") {
+				if name == "" {
+					return fmt.Errorf("value %s: invalid bodyField %q", v.Field, v.BodyField)
+				}
+			}
+		}
+	}
+
+	if spec.CacheTTL != "" {
+		if d, err := time.ParseDuration(spec.CacheTTL); err != nil || d < 0 {
+			return fmt.Errorf("invalid cacheTTL %q", spec.CacheTTL)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the Enricher filter instance.
+func (e *Enricher) Name() string {
+	return e.spec.Name()
+}
+
+// Kind returns the kind of Enricher.
+func (e *Enricher) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the Enricher
+func (e *Enricher) Spec() filters.Spec {
+	return e.spec
+}
+
+// Init initializes Enricher.
+func (e *Enricher) Init() {
+	e.reload()
+}
+
+// Inherit inherits previous generation of Enricher.
+func (e *Enricher) Inherit(previousGeneration filters.Filter) {
+	e.reload()
+}
+
+func (e *Enricher) reload() {
+	spec := e.spec
+	if spec.OnMissing == "" {
+		spec.OnMissing = OnMissingSkip
+	}
+	if spec.CacheSize <= 0 {
+		spec.CacheSize = defaultCacheSize
+	}
+	if spec.MaxBodySize <= 0 {
+		spec.MaxBodySize = defaultMaxBodySize
+	}
+
+	e.cacheTTL = defaultCacheTTL
+	if spec.CacheTTL != "" {
+		e.cacheTTL, _ = time.ParseDuration(spec.CacheTTL)
+	}
+	e.cache, _ = lru.New(spec.CacheSize)
+
+	// the spec has been verified in Validate, so no error here.
+	switch spec.Key.Source {
+	case KeySourcePath:
+		e.pathRegexp = regexp.MustCompile(spec.Key.PathRegexp)
+	case KeySourceBody:
+		e.bodyPath, _ = jsonpath.Parse(spec.Key.BodyPath)
+	}
+
+	source, err := getDataSourceCreator(spec.DataSource.Kind)(spec.DataSource, spec.Super())
+	if err != nil {
+		logger.Errorf("%s: failed to create data source %s: %v", e.Name(), spec.DataSource.Kind, err)
+		return
+	}
+	e.source = source
+}
+
+// needBody returns whether the JSON body is needed.
+func (e *Enricher) needBody() bool {
+	if e.spec.Key.Source == KeySourceBody {
+		return true
+	}
+	for _, v := range e.spec.Values {
+		if v.BodyField != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// decode decodes the body of the request, it returns nil if the body is
+// not a JSON object within the size limit.
+func (e *Enricher) decode(req *httpprot.Request) map[string]interface{} {
+	if req.IsStream() {
+		return nil
+	}
+
+	body := req.RawPayload()
+	if int64(len(body)) > e.spec.MaxBodySize {
+		return nil
+	}
+	if len(body) == 0 {
+		return map[string]interface{}{}
+	}
+
+	var m map[string]interface{}
+	if err := codectool.UnmarshalJSONNumber(body, &m); err != nil || m == nil {
+		return nil
+	}
+	return m
+}
+
+// key reads the key from the request.
+func (e *Enricher) key(req *httpprot.Request, body map[string]interface{}) string {
+	switch e.spec.Key.Source {
+	case KeySourceHeader:
+		return req.HTTPHeader().Get(e.spec.Key.Header)
+	case KeySourcePath:
+		match := e.pathRegexp.FindStringSubmatch(req.Path())
+		if len(match) > 1 {
+			return match[1]
+		}
+		if len(match) == 1 {
+			return match[0]
+		}
+	case KeySourceBody:
+		if body == nil {
+			return ""
+		}
+		if v, ok := e.bodyPath.Lookup(body); ok {
+			if s, ok := toString(v); ok {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// lookup looks up the values of the key, the values are nil if the key is
+// not found.
+func (e *Enricher) lookup(req *httpprot.Request, key string) (map[string]interface{}, error) {
+	atomic.AddUint64(&e.lookups, 1)
+
+	now := time.Now()
+	if v, ok := e.cache.Get(key); ok {
+		entry := v.(*cacheEntry)
+		if now.Before(entry.expires) {
+			atomic.AddUint64(&e.hits, 1)
+			return entry.values, nil
+		}
+		e.cache.Remove(key)
+	}
+
+	if e.source == nil {
+		return nil, fmt.Errorf("data source is unavailable")
+	}
+	values, err := e.source.Lookup(req.Context(), key)
+	if err != nil {
+		return nil, err
+	}
+	if e.cacheTTL > 0 {
+		e.cache.Add(key, &cacheEntry{values: values, expires: now.Add(e.cacheTTL)})
+	}
+	return values, nil
+}
+
+// toString converts a value to a string, objects and arrays are converted
+// to compact JSON. It returns false if the value is null.
+func toString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		data, err := codectool.MarshalJSON(v)
+		if err != nil {
+			return "", false
+		}
+		return string(data), true
+	}
+}
+
+// setPath sets the value at the path of m, the objects on the path are
+// created if they don't exist.
+func setPath(m map[string]interface{}, path []string, value interface{}) {
+	for _, name := range path[:len(path)-1] {
+		child, ok := m[name].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			m[name] = child
+		}
+		m = child
+	}
+	m[path[len(path)-1]] = value
+}
+
+func (e *Enricher) reject(ctx *context.Context, key string) string {
+	atomic.AddUint64(&e.rejected, 1)
+	ctx.AddTag(fmt.Sprintf("enricher: key %q not found", key))
+
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(http.StatusForbidden)
+	ctx.SetOutputResponse(resp)
+	return resultNotFound
+}
+
+// Handle looks up the key of the request, and enriches the request with
+// the values of the key.
+func (e *Enricher) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	header := req.HTTPHeader()
+
+	// remove the headers sent by the client, so the values can't be
+	// forged.
+	for _, v := range e.spec.Values {
+		if v.Header != "" {
+			header.Del(v.Header)
+		}
+	}
+
+	var body map[string]interface{}
+	if e.needBody() {
+		body = e.decode(req)
+	}
+
+	key := e.key(req, body)
+	var values map[string]interface{}
+	if key != "" {
+		var err error
+		values, err = e.lookup(req, key)
+		if err != nil {
+			atomic.AddUint64(&e.errors, 1)
+			logger.Warnf("%s: failed to look up key %s: %v", e.Name(), key, err)
+		} else if values == nil {
+			atomic.AddUint64(&e.notFound, 1)
+		}
+	}
+
+	if values == nil {
+		switch e.spec.OnMissing {
+		case OnMissingReject:
+			return e.reject(ctx, key)
+		case OnMissingSkip:
+			return ""
+		}
+	}
+
+	bodyChanged := false
+	for _, v := range e.spec.Values {
+		value, ok := values[v.Field]
+		if !ok || value == nil {
+			if v.Default == "" {
+				continue
+			}
+			value = v.Default
+		}
+
+		if v.Header != "" {
+			if s, ok := toString(value); ok && httpguts.ValidHeaderFieldValue(s) {
+				header.Set(v.Header, s)
+			}
+		}
+		if v.BodyField != "" && body != nil {
+			setPath(body, strings.Split(v.BodyField, "."), value)
+			bodyChanged = true
+		}
+	}
+
+	if bodyChanged {
+		data, err := codectool.MarshalJSON(body)
+		if err != nil {
+			logger.Errorf("%s: failed to encode body: %v", e.Name(), err)
+			return ""
+		}
+		req.SetPayload(data)
+		header.Set("Content-Length", strconv.Itoa(len(data)))
+	}
+	return ""
+}
+
+// Status returns status.
+func (e *Enricher) Status() interface{} {
+	return &Status{
+		Lookups:  atomic.LoadUint64(&e.lookups),
+		Hits:     atomic.LoadUint64(&e.hits),
+		NotFound: atomic.LoadUint64(&e.notFound),
+		Errors:   atomic.LoadUint64(&e.errors),
+		Rejected: atomic.LoadUint64(&e.rejected),
+	}
+}
+
+// Close closes Enricher.
+func (e *Enricher) Close() {
+}
@@ -0,0 +1,352 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package enricher
+
+import (
+	stdcontext "context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+
+	"github.com/megaease/easegress/v2/pkg/cluster/clustertest"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/option"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newEnricher(t *testing.T, super *supervisor.Supervisor, yamlConfig string) *Enricher {
+	rawSpec := make(map[string]interface{})
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(super, "pipeline", rawSpec)
+	assert.NoError(t, err)
+	e := kind.CreateInstance(spec).(*Enricher)
+	e.Init()
+	return e
+}
+
+func newContext(path, body string, header http.Header) (*context.Context, *httpprot.Request) {
+	stdReq, _ := http.NewRequest(http.MethodPost, "http://localhost"+path, strings.NewReader(body))
+	for k, v := range header {
+		stdReq.Header[k] = v
+	}
+	req, _ := httpprot.NewRequest(stdReq)
+	req.FetchPayload(1024)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, req
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, yamlConfig := range []string{`
+kind: Enricher
+name: e
+key: {source: path}
+dataSource: {kind: static}
+values: [{field: tier, header: X-Tier}]
+`, `
+kind: Enricher
+name: e
+key: {source: header, header: X-Api-Key}
+dataSource: {kind: redis}
+values: [{field: tier, header: X-Tier}]
+`, `
+kind: Enricher
+name: e
+key: {source: header, header: X-Api-Key}
+dataSource: {kind: http, url: "http://127.0.0.1/keys"}
+values: [{field: tier, header: X-Tier}]
+`, `
+kind: Enricher
+name: e
+key: {source: body, bodyPath: $.apiKey}
+dataSource: {kind: static}
+values: [{field: tier}]
+`, `
+kind: Enricher
+name: e
+key: {source: header, header: X-Api-Key}
+dataSource: {kind: customData}
+values: [{field: tier, bodyField: customer..tier}]
+`} {
+		rawSpec := make(map[string]interface{})
+		codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+		_, err := filters.NewSpec(nil, "", rawSpec)
+		assert.Error(err, yamlConfig)
+	}
+}
+
+func TestHeaderKey(t *testing.T) {
+	assert := assert.New(t)
+
+	e := newEnricher(t, nil, `
+kind: Enricher
+name: e
+key:
+  source: header
+  header: X-Api-Key
+dataSource:
+  kind: static
+  static:
+    key-1:
+      tier: gold
+      limits: {rps: 100}
+    key-2:
+      tier: silver
+values:
+- field: tier
+  header: X-Customer-Tier
+- field: limits
+  header: X-Customer-Limits
+  default: "{}"
+onMissing: default
+`)
+	defer e.Close()
+
+	ctx, req := newContext("/", "", http.Header{"X-Api-Key": {"key-1"}, "X-Customer-Tier": {"platinum"}})
+	assert.Equal("", e.Handle(ctx))
+	assert.Equal("gold", req.HTTPHeader().Get("X-Customer-Tier"))
+	assert.Equal(`{"rps":100}`, req.HTTPHeader().Get("X-Customer-Limits"))
+
+	// the default of a missing field.
+	ctx, req = newContext("/", "", http.Header{"X-Api-Key": {"key-2"}})
+	assert.Equal("", e.Handle(ctx))
+	assert.Equal("silver", req.HTTPHeader().Get("X-Customer-Tier"))
+	assert.Equal("{}", req.HTTPHeader().Get("X-Customer-Limits"))
+
+	// the defaults of a missing key, the forged header is removed.
+	ctx, req = newContext("/", "", http.Header{"X-Api-Key": {"key-3"}, "X-Customer-Tier": {"platinum"}})
+	assert.Equal("", e.Handle(ctx))
+	assert.Equal("", req.HTTPHeader().Get("X-Customer-Tier"))
+	assert.Equal("{}", req.HTTPHeader().Get("X-Customer-Limits"))
+
+	ctx, _ = newContext("/", "", nil)
+	assert.Equal("", e.Handle(ctx))
+
+	status := e.Status().(*Status)
+	assert.Equal(uint64(3), status.Lookups)
+	assert.Equal(uint64(1), status.NotFound)
+}
+
+func TestPathKeyAndReject(t *testing.T) {
+	assert := assert.New(t)
+
+	e := newEnricher(t, nil, `
+kind: Enricher
+name: e
+key:
+  source: path
+  pathRegexp: ^/customers/([^/]+)
+dataSource:
+  kind: static
+  static:
+    c1: {region: us}
+values:
+- field: region
+  header: X-Region
+onMissing: reject
+`)
+	defer e.Close()
+
+	ctx, req := newContext("/customers/c1/orders", "", nil)
+	assert.Equal("", e.Handle(ctx))
+	assert.Equal("us", req.HTTPHeader().Get("X-Region"))
+
+	ctx, _ = newContext("/customers/c2/orders", "", nil)
+	assert.Equal(resultNotFound, e.Handle(ctx))
+	assert.Equal(http.StatusForbidden, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	ctx, _ = newContext("/orders", "", nil)
+	assert.Equal(resultNotFound, e.Handle(ctx))
+	assert.Equal(uint64(2), e.Status().(*Status).Rejected)
+}
+
+func TestBodyKeyAndField(t *testing.T) {
+	assert := assert.New(t)
+
+	e := newEnricher(t, nil, `
+kind: Enricher
+name: e
+key:
+  source: body
+  bodyPath: $.auth.apiKey
+dataSource:
+  kind: static
+  static:
+    k1: {tier: gold, credits: 10}
+values:
+- field: tier
+  bodyField: customer.tier
+- field: credits
+  bodyField: customer.credits
+  header: X-Credits
+`)
+	defer e.Close()
+
+	ctx, req := newContext("/", `{"auth":{"apiKey":"k1"},"customer":{"id":1}}`, nil)
+	assert.Equal("", e.Handle(ctx))
+	assert.Equal("10", req.HTTPHeader().Get("X-Credits"))
+	assert.JSONEq(`{"auth":{"apiKey":"k1"},"customer":{"id":1,"tier":"gold","credits":10}}`, string(req.RawPayload()))
+
+	// skipped, the body is not changed.
+	body := `{"auth":{"apiKey":"k2"}}`
+	ctx, req = newContext("/", body, nil)
+	assert.Equal("", e.Handle(ctx))
+	assert.Equal(body, string(req.RawPayload()))
+}
+
+func TestHTTPDataSource(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		assert.Equal("secret", r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/keys/a b":
+			w.Write([]byte(`{"tier":"gold"}`))
+		case "/keys/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	e := newEnricher(t, nil, `
+kind: Enricher
+name: e
+key:
+  source: header
+  header: X-Api-Key
+dataSource:
+  kind: http
+  url: `+server.URL+`/keys/{key}
+  headers:
+    Authorization: secret
+values:
+- field: tier
+  header: X-Tier
+cacheTTL: 1m
+`)
+	defer e.Close()
+
+	handle := func(key string) string {
+		ctx, req := newContext("/", "", http.Header{"X-Api-Key": {key}})
+		e.Handle(ctx)
+		return req.HTTPHeader().Get("X-Tier")
+	}
+
+	assert.Equal("gold", handle("a b"))
+	assert.Equal("gold", handle("a b"))
+	assert.Equal("", handle("unknown"))
+	assert.Equal("", handle("unknown"))
+	// errors are not cached.
+	assert.Equal("", handle("broken"))
+	assert.Equal("", handle("broken"))
+	assert.Equal(int32(4), atomic.LoadInt32(&requests))
+
+	status := e.Status().(*Status)
+	assert.Equal(uint64(6), status.Lookups)
+	assert.Equal(uint64(2), status.Hits)
+	assert.Equal(uint64(2), status.NotFound)
+	assert.Equal(uint64(2), status.Errors)
+}
+
+func TestCustomDataSource(t *testing.T) {
+	assert := assert.New(t)
+
+	cls := clustertest.NewMockedCluster()
+	cls.MockedGetRaw = func(key string) (*mvccpb.KeyValue, error) {
+		if key == "/custom-data/customers/c1" {
+			return &mvccpb.KeyValue{Value: []byte("name: c1\ntier: gold")}, nil
+		}
+		return nil, nil
+	}
+	super := supervisor.NewMock(option.New(), cls, nil, nil, false, nil, nil)
+
+	e := newEnricher(t, super, `
+kind: Enricher
+name: e
+key:
+  source: header
+  header: X-Customer
+dataSource:
+  kind: customData
+  customDataKind: customers
+values:
+- field: tier
+  header: X-Tier
+`)
+	defer e.Close()
+
+	ctx, req := newContext("/", "", http.Header{"X-Customer": {"c1"}})
+	e.Handle(ctx)
+	assert.Equal("gold", req.HTTPHeader().Get("X-Tier"))
+
+	ctx, req = newContext("/", "", http.Header{"X-Customer": {"c2"}})
+	e.Handle(ctx)
+	assert.Equal("", req.HTTPHeader().Get("X-Tier"))
+}
+
+type mockDataSource struct{}
+
+func (m *mockDataSource) Lookup(ctx stdcontext.Context, key string) (map[string]interface{}, error) {
+	return map[string]interface{}{"upper": strings.ToUpper(key)}, nil
+}
+
+func TestRegisterDataSource(t *testing.T) {
+	assert := assert.New(t)
+
+	RegisterDataSource("mock", func(spec *DataSourceSpec, super *supervisor.Supervisor) (DataSource, error) {
+		return &mockDataSource{}, nil
+	})
+	assert.Panics(func() { RegisterDataSource(DataSourceStatic, nil) })
+
+	e := newEnricher(t, nil, `
+kind: Enricher
+name: e
+key:
+  source: header
+  header: X-Name
+dataSource:
+  kind: mock
+values:
+- field: upper
+  header: X-Upper
+`)
+	defer e.Close()
+
+	ctx, req := newContext("/", "", http.Header{"X-Name": {"abc"}})
+	e.Handle(ctx)
+	assert.Equal("ABC", req.HTTPHeader().Get("X-Upper"))
+}
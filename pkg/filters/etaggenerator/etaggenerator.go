@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package etaggenerator implements a filter which generates ETags for
+// responses and handles conditional requests with them.
+package etaggenerator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of ETagGenerator.
+	Kind = "ETagGenerator"
+
+	defaultMaxBodySize = 1024 * 1024
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "ETagGenerator generates ETags for responses without one, and responds 304 to matched conditional requests.",
+	Results:     []string{},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &ETagGenerator{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// ETagGenerator is the filter ETagGenerator.
+	ETagGenerator struct {
+		spec *Spec
+
+		generated   uint64
+		notModified uint64
+	}
+
+	// Spec is the spec of ETagGenerator.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Weak generates weak ETags, which should be used if the body
+		// could be changed by the following filters.
+		Weak bool `json:"weak,omitempty"`
+		// MaxBodySize is the max size of the bodies to hash, ETags are
+		// not generated for larger bodies and stream bodies.
+		MaxBodySize int64 `json:"maxBodySize,omitempty" jsonschema:"minimum=0"`
+	}
+
+	// Status is the status of ETagGenerator.
+	Status struct {
+		Generated   uint64 `json:"generated"`
+		NotModified uint64 `json:"notModified"`
+	}
+)
+
+// Name returns the name of the ETagGenerator filter instance.
+func (eg *ETagGenerator) Name() string {
+	return eg.spec.Name()
+}
+
+// Kind returns the kind of ETagGenerator.
+func (eg *ETagGenerator) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the ETagGenerator
+func (eg *ETagGenerator) Spec() filters.Spec {
+	return eg.spec
+}
+
+// Init initializes ETagGenerator.
+func (eg *ETagGenerator) Init() {
+}
+
+// Inherit inherits previous generation of ETagGenerator.
+func (eg *ETagGenerator) Inherit(previousGeneration filters.Filter) {
+	eg.Init()
+}
+
+func (eg *ETagGenerator) maxBodySize() int64 {
+	if eg.spec.MaxBodySize > 0 {
+		return eg.spec.MaxBodySize
+	}
+	return defaultMaxBodySize
+}
+
+// cacheable returns whether the response is cacheable by its status code,
+// and has a representation to be hashed.
+func cacheable(statusCode int) bool {
+	return statusCode == http.StatusOK || statusCode == http.StatusNonAuthoritativeInfo
+}
+
+// noStore returns whether the Cache-Control header has the no-store
+// directive.
+func noStore(h http.Header) bool {
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(d), "no-store") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchIfNoneMatch returns whether any entity tag in the If-None-Match
+// header matches etag, using the weak comparison defined by RFC 7232.
+func matchIfNoneMatch(h http.Header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range h.Values("If-None-Match") {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (eg *ETagGenerator) etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if eg.spec.Weak {
+		etag = "W/" + etag
+	}
+	return etag
+}
+
+// Handle generates the ETag of the response, and replaces the response with
+// a 304 one if the ETag matches the If-None-Match header of the request.
+func (eg *ETagGenerator) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	if req.Method() != http.MethodGet {
+		return ""
+	}
+
+	resp, _ := ctx.GetInputResponse().(*httpprot.Response)
+	if resp == nil || !cacheable(resp.StatusCode()) {
+		return ""
+	}
+	h := resp.HTTPHeader()
+	if h.Get("ETag") != "" || noStore(h) {
+		return ""
+	}
+	if resp.IsStream() || resp.PayloadSize() > eg.maxBodySize() {
+		logger.Debugf("%s: body too large to generate ETag", eg.Name())
+		return ""
+	}
+
+	etag := eg.etag(resp.RawPayload())
+	h.Set("ETag", etag)
+	atomic.AddUint64(&eg.generated, 1)
+
+	if !matchIfNoneMatch(req.HTTPHeader(), etag) {
+		return ""
+	}
+
+	atomic.AddUint64(&eg.notModified, 1)
+	resp.SetStatusCode(http.StatusNotModified)
+	resp.SetPayload(nil)
+	h.Del("Content-Length")
+	return ""
+}
+
+// Status returns status.
+func (eg *ETagGenerator) Status() interface{} {
+	return &Status{
+		Generated:   atomic.LoadUint64(&eg.generated),
+		NotModified: atomic.LoadUint64(&eg.notModified),
+	}
+}
+
+// Close closes ETagGenerator.
+func (eg *ETagGenerator) Close() {
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etaggenerator
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestFilter(yamlConfig string) *ETagGenerator {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		panic(err)
+	}
+	eg := kind.CreateInstance(spec).(*ETagGenerator)
+	eg.Init()
+	return eg
+}
+
+func newContext(method, ifNoneMatch string, statusCode int, body string) (*context.Context, *httpprot.Response) {
+	stdr, _ := http.NewRequest(method, "http://127.0.0.1/", nil)
+	if ifNoneMatch != "" {
+		stdr.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	req, _ := httpprot.NewRequest(stdr)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(statusCode)
+	resp.SetPayload(body)
+	resp.HTTPHeader().Set("Content-Length", "5")
+	ctx.SetInputResponse(resp)
+	return ctx, resp
+}
+
+func TestETagGenerator(t *testing.T) {
+	assert := assert.New(t)
+
+	eg := newTestFilter(`
+kind: ETagGenerator
+name: eg
+maxBodySize: 10
+`)
+	assert.Equal(kind, eg.Kind())
+	assert.Equal("eg", eg.Name())
+
+	ctx, resp := newContext(http.MethodGet, "", http.StatusOK, "hello")
+	assert.Empty(eg.Handle(ctx))
+	etag := resp.HTTPHeader().Get("ETag")
+	assert.Len(etag, 34)
+	assert.True(strings.HasPrefix(etag, `"`))
+
+	// the ETag is stable.
+	ctx, resp = newContext(http.MethodGet, "", http.StatusOK, "hello")
+	eg.Handle(ctx)
+	assert.Equal(etag, resp.HTTPHeader().Get("ETag"))
+
+	// the ETag changes with the body.
+	ctx, resp = newContext(http.MethodGet, "", http.StatusOK, "world")
+	eg.Handle(ctx)
+	assert.NotEqual(etag, resp.HTTPHeader().Get("ETag"))
+
+	for _, inm := range []string{etag, `"x", W/` + etag, "*"} {
+		ctx, resp = newContext(http.MethodGet, inm, http.StatusOK, "hello")
+		assert.Empty(eg.Handle(ctx))
+		assert.Equal(http.StatusNotModified, resp.StatusCode())
+		assert.Equal(etag, resp.HTTPHeader().Get("ETag"))
+		assert.Empty(resp.RawPayload())
+		assert.Empty(resp.HTTPHeader().Get("Content-Length"))
+	}
+
+	ctx, resp = newContext(http.MethodGet, `"x"`, http.StatusOK, "hello")
+	eg.Handle(ctx)
+	assert.Equal(http.StatusOK, resp.StatusCode())
+	assert.Equal("hello", string(resp.RawPayload()))
+
+	// skipped responses.
+	for _, c := range []struct {
+		method     string
+		statusCode int
+		body       string
+		header     string
+	}{
+		{http.MethodPost, http.StatusOK, "hello", ""},
+		{http.MethodGet, http.StatusNotFound, "hello", ""},
+		{http.MethodGet, http.StatusOK, "hello world", ""},
+		{http.MethodGet, http.StatusOK, "hello", "Cache-Control"},
+		{http.MethodGet, http.StatusOK, "hello", "ETag"},
+	} {
+		ctx, resp = newContext(c.method, "*", c.statusCode, c.body)
+		switch c.header {
+		case "Cache-Control":
+			resp.HTTPHeader().Set("Cache-Control", "private, no-store")
+		case "ETag":
+			resp.HTTPHeader().Set("ETag", `"backend"`)
+		}
+		eg.Handle(ctx)
+		assert.Equal(c.statusCode, resp.StatusCode())
+		if c.header != "ETag" {
+			assert.Empty(resp.HTTPHeader().Get("ETag"))
+		}
+	}
+
+	status := eg.Status().(*Status)
+	assert.Equal(uint64(7), status.Generated)
+	assert.Equal(uint64(3), status.NotModified)
+
+	eg = newTestFilter(`
+kind: ETagGenerator
+name: eg
+weak: true
+`)
+	ctx, resp = newContext(http.MethodGet, etag, http.StatusOK, "hello")
+	eg.Handle(ctx)
+	assert.Equal("W/"+etag, resp.HTTPHeader().Get("ETag"))
+	assert.Equal(http.StatusNotModified, resp.StatusCode())
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package expectcontinue implements a filter which approves requests
+// whose 100 Continue responses are deferred by the HTTP server.
+package expectcontinue
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of ExpectContinue.
+	Kind = "ExpectContinue"
+
+	resultTooLarge   = "tooLarge"
+	resultReadFailed = "readFailed"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "ExpectContinue approves the request, the client is asked to send the body if it is waiting for a 100 Continue.",
+	Results:     []string{resultTooLarge, resultReadFailed},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &ExpectContinue{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// ExpectContinue is the filter ExpectContinue.
+	ExpectContinue struct {
+		spec *Spec
+
+		approved uint64
+		failures uint64
+	}
+
+	// Spec is the spec of ExpectContinue.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+	}
+
+	// Status is the status of ExpectContinue.
+	Status struct {
+		Approved uint64 `json:"approved"`
+		Failures uint64 `json:"failures"`
+	}
+)
+
+// Name returns the name of the ExpectContinue filter instance.
+func (ec *ExpectContinue) Name() string {
+	return ec.spec.Name()
+}
+
+// Kind returns the kind of ExpectContinue.
+func (ec *ExpectContinue) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the ExpectContinue
+func (ec *ExpectContinue) Spec() filters.Spec {
+	return ec.spec
+}
+
+// Init initializes ExpectContinue.
+func (ec *ExpectContinue) Init() {
+}
+
+// Inherit inherits previous generation of ExpectContinue.
+func (ec *ExpectContinue) Inherit(previousGeneration filters.Filter) {
+	ec.Init()
+}
+
+// Handle fetches the deferred body of the request, which sends the 100
+// Continue response to the client. Requests without a deferred body are
+// passed through.
+func (ec *ExpectContinue) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	if !req.PayloadDeferred() {
+		return ""
+	}
+
+	err := req.FetchDeferredPayload()
+	if err == nil {
+		atomic.AddUint64(&ec.approved, 1)
+		return ""
+	}
+
+	atomic.AddUint64(&ec.failures, 1)
+	ctx.AddTag(fmt.Sprintf("expectContinue: failed to read request body: %v", err))
+
+	result, code := resultReadFailed, http.StatusBadRequest
+	if err == httpprot.ErrRequestEntityTooLarge {
+		result, code = resultTooLarge, http.StatusRequestEntityTooLarge
+	}
+
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(code)
+	ctx.SetOutputResponse(resp)
+	return result
+}
+
+// Status returns status.
+func (ec *ExpectContinue) Status() interface{} {
+	return &Status{
+		Approved: atomic.LoadUint64(&ec.approved),
+		Failures: atomic.LoadUint64(&ec.failures),
+	}
+}
+
+// Close closes ExpectContinue.
+func (ec *ExpectContinue) Close() {
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package expectcontinue
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestFilter() *ExpectContinue {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(`
+kind: ExpectContinue
+name: approve
+`), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		panic(err)
+	}
+	ec := kind.CreateInstance(spec).(*ExpectContinue)
+	ec.Init()
+	return ec
+}
+
+func newContext(stdr *http.Request, maxPayloadSize int64) (*context.Context, *httpprot.Request) {
+	stdr.Header.Set("Expect", "100-continue")
+	req, _ := httpprot.NewRequest(stdr)
+	req.DeferPayload(maxPayloadSize)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, req
+}
+
+func TestExpectContinue(t *testing.T) {
+	assert := assert.New(t)
+
+	ec := newTestFilter()
+	assert.Equal(kind, ec.Kind())
+	assert.Equal("approve", ec.Name())
+
+	stdr, _ := http.NewRequest(http.MethodPut, "http://127.0.0.1/", strings.NewReader("hello"))
+	ctx, req := newContext(stdr, 1024)
+	assert.True(req.PayloadDeferred())
+	assert.Equal("", ec.Handle(ctx))
+	assert.False(req.PayloadDeferred())
+	assert.Equal([]byte("hello"), req.RawPayload())
+
+	// not deferred, passed through.
+	assert.Equal("", ec.Handle(ctx))
+
+	stdr, _ = http.NewRequest(http.MethodPut, "http://127.0.0.1/", strings.NewReader("hello"))
+	stdr.ContentLength = -1
+	ctx, _ = newContext(stdr, 3)
+	assert.Equal(resultTooLarge, ec.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusRequestEntityTooLarge, resp.StatusCode())
+
+	stdr, _ = http.NewRequest(http.MethodPut, "http://127.0.0.1/", iotest.ErrReader(fmt.Errorf("dummy")))
+	stdr.ContentLength = -1
+	ctx, _ = newContext(stdr, 1024)
+	assert.Equal(resultReadFailed, ec.Handle(ctx))
+	resp = ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode())
+
+	status := ec.Status().(*Status)
+	assert.Equal(uint64(1), status.Approved)
+	assert.Equal(uint64(2), status.Failures)
+
+	ec.Inherit(newTestFilter())
+	ec.Close()
+}
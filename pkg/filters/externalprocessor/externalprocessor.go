@@ -0,0 +1,383 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package externalprocessor implements a filter which delegates the
+// transformation of requests to an external HTTP service.
+package externalprocessor
+
+import (
+	"bytes"
+	stdcontext "context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+const (
+	// Kind is the kind of ExternalProcessor.
+	Kind = "ExternalProcessor"
+
+	resultFailed         = "failed"
+	resultShortCircuited = "shortCircuited"
+
+	failureModeOpen   = "open"
+	failureModeClosed = "closed"
+
+	defaultTimeout     = time.Second
+	defaultMaxBodySize = 64 * 1024
+	// maxCalloutResponseSize is the max size of the response of the
+	// external service.
+	maxCalloutResponseSize = 4 * 1024 * 1024
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "ExternalProcessor delegates the transformation of requests to an external HTTP service.",
+	Results:     []string{resultFailed, resultShortCircuited},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{
+			Timeout:     defaultTimeout.String(),
+			FailureMode: failureModeClosed,
+			MaxBodySize: defaultMaxBodySize,
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &ExternalProcessor{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// ExternalProcessor is the filter ExternalProcessor.
+	ExternalProcessor struct {
+		spec   *Spec
+		client *http.Client
+
+		processed      uint64
+		shortCircuited uint64
+		failures       uint64
+	}
+
+	// Spec is the spec of ExternalProcessor.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// URL is the address of the external service.
+		URL string `json:"url" jsonschema:"required,format=uri"`
+		// Timeout is the timeout of a callout.
+		Timeout string `json:"timeout,omitempty" jsonschema:"format=duration"`
+		// FailureMode decides what to do if a callout fails, "open" to
+		// continue with the original request, "closed" to reject it.
+		FailureMode string `json:"failureMode,omitempty" jsonschema:"enum=open,enum=closed"`
+		// SendBody sends the body of the request to the external service.
+		SendBody bool `json:"sendBody,omitempty"`
+		// MaxBodySize is the max size of the body sent to the external
+		// service, larger bodies are not sent.
+		MaxBodySize int64 `json:"maxBodySize,omitempty" jsonschema:"minimum=0"`
+
+		timeout time.Duration
+	}
+
+	// Status is the status of ExternalProcessor.
+	Status struct {
+		Processed      uint64 `json:"processed"`
+		ShortCircuited uint64 `json:"shortCircuited"`
+		Failures       uint64 `json:"failures"`
+	}
+
+	// calloutRequest is sent to the external service.
+	calloutRequest struct {
+		Request *requestEntity `json:"request"`
+	}
+
+	requestEntity struct {
+		RealIP string      `json:"realIP"`
+		Method string      `json:"method"`
+		Scheme string      `json:"scheme"`
+		Host   string      `json:"host"`
+		Path   string      `json:"path"`
+		Query  string      `json:"query"`
+		Proto  string      `json:"proto"`
+		Header http.Header `json:"header"`
+		Body   []byte      `json:"body,omitempty"`
+		// BodyOmitted is true if the request has a body, but it is not
+		// sent because of the configuration or its size.
+		BodyOmitted bool `json:"bodyOmitted,omitempty"`
+	}
+
+	// calloutResponse is the response of the external service.
+	calloutResponse struct {
+		// Mutation is the mutation to the request.
+		Mutation *requestMutation `json:"mutation,omitempty"`
+		// Response short circuits the request if it is not nil.
+		Response *immediateResponse `json:"response,omitempty"`
+	}
+
+	requestMutation struct {
+		Method        string            `json:"method,omitempty"`
+		Path          string            `json:"path,omitempty"`
+		Query         *string           `json:"query,omitempty"`
+		SetHeaders    map[string]string `json:"setHeaders,omitempty"`
+		AddHeaders    map[string]string `json:"addHeaders,omitempty"`
+		RemoveHeaders []string          `json:"removeHeaders,omitempty"`
+		// Body replaces the body of the request if it is not nil.
+		Body *[]byte `json:"body,omitempty"`
+	}
+
+	immediateResponse struct {
+		StatusCode int         `json:"statusCode"`
+		Header     http.Header `json:"header,omitempty"`
+		Body       []byte      `json:"body,omitempty"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if spec.Timeout != "" {
+		if d, err := time.ParseDuration(spec.Timeout); err != nil {
+			return err
+		} else if d <= 0 {
+			return fmt.Errorf("timeout must be positive")
+		}
+	}
+	switch spec.FailureMode {
+	case "", failureModeOpen, failureModeClosed:
+	default:
+		return fmt.Errorf("invalid failureMode %s", spec.FailureMode)
+	}
+	return nil
+}
+
+// Name returns the name of the ExternalProcessor filter instance.
+func (ep *ExternalProcessor) Name() string {
+	return ep.spec.Name()
+}
+
+// Kind returns the kind of ExternalProcessor.
+func (ep *ExternalProcessor) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the ExternalProcessor
+func (ep *ExternalProcessor) Spec() filters.Spec {
+	return ep.spec
+}
+
+// Init initializes ExternalProcessor.
+func (ep *ExternalProcessor) Init() {
+	ep.reload()
+}
+
+// Inherit inherits previous generation of ExternalProcessor.
+func (ep *ExternalProcessor) Inherit(previousGeneration filters.Filter) {
+	ep.reload()
+}
+
+func (ep *ExternalProcessor) reload() {
+	ep.spec.timeout, _ = time.ParseDuration(ep.spec.Timeout)
+	if ep.spec.timeout <= 0 {
+		ep.spec.timeout = defaultTimeout
+	}
+	if ep.spec.MaxBodySize <= 0 {
+		ep.spec.MaxBodySize = defaultMaxBodySize
+	}
+	ep.client = &http.Client{
+		Timeout: ep.spec.timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Handle calls out to the external service and applies its decision to
+// the request.
+func (ep *ExternalProcessor) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	cr, err := ep.callout(req)
+	if err != nil {
+		atomic.AddUint64(&ep.failures, 1)
+		if ep.spec.FailureMode == failureModeOpen {
+			logger.Warnf("%s: callout failed, continue with the original request: %v", ep.Name(), err)
+			return ""
+		}
+		logger.Errorf("%s: callout failed: %v", ep.Name(), err)
+		ctx.AddTag(fmt.Sprintf("externalProcessor: callout failed: %v", err))
+		resp, _ := httpprot.NewResponse(nil)
+		resp.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.SetOutputResponse(resp)
+		return resultFailed
+	}
+
+	atomic.AddUint64(&ep.processed, 1)
+	if cr == nil {
+		return ""
+	}
+
+	if ir := cr.Response; ir != nil {
+		atomic.AddUint64(&ep.shortCircuited, 1)
+		ctx.AddTag(fmt.Sprintf("externalProcessor: short circuited with status code %d", ir.StatusCode))
+		resp, _ := httpprot.NewResponse(nil)
+		resp.SetStatusCode(ir.StatusCode)
+		for k, vs := range ir.Header {
+			for _, v := range vs {
+				resp.HTTPHeader().Add(k, v)
+			}
+		}
+		resp.SetPayload(ir.Body)
+		ctx.SetOutputResponse(resp)
+		return resultShortCircuited
+	}
+
+	if cr.Mutation != nil {
+		applyMutation(req, cr.Mutation)
+	}
+	return ""
+}
+
+// callout sends the request to the external service, it returns nil if
+// the service has nothing to do with the request.
+func (ep *ExternalProcessor) callout(req *httpprot.Request) (*calloutResponse, error) {
+	entity := &requestEntity{
+		RealIP: req.RealIP(),
+		Method: req.Method(),
+		Scheme: req.Scheme(),
+		Host:   req.Host(),
+		Path:   req.Path(),
+		Query:  req.URL().RawQuery,
+		Proto:  req.Proto(),
+		Header: req.HTTPHeader(),
+	}
+	// the body of a stream request can only be read once, so it is
+	// never sent.
+	if req.IsStream() {
+		entity.BodyOmitted = true
+	} else if body := req.RawPayload(); len(body) > 0 {
+		if ep.spec.SendBody && int64(len(body)) <= ep.spec.MaxBodySize {
+			entity.Body = body
+		} else {
+			entity.BodyOmitted = true
+		}
+	}
+
+	data, err := codectool.MarshalJSON(&calloutRequest{Request: entity})
+	if err != nil {
+		return nil, err
+	}
+
+	stdctx, cancel := stdcontext.WithTimeout(req.Context(), ep.spec.timeout)
+	defer cancel()
+	stdReq, err := http.NewRequestWithContext(stdctx, http.MethodPost, ep.spec.URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	stdReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := ep.client.Do(stdReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	data, err = io.ReadAll(io.LimitReader(resp.Body, maxCalloutResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxCalloutResponseSize {
+		return nil, fmt.Errorf("response is larger than %d bytes", maxCalloutResponseSize)
+	}
+
+	cr := &calloutResponse{}
+	if err = codectool.UnmarshalJSON(data, cr); err != nil {
+		return nil, err
+	}
+	if ir := cr.Response; ir != nil && (ir.StatusCode < 200 || ir.StatusCode > 599) {
+		return nil, fmt.Errorf("invalid status code %d of the immediate response", ir.StatusCode)
+	}
+	return cr, nil
+}
+
+func applyMutation(req *httpprot.Request, m *requestMutation) {
+	if m.Method != "" {
+		req.SetMethod(m.Method)
+	}
+	if m.Path != "" {
+		req.SetPath(m.Path)
+	}
+	if m.Query != nil {
+		req.URL().RawQuery = *m.Query
+	}
+
+	h := req.HTTPHeader()
+	for _, k := range m.RemoveHeaders {
+		h.Del(k)
+	}
+	for k, v := range m.SetHeaders {
+		h.Set(k, v)
+	}
+	for k, v := range m.AddHeaders {
+		h.Add(k, v)
+	}
+
+	if m.Body == nil {
+		return
+	}
+	if req.IsStream() {
+		if c, ok := req.GetPayload().(io.Closer); ok {
+			c.Close()
+		}
+	}
+	body := *m.Body
+	req.SetPayload(body)
+	req.ContentLength = int64(len(body))
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	h.Del("Content-Encoding")
+}
+
+// Status returns status.
+func (ep *ExternalProcessor) Status() interface{} {
+	return &Status{
+		Processed:      atomic.LoadUint64(&ep.processed),
+		ShortCircuited: atomic.LoadUint64(&ep.shortCircuited),
+		Failures:       atomic.LoadUint64(&ep.failures),
+	}
+}
+
+// Close closes ExternalProcessor.
+func (ep *ExternalProcessor) Close() {
+	ep.client.CloseIdleConnections()
+}
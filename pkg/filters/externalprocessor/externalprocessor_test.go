@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package externalprocessor
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestProcessor(yamlConfig string) *ExternalProcessor {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		panic(err)
+	}
+	ep := kind.CreateInstance(spec).(*ExternalProcessor)
+	ep.Init()
+	return ep
+}
+
+func newContext(body string) *context.Context {
+	stdReq, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/api?a=1", strings.NewReader(body))
+	stdReq.Header.Set("X-Remove", "true")
+	req, _ := httpprot.NewRequest(stdReq)
+	req.FetchPayload(1024)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestMutation(t *testing.T) {
+	assert := assert.New(t)
+
+	var received calloutRequest
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		received = calloutRequest{}
+		codectool.MustUnmarshal(data, &received)
+		w.Write([]byte(`{"mutation": {
+			"method": "PUT",
+			"path": "/v2/api",
+			"query": "b=2",
+			"setHeaders": {"X-Set": "1"},
+			"addHeaders": {"X-Add": "2"},
+			"removeHeaders": ["X-Remove"],
+			"body": "bmV3IGJvZHk="
+		}}`))
+	}))
+	defer svr.Close()
+
+	ep := newTestProcessor(`
+kind: ExternalProcessor
+name: processor
+url: ` + svr.URL + `
+sendBody: true
+`)
+	defer ep.Close()
+	assert.Equal(kind, ep.Kind())
+	assert.Equal("processor", ep.Name())
+
+	ctx := newContext("hello")
+	assert.Equal("", ep.Handle(ctx))
+	assert.Equal("/api", received.Request.Path)
+	assert.Equal("hello", string(received.Request.Body))
+	assert.False(received.Request.BodyOmitted)
+
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	assert.Equal(http.MethodPut, req.Method())
+	assert.Equal("/v2/api", req.Path())
+	assert.Equal("b=2", req.URL().RawQuery)
+	assert.Equal("1", req.HTTPHeader().Get("X-Set"))
+	assert.Equal("2", req.HTTPHeader().Get("X-Add"))
+	assert.Equal("", req.HTTPHeader().Get("X-Remove"))
+	assert.Equal("new body", string(req.RawPayload()))
+	assert.Equal("8", req.HTTPHeader().Get("Content-Length"))
+
+	// the body is not sent if it is too large.
+	ep.spec.MaxBodySize = 2
+	assert.Equal("", ep.Handle(newContext("hello")))
+	assert.Nil(received.Request.Body)
+	assert.True(received.Request.BodyOmitted)
+
+	assert.Equal(uint64(2), ep.Status().(*Status).Processed)
+}
+
+func TestShortCircuit(t *testing.T) {
+	assert := assert.New(t)
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/nothing" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(`{"response": {"statusCode": 403, "header": {"X-Reason": ["denied"]}, "body": "ZGVuaWVk"}}`))
+	}))
+	defer svr.Close()
+
+	ep := newTestProcessor(`
+kind: ExternalProcessor
+name: processor
+url: ` + svr.URL + `
+`)
+	defer ep.Close()
+
+	ctx := newContext("hello")
+	assert.Equal(resultShortCircuited, ep.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusForbidden, resp.StatusCode())
+	assert.Equal("denied", resp.HTTPHeader().Get("X-Reason"))
+	assert.Equal("denied", string(resp.RawPayload()))
+
+	ep.spec.URL = svr.URL + "/nothing"
+	ctx = newContext("hello")
+	assert.Equal("", ep.Handle(ctx))
+	assert.Nil(ctx.GetOutputResponse())
+	assert.Equal("hello", string(ctx.GetInputRequest().(*httpprot.Request).RawPayload()))
+
+	assert.Equal(uint64(1), ep.Status().(*Status).ShortCircuited)
+}
+
+func TestFailureMode(t *testing.T) {
+	assert := assert.New(t)
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte(`not json`))
+		}
+	}))
+	defer svr.Close()
+
+	ep := newTestProcessor(`
+kind: ExternalProcessor
+name: processor
+url: ` + svr.URL + `/slow
+timeout: 50ms
+`)
+	defer ep.Close()
+
+	for _, path := range []string{"/slow", "/error", "/invalid"} {
+		ep.spec.URL = svr.URL + path
+		ctx := newContext("hello")
+		assert.Equal(resultFailed, ep.Handle(ctx), path)
+		assert.Equal(http.StatusServiceUnavailable, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+	}
+
+	ep.spec.FailureMode = failureModeOpen
+	ctx := newContext("hello")
+	assert.Equal("", ep.Handle(ctx))
+	assert.Nil(ctx.GetOutputResponse())
+
+	assert.Equal(uint64(4), ep.Status().(*Status).Failures)
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError((&Spec{URL: "http://127.0.0.1", Timeout: "1s", FailureMode: "open"}).Validate())
+	assert.Error((&Spec{URL: "http://127.0.0.1", Timeout: "abc"}).Validate())
+	assert.Error((&Spec{URL: "http://127.0.0.1", Timeout: "0s"}).Validate())
+	assert.Error((&Spec{URL: "http://127.0.0.1", FailureMode: "unknown"}).Validate())
+}
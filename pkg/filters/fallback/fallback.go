@@ -19,12 +19,18 @@
 package fallback
 
 import (
+	"fmt"
 	"io"
 	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/proxies"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/prometheushelper"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
@@ -57,6 +63,10 @@ type (
 		spec       *Spec
 		mockBody   []byte
 		bodyLength string
+
+		responseTimeThreshold time.Duration
+		slowFallbacks         uint64
+		slowFallbackCounter   prometheus.Counter
 	}
 
 	// Spec describes the Fallback.
@@ -66,9 +76,40 @@ type (
 		MockCode    int               `json:"mockCode" jsonschema:"required,format=httpcode"`
 		MockHeaders map[string]string `json:"mockHeaders,omitempty"`
 		MockBody    string            `json:"mockBody,omitempty"`
+		// ResponseTime activates the fallback when the response time of
+		// the proxy exceeds the threshold.
+		ResponseTime *ResponseTimeSpec `json:"responseTime,omitempty"`
+	}
+
+	// ResponseTimeSpec describes the response time based fallback.
+	ResponseTimeSpec struct {
+		Threshold string `json:"threshold" jsonschema:"required,format=duration"`
+		// CompleteInBackground lets the slow request complete in the
+		// background after the fallback is returned, e.g. to warm the
+		// cache of the proxy.
+		CompleteInBackground bool `json:"completeInBackground,omitempty"`
+	}
+
+	// Status is the status of Fallback.
+	Status struct {
+		// SlowFallbacks is the number of fallbacks activated by the
+		// response time.
+		SlowFallbacks uint64 `json:"slowFallbacks"`
 	}
 )
 
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if spec.ResponseTime == nil {
+		return nil
+	}
+	d, err := time.ParseDuration(spec.ResponseTime.Threshold)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("invalid response time threshold %s", spec.ResponseTime.Threshold)
+	}
+	return nil
+}
+
 // Name returns the name of the Fallback filter instance.
 func (f *Fallback) Name() string {
 	return f.spec.Name()
@@ -97,12 +138,54 @@ func (f *Fallback) Inherit(previousGeneration filters.Filter) {
 func (f *Fallback) reload() {
 	f.mockBody = []byte(f.spec.MockBody)
 	f.bodyLength = strconv.Itoa(len(f.mockBody))
+
+	if f.spec.ResponseTime != nil {
+		f.responseTimeThreshold, _ = time.ParseDuration(f.spec.ResponseTime.Threshold)
+		f.slowFallbackCounter = f.newSlowFallbackCounter()
+	}
+}
+
+func (f *Fallback) newSlowFallbackCounter() prometheus.Counter {
+	labels := prometheus.Labels{
+		"filterName":   f.Name(),
+		"kind":         Kind,
+		"clusterName":  "",
+		"clusterRole":  "",
+		"instanceName": "",
+	}
+	if super := f.spec.Super(); super != nil && super.Options() != nil {
+		labels["clusterName"] = super.Options().ClusterName
+		labels["clusterRole"] = super.Options().ClusterRole
+		labels["instanceName"] = super.Options().Name
+	}
+	return prometheushelper.NewCounter("fallback_slow_responses",
+		"the total count of fallbacks activated by the response time",
+		[]string{"clusterName", "clusterRole", "instanceName", "filterName", "kind"},
+	).With(labels)
 }
 
 // Handle fallbacks HTTPContext.
-// It always returns fallback.
+//
+// If the response time based fallback is enabled, and the filter is
+// placed before the Proxy, that is, there's no response, it limits the
+// response time of the Proxy and returns an empty result. Otherwise, it
+// always returns fallback.
 func (f *Fallback) Handle(ctx *context.Context) string {
-	resp := ctx.GetInputResponse().(*httpprot.Response)
+	resp, _ := ctx.GetInputResponse().(*httpprot.Response)
+	if f.responseTimeThreshold > 0 {
+		limit, _ := ctx.GetData(proxies.ResponseTimeLimitKey).(*proxies.ResponseTimeLimit)
+		if resp == nil && limit == nil {
+			limit = proxies.NewResponseTimeLimit(f.responseTimeThreshold, f.spec.ResponseTime.CompleteInBackground)
+			ctx.SetData(proxies.ResponseTimeLimitKey, limit)
+			return ""
+		}
+		if limit != nil && limit.Exceeded() {
+			atomic.AddUint64(&f.slowFallbacks, 1)
+			f.slowFallbackCounter.Inc()
+			ctx.AddTag("fallback: response time exceeded")
+		}
+	}
+
 	if resp == nil {
 		return resultResponseNotFound
 	}
@@ -125,7 +208,10 @@ func (f *Fallback) Handle(ctx *context.Context) string {
 
 // Status returns Status.
 func (f *Fallback) Status() interface{} {
-	return nil
+	if f.responseTimeThreshold <= 0 {
+		return nil
+	}
+	return &Status{SlowFallbacks: atomic.LoadUint64(&f.slowFallbacks)}
 }
 
 // Close closes Fallback.
@@ -20,15 +20,22 @@ package fallback
 import (
 	"io"
 	"testing"
+	"time"
 
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/proxies"
+	"github.com/megaease/easegress/v2/pkg/logger"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
 	"github.com/megaease/easegress/v2/pkg/tracing"
 	"github.com/megaease/easegress/v2/pkg/util/codectool"
 	"github.com/stretchr/testify/assert"
 )
 
+func init() {
+	logger.InitNop()
+}
+
 func TestFallback(t *testing.T) {
 	assert := assert.New(t)
 	const yamlConfig = `
@@ -98,3 +105,45 @@ mockBody: "mocked body"
 		t.Error("header is not correct")
 	}
 }
+
+func TestResponseTimeFallback(t *testing.T) {
+	assert := assert.New(t)
+	const yamlConfig = `
+kind: Fallback
+name: fallback
+mockCode: 200
+mockBody: "mocked body"
+responseTime:
+  threshold: 100ms
+  completeInBackground: true
+`
+	rawSpec := make(map[string]interface{})
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	assert.Nil(err)
+
+	fb := kind.CreateInstance(spec)
+	fb.Init()
+
+	// before the proxy, it limits the response time.
+	ctx := context.New(tracing.NoopSpan)
+	assert.Equal("", fb.Handle(ctx))
+	limit := ctx.GetData(proxies.ResponseTimeLimitKey).(*proxies.ResponseTimeLimit)
+	assert.Equal(100*time.Millisecond, limit.Threshold)
+	assert.True(limit.CompleteInBackground)
+
+	// after the proxy, the fallback is counted if the limit is exceeded.
+	resp, _ := httpprot.NewResponse(nil)
+	ctx.SetInputResponse(resp)
+	assert.Equal(resultFallback, fb.Handle(ctx))
+	assert.Equal(uint64(0), fb.Status().(*Status).SlowFallbacks)
+
+	limit.Exceed()
+	assert.Equal(resultFallback, fb.Handle(ctx))
+	assert.Equal(200, resp.StatusCode())
+	assert.Equal(uint64(1), fb.Status().(*Status).SlowFallbacks)
+
+	rawSpec["responseTime"] = map[string]interface{}{"threshold": "-1s"}
+	_, err = filters.NewSpec(nil, "", rawSpec)
+	assert.Error(err)
+}
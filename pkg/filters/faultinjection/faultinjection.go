@@ -0,0 +1,308 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package faultinjection implements a filter which injects faults into
+// requests for chaos testing.
+package faultinjection
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of FaultInjection.
+	Kind = "FaultInjection"
+
+	resultAborted = "aborted"
+
+	// HeaderFaultInjected is the header added to responses of aborted
+	// requests, so that they are not mistaken for real failures.
+	HeaderFaultInjected = "X-EG-Fault-Injected"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "FaultInjection injects delays and aborts into requests for chaos testing.",
+	Results:     []string{resultAborted},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &FaultInjection{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// FaultInjection is the filter FaultInjection.
+	FaultInjection struct {
+		spec *Spec
+
+		delayed uint64
+		aborted uint64
+	}
+
+	// Spec is the spec of FaultInjection.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		Rules []*Rule `json:"rules" jsonschema:"required,minItems=1"`
+	}
+
+	// Rule is a fault injection rule, the delay and abort of a rule are
+	// injected independently.
+	Rule struct {
+		Match *MatchRule `json:"match,omitempty"`
+		Delay *Delay     `json:"delay,omitempty"`
+		Abort *Abort     `json:"abort,omitempty"`
+	}
+
+	// MatchRule is the rule to match a request, an empty rule matches
+	// all requests.
+	MatchRule struct {
+		Methods         []string                             `json:"methods,omitempty" jsonschema:"uniqueItems=true,format=httpmethod-array"`
+		Path            string                               `json:"path,omitempty" jsonschema:"pattern=^/"`
+		PathPrefix      string                               `json:"pathPrefix,omitempty" jsonschema:"pattern=^/"`
+		Headers         map[string]*stringtool.StringMatcher `json:"headers,omitempty"`
+		MatchAllHeaders bool                                 `json:"matchAllHeaders,omitempty"`
+	}
+
+	// Delay is the delay to inject.
+	Delay struct {
+		// Percentage is the percentage of matched requests to delay.
+		Percentage float64 `json:"percentage,omitempty" jsonschema:"minimum=0,maximum=100"`
+		FixedDelay string  `json:"fixedDelay" jsonschema:"required,format=duration"`
+
+		fixedDelay time.Duration
+	}
+
+	// Abort is the abort to inject.
+	Abort struct {
+		// Percentage is the percentage of matched requests to abort.
+		Percentage float64 `json:"percentage,omitempty" jsonschema:"minimum=0,maximum=100"`
+		StatusCode int     `json:"statusCode" jsonschema:"required,format=httpcode"`
+		Body       string  `json:"body,omitempty"`
+	}
+
+	// Status is the status of FaultInjection.
+	Status struct {
+		Delayed uint64 `json:"delayed"`
+		Aborted uint64 `json:"aborted"`
+	}
+)
+
+// Validate validates the Rule.
+func (r *Rule) Validate() error {
+	if r.Delay == nil && r.Abort == nil {
+		return fmt.Errorf("neither delay nor abort is specified")
+	}
+	return nil
+}
+
+// Validate validates the MatchRule.
+func (mr *MatchRule) Validate() error {
+	for _, h := range mr.Headers {
+		if err := h.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate validates the Delay.
+func (d *Delay) Validate() error {
+	v, err := time.ParseDuration(d.FixedDelay)
+	if err != nil || v <= 0 {
+		return fmt.Errorf("invalid fixedDelay %q", d.FixedDelay)
+	}
+	return nil
+}
+
+// Name returns the name of the FaultInjection filter instance.
+func (fi *FaultInjection) Name() string {
+	return fi.spec.Name()
+}
+
+// Kind returns the kind of FaultInjection.
+func (fi *FaultInjection) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the FaultInjection
+func (fi *FaultInjection) Spec() filters.Spec {
+	return fi.spec
+}
+
+// Init initializes FaultInjection.
+func (fi *FaultInjection) Init() {
+	fi.reload()
+}
+
+// Inherit inherits previous generation of FaultInjection.
+func (fi *FaultInjection) Inherit(previousGeneration filters.Filter) {
+	fi.reload()
+}
+
+func (fi *FaultInjection) reload() {
+	for _, r := range fi.spec.Rules {
+		if r.Delay != nil {
+			r.Delay.fixedDelay, _ = time.ParseDuration(r.Delay.FixedDelay)
+		}
+	}
+}
+
+// hit returns whether a fault of the percentage should be injected, a zero
+// percentage never injects faults.
+func hit(percentage float64) bool {
+	if percentage <= 0 {
+		return false
+	}
+	return percentage >= 100 || rand.Float64()*100 < percentage
+}
+
+func (mr *MatchRule) match(req *httpprot.Request) bool {
+	if mr == nil {
+		return true
+	}
+
+	if len(mr.Methods) > 0 && !stringtool.StrInSlice(req.Method(), mr.Methods) {
+		return false
+	}
+
+	path := req.Path()
+	if mr.Path != "" || mr.PathPrefix != "" {
+		if mr.Path != path && (mr.PathPrefix == "" || !strings.HasPrefix(path, mr.PathPrefix)) {
+			return false
+		}
+	}
+
+	if len(mr.Headers) == 0 {
+		return true
+	}
+
+	header := req.HTTPHeader()
+	matchOneHeader := func(key string, rule *stringtool.StringMatcher) bool {
+		values := header.Values(key)
+		if len(values) == 0 {
+			return rule.Empty
+		}
+		if rule.Empty {
+			return false
+		}
+		for _, v := range values {
+			if rule.Match(v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for key, r := range mr.Headers {
+		if matchOneHeader(key, r) {
+			if !mr.MatchAllHeaders {
+				return true
+			}
+		} else if mr.MatchAllHeaders {
+			return false
+		}
+	}
+	return mr.MatchAllHeaders
+}
+
+// Handle injects faults into the request according to the first matched
+// rule, the delay is injected before the abort.
+func (fi *FaultInjection) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	var rule *Rule
+	for _, r := range fi.spec.Rules {
+		if r.Match.match(req) {
+			rule = r
+			break
+		}
+	}
+	if rule == nil {
+		return ""
+	}
+
+	if rule.Delay != nil && hit(rule.Delay.Percentage) {
+		fi.delay(ctx, req, rule.Delay.fixedDelay)
+	}
+
+	if rule.Abort != nil && hit(rule.Abort.Percentage) {
+		fi.abort(ctx, rule.Abort)
+		return resultAborted
+	}
+
+	return ""
+}
+
+func (fi *FaultInjection) delay(ctx *context.Context, req *httpprot.Request, d time.Duration) {
+	atomic.AddUint64(&fi.delayed, 1)
+	ctx.AddTag(fmt.Sprintf("faultInjection: delayed %v", d))
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-req.Context().Done():
+		logger.Debugf("%s: request cancelled in the middle of delay injection", fi.Name())
+	case <-timer.C:
+	}
+}
+
+func (fi *FaultInjection) abort(ctx *context.Context, abort *Abort) {
+	atomic.AddUint64(&fi.aborted, 1)
+	ctx.AddTag(fmt.Sprintf("faultInjection: aborted with %d", abort.StatusCode))
+
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+
+	resp.SetStatusCode(abort.StatusCode)
+	resp.HTTPHeader().Set(HeaderFaultInjected, "abort")
+	if abort.Body != "" {
+		resp.SetPayload([]byte(abort.Body))
+	}
+	ctx.SetOutputResponse(resp)
+}
+
+// Status returns status.
+func (fi *FaultInjection) Status() interface{} {
+	return &Status{
+		Delayed: atomic.LoadUint64(&fi.delayed),
+		Aborted: atomic.LoadUint64(&fi.aborted),
+	}
+}
+
+// Close closes FaultInjection.
+func (fi *FaultInjection) Close() {
+}
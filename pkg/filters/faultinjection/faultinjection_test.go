@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package faultinjection
+
+import (
+	stdcontext "context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func createFaultInjection(t *testing.T, yamlConfig string) *FaultInjection {
+	rawSpec := make(map[string]interface{})
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		t.Fatalf("failed to create spec: %v", err)
+	}
+	fi := kind.CreateInstance(spec).(*FaultInjection)
+	fi.Init()
+	return fi
+}
+
+func newContext(t *testing.T, stdctx stdcontext.Context, method, url string, header map[string]string) *context.Context {
+	stdr, _ := http.NewRequestWithContext(stdctx, method, url, nil)
+	for k, v := range header {
+		stdr.Header.Set(k, v)
+	}
+	req, err := httpprot.NewRequest(stdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	invalid := []string{
+		"kind: FaultInjection\nname: fi",
+		"kind: FaultInjection\nname: fi\nrules:\n- match:\n    path: /a",
+		"kind: FaultInjection\nname: fi\nrules:\n- delay:\n    percentage: 10",
+		"kind: FaultInjection\nname: fi\nrules:\n- delay:\n    fixedDelay: abc",
+		"kind: FaultInjection\nname: fi\nrules:\n- abort:\n    percentage: 10",
+		"kind: FaultInjection\nname: fi\nrules:\n- abort:\n    statusCode: 503\n    percentage: 101",
+	}
+	for _, c := range invalid {
+		rawSpec := make(map[string]interface{})
+		codectool.MustUnmarshal([]byte(c), &rawSpec)
+		_, err := filters.NewSpec(nil, "", rawSpec)
+		assert.Error(err, c)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	assert := assert.New(t)
+
+	fi := createFaultInjection(t, `
+kind: FaultInjection
+name: fi
+rules:
+- match:
+    methods: [POST]
+    pathPrefix: /orders/
+  abort:
+    percentage: 100
+    statusCode: 503
+    body: injected
+- match:
+    path: /users
+    headers:
+      X-Chaos:
+        exact: "true"
+  delay:
+    percentage: 100
+    fixedDelay: 20ms
+  abort:
+    percentage: 100
+    statusCode: 500
+- match:
+    path: /safe
+  delay:
+    fixedDelay: 10s
+  abort:
+    statusCode: 500
+`)
+	assert.Equal(kind, fi.Kind())
+	assert.Equal("fi", fi.Name())
+
+	// matched, aborted
+	ctx := newContext(t, stdcontext.Background(), http.MethodPost, "http://127.0.0.1/orders/1", nil)
+	assert.Equal(resultAborted, fi.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(503, resp.StatusCode())
+	assert.Equal("abort", resp.Std().Header.Get(HeaderFaultInjected))
+	assert.Equal("injected", string(resp.RawPayload()))
+
+	// method not matched
+	ctx = newContext(t, stdcontext.Background(), http.MethodGet, "http://127.0.0.1/orders/1", nil)
+	assert.Equal("", fi.Handle(ctx))
+	assert.Nil(ctx.GetOutputResponse())
+
+	// header not matched
+	ctx = newContext(t, stdcontext.Background(), http.MethodGet, "http://127.0.0.1/users", nil)
+	assert.Equal("", fi.Handle(ctx))
+
+	// delayed and aborted
+	start := time.Now()
+	ctx = newContext(t, stdcontext.Background(), http.MethodGet, "http://127.0.0.1/users", map[string]string{"X-Chaos": "true"})
+	assert.Equal(resultAborted, fi.Handle(ctx))
+	assert.GreaterOrEqual(time.Since(start), 20*time.Millisecond)
+	assert.Equal(500, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	// zero percentages never inject faults
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		ctx = newContext(t, stdcontext.Background(), http.MethodGet, "http://127.0.0.1/safe", nil)
+		assert.Equal("", fi.Handle(ctx))
+	}
+	assert.Less(time.Since(start), 5*time.Second)
+
+	status := fi.Status().(*Status)
+	assert.Equal(uint64(1), status.Delayed)
+	assert.Equal(uint64(2), status.Aborted)
+
+	fi.Inherit(fi)
+	fi.Close()
+}
+
+func TestDelayCancelled(t *testing.T) {
+	assert := assert.New(t)
+
+	fi := createFaultInjection(t, `
+kind: FaultInjection
+name: fi
+rules:
+- delay:
+    percentage: 100
+    fixedDelay: 10s
+`)
+
+	stdctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	ctx := newContext(t, stdctx, http.MethodGet, "http://127.0.0.1/", nil)
+	assert.Equal("", fi.Handle(ctx))
+	assert.Less(time.Since(start), 5*time.Second)
+}
@@ -19,6 +19,7 @@
 package filters
 
 import (
+	stdcontext "context"
 	"fmt"
 
 	"github.com/megaease/easegress/v2/pkg/context"
@@ -28,6 +29,14 @@ import (
 	"github.com/megaease/easegress/v2/pkg/v"
 )
 
+const (
+	// HealthHealthy means the filter works normally.
+	HealthHealthy = "healthy"
+	// HealthDegraded means the filter is rejecting or limiting requests,
+	// e.g. a circuit breaker is open.
+	HealthDegraded = "degraded"
+)
+
 type (
 	// Kind contains the meta data and functions of a filter kind.
 	Kind struct {
@@ -41,6 +50,15 @@ type (
 		// result (i.e. empty string).
 		Results []string
 
+		// RequestOnly indicates the filter only handles requests, so it
+		// can't be used in the response flow of a pipeline.
+		RequestOnly bool
+
+		// RequiresBuffering indicates the filter needs the whole body of
+		// requests in memory, so it can't be used in a pipeline streaming
+		// the body of requests.
+		RequiresBuffering bool
+
 		// CreateInstance creates a new filter instance of the kind.
 		CreateInstance func(spec Spec) Filter
 
@@ -82,6 +100,47 @@ type (
 		Close()
 	}
 
+	// Drainer is the interface of filters holding in-flight work, like
+	// batching or asynchronous publishing. Drain is called before Close when
+	// the pipeline is reloaded or closed, to give the filter a chance to
+	// finish the pending work.
+	Drainer interface {
+		// Drain flushes the pending work, it returns when the work is done
+		// or ctx is done, whichever comes first, and returns ctx.Err() in
+		// the latter case. Work not finished on expiry may be dropped by
+		// Close. The filter won't handle new requests after Drain.
+		Drain(ctx stdcontext.Context) error
+	}
+
+	// HealthReporter is the interface of filters which could report their
+	// health, the pipeline aggregates the health of its filters into its
+	// status, for example, a filter is degraded when its circuit breaker is
+	// open.
+	HealthReporter interface {
+		HealthReport() *HealthReport
+	}
+
+	// HealthReport is the health of a filter.
+	HealthReport struct {
+		// Health is either HealthHealthy or HealthDegraded.
+		Health string `json:"health"`
+		// Reason explains why the filter is degraded.
+		Reason string `json:"reason,omitempty"`
+		// Metrics are the key metrics of the filter.
+		Metrics map[string]interface{} `json:"metrics,omitempty"`
+	}
+
+	// Recreator is the interface of filters which opt out of being reused
+	// by the next generation of the pipeline. By default, a filter whose
+	// spec is not changed is reused as is when the pipeline is reloaded,
+	// filters whose behavior depends on more than their spec should
+	// implement this interface to be recreated and inherit the previous
+	// generation on every reload.
+	Recreator interface {
+		// AlwaysRecreate reports whether the filter must be recreated.
+		AlwaysRecreate() bool
+	}
+
 	// Resiliencer is the interface of objects that accept resilience policies.
 	Resiliencer interface {
 		InjectResiliencePolicy(policies map[string]resilience.Policy)
@@ -0,0 +1,430 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package fragmentcomposer implements a filter which composes responses
+// from fragments by processing include directives in the response body.
+package fragmentcomposer
+
+import (
+	stdcontext "context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cache "github.com/patrickmn/go-cache"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of FragmentComposer.
+	Kind = "FragmentComposer"
+
+	resultIncludeFailed = "includeFailed"
+
+	// OnErrorPlaceholder replaces failed includes with the placeholder.
+	OnErrorPlaceholder = "placeholder"
+	// OnErrorFail fails the response on failed includes.
+	OnErrorFail = "fail"
+
+	defaultTimeout         = 2 * time.Second
+	defaultMaxConcurrency  = 4
+	defaultMaxIncludes     = 16
+	defaultMaxFragmentSize = 1024 * 1024
+	defaultMaxBodySize     = 4 * 1024 * 1024
+	minCleanupInterval     = time.Minute
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "FragmentComposer composes responses by replacing include directives with fragments fetched from backends.",
+	Results:     []string{resultIncludeFailed},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{OnError: OnErrorPlaceholder}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &FragmentComposer{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+var (
+	// htmlInclude is the include directive of HTML and other textual
+	// bodies, like <esi:include src="/fragments/header"/>.
+	htmlInclude = regexp.MustCompile(`<esi:include\s+src="([^"]*)"\s*/>`)
+	// jsonInclude is the include directive of JSON bodies, like
+	// {"$include": "/fragments/user"}. As quotes in JSON strings are
+	// escaped, it never matches the content of a string.
+	jsonInclude = regexp.MustCompile(`\{\s*"\$include"\s*:\s*"([^"\\]*)"\s*\}`)
+)
+
+type (
+	// FragmentComposer is the filter FragmentComposer.
+	FragmentComposer struct {
+		spec *Spec
+
+		baseURL *url.URL
+		client  *http.Client
+		cache   *cache.Cache
+
+		composed uint64
+		fetched  uint64
+		cached   uint64
+		failed   uint64
+	}
+
+	// Spec is the spec of FragmentComposer.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// BaseURL is the URL which the paths of the includes are
+		// relative to.
+		BaseURL string `json:"baseURL" jsonschema:"required,format=uri"`
+		Timeout string `json:"timeout,omitempty" jsonschema:"format=duration"`
+		// CacheTTL is the time to cache the fragments, fragments are not
+		// cached if it is empty.
+		CacheTTL        string `json:"cacheTTL,omitempty" jsonschema:"format=duration"`
+		MaxConcurrency  int    `json:"maxConcurrency,omitempty" jsonschema:"minimum=0"`
+		MaxIncludes     int    `json:"maxIncludes,omitempty" jsonschema:"minimum=0"`
+		MaxFragmentSize int64  `json:"maxFragmentSize,omitempty" jsonschema:"minimum=0"`
+		MaxBodySize     int64  `json:"maxBodySize,omitempty" jsonschema:"minimum=0"`
+		OnError         string `json:"onError,omitempty" jsonschema:"enum=placeholder,enum=fail"`
+		// Placeholder replaces the failed includes, it is empty for
+		// textual bodies and null for JSON bodies by default.
+		Placeholder *string `json:"placeholder,omitempty"`
+	}
+
+	// Status is the status of FragmentComposer.
+	Status struct {
+		Composed uint64 `json:"composed"`
+		Fetched  uint64 `json:"fetched"`
+		Cached   uint64 `json:"cached"`
+		Failed   uint64 `json:"failed"`
+	}
+
+	// fragment is the result of an include.
+	fragment struct {
+		body []byte
+		err  error
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	u, err := url.Parse(spec.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid baseURL %q", spec.BaseURL)
+	}
+	for _, d := range []string{spec.Timeout, spec.CacheTTL} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return fmt.Errorf("invalid duration %q", d)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the FragmentComposer filter instance.
+func (fc *FragmentComposer) Name() string {
+	return fc.spec.Name()
+}
+
+// Kind returns the kind of FragmentComposer.
+func (fc *FragmentComposer) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the FragmentComposer
+func (fc *FragmentComposer) Spec() filters.Spec {
+	return fc.spec
+}
+
+// Init initializes FragmentComposer.
+func (fc *FragmentComposer) Init() {
+	fc.reload()
+}
+
+// Inherit inherits previous generation of FragmentComposer.
+func (fc *FragmentComposer) Inherit(previousGeneration filters.Filter) {
+	fc.reload()
+}
+
+func (fc *FragmentComposer) reload() {
+	fc.baseURL, _ = url.Parse(fc.spec.BaseURL)
+
+	timeout := defaultTimeout
+	if fc.spec.Timeout != "" {
+		timeout, _ = time.ParseDuration(fc.spec.Timeout)
+	}
+	fc.client = &http.Client{Timeout: timeout}
+
+	fc.cache = nil
+	if fc.spec.CacheTTL != "" {
+		ttl, _ := time.ParseDuration(fc.spec.CacheTTL)
+		cleanupInterval := ttl * 2
+		if cleanupInterval < minCleanupInterval {
+			cleanupInterval = minCleanupInterval
+		}
+		fc.cache = cache.New(ttl, cleanupInterval)
+	}
+}
+
+func (fc *FragmentComposer) maxConcurrency() int {
+	if fc.spec.MaxConcurrency > 0 {
+		return fc.spec.MaxConcurrency
+	}
+	return defaultMaxConcurrency
+}
+
+func (fc *FragmentComposer) maxIncludes() int {
+	if fc.spec.MaxIncludes > 0 {
+		return fc.spec.MaxIncludes
+	}
+	return defaultMaxIncludes
+}
+
+func (fc *FragmentComposer) maxFragmentSize() int64 {
+	if fc.spec.MaxFragmentSize > 0 {
+		return fc.spec.MaxFragmentSize
+	}
+	return defaultMaxFragmentSize
+}
+
+func (fc *FragmentComposer) maxBodySize() int64 {
+	if fc.spec.MaxBodySize > 0 {
+		return fc.spec.MaxBodySize
+	}
+	return defaultMaxBodySize
+}
+
+// isJSON returns whether the media type is a JSON one.
+func isJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// resolve resolves the path of an include against the base URL, only paths
+// are allowed, so that fragments can't be fetched from arbitrary hosts.
+func (fc *FragmentComposer) resolve(src string) (string, error) {
+	if !strings.HasPrefix(src, "/") || strings.HasPrefix(src, "//") {
+		return "", fmt.Errorf("include %q is not a path", src)
+	}
+	ref, err := url.Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("invalid include %q: %v", src, err)
+	}
+	u := *fc.baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + ref.Path
+	u.RawPath = ""
+	u.RawQuery = ref.RawQuery
+	return u.String(), nil
+}
+
+// fetch fetches a fragment, from the cache if possible.
+func (fc *FragmentComposer) fetch(ctx stdcontext.Context, src string, jsonBody bool) ([]byte, error) {
+	u, err := fc.resolve(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if fc.cache != nil {
+		if body, ok := fc.cache.Get(u); ok {
+			atomic.AddUint64(&fc.cached, 1)
+			return body.([]byte), nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	atomic.AddUint64(&fc.fetched, 1)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("fragment %s: status code %d", src, resp.StatusCode)
+	}
+
+	max := fc.maxFragmentSize()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > max {
+		return nil, fmt.Errorf("fragment %s: too large", src)
+	}
+	if jsonBody && !json.Valid(body) {
+		return nil, fmt.Errorf("fragment %s: invalid JSON", src)
+	}
+
+	if fc.cache != nil {
+		fc.cache.SetDefault(u, body)
+	}
+	return body, nil
+}
+
+// fetchAll fetches the fragments of the includes in parallel, with at most
+// maxConcurrency fetches at the same time.
+func (fc *FragmentComposer) fetchAll(ctx stdcontext.Context, srcs []string, jsonBody bool) map[string]*fragment {
+	fragments := make(map[string]*fragment, len(srcs))
+	for _, src := range srcs {
+		fragments[src] = &fragment{}
+	}
+
+	sem := make(chan struct{}, fc.maxConcurrency())
+	wg := &sync.WaitGroup{}
+	for src, f := range fragments {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(src string, f *fragment) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			f.body, f.err = fc.fetch(ctx, src, jsonBody)
+		}(src, f)
+	}
+	wg.Wait()
+
+	return fragments
+}
+
+func (fc *FragmentComposer) fail(ctx *context.Context, resp *httpprot.Response, reason string) string {
+	atomic.AddUint64(&fc.failed, 1)
+	ctx.AddTag(fmt.Sprintf("fragmentComposer: %s", reason))
+	resp.SetStatusCode(http.StatusBadGateway)
+	resp.SetPayload(nil)
+	resp.HTTPHeader().Del("Content-Length")
+	return resultIncludeFailed
+}
+
+// Handle replaces the include directives in the response body with the
+// fragments.
+func (fc *FragmentComposer) Handle(ctx *context.Context) string {
+	resp, _ := ctx.GetInputResponse().(*httpprot.Response)
+	if resp == nil {
+		return ""
+	}
+
+	h := resp.HTTPHeader()
+	if ce := h.Get("Content-Encoding"); ce != "" && ce != "identity" {
+		return ""
+	}
+	if resp.IsStream() || resp.PayloadSize() > fc.maxBodySize() {
+		logger.Debugf("%s: body too large to compose", fc.Name())
+		return ""
+	}
+
+	jsonBody := isJSON(h.Get("Content-Type"))
+	re, placeholder := htmlInclude, ""
+	if jsonBody {
+		re, placeholder = jsonInclude, "null"
+	}
+	if fc.spec.Placeholder != nil {
+		placeholder = *fc.spec.Placeholder
+	}
+
+	body := resp.RawPayload()
+	matches := re.FindAllSubmatchIndex(body, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+	if len(matches) > fc.maxIncludes() {
+		if fc.spec.OnError == OnErrorFail {
+			return fc.fail(ctx, resp, "too many includes")
+		}
+		matches = matches[:fc.maxIncludes()]
+	}
+
+	var srcs []string
+	seen := map[string]struct{}{}
+	for _, m := range matches {
+		src := string(body[m[2]:m[3]])
+		if _, ok := seen[src]; !ok {
+			seen[src] = struct{}{}
+			srcs = append(srcs, src)
+		}
+	}
+
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	fragments := fc.fetchAll(req.Context(), srcs, jsonBody)
+
+	composed := make([]byte, 0, len(body))
+	last := 0
+	for _, m := range matches {
+		composed = append(composed, body[last:m[0]]...)
+		last = m[1]
+
+		f := fragments[string(body[m[2]:m[3]])]
+		if f.err == nil {
+			composed = append(composed, f.body...)
+			continue
+		}
+		if fc.spec.OnError == OnErrorFail {
+			return fc.fail(ctx, resp, f.err.Error())
+		}
+		atomic.AddUint64(&fc.failed, 1)
+		ctx.AddTag(fmt.Sprintf("fragmentComposer: %v", f.err))
+		composed = append(composed, placeholder...)
+	}
+	composed = append(composed, body[last:]...)
+
+	atomic.AddUint64(&fc.composed, 1)
+	resp.SetPayload(composed)
+	if h.Get("Content-Length") != "" {
+		h.Set("Content-Length", strconv.Itoa(len(composed)))
+	}
+	// the validators of the backend response don't apply to the composed
+	// one.
+	h.Del("ETag")
+	return ""
+}
+
+// Status returns status.
+func (fc *FragmentComposer) Status() interface{} {
+	return &Status{
+		Composed: atomic.LoadUint64(&fc.composed),
+		Fetched:  atomic.LoadUint64(&fc.fetched),
+		Cached:   atomic.LoadUint64(&fc.cached),
+		Failed:   atomic.LoadUint64(&fc.failed),
+	}
+}
+
+// Close closes FragmentComposer.
+func (fc *FragmentComposer) Close() {
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fragmentcomposer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestFilter(yamlConfig string) *FragmentComposer {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		panic(err)
+	}
+	fc := kind.CreateInstance(spec).(*FragmentComposer)
+	fc.Init()
+	return fc
+}
+
+func newContext(contentType, body string) (*context.Context, *httpprot.Response) {
+	stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	req, _ := httpprot.NewRequest(stdr)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+
+	resp, _ := httpprot.NewResponse(nil)
+	resp.HTTPHeader().Set("Content-Type", contentType)
+	resp.HTTPHeader().Set("Content-Length", fmt.Sprint(len(body)))
+	resp.SetPayload(body)
+	ctx.SetInputResponse(resp)
+	return ctx, resp
+}
+
+func newFragmentServer(hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		switch r.URL.Path {
+		case "/fragments/header":
+			w.Write([]byte("<h1>Header</h1>"))
+		case "/fragments/user":
+			w.Write([]byte(`{"name":"alice"}`))
+		case "/fragments/text":
+			w.Write([]byte("not json"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, baseURL := range []string{"", "ftp://example.com", "http://"} {
+		spec := &Spec{BaseURL: baseURL}
+		assert.Error(spec.Validate(), baseURL)
+	}
+
+	spec := &Spec{BaseURL: "http://example.com", CacheTTL: "abc"}
+	assert.Error(spec.Validate())
+
+	spec = &Spec{BaseURL: "http://example.com/base/", Timeout: "1s", CacheTTL: "1m"}
+	assert.NoError(spec.Validate())
+}
+
+func TestHTMLInclude(t *testing.T) {
+	assert := assert.New(t)
+
+	var hits int32
+	server := newFragmentServer(&hits)
+	defer server.Close()
+
+	fc := newTestFilter(fmt.Sprintf(`
+kind: FragmentComposer
+name: fc
+baseURL: %s
+placeholder: "<!-- missing -->"
+`, server.URL))
+	assert.Equal(kind, fc.Kind())
+	assert.Equal("fc", fc.Name())
+
+	body := `<esi:include src="/fragments/header"/><p>x</p><esi:include src="/fragments/missing" /><esi:include src="/fragments/header"/>`
+	ctx, resp := newContext("text/html", body)
+	assert.Empty(fc.Handle(ctx))
+
+	expected := "<h1>Header</h1><p>x</p><!-- missing --><h1>Header</h1>"
+	assert.Equal(expected, string(resp.RawPayload()))
+	assert.Equal(fmt.Sprint(len(expected)), resp.HTTPHeader().Get("Content-Length"))
+	// duplicated includes are fetched only once.
+	assert.Equal(int32(2), atomic.LoadInt32(&hits))
+
+	status := fc.Status().(*Status)
+	assert.Equal(uint64(1), status.Composed)
+	assert.Equal(uint64(1), status.Failed)
+
+	// includes must be paths.
+	ctx, resp = newContext("text/html", `a<esi:include src="http://evil.com/x"/>b`)
+	assert.Empty(fc.Handle(ctx))
+	assert.Equal("a<!-- missing -->b", string(resp.RawPayload()))
+	assert.Equal(int32(2), atomic.LoadInt32(&hits))
+
+	// encoded bodies are not composed.
+	ctx, resp = newContext("text/html", body)
+	resp.HTTPHeader().Set("Content-Encoding", "gzip")
+	assert.Empty(fc.Handle(ctx))
+	assert.Equal(body, string(resp.RawPayload()))
+}
+
+func TestJSONInclude(t *testing.T) {
+	assert := assert.New(t)
+
+	var hits int32
+	server := newFragmentServer(&hits)
+	defer server.Close()
+
+	fc := newTestFilter(fmt.Sprintf(`
+kind: FragmentComposer
+name: fc
+baseURL: %s
+`, server.URL))
+
+	body := `{"user": {"$include": "/fragments/user"}, "bad": { "$include" : "/fragments/text" }, "s": "{\"$include\": \"/fragments/user\"}"}`
+	ctx, resp := newContext("application/json; charset=utf-8", body)
+	assert.Empty(fc.Handle(ctx))
+	assert.Equal(`{"user": {"name":"alice"}, "bad": null, "s": "{\"$include\": \"/fragments/user\"}"}`, string(resp.RawPayload()))
+}
+
+func TestOnErrorFail(t *testing.T) {
+	assert := assert.New(t)
+
+	var hits int32
+	server := newFragmentServer(&hits)
+	defer server.Close()
+
+	fc := newTestFilter(fmt.Sprintf(`
+kind: FragmentComposer
+name: fc
+baseURL: %s
+onError: fail
+maxIncludes: 2
+`, server.URL))
+
+	ctx, resp := newContext("text/html", `<esi:include src="/fragments/missing"/>`)
+	assert.Equal(resultIncludeFailed, fc.Handle(ctx))
+	assert.Equal(http.StatusBadGateway, resp.StatusCode())
+	assert.Empty(resp.RawPayload())
+
+	ctx, resp = newContext("text/html", `<esi:include src="/a"/><esi:include src="/b"/><esi:include src="/c"/>`)
+	assert.Equal(resultIncludeFailed, fc.Handle(ctx))
+	assert.Equal(http.StatusBadGateway, resp.StatusCode())
+	assert.Equal(int32(1), atomic.LoadInt32(&hits))
+}
+
+func TestCache(t *testing.T) {
+	assert := assert.New(t)
+
+	var hits int32
+	server := newFragmentServer(&hits)
+	defer server.Close()
+
+	fc := newTestFilter(fmt.Sprintf(`
+kind: FragmentComposer
+name: fc
+baseURL: %s
+cacheTTL: 1m
+maxFragmentSize: 10
+`, server.URL))
+
+	for i := 0; i < 3; i++ {
+		ctx, resp := newContext("application/json", `[{"$include": "/fragments/user"}]`)
+		assert.Empty(fc.Handle(ctx))
+		// the fragment is larger than maxFragmentSize.
+		assert.Equal(`[null]`, string(resp.RawPayload()))
+	}
+	assert.Equal(int32(3), atomic.LoadInt32(&hits))
+
+	fc.spec.MaxFragmentSize = 0
+	for i := 0; i < 3; i++ {
+		ctx, resp := newContext("application/json", `[{"$include": "/fragments/user"}]`)
+		assert.Empty(fc.Handle(ctx))
+		assert.Equal(`[{"name":"alice"}]`, string(resp.RawPayload()))
+	}
+	assert.Equal(int32(4), atomic.LoadInt32(&hits))
+	assert.Equal(uint64(2), fc.Status().(*Status).Cached)
+	fc.Close()
+}
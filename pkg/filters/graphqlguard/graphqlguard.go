@@ -0,0 +1,458 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package graphqlguard implements a filter which limits the depth and
+// complexity of GraphQL queries.
+package graphqlguard
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of GraphQLGuard.
+	Kind = "GraphQLGuard"
+
+	resultRejected = "rejected"
+
+	defaultMaxQuerySize = 64 * 1024
+
+	codeInvalid       = "GRAPHQL_PARSE_FAILED"
+	codeTooLarge      = "QUERY_TOO_LARGE"
+	codeTooDeep       = "QUERY_TOO_DEEP"
+	codeTooComplex    = "QUERY_TOO_COMPLEX"
+	codeIntrospection = "INTROSPECTION_DISABLED"
+)
+
+var kind = &filters.Kind{
+	Name:              Kind,
+	Description:       "GraphQLGuard rejects GraphQL queries exceeding the depth or complexity limits.",
+	Results:           []string{resultRejected},
+	RequestOnly:       true,
+	RequiresBuffering: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{
+			MaxQuerySize:    defaultMaxQuerySize,
+			ErrorStatusCode: http.StatusBadRequest,
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &GraphQLGuard{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// GraphQLGuard is the filter GraphQLGuard.
+	GraphQLGuard struct {
+		spec *Spec
+
+		accepted      uint64
+		invalid       uint64
+		tooLarge      uint64
+		tooDeep       uint64
+		tooComplex    uint64
+		introspection uint64
+	}
+
+	// Spec is the spec of GraphQLGuard.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// MaxDepth is the max depth of the queries, 0 means no limit.
+		MaxDepth int `json:"maxDepth,omitempty" jsonschema:"minimum=0"`
+		// MaxComplexity is the max complexity of the queries, 0 means no
+		// limit.
+		MaxComplexity int64 `json:"maxComplexity,omitempty" jsonschema:"minimum=0"`
+		// ListArguments are the arguments limiting the size of a list
+		// field, like first and last, the complexity of the selections of
+		// the field is multiplied by the value of the argument.
+		ListArguments []string `json:"listArguments,omitempty"`
+		// BlockIntrospection rejects the queries of __schema and __type.
+		BlockIntrospection bool `json:"blockIntrospection,omitempty"`
+		// MaxQuerySize is the max size of the query in bytes.
+		MaxQuerySize int `json:"maxQuerySize,omitempty" jsonschema:"minimum=1"`
+		// ErrorStatusCode is the status code of the rejections, 400 or
+		// 200, the body is a GraphQL error response in both cases.
+		ErrorStatusCode int `json:"errorStatusCode,omitempty"`
+	}
+
+	// Status is the status of GraphQLGuard.
+	Status struct {
+		Accepted      uint64 `json:"accepted"`
+		Invalid       uint64 `json:"invalid"`
+		TooLarge      uint64 `json:"tooLarge"`
+		TooDeep       uint64 `json:"tooDeep"`
+		TooComplex    uint64 `json:"tooComplex"`
+		Introspection uint64 `json:"introspection"`
+	}
+
+	// request is a GraphQL request.
+	request struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+
+	// rejection is the reason of a rejection.
+	rejection struct {
+		code    string
+		message string
+	}
+
+	// measure is the measurement of selections.
+	measure struct {
+		depth         int
+		complexity    int64
+		introspection bool
+	}
+
+	// analyzer measures the operations of a document.
+	analyzer struct {
+		doc       *document
+		listArgs  []string
+		variables map[string]interface{}
+		defaults  map[string]int64
+		fragments map[string]*measure
+		visiting  map[string]bool
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	switch spec.ErrorStatusCode {
+	case 0, http.StatusOK, http.StatusBadRequest:
+	default:
+		return fmt.Errorf("errorStatusCode must be 200 or 400")
+	}
+	return nil
+}
+
+// Name returns the name of the GraphQLGuard filter instance.
+func (g *GraphQLGuard) Name() string {
+	return g.spec.Name()
+}
+
+// Kind returns the kind of GraphQLGuard.
+func (g *GraphQLGuard) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the GraphQLGuard
+func (g *GraphQLGuard) Spec() filters.Spec {
+	return g.spec
+}
+
+// Init initializes GraphQLGuard.
+func (g *GraphQLGuard) Init() {
+	if g.spec.MaxQuerySize == 0 {
+		g.spec.MaxQuerySize = defaultMaxQuerySize
+	}
+	if g.spec.ErrorStatusCode == 0 {
+		g.spec.ErrorStatusCode = http.StatusBadRequest
+	}
+}
+
+// Inherit inherits previous generation of GraphQLGuard.
+func (g *GraphQLGuard) Inherit(previousGeneration filters.Filter) {
+	g.Init()
+}
+
+// satAdd and satMul are saturating operations, so that the complexity of
+// a malicious query never overflows.
+func satAdd(a, b int64) int64 {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+func satMul(a, b int64) int64 {
+	if a != 0 && b > math.MaxInt64/a {
+		return math.MaxInt64
+	}
+	return a * b
+}
+
+// multiplier returns the multiplier of the complexity of the selections
+// of a field by its list arguments.
+func (a *analyzer) multiplier(sel *selection) int64 {
+	for _, name := range a.listArgs {
+		arg := sel.args[name]
+		if arg == nil {
+			continue
+		}
+		n := arg.intValue
+		if arg.variable != "" {
+			if v, ok := a.variables[arg.variable].(float64); ok {
+				n = int64(v)
+			} else {
+				n = a.defaults[arg.variable]
+			}
+		}
+		if n > 1 {
+			return n
+		}
+	}
+	return 1
+}
+
+// measureSelections measures the selections, the depth of fields is 1 and
+// every field costs 1 in the complexity.
+func (a *analyzer) measureSelections(sels []*selection) (*measure, error) {
+	m := &measure{}
+	for _, sel := range sels {
+		var sm *measure
+		var err error
+
+		switch {
+		case sel.field != "":
+			sm, err = a.measureSelections(sel.selections)
+			if err != nil {
+				return nil, err
+			}
+			sm.depth++
+			sm.complexity = satAdd(satMul(sm.complexity, a.multiplier(sel)), 1)
+			if sel.field == "__schema" || sel.field == "__type" {
+				sm.introspection = true
+			}
+		case sel.spread != "":
+			sm, err = a.measureFragment(sel.spread)
+		default:
+			sm, err = a.measureSelections(sel.selections)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if sm.depth > m.depth {
+			m.depth = sm.depth
+		}
+		m.complexity = satAdd(m.complexity, sm.complexity)
+		m.introspection = m.introspection || sm.introspection
+	}
+	return m, nil
+}
+
+// measureFragment measures a fragment, the measurements are cached, so
+// fragments spread many times are measured only once.
+func (a *analyzer) measureFragment(name string) (*measure, error) {
+	if m := a.fragments[name]; m != nil {
+		return &measure{depth: m.depth, complexity: m.complexity, introspection: m.introspection}, nil
+	}
+
+	sels, ok := a.doc.fragments[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown fragment %s", name)
+	}
+	if a.visiting[name] {
+		return nil, fmt.Errorf("cyclic fragment %s", name)
+	}
+
+	a.visiting[name] = true
+	m, err := a.measureSelections(sels)
+	delete(a.visiting, name)
+	if err != nil {
+		return nil, err
+	}
+
+	a.fragments[name] = &measure{depth: m.depth, complexity: m.complexity, introspection: m.introspection}
+	return m, nil
+}
+
+// check checks a GraphQL request, it returns nil if the request is
+// accepted.
+func (g *GraphQLGuard) check(r *request) *rejection {
+	if len(r.Query) > g.spec.MaxQuerySize {
+		atomic.AddUint64(&g.tooLarge, 1)
+		return &rejection{codeTooLarge, fmt.Sprintf("query size %d exceeds %d", len(r.Query), g.spec.MaxQuerySize)}
+	}
+
+	doc, err := parse(r.Query)
+	if err != nil {
+		atomic.AddUint64(&g.invalid, 1)
+		return &rejection{codeInvalid, err.Error()}
+	}
+
+	found := false
+	for _, op := range doc.operations {
+		if r.OperationName != "" && op.name != r.OperationName {
+			continue
+		}
+		found = true
+
+		// operations are measured separately, but they share the
+		// fragments, a document rarely has more than one operation.
+		a := &analyzer{
+			doc:       doc,
+			listArgs:  g.spec.ListArguments,
+			variables: r.Variables,
+			defaults:  op.defaults,
+			fragments: map[string]*measure{},
+			visiting:  map[string]bool{},
+		}
+		m, err := a.measureSelections(op.selections)
+		if err != nil {
+			atomic.AddUint64(&g.invalid, 1)
+			return &rejection{codeInvalid, err.Error()}
+		}
+
+		if g.spec.BlockIntrospection && m.introspection {
+			atomic.AddUint64(&g.introspection, 1)
+			return &rejection{codeIntrospection, "introspection is disabled"}
+		}
+		if g.spec.MaxDepth > 0 && m.depth > g.spec.MaxDepth {
+			atomic.AddUint64(&g.tooDeep, 1)
+			return &rejection{codeTooDeep, fmt.Sprintf("query depth %d exceeds %d", m.depth, g.spec.MaxDepth)}
+		}
+		if g.spec.MaxComplexity > 0 && m.complexity > g.spec.MaxComplexity {
+			atomic.AddUint64(&g.tooComplex, 1)
+			return &rejection{codeTooComplex, fmt.Sprintf("query complexity %d exceeds %d", m.complexity, g.spec.MaxComplexity)}
+		}
+	}
+
+	if !found {
+		atomic.AddUint64(&g.invalid, 1)
+		return &rejection{codeInvalid, fmt.Sprintf("unknown operation %s", r.OperationName)}
+	}
+	return nil
+}
+
+// requests returns the GraphQL requests carried by the HTTP request, a
+// request may carry a batch of GraphQL requests.
+func (g *GraphQLGuard) requests(req *httpprot.Request) ([]*request, *rejection) {
+	if req.Method() == http.MethodGet {
+		q := req.Std().URL.Query()
+		if !q.Has("query") {
+			return nil, nil
+		}
+		r := &request{Query: q.Get("query"), OperationName: q.Get("operationName")}
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &r.Variables); err != nil {
+				atomic.AddUint64(&g.invalid, 1)
+				return nil, &rejection{codeInvalid, "invalid variables"}
+			}
+		}
+		return []*request{r}, nil
+	}
+
+	if req.IsStream() {
+		atomic.AddUint64(&g.tooLarge, 1)
+		return nil, &rejection{codeTooLarge, "request body is too large"}
+	}
+
+	body := req.RawPayload()
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	mt, _, _ := mime.ParseMediaType(req.HTTPHeader().Get("Content-Type"))
+	if mt == "application/graphql" {
+		return []*request{{Query: string(body)}}, nil
+	}
+
+	var rs []*request
+	if body[0] == '[' {
+		if err := json.Unmarshal(body, &rs); err != nil {
+			rs = nil
+		}
+	} else {
+		r := &request{}
+		if err := json.Unmarshal(body, r); err == nil {
+			rs = []*request{r}
+		}
+	}
+	if len(rs) == 0 {
+		atomic.AddUint64(&g.invalid, 1)
+		return nil, &rejection{codeInvalid, "invalid GraphQL request"}
+	}
+	return rs, nil
+}
+
+// Handle checks the GraphQL queries of the request.
+func (g *GraphQLGuard) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	rs, rej := g.requests(req)
+	for _, r := range rs {
+		if r == nil {
+			continue
+		}
+		if rej = g.check(r); rej != nil {
+			break
+		}
+	}
+
+	if rej == nil {
+		if len(rs) > 0 {
+			atomic.AddUint64(&g.accepted, 1)
+		}
+		return ""
+	}
+	return g.reject(ctx, rej)
+}
+
+func (g *GraphQLGuard) reject(ctx *context.Context, rej *rejection) string {
+	ctx.AddTag(fmt.Sprintf("graphqlGuard: %s", rej.message))
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"errors": []interface{}{
+			map[string]interface{}{
+				"message":    rej.message,
+				"extensions": map[string]string{"code": rej.code},
+			},
+		},
+	})
+
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(g.spec.ErrorStatusCode)
+	resp.HTTPHeader().Set("Content-Type", "application/json")
+	resp.SetPayload(body)
+	ctx.SetOutputResponse(resp)
+	return resultRejected
+}
+
+// Status returns status.
+func (g *GraphQLGuard) Status() interface{} {
+	return &Status{
+		Accepted:      atomic.LoadUint64(&g.accepted),
+		Invalid:       atomic.LoadUint64(&g.invalid),
+		TooLarge:      atomic.LoadUint64(&g.tooLarge),
+		TooDeep:       atomic.LoadUint64(&g.tooDeep),
+		TooComplex:    atomic.LoadUint64(&g.tooComplex),
+		Introspection: atomic.LoadUint64(&g.introspection),
+	}
+}
+
+// Close closes GraphQLGuard.
+func (g *GraphQLGuard) Close() {
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphqlguard
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestGraphQLGuard(yamlConfig string) (*GraphQLGuard, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	g := kind.CreateInstance(spec).(*GraphQLGuard)
+	g.Init()
+	return g, nil
+}
+
+func newContext(stdReq *http.Request) *context.Context {
+	req, _ := httpprot.NewRequest(stdReq)
+	req.FetchPayload(1024 * 1024)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func postQuery(query string, variables map[string]interface{}) *context.Context {
+	body, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	stdReq := httptest.NewRequest(http.MethodPost, "http://localhost/graphql", strings.NewReader(string(body)))
+	stdReq.Header.Set("Content-Type", "application/json")
+	return newContext(stdReq)
+}
+
+func errorCode(ctx *context.Context) string {
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	v := struct {
+		Errors []struct {
+			Extensions struct {
+				Code string `json:"code"`
+			} `json:"extensions"`
+		} `json:"errors"`
+	}{}
+	json.Unmarshal(resp.RawPayload(), &v)
+	if len(v.Errors) == 0 {
+		return ""
+	}
+	return v.Errors[0].Extensions.Code
+}
+
+func TestGraphQLGuard(t *testing.T) {
+	assert := assert.New(t)
+
+	g, err := newTestGraphQLGuard(`
+kind: GraphQLGuard
+name: guard
+maxDepth: 3
+maxComplexity: 20
+listArguments: ["first", "last"]
+blockIntrospection: true
+maxQuerySize: 512
+`)
+	assert.Nil(err)
+	assert.Equal(kind, g.Kind())
+	assert.Equal("guard", g.Name())
+	assert.NotNil(g.Spec())
+
+	cases := []struct {
+		query     string
+		variables map[string]interface{}
+		code      string
+	}{
+		{`{ viewer { name orders { id } } }`, nil, ""},
+		{`{ viewer { orders { items { name } } } }`, nil, codeTooDeep},
+		// depth of fragments is counted where they are spread.
+		{`{ viewer { ...F } } fragment F on User { orders { items { name } } }`, nil, codeTooDeep},
+		{`{ viewer { ... on User { orders { id } } } }`, nil, ""},
+		// complexity: 1 + 10 * (1 + 1) = 21.
+		{`{ orders(first: 10) { id name } }`, nil, codeTooComplex},
+		{`query Q($n: Int) { orders(first: $n) { id name } }`, map[string]interface{}{"n": 5}, ""},
+		{`query Q($n: Int = 10) { orders(last: $n) { id name } }`, nil, codeTooComplex},
+		{`{ __schema { types { name } } }`, nil, codeIntrospection},
+		{`{ viewer { __typename } }`, nil, ""},
+		{`{ viewer { ...A } } fragment A on User { ...B } fragment B on User { ...A }`, nil, codeInvalid},
+		{`{ viewer { ...Missing } }`, nil, codeInvalid},
+		{`{ viewer `, nil, codeInvalid},
+		{`{ viewer { name ` + strings.Repeat(" ", 512) + `} }`, nil, codeTooLarge},
+	}
+
+	for _, c := range cases {
+		ctx := postQuery(c.query, c.variables)
+		result := g.Handle(ctx)
+		if c.code == "" {
+			assert.Equal("", result, c.query)
+			assert.Nil(ctx.GetOutputResponse(), c.query)
+			continue
+		}
+		assert.Equal(resultRejected, result, c.query)
+		assert.Equal(c.code, errorCode(ctx), c.query)
+		assert.Equal(http.StatusBadRequest, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+	}
+
+	status := g.Status().(*Status)
+	assert.Equal(uint64(4), status.Accepted)
+	assert.Equal(uint64(2), status.TooDeep)
+	assert.Equal(uint64(2), status.TooComplex)
+	assert.Equal(uint64(1), status.Introspection)
+	assert.Equal(uint64(3), status.Invalid)
+	assert.Equal(uint64(1), status.TooLarge)
+	g.Close()
+}
+
+func TestGraphQLGuardRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	g, err := newTestGraphQLGuard(`
+kind: GraphQLGuard
+name: guard
+maxDepth: 2
+errorStatusCode: 200
+`)
+	assert.Nil(err)
+
+	// GET request.
+	q := url.Values{"query": {`{ a { b { c } } }`}}
+	ctx := newContext(httptest.NewRequest(http.MethodGet, "http://localhost/graphql?"+q.Encode(), nil))
+	assert.Equal(resultRejected, g.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusOK, resp.StatusCode())
+	assert.Equal("application/json", resp.HTTPHeader().Get("Content-Type"))
+	assert.Equal(codeTooDeep, errorCode(ctx))
+
+	// not a GraphQL request.
+	ctx = newContext(httptest.NewRequest(http.MethodGet, "http://localhost/graphql", nil))
+	assert.Equal("", g.Handle(ctx))
+
+	// application/graphql.
+	stdReq := httptest.NewRequest(http.MethodPost, "http://localhost/graphql", strings.NewReader(`{ a { b } }`))
+	stdReq.Header.Set("Content-Type", "application/graphql")
+	assert.Equal("", g.Handle(newContext(stdReq)))
+
+	// batch, the second one is too deep.
+	body := `[{"query": "{ a }"}, {"query": "{ a { b { c } } }"}]`
+	stdReq = httptest.NewRequest(http.MethodPost, "http://localhost/graphql", strings.NewReader(body))
+	assert.Equal(resultRejected, g.Handle(newContext(stdReq)))
+
+	// operation name.
+	body = `{"query": "query A { a } query B { a { b { c } } }", "operationName": "A"}`
+	stdReq = httptest.NewRequest(http.MethodPost, "http://localhost/graphql", strings.NewReader(body))
+	assert.Equal("", g.Handle(newContext(stdReq)))
+
+	body = `{"query": "query A { a }", "operationName": "C"}`
+	stdReq = httptest.NewRequest(http.MethodPost, "http://localhost/graphql", strings.NewReader(body))
+	ctx = newContext(stdReq)
+	assert.Equal(resultRejected, g.Handle(ctx))
+	assert.Equal(codeInvalid, errorCode(ctx))
+
+	// invalid JSON.
+	stdReq = httptest.NewRequest(http.MethodPost, "http://localhost/graphql", strings.NewReader(`{"query":`))
+	assert.Equal(resultRejected, g.Handle(newContext(stdReq)))
+
+	_, err = newTestGraphQLGuard(`
+kind: GraphQLGuard
+name: guard
+errorStatusCode: 500
+`)
+	assert.NotNil(err)
+}
@@ -0,0 +1,637 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphqlguard
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// This file implements a parser of GraphQL executable documents, which
+// keeps only the information required to measure the queries, that's the
+// selections, the fragments, and the integer arguments of the fields.
+//
+// See https://spec.graphql.org/October2021/#sec-Language
+
+// maxNesting is the max nesting of selection sets, lists and objects, it
+// protects the parser from stack exhaustion.
+const maxNesting = 256
+
+type (
+	tokenKind int
+
+	token struct {
+		kind  tokenKind
+		value string
+		pos   int
+	}
+
+	// argument is an argument of a field, only integer literals and
+	// variables are kept.
+	argument struct {
+		isInt    bool
+		intValue int64
+		variable string
+	}
+
+	selection struct {
+		// field is the name of the field, it is empty for fragment
+		// spreads and inline fragments.
+		field string
+		args  map[string]*argument
+		// spread is the name of the spread fragment.
+		spread     string
+		selections []*selection
+	}
+
+	operation struct {
+		kind       string
+		name       string
+		defaults   map[string]int64
+		selections []*selection
+	}
+
+	document struct {
+		operations []*operation
+		fragments  map[string][]*selection
+	}
+
+	parser struct {
+		src     string
+		pos     int
+		tok     token
+		nesting int
+	}
+)
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+// parse parses a GraphQL executable document.
+func parse(src string) (doc *document, err error) {
+	p := &parser{src: src}
+	if err = p.next(); err != nil {
+		return nil, err
+	}
+
+	doc = &document{fragments: map[string][]*selection{}}
+	for p.tok.kind != tokenEOF {
+		if p.isPunct("{") {
+			sels, err := p.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operation{kind: "query", selections: sels})
+			continue
+		}
+
+		if p.tok.kind != tokenName {
+			return nil, p.errorf("unexpected %q", p.tok.value)
+		}
+		switch p.tok.value {
+		case "query", "mutation", "subscription":
+			op, err := p.parseOperation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case "fragment":
+			name, sels, err := p.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[name]; ok {
+				return nil, fmt.Errorf("duplicated fragment %s", name)
+			}
+			doc.fragments[name] = sels
+		default:
+			return nil, p.errorf("unexpected %q, only executable definitions are supported", p.tok.value)
+		}
+	}
+
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("no operation")
+	}
+	return doc, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("syntax error at %d: %s", p.tok.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) isPunct(v string) bool {
+	return p.tok.kind == tokenPunct && p.tok.value == v
+}
+
+func (p *parser) expectPunct(v string) error {
+	if !p.isPunct(v) {
+		return p.errorf("expected %q, got %q", v, p.tok.value)
+	}
+	return p.next()
+}
+
+func (p *parser) expectName() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.errorf("expected a name, got %q", p.tok.value)
+	}
+	name := p.tok.value
+	return name, p.next()
+}
+
+func (p *parser) enter() error {
+	p.nesting++
+	if p.nesting > maxNesting {
+		return p.errorf("nested too deeply")
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.nesting--
+}
+
+func (p *parser) parseOperation() (*operation, error) {
+	op := &operation{kind: p.tok.value, defaults: map[string]int64{}}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokenName {
+		op.name = p.tok.value
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.isPunct("(") {
+		if err := p.parseVariableDefinitions(op); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+
+	sels, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.selections = sels
+	return op, nil
+}
+
+func (p *parser) parseVariableDefinitions(op *operation) error {
+	if err := p.expectPunct("("); err != nil {
+		return err
+	}
+	for !p.isPunct(")") {
+		if err := p.expectPunct("$"); err != nil {
+			return err
+		}
+		name, err := p.expectName()
+		if err != nil {
+			return err
+		}
+		if err = p.expectPunct(":"); err != nil {
+			return err
+		}
+		if err = p.parseType(); err != nil {
+			return err
+		}
+		if p.isPunct("=") {
+			if err = p.next(); err != nil {
+				return err
+			}
+			arg, err := p.parseValue()
+			if err != nil {
+				return err
+			}
+			if arg != nil && arg.isInt {
+				op.defaults[name] = arg.intValue
+			}
+		}
+		if err = p.parseDirectives(); err != nil {
+			return err
+		}
+	}
+	return p.next()
+}
+
+func (p *parser) parseType() error {
+	if p.isPunct("[") {
+		if err := p.enter(); err != nil {
+			return err
+		}
+		defer p.leave()
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.parseType(); err != nil {
+			return err
+		}
+		if err := p.expectPunct("]"); err != nil {
+			return err
+		}
+	} else if _, err := p.expectName(); err != nil {
+		return err
+	}
+
+	if p.isPunct("!") {
+		return p.next()
+	}
+	return nil
+}
+
+func (p *parser) parseFragment() (string, []*selection, error) {
+	if err := p.next(); err != nil {
+		return "", nil, err
+	}
+	name, err := p.expectName()
+	if err != nil {
+		return "", nil, err
+	}
+	if name == "on" {
+		return "", nil, p.errorf("invalid fragment name on")
+	}
+	if err = p.parseTypeCondition(); err != nil {
+		return "", nil, err
+	}
+	if err = p.parseDirectives(); err != nil {
+		return "", nil, err
+	}
+	sels, err := p.parseSelectionSet()
+	return name, sels, err
+}
+
+func (p *parser) parseTypeCondition() error {
+	if p.tok.kind != tokenName || p.tok.value != "on" {
+		return p.errorf("expected \"on\", got %q", p.tok.value)
+	}
+	if err := p.next(); err != nil {
+		return err
+	}
+	_, err := p.expectName()
+	return err
+}
+
+func (p *parser) parseDirectives() error {
+	for p.isPunct("@") {
+		if err := p.next(); err != nil {
+			return err
+		}
+		if _, err := p.expectName(); err != nil {
+			return err
+		}
+		if p.isPunct("(") {
+			if _, err := p.parseArguments(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseArguments() (map[string]*argument, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+
+	args := map[string]*argument{}
+	for !p.isPunct(")") {
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expectPunct(":"); err != nil {
+			return nil, err
+		}
+		arg, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if arg != nil {
+			args[name] = arg
+		}
+	}
+	return args, p.next()
+}
+
+// parseValue parses a value, it returns nil if the value is neither an
+// integer nor a variable.
+func (p *parser) parseValue() (*argument, error) {
+	switch p.tok.kind {
+	case tokenInt:
+		n, err := strconv.ParseInt(p.tok.value, 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid integer %s", p.tok.value)
+		}
+		return &argument{isInt: true, intValue: n}, p.next()
+	case tokenFloat, tokenString, tokenName:
+		return nil, p.next()
+	case tokenEOF:
+		return nil, p.errorf("unexpected end of document")
+	}
+
+	switch p.tok.value {
+	case "$":
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		return &argument{variable: name}, nil
+	case "[", "{":
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		end := "]"
+		if p.tok.value == "{" {
+			end = "}"
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		for !p.isPunct(end) {
+			if end == "}" {
+				if _, err := p.expectName(); err != nil {
+					return nil, err
+				}
+				if err := p.expectPunct(":"); err != nil {
+					return nil, err
+				}
+			}
+			if _, err := p.parseValue(); err != nil {
+				return nil, err
+			}
+		}
+		return nil, p.next()
+	}
+	return nil, p.errorf("unexpected %q", p.tok.value)
+}
+
+func (p *parser) parseSelectionSet() ([]*selection, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+
+	var sels []*selection
+	for !p.isPunct("}") {
+		sel, err := p.parseSelection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) == 0 {
+		return nil, p.errorf("empty selection set")
+	}
+	return sels, p.next()
+}
+
+func (p *parser) parseSelection() (*selection, error) {
+	sel := &selection{}
+
+	if p.isPunct("...") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		// fragment spread.
+		if p.tok.kind == tokenName && p.tok.value != "on" {
+			sel.spread = p.tok.value
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			return sel, p.parseDirectives()
+		}
+
+		// inline fragment.
+		if p.tok.kind == tokenName {
+			if err := p.parseTypeCondition(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.parseDirectives(); err != nil {
+			return nil, err
+		}
+		sels, err := p.parseSelectionSet()
+		if err != nil {
+			return nil, err
+		}
+		sel.selections = sels
+		return sel, nil
+	}
+
+	// field, the alias is discarded.
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	if p.isPunct(":") {
+		if err = p.next(); err != nil {
+			return nil, err
+		}
+		if name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+	}
+	sel.field = name
+
+	if p.isPunct("(") {
+		if sel.args, err = p.parseArguments(); err != nil {
+			return nil, err
+		}
+	}
+	if err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	if p.isPunct("{") {
+		if sel.selections, err = p.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// next reads the next token.
+func (p *parser) next() error {
+	src := p.src
+
+	// skip ignored tokens.
+	for p.pos < len(src) {
+		c := src[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+		} else if c == '#' {
+			for p.pos < len(src) && src[p.pos] != '\n' && src[p.pos] != '\r' {
+				p.pos++
+			}
+		} else if len(src)-p.pos >= 3 && src[p.pos:p.pos+3] == "\ufeff" {
+			p.pos += 3
+		} else {
+			break
+		}
+	}
+
+	start := p.pos
+	p.tok = token{pos: start}
+	if p.pos >= len(src) {
+		p.tok.kind = tokenEOF
+		return nil
+	}
+
+	c := src[p.pos]
+	switch {
+	case c == '.':
+		if len(src)-p.pos < 3 || src[p.pos:p.pos+3] != "..." {
+			return p.errorf("unexpected character %q", c)
+		}
+		p.pos += 3
+		p.tok.kind, p.tok.value = tokenPunct, "..."
+	case isPunct(c):
+		p.pos++
+		p.tok.kind, p.tok.value = tokenPunct, string(c)
+	case isNameStart(c):
+		for p.pos < len(src) && isNameContinue(src[p.pos]) {
+			p.pos++
+		}
+		p.tok.kind, p.tok.value = tokenName, src[start:p.pos]
+	case c == '-' || isDigit(c):
+		return p.readNumber()
+	case c == '"':
+		return p.readString()
+	default:
+		return p.errorf("unexpected character %q", c)
+	}
+	return nil
+}
+
+func (p *parser) readNumber() error {
+	src, start := p.src, p.pos
+	digits := func() int {
+		n := 0
+		for p.pos < len(src) && isDigit(src[p.pos]) {
+			p.pos++
+			n++
+		}
+		return n
+	}
+
+	if src[p.pos] == '-' {
+		p.pos++
+	}
+	if digits() == 0 {
+		return p.errorf("invalid number")
+	}
+
+	kind := tokenInt
+	if p.pos < len(src) && src[p.pos] == '.' {
+		p.pos++
+		kind = tokenFloat
+		if digits() == 0 {
+			return p.errorf("invalid number")
+		}
+	}
+	if p.pos < len(src) && (src[p.pos] == 'e' || src[p.pos] == 'E') {
+		p.pos++
+		kind = tokenFloat
+		if p.pos < len(src) && (src[p.pos] == '+' || src[p.pos] == '-') {
+			p.pos++
+		}
+		if digits() == 0 {
+			return p.errorf("invalid number")
+		}
+	}
+	if p.pos < len(src) && (isNameStart(src[p.pos]) || src[p.pos] == '.') {
+		return p.errorf("invalid number")
+	}
+
+	p.tok.kind, p.tok.value = kind, src[start:p.pos]
+	return nil
+}
+
+// readString reads a string or a block string, the value of the token
+// is the raw string as it is never used.
+func (p *parser) readString() error {
+	src, start := p.src, p.pos
+
+	if len(src)-p.pos >= 3 && src[p.pos:p.pos+3] == `"""` {
+		p.pos += 3
+		for ; p.pos < len(src); p.pos++ {
+			if src[p.pos] == '\\' && len(src)-p.pos >= 4 && src[p.pos+1:p.pos+4] == `"""` {
+				p.pos += 3
+				continue
+			}
+			if len(src)-p.pos >= 3 && src[p.pos:p.pos+3] == `"""` {
+				p.pos += 3
+				p.tok.kind, p.tok.value = tokenString, src[start:p.pos]
+				return nil
+			}
+		}
+		return p.errorf("unterminated string")
+	}
+
+	for p.pos++; p.pos < len(src); p.pos++ {
+		switch src[p.pos] {
+		case '\\':
+			p.pos++
+		case '\n', '\r':
+			return p.errorf("unterminated string")
+		case '"':
+			p.pos++
+			p.tok.kind, p.tok.value = tokenString, src[start:p.pos]
+			return nil
+		}
+	}
+	return p.errorf("unterminated string")
+}
+
+func isPunct(c byte) bool {
+	switch c {
+	case '!', '$', '&', '(', ')', ':', '=', '@', '[', ']', '{', '|', '}':
+		return true
+	}
+	return false
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameContinue(c byte) bool {
+	return isNameStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphqlguard
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	doc, err := parse(`
+# a comment
+query Orders($first: Int = 10, $ids: [ID!]!) @cached(ttl: 60) {
+  me: viewer {
+    orders(first: $first, filter: {status: [PAID, "SHIPPED"], min: 1.5e3}) {
+      ...OrderFields
+      ... on DigitalOrder @include(if: true) { url }
+      ... { id }
+    }
+  }
+}
+
+fragment OrderFields on Order {
+  id
+  note(format: """block "string" \"""""")
+  items(last: 5) { name }
+}
+
+mutation { cancel(id: "1\"2", reason: null) { ok } }
+`)
+	assert.NoError(err)
+	assert.Len(doc.operations, 2)
+
+	op := doc.operations[0]
+	assert.Equal("query", op.kind)
+	assert.Equal("Orders", op.name)
+	assert.Equal(int64(10), op.defaults["first"])
+	assert.Equal("viewer", op.selections[0].field)
+
+	orders := op.selections[0].selections[0]
+	assert.Equal("orders", orders.field)
+	assert.Equal("first", orders.args["first"].variable)
+	assert.Nil(orders.args["filter"])
+	assert.Equal("OrderFields", orders.selections[0].spread)
+	assert.Equal("", orders.selections[1].field)
+	assert.Equal("url", orders.selections[1].selections[0].field)
+
+	frag := doc.fragments["OrderFields"]
+	assert.Len(frag, 3)
+	assert.Equal(int64(5), frag[2].args["last"].intValue)
+
+	assert.Equal("mutation", doc.operations[1].kind)
+
+	for _, invalid := range []string{
+		``,
+		`{}`,
+		`{ a`,
+		`{ a(b: ) }`,
+		`{ a(b: "unterminated) }`,
+		`{ a(b: 1x) }`,
+		`query Q($a) { a }`,
+		`type Query { a: Int }`,
+		`fragment on on T { a }`,
+		`fragment F on T { a } fragment F on T { b } { a }`,
+		`fragment F on T { a }`,
+		`{ a .. b }`,
+		strings.Repeat("{ a ", 300) + strings.Repeat("}", 300),
+	} {
+		_, err := parse(invalid)
+		assert.Error(err, invalid)
+	}
+}
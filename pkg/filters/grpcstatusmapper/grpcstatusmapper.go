@@ -0,0 +1,281 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package grpcstatusmapper implements a filter which maps the gRPC status of
+// responses to HTTP status codes.
+package grpcstatusmapper
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+const (
+	// Kind is the kind of GRPCStatusMapper.
+	Kind = "GRPCStatusMapper"
+
+	headerGRPCStatus  = "Grpc-Status"
+	headerGRPCMessage = "Grpc-Message"
+	headerGRPCDetails = "Grpc-Status-Details-Bin"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "GRPCStatusMapper maps the gRPC status of responses to HTTP status codes.",
+	Results:     []string{},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &GRPCStatusMapper{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+// codeNames are the canonical names of the gRPC status codes.
+var codeNames = map[codes.Code]string{
+	codes.OK:                 "OK",
+	codes.Canceled:           "CANCELLED",
+	codes.Unknown:            "UNKNOWN",
+	codes.InvalidArgument:    "INVALID_ARGUMENT",
+	codes.DeadlineExceeded:   "DEADLINE_EXCEEDED",
+	codes.NotFound:           "NOT_FOUND",
+	codes.AlreadyExists:      "ALREADY_EXISTS",
+	codes.PermissionDenied:   "PERMISSION_DENIED",
+	codes.ResourceExhausted:  "RESOURCE_EXHAUSTED",
+	codes.FailedPrecondition: "FAILED_PRECONDITION",
+	codes.Aborted:            "ABORTED",
+	codes.OutOfRange:         "OUT_OF_RANGE",
+	codes.Unimplemented:      "UNIMPLEMENTED",
+	codes.Internal:           "INTERNAL",
+	codes.Unavailable:        "UNAVAILABLE",
+	codes.DataLoss:           "DATA_LOSS",
+	codes.Unauthenticated:    "UNAUTHENTICATED",
+}
+
+// defaultStatusCodes is the standard mapping from gRPC status codes to HTTP
+// status codes.
+var defaultStatusCodes = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+type (
+	// GRPCStatusMapper is the filter GRPCStatusMapper.
+	//
+	// It should be put after the proxy of a gRPC backend, and it replaces
+	// responses with a non-OK gRPC status by a JSON error.
+	GRPCStatusMapper struct {
+		spec        *Spec
+		statusCodes map[codes.Code]int
+	}
+
+	// Spec is the spec of GRPCStatusMapper.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Overrides overrides the standard mapping, the key is the name
+		// of the gRPC status code like NOT_FOUND, or the number of it.
+		Overrides map[string]int `json:"overrides,omitempty"`
+	}
+
+	// Err is the error body of responses with a non-OK gRPC status.
+	Err struct {
+		Code    int    `json:"code"`
+		Status  string `json:"status"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// parseCode parses the name or the number of a gRPC status code.
+func parseCode(s string) (codes.Code, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return codes.Code(n), nil
+	}
+	for code, name := range codeNames {
+		if strings.EqualFold(name, s) {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown gRPC status code %s", s)
+}
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	for k, v := range spec.Overrides {
+		if _, err := parseCode(k); err != nil {
+			return err
+		}
+		if v < 100 || v > 599 {
+			return fmt.Errorf("invalid HTTP status code %d of %s", v, k)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the GRPCStatusMapper filter instance.
+func (m *GRPCStatusMapper) Name() string {
+	return m.spec.Name()
+}
+
+// Kind returns the kind of GRPCStatusMapper.
+func (m *GRPCStatusMapper) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the GRPCStatusMapper
+func (m *GRPCStatusMapper) Spec() filters.Spec {
+	return m.spec
+}
+
+// Init initializes GRPCStatusMapper.
+func (m *GRPCStatusMapper) Init() {
+	m.reload()
+}
+
+// Inherit inherits previous generation of GRPCStatusMapper.
+func (m *GRPCStatusMapper) Inherit(previousGeneration filters.Filter) {
+	m.Init()
+}
+
+func (m *GRPCStatusMapper) reload() {
+	m.statusCodes = make(map[codes.Code]int, len(defaultStatusCodes))
+	for code, statusCode := range defaultStatusCodes {
+		m.statusCodes[code] = statusCode
+	}
+	for k, v := range m.spec.Overrides {
+		code, _ := parseCode(k)
+		m.statusCodes[code] = v
+	}
+}
+
+func (m *GRPCStatusMapper) statusCode(code codes.Code) int {
+	if statusCode, ok := m.statusCodes[code]; ok {
+		return statusCode
+	}
+	// codes out of the standard range are treated as UNKNOWN.
+	return m.statusCodes[codes.Unknown]
+}
+
+// Handle maps the gRPC status of the response to the HTTP status code.
+//
+// The gRPC status is read from the trailer, or from the header for
+// trailers-only responses. If the backend returns both a non-OK gRPC status
+// and an HTTP status, the gRPC status wins as it is more specific, but a
+// non-200 HTTP status is kept if the gRPC status is OK or absent.
+func (m *GRPCStatusMapper) Handle(ctx *context.Context) string {
+	resp, _ := ctx.GetInputResponse().(*httpprot.Response)
+	if resp == nil {
+		return ""
+	}
+
+	h, value := resp.HTTPHeader(), resp.HTTPHeader().Get(headerGRPCStatus)
+	if value == "" {
+		if resp.IsStream() {
+			// the trailer is not available until the body is read.
+			logger.Debugf("%s: cannot read trailer of stream response", m.Name())
+			return ""
+		}
+		h, value = resp.Std().Trailer, resp.Std().Trailer.Get(headerGRPCStatus)
+	}
+	if value == "" {
+		return ""
+	}
+
+	n, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		logger.Warnf("%s: invalid gRPC status %q", m.Name(), value)
+		n = uint64(codes.Unknown)
+	}
+	code := codes.Code(n)
+	if code == codes.OK {
+		return ""
+	}
+
+	message := h.Get(headerGRPCMessage)
+	if decoded, err := url.PathUnescape(message); err == nil {
+		message = decoded
+	}
+	status := codeNames[code]
+	if status == "" {
+		status = codeNames[codes.Unknown]
+	}
+
+	for _, hdr := range []http.Header{resp.HTTPHeader(), resp.Std().Trailer} {
+		hdr.Del(headerGRPCStatus)
+		hdr.Del(headerGRPCMessage)
+		hdr.Del(headerGRPCDetails)
+	}
+
+	if resp.IsStream() {
+		if c, ok := resp.GetPayload().(io.Closer); ok {
+			c.Close()
+		}
+	}
+
+	statusCode := m.statusCode(code)
+	ctx.AddTag(fmt.Sprintf("grpcStatusMapper: %s to %d", status, statusCode))
+	body, _ := codectool.MarshalJSON(&Err{
+		Code:    int(code),
+		Status:  status,
+		Message: message,
+	})
+	resp.SetStatusCode(statusCode)
+	resp.HTTPHeader().Set("Content-Type", "application/json")
+	resp.HTTPHeader().Set("Content-Length", strconv.Itoa(len(body)))
+	resp.SetPayload(body)
+	return ""
+}
+
+// Status returns status.
+func (m *GRPCStatusMapper) Status() interface{} {
+	return nil
+}
+
+// Close closes GRPCStatusMapper.
+func (m *GRPCStatusMapper) Close() {
+}
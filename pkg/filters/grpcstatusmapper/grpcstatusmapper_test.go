@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcstatusmapper
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestMapper(yamlConfig string) (*GRPCStatusMapper, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	m := kind.CreateInstance(spec).(*GRPCStatusMapper)
+	m.Init()
+	return m, nil
+}
+
+func newContext(statusCode int, header, trailer http.Header) (*context.Context, *httpprot.Response) {
+	stdResp := &http.Response{
+		StatusCode: statusCode,
+		Header:     header,
+		Trailer:    trailer,
+		Body:       io.NopCloser(strings.NewReader("")),
+	}
+	resp, _ := httpprot.NewResponse(stdResp)
+	resp.FetchPayload(0)
+	ctx := context.New(nil)
+	ctx.SetInputResponse(resp)
+	return ctx, resp
+}
+
+func TestGRPCStatusMapper(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := newTestMapper(`
+kind: GRPCStatusMapper
+name: mapper
+overrides:
+  NOT_FOUND: 410
+  "14": 502
+`)
+	assert.Nil(err)
+	assert.Equal(kind, m.Kind())
+	assert.Nil(m.Status())
+
+	cases := []struct {
+		statusCode int
+		header     http.Header
+		trailer    http.Header
+		want       int
+		err        *Err
+	}{
+		// status in the trailer.
+		{200, http.Header{}, http.Header{"Grpc-Status": {"3"}, "Grpc-Message": {"bad%20name"}}, 400, &Err{3, "INVALID_ARGUMENT", "bad name"}},
+		// trailers-only response.
+		{200, http.Header{"Grpc-Status": {"7"}}, nil, 403, &Err{7, "PERMISSION_DENIED", ""}},
+		// overrides.
+		{200, http.Header{}, http.Header{"Grpc-Status": {"5"}}, 410, &Err{5, "NOT_FOUND", ""}},
+		{200, http.Header{}, http.Header{"Grpc-Status": {"14"}}, 502, &Err{14, "UNAVAILABLE", ""}},
+		// the gRPC status wins over the HTTP status.
+		{503, http.Header{}, http.Header{"Grpc-Status": {"16"}}, 401, &Err{16, "UNAUTHENTICATED", ""}},
+		// unknown and invalid codes.
+		{200, http.Header{}, http.Header{"Grpc-Status": {"99"}}, 500, &Err{99, "UNKNOWN", ""}},
+		{200, http.Header{}, http.Header{"Grpc-Status": {"abc"}}, 500, &Err{2, "UNKNOWN", ""}},
+		// OK or no gRPC status, the HTTP status is kept.
+		{200, http.Header{}, http.Header{"Grpc-Status": {"0"}}, 200, nil},
+		{502, http.Header{}, nil, 502, nil},
+	}
+	for i, c := range cases {
+		ctx, resp := newContext(c.statusCode, c.header, c.trailer)
+		assert.Equal("", m.Handle(ctx), i)
+		assert.Equal(c.want, resp.StatusCode(), i)
+		if c.err == nil {
+			continue
+		}
+
+		e := &Err{}
+		codectool.MustUnmarshal(resp.RawPayload(), e)
+		assert.Equal(c.err, e, i)
+		assert.Equal("application/json", resp.HTTPHeader().Get("Content-Type"), i)
+		assert.Empty(resp.HTTPHeader().Get(headerGRPCStatus), i)
+		assert.Empty(resp.Std().Trailer.Get(headerGRPCStatus), i)
+	}
+
+	// no response.
+	assert.Equal("", m.Handle(context.New(nil)))
+
+	newM := kind.CreateInstance(m.spec).(*GRPCStatusMapper)
+	newM.Inherit(m)
+	m.Close()
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []string{"NOT_EXIST: 400", "NOT_FOUND: 99", "-1: 400"} {
+		_, err := newTestMapper("kind: GRPCStatusMapper\nname: mapper\noverrides:\n  " + c)
+		assert.NotNil(err, c)
+	}
+
+	_, err := newTestMapper("kind: GRPCStatusMapper\nname: mapper\noverrides:\n  not_found: 400")
+	assert.Nil(err)
+}
@@ -50,6 +50,7 @@ var kind = &filters.Kind{
 	Name:        Kind,
 	Description: "HeaderLookup enriches request headers per request, looking up values from etcd.",
 	Results:     []string{},
+	RequestOnly: true,
 	DefaultSpec: func() filters.Spec {
 		return &Spec{}
 	},
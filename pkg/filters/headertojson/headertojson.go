@@ -20,12 +20,19 @@ package headertojson
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
 	json "github.com/goccy/go-json"
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
 )
 
 const (
@@ -34,6 +41,7 @@ const (
 
 	resultJSONEncodeDecodeErr = "jsonEncodeDecodeErr"
 	resultBodyReadErr         = "bodyReadErr"
+	resultHeaderTypeErr       = "headerTypeErr"
 )
 
 var (
@@ -46,7 +54,10 @@ var kind = &filters.Kind{
 	Results: []string{
 		resultJSONEncodeDecodeErr,
 		resultBodyReadErr,
+		resultHeaderTypeErr,
 	},
+	RequestOnly:       true,
+	RequiresBuffering: true,
 	DefaultSpec: func() filters.Spec {
 		return &Spec{}
 	},
@@ -62,8 +73,21 @@ func init() {
 type (
 	// HeaderToJSON put http request headers into body as JSON fields.
 	HeaderToJSON struct {
-		spec      *Spec
-		headerMap map[string]string
+		spec     *Spec
+		fields   []*field
+		prefixes []*prefix
+	}
+
+	field struct {
+		header string
+		path   []string
+		typ    string
+	}
+
+	prefix struct {
+		prefix string
+		path   []string
+		typ    string
 	}
 )
 
@@ -84,10 +108,34 @@ func (h *HeaderToJSON) Spec() filters.Spec {
 	return h.spec
 }
 
+func splitPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
 func (h *HeaderToJSON) init() {
-	h.headerMap = make(map[string]string)
-	for _, header := range h.spec.HeaderMap {
-		h.headerMap[http.CanonicalHeaderKey(header.Header)] = header.JSON
+	h.fields = nil
+	for _, hm := range h.spec.HeaderMap {
+		path := []string{hm.JSON}
+		if h.spec.Nested {
+			path = splitPath(hm.JSON)
+		}
+		h.fields = append(h.fields, &field{
+			header: http.CanonicalHeaderKey(hm.Header),
+			path:   path,
+			typ:    hm.Type,
+		})
+	}
+
+	h.prefixes = nil
+	for _, pm := range h.spec.PrefixMap {
+		h.prefixes = append(h.prefixes, &prefix{
+			prefix: http.CanonicalHeaderKey(pm.Prefix),
+			path:   splitPath(pm.JSON),
+			typ:    pm.Type,
+		})
 	}
 }
 
@@ -110,16 +158,110 @@ func (h *HeaderToJSON) Status() interface{} {
 	return nil
 }
 
-// Handle handle Context
-func (h *HeaderToJSON) Handle(ctx *context.Context) string {
-	req := ctx.GetInputRequest()
+// coerce converts the header value to the type.
+func coerce(value, typ string) (interface{}, error) {
+	switch typ {
+	case TypeNumber:
+		var v interface{}
+		err := codectool.UnmarshalJSONNumber([]byte(value), &v)
+		if _, ok := v.(json.Number); err != nil || !ok {
+			return nil, fmt.Errorf("%q is not a number", value)
+		}
+		return v, nil
+	case TypeBoolean:
+		return strconv.ParseBool(value)
+	case TypeJSON:
+		var v interface{}
+		if err := codectool.UnmarshalJSONNumber([]byte(value), &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return value, nil
+}
+
+// setPath sets the value at the path of m, the objects on the path are
+// created if they don't exist.
+func setPath(m map[string]interface{}, path []string, value interface{}) {
+	for _, name := range path[:len(path)-1] {
+		child, ok := m[name].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			m[name] = child
+		}
+		m = child
+	}
+	m[path[len(path)-1]] = value
+}
+
+// prefixPath returns the path of the header for the prefix, or nil if the
+// header doesn't have the prefix.
+func (p *prefix) prefixPath(header string) []string {
+	rest, ok := strings.CutPrefix(header, p.prefix)
+	if !ok || rest == "" {
+		return nil
+	}
+
+	path := append([]string{}, p.path...)
+	for _, name := range strings.Split(strings.ToLower(rest), "-") {
+		if name == "" {
+			return nil
+		}
+		path = append(path, name)
+	}
+	return path
+}
+
+// headerMap builds the JSON object from the headers of the request.
+func (h *HeaderToJSON) headerMap(req *httpprot.Request) (map[string]interface{}, error) {
 	headerMap := make(map[string]interface{})
-	for header, json := range h.headerMap {
-		value := req.Header().Get(header)
-		if value != "" {
-			headerMap[json] = value
+	header := req.HTTPHeader()
+
+	for _, p := range h.prefixes {
+		// sort the headers, so the result is stable if they collide.
+		keys := make([]string, 0, len(header))
+		for k := range header {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			path := p.prefixPath(k)
+			if path == nil {
+				continue
+			}
+			v, err := coerce(header.Get(k), p.typ)
+			if err != nil {
+				return nil, fmt.Errorf("header %s: %w", k, err)
+			}
+			setPath(headerMap, path, v)
 		}
 	}
+
+	// explicitly mapped headers win over the prefixed ones.
+	for _, f := range h.fields {
+		value := header.Get(f.header)
+		if value == "" {
+			continue
+		}
+		v, err := coerce(value, f.typ)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", f.header, err)
+		}
+		setPath(headerMap, f.path, v)
+	}
+
+	return headerMap, nil
+}
+
+// Handle handle Context
+func (h *HeaderToJSON) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	headerMap, err := h.headerMap(req)
+	if err != nil {
+		logger.Debugf("%s: %v", h.Name(), err)
+		return resultHeaderTypeErr
+	}
 	if len(headerMap) == 0 {
 		return ""
 	}
@@ -135,7 +277,7 @@ func (h *HeaderToJSON) Handle(ctx *context.Context) string {
 		body = headerMap
 	} else {
 		var err error
-		if body, err = getNewBody(reqBody, headerMap); err != nil {
+		if body, err = getNewBody(reqBody, headerMap, h.spec.Precedence != PrecedenceBody); err != nil {
 			return resultJSONEncodeDecodeErr
 		}
 	}
@@ -146,12 +288,13 @@ func (h *HeaderToJSON) Handle(ctx *context.Context) string {
 	}
 
 	req.SetPayload(bodyBytes)
+	req.HTTPHeader().Set("Content-Length", strconv.Itoa(len(bodyBytes)))
 	return ""
 }
 
 func decodeMapJSON(body []byte) (map[string]interface{}, error) {
 	res := make(map[string]interface{})
-	err := json.Unmarshal(body, &res)
+	err := codectool.UnmarshalJSONNumber(body, &res)
 	if err != nil && err != io.EOF {
 		return nil, err
 	}
@@ -160,23 +303,36 @@ func decodeMapJSON(body []byte) (map[string]interface{}, error) {
 
 func decodeArrayJSON(body []byte) ([]map[string]interface{}, error) {
 	res := []map[string]interface{}{}
-	err := json.Unmarshal(body, &res)
+	err := codectool.UnmarshalJSONNumber(body, &res)
 	if err != nil && err != io.EOF {
 		return nil, err
 	}
 	return res, nil
 }
 
-func mergeMap(m1 map[string]interface{}, m2 map[string]interface{}) map[string]interface{} {
-	for k, v := range m2 {
-		m1[k] = v
+// mergeMap merges m2 into m1, objects are merged recursively, and for
+// other collisions, the value of m2 wins if override is true.
+func mergeMap(m1 map[string]interface{}, m2 map[string]interface{}, override bool) map[string]interface{} {
+	for k, v2 := range m2 {
+		if v1, ok := m1[k]; ok {
+			o1, ok1 := v1.(map[string]interface{})
+			o2, ok2 := v2.(map[string]interface{})
+			if ok1 && ok2 {
+				mergeMap(o1, o2, override)
+				continue
+			}
+			if !override {
+				continue
+			}
+		}
+		m1[k] = v2
 	}
 	return m1
 }
 
-func mergeMapToArrayMap(arrayMap []map[string]interface{}, m map[string]interface{}) []map[string]interface{} {
+func mergeMapToArrayMap(arrayMap []map[string]interface{}, m map[string]interface{}, override bool) []map[string]interface{} {
 	for i := range arrayMap {
-		arrayMap[i] = mergeMap(arrayMap[i], m)
+		arrayMap[i] = mergeMap(arrayMap[i], m, override)
 	}
 	return arrayMap
 }
@@ -192,7 +348,7 @@ func firstNonBlandByte(bytes []byte) byte {
 	return 0
 }
 
-func getNewBody(reqBody []byte, headerMap map[string]interface{}) (interface{}, error) {
+func getNewBody(reqBody []byte, headerMap map[string]interface{}, override bool) (interface{}, error) {
 	char := firstNonBlandByte(reqBody)
 
 	if char == '{' {
@@ -200,14 +356,14 @@ func getNewBody(reqBody []byte, headerMap map[string]interface{}) (interface{},
 		if err != nil {
 			return nil, errJSONEncodeDecode
 		}
-		return mergeMap(bodyMap, headerMap), nil
+		return mergeMap(bodyMap, headerMap, override), nil
 
 	} else if char == '[' {
 		bodyArray, err := decodeArrayJSON(reqBody)
 		if err != nil {
 			return nil, errJSONEncodeDecode
 		}
-		return mergeMapToArrayMap(bodyArray, headerMap), nil
+		return mergeMapToArrayMap(bodyArray, headerMap, override), nil
 	}
 	return nil, errJSONEncodeDecode
 }
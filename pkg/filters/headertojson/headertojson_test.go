@@ -21,6 +21,7 @@ import (
 	"bytes"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -202,3 +203,116 @@ func TestHandleHTTP(t *testing.T) {
 		assert.Equal(resultBodyReadErr, ans)
 	}
 }
+
+func TestJSONNumber(t *testing.T) {
+	assert := assert.New(t)
+	spec := defaultFilterSpec(&Spec{
+		HeaderMap: []*HeaderMap{
+			{Header: "X-Id", JSON: "xid"},
+		},
+	})
+	h := kind.CreateInstance(spec)
+	h.Init()
+
+	for _, c := range []struct {
+		body string
+		want string
+	}{
+		{
+			body: `{"id":9007199254740993,"price":1.10,"ratio":1e3}`,
+			want: `{"id":9007199254740993,"price":1.10,"ratio":1e3,"xid":"1"}`,
+		},
+		{
+			body: `[{"id":18446744073709551615}]`,
+			want: `[{"id":18446744073709551615,"xid":"1"}]`,
+		},
+	} {
+		stdReq, err := http.NewRequest(http.MethodPost, "/", strings.NewReader(c.body))
+		assert.Nil(err)
+		stdReq.Header.Set("X-Id", "1")
+		ctx := context.New(nil)
+		setRequest(t, ctx, stdReq)
+
+		assert.Equal("", h.Handle(ctx))
+		assert.Equal(c.want, string(ctx.GetInputRequest().(*httpprot.Request).RawPayload()))
+	}
+}
+
+func TestNestedAndPrefix(t *testing.T) {
+	assert := assert.New(t)
+	spec := defaultFilterSpec(&Spec{
+		Nested: true,
+		HeaderMap: []*HeaderMap{
+			{Header: "X-Tenant", JSON: "meta.tenant.id"},
+			{Header: "X-Age", JSON: "user.age", Type: TypeNumber},
+			{Header: "X-Vip", JSON: "user.vip", Type: TypeBoolean},
+			{Header: "X-Roles", JSON: "user.roles", Type: TypeJSON},
+		},
+		PrefixMap: []*PrefixMap{
+			{Prefix: "X-Gw-", JSON: "gateway"},
+		},
+	})
+	h := kind.CreateInstance(spec)
+	h.Init()
+
+	handle := func(body string, header map[string]string) (string, *httpprot.Request) {
+		stdReq, err := http.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		assert.Nil(err)
+		for k, v := range header {
+			stdReq.Header.Set(k, v)
+		}
+		ctx := context.New(nil)
+		setRequest(t, ctx, stdReq)
+		result := h.Handle(ctx)
+		return result, ctx.GetInputRequest().(*httpprot.Request)
+	}
+
+	header := map[string]string{
+		"X-Tenant":         "acme",
+		"X-Age":            "30",
+		"X-Vip":            "true",
+		"X-Roles":          `["admin"]`,
+		"X-Gw-User-Name":   "alice",
+		"X-Gw-Request-Id":  "r-1",
+		"X-Other":          "ignored",
+		"X-Gw-":            "ignored",
+		"X-Gw-Invalid--Id": "ignored",
+	}
+	result, req := handle(`{"user": {"name": "bob", "age": 20}, "meta": {"source": "app"}}`, header)
+	assert.Equal("", result)
+	want := `{"gateway":{"request":{"id":"r-1"},"user":{"name":"alice"}},"meta":{"source":"app","tenant":{"id":"acme"}},"user":{"age":30,"name":"bob","roles":["admin"],"vip":true}}`
+	assert.Equal(want, string(req.RawPayload()))
+	assert.Equal(strconv.Itoa(len(want)), req.HTTPHeader().Get("Content-Length"))
+
+	// invalid values.
+	result, _ = handle(`{}`, map[string]string{"X-Age": "thirty"})
+	assert.Equal(resultHeaderTypeErr, result)
+	result, _ = handle(`{}`, map[string]string{"X-Vip": "maybe"})
+	assert.Equal(resultHeaderTypeErr, result)
+	result, _ = handle(`{}`, map[string]string{"X-Roles": "[admin"})
+	assert.Equal(resultHeaderTypeErr, result)
+
+	// the body wins.
+	spec = defaultFilterSpec(&Spec{
+		Precedence: PrecedenceBody,
+		HeaderMap: []*HeaderMap{
+			{Header: "X-User", JSON: "user.name"},
+			{Header: "X-Id", JSON: "id"},
+		},
+	})
+	h = kind.CreateInstance(spec)
+	h.Init()
+	_, req = handle(`{"id": 1}`, map[string]string{"X-Id": "2", "X-User": "alice"})
+	// dots are not nested if nested is false.
+	assert.Equal(`{"id":1,"user.name":"alice"}`, string(req.RawPayload()))
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Error((&Spec{}).Validate())
+	assert.NoError((&Spec{PrefixMap: []*PrefixMap{{Prefix: "X-"}}}).Validate())
+	assert.Error((&Spec{PrefixMap: []*PrefixMap{{Prefix: "X-", JSON: "a..b"}}}).Validate())
+	assert.NoError((&Spec{HeaderMap: []*HeaderMap{{Header: "X-A", JSON: ".a"}}}).Validate())
+	assert.Error((&Spec{Nested: true, HeaderMap: []*HeaderMap{{Header: "X-A", JSON: ".a"}}}).Validate())
+}
@@ -17,18 +17,90 @@
 
 package headertojson
 
-import "github.com/megaease/easegress/v2/pkg/filters"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/megaease/easegress/v2/pkg/filters"
+)
+
+// types of header values.
+const (
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	TypeJSON    = "json"
+)
+
+// precedences of collisions between headers and fields of the body.
+const (
+	PrecedenceHeader = "header"
+	PrecedenceBody   = "body"
+)
 
 type (
 	// Spec is spec of HeaderToJson
 	Spec struct {
 		filters.BaseSpec `json:",inline"`
-		HeaderMap        []*HeaderMap `json:"headerMap" jsonschema:"required"`
+		HeaderMap        []*HeaderMap `json:"headerMap,omitempty"`
+		PrefixMap        []*PrefixMap `json:"prefixMap,omitempty"`
+		// Nested makes the dots in the JSON field names of HeaderMap
+		// build nested objects, e.g. user.name is {"user":{"name":...}}.
+		Nested bool `json:"nested,omitempty"`
+		// Precedence decides which one wins when a field from headers
+		// collides with a field of the body, default is header. Objects
+		// are always merged.
+		Precedence string `json:"precedence,omitempty" jsonschema:"enum=,enum=header,enum=body"`
 	}
 
 	// HeaderMap defines relationship between http header and json
 	HeaderMap struct {
 		Header string `json:"header" jsonschema:"required"`
 		JSON   string `json:"json" jsonschema:"required"`
+		// Type is the type to coerce the header value to, default is
+		// string.
+		Type string `json:"type,omitempty" jsonschema:"enum=,enum=string,enum=number,enum=boolean,enum=json"`
+	}
+
+	// PrefixMap maps all headers with a prefix to nested JSON fields, the
+	// rest of the header name is split by '-', e.g. X-User-Name is
+	// {"user":{"name":...}} for prefix X-.
+	PrefixMap struct {
+		Prefix string `json:"prefix" jsonschema:"required"`
+		// JSON is the dotted path of the object to put the fields in, the
+		// fields are put in the root object if it is empty.
+		JSON string `json:"json,omitempty"`
+		Type string `json:"type,omitempty" jsonschema:"enum=,enum=string,enum=number,enum=boolean,enum=json"`
 	}
 )
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if len(spec.HeaderMap) == 0 && len(spec.PrefixMap) == 0 {
+		return fmt.Errorf("headerMap or prefixMap is required")
+	}
+	if spec.Nested {
+		for _, hm := range spec.HeaderMap {
+			if err := validatePath(hm.JSON); err != nil {
+				return err
+			}
+		}
+	}
+	for _, pm := range spec.PrefixMap {
+		if pm.JSON != "" {
+			if err := validatePath(pm.JSON); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validatePath(path string) error {
+	for _, name := range strings.Split(path, ".") {
+		if name == "" {
+			return fmt.Errorf("invalid json path %q", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package hostadaptor implements a filter which validates and rewrites the
+// Host header of requests.
+package hostadaptor
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"text/template"
+
+	sprig "github.com/go-task/slim-sprig"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of HostAdaptor.
+	Kind = "HostAdaptor"
+
+	resultHostNotAllowed = "hostNotAllowed"
+	resultRewriteFailed  = "rewriteFailed"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "HostAdaptor validates the Host header against an allowlist and rewrites it.",
+	Results:     []string{resultHostNotAllowed, resultRewriteFailed},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &HostAdaptor{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// HostAdaptor is the filter HostAdaptor.
+	HostAdaptor struct {
+		spec     *Spec
+		patterns []*hostPattern
+		template *template.Template
+
+		rejected  uint64
+		rewritten uint64
+	}
+
+	// Spec is the spec of HostAdaptor.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// AllowedHosts are the patterns of the allowed hosts, a pattern
+		// could start with "*." to match all subdomains, and could have a
+		// port to match the port as well. All hosts are allowed if it is
+		// empty.
+		AllowedHosts []string `json:"allowedHosts,omitempty" jsonschema:"uniqueItems=true"`
+		// Host is the new host, which could be a template.
+		Host string `json:"host,omitempty"`
+	}
+
+	// Status is the status of HostAdaptor.
+	Status struct {
+		Rejected  uint64 `json:"rejected"`
+		Rewritten uint64 `json:"rewritten"`
+	}
+
+	hostPattern struct {
+		// suffix is true if the pattern matches subdomains.
+		suffix   bool
+		hostname string
+		port     string
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if len(spec.AllowedHosts) == 0 && spec.Host == "" {
+		return fmt.Errorf("at least one of allowedHosts and host must be set")
+	}
+	for _, h := range spec.AllowedHosts {
+		if _, err := parseHostPattern(h); err != nil {
+			return err
+		}
+	}
+	if spec.Host != "" {
+		if _, err := newTemplate(spec.Host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newTemplate(text string) (*template.Template, error) {
+	return template.New("").Funcs(sprig.TxtFuncMap()).Parse(text)
+}
+
+// splitHostPort splits host into hostname and port, the port is empty if
+// host has no port.
+func splitHostPort(host string) (string, string) {
+	if hostname, port, err := net.SplitHostPort(host); err == nil {
+		return hostname, port
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), ""
+}
+
+func parseHostPattern(s string) (*hostPattern, error) {
+	hostname, port := splitHostPort(strings.ToLower(s))
+	p := &hostPattern{hostname: hostname, port: port}
+	if strings.HasPrefix(hostname, "*.") {
+		p.suffix = true
+		p.hostname = hostname[1:]
+	}
+	if p.hostname == "" || p.hostname == "." || strings.Contains(p.hostname, "*") {
+		return nil, fmt.Errorf("invalid host pattern %q", s)
+	}
+	return p, nil
+}
+
+func (p *hostPattern) match(hostname, port string) bool {
+	if p.port != "" && p.port != port {
+		return false
+	}
+	if p.suffix {
+		return strings.HasSuffix(hostname, p.hostname)
+	}
+	return hostname == p.hostname
+}
+
+// Name returns the name of the HostAdaptor filter instance.
+func (ha *HostAdaptor) Name() string {
+	return ha.spec.Name()
+}
+
+// Kind returns the kind of HostAdaptor.
+func (ha *HostAdaptor) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the HostAdaptor
+func (ha *HostAdaptor) Spec() filters.Spec {
+	return ha.spec
+}
+
+// Init initializes HostAdaptor.
+func (ha *HostAdaptor) Init() {
+	ha.reload()
+}
+
+// Inherit inherits previous generation of HostAdaptor.
+func (ha *HostAdaptor) Inherit(previousGeneration filters.Filter) {
+	ha.reload()
+}
+
+func (ha *HostAdaptor) reload() {
+	ha.patterns = nil
+	for _, h := range ha.spec.AllowedHosts {
+		p, _ := parseHostPattern(h)
+		ha.patterns = append(ha.patterns, p)
+	}
+	if ha.spec.Host != "" {
+		ha.template, _ = newTemplate(ha.spec.Host)
+	}
+}
+
+func (ha *HostAdaptor) allowed(host string) bool {
+	if len(ha.patterns) == 0 {
+		return true
+	}
+	hostname, port := splitHostPort(strings.ToLower(host))
+	if hostname == "" {
+		return false
+	}
+	for _, p := range ha.patterns {
+		if p.match(hostname, port) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ha *HostAdaptor) newHost(ctx *context.Context, req *httpprot.Request) (string, error) {
+	hostname, port := splitHostPort(req.Host())
+	data := map[string]interface{}{
+		"host":     req.Host(),
+		"hostname": hostname,
+		"port":     port,
+		"req":      req.ToBuilderRequest(ctx.Namespace()),
+		"data":     ctx.Data(),
+	}
+
+	var sb strings.Builder
+	if err := ha.template.Execute(&sb, data); err != nil {
+		return "", err
+	}
+
+	host := strings.TrimSpace(sb.String())
+	if host == "" || strings.ContainsAny(host, " \t\r\n/?#@") {
+		return "", fmt.Errorf("invalid host %q", host)
+	}
+	return host, nil
+}
+
+func (ha *HostAdaptor) reject(ctx *context.Context, code int, tag string) {
+	ctx.AddTag(tag)
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(code)
+	ctx.SetOutputResponse(resp)
+}
+
+// Handle validates the Host of the request, and rewrites it if required.
+func (ha *HostAdaptor) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	if !ha.allowed(req.Host()) {
+		atomic.AddUint64(&ha.rejected, 1)
+		ha.reject(ctx, http.StatusBadRequest, fmt.Sprintf("hostAdaptor: host %q is not allowed", req.Host()))
+		return resultHostNotAllowed
+	}
+
+	if ha.template == nil {
+		return ""
+	}
+
+	host, err := ha.newHost(ctx, req)
+	if err != nil {
+		logger.Errorf("%s: failed to rewrite host: %v", ha.Name(), err)
+		ha.reject(ctx, http.StatusInternalServerError, fmt.Sprintf("hostAdaptor: failed to rewrite host: %v", err))
+		return resultRewriteFailed
+	}
+
+	atomic.AddUint64(&ha.rewritten, 1)
+	req.SetHost(host)
+	return ""
+}
+
+// Status returns status.
+func (ha *HostAdaptor) Status() interface{} {
+	return &Status{
+		Rejected:  atomic.LoadUint64(&ha.rejected),
+		Rewritten: atomic.LoadUint64(&ha.rewritten),
+	}
+}
+
+// Close closes HostAdaptor.
+func (ha *HostAdaptor) Close() {
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hostadaptor
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestAdaptor(yamlConfig string) *HostAdaptor {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		panic(err)
+	}
+	ha := kind.CreateInstance(spec).(*HostAdaptor)
+	ha.Init()
+	return ha
+}
+
+func newContext(host string) *context.Context {
+	stdReq, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	stdReq.Host = host
+	stdReq.Header.Set("X-Tenant", "acme")
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestAllowedHosts(t *testing.T) {
+	assert := assert.New(t)
+
+	ha := newTestAdaptor(`
+kind: HostAdaptor
+name: host
+allowedHosts:
+- example.com
+- "*.example.org"
+- api.example.net:8443
+- "[::1]"
+`)
+	assert.Equal(kind, ha.Kind())
+	assert.Equal("host", ha.Name())
+
+	for _, host := range []string{"example.com", "EXAMPLE.com:8080", "a.example.org", "a.b.example.org", "api.example.net:8443", "[::1]:80"} {
+		assert.Equal("", ha.Handle(newContext(host)), host)
+	}
+
+	for _, host := range []string{"", "evil.com", "example.com.evil.com", "example.org", "api.example.net", "api.example.net:443"} {
+		ctx := newContext(host)
+		assert.Equal(resultHostNotAllowed, ha.Handle(ctx), host)
+		assert.Equal(http.StatusBadRequest, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+	}
+
+	assert.Equal(uint64(6), ha.Status().(*Status).Rejected)
+	ha.Inherit(ha)
+	ha.Close()
+}
+
+func TestRewrite(t *testing.T) {
+	assert := assert.New(t)
+
+	ha := newTestAdaptor(`
+kind: HostAdaptor
+name: host
+host: backend.internal
+`)
+	ctx := newContext("www.example.com")
+	assert.Equal("", ha.Handle(ctx))
+	assert.Equal("backend.internal", ctx.GetInputRequest().(*httpprot.Request).Host())
+
+	ha = newTestAdaptor(`
+kind: HostAdaptor
+name: host
+host: '{{ .req.Header.Get "X-Tenant" }}.{{ .hostname }}:{{ .port | default "80" }}'
+`)
+	ctx = newContext("www.example.com")
+	assert.Equal("", ha.Handle(ctx))
+	assert.Equal("acme.www.example.com:80", ctx.GetInputRequest().(*httpprot.Request).Host())
+	assert.Equal(uint64(1), ha.Status().(*Status).Rewritten)
+
+	ha = newTestAdaptor(`
+kind: HostAdaptor
+name: host
+host: '{{ .req.Header.Get "X-Unknown" }}'
+`)
+	ctx = newContext("www.example.com")
+	assert.Equal(resultRewriteFailed, ha.Handle(ctx))
+	assert.Equal(http.StatusInternalServerError, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+	assert.Equal("www.example.com", ctx.GetInputRequest().(*httpprot.Request).Host())
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError((&Spec{Host: "example.com"}).Validate())
+	assert.NoError((&Spec{AllowedHosts: []string{"*.example.com"}}).Validate())
+	assert.Error((&Spec{}).Validate())
+	assert.Error((&Spec{AllowedHosts: []string{"a.*.example.com"}}).Validate())
+	assert.Error((&Spec{AllowedHosts: []string{"*."}}).Validate())
+	assert.Error((&Spec{Host: "{{ .host "}).Validate())
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package jsontoheader implements a filter which promotes fields of the
+// JSON request body to request headers.
+package jsontoheader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/megaease/easegress/v2/pkg/util/jsonpath"
+)
+
+const (
+	// Kind is the kind of JSONToHeader.
+	Kind = "JSONToHeader"
+
+	defaultMaxBodySize = 64 * 1024
+)
+
+var kind = &filters.Kind{
+	Name:              Kind,
+	Description:       "JSONToHeader promotes fields of the JSON request body to request headers.",
+	Results:           []string{},
+	RequestOnly:       true,
+	RequiresBuffering: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{MaxBodySize: defaultMaxBodySize}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &JSONToHeader{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// JSONToHeader is the filter JSONToHeader.
+	JSONToHeader struct {
+		spec  *Spec
+		paths []jsonpath.Path
+	}
+
+	// Spec is the spec of JSONToHeader.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		Fields []*Field `json:"fields" jsonschema:"required,minItems=1"`
+		// MaxBodySize is the max size of the body to inspect, fields of
+		// larger bodies are treated as missing.
+		MaxBodySize int64 `json:"maxBodySize,omitempty" jsonschema:"minimum=1"`
+	}
+
+	// Field promotes a field of the body to a header.
+	Field struct {
+		// Path is the JSONPath of the field, e.g. $.order.id.
+		Path   string `json:"path" jsonschema:"required"`
+		Header string `json:"header" jsonschema:"required"`
+		// Default is the value of the header if the field is missing,
+		// the header is not set if it is empty.
+		Default string `json:"default,omitempty"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	headers := map[string]bool{}
+	for _, f := range spec.Fields {
+		if _, err := jsonpath.Parse(f.Path); err != nil {
+			return err
+		}
+		if !httpguts.ValidHeaderFieldName(f.Header) {
+			return fmt.Errorf("invalid header %q", f.Header)
+		}
+		h := http.CanonicalHeaderKey(f.Header)
+		if headers[h] {
+			return fmt.Errorf("duplicated header %s", f.Header)
+		}
+		headers[h] = true
+		if !httpguts.ValidHeaderFieldValue(f.Default) {
+			return fmt.Errorf("invalid default value of header %s", f.Header)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the JSONToHeader filter instance.
+func (jh *JSONToHeader) Name() string {
+	return jh.spec.Name()
+}
+
+// Kind returns the kind of JSONToHeader.
+func (jh *JSONToHeader) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the JSONToHeader
+func (jh *JSONToHeader) Spec() filters.Spec {
+	return jh.spec
+}
+
+// Init initializes JSONToHeader.
+func (jh *JSONToHeader) Init() {
+	jh.reload()
+}
+
+// Inherit inherits previous generation of JSONToHeader.
+func (jh *JSONToHeader) Inherit(previousGeneration filters.Filter) {
+	jh.Init()
+}
+
+func (jh *JSONToHeader) reload() {
+	// the paths have been verified in Validate, so no error here.
+	jh.paths = make([]jsonpath.Path, len(jh.spec.Fields))
+	for i, f := range jh.spec.Fields {
+		jh.paths[i], _ = jsonpath.Parse(f.Path)
+	}
+
+	if jh.spec.MaxBodySize <= 0 {
+		jh.spec.MaxBodySize = defaultMaxBodySize
+	}
+}
+
+// decode decodes the body of the request, it returns nil if the body
+// can't be inspected.
+func (jh *JSONToHeader) decode(req *httpprot.Request) interface{} {
+	// a stream body can't be inspected without consuming it.
+	if req.IsStream() {
+		return nil
+	}
+
+	body := req.RawPayload()
+	if len(body) == 0 || int64(len(body)) > jh.spec.MaxBodySize {
+		return nil
+	}
+
+	var v interface{}
+	if err := codectool.UnmarshalJSONNumber(body, &v); err != nil {
+		return nil
+	}
+	return v
+}
+
+// headerValue converts the value of a field to a header value, objects and
+// arrays are converted to compact JSON. It returns false if the value is
+// null or is not a valid header value.
+func headerValue(v interface{}) (string, bool) {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		s = v
+	case json.Number:
+		s = v.String()
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		data, err := codectool.MarshalJSON(v)
+		if err != nil {
+			return "", false
+		}
+		s = string(data)
+	}
+	return s, httpguts.ValidHeaderFieldValue(s)
+}
+
+// Handle promotes the fields of the body to the headers, the body is not
+// changed.
+func (jh *JSONToHeader) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	body := jh.decode(req)
+
+	h := req.HTTPHeader()
+	for i, f := range jh.spec.Fields {
+		// remove the header sent by the client, so the value can't be
+		// forged.
+		h.Del(f.Header)
+
+		value, ok := "", false
+		if body != nil {
+			var v interface{}
+			if v, ok = jh.paths[i].Lookup(body); ok {
+				value, ok = headerValue(v)
+			}
+		}
+		if !ok {
+			value = f.Default
+		}
+		if value != "" {
+			h.Set(f.Header, value)
+		}
+	}
+	return ""
+}
+
+// Status returns status.
+func (jh *JSONToHeader) Status() interface{} {
+	return nil
+}
+
+// Close closes JSONToHeader.
+func (jh *JSONToHeader) Close() {
+}
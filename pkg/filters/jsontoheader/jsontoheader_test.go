@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jsontoheader
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestJSONToHeader(yamlConfig string) (*JSONToHeader, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	jh := kind.CreateInstance(spec).(*JSONToHeader)
+	jh.Init()
+	return jh, nil
+}
+
+func newContext(body string, header http.Header, maxPayload int64) (*context.Context, *httpprot.Request) {
+	stdReq, _ := http.NewRequest(http.MethodPost, "http://localhost/orders", strings.NewReader(body))
+	for k, v := range header {
+		stdReq.Header[k] = v
+	}
+	req, _ := httpprot.NewRequest(stdReq)
+	req.FetchPayload(maxPayload)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, req
+}
+
+func TestJSONToHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	jh, err := newTestJSONToHeader(`
+kind: JSONToHeader
+name: jh
+maxBodySize: 256
+fields:
+- path: $.order.id
+  header: X-Order-ID
+- path: $.order.amount
+  header: X-Order-Amount
+- path: $.order.paid
+  header: X-Order-Paid
+- path: $.order.tags
+  header: X-Order-Tags
+- path: $.order.channel
+  header: X-Order-Channel
+  default: web
+- path: $.order.note
+  header: X-Order-Note
+`)
+	assert.NoError(err)
+	assert.Equal(kind, jh.Kind())
+	assert.Equal("jh", jh.Name())
+	assert.NotNil(jh.Spec())
+	assert.Nil(jh.Status())
+
+	body := `{"order": {"id": "o-1", "amount": 12.50, "paid": true, "tags": ["a", "b"], "note": "line1\nline2"}}`
+	ctx, req := newContext(body, http.Header{"X-Order-Id": {"forged"}}, 1024)
+	assert.Equal("", jh.Handle(ctx))
+
+	h := req.HTTPHeader()
+	assert.Equal("o-1", h.Get("X-Order-ID"))
+	assert.Equal("12.50", h.Get("X-Order-Amount"))
+	assert.Equal("true", h.Get("X-Order-Paid"))
+	assert.Equal(`["a","b"]`, h.Get("X-Order-Tags"))
+	assert.Equal("web", h.Get("X-Order-Channel"))
+	// invalid header values are skipped.
+	assert.Equal("", h.Get("X-Order-Note"))
+	// the body is not consumed.
+	assert.Equal(body, string(req.RawPayload()))
+
+	// too large, only the defaults are set.
+	body = `{"order": {"id": "` + strings.Repeat("x", 256) + `"}}`
+	ctx, req = newContext(body, http.Header{"X-Order-Id": {"forged"}}, 1024)
+	jh.Handle(ctx)
+	assert.Equal("", req.HTTPHeader().Get("X-Order-ID"))
+	assert.Equal("web", req.HTTPHeader().Get("X-Order-Channel"))
+
+	// stream body.
+	ctx, req = newContext(body, nil, -1)
+	assert.True(req.IsStream())
+	jh.Handle(ctx)
+	assert.Equal("web", req.HTTPHeader().Get("X-Order-Channel"))
+
+	// invalid JSON.
+	ctx, req = newContext(`{"order": `, nil, 1024)
+	jh.Handle(ctx)
+	assert.Equal("", req.HTTPHeader().Get("X-Order-ID"))
+
+	jh.Inherit(jh)
+	jh.Close()
+}
+
+func TestJSONToHeaderValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, spec := range []string{
+		`
+kind: JSONToHeader
+name: jh
+fields:
+- path: order.id
+  header: X-Order-ID`,
+		`
+kind: JSONToHeader
+name: jh
+fields:
+- path: $.order.id
+  header: X-Order-ID
+- path: $.id
+  header: x-order-id`,
+		`
+kind: JSONToHeader
+name: jh
+fields:
+- path: $.order.id
+  header: "X Order"`,
+		`
+kind: JSONToHeader
+name: jh
+fields: []`,
+	} {
+		_, err := newTestJSONToHeader(spec)
+		assert.Error(err, spec)
+	}
+}
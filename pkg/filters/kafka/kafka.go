@@ -19,7 +19,9 @@
 package kafka
 
 import (
+	stdcontext "context"
 	"fmt"
+	"sync"
 
 	"github.com/Shopify/sarama"
 	"github.com/megaease/easegress/v2/pkg/context"
@@ -54,9 +56,11 @@ func init() {
 type (
 	// Kafka is a kafka proxy for MQTT requests.
 	Kafka struct {
-		spec     *Spec
-		producer sarama.AsyncProducer
-		done     chan struct{}
+		spec      *Spec
+		producer  sarama.AsyncProducer
+		done      chan struct{}
+		closed    chan struct{}
+		closeOnce sync.Once
 
 		defaultTopic string
 		topicKey     string
@@ -66,6 +70,7 @@ type (
 )
 
 var _ filters.Filter = (*Kafka)(nil)
+var _ filters.Drainer = (*Kafka)(nil)
 
 // Name returns the name of the Kafka filter instance.
 func (k *Kafka) Name() string {
@@ -107,6 +112,7 @@ func (k *Kafka) setProducer() {
 	k.producer = producer
 
 	go func() {
+		defer close(k.closed)
 		for {
 			select {
 			case <-k.done:
@@ -128,6 +134,7 @@ func (k *Kafka) setProducer() {
 // Init init Kafka
 func (k *Kafka) Init() {
 	k.done = make(chan struct{})
+	k.closed = make(chan struct{})
 	k.setKV()
 	k.setProducer()
 }
@@ -137,9 +144,21 @@ func (k *Kafka) Inherit(previousGeneration filters.Filter) {
 	k.Init()
 }
 
+// Drain waits for the producer to flush the buffered messages. On expiry,
+// the producer keeps flushing in background until its own timeout.
+func (k *Kafka) Drain(ctx stdcontext.Context) error {
+	k.closeOnce.Do(func() { close(k.done) })
+	select {
+	case <-k.closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Close close Kafka
 func (k *Kafka) Close() {
-	close(k.done)
+	k.closeOnce.Do(func() { close(k.done) })
 }
 
 // Status return status of Kafka
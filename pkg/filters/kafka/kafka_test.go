@@ -18,6 +18,7 @@
 package kafka
 
 import (
+	stdcontext "context"
 	"fmt"
 	"sync/atomic"
 	"testing"
@@ -220,3 +221,41 @@ func TestKafka2(t *testing.T) {
 	}
 	assert.Equal(int32(1), atomic.LoadInt32(&p.closed))
 }
+
+type blockingAsyncProducer struct {
+	*mockAsyncProducer
+	release chan struct{}
+}
+
+func (b *blockingAsyncProducer) Close() error {
+	<-b.release
+	return b.mockAsyncProducer.Close()
+}
+
+func TestKafkaDrain(t *testing.T) {
+	assert := assert.New(t)
+
+	newAsyncProducer = func(addrs []string, conf *sarama.Config) (sarama.AsyncProducer, error) {
+		return newMockAsyncProducer(), nil
+	}
+	kafka := Kafka{spec: &Spec{Backend: []string{"localhost:1234"}}}
+	kafka.Init()
+	assert.Nil(kafka.Drain(stdcontext.Background()))
+	assert.Equal(int32(1), atomic.LoadInt32(&kafka.producer.(*mockAsyncProducer).closed))
+	kafka.Close()
+
+	// the producer does not finish flushing in time.
+	release := make(chan struct{})
+	newAsyncProducer = func(addrs []string, conf *sarama.Config) (sarama.AsyncProducer, error) {
+		return &blockingAsyncProducer{newMockAsyncProducer().(*mockAsyncProducer), release}, nil
+	}
+	kafka = Kafka{spec: &Spec{Backend: []string{"localhost:1234"}}}
+	kafka.Init()
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(stdcontext.DeadlineExceeded, kafka.Drain(ctx))
+	kafka.Close()
+
+	close(release)
+	assert.Nil(kafka.Drain(stdcontext.Background()))
+}
@@ -19,15 +19,19 @@
 package kafka
 
 import (
+	stdcontext "context"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 
 	"github.com/Shopify/sarama"
+	"github.com/google/uuid"
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters"
 	"github.com/megaease/easegress/v2/pkg/logger"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/tracing"
 	"github.com/megaease/easegress/v2/pkg/util/codectool"
 )
 
@@ -42,6 +46,7 @@ var kind = &filters.Kind{
 	Name:        Kind,
 	Description: "Kafka is a kafka proxy for HTTP requests",
 	Results:     []string{resultParseErr},
+	RequestOnly: true,
 	DefaultSpec: func() filters.Spec {
 		return &Spec{}
 	},
@@ -61,9 +66,12 @@ type (
 		asyncProducer sarama.AsyncProducer
 		syncProcuder  sarama.SyncProducer
 
-		headerTopic string
-		headerKey   string
-		done        chan struct{}
+		headerTopic       string
+		headerKey         string
+		headerCorrelation string
+		done              chan struct{}
+		closed            chan struct{}
+		closeOnce         sync.Once
 	}
 
 	// Err is the error of Kafka
@@ -74,6 +82,7 @@ type (
 )
 
 var _ filters.Filter = (*Kafka)(nil)
+var _ filters.Drainer = (*Kafka)(nil)
 
 // Name returns the name of the Kafka filter instance.
 func (k *Kafka) Name() string {
@@ -103,12 +112,19 @@ func (k *Kafka) setHeader() {
 			panic("empty header key")
 		}
 	}
+	if k.spec.Correlation != nil {
+		k.headerCorrelation = http.CanonicalHeaderKey(k.spec.Correlation.Header)
+		if k.headerCorrelation == "" {
+			k.headerCorrelation = tracing.CorrelationHeader
+		}
+	}
 }
 
 // Init init Kafka
 func (k *Kafka) Init() {
 	spec := k.spec
 	k.done = make(chan struct{})
+	k.closed = make(chan struct{})
 	k.setHeader()
 
 	config := sarama.NewConfig()
@@ -133,6 +149,7 @@ func (k *Kafka) Init() {
 }
 
 func (k *Kafka) checkProduceError() {
+	defer close(k.closed)
 	for {
 		select {
 		case <-k.done:
@@ -155,9 +172,25 @@ func (k *Kafka) Inherit(previousGeneration filters.Filter) {
 	k.Init()
 }
 
+// Drain waits for the async producer to flush the buffered messages. On
+// expiry, the producer keeps flushing in background until its own timeout.
+func (k *Kafka) Drain(ctx stdcontext.Context) error {
+	k.closeOnce.Do(func() { close(k.done) })
+	if k.asyncProducer == nil {
+		return nil
+	}
+
+	select {
+	case <-k.closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Close close Kafka
 func (k *Kafka) Close() {
-	close(k.done)
+	k.closeOnce.Do(func() { close(k.done) })
 }
 
 // Status return status of Kafka
@@ -205,6 +238,9 @@ func (k *Kafka) Handle(ctx *context.Context) (result string) {
 	if key != "" {
 		msg.Key = sarama.StringEncoder(key)
 	}
+	if k.headerCorrelation != "" {
+		k.correlate(ctx, req, msg)
+	}
 
 	if k.spec.Sync {
 		_, _, err = k.syncProcuder.SendMessage(msg)
@@ -221,6 +257,59 @@ func (k *Kafka) Handle(ctx *context.Context) (result string) {
 	return ""
 }
 
+// messageHeaderCarrier adapts the headers of a Kafka message to a
+// propagation.TextMapCarrier.
+type messageHeaderCarrier struct {
+	msg *sarama.ProducerMessage
+}
+
+func (c messageHeaderCarrier) Get(key string) string {
+	for _, h := range c.msg.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c messageHeaderCarrier) Set(key, value string) {
+	c.msg.Headers = append(c.msg.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (c messageHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Headers))
+	for _, h := range c.msg.Headers {
+		keys = append(keys, string(h.Key))
+	}
+	return keys
+}
+
+// correlate sets the correlation ID and the trace context of the request to
+// the headers of the message. The correlation ID is taken from the request,
+// or the trace ID if the request doesn't have one, or generated if there's
+// no trace, and it is set to the request too, so that the following filters,
+// like the Proxy, forward the same ID.
+func (k *Kafka) correlate(ctx *context.Context, req *httpprot.Request, msg *sarama.ProducerMessage) {
+	span := ctx.Span()
+
+	id := req.HTTPHeader().Get(k.headerCorrelation)
+	if id == "" {
+		if span != nil {
+			id = span.TraceID()
+		}
+		if id == "" {
+			id = uuid.NewString()
+		}
+		req.HTTPHeader().Set(k.headerCorrelation, id)
+	}
+
+	carrier := messageHeaderCarrier{msg: msg}
+	carrier.Set(k.headerCorrelation, id)
+	if span != nil {
+		span.Inject(carrier)
+	}
+}
+
 func setSuccessResponse(ctx *context.Context) {
 	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
 	if resp == nil {
@@ -18,6 +18,7 @@
 package kafka
 
 import (
+	stdcontext "context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -29,6 +30,7 @@ import (
 	"github.com/megaease/easegress/v2/pkg/filters"
 	"github.com/megaease/easegress/v2/pkg/logger"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/tracing"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -180,6 +182,7 @@ func TestHandleHTTP(t *testing.T) {
 		},
 		asyncProducer: newMockAsyncProducer(),
 		done:          make(chan struct{}),
+		closed:        make(chan struct{}),
 	}
 	kafka.setHeader()
 	go kafka.checkProduceError()
@@ -301,3 +304,66 @@ func TestHandleHTTPSync(t *testing.T) {
 	assert.Nil(err)
 	assert.Equal("text", string(value))
 }
+
+func TestHandleHTTPCorrelation(t *testing.T) {
+	assert := assert.New(t)
+	kafka := Kafka{
+		spec: &Spec{
+			Topic:       &Topic{Default: "default-topic"},
+			Correlation: &Correlation{},
+		},
+		asyncProducer: newMockAsyncProducer(),
+		done:          make(chan struct{}),
+		closed:        make(chan struct{}),
+	}
+	kafka.setHeader()
+	go kafka.checkProduceError()
+	defer kafka.Close()
+
+	headers := func(msg *sarama.ProducerMessage) map[string]string {
+		m := map[string]string{}
+		for _, h := range msg.Headers {
+			m[string(h.Key)] = string(h.Value)
+		}
+		return m
+	}
+
+	// the correlation ID of the request is propagated.
+	ctx := context.New(nil)
+	req, _ := http.NewRequest(http.MethodPost, "127.0.0.1", strings.NewReader("text"))
+	req.Header.Set("X-Correlation-Id", "abc")
+	setRequest(t, ctx, req)
+	assert.Equal("", kafka.Handle(ctx))
+	msg := <-kafka.asyncProducer.(*mockAsyncProducer).ch
+	assert.Equal(map[string]string{"X-Correlation-Id": "abc"}, headers(msg))
+
+	// the correlation ID is generated and set to the request.
+	ctx = context.New(tracing.NoopSpan)
+	req, _ = http.NewRequest(http.MethodPost, "127.0.0.1", strings.NewReader("text"))
+	setRequest(t, ctx, req)
+	assert.Equal("", kafka.Handle(ctx))
+	msg = <-kafka.asyncProducer.(*mockAsyncProducer).ch
+	id := headers(msg)["X-Correlation-Id"]
+	assert.Len(id, 36)
+	assert.Equal(id, ctx.GetInputRequest().(*httpprot.Request).HTTPHeader().Get("X-Correlation-Id"))
+
+	// the trace ID is the correlation ID, and the trace context is injected.
+	tracer, err := tracing.New(&tracing.Spec{
+		ServiceName: "test",
+		SampleRate:  1,
+		Exporter: &tracing.ExporterSpec{
+			Zipkin: &tracing.ZipkinSpec{Endpoint: "http://localhost:2181"},
+		},
+	})
+	assert.Nil(err)
+	defer tracer.Close()
+	span := tracer.NewSpan(stdcontext.Background(), "test")
+	ctx = context.New(span)
+	req, _ = http.NewRequest(http.MethodPost, "127.0.0.1", strings.NewReader("text"))
+	setRequest(t, ctx, req)
+	assert.Equal("", kafka.Handle(ctx))
+	msg = <-kafka.asyncProducer.(*mockAsyncProducer).ch
+	h := headers(msg)
+	assert.Equal(span.TraceID(), h["X-Correlation-Id"])
+	assert.Contains(h["traceparent"], span.TraceID())
+}
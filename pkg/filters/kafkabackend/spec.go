@@ -29,6 +29,8 @@ type (
 
 		Topic *Topic `json:"topic" jsonschema:"required"`
 		Key   Key    `json:"key,omitempty"`
+
+		Correlation *Correlation `json:"correlation,omitempty"`
 	}
 
 	// Topic defined ways to get Kafka topic
@@ -47,4 +49,12 @@ type (
 		Default string   `json:"default"`
 		Dynamic *Dynamic `json:"dynamic,omitempty"`
 	}
+
+	// Correlation defines how to propagate the correlation ID and the
+	// trace context of HTTP requests to Kafka messages.
+	Correlation struct {
+		// Header is the header of the correlation ID, default is
+		// X-Correlation-Id.
+		Header string `json:"header,omitempty"`
+	}
 )
@@ -0,0 +1,329 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package metadatainjector implements a filter which injects headers derived
+// from the metadata of the node and the cluster into requests.
+package metadatainjector
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/cluster/customdata"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of MetadataInjector.
+	Kind = "MetadataInjector"
+
+	// SourceNode is the name of the Easegress node.
+	SourceNode = "node"
+	// SourceCluster is the name of the cluster.
+	SourceCluster = "cluster"
+	// SourceRole is the role of the node in the cluster.
+	SourceRole = "role"
+	// SourceLabel is a label of the node, like the region.
+	SourceLabel = "label"
+	// SourcePipeline is the name of the pipeline.
+	SourcePipeline = "pipeline"
+	// SourceCustomData is a field of a custom data in the cluster.
+	SourceCustomData = "customData"
+
+	defaultRefreshInterval = 30 * time.Second
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "MetadataInjector injects headers derived from node and cluster metadata into requests.",
+	Results:     []string{},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &MetadataInjector{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// MetadataInjector is the filter MetadataInjector.
+	MetadataInjector struct {
+		spec *Spec
+
+		// static are the headers whose values never change.
+		static map[string]string
+		// dynamic are the headers whose values are loaded from the custom
+		// data, which are refreshed in the background.
+		dynamic atomic.Value // map[string]string
+		store   *customdata.Store
+		done    chan struct{}
+	}
+
+	// Spec is the spec of MetadataInjector.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		Headers []*HeaderSpec `json:"headers" jsonschema:"required,minItems=1"`
+		// RefreshInterval is the interval to refresh the values loaded
+		// from the custom data, it is 30s by default.
+		RefreshInterval string `json:"refreshInterval,omitempty" jsonschema:"format=duration"`
+	}
+
+	// HeaderSpec describes a header and the source of its value.
+	HeaderSpec struct {
+		Name   string `json:"name" jsonschema:"required"`
+		Source string `json:"source" jsonschema:"required,enum=node,enum=cluster,enum=role,enum=label,enum=pipeline,enum=customData"`
+		// Label is the name of the node label, required by source label.
+		Label string `json:"label,omitempty"`
+		// CustomData is the field of a custom data, required by source
+		// customData.
+		CustomData *CustomDataField `json:"customData,omitempty"`
+		// Default is the value if the source has no value, the header is
+		// not injected if both are empty.
+		Default string `json:"default,omitempty"`
+	}
+
+	// CustomDataField is a field of a custom data.
+	CustomDataField struct {
+		Kind  string `json:"kind" jsonschema:"required"`
+		ID    string `json:"id" jsonschema:"required"`
+		Field string `json:"field" jsonschema:"required"`
+	}
+
+	// Status is the status of MetadataInjector.
+	Status struct {
+		// Headers are the current values of the headers.
+		Headers map[string]string `json:"headers"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	for _, h := range spec.Headers {
+		switch h.Source {
+		case SourceLabel:
+			if h.Label == "" {
+				return fmt.Errorf("header %s: label is required by source label", h.Name)
+			}
+		case SourceCustomData:
+			if h.CustomData == nil {
+				return fmt.Errorf("header %s: customData is required by source customData", h.Name)
+			}
+		}
+	}
+	if spec.RefreshInterval != "" {
+		if d, err := time.ParseDuration(spec.RefreshInterval); err != nil || d <= 0 {
+			return fmt.Errorf("invalid refresh interval %s", spec.RefreshInterval)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the MetadataInjector filter instance.
+func (mi *MetadataInjector) Name() string {
+	return mi.spec.Name()
+}
+
+// Kind returns the kind of MetadataInjector.
+func (mi *MetadataInjector) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the MetadataInjector
+func (mi *MetadataInjector) Spec() filters.Spec {
+	return mi.spec
+}
+
+// Init initializes MetadataInjector.
+func (mi *MetadataInjector) Init() {
+	mi.reload(nil)
+}
+
+// Inherit inherits previous generation of MetadataInjector.
+func (mi *MetadataInjector) Inherit(previousGeneration filters.Filter) {
+	mi.reload(previousGeneration.(*MetadataInjector))
+}
+
+func (mi *MetadataInjector) reload(prev *MetadataInjector) {
+	mi.static = map[string]string{}
+	// values of the previous generation are kept if the first refresh
+	// fails.
+	if prev != nil {
+		mi.dynamic.Store(prev.dynamic.Load())
+	} else {
+		mi.dynamic.Store(map[string]string{})
+	}
+
+	super := mi.spec.Super()
+	hasCustomData := false
+	for _, h := range mi.spec.Headers {
+		value := ""
+		switch h.Source {
+		case SourceCustomData:
+			hasCustomData = true
+			continue
+		case SourcePipeline:
+			value = mi.spec.Pipeline()
+		default:
+			if super != nil && super.Options() != nil {
+				opt := super.Options()
+				switch h.Source {
+				case SourceNode:
+					value = opt.Name
+				case SourceCluster:
+					value = opt.ClusterName
+				case SourceRole:
+					value = opt.ClusterRole
+				case SourceLabel:
+					value = opt.Labels[h.Label]
+				}
+			}
+		}
+		if value == "" {
+			value = h.Default
+		}
+		if value != "" {
+			mi.static[h.Name] = value
+		}
+	}
+
+	if !hasCustomData {
+		return
+	}
+	if super == nil || super.Cluster() == nil {
+		logger.Errorf("%s: cluster is unavailable, headers from custom data use default values", mi.Name())
+	} else {
+		cls := super.Cluster()
+		mi.store = customdata.NewStore(cls, cls.Layout().CustomDataKindPrefix(), cls.Layout().CustomDataPrefix())
+	}
+
+	// load the values before handling requests, and refresh them in the
+	// background, so that the requests never wait for the cluster.
+	mi.refresh()
+	mi.done = make(chan struct{})
+	interval := defaultRefreshInterval
+	if mi.spec.RefreshInterval != "" {
+		interval, _ = time.ParseDuration(mi.spec.RefreshInterval)
+	}
+	go mi.run(interval)
+}
+
+func (mi *MetadataInjector) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mi.done:
+			return
+		case <-ticker.C:
+			mi.refresh()
+		}
+	}
+}
+
+// refresh loads the values of the headers from the custom data, a value is
+// kept unchanged if it fails to load.
+func (mi *MetadataInjector) refresh() {
+	prev := mi.dynamic.Load().(map[string]string)
+	values := make(map[string]string, len(prev))
+
+	for _, h := range mi.spec.Headers {
+		if h.Source != SourceCustomData {
+			continue
+		}
+
+		value, err := mi.loadCustomData(h.CustomData)
+		if err != nil {
+			logger.Warnf("%s: failed to load custom data for header %s: %v", mi.Name(), h.Name, err)
+			if v, ok := prev[h.Name]; ok {
+				values[h.Name] = v
+			}
+			continue
+		}
+		if value == "" {
+			value = h.Default
+		}
+		if value != "" {
+			values[h.Name] = value
+		}
+	}
+
+	mi.dynamic.Store(values)
+}
+
+func (mi *MetadataInjector) loadCustomData(f *CustomDataField) (string, error) {
+	if mi.store == nil {
+		return "", nil
+	}
+
+	data, err := mi.store.GetData(f.Kind, f.ID)
+	if err != nil {
+		return "", err
+	}
+	if data == nil {
+		return "", nil
+	}
+
+	value, ok := data[f.Field]
+	if !ok || value == nil {
+		return "", nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// Handle injects the headers into the request.
+func (mi *MetadataInjector) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	header := req.HTTPHeader()
+
+	for name, value := range mi.static {
+		header.Set(name, value)
+	}
+	for name, value := range mi.dynamic.Load().(map[string]string) {
+		header.Set(name, value)
+	}
+
+	return ""
+}
+
+// Status returns status.
+func (mi *MetadataInjector) Status() interface{} {
+	s := &Status{Headers: map[string]string{}}
+	for name, value := range mi.static {
+		s.Headers[name] = value
+	}
+	for name, value := range mi.dynamic.Load().(map[string]string) {
+		s.Headers[name] = value
+	}
+	return s
+}
+
+// Close closes MetadataInjector.
+func (mi *MetadataInjector) Close() {
+	if mi.done != nil {
+		close(mi.done)
+	}
+}
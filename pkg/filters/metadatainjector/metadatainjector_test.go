@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadatainjector
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+
+	"github.com/megaease/easegress/v2/pkg/cluster/clustertest"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/option"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func createMetadataInjector(yamlConfig string, super *supervisor.Supervisor) *MetadataInjector {
+	rawSpec := make(map[string]interface{})
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(super, "pipeline-demo", rawSpec)
+	if err != nil {
+		panic(err.Error())
+	}
+	mi := kind.CreateInstance(spec).(*MetadataInjector)
+	mi.Init()
+	return mi
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, yamlConfig := range []string{`
+kind: MetadataInjector
+name: mi
+headers:
+- name: X-Region
+  source: label
+`, `
+kind: MetadataInjector
+name: mi
+headers:
+- name: X-Region
+  source: customData
+`, `
+kind: MetadataInjector
+name: mi
+headers:
+- name: X-Node
+  source: node
+refreshInterval: -1s
+`} {
+		rawSpec := make(map[string]interface{})
+		codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+		_, err := filters.NewSpec(nil, "", rawSpec)
+		assert.Error(err)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	assert := assert.New(t)
+
+	opt := option.New()
+	opt.Name = "eg-1"
+	opt.ClusterName = "eg-cluster"
+	opt.ClusterRole = "primary"
+	opt.Labels = map[string]string{"region": "us-east-1"}
+
+	var gets int32
+	region := "us"
+	cls := clustertest.NewMockedCluster()
+	cls.MockedGetRaw = func(key string) (*mvccpb.KeyValue, error) {
+		atomic.AddInt32(&gets, 1)
+		if !strings.HasSuffix(key, "/env/prod") {
+			return nil, fmt.Errorf("unexpected key %s", key)
+		}
+		if region == "" {
+			return nil, fmt.Errorf("cluster is unavailable")
+		}
+		return &mvccpb.KeyValue{Value: []byte("name: prod\nregion: " + region)}, nil
+	}
+	super := supervisor.NewMock(opt, cls, nil, nil, false, nil, nil)
+
+	mi := createMetadataInjector(`
+kind: MetadataInjector
+name: mi
+headers:
+- name: X-Node
+  source: node
+- name: X-Cluster
+  source: cluster
+- name: X-Role
+  source: role
+- name: X-Region
+  source: label
+  label: region
+- name: X-Zone
+  source: label
+  label: zone
+  default: unknown
+- name: X-Rack
+  source: label
+  label: rack
+- name: X-Pipeline
+  source: pipeline
+- name: X-Env-Region
+  source: customData
+  customData:
+    kind: env
+    id: prod
+    field: region
+refreshInterval: 1h
+`, super)
+	defer mi.Close()
+
+	handle := func() http.Header {
+		ctx := context.New(nil)
+		stdReq, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1", nil)
+		req, _ := httpprot.NewRequest(stdReq)
+		ctx.SetInputRequest(req)
+		assert.Equal("", mi.Handle(ctx))
+		return req.HTTPHeader()
+	}
+
+	header := handle()
+	assert.Equal("eg-1", header.Get("X-Node"))
+	assert.Equal("eg-cluster", header.Get("X-Cluster"))
+	assert.Equal("primary", header.Get("X-Role"))
+	assert.Equal("us-east-1", header.Get("X-Region"))
+	assert.Equal("unknown", header.Get("X-Zone"))
+	assert.Empty(header.Values("X-Rack"))
+	assert.Equal("pipeline-demo", header.Get("X-Pipeline"))
+	assert.Equal("us", header.Get("X-Env-Region"))
+
+	// values are cached, requests never access the cluster.
+	handle()
+	assert.Equal(int32(1), atomic.LoadInt32(&gets))
+
+	region = "eu"
+	mi.refresh()
+	assert.Equal("eu", handle().Get("X-Env-Region"))
+
+	// the previous value is kept if the refresh fails.
+	region = ""
+	mi.refresh()
+	assert.Equal("eu", handle().Get("X-Env-Region"))
+
+	status := mi.Status().(*Status)
+	assert.Equal("eu", status.Headers["X-Env-Region"])
+	assert.Equal("eg-1", status.Headers["X-Node"])
+
+	newMi := kind.CreateInstance(mi.spec).(*MetadataInjector)
+	newMi.Inherit(mi)
+	defer newMi.Close()
+	status = newMi.Status().(*Status)
+	assert.Equal("eg-1", status.Headers["X-Node"])
+	assert.Equal("eu", status.Headers["X-Env-Region"])
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package methodrouter implements a filter which makes routing decisions
+// by the method of requests.
+package methodrouter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/routeheader"
+)
+
+const (
+	// Kind is the kind of MethodRouter.
+	Kind = "MethodRouter"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "MethodRouter sets the routing decision by the method of the request.",
+	Results:     []string{},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &MethodRouter{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// MethodRouter is the filter MethodRouter.
+	MethodRouter struct {
+		spec   *Spec
+		routes []*route
+	}
+
+	// Spec is the spec of MethodRouter.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Header is the request header to carry the routing decision.
+		Header       routeheader.Header `json:"header" jsonschema:"required,minLength=1"`
+		Routes       []*Route           `json:"routes" jsonschema:"required,minItems=1"`
+		DefaultRoute string             `json:"defaultRoute,omitempty"`
+	}
+
+	// Route maps methods to a route, the route could be restricted to
+	// the paths matching PathPrefix or PathRegexp.
+	Route struct {
+		Name       string   `json:"name" jsonschema:"required"`
+		Methods    []string `json:"methods" jsonschema:"required,minItems=1"`
+		PathPrefix string   `json:"pathPrefix,omitempty"`
+		PathRegexp string   `json:"pathRegexp,omitempty"`
+	}
+
+	route struct {
+		name       string
+		methods    map[string]bool
+		pathPrefix string
+		pathRegexp *regexp.Regexp
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	for _, r := range spec.Routes {
+		for _, m := range r.Methods {
+			if !httpguts.ValidHeaderFieldName(m) {
+				return fmt.Errorf("route %s: invalid method %q", r.Name, m)
+			}
+		}
+		if r.PathRegexp != "" {
+			if _, err := regexp.Compile(r.PathRegexp); err != nil {
+				return fmt.Errorf("route %s: %v", r.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the MethodRouter filter instance.
+func (mr *MethodRouter) Name() string {
+	return mr.spec.Name()
+}
+
+// Kind returns the kind of MethodRouter.
+func (mr *MethodRouter) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the MethodRouter
+func (mr *MethodRouter) Spec() filters.Spec {
+	return mr.spec
+}
+
+// Init initializes MethodRouter.
+func (mr *MethodRouter) Init() {
+	mr.reload()
+}
+
+// Inherit inherits previous generation of MethodRouter.
+func (mr *MethodRouter) Inherit(previousGeneration filters.Filter) {
+	mr.Init()
+}
+
+func (mr *MethodRouter) reload() {
+	mr.routes = make([]*route, 0, len(mr.spec.Routes))
+	for _, r := range mr.spec.Routes {
+		rt := &route{
+			name:       r.Name,
+			methods:    map[string]bool{},
+			pathPrefix: r.PathPrefix,
+		}
+		// methods are case-sensitive, but the standard ones are always
+		// upper case.
+		for _, m := range r.Methods {
+			rt.methods[strings.ToUpper(m)] = true
+		}
+		// the regexp has been verified in Validate, so no error here.
+		if r.PathRegexp != "" {
+			rt.pathRegexp = regexp.MustCompile(r.PathRegexp)
+		}
+		mr.routes = append(mr.routes, rt)
+	}
+}
+
+func (r *route) match(method, path string) bool {
+	if !r.methods[method] {
+		return false
+	}
+	if r.pathPrefix != "" && !strings.HasPrefix(path, r.pathPrefix) {
+		return false
+	}
+	if r.pathRegexp != nil && !r.pathRegexp.MatchString(path) {
+		return false
+	}
+	return true
+}
+
+// route returns the routing decision of the request, the first matching
+// route in the spec is selected.
+func (mr *MethodRouter) route(req *httpprot.Request) string {
+	method, path := req.Method(), req.Path()
+	for _, r := range mr.routes {
+		if r.match(method, path) {
+			return r.name
+		}
+	}
+	return mr.spec.DefaultRoute
+}
+
+// Handle sets the routing decision to the request header.
+func (mr *MethodRouter) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	mr.spec.Header.Reset(req.HTTPHeader())
+
+	if route := mr.route(req); route != "" {
+		mr.spec.Header.Set(req.HTTPHeader(), route)
+		ctx.LazyAddTag(func() string {
+			return "methodRouter: " + route
+		})
+	}
+	return ""
+}
+
+// Status returns status.
+func (mr *MethodRouter) Status() interface{} {
+	return nil
+}
+
+// Close closes MethodRouter.
+func (mr *MethodRouter) Close() {
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package methodrouter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/proxies/httpproxy"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestMethodRouter(yamlConfig string) (*MethodRouter, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	mr := kind.CreateInstance(spec).(*MethodRouter)
+	mr.Init()
+	return mr, nil
+}
+
+func newContext(method, path string) (*context.Context, *httpprot.Request) {
+	stdReq := httptest.NewRequest(method, "http://localhost"+path, nil)
+	stdReq.Header.Set("X-Route", "forged")
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, req
+}
+
+func TestMethodRouter(t *testing.T) {
+	assert := assert.New(t)
+
+	mr, err := newTestMethodRouter(`
+kind: MethodRouter
+name: router
+header: X-Route
+defaultRoute: primary
+routes:
+- name: search
+  methods: [POST]
+  pathPrefix: /api/
+  pathRegexp: /search$
+- name: replica
+  methods: [get, HEAD]
+`)
+	assert.Nil(err)
+	assert.Equal(kind, mr.Kind())
+	assert.Equal("router", mr.Name())
+	assert.NotNil(mr.Spec())
+
+	cases := []struct {
+		method string
+		path   string
+		route  string
+	}{
+		{http.MethodGet, "/api/orders", "replica"},
+		{http.MethodHead, "/api/orders", "replica"},
+		{http.MethodPost, "/api/orders/search", "search"},
+		{http.MethodPost, "/v2/orders/search", "primary"},
+		{http.MethodPost, "/api/orders", "primary"},
+		{http.MethodDelete, "/api/orders/1", "primary"},
+	}
+	for _, c := range cases {
+		ctx, req := newContext(c.method, c.path)
+		assert.Equal("", mr.Handle(ctx))
+		assert.Equal(c.route, req.HTTPHeader().Get("X-Route"), c.method+" "+c.path)
+	}
+
+	// no default route, the header is removed.
+	mr, err = newTestMethodRouter(`
+kind: MethodRouter
+name: router
+header: X-Route
+routes:
+- name: replica
+  methods: [GET]
+`)
+	assert.Nil(err)
+	ctx, req := newContext(http.MethodPut, "/")
+	mr.Handle(ctx)
+	assert.Equal("", req.HTTPHeader().Get("X-Route"))
+}
+
+func TestMethodRouterWithPool(t *testing.T) {
+	assert := assert.New(t)
+
+	mr, err := newTestMethodRouter(`
+kind: MethodRouter
+name: router
+header: X-Route
+routes:
+- name: replica
+  methods: [GET]
+`)
+	assert.Nil(err)
+
+	spec := &httpproxy.RequestMatcherSpec{}
+	codectool.MustUnmarshal([]byte(`
+headers:
+  X-Route:
+    exact: replica
+`), spec)
+	matcher := httpproxy.NewRequestMatcher(spec)
+
+	ctx, req := newContext(http.MethodGet, "/")
+	mr.Handle(ctx)
+	assert.True(matcher.Match(req))
+
+	ctx, req = newContext(http.MethodPost, "/")
+	mr.Handle(ctx)
+	assert.False(matcher.Match(req))
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, yamlConfig := range []string{`
+kind: MethodRouter
+name: router
+header: X-Route
+routes:
+- name: replica
+  methods: ["G ET"]
+`, `
+kind: MethodRouter
+name: router
+header: X-Route
+routes:
+- name: replica
+  methods: [GET]
+  pathRegexp: "(["
+`, `
+kind: MethodRouter
+name: router
+header: X-Route
+routes: []
+`} {
+		_, err := newTestMethodRouter(yamlConfig)
+		assert.Error(err)
+	}
+}
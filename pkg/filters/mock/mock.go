@@ -40,6 +40,7 @@ var kind = &filters.Kind{
 	Name:        Kind,
 	Description: "Mock mocks the response.",
 	Results:     []string{resultMocked},
+	RequestOnly: true,
 	DefaultSpec: func() filters.Spec {
 		return &Spec{}
 	},
@@ -0,0 +1,538 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package multishadow implements a filter which replays requests to
+// multiple environments and compares their responses with the response
+// of the primary.
+package multishadow
+
+import (
+	"bytes"
+	stdcontext "context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/pathadaptor"
+)
+
+const (
+	// Kind is the kind of MultiShadow.
+	Kind = "MultiShadow"
+
+	defaultMaxConcurrency = 10
+	defaultMaxBodySize    = 1024 * 1024
+	defaultTimeout        = 5 * time.Second
+
+	// reportQueueSize is the max number of reports waiting to be sent,
+	// reports are dropped if the queue is full.
+	reportQueueSize = 1024
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "MultiShadow replays requests to multiple environments and compares their responses with the primary one.",
+	Results:     []string{},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &MultiShadow{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+// headers not replayed to the targets, the hop-by-hop headers are handled
+// by the transport, and the Content-Length is set from the body.
+var droppedHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Content-Length",
+}
+
+type (
+	// MultiShadow is the filter MultiShadow.
+	MultiShadow struct {
+		spec *Spec
+
+		targets     []*target
+		client      *http.Client
+		sink        Sink
+		timeout     time.Duration
+		maxBodySize int64
+
+		// tokens bounds the number of requests being replayed.
+		tokens  chan struct{}
+		reports chan *Report
+		done    chan struct{}
+		wg      sync.WaitGroup
+
+		replayed       uint64
+		dropped        uint64
+		skipped        uint64
+		reportsDropped uint64
+	}
+
+	// Spec is the spec of MultiShadow.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		Targets []*Target `json:"targets" jsonschema:"required,minItems=1"`
+		// MaxConcurrency is the max number of requests being replayed,
+		// requests exceeding it are not replayed.
+		MaxConcurrency int `json:"maxConcurrency,omitempty" jsonschema:"minimum=1"`
+		// MaxBodySize is the max size of the request bodies to replay and
+		// the response bodies to compare.
+		MaxBodySize int64  `json:"maxBodySize,omitempty" jsonschema:"minimum=1"`
+		Timeout     string `json:"timeout,omitempty" jsonschema:"format=duration"`
+		// CompareHeaders are the response headers to compare, the bodies
+		// and the status codes are always compared.
+		CompareHeaders []string `json:"compareHeaders,omitempty"`
+		// ReportMatches sends the reports of the requests whose responses
+		// match too, by default, only mismatches and failures are sent.
+		ReportMatches bool      `json:"reportMatches,omitempty"`
+		Sink          *SinkSpec `json:"sink,omitempty"`
+	}
+
+	// Target is an environment to replay the requests to.
+	Target struct {
+		Name string `json:"name" jsonschema:"required"`
+		// URL is the scheme and host of the environment, like
+		// https://staging.example.com.
+		URL     string            `json:"url" jsonschema:"required,format=uri"`
+		Path    *pathadaptor.Spec `json:"path,omitempty"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Timeout string            `json:"timeout,omitempty" jsonschema:"format=duration"`
+	}
+
+	// Status is the status of MultiShadow.
+	Status struct {
+		Replayed       uint64                   `json:"replayed"`
+		Dropped        uint64                   `json:"dropped"`
+		Skipped        uint64                   `json:"skipped"`
+		ReportsDropped uint64                   `json:"reportsDropped"`
+		Targets        map[string]*TargetStatus `json:"targets"`
+	}
+
+	// TargetStatus is the status of a target.
+	TargetStatus struct {
+		Requests   uint64 `json:"requests"`
+		Mismatches uint64 `json:"mismatches"`
+		Errors     uint64 `json:"errors"`
+	}
+
+	target struct {
+		spec    *Target
+		base    *url.URL
+		pa      *pathadaptor.PathAdaptor
+		timeout time.Duration
+
+		requests   uint64
+		mismatches uint64
+		errors     uint64
+	}
+
+	// snapshot is the copy of the request to replay, the request itself
+	// may be changed or released before the replay completes.
+	snapshot struct {
+		method   string
+		path     string
+		rawQuery string
+		header   http.Header
+		body     []byte
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	names := map[string]bool{}
+	for _, t := range spec.Targets {
+		if names[t.Name] {
+			return fmt.Errorf("duplicated target %s", t.Name)
+		}
+		names[t.Name] = true
+	}
+
+	if spec.Timeout != "" {
+		if d, err := time.ParseDuration(spec.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q", spec.Timeout)
+		}
+	}
+	return nil
+}
+
+// Validate validates the Target.
+func (t *Target) Validate() error {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return fmt.Errorf("target %s: %v", t.Name, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("target %s: url must be like http(s)://host[:port]", t.Name)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return fmt.Errorf("target %s: url must not have a path, use path to rewrite it", t.Name)
+	}
+
+	if t.Timeout != "" {
+		if d, err := time.ParseDuration(t.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("target %s: invalid timeout %q", t.Name, t.Timeout)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the MultiShadow filter instance.
+func (ms *MultiShadow) Name() string {
+	return ms.spec.Name()
+}
+
+// Kind returns the kind of MultiShadow.
+func (ms *MultiShadow) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the MultiShadow
+func (ms *MultiShadow) Spec() filters.Spec {
+	return ms.spec
+}
+
+// Init initializes MultiShadow.
+func (ms *MultiShadow) Init() {
+	ms.reload()
+}
+
+// Inherit inherits previous generation of MultiShadow.
+func (ms *MultiShadow) Inherit(previousGeneration filters.Filter) {
+	ms.reload()
+}
+
+func (ms *MultiShadow) reload() {
+	ms.timeout = defaultTimeout
+	if ms.spec.Timeout != "" {
+		ms.timeout, _ = time.ParseDuration(ms.spec.Timeout)
+	}
+	ms.maxBodySize = ms.spec.MaxBodySize
+	if ms.maxBodySize <= 0 {
+		ms.maxBodySize = defaultMaxBodySize
+	}
+	maxConcurrency := ms.spec.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = defaultMaxConcurrency
+	}
+
+	ms.targets = make([]*target, 0, len(ms.spec.Targets))
+	for _, t := range ms.spec.Targets {
+		// the url has been verified in Validate, so no error here.
+		base, _ := url.Parse(t.URL)
+		tgt := &target{spec: t, base: base, timeout: ms.timeout}
+		if t.Path != nil {
+			tgt.pa = pathadaptor.New(t.Path)
+		}
+		if t.Timeout != "" {
+			tgt.timeout, _ = time.ParseDuration(t.Timeout)
+		}
+		ms.targets = append(ms.targets, tgt)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = maxConcurrency
+	ms.client = &http.Client{
+		Transport: transport,
+		// the redirections are compared instead of followed.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	ms.sink = newSink(ms.spec.Sink)
+	ms.tokens = make(chan struct{}, maxConcurrency)
+	ms.reports = make(chan *Report, reportQueueSize)
+	ms.done = make(chan struct{})
+	ms.wg.Add(1)
+	go ms.run()
+}
+
+func (ms *MultiShadow) run() {
+	defer ms.wg.Done()
+	for {
+		select {
+		case <-ms.done:
+			return
+		case report := <-ms.reports:
+			if err := ms.sink.Send(report); err != nil {
+				logger.Warnf("%s: failed to send report: %v", ms.Name(), err)
+			}
+		}
+	}
+}
+
+// Handle replays the request to the targets asynchronously, the request
+// itself is not changed.
+func (ms *MultiShadow) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	// the body of a stream can only be read once, which is reserved for
+	// the primary, and a body failed to read is incomplete.
+	if req.IsStream() || req.FetchDeferredPayload() != nil || req.PayloadSize() > ms.maxBodySize {
+		atomic.AddUint64(&ms.skipped, 1)
+		return ""
+	}
+
+	select {
+	case ms.tokens <- struct{}{}:
+	default:
+		atomic.AddUint64(&ms.dropped, 1)
+		return ""
+	}
+	atomic.AddUint64(&ms.replayed, 1)
+
+	snap := &snapshot{
+		method:   req.Method(),
+		path:     req.Path(),
+		rawQuery: req.URL().RawQuery,
+		header:   req.HTTPHeader().Clone(),
+		body:     bytes.Clone(req.RawPayload()),
+	}
+	for _, h := range droppedHeaders {
+		snap.header.Del(h)
+	}
+
+	// the response of the primary is available after the request is
+	// handled by all filters.
+	ns, start := ctx.Namespace(), time.Now()
+	primary := make(chan *Result, 1)
+	ctx.OnFinish(func() {
+		primary <- ms.primaryResult(ctx, ns, start)
+	})
+
+	go ms.replay(snap, primary)
+	return ""
+}
+
+func (ms *MultiShadow) primaryResult(ctx *context.Context, ns string, start time.Time) *Result {
+	result := &Result{Duration: time.Since(start).String()}
+	if err := ctx.GetError(); err != nil {
+		result.Error = err.Error()
+	}
+
+	resp, _ := ctx.GetResponse(ns).(*httpprot.Response)
+	if resp == nil {
+		if result.Error == "" {
+			result.Error = "no response"
+		}
+		return result
+	}
+
+	result.StatusCode = resp.StatusCode()
+	result.header = resp.HTTPHeader().Clone()
+	result.BodySize = int(resp.PayloadSize())
+	if !resp.IsStream() && resp.PayloadSize() <= ms.maxBodySize {
+		result.body = bytes.Clone(resp.RawPayload())
+		result.bodyCompared = true
+	}
+	return result
+}
+
+func (ms *MultiShadow) replay(snap *snapshot, primary chan *Result) {
+	defer func() { <-ms.tokens }()
+
+	results := make([]*Result, len(ms.targets))
+	var wg sync.WaitGroup
+	for i, t := range ms.targets {
+		wg.Add(1)
+		go func(i int, t *target) {
+			defer wg.Done()
+			results[i] = ms.call(t, snap)
+		}(i, t)
+	}
+	wg.Wait()
+
+	var p *Result
+	select {
+	case p = <-primary:
+	case <-time.After(ms.timeout):
+		p = &Result{Error: "timeout waiting for the primary response"}
+	}
+
+	report := &Report{
+		Time:    time.Now(),
+		Filter:  ms.Name(),
+		Method:  snap.method,
+		Path:    snap.path,
+		Primary: p,
+		Targets: results,
+	}
+	for i, t := range ms.targets {
+		r := results[i]
+		atomic.AddUint64(&t.requests, 1)
+		if r.Error != "" {
+			atomic.AddUint64(&t.errors, 1)
+			continue
+		}
+		// nothing to compare with if the primary has no response.
+		if p.StatusCode == 0 {
+			continue
+		}
+		if r.Diffs = ms.compare(p, r); len(r.Diffs) > 0 {
+			atomic.AddUint64(&t.mismatches, 1)
+		}
+	}
+
+	if !ms.spec.ReportMatches && !report.Mismatched() {
+		return
+	}
+	select {
+	case ms.reports <- report:
+	default:
+		if atomic.AddUint64(&ms.reportsDropped, 1)%100 == 1 {
+			logger.Warnf("%s: report queue is full, reports are dropped", ms.Name())
+		}
+	}
+}
+
+func (ms *MultiShadow) call(t *target, snap *snapshot) *Result {
+	result := &Result{Name: t.spec.Name}
+
+	u := *t.base
+	u.Path = snap.path
+	if t.pa != nil {
+		u.Path = t.pa.Adapt(snap.path)
+	}
+	u.RawQuery = snap.rawQuery
+
+	stdctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), t.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(stdctx, snap.method, u.String(), bytes.NewReader(snap.body))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header = snap.header.Clone()
+	for k, v := range t.spec.Headers {
+		req.Header.Set(k, v)
+	}
+
+	start := time.Now()
+	resp, err := ms.client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, ms.maxBodySize+1))
+	result.Duration = time.Since(start).String()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.StatusCode = resp.StatusCode
+	result.header = resp.Header
+	result.BodySize = len(body)
+	if int64(len(body)) <= ms.maxBodySize {
+		result.body = body
+		result.bodyCompared = true
+	}
+	return result
+}
+
+// compare returns the differences between the responses of the primary
+// and a target.
+func (ms *MultiShadow) compare(p, t *Result) []string {
+	var diffs []string
+	if p.StatusCode != t.StatusCode {
+		diffs = append(diffs, fmt.Sprintf("statusCode: %d != %d", p.StatusCode, t.StatusCode))
+	}
+
+	for _, h := range ms.spec.CompareHeaders {
+		pv := strings.Join(p.header.Values(h), ", ")
+		tv := strings.Join(t.header.Values(h), ", ")
+		if pv != tv {
+			diffs = append(diffs, fmt.Sprintf("header %s: %q != %q", h, pv, tv))
+		}
+	}
+
+	if p.bodyCompared && t.bodyCompared && !bodyEqual(p.body, t.body) {
+		diffs = append(diffs, "body differs")
+	}
+	return diffs
+}
+
+// bodyEqual compares JSON bodies by their values, so the formatting and
+// the order of the fields don't matter, other bodies are compared by
+// bytes.
+func bodyEqual(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// Status returns status.
+func (ms *MultiShadow) Status() interface{} {
+	s := &Status{
+		Replayed:       atomic.LoadUint64(&ms.replayed),
+		Dropped:        atomic.LoadUint64(&ms.dropped),
+		Skipped:        atomic.LoadUint64(&ms.skipped),
+		ReportsDropped: atomic.LoadUint64(&ms.reportsDropped),
+		Targets:        map[string]*TargetStatus{},
+	}
+	for _, t := range ms.targets {
+		s.Targets[t.spec.Name] = &TargetStatus{
+			Requests:   atomic.LoadUint64(&t.requests),
+			Mismatches: atomic.LoadUint64(&t.mismatches),
+			Errors:     atomic.LoadUint64(&t.errors),
+		}
+	}
+	return s
+}
+
+// Close closes MultiShadow, reports waiting to be sent are discarded.
+func (ms *MultiShadow) Close() {
+	close(ms.done)
+	ms.wg.Wait()
+	ms.client.CloseIdleConnections()
+}
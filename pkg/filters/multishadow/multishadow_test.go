@@ -0,0 +1,315 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package multishadow
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+type mockSink struct {
+	reports chan *Report
+}
+
+func (s *mockSink) Send(report *Report) error {
+	s.reports <- report
+	return nil
+}
+
+func newTestMultiShadow(t *testing.T, yamlConfig string) (*MultiShadow, *mockSink) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	assert.NoError(t, err)
+	ms := kind.CreateInstance(spec).(*MultiShadow)
+	ms.Init()
+	sink := &mockSink{reports: make(chan *Report, 10)}
+	ms.sink = sink
+	return ms, sink
+}
+
+func newContext(method, target, body string) *context.Context {
+	stdReq := httptest.NewRequest(method, target, strings.NewReader(body))
+	stdReq.Header.Set("X-User", "u1")
+	req, _ := httpprot.NewRequest(stdReq)
+	req.FetchPayload(1024)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+// finish simulates the primary, which responds 200 with the body.
+func finish(ctx *context.Context, body string) {
+	resp, _ := httpprot.NewResponse(nil)
+	resp.HTTPHeader().Set("X-Version", "v1")
+	resp.SetPayload([]byte(body))
+	ctx.SetOutputResponse(resp)
+	ctx.Finish()
+}
+
+func waitReport(t *testing.T, sink *mockSink) *Report {
+	select {
+	case r := <-sink.reports:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("no report")
+		return nil
+	}
+}
+
+func TestMultiShadow(t *testing.T) {
+	assert := assert.New(t)
+
+	staging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(http.MethodPost, r.Method)
+		assert.Equal("/staging/orders", r.URL.Path)
+		assert.Equal("id=1", r.URL.RawQuery)
+		assert.Equal("u1", r.Header.Get("X-User"))
+		assert.Equal("staging", r.Header.Get("X-Env"))
+		assert.Equal(`{"item":"a"}`, string(body))
+		w.Header().Set("X-Version", "v1")
+		w.Write([]byte(`{ "status": "ok", "id": 1 }`))
+	}))
+	defer staging.Close()
+
+	candidate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/orders", r.URL.Path)
+		w.Header().Set("X-Version", "v2")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status":"created"}`))
+	}))
+	defer candidate.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	broken.Close()
+
+	ms, sink := newTestMultiShadow(t, `
+kind: MultiShadow
+name: shadow
+targets:
+- name: staging
+  url: `+staging.URL+`
+  path:
+    addPrefix: /staging
+  headers:
+    X-Env: staging
+- name: candidate
+  url: `+candidate.URL+`
+- name: broken
+  url: `+broken.URL+`
+compareHeaders: [X-Version]
+`)
+	defer ms.Close()
+	assert.Equal(kind, ms.Kind())
+	assert.Equal("shadow", ms.Name())
+	assert.NotNil(ms.Spec())
+
+	ctx := newContext(http.MethodPost, "http://localhost/orders?id=1", `{"item":"a"}`)
+	assert.Equal("", ms.Handle(ctx))
+	// the filters after MultiShadow may change the payload in place.
+	copy(ctx.GetInputRequest().(*httpprot.Request).RawPayload(), "changed")
+	finish(ctx, `{"id":1,"status":"ok"}`)
+
+	report := waitReport(t, sink)
+	assert.Equal("shadow", report.Filter)
+	assert.Equal("/orders", report.Path)
+	assert.Equal(http.StatusOK, report.Primary.StatusCode)
+	assert.True(report.Mismatched())
+
+	assert.Equal("staging", report.Targets[0].Name)
+	assert.Empty(report.Targets[0].Error)
+	assert.Empty(report.Targets[0].Diffs)
+
+	assert.Equal([]string{
+		"statusCode: 200 != 201",
+		`header X-Version: "v1" != "v2"`,
+		"body differs",
+	}, report.Targets[1].Diffs)
+
+	assert.NotEmpty(report.Targets[2].Error)
+
+	status := ms.Status().(*Status)
+	assert.Equal(uint64(1), status.Replayed)
+	assert.Equal(&TargetStatus{Requests: 1}, status.Targets["staging"])
+	assert.Equal(&TargetStatus{Requests: 1, Mismatches: 1}, status.Targets["candidate"])
+	assert.Equal(&TargetStatus{Requests: 1, Errors: 1}, status.Targets["broken"])
+}
+
+func TestMultiShadowReportMatches(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	yamlConfig := `
+kind: MultiShadow
+name: shadow
+targets:
+- name: staging
+  url: ` + server.URL
+
+	// matches are not reported by default.
+	ms, sink := newTestMultiShadow(t, yamlConfig)
+	ctx := newContext(http.MethodGet, "http://localhost/", "")
+	ms.Handle(ctx)
+	finish(ctx, "hello")
+	ctx = newContext(http.MethodGet, "http://localhost/", "")
+	ms.Handle(ctx)
+	finish(ctx, "world")
+	report := waitReport(t, sink)
+	assert.Equal([]string{"body differs"}, report.Targets[0].Diffs)
+	ms.Close()
+	assert.Empty(sink.reports)
+
+	ms, sink = newTestMultiShadow(t, yamlConfig+"\nreportMatches: true")
+	defer ms.Close()
+	ctx = newContext(http.MethodGet, "http://localhost/", "")
+	ms.Handle(ctx)
+	finish(ctx, "hello")
+	report = waitReport(t, sink)
+	assert.False(report.Mismatched())
+}
+
+func TestMultiShadowBounded(t *testing.T) {
+	assert := assert.New(t)
+
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+
+	ms, sink := newTestMultiShadow(t, `
+kind: MultiShadow
+name: shadow
+targets:
+- name: slow
+  url: `+server.URL+`
+  timeout: 100ms
+maxConcurrency: 1
+maxBodySize: 4
+timeout: 100ms
+`)
+	defer ms.Close()
+
+	// the primary is not affected by the slow target.
+	ctx1 := newContext(http.MethodGet, "http://localhost/", "")
+	ms.Handle(ctx1)
+	ctx2 := newContext(http.MethodGet, "http://localhost/", "")
+	ms.Handle(ctx2)
+	ctx3 := newContext(http.MethodPost, "http://localhost/", "too large")
+	ms.Handle(ctx3)
+
+	status := ms.Status().(*Status)
+	assert.Equal(uint64(1), status.Replayed)
+	assert.Equal(uint64(1), status.Dropped)
+	assert.Equal(uint64(1), status.Skipped)
+
+	// the primary never finishes.
+	report := waitReport(t, sink)
+	close(block)
+	assert.NotEmpty(report.Targets[0].Error)
+	assert.NotEmpty(report.Primary.Error)
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, yamlConfig := range []string{`
+kind: MultiShadow
+name: shadow
+targets: []
+`, `
+kind: MultiShadow
+name: shadow
+targets:
+- name: a
+  url: http://a
+- name: a
+  url: http://b
+`, `
+kind: MultiShadow
+name: shadow
+targets:
+- name: a
+  url: ftp://a
+`, `
+kind: MultiShadow
+name: shadow
+targets:
+- name: a
+  url: http://a/api
+`, `
+kind: MultiShadow
+name: shadow
+targets:
+- name: a
+  url: http://a
+sink:
+  kind: http
+`} {
+		rawSpec := map[string]interface{}{}
+		codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+		_, err := filters.NewSpec(nil, "", rawSpec)
+		assert.Error(err, yamlConfig)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	assert := assert.New(t)
+
+	var received *Report
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/404" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		assert.Equal("token", r.Header.Get("Authorization"))
+		received = &Report{}
+		codectool.MustDecodeJSON(r.Body, received)
+	}))
+	defer server.Close()
+
+	sink := newSink(&SinkSpec{Kind: SinkHTTP, URL: server.URL, Headers: map[string]string{"Authorization": "token"}})
+	err := sink.Send(&Report{Filter: "shadow", Targets: []*Result{{Name: "staging", Diffs: []string{"body differs"}}}})
+	assert.NoError(err)
+	assert.Equal("shadow", received.Filter)
+	assert.Equal([]string{"body differs"}, received.Targets[0].Diffs)
+
+	sink = newSink(&SinkSpec{Kind: SinkHTTP, URL: server.URL + "/404"})
+	assert.Error(sink.Send(&Report{}))
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package multishadow
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+const (
+	// SinkLog writes the reports to the log.
+	SinkLog = "log"
+	// SinkHTTP posts the reports to an HTTP endpoint in JSON.
+	SinkHTTP = "http"
+
+	defaultSinkTimeout = 5 * time.Second
+)
+
+type (
+	// Report is the comparison of the responses of a request.
+	Report struct {
+		Time    time.Time `json:"time"`
+		Filter  string    `json:"filter"`
+		Method  string    `json:"method"`
+		Path    string    `json:"path"`
+		Primary *Result   `json:"primary"`
+		Targets []*Result `json:"targets"`
+	}
+
+	// Result is the response of the primary or a target.
+	Result struct {
+		// Name is the name of the target, it is empty for the primary.
+		Name       string `json:"name,omitempty"`
+		StatusCode int    `json:"statusCode,omitempty"`
+		BodySize   int    `json:"bodySize,omitempty"`
+		Duration   string `json:"duration,omitempty"`
+		Error      string `json:"error,omitempty"`
+		// Diffs are the differences from the primary, only for targets.
+		Diffs []string `json:"diffs,omitempty"`
+
+		header http.Header
+		body   []byte
+		// bodyCompared is false if the body is a stream or too large.
+		bodyCompared bool
+	}
+
+	// Sink receives the reports.
+	Sink interface {
+		Send(report *Report) error
+	}
+
+	// SinkSpec is the spec of the sink.
+	SinkSpec struct {
+		Kind    string            `json:"kind,omitempty" jsonschema:"enum=,enum=log,enum=http"`
+		URL     string            `json:"url,omitempty" jsonschema:"format=uri"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Timeout string            `json:"timeout,omitempty" jsonschema:"format=duration"`
+	}
+
+	logSink struct{}
+
+	httpSink struct {
+		spec   *SinkSpec
+		client *http.Client
+	}
+)
+
+// Validate validates the SinkSpec.
+func (spec *SinkSpec) Validate() error {
+	if spec.Kind == SinkHTTP && spec.URL == "" {
+		return fmt.Errorf("url is required by sink http")
+	}
+	if spec.Timeout != "" {
+		if d, err := time.ParseDuration(spec.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q", spec.Timeout)
+		}
+	}
+	return nil
+}
+
+func newSink(spec *SinkSpec) Sink {
+	if spec == nil || spec.Kind != SinkHTTP {
+		return logSink{}
+	}
+
+	timeout := defaultSinkTimeout
+	if spec.Timeout != "" {
+		timeout, _ = time.ParseDuration(spec.Timeout)
+	}
+	return &httpSink{spec: spec, client: &http.Client{Timeout: timeout}}
+}
+
+// Mismatched returns whether any target failed or differs from the
+// primary.
+func (r *Report) Mismatched() bool {
+	for _, t := range r.Targets {
+		if t.Error != "" || len(t.Diffs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Send implements Sink.
+func (s logSink) Send(report *Report) error {
+	data, err := codectool.MarshalJSON(report)
+	if err != nil {
+		return err
+	}
+	logger.Infof("multi shadow report: %s", data)
+	return nil
+}
+
+// Send implements Sink.
+func (s *httpSink) Send(report *Report) error {
+	data, err := codectool.MarshalJSON(report)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.spec.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, v := range s.spec.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: status code %d", s.spec.URL, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package oauth2client implements a filter which authenticates requests to
+// backends with OAuth2 access tokens of the client credentials grant.
+package oauth2client
+
+import (
+	stdctx "context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of OAuth2Client.
+	Kind = "OAuth2Client"
+
+	resultTokenFailed = "tokenFailed"
+
+	// FailurePolicyFail fails the request if no token is acquired.
+	FailurePolicyFail = "fail"
+	// FailurePolicyContinue forwards the request without a token if no
+	// token is acquired.
+	FailurePolicyContinue = "continue"
+
+	// failureCooldown is the time to fail fast after a failure, so that
+	// an unavailable token endpoint is not overwhelmed by the requests.
+	failureCooldown = time.Second
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "OAuth2Client acquires OAuth2 access tokens with the client credentials grant, and injects them into requests to backends.",
+	Results:     []string{resultTokenFailed},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{
+			Timeout:       "5s",
+			RefreshBefore: "30s",
+			FailurePolicy: FailurePolicyFail,
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &OAuth2Client{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// OAuth2Client is the filter OAuth2Client.
+	OAuth2Client struct {
+		spec *Spec
+
+		tokenSource oauth2.TokenSource
+		fetcher     *fetcher
+	}
+
+	// Spec is the spec of OAuth2Client.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		TokenURL        string            `json:"tokenURL" jsonschema:"required,format=uri"`
+		ClientID        string            `json:"clientId" jsonschema:"required"`
+		ClientSecret    string            `json:"clientSecret,omitempty"`
+		ClientSecretRef *SecretRef        `json:"clientSecretRef,omitempty"`
+		Scopes          []string          `json:"scopes,omitempty"`
+		EndpointParams  map[string]string `json:"endpointParams,omitempty"`
+		AuthStyle       string            `json:"authStyle,omitempty" jsonschema:"enum=,enum=header,enum=params"`
+		Timeout         string            `json:"timeout,omitempty" jsonschema:"format=duration"`
+
+		// RefreshBefore is how long before the expiry the token is
+		// refreshed.
+		RefreshBefore string `json:"refreshBefore,omitempty" jsonschema:"format=duration"`
+		FailurePolicy string `json:"failurePolicy,omitempty" jsonschema:"enum=fail,enum=continue"`
+	}
+
+	// SecretRef refers to a secret stored outside of the spec, exactly one
+	// of the fields must be set.
+	SecretRef struct {
+		// Env is the name of the environment variable.
+		Env string `json:"env,omitempty"`
+		// File is the path of the file, the leading and trailing white
+		// spaces of its content are trimmed.
+		File string `json:"file,omitempty"`
+	}
+
+	// Status is the status of OAuth2Client.
+	Status struct {
+		TokenExpiry time.Time `json:"tokenExpiry,omitempty"`
+		Fetches     uint64    `json:"fetches"`
+		Failures    uint64    `json:"failures"`
+	}
+
+	// fetcher fetches a new token on every call, it is wrapped by a
+	// reusing token source, which caches the token until it expires.
+	fetcher struct {
+		spec   *Spec
+		ctx    stdctx.Context
+		params url.Values
+
+		mutex    sync.Mutex
+		expiry   time.Time
+		failedAt time.Time
+		lastErr  error
+		fetches  uint64
+		failures uint64
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if (spec.ClientSecret == "") == (spec.ClientSecretRef == nil) {
+		return fmt.Errorf("exactly one of clientSecret and clientSecretRef must be set")
+	}
+	return nil
+}
+
+// Validate validates the secret reference.
+func (ref *SecretRef) Validate() error {
+	if (ref.Env == "") == (ref.File == "") {
+		return fmt.Errorf("exactly one of env and file must be set")
+	}
+	return nil
+}
+
+// Resolve returns the secret.
+func (ref *SecretRef) Resolve() (string, error) {
+	if ref.Env != "" {
+		v, ok := os.LookupEnv(ref.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s not found", ref.Env)
+		}
+		return v, nil
+	}
+
+	data, err := os.ReadFile(ref.File)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Name returns the name of the OAuth2Client filter instance.
+func (oc *OAuth2Client) Name() string {
+	return oc.spec.Name()
+}
+
+// Kind returns the kind of OAuth2Client.
+func (oc *OAuth2Client) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the OAuth2Client
+func (oc *OAuth2Client) Spec() filters.Spec {
+	return oc.spec
+}
+
+// Init initializes OAuth2Client.
+func (oc *OAuth2Client) Init() {
+	timeout, _ := time.ParseDuration(oc.spec.Timeout)
+	refreshBefore, _ := time.ParseDuration(oc.spec.RefreshBefore)
+
+	params := url.Values{}
+	for k, v := range oc.spec.EndpointParams {
+		params.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	oc.fetcher = &fetcher{
+		spec:   oc.spec,
+		ctx:    stdctx.WithValue(stdctx.Background(), oauth2.HTTPClient, client),
+		params: params,
+	}
+	oc.tokenSource = oauth2.ReuseTokenSourceWithExpiry(nil, oc.fetcher, refreshBefore)
+}
+
+// Inherit inherits previous generation of OAuth2Client.
+func (oc *OAuth2Client) Inherit(previousGeneration filters.Filter) {
+	oc.Init()
+}
+
+// Token implements oauth2.TokenSource.
+func (f *fetcher) Token() (*oauth2.Token, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !f.failedAt.IsZero() && time.Since(f.failedAt) < failureCooldown {
+		return nil, f.lastErr
+	}
+
+	token, err := f.fetch()
+	f.fetches++
+	if err != nil {
+		f.failures++
+		f.failedAt, f.lastErr = time.Now(), err
+		return nil, err
+	}
+
+	f.failedAt, f.lastErr = time.Time{}, nil
+	f.expiry = token.Expiry
+	return token, nil
+}
+
+func (f *fetcher) fetch() (*oauth2.Token, error) {
+	secret := f.spec.ClientSecret
+	if ref := f.spec.ClientSecretRef; ref != nil {
+		s, err := ref.Resolve()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve client secret: %v", err)
+		}
+		secret = s
+	}
+
+	cfg := &clientcredentials.Config{
+		ClientID:       f.spec.ClientID,
+		ClientSecret:   secret,
+		TokenURL:       f.spec.TokenURL,
+		Scopes:         f.spec.Scopes,
+		EndpointParams: f.params,
+	}
+	switch f.spec.AuthStyle {
+	case "header":
+		cfg.AuthStyle = oauth2.AuthStyleInHeader
+	case "params":
+		cfg.AuthStyle = oauth2.AuthStyleInParams
+	}
+
+	return cfg.Token(f.ctx)
+}
+
+// Handle injects the access token into the request.
+func (oc *OAuth2Client) Handle(ctx *context.Context) string {
+	token, err := oc.tokenSource.Token()
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("oauth2Client: failed to acquire token: %v", err))
+		if oc.spec.FailurePolicy == FailurePolicyContinue {
+			return ""
+		}
+
+		logger.Errorf("%s: failed to acquire token: %v", oc.Name(), err)
+		resp, _ := httpprot.NewResponse(nil)
+		resp.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.SetOutputResponse(resp)
+		return resultTokenFailed
+	}
+
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	req.HTTPHeader().Set("Authorization", "Bearer "+token.AccessToken)
+	return ""
+}
+
+// Status returns status.
+func (oc *OAuth2Client) Status() interface{} {
+	f := oc.fetcher
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return &Status{
+		TokenExpiry: f.expiry,
+		Fetches:     f.fetches,
+		Failures:    f.failures,
+	}
+}
+
+// Close closes OAuth2Client.
+func (oc *OAuth2Client) Close() {
+}
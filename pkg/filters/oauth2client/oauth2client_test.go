@@ -0,0 +1,248 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oauth2client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newSpec(yamlConfig string) (filters.Spec, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	return filters.NewSpec(nil, "", rawSpec)
+}
+
+func newTestFilter(yamlConfig string) *OAuth2Client {
+	spec, err := newSpec(yamlConfig)
+	if err != nil {
+		panic(err)
+	}
+	oc := kind.CreateInstance(spec).(*OAuth2Client)
+	oc.Init()
+	return oc
+}
+
+func newContext() (*context.Context, *httpprot.Request) {
+	stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	stdr.Header.Set("Authorization", "Basic client")
+	req, _ := httpprot.NewRequest(stdr)
+	req.FetchPayload(0)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, req
+}
+
+type tokenServer struct {
+	*httptest.Server
+	issued    int32
+	expiresIn int
+	fail      atomic.Bool
+}
+
+func newTokenServer(t *testing.T, secret string) *tokenServer {
+	ts := &tokenServer{expiresIn: 3600}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, s, ok := r.BasicAuth()
+		if ts.fail.Load() || !ok || id != "gateway" || s != secret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		assert.Equal(t, "client_credentials", r.Form.Get("grant_type"))
+		assert.Equal(t, "read write", r.Form.Get("scope"))
+		assert.Equal(t, "orders", r.Form.Get("audience"))
+
+		n := atomic.AddInt32(&ts.issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":%d}`, n, ts.expiresIn)
+	}))
+	return ts
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newSpec(`
+kind: OAuth2Client
+name: oc
+tokenURL: http://127.0.0.1/token
+clientId: gateway
+`)
+	assert.Error(err)
+
+	_, err = newSpec(`
+kind: OAuth2Client
+name: oc
+tokenURL: http://127.0.0.1/token
+clientId: gateway
+clientSecret: abc
+clientSecretRef:
+  env: SECRET
+`)
+	assert.Error(err)
+
+	_, err = newSpec(`
+kind: OAuth2Client
+name: oc
+tokenURL: http://127.0.0.1/token
+clientId: gateway
+clientSecretRef:
+  env: SECRET
+  file: /tmp/secret
+`)
+	assert.Error(err)
+}
+
+func TestOAuth2Client(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := newTokenServer(t, "s3cret")
+	defer ts.Close()
+
+	oc := newTestFilter(fmt.Sprintf(`
+kind: OAuth2Client
+name: oc
+tokenURL: %s
+clientId: gateway
+clientSecret: s3cret
+authStyle: header
+scopes: [read, write]
+endpointParams:
+  audience: orders
+`, ts.URL))
+	assert.Equal(kind, oc.Kind())
+	assert.Equal("oc", oc.Name())
+
+	ctx, req := newContext()
+	assert.Equal("", oc.Handle(ctx))
+	assert.Equal("Bearer token-1", req.HTTPHeader().Get("Authorization"))
+
+	// the token is cached.
+	ctx, req = newContext()
+	assert.Equal("", oc.Handle(ctx))
+	assert.Equal("Bearer token-1", req.HTTPHeader().Get("Authorization"))
+	assert.Equal(int32(1), atomic.LoadInt32(&ts.issued))
+
+	status := oc.Status().(*Status)
+	assert.Equal(uint64(1), status.Fetches)
+	assert.True(status.TokenExpiry.After(time.Now().Add(time.Hour - time.Minute)))
+
+	// the token is refreshed before it expires.
+	ts.expiresIn = 10
+	oc.Init()
+	for i := 2; i <= 3; i++ {
+		ctx, req = newContext()
+		assert.Equal("", oc.Handle(ctx))
+		assert.Equal(fmt.Sprintf("Bearer token-%d", i), req.HTTPHeader().Get("Authorization"))
+	}
+}
+
+func TestFailurePolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := newTokenServer(t, "s3cret")
+	defer ts.Close()
+	ts.fail.Store(true)
+
+	yamlConfig := `
+kind: OAuth2Client
+name: oc
+tokenURL: %s
+clientId: gateway
+clientSecret: s3cret
+scopes: [read, write]
+endpointParams:
+  audience: orders
+failurePolicy: %s
+`
+	oc := newTestFilter(fmt.Sprintf(yamlConfig, ts.URL, FailurePolicyFail))
+	ctx, _ := newContext()
+	assert.Equal(resultTokenFailed, oc.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode())
+
+	// fail fast in the cool-down.
+	ts.fail.Store(false)
+	ctx, _ = newContext()
+	assert.Equal(resultTokenFailed, oc.Handle(ctx))
+	status := oc.Status().(*Status)
+	assert.Equal(uint64(1), status.Fetches)
+	assert.Equal(uint64(1), status.Failures)
+
+	ts.fail.Store(true)
+	oc = newTestFilter(fmt.Sprintf(yamlConfig, ts.URL, FailurePolicyContinue))
+	ctx, req := newContext()
+	assert.Equal("", oc.Handle(ctx))
+	assert.Nil(ctx.GetOutputResponse())
+	assert.Equal("Basic client", req.HTTPHeader().Get("Authorization"))
+}
+
+func TestSecretRef(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := newTokenServer(t, "from-file")
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(path, []byte("from-file\n"), 0o600)
+
+	oc := newTestFilter(fmt.Sprintf(`
+kind: OAuth2Client
+name: oc
+tokenURL: %s
+clientId: gateway
+clientSecretRef:
+  file: %s
+authStyle: header
+scopes: [read, write]
+endpointParams:
+  audience: orders
+`, ts.URL, path))
+	ctx, req := newContext()
+	assert.Equal("", oc.Handle(ctx))
+	assert.Equal("Bearer token-1", req.HTTPHeader().Get("Authorization"))
+
+	ref := &SecretRef{Env: "OAUTH2CLIENT_TEST_SECRET"}
+	_, err := ref.Resolve()
+	assert.Error(err)
+	t.Setenv("OAUTH2CLIENT_TEST_SECRET", "from-env")
+	secret, err := ref.Resolve()
+	assert.NoError(err)
+	assert.Equal("from-env", secret)
+
+	oc.Inherit(oc)
+	oc.Close()
+}
@@ -36,6 +36,7 @@ import (
 	"github.com/megaease/easegress/v2/pkg/filters"
 	"github.com/megaease/easegress/v2/pkg/logger"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
 )
 
 // https://openid.net/specs/openid-connect-core-1_0.html
@@ -55,6 +56,7 @@ var kind = &filters.Kind{
 	Name:        kindName,
 	Description: "OIDCAdaptor implement OpenID Connect authorization code flow spec",
 	Results:     []string{resultFiltered},
+	RequestOnly: true,
 	DefaultSpec: func() filters.Spec {
 		return &Spec{}
 	},
@@ -63,15 +65,16 @@ var kind = &filters.Kind{
 	},
 }
 
-type store interface {
-	put(key, value string, timeout time.Duration) error
-	get(key string) string
-}
+// stateTTL is the lifetime of an authorization state, end-user may spend
+// some time doing login stuff, so we use a 10-minute timeout.
+const stateTTL = 10 * time.Minute
 
 // OIDCAdaptor is the filter for OpenID Connect authorization.
 type OIDCAdaptor struct {
 	spec *Spec
-	store
+	// sessions keeps the authorization states, and the original request
+	// URL of each state.
+	sessions supervisor.SessionStore
 
 	// Following are user custom defined
 	jwksRefreshInterval  string
@@ -150,8 +153,9 @@ func (o *OIDCAdaptor) Kind() *filters.Kind {
 
 // Init initializes the filter.
 func (o *OIDCAdaptor) Init() {
-	// delegate store interface operation to itself for testing
-	o.store = o
+	if o.sessions == nil {
+		o.sessions = o.newSessionStore()
+	}
 	if len(o.spec.Discovery) > 0 {
 		o.initDiscoveryOIDCConf()
 	} else {
@@ -173,6 +177,9 @@ func (o *OIDCAdaptor) Init() {
 
 // Inherit inherits previous generation of the filter instance.
 func (o *OIDCAdaptor) Inherit(previousGeneration filters.Filter) {
+	// keep the states of the ongoing authorizations.
+	prev := previousGeneration.(*OIDCAdaptor)
+	o.sessions, prev.sessions = prev.sessions, nil
 	o.Init()
 	previousGeneration.Close()
 }
@@ -214,6 +221,22 @@ func (o *OIDCAdaptor) Status() interface{} {
 
 // Close closes the filter instance.
 func (o *OIDCAdaptor) Close() {
+	if o.sessions != nil {
+		o.sessions.Close()
+	}
+}
+
+// newSessionStore creates the session store of the authorization states,
+// the states are kept in the cluster so that the callback could be handled
+// by any member.
+func (o *OIDCAdaptor) newSessionStore() supervisor.SessionStore {
+	super := o.spec.Super()
+	if super == nil || super.Cluster() == nil {
+		return supervisor.NewMemorySessionStore(stateTTL)
+	}
+	cls := super.Cluster()
+	name := "oidc-" + o.spec.Pipeline() + "-" + o.spec.Name()
+	return supervisor.NewClusterSessionStore(cls, cls.Layout().SessionDataPrefix(name), stateTTL)
 }
 
 func (o *OIDCAdaptor) initDiscoveryOIDCConf() {
@@ -262,7 +285,7 @@ func (o *OIDCAdaptor) handleOIDCCallback(ctx *context.Context) string {
 			req.Header().Set("X-Access-Token", oidcToken.AccessToken)
 		}
 	}
-	reqURL := o.store.get(clusterCacheKey("request_url", state))
+	reqURL := o.requestURLOf(state)
 	req.Header().Set("X-Origin-Request-URL", reqURL)
 
 	userInfo := map[string]any{}
@@ -359,8 +382,11 @@ func (o *OIDCAdaptor) validateCodeAndState(code, state string) error {
 	if len(state) == 0 {
 		return fmt.Errorf(errFmt, "empty state")
 	}
-	cacheState := o.store.get(clusterCacheKey("state", state))
-	if cacheState != "1" {
+	data, err := o.sessions.Get(state)
+	if err != nil {
+		logger.Errorf("get oidc state error: %s", err)
+	}
+	if data == nil {
 		return fmt.Errorf(errFmt, "invalid state")
 	}
 	if len(code) == 0 {
@@ -377,11 +403,6 @@ func (o *OIDCAdaptor) buildAuthorizeURL(req *httpprot.Request) string {
 	state := strings.ReplaceAll(uuid.New().String(), "-", "")
 	// state is recommended
 	authURLBuilder.WriteString("&state=" + state)
-	// End-user may spend some time doing login stuff, so we use a 10-minute timeout
-	err := o.store.put(clusterCacheKey("state", state), "1", 10*time.Minute)
-	if err != nil {
-		logger.Errorf("put oidc state error: %s", err)
-	}
 	var reqURL string
 	args := req.URL().RawQuery
 	if args != "" {
@@ -389,9 +410,9 @@ func (o *OIDCAdaptor) buildAuthorizeURL(req *httpprot.Request) string {
 	} else {
 		reqURL = fmt.Sprintf("%s://%s%s", req.Scheme(), req.Host(), req.Path())
 	}
-	err = o.store.put(clusterCacheKey("request_url", state), reqURL, 10*time.Minute)
+	err := o.sessions.Set(state, map[string]string{"requestURL": reqURL}, stateTTL)
 	if err != nil {
-		logger.Errorf("put origin request url error: %s", err)
+		logger.Errorf("put oidc state error: %s", err)
 	}
 	// nonce is optional
 	nonce := strings.ReplaceAll(uuid.New().String(), "-", "")
@@ -407,8 +428,17 @@ func (o *OIDCAdaptor) buildAuthorizeURL(req *httpprot.Request) string {
 	return authURLBuilder.String()
 }
 
-func clusterCacheKey(tag string, val string) string {
-	return "eg_oidc_key_" + tag + "_" + val
+// requestURLOf returns the original request URL of the state, and deletes
+// the state as it could be used only once.
+func (o *OIDCAdaptor) requestURLOf(state string) string {
+	data, err := o.sessions.Get(state)
+	if err != nil {
+		logger.Errorf("get oidc state error: %s", err)
+	}
+	if err = o.sessions.Delete(state); err != nil {
+		logger.Errorf("delete oidc state error: %s", err)
+	}
+	return data["requestURL"]
 }
 
 func readResp(resp *http.Response, err error, result any) error {
@@ -440,17 +470,3 @@ func errorResp(resp *httpprot.Response, payload any) string {
 	resp.SetPayload(payload)
 	return resultFiltered
 }
-
-func (o *OIDCAdaptor) put(key, value string, timeout time.Duration) error {
-	return o.spec.Super().Cluster().PutUnderTimeout(key, value, timeout)
-}
-func (o *OIDCAdaptor) get(key string) string {
-	val, err := o.spec.Super().Cluster().Get(key)
-	if err != nil {
-		logger.Errorf("get value by key[%s] error: %s", key, err)
-	}
-	if val == nil {
-		return ""
-	}
-	return *val
-}
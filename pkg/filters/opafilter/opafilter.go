@@ -51,6 +51,7 @@ var kind = &filters.Kind{
 	Name:        kindName,
 	Description: "OPAFilter implement OpenPolicyAgent function",
 	Results:     []string{resultFiltered},
+	RequestOnly: true,
 	DefaultSpec: func() filters.Spec {
 		return &Spec{
 			DefaultStatus: 403,
@@ -0,0 +1,254 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package originguard implements a filter which checks the Origin or
+// Referer of state-changing requests against an allowlist.
+package originguard
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/prometheushelper"
+)
+
+const (
+	// Kind is the kind of OriginGuard.
+	Kind = "OriginGuard"
+
+	resultMissingOrigin  = "missingOrigin"
+	resultOriginMismatch = "originMismatch"
+
+	reasonMissing        = "missing"
+	reasonMismatch       = "mismatch"
+	reasonInvalidReferer = "invalidReferer"
+
+	// PolicyStrict rejects requests without both Origin and Referer.
+	PolicyStrict = "strict"
+	// PolicyLenient allows requests without both Origin and Referer.
+	PolicyLenient = "lenient"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "OriginGuard rejects state-changing requests whose Origin or Referer is not allowed.",
+	Results:     []string{resultMissingOrigin, resultOriginMismatch},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{MissingPolicy: PolicyLenient}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &OriginGuard{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+// safeMethods are the methods which are not checked.
+var safeMethods = map[string]struct{}{
+	http.MethodGet:     {},
+	http.MethodHead:    {},
+	http.MethodOptions: {},
+	http.MethodTrace:   {},
+}
+
+type (
+	// OriginGuard is the filter OriginGuard.
+	OriginGuard struct {
+		spec *Spec
+
+		allowedOrigins []string
+		rejected       *prometheus.CounterVec
+
+		missing        uint64
+		mismatch       uint64
+		invalidReferer uint64
+	}
+
+	// Spec is the spec of OriginGuard.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// AllowedOrigins are the allowed origins, like
+		// https://example.com, a '*' matches any sequence of characters
+		// in the host, like https://*.example.com.
+		AllowedOrigins []string `json:"allowedOrigins" jsonschema:"required,minItems=1"`
+		// MissingPolicy is the policy of requests without both Origin
+		// and Referer.
+		MissingPolicy string `json:"missingPolicy,omitempty" jsonschema:"enum=strict,enum=lenient"`
+	}
+
+	// Status is the status of OriginGuard.
+	Status struct {
+		Missing        uint64 `json:"missing"`
+		Mismatch       uint64 `json:"mismatch"`
+		InvalidReferer uint64 `json:"invalidReferer"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	for _, o := range spec.AllowedOrigins {
+		if _, err := path.Match(o, ""); err != nil {
+			return fmt.Errorf("invalid allowed origin %q: %v", o, err)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the OriginGuard filter instance.
+func (g *OriginGuard) Name() string {
+	return g.spec.Name()
+}
+
+// Kind returns the kind of OriginGuard.
+func (g *OriginGuard) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the OriginGuard
+func (g *OriginGuard) Spec() filters.Spec {
+	return g.spec
+}
+
+// Init initializes OriginGuard.
+func (g *OriginGuard) Init() {
+	g.reload()
+}
+
+// Inherit inherits previous generation of OriginGuard.
+func (g *OriginGuard) Inherit(previousGeneration filters.Filter) {
+	g.Init()
+}
+
+func (g *OriginGuard) reload() {
+	g.allowedOrigins = make([]string, 0, len(g.spec.AllowedOrigins))
+	for _, o := range g.spec.AllowedOrigins {
+		g.allowedOrigins = append(g.allowedOrigins, strings.ToLower(strings.TrimSuffix(o, "/")))
+	}
+	g.rejected = g.newRejectedCounter()
+}
+
+func (g *OriginGuard) newRejectedCounter() *prometheus.CounterVec {
+	labels := prometheus.Labels{
+		"filterName":   g.Name(),
+		"kind":         Kind,
+		"clusterName":  "",
+		"clusterRole":  "",
+		"instanceName": "",
+	}
+	if super := g.spec.Super(); super != nil && super.Options() != nil {
+		labels["clusterName"] = super.Options().ClusterName
+		labels["clusterRole"] = super.Options().ClusterRole
+		labels["instanceName"] = super.Options().Name
+	}
+	return prometheushelper.NewCounter("originguard_rejected_requests",
+		"the total count of requests rejected by the origin guard",
+		[]string{"clusterName", "clusterRole", "instanceName", "filterName", "kind", "reason"},
+	).MustCurryWith(labels)
+}
+
+// allowed returns whether the origin matches any of the allowed origins,
+// the path.Match never fails as the patterns are validated.
+func (g *OriginGuard) allowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range g.allowedOrigins {
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// refererOrigin returns the origin of the referer.
+func refererOrigin(referer string) (string, bool) {
+	u, err := url.Parse(referer)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", false
+	}
+	return u.Scheme + "://" + u.Host, true
+}
+
+func (g *OriginGuard) reject(ctx *context.Context, result, reason string, counter *uint64) string {
+	atomic.AddUint64(counter, 1)
+	g.rejected.WithLabelValues(reason).Inc()
+
+	ctx.AddTag(fmt.Sprintf("originGuard: rejected by %s", reason))
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(http.StatusForbidden)
+	ctx.SetOutputResponse(resp)
+	return result
+}
+
+// Handle checks the Origin, or the Referer if there's no Origin, of
+// state-changing requests.
+func (g *OriginGuard) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	if _, ok := safeMethods[req.Method()]; ok {
+		return ""
+	}
+
+	h := req.HTTPHeader()
+	origin := h.Get("Origin")
+	if origin == "" {
+		referer := h.Get("Referer")
+		if referer == "" {
+			if g.spec.MissingPolicy == PolicyStrict {
+				return g.reject(ctx, resultMissingOrigin, reasonMissing, &g.missing)
+			}
+			return ""
+		}
+
+		var ok bool
+		if origin, ok = refererOrigin(referer); !ok {
+			return g.reject(ctx, resultOriginMismatch, reasonInvalidReferer, &g.invalidReferer)
+		}
+	}
+
+	// an opaque origin, "null", is only allowed if it is in the allowlist.
+	if !g.allowed(origin) {
+		return g.reject(ctx, resultOriginMismatch, reasonMismatch, &g.mismatch)
+	}
+	return ""
+}
+
+// Status returns status.
+func (g *OriginGuard) Status() interface{} {
+	return &Status{
+		Missing:        atomic.LoadUint64(&g.missing),
+		Mismatch:       atomic.LoadUint64(&g.mismatch),
+		InvalidReferer: atomic.LoadUint64(&g.invalidReferer),
+	}
+}
+
+// Close closes OriginGuard.
+func (g *OriginGuard) Close() {
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package originguard
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestGuard(yamlConfig string) (*OriginGuard, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	g := kind.CreateInstance(spec).(*OriginGuard)
+	g.Init()
+	return g, nil
+}
+
+func newContext(method, origin, referer string) *context.Context {
+	stdReq, _ := http.NewRequest(method, "https://127.0.0.1/", nil)
+	if origin != "" {
+		stdReq.Header.Set("Origin", origin)
+	}
+	if referer != "" {
+		stdReq.Header.Set("Referer", referer)
+	}
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestOriginGuard(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newTestGuard(`
+kind: OriginGuard
+name: og
+allowedOrigins: ["https://[a-"]
+`)
+	assert.Error(err)
+
+	g, err := newTestGuard(`
+kind: OriginGuard
+name: og
+allowedOrigins: ["https://example.com", "https://*.example.org/"]
+`)
+	assert.NoError(err)
+	assert.Equal(kind, g.Kind())
+	assert.Equal("og", g.Name())
+
+	for _, c := range []struct {
+		method  string
+		origin  string
+		referer string
+		result  string
+	}{
+		{http.MethodGet, "https://evil.com", "", ""},
+		{http.MethodOptions, "https://evil.com", "", ""},
+		{http.MethodPost, "https://example.com", "", ""},
+		{http.MethodPost, "HTTPS://EXAMPLE.COM", "", ""},
+		{http.MethodPut, "https://a.example.org", "", ""},
+		{http.MethodDelete, "https://a.b.example.org", "", ""},
+		{http.MethodPost, "", "https://example.com/form?x=1", ""},
+		{http.MethodPost, "", "", ""},
+		{http.MethodPost, "https://evil.com", "", resultOriginMismatch},
+		{http.MethodPost, "http://example.com", "", resultOriginMismatch},
+		{http.MethodPost, "https://example.com:8443", "", resultOriginMismatch},
+		{http.MethodPost, "https://example.org", "", resultOriginMismatch},
+		{http.MethodPost, "null", "", resultOriginMismatch},
+		{http.MethodPatch, "", "https://evil.com/https://example.com", resultOriginMismatch},
+		{http.MethodPost, "https://evil.com", "https://example.com/", resultOriginMismatch},
+		{http.MethodPost, "", "/relative", resultOriginMismatch},
+	} {
+		ctx := newContext(c.method, c.origin, c.referer)
+		assert.Equal(c.result, g.Handle(ctx), "%+v", c)
+		if c.result != "" {
+			resp := ctx.GetOutputResponse().(*httpprot.Response)
+			assert.Equal(http.StatusForbidden, resp.StatusCode())
+		}
+	}
+
+	status := g.Status().(*Status)
+	assert.Equal(uint64(0), status.Missing)
+	assert.Equal(uint64(7), status.Mismatch)
+	assert.Equal(uint64(1), status.InvalidReferer)
+	assert.Equal(7.0, testutil.ToFloat64(g.rejected.WithLabelValues(reasonMismatch)))
+
+	g, err = newTestGuard(`
+kind: OriginGuard
+name: og-strict
+allowedOrigins: ["https://example.com"]
+missingPolicy: strict
+`)
+	assert.NoError(err)
+	assert.Equal(resultMissingOrigin, g.Handle(newContext(http.MethodPost, "", "")))
+	assert.Equal("", g.Handle(newContext(http.MethodGet, "", "")))
+	assert.Equal(uint64(1), g.Status().(*Status).Missing)
+}
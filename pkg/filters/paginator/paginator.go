@@ -0,0 +1,524 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package paginator implements a filter which normalizes the pagination of
+// heterogeneous backends to a consistent scheme.
+package paginator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/jsonpath"
+)
+
+const (
+	// Kind is the kind of Paginator.
+	Kind = "Paginator"
+
+	resultInvalidPagination = "invalidPagination"
+
+	// SchemePage paginates by page number and page size.
+	SchemePage = "page"
+	// SchemeOffset paginates by offset and limit.
+	SchemeOffset = "offset"
+	// SchemeCursor paginates by an opaque cursor and limit.
+	SchemeCursor = "cursor"
+
+	defaultDefaultSize = 20
+	defaultMaxSize     = 100
+
+	// cursorPrefix is the prefix of the cursors generated by the filter,
+	// which encode offsets for backends not supporting cursors.
+	cursorPrefix = "offset:"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "Paginator normalizes pagination parameters of requests and pagination metadata of responses.",
+	Results:     []string{resultInvalidPagination},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{DefaultSize: defaultDefaultSize, MaxSize: defaultMaxSize}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &Paginator{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// Paginator is the filter Paginator.
+	Paginator struct {
+		spec *Spec
+
+		items      jsonpath.Path
+		total      jsonpath.Path
+		nextCursor jsonpath.Path
+	}
+
+	// Spec is the spec of Paginator.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		Client      *Scheme   `json:"client" jsonschema:"required"`
+		Backend     *Scheme   `json:"backend" jsonschema:"required"`
+		Response    *Response `json:"response" jsonschema:"required"`
+		DefaultSize int       `json:"defaultSize,omitempty" jsonschema:"minimum=1"`
+		MaxSize     int       `json:"maxSize,omitempty" jsonschema:"minimum=1"`
+	}
+
+	// Scheme is a pagination scheme.
+	Scheme struct {
+		Type string `json:"type" jsonschema:"required,enum=page,enum=offset,enum=cursor"`
+		// SizeParam is the query parameter of the page size, default is
+		// 'size' for the page scheme, and 'limit' for the others.
+		SizeParam   string `json:"sizeParam,omitempty"`
+		PageParam   string `json:"pageParam,omitempty"`
+		OffsetParam string `json:"offsetParam,omitempty"`
+		CursorParam string `json:"cursorParam,omitempty"`
+		// ZeroBasedPage is whether page numbers start from 0, they start
+		// from 1 by default.
+		ZeroBasedPage bool `json:"zeroBasedPage,omitempty"`
+	}
+
+	// Response describes where the pagination data are in the backend
+	// responses.
+	Response struct {
+		Items      string `json:"items" jsonschema:"required"`
+		Total      string `json:"total,omitempty"`
+		NextCursor string `json:"nextCursor,omitempty"`
+	}
+
+	// page is the pagination of a request, which is saved in the context
+	// data for the response.
+	page struct {
+		offset int
+		size   int
+		cursor string
+	}
+
+	// Pagination is the normalized pagination metadata of responses.
+	Pagination struct {
+		Page       *int   `json:"page,omitempty"`
+		Offset     *int   `json:"offset,omitempty"`
+		Size       int    `json:"size"`
+		Total      *int   `json:"total,omitempty"`
+		TotalPages *int   `json:"totalPages,omitempty"`
+		NextPage   *int   `json:"nextPage,omitempty"`
+		NextOffset *int   `json:"nextOffset,omitempty"`
+		NextCursor string `json:"nextCursor,omitempty"`
+	}
+
+	// Body is the normalized body of responses.
+	Body struct {
+		Items      json.RawMessage `json:"items"`
+		Pagination *Pagination     `json:"pagination"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if spec.Backend.Type == SchemeCursor && spec.Client.Type != SchemeCursor {
+		return fmt.Errorf("backend cursors can only be used by clients of the cursor scheme")
+	}
+	if spec.Backend.Type == SchemeCursor && spec.Response.NextCursor == "" {
+		return fmt.Errorf("response.nextCursor is required for the backend cursor scheme")
+	}
+	if spec.DefaultSize > 0 && spec.MaxSize > 0 && spec.DefaultSize > spec.MaxSize {
+		return fmt.Errorf("defaultSize is greater than maxSize")
+	}
+	return nil
+}
+
+// Validate validates the response.
+func (r *Response) Validate() error {
+	if _, err := parseItemsPath(r.Items); err != nil {
+		return err
+	}
+	for _, p := range []string{r.Total, r.NextCursor} {
+		if p == "" {
+			continue
+		}
+		if _, err := jsonpath.Parse(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseItemsPath parses the JSONPath of the items, unlike other paths, it
+// could be '$', which means the body is the array of the items.
+func parseItemsPath(path string) (jsonpath.Path, error) {
+	if path == "$" {
+		return jsonpath.Path{}, nil
+	}
+	return jsonpath.Parse(path)
+}
+
+func (s *Scheme) sizeParam() string {
+	if s.SizeParam != "" {
+		return s.SizeParam
+	}
+	if s.Type == SchemePage {
+		return "size"
+	}
+	return "limit"
+}
+
+func (s *Scheme) firstPage() int {
+	if s.ZeroBasedPage {
+		return 0
+	}
+	return 1
+}
+
+func (s *Scheme) pageParam() string {
+	if s.PageParam != "" {
+		return s.PageParam
+	}
+	return "page"
+}
+
+func (s *Scheme) offsetParam() string {
+	if s.OffsetParam != "" {
+		return s.OffsetParam
+	}
+	return "offset"
+}
+
+func (s *Scheme) cursorParam() string {
+	if s.CursorParam != "" {
+		return s.CursorParam
+	}
+	return "cursor"
+}
+
+// Name returns the name of the Paginator filter instance.
+func (p *Paginator) Name() string {
+	return p.spec.Name()
+}
+
+// Kind returns the kind of Paginator.
+func (p *Paginator) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the Paginator
+func (p *Paginator) Spec() filters.Spec {
+	return p.spec
+}
+
+// Init initializes Paginator.
+func (p *Paginator) Init() {
+	p.reload()
+}
+
+// Inherit inherits previous generation of Paginator.
+func (p *Paginator) Inherit(previousGeneration filters.Filter) {
+	p.Init()
+}
+
+func (p *Paginator) reload() {
+	if p.spec.DefaultSize <= 0 {
+		p.spec.DefaultSize = defaultDefaultSize
+	}
+	if p.spec.MaxSize <= 0 {
+		p.spec.MaxSize = defaultMaxSize
+	}
+
+	// the paths have been verified in Validate, so no error here.
+	p.items, _ = parseItemsPath(p.spec.Response.Items)
+	p.total, p.nextCursor = nil, nil
+	if p.spec.Response.Total != "" {
+		p.total, _ = jsonpath.Parse(p.spec.Response.Total)
+	}
+	if p.spec.Response.NextCursor != "" {
+		p.nextCursor, _ = jsonpath.Parse(p.spec.Response.NextCursor)
+	}
+}
+
+func (p *Paginator) dataKey() string {
+	return "PAGINATION_" + p.Name()
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !bytes.HasPrefix(data, []byte(cursorPrefix)) {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	offset, err := strconv.Atoi(string(data[len(cursorPrefix):]))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	return offset, nil
+}
+
+// parseInt parses the integer query parameter, it returns the default
+// value if the parameter is absent.
+func parseInt(query map[string][]string, name string, min, defaultValue int) (int, error) {
+	values := query[name]
+	if len(values) == 0 || values[0] == "" {
+		return defaultValue, nil
+	}
+	v, err := strconv.Atoi(values[0])
+	if err != nil || v < min {
+		return 0, fmt.Errorf("invalid %s %q", name, values[0])
+	}
+	return v, nil
+}
+
+// parseRequest parses the pagination of the request in the client scheme.
+func (p *Paginator) parseRequest(req *httpprot.Request) (*page, error) {
+	client := p.spec.Client
+	query := req.Std().URL.Query()
+
+	size, err := parseInt(query, client.sizeParam(), 1, p.spec.DefaultSize)
+	if err != nil {
+		return nil, err
+	}
+	if size > p.spec.MaxSize {
+		size = p.spec.MaxSize
+	}
+
+	pg := &page{size: size}
+	switch client.Type {
+	case SchemePage:
+		first := client.firstPage()
+		n, err := parseInt(query, client.pageParam(), first, first)
+		if err != nil {
+			return nil, err
+		}
+		pg.offset = (n - first) * size
+	case SchemeOffset:
+		if pg.offset, err = parseInt(query, client.offsetParam(), 0, 0); err != nil {
+			return nil, err
+		}
+	case SchemeCursor:
+		pg.cursor = query.Get(client.cursorParam())
+		if pg.cursor != "" && p.spec.Backend.Type != SchemeCursor {
+			if pg.offset, err = decodeCursor(pg.cursor); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if p.spec.Backend.Type == SchemePage && pg.offset%size != 0 {
+		return nil, fmt.Errorf("offset %d is not a multiple of size %d", pg.offset, size)
+	}
+	return pg, nil
+}
+
+// rewriteRequest rewrites the query of the request to the backend scheme.
+func (p *Paginator) rewriteRequest(req *httpprot.Request, pg *page) {
+	client, backend := p.spec.Client, p.spec.Backend
+	u := req.Std().URL
+	query := u.Query()
+
+	for _, name := range []string{client.sizeParam(), client.pageParam(), client.offsetParam(), client.cursorParam()} {
+		query.Del(name)
+	}
+
+	query.Set(backend.sizeParam(), strconv.Itoa(pg.size))
+	switch backend.Type {
+	case SchemePage:
+		query.Set(backend.pageParam(), strconv.Itoa(pg.offset/pg.size+backend.firstPage()))
+	case SchemeOffset:
+		query.Set(backend.offsetParam(), strconv.Itoa(pg.offset))
+	case SchemeCursor:
+		if pg.cursor != "" {
+			query.Set(backend.cursorParam(), pg.cursor)
+		}
+	}
+
+	u.RawQuery = query.Encode()
+}
+
+// Handle normalizes the pagination of the request or the response.
+func (p *Paginator) Handle(ctx *context.Context) string {
+	if resp := ctx.GetInputResponse(); resp != nil {
+		if pg, ok := ctx.GetData(p.dataKey()).(*page); ok {
+			p.handleResponse(resp.(*httpprot.Response), pg)
+		}
+		return ""
+	}
+
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	pg, err := p.parseRequest(req)
+	if err != nil {
+		return p.reject(ctx, err)
+	}
+	p.rewriteRequest(req, pg)
+	ctx.SetData(p.dataKey(), pg)
+	return ""
+}
+
+func (p *Paginator) reject(ctx *context.Context, err error) string {
+	ctx.AddTag("paginator: " + err.Error())
+
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(http.StatusBadRequest)
+	ctx.SetOutputResponse(resp)
+	return resultInvalidPagination
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func toInt(v interface{}) (int, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := strconv.Atoi(n.String())
+	return i, err == nil && i >= 0
+}
+
+// handleResponse rewrites the pagination metadata of the response to the
+// client scheme, responses which are not paginated are passed through.
+func (p *Paginator) handleResponse(resp *httpprot.Response, pg *page) {
+	if resp.StatusCode() < 200 || resp.StatusCode() > 299 || resp.IsStream() {
+		return
+	}
+	h := resp.HTTPHeader()
+	if ce := h.Get("Content-Encoding"); ce != "" && ce != "identity" {
+		return
+	}
+	if !isJSON(h.Get("Content-Type")) {
+		return
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(resp.RawPayload()))
+	decoder.UseNumber()
+	var body interface{}
+	if err := decoder.Decode(&body); err != nil {
+		return
+	}
+
+	v, ok := p.items.Lookup(body)
+	if !ok {
+		return
+	}
+	items, ok := v.([]interface{})
+	if !ok {
+		return
+	}
+
+	total, hasTotal := -1, false
+	if p.total != nil {
+		if v, ok := p.total.Lookup(body); ok {
+			total, hasTotal = toInt(v)
+		}
+	}
+
+	nextCursor := ""
+	hasNext := len(items) >= pg.size
+	if p.spec.Backend.Type == SchemeCursor {
+		v, _ := p.nextCursor.Lookup(body)
+		nextCursor, _ = v.(string)
+		hasNext = nextCursor != ""
+	} else if hasTotal {
+		hasNext = pg.offset+len(items) < total
+	}
+
+	pagination := p.pagination(pg, total, hasTotal, hasNext, nextCursor)
+	rawItems, err := json.Marshal(items)
+	if err != nil {
+		logger.Errorf("%s: failed to marshal items: %v", p.Name(), err)
+		return
+	}
+	data, err := json.Marshal(&Body{Items: rawItems, Pagination: pagination})
+	if err != nil {
+		logger.Errorf("%s: failed to marshal body: %v", p.Name(), err)
+		return
+	}
+
+	resp.SetPayload(data)
+	if h.Get("Content-Length") != "" {
+		h.Set("Content-Length", strconv.Itoa(len(data)))
+	}
+	// the validators of the backend response don't apply to the
+	// normalized one.
+	h.Del("ETag")
+}
+
+// pagination builds the pagination metadata in the client scheme.
+func (p *Paginator) pagination(pg *page, total int, hasTotal, hasNext bool, nextCursor string) *Pagination {
+	intPtr := func(i int) *int { return &i }
+
+	result := &Pagination{Size: pg.size}
+	if hasTotal {
+		result.Total = intPtr(total)
+	}
+
+	switch p.spec.Client.Type {
+	case SchemePage:
+		n := pg.offset/pg.size + p.spec.Client.firstPage()
+		result.Page = intPtr(n)
+		if hasTotal {
+			result.TotalPages = intPtr((total + pg.size - 1) / pg.size)
+		}
+		if hasNext {
+			result.NextPage = intPtr(n + 1)
+		}
+	case SchemeOffset:
+		result.Offset = intPtr(pg.offset)
+		if hasNext {
+			result.NextOffset = intPtr(pg.offset + pg.size)
+		}
+	case SchemeCursor:
+		if !hasNext {
+			break
+		}
+		if p.spec.Backend.Type == SchemeCursor {
+			result.NextCursor = nextCursor
+		} else {
+			result.NextCursor = encodeCursor(pg.offset + pg.size)
+		}
+	}
+	return result
+}
+
+// Status returns status.
+func (p *Paginator) Status() interface{} {
+	return nil
+}
+
+// Close closes Paginator.
+func (p *Paginator) Close() {
+}
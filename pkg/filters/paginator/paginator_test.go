@@ -0,0 +1,285 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package paginator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestPaginator(yamlConfig string) (*Paginator, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	p := kind.CreateInstance(spec).(*Paginator)
+	p.Init()
+	return p, nil
+}
+
+// roundTrip runs the filter on the request, and then on the response with
+// the body if the request is accepted. It returns the backend query and
+// the client response body.
+func roundTrip(t *testing.T, p *Paginator, url string, body string) (string, string, string) {
+	ctx := context.New(nil)
+	req, _ := httpprot.NewRequest(httptest.NewRequest(http.MethodGet, url, nil))
+	ctx.SetInputRequest(req)
+
+	if result := p.Handle(ctx); result != "" {
+		return "", "", result
+	}
+	query := req.Std().URL.RawQuery
+
+	resp, _ := httpprot.NewResponse(nil)
+	resp.HTTPHeader().Set("Content-Type", "application/json")
+	resp.HTTPHeader().Set("ETag", `"abc"`)
+	resp.SetPayload([]byte(body))
+	ctx.SetOutputResponse(resp)
+	assert.Equal(t, "", p.Handle(ctx))
+	return query, string(resp.RawPayload()), ""
+}
+
+func TestPageToOffset(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := newTestPaginator(`
+kind: Paginator
+name: paginator
+defaultSize: 10
+maxSize: 50
+client:
+  type: page
+backend:
+  type: offset
+  sizeParam: count
+  offsetParam: start
+response:
+  items: $.data.rows
+  total: $.data.total
+`)
+	assert.Nil(err)
+	assert.Equal(kind, p.Kind())
+	assert.Equal("paginator", p.Name())
+	assert.NotNil(p.Spec())
+
+	backend := `{"data": {"rows": [{"id": 21}, {"id": 22}], "total": 22}}`
+	query, body, _ := roundTrip(t, p, "http://localhost/users?page=3&size=10&q=a", backend)
+	assert.Equal("count=10&q=a&start=20", query)
+	assert.JSONEq(`{"items": [{"id": 21}, {"id": 22}], "pagination": {"page": 3, "size": 10, "total": 22, "totalPages": 3}}`, body)
+
+	backend = `{"data": {"rows": [1, 2], "total": 100}}`
+	query, body, _ = roundTrip(t, p, "http://localhost/users", backend)
+	assert.Equal("count=10&start=0", query)
+	assert.JSONEq(`{"items": [1, 2], "pagination": {"page": 1, "size": 10, "total": 100, "totalPages": 10, "nextPage": 2}}`, body)
+
+	// size is limited by maxSize
+	query, _, _ = roundTrip(t, p, "http://localhost/users?page=2&size=1000", backend)
+	assert.Equal("count=50&start=50", query)
+
+	// non-paginated responses pass through.
+	_, body, _ = roundTrip(t, p, "http://localhost/users/1", `{"id": 1}`)
+	assert.Equal(`{"id": 1}`, body)
+
+	for _, url := range []string{"http://localhost/users?page=0", "http://localhost/users?page=a", "http://localhost/users?size=0"} {
+		_, _, result := roundTrip(t, p, url, "")
+		assert.Equal(resultInvalidPagination, result, url)
+	}
+
+	p.Inherit(p)
+	assert.Nil(p.Status())
+	p.Close()
+}
+
+func TestOffsetToPage(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := newTestPaginator(`
+kind: Paginator
+name: paginator
+client:
+  type: offset
+backend:
+  type: page
+  pageParam: p
+  sizeParam: per_page
+  zeroBasedPage: true
+response:
+  items: $
+`)
+	assert.Nil(err)
+
+	query, body, _ := roundTrip(t, p, "http://localhost/users?offset=40&limit=20", `[1, 2]`)
+	assert.Equal("p=2&per_page=20", query)
+	assert.JSONEq(`{"items": [1, 2], "pagination": {"offset": 40, "size": 20}}`, body)
+
+	_, _, result := roundTrip(t, p, "http://localhost/users?offset=30&limit=20", "")
+	assert.Equal(resultInvalidPagination, result)
+}
+
+func TestCursor(t *testing.T) {
+	assert := assert.New(t)
+
+	// cursors generated by the filter for an offset backend.
+	p, err := newTestPaginator(`
+kind: Paginator
+name: paginator
+client:
+  type: cursor
+backend:
+  type: offset
+response:
+  items: $.items
+`)
+	assert.Nil(err)
+
+	query, body, _ := roundTrip(t, p, "http://localhost/users?limit=2", `{"items": [1, 2]}`)
+	assert.Equal("limit=2&offset=0", query)
+	cursor := encodeCursor(2)
+	assert.JSONEq(`{"items": [1, 2], "pagination": {"size": 2, "nextCursor": "`+cursor+`"}}`, body)
+
+	query, body, _ = roundTrip(t, p, "http://localhost/users?limit=2&cursor="+cursor, `{"items": [3]}`)
+	assert.Equal("limit=2&offset=2", query)
+	assert.JSONEq(`{"items": [3], "pagination": {"size": 2}}`, body)
+
+	_, _, result := roundTrip(t, p, "http://localhost/users?cursor=forged", "")
+	assert.Equal(resultInvalidPagination, result)
+
+	// cursors of a cursor backend.
+	p, err = newTestPaginator(`
+kind: Paginator
+name: paginator
+client:
+  type: cursor
+backend:
+  type: cursor
+  cursorParam: after
+  sizeParam: first
+response:
+  items: $.nodes
+  nextCursor: $.pageInfo.endCursor
+`)
+	assert.Nil(err)
+
+	query, body, _ = roundTrip(t, p, "http://localhost/users?cursor=abc&limit=5", `{"nodes": [1], "pageInfo": {"endCursor": "def"}}`)
+	assert.Equal("after=abc&first=5", query)
+	assert.JSONEq(`{"items": [1], "pagination": {"size": 5, "nextCursor": "def"}}`, body)
+
+	_, body, _ = roundTrip(t, p, "http://localhost/users", `{"nodes": [], "pageInfo": {"endCursor": null}}`)
+	assert.JSONEq(`{"items": [], "pagination": {"size": 20}}`, body)
+}
+
+func TestResponsePassThrough(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := newTestPaginator(`
+kind: Paginator
+name: paginator
+client:
+  type: page
+backend:
+  type: offset
+response:
+  items: $.items
+`)
+	assert.Nil(err)
+
+	ctx := context.New(nil)
+	req, _ := httpprot.NewRequest(httptest.NewRequest(http.MethodGet, "http://localhost/users", nil))
+	ctx.SetInputRequest(req)
+	assert.Equal("", p.Handle(ctx))
+
+	for _, c := range []struct {
+		status      int
+		contentType string
+		body        string
+	}{
+		{http.StatusInternalServerError, "application/json", `{"items": []}`},
+		{http.StatusOK, "text/plain", `{"items": []}`},
+		{http.StatusOK, "application/json", `{"items": {}}`},
+		{http.StatusOK, "application/json", `not json`},
+	} {
+		resp, _ := httpprot.NewResponse(nil)
+		resp.SetStatusCode(c.status)
+		resp.HTTPHeader().Set("Content-Type", c.contentType)
+		resp.SetPayload([]byte(c.body))
+		ctx.SetOutputResponse(resp)
+		p.Handle(ctx)
+		assert.Equal(c.body, string(resp.RawPayload()))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, yamlConfig := range []string{`
+kind: Paginator
+name: paginator
+client:
+  type: page
+backend:
+  type: cursor
+response:
+  items: $.items
+  nextCursor: $.next
+`, `
+kind: Paginator
+name: paginator
+client:
+  type: cursor
+backend:
+  type: cursor
+response:
+  items: $.items
+`, `
+kind: Paginator
+name: paginator
+client:
+  type: page
+backend:
+  type: offset
+response:
+  items: items
+`, `
+kind: Paginator
+name: paginator
+defaultSize: 200
+client:
+  type: page
+backend:
+  type: offset
+response:
+  items: $.items
+`} {
+		_, err := newTestPaginator(yamlConfig)
+		assert.NotNil(err, yamlConfig)
+	}
+}
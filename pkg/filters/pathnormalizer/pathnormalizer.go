@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package pathnormalizer implements a filter which normalizes request paths.
+package pathnormalizer
+
+import (
+	"net/http"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	libpn "github.com/megaease/easegress/v2/pkg/util/pathnormalizer"
+)
+
+const (
+	// Kind is the kind of PathNormalizer.
+	Kind = "PathNormalizer"
+
+	resultInvalidPath = "invalidPath"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "PathNormalizer normalizes request paths to prevent path traversal.",
+	Results:     []string{resultInvalidPath},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &PathNormalizer{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// PathNormalizer is the filter PathNormalizer. Note that the filter
+	// runs after routing, please use the pathNormalizer of the HTTPServer
+	// to normalize paths before routing.
+	PathNormalizer struct {
+		spec       *Spec
+		normalizer *libpn.PathNormalizer
+	}
+
+	// Spec is the spec of PathNormalizer.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+		libpn.Spec       `json:",inline"`
+	}
+)
+
+// Name returns the name of the PathNormalizer filter instance.
+func (pn *PathNormalizer) Name() string {
+	return pn.spec.Name()
+}
+
+// Kind returns the kind of PathNormalizer.
+func (pn *PathNormalizer) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the PathNormalizer
+func (pn *PathNormalizer) Spec() filters.Spec {
+	return pn.spec
+}
+
+// Init initializes PathNormalizer.
+func (pn *PathNormalizer) Init() {
+	pn.normalizer = libpn.New(&pn.spec.Spec)
+}
+
+// Inherit inherits previous generation of PathNormalizer.
+func (pn *PathNormalizer) Inherit(previousGeneration filters.Filter) {
+	pn.Init()
+}
+
+// Handle normalizes the path of the request.
+func (pn *PathNormalizer) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	if pn.normalizer.NormalizeRequest(req.Std()) {
+		return ""
+	}
+
+	ctx.AddTag("pathNormalizer: invalid path")
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(http.StatusBadRequest)
+	ctx.SetOutputResponse(resp)
+	return resultInvalidPath
+}
+
+// Status returns status.
+func (pn *PathNormalizer) Status() interface{} {
+	return nil
+}
+
+// Close closes PathNormalizer.
+func (pn *PathNormalizer) Close() {
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pathnormalizer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestPathNormalizer(t *testing.T, yamlConfig string) *PathNormalizer {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	assert.Nil(t, err)
+	pn := kind.CreateInstance(spec).(*PathNormalizer)
+	pn.Init()
+	return pn
+}
+
+func newContext(uri string) *context.Context {
+	stdReq := httptest.NewRequest(http.MethodGet, uri, nil)
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestPathNormalizer(t *testing.T) {
+	assert := assert.New(t)
+
+	pn := newTestPathNormalizer(t, `
+kind: PathNormalizer
+name: normalizer
+`)
+	assert.Equal(kind, pn.Kind())
+	assert.Nil(pn.Status())
+
+	ctx := newContext("/static/%2e%2e/admin")
+	assert.Equal("", pn.Handle(ctx))
+	assert.Equal("/admin", ctx.GetInputRequest().(*httpprot.Request).Path())
+
+	ctx = newContext("/static/%00/admin")
+	assert.Equal(resultInvalidPath, pn.Handle(ctx))
+	assert.Equal(http.StatusBadRequest, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	newPn := kind.CreateInstance(pn.spec).(*PathNormalizer)
+	newPn.Inherit(pn)
+	pn.Close()
+
+	pn = newTestPathNormalizer(t, `
+kind: PathNormalizer
+name: normalizer
+action: Reject
+`)
+	ctx = newContext("/static/%2e%2e/admin")
+	assert.Equal(resultInvalidPath, pn.Handle(ctx))
+	ctx = newContext("//admin")
+	assert.Equal("", pn.Handle(ctx))
+	assert.Equal("/admin", ctx.GetInputRequest().(*httpprot.Request).Path())
+}
@@ -0,0 +1,289 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package prototranscoder implements a filter which transcodes HTTP bodies
+// between JSON and Protobuf.
+package prototranscoder
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of ProtobufTranscoder.
+	Kind = "ProtobufTranscoder"
+
+	resultInvalid = "invalid"
+
+	defaultProtobufContentType = "application/x-protobuf"
+	jsonContentType            = "application/json"
+
+	keyContentType   = "Content-Type"
+	keyContentLength = "Content-Length"
+)
+
+var kind = &filters.Kind{
+	Name:              Kind,
+	Description:       "ProtobufTranscoder transcodes request bodies from JSON to Protobuf and response bodies back",
+	Results:           []string{resultInvalid},
+	RequiresBuffering: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &ProtobufTranscoder{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// ProtobufTranscoder transcodes HTTP bodies between JSON and Protobuf.
+	// When there's no response in the context, it transcodes the JSON
+	// request body to Protobuf, otherwise it transcodes the Protobuf
+	// response body to JSON. So it should be put before the proxy to
+	// transcode requests, and after the proxy (with an alias) to transcode
+	// responses.
+	ProtobufTranscoder struct {
+		spec *Spec
+
+		reqDesc  protoreflect.MessageDescriptor
+		respDesc protoreflect.MessageDescriptor
+	}
+
+	// Spec is the spec of ProtobufTranscoder.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// DescriptorSet is the base64 encoded FileDescriptorSet, which can
+		// be generated by: protoc --include_imports --descriptor_set_out.
+		DescriptorSet   string `json:"descriptorSet" jsonschema:"required"`
+		RequestMessage  string `json:"requestMessage,omitempty"`
+		ResponseMessage string `json:"responseMessage,omitempty"`
+		ContentType     string `json:"contentType,omitempty"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if spec.RequestMessage == "" && spec.ResponseMessage == "" {
+		return fmt.Errorf("at least one of requestMessage and responseMessage is required")
+	}
+	_, _, err := spec.messageDescriptors()
+	return err
+}
+
+func (spec *Spec) messageDescriptors() (req, resp protoreflect.MessageDescriptor, err error) {
+	data, err := base64.StdEncoding.DecodeString(spec.DescriptorSet)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid descriptorSet: %v", err)
+	}
+
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err = proto.Unmarshal(data, fds); err != nil {
+		return nil, nil, fmt.Errorf("invalid descriptorSet: %v", err)
+	}
+
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid descriptorSet: %v", err)
+	}
+
+	find := func(name string) (protoreflect.MessageDescriptor, error) {
+		if name == "" {
+			return nil, nil
+		}
+		d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return nil, fmt.Errorf("message %s: %v", name, err)
+		}
+		md, ok := d.(protoreflect.MessageDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a message", name)
+		}
+		return md, nil
+	}
+
+	if req, err = find(spec.RequestMessage); err != nil {
+		return nil, nil, err
+	}
+	if resp, err = find(spec.ResponseMessage); err != nil {
+		return nil, nil, err
+	}
+	return req, resp, nil
+}
+
+// Name returns the name of the ProtobufTranscoder filter instance.
+func (pt *ProtobufTranscoder) Name() string {
+	return pt.spec.Name()
+}
+
+// Kind returns the kind of ProtobufTranscoder.
+func (pt *ProtobufTranscoder) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the ProtobufTranscoder
+func (pt *ProtobufTranscoder) Spec() filters.Spec {
+	return pt.spec
+}
+
+// Init initializes ProtobufTranscoder.
+func (pt *ProtobufTranscoder) Init() {
+	pt.reload()
+}
+
+// Inherit inherits previous generation of ProtobufTranscoder.
+func (pt *ProtobufTranscoder) Inherit(previousGeneration filters.Filter) {
+	pt.reload()
+}
+
+func (pt *ProtobufTranscoder) reload() {
+	if pt.spec.ContentType == "" {
+		pt.spec.ContentType = defaultProtobufContentType
+	}
+
+	var err error
+	pt.reqDesc, pt.respDesc, err = pt.spec.messageDescriptors()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Handle transcodes the request or the response.
+func (pt *ProtobufTranscoder) Handle(ctx *context.Context) string {
+	if resp := ctx.GetInputResponse(); resp != nil {
+		return pt.handleResponse(resp.(*httpprot.Response))
+	}
+	return pt.handleRequest(ctx.GetInputRequest().(*httpprot.Request))
+}
+
+func (pt *ProtobufTranscoder) handleRequest(req *httpprot.Request) string {
+	if pt.reqDesc == nil {
+		return ""
+	}
+	if req.IsStream() {
+		logger.Warnf("%s: cannot transcode stream request body", pt.Name())
+		return resultInvalid
+	}
+
+	msg := dynamicpb.NewMessage(pt.reqDesc)
+	if err := protojson.Unmarshal(req.RawPayload(), msg); err != nil {
+		logger.Debugf("%s: failed to decode JSON request body: %v", pt.Name(), err)
+		return resultInvalid
+	}
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		logger.Errorf("%s: failed to encode Protobuf request body: %v", pt.Name(), err)
+		return resultInvalid
+	}
+
+	req.SetPayload(data)
+	req.Std().ContentLength = int64(len(data))
+	req.HTTPHeader().Set(keyContentLength, strconv.Itoa(len(data)))
+	req.HTTPHeader().Set(keyContentType, pt.spec.ContentType)
+	return ""
+}
+
+func (pt *ProtobufTranscoder) handleResponse(resp *httpprot.Response) string {
+	if pt.respDesc == nil {
+		return ""
+	}
+	if resp.IsStream() {
+		logger.Warnf("%s: cannot transcode stream response body", pt.Name())
+		return resultInvalid
+	}
+
+	msg := dynamicpb.NewMessage(pt.respDesc)
+	if err := proto.Unmarshal(resp.RawPayload(), msg); err != nil {
+		logger.Debugf("%s: failed to decode Protobuf response body: %v", pt.Name(), err)
+		return resultInvalid
+	}
+	// proto.Unmarshal keeps unknown fields instead of reporting an error,
+	// but they indicate a mismatch between the descriptor and the backend.
+	if hasUnknownFields(msg) {
+		logger.Debugf("%s: unknown fields in Protobuf response body", pt.Name())
+		return resultInvalid
+	}
+
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		logger.Errorf("%s: failed to encode JSON response body: %v", pt.Name(), err)
+		return resultInvalid
+	}
+
+	resp.SetPayload(data)
+	resp.ContentLength = int64(len(data))
+	resp.HTTPHeader().Set(keyContentLength, strconv.Itoa(len(data)))
+	resp.HTTPHeader().Set(keyContentType, jsonContentType)
+	return ""
+}
+
+// hasUnknownFields reports whether the message, or any message nested in
+// it, including the elements of lists and the values of maps, has unknown
+// fields.
+func hasUnknownFields(m protoreflect.Message) bool {
+	if len(m.GetUnknown()) > 0 {
+		return true
+	}
+
+	found := false
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			l := v.List()
+			for i := 0; i < l.Len() && !found; i++ {
+				found = hasUnknownFields(l.Get(i).Message())
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+				found = hasUnknownFields(mv.Message())
+				return !found
+			})
+		case fd.Message() != nil && !fd.IsMap():
+			found = hasUnknownFields(v.Message())
+		}
+		return !found
+	})
+	return found
+}
+
+// Status returns status.
+func (pt *ProtobufTranscoder) Status() interface{} {
+	return nil
+}
+
+// Close closes ProtobufTranscoder.
+func (pt *ProtobufTranscoder) Close() {
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prototranscoder
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func testDescriptorSet() string {
+	fds := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{{
+			Name:    proto.String("greeting.proto"),
+			Package: proto.String("test"),
+			Syntax:  proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Greeting"),
+				Field: []*descriptorpb.FieldDescriptorProto{{
+					Name:     proto.String("name"),
+					JsonName: proto.String("name"),
+					Number:   proto.Int32(1),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				}, {
+					Name:     proto.String("count"),
+					JsonName: proto.String("count"),
+					Number:   proto.Int32(2),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+				}, {
+					Name:     proto.String("parent"),
+					JsonName: proto.String("parent"),
+					Number:   proto.Int32(3),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+					TypeName: proto.String(".test.Greeting"),
+				}, {
+					Name:     proto.String("children"),
+					JsonName: proto.String("children"),
+					Number:   proto.Int32(4),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+					TypeName: proto.String(".test.Greeting"),
+				}, {
+					Name:     proto.String("labels"),
+					JsonName: proto.String("labels"),
+					Number:   proto.Int32(5),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+					TypeName: proto.String(".test.Greeting.LabelsEntry"),
+				}},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name: proto.String("LabelsEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{{
+						Name:     proto.String("key"),
+						JsonName: proto.String("key"),
+						Number:   proto.Int32(1),
+						Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+					}, {
+						Name:     proto.String("value"),
+						JsonName: proto.String("value"),
+						Number:   proto.Int32(2),
+						Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+						TypeName: proto.String(".test.Greeting"),
+					}},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+			}},
+		}},
+	}
+	data, _ := proto.Marshal(fds)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func newTestTranscoder(t *testing.T, spec *Spec) *ProtobufTranscoder {
+	spec.BaseSpec.MetaSpec.Kind = Kind
+	spec.BaseSpec.MetaSpec.Name = "transcoder"
+	s, err := filters.NewSpec(nil, "pipeline-demo", spec)
+	assert.Nil(t, err)
+	pt := kind.CreateInstance(s).(*ProtobufTranscoder)
+	pt.Init()
+	return pt
+}
+
+func newContext(t *testing.T, body string) *context.Context {
+	stdReq, err := http.NewRequest(http.MethodPost, "http://127.0.0.1/greet", strings.NewReader(body))
+	assert.Nil(t, err)
+	req, err := httpprot.NewRequest(stdReq)
+	assert.Nil(t, err)
+	assert.Nil(t, req.FetchPayload(1024*1024))
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &Spec{DescriptorSet: testDescriptorSet()}
+	assert.NotNil(spec.Validate())
+
+	spec.RequestMessage = "test.Unknown"
+	assert.NotNil(spec.Validate())
+
+	spec.RequestMessage = "test.Greeting"
+	assert.Nil(spec.Validate())
+
+	spec.DescriptorSet = "not base64!"
+	assert.NotNil(spec.Validate())
+}
+
+func TestTranscodeRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	pt := newTestTranscoder(t, &Spec{
+		DescriptorSet:   testDescriptorSet(),
+		RequestMessage:  "test.Greeting",
+		ResponseMessage: "test.Greeting",
+	})
+	assert.Equal(kind, pt.Kind())
+	assert.Nil(pt.Status())
+	defer pt.Close()
+
+	ctx := newContext(t, `{"name": "eg", "count": 3}`)
+	assert.Equal("", pt.Handle(ctx))
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	assert.Equal(defaultProtobufContentType, req.HTTPHeader().Get(keyContentType))
+	assert.Equal(int64(len(req.RawPayload())), req.Std().ContentLength)
+
+	want := protowire.AppendTag(nil, 1, protowire.BytesType)
+	want = protowire.AppendString(want, "eg")
+	want = protowire.AppendTag(want, 2, protowire.VarintType)
+	want = protowire.AppendVarint(want, 3)
+	// the field order of dynamic messages is not stable, so compare the
+	// decoded messages.
+	wantMsg, gotMsg := dynamicpb.NewMessage(pt.reqDesc), dynamicpb.NewMessage(pt.reqDesc)
+	assert.Nil(proto.Unmarshal(want, wantMsg))
+	assert.Nil(proto.Unmarshal(req.RawPayload(), gotMsg))
+	assert.True(proto.Equal(wantMsg, gotMsg))
+
+	ctx = newContext(t, `{"name": "eg", "unknown": 1}`)
+	assert.Equal(resultInvalid, pt.Handle(ctx))
+
+	ctx = newContext(t, `{"count": "abc"}`)
+	assert.Equal(resultInvalid, pt.Handle(ctx))
+}
+
+func TestTranscodeResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	pt := newTestTranscoder(t, &Spec{
+		DescriptorSet:   testDescriptorSet(),
+		ResponseMessage: "test.Greeting",
+	})
+
+	body := protowire.AppendTag(nil, 1, protowire.BytesType)
+	body = protowire.AppendString(body, "eg")
+
+	ctx := newContext(t, "")
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetPayload(body)
+	ctx.SetInputResponse(resp)
+	assert.Equal("", pt.Handle(ctx))
+	assert.JSONEq(`{"name": "eg"}`, string(resp.RawPayload()))
+	assert.Equal(jsonContentType, resp.HTTPHeader().Get(keyContentType))
+	assert.Equal(int64(len(resp.RawPayload())), resp.ContentLength)
+
+	// a nested message without unknown fields.
+	valid := protowire.AppendTag(nil, 3, protowire.BytesType)
+	valid = protowire.AppendBytes(valid, body)
+	resp.SetPayload(valid)
+	assert.Equal("", pt.Handle(ctx))
+	assert.JSONEq(`{"parent": {"name": "eg"}}`, string(resp.RawPayload()))
+
+	unknown := protowire.AppendTag(nil, 9, protowire.VarintType)
+	unknown = protowire.AppendVarint(unknown, 1)
+	resp.SetPayload(append(body, unknown...))
+	assert.Equal(resultInvalid, pt.Handle(ctx))
+
+	// unknown fields in nested messages, list elements and map values.
+	entry := protowire.AppendTag(nil, 1, protowire.BytesType)
+	entry = protowire.AppendString(entry, "a")
+	entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+	entry = protowire.AppendBytes(entry, unknown)
+	for _, nested := range [][]byte{
+		protowire.AppendBytes(protowire.AppendTag(nil, 3, protowire.BytesType), unknown),
+		protowire.AppendBytes(protowire.AppendTag(nil, 4, protowire.BytesType), unknown),
+		protowire.AppendBytes(protowire.AppendTag(nil, 5, protowire.BytesType), entry),
+	} {
+		resp.SetPayload(nested)
+		assert.Equal(resultInvalid, pt.Handle(ctx))
+	}
+
+	// requests pass through if no request message is configured.
+	ctx = newContext(t, "not json")
+	assert.Equal("", pt.Handle(ctx))
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols"
+)
+
+const (
+	defaultDynamicWeightInterval    = 10 * time.Second
+	defaultDynamicWeightThreshold   = 0.5
+	defaultDynamicWeightMinRequests = 10
+	defaultDynamicWeightStep        = 0.1
+	defaultDynamicWeightMinFactor   = 0.1
+
+	// weights are scaled when adjusted, so that they keep enough
+	// precision as integers.
+	dynamicWeightScale = 100
+)
+
+// DynamicWeightSpec is the spec of dynamic weight adjustment, it reduces the
+// effective weight of servers with elevated error rates, and restores it as
+// they recover.
+type DynamicWeightSpec struct {
+	// Interval is the interval to evaluate error rates and adjust weights.
+	Interval string `json:"interval,omitempty" jsonschema:"format=duration"`
+	// ErrorRateThreshold is the error rate above which the weight of a
+	// server is reduced.
+	ErrorRateThreshold float64 `json:"errorRateThreshold,omitempty" jsonschema:"minimum=0,maximum=1"`
+	// MinRequests is the minimum number of requests in an interval to
+	// evaluate the error rate of a server.
+	MinRequests int `json:"minRequests,omitempty" jsonschema:"minimum=1"`
+	// Step is the amount by which the weight factor of a server is
+	// reduced or restored in each interval.
+	Step float64 `json:"step,omitempty" jsonschema:"minimum=0,maximum=1"`
+	// MinFactor is the lower bound of the weight factor, it avoids the full
+	// starvation of a server.
+	MinFactor float64 `json:"minFactor,omitempty" jsonschema:"minimum=0,maximum=1"`
+	// FailureCodes are the response codes counted as errors, all 5xx
+	// codes are counted if it is empty.
+	FailureCodes []int `json:"failureCodes,omitempty" jsonschema:"uniqueItems=true"`
+}
+
+// DynamicWeightStatus is the dynamic weight status of a server.
+type DynamicWeightStatus struct {
+	Weight          int     `json:"weight"`
+	Factor          float64 `json:"factor"`
+	EffectiveWeight float64 `json:"effectiveWeight"`
+	ErrorRate       float64 `json:"errorRate"`
+}
+
+type dynamicWeightStat struct {
+	requests int64
+	failures int64
+
+	// fields below are protected by the mutex of dynamicWeighter.
+	factor    float64
+	errorRate float64
+}
+
+type dynamicWeighter struct {
+	spec         *DynamicWeightSpec
+	interval     time.Duration
+	failureCodes map[int]bool
+
+	mutex sync.Mutex
+	// stats is not modified after creation, so it can be read without lock.
+	stats map[*Server]*dynamicWeightStat
+}
+
+// Validate validates DynamicWeightSpec.
+func (spec *DynamicWeightSpec) Validate() error {
+	if spec.Interval != "" {
+		if d, err := time.ParseDuration(spec.Interval); err != nil {
+			return err
+		} else if d <= 0 {
+			return fmt.Errorf("interval must be positive")
+		}
+	}
+	if spec.MinFactor > 0 && spec.Step > 0 && spec.Step > 1-spec.MinFactor {
+		return fmt.Errorf("step should not be greater than 1 - minFactor")
+	}
+	return nil
+}
+
+func newDynamicWeighter(spec *DynamicWeightSpec, servers []*Server) *dynamicWeighter {
+	dw := &dynamicWeighter{
+		spec:         spec,
+		interval:     defaultDynamicWeightInterval,
+		failureCodes: map[int]bool{},
+		stats:        make(map[*Server]*dynamicWeightStat, len(servers)),
+	}
+
+	if d, err := time.ParseDuration(spec.Interval); err == nil && d > 0 {
+		dw.interval = d
+	}
+	if spec.ErrorRateThreshold <= 0 {
+		spec.ErrorRateThreshold = defaultDynamicWeightThreshold
+	}
+	if spec.MinRequests <= 0 {
+		spec.MinRequests = defaultDynamicWeightMinRequests
+	}
+	if spec.Step <= 0 {
+		spec.Step = defaultDynamicWeightStep
+	}
+	if spec.MinFactor <= 0 {
+		spec.MinFactor = defaultDynamicWeightMinFactor
+	}
+	for _, code := range spec.FailureCodes {
+		dw.failureCodes[code] = true
+	}
+
+	for _, svr := range servers {
+		dw.stats[svr] = &dynamicWeightStat{factor: 1}
+	}
+	return dw
+}
+
+func (dw *dynamicWeighter) isFailure(code int) bool {
+	if len(dw.failureCodes) == 0 {
+		return code >= 500
+	}
+	return dw.failureCodes[code]
+}
+
+// record records the response of a server.
+func (dw *dynamicWeighter) record(svr *Server, resp protocols.Response) {
+	stat := dw.stats[svr]
+	if stat == nil {
+		return
+	}
+
+	atomic.AddInt64(&stat.requests, 1)
+	if r, ok := resp.(interface{ StatusCode() int }); ok && dw.isFailure(r.StatusCode()) {
+		atomic.AddInt64(&stat.failures, 1)
+	}
+}
+
+// adjust evaluates the error rates of the servers in the last interval and
+// adjusts their weight factors, it returns whether any factor is changed.
+func (dw *dynamicWeighter) adjust() bool {
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+
+	changed := false
+	for svr, stat := range dw.stats {
+		requests := atomic.SwapInt64(&stat.requests, 0)
+		failures := atomic.SwapInt64(&stat.failures, 0)
+
+		factor := stat.factor
+		stat.errorRate = 0
+		if requests > 0 {
+			stat.errorRate = float64(failures) / float64(requests)
+		}
+
+		// a server without enough requests is considered recovering, as
+		// its traffic may be reduced by a small factor.
+		if requests >= int64(dw.spec.MinRequests) && stat.errorRate > dw.spec.ErrorRateThreshold {
+			factor = math.Max(factor-dw.spec.Step, dw.spec.MinFactor)
+		} else {
+			factor = math.Min(factor+dw.spec.Step, 1)
+		}
+
+		if factor != stat.factor {
+			logger.Debugf("weight factor of server %s: %.2f -> %.2f", svr.ID(), stat.factor, factor)
+			stat.factor = factor
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// serverGroup creates a server group with the adjusted weights.
+func (dw *dynamicWeighter) serverGroup(servers []*Server) *ServerGroup {
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+
+	sg := &ServerGroup{Servers: servers, Weights: make([]int, len(servers))}
+	for i, svr := range servers {
+		factor := 1.0
+		if stat := dw.stats[svr]; stat != nil {
+			factor = stat.factor
+		}
+		w := int(math.Round(float64(svr.Weight*dynamicWeightScale) * factor))
+		if w < 1 && svr.Weight > 0 {
+			w = 1
+		}
+		sg.Weights[i] = w
+		sg.TotalWeight += w
+	}
+	return sg
+}
+
+func (dw *dynamicWeighter) status() map[string]*DynamicWeightStatus {
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+
+	s := make(map[string]*DynamicWeightStatus, len(dw.stats))
+	for svr, stat := range dw.stats {
+		s[svr.ID()] = &DynamicWeightStatus{
+			Weight:          svr.Weight,
+			Factor:          stat.factor,
+			EffectiveWeight: float64(svr.Weight) * stat.factor,
+			ErrorRate:       stat.errorRate,
+		}
+	}
+	return s
+}
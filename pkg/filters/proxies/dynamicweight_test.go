@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func newResponse(code int) *httpprot.Response {
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(code)
+	return resp
+}
+
+func TestDynamicWeight(t *testing.T) {
+	assert := assert.New(t)
+
+	servers := prepareServers(2)
+	spec := &LoadBalanceSpec{
+		Policy: LoadBalancePolicyWeightedRandom,
+		DynamicWeight: &DynamicWeightSpec{
+			Interval:  "1h",
+			Step:      0.5,
+			MinFactor: 0.2,
+		},
+	}
+	lb := NewGeneralLoadBalancer(spec, servers)
+	lb.Init(nil, nil, nil)
+	defer lb.Close()
+
+	failAll := func() {
+		for i := 0; i < 10; i++ {
+			lb.ReturnServer(servers[0], nil, newResponse(503))
+			lb.ReturnServer(servers[1], nil, newResponse(200))
+		}
+	}
+
+	// server 0 fails, its weight is reduced, but bounded by minFactor.
+	failAll()
+	lb.adjustWeights()
+	status := lb.DynamicWeights()
+	assert.Equal(0.5, status[servers[0].ID()].Factor)
+	assert.Equal(1.0, status[servers[0].ID()].ErrorRate)
+	assert.Equal(1.0, status[servers[1].ID()].Factor)
+	assert.Equal([]int{50, 200}, lb.healthyServers.Load().Weights)
+
+	failAll()
+	lb.adjustWeights()
+	assert.Equal(0.2, lb.DynamicWeights()[servers[0].ID()].Factor)
+	sg := lb.healthyServers.Load()
+	assert.Equal([]int{20, 200}, sg.Weights)
+	assert.Equal(220, sg.TotalWeight)
+
+	counter := [2]int{}
+	for i := 0; i < 10000; i++ {
+		svr := lb.ChooseServer(nil)
+		counter[svr.Weight-1]++
+	}
+	assert.Greater(counter[0], 0)
+	assert.Greater(counter[1], counter[0]*5)
+
+	// not enough requests, server 0 recovers.
+	lb.ReturnServer(servers[0], nil, newResponse(503))
+	lb.adjustWeights()
+	assert.Equal(0.7, lb.DynamicWeights()[servers[0].ID()].Factor)
+	lb.adjustWeights()
+	assert.Equal(1.0, lb.DynamicWeights()[servers[0].ID()].Factor)
+	assert.Equal([]int{100, 200}, lb.healthyServers.Load().Weights)
+}
+
+func TestDynamicWeightFailureCodes(t *testing.T) {
+	assert := assert.New(t)
+
+	servers := prepareServers(1)
+	dw := newDynamicWeighter(&DynamicWeightSpec{FailureCodes: []int{429}}, servers)
+	assert.Equal(defaultDynamicWeightInterval, dw.interval)
+
+	for i := 0; i < 10; i++ {
+		dw.record(servers[0], newResponse(500))
+	}
+	assert.False(dw.adjust())
+
+	for i := 0; i < 10; i++ {
+		dw.record(servers[0], newResponse(429))
+	}
+	assert.True(dw.adjust())
+	assert.Equal(0.9, dw.status()[servers[0].ID()].Factor)
+
+	// unknown servers are ignored.
+	dw.record(&Server{URL: "unknown"}, newResponse(429))
+	lb := NewGeneralLoadBalancer(&LoadBalanceSpec{}, servers)
+	assert.Nil(lb.DynamicWeights())
+}
+
+func TestDynamicWeightSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &DynamicWeightSpec{Interval: "-1s"}
+	assert.NotNil(spec.Validate())
+
+	spec = &DynamicWeightSpec{Step: 0.5, MinFactor: 0.8}
+	assert.NotNil(spec.Validate())
+
+	spec = &DynamicWeightSpec{Interval: "5s", Step: 0.2, MinFactor: 0.1}
+	assert.Nil(spec.Validate())
+
+	sps := &ServerPoolBaseSpec{
+		Servers:     prepareServers(2),
+		LoadBalance: &LoadBalanceSpec{DynamicWeight: spec},
+	}
+	assert.NotNil(sps.Validate())
+	sps.LoadBalance.Policy = LoadBalancePolicyWeightedRandom
+	assert.Nil(sps.Validate())
+}
@@ -24,6 +24,7 @@ import (
 	"net"
 	"net/url"
 	"sync"
+	"time"
 
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters/proxies"
@@ -214,10 +215,16 @@ func (sp *ServerPool) handle(ctx *context.Context) string {
 		spCtx.stdw.SetTrailer(spCtx.resp.RawTrailer().GetMD())
 	}()
 
+	// a timeout set by the previous filters overrides the one of the proxy.
+	timeout := sp.proxy.timeout
+	if d, _ := ctx.GetData(proxies.TimeoutKey).(time.Duration); d > 0 {
+		timeout = d
+	}
+
 	handler := func(stdctx stdcontext.Context) error {
-		if sp.proxy.timeout > 0 {
+		if timeout > 0 {
 			var cancel stdcontext.CancelFunc
-			stdctx, cancel = stdcontext.WithTimeout(stdctx, sp.proxy.timeout)
+			stdctx, cancel = stdcontext.WithTimeout(stdctx, timeout)
 			defer cancel()
 		}
 
@@ -284,6 +291,9 @@ func (sp *ServerPool) doHandle(ctx stdcontext.Context, spCtx *serverPoolContext)
 		logger.Debugf("%s: no available server", sp.Name)
 		return serverPoolError{status.New(codes.InvalidArgument, "no available server"), resultClientError}
 	}
+	svr.IncInflight()
+	defer svr.DecInflight()
+
 	target := sp.getTarget(svr.URL)
 	lb.ReturnServer(svr, spCtx.req, spCtx.resp)
 	if target == "" {
@@ -56,6 +56,7 @@ var (
 			resultServerError,
 			resultShortCircuited,
 		},
+		RequestOnly: true,
 		DefaultSpec: func() filters.Spec {
 			return &Spec{
 				MaxIdleConnsPerHost: 1024,
@@ -78,6 +79,7 @@ var (
 var (
 	_ filters.Filter      = (*Proxy)(nil)
 	_ filters.Resiliencer = (*Proxy)(nil)
+	_ filters.Recreator   = (*Proxy)(nil)
 )
 
 func init() {
@@ -202,6 +204,18 @@ func (p *Proxy) Inherit(previousGeneration filters.Filter) {
 	p.reload()
 }
 
+// AlwaysRecreate implements filters.Recreator. A proxy discovering servers
+// from service registries is recreated on every reload to list the
+// servers again.
+func (p *Proxy) AlwaysRecreate() bool {
+	for _, spec := range p.spec.Pools {
+		if spec.UseServiceRegistry() {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Proxy) reload() {
 	for _, spec := range p.spec.Pools {
 		name := ""
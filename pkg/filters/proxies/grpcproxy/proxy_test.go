@@ -208,3 +208,11 @@ name: grpcforwardproxy
 	assert.Nil(t, p.Status())
 	p.Close()
 }
+
+func TestAlwaysRecreate(t *testing.T) {
+	spec := &Spec{Pools: []*ServerPoolSpec{{BaseServerPoolSpec: proxies.ServerPoolBaseSpec{Servers: []*Server{{URL: "127.0.0.1:9095"}}}}}}
+	assert.False(t, (&Proxy{spec: spec}).AlwaysRecreate())
+
+	spec.Pools = append(spec.Pools, &ServerPoolSpec{BaseServerPoolSpec: proxies.ServerPoolBaseSpec{ServiceRegistry: "registry", ServiceName: "service"}})
+	assert.True(t, (&Proxy{spec: spec}).AlwaysRecreate())
+}
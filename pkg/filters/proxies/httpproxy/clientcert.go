@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+)
+
+// ClientCertDataKey is the key of the client certificate selected for a
+// request in the context data, the Proxy presents it to the backend
+// instead of the certificate in mtls.
+const ClientCertDataKey = "PROXY_CLIENT_CERT"
+
+// ClientCert is a client certificate selected for a request.
+type ClientCert struct {
+	Name        string
+	Certificate tls.Certificate
+
+	// key identifies the certificate in the client cache of pools.
+	key string
+}
+
+// NewClientCert creates a ClientCert from the PEM encoded certificate and
+// private key.
+func NewClientCert(name string, certPEM, keyPEM []byte) (*ClientCert, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	sum := sha256.Sum256(cert.Certificate[0])
+	return &ClientCert{
+		Name:        name,
+		Certificate: cert,
+		key:         name + "#" + hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// httpClientFor returns the client of the request, which presents the
+// client certificate selected for the request if there is one.
+//
+// Every certificate has its own client, so that connections established
+// with one certificate are never reused by requests selecting another one.
+// Clients are cached by the name and the fingerprint of the certificate,
+// so they survive the reloading of the selecting filter.
+func (sp *ServerPool) httpClientFor(ctx *context.Context) *http.Client {
+	cc, _ := ctx.GetData(ClientCertDataKey).(*ClientCert)
+	if cc == nil {
+		return sp.httpClient()
+	}
+
+	if c, ok := sp.certClients.Load(cc.key); ok {
+		return c.(*http.Client)
+	}
+
+	// the TLS config has been verified in Validate, so no error here.
+	tlsCfg, _ := sp.proxy.tlsConfig()
+	tlsCfg.Certificates = []tls.Certificate{cc.Certificate}
+	c, _ := sp.certClients.LoadOrStore(cc.key, HTTPClient(tlsCfg, sp.clientSpec, 0))
+	return c.(*http.Client)
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/option"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/stretchr/testify/assert"
+)
+
+func genClientCert(t *testing.T, name string) *ClientCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	cc, err := NewClientCert(name,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	assert.Nil(t, err)
+	return cc
+}
+
+func TestNewClientCert(t *testing.T) {
+	_, err := NewClientCert("bad", []byte("abc"), []byte("abc"))
+	assert.NotNil(t, err)
+}
+
+func TestHTTPClientFor(t *testing.T) {
+	assert := assert.New(t)
+
+	p := kind.CreateInstance(kind.DefaultSpec()).(*Proxy)
+	p.super = supervisor.NewMock(option.New(), nil, nil,
+		nil, false, nil, nil)
+	sp := NewServerPool(p, &ServerPoolSpec{}, "test")
+	defer sp.Close()
+
+	ctx := context.New(nil)
+	assert.Same(sp.httpClient(), sp.httpClientFor(ctx))
+
+	cc1 := genClientCert(t, "cert1")
+	ctx.SetData(ClientCertDataKey, cc1)
+	c1 := sp.httpClientFor(ctx)
+	assert.NotSame(sp.httpClient(), c1)
+	assert.Same(c1, sp.httpClientFor(ctx))
+
+	// the same certificate selected by another filter instance.
+	ctx = context.New(nil)
+	ctx.SetData(ClientCertDataKey, &ClientCert{Name: cc1.Name, Certificate: cc1.Certificate, key: cc1.key})
+	assert.Same(c1, sp.httpClientFor(ctx))
+
+	ctx.SetData(ClientCertDataKey, genClientCert(t, "cert2"))
+	assert.NotSame(c1, sp.httpClientFor(ctx))
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	stdcontext "context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// formats of the deadline headers.
+const (
+	// DeadlineFormatGRPC is the format of grpc-timeout, like 100m.
+	DeadlineFormatGRPC = "grpc"
+	// DeadlineFormatDuration is the format of Go durations, like 1.5s.
+	DeadlineFormatDuration = "duration"
+	// DeadlineFormatMilliseconds is an integer of milliseconds, like 1500.
+	DeadlineFormatMilliseconds = "milliseconds"
+	// DeadlineFormatSeconds is a decimal of seconds, like 1.5.
+	DeadlineFormatSeconds = "seconds"
+)
+
+type (
+	// DeadlinePropagationSpec is the spec to honor the deadline of the
+	// client. The budget of the client is read from the headers, and the
+	// request to the backend, including all its retries, is cancelled
+	// when the budget runs out, so backends don't waste work on requests
+	// abandoned by the client. The headers sent to the backend are
+	// updated to the remaining budget.
+	DeadlinePropagationSpec struct {
+		Headers []*DeadlineHeaderSpec `json:"headers" jsonschema:"required,minItems=1"`
+		// MaxTimeout caps the budget of the client, it is useful if the
+		// pool has no timeout.
+		MaxTimeout string `json:"maxTimeout,omitempty" jsonschema:"format=duration"`
+	}
+
+	// DeadlineHeaderSpec is a header carrying the budget of the client.
+	DeadlineHeaderSpec struct {
+		Name   string `json:"name" jsonschema:"required"`
+		Format string `json:"format" jsonschema:"required,enum=grpc,enum=duration,enum=milliseconds,enum=seconds"`
+	}
+
+	// DeadlineStatus is the status of the deadline propagation.
+	DeadlineStatus struct {
+		// Propagated is the number of requests with a client deadline.
+		Propagated uint64 `json:"propagated"`
+		// Invalid is the number of requests with an invalid deadline
+		// header, the header is ignored.
+		Invalid uint64 `json:"invalid"`
+		// Exceeded is the number of requests cancelled by the deadline
+		// of the client.
+		Exceeded uint64 `json:"exceeded"`
+	}
+
+	deadlinePropagator struct {
+		spec       *DeadlinePropagationSpec
+		maxTimeout time.Duration
+
+		propagated uint64
+		invalid    uint64
+		exceeded   uint64
+	}
+)
+
+// Validate validates the DeadlinePropagationSpec.
+func (spec *DeadlinePropagationSpec) Validate() error {
+	for _, h := range spec.Headers {
+		switch h.Format {
+		case DeadlineFormatGRPC, DeadlineFormatDuration, DeadlineFormatMilliseconds, DeadlineFormatSeconds:
+		default:
+			return fmt.Errorf("invalid deadline format %q of header %s", h.Format, h.Name)
+		}
+	}
+	if spec.MaxTimeout != "" {
+		if d, err := time.ParseDuration(spec.MaxTimeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid maxTimeout %q", spec.MaxTimeout)
+		}
+	}
+	return nil
+}
+
+func newDeadlinePropagator(spec *DeadlinePropagationSpec) *deadlinePropagator {
+	dp := &deadlinePropagator{spec: spec}
+	if spec.MaxTimeout != "" {
+		dp.maxTimeout, _ = time.ParseDuration(spec.MaxTimeout)
+	}
+	return dp
+}
+
+// parseGRPCTimeout parses the value of grpc-timeout, which is a positive
+// integer of at most 8 digits followed by a unit.
+func parseGRPCTimeout(value string) (time.Duration, error) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, fmt.Errorf("invalid grpc timeout %q", value)
+	}
+
+	var unit time.Duration
+	switch value[len(value)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, fmt.Errorf("invalid grpc timeout %q", value)
+	}
+
+	n, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid grpc timeout %q", value)
+	}
+	if n > uint64(math.MaxInt64/unit) {
+		return math.MaxInt64, nil
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// formatGRPCTimeout formats d in the format of grpc-timeout, with the most
+// precise unit which fits in 8 digits.
+func formatGRPCTimeout(d time.Duration) string {
+	const maxValue = 1e8 - 1
+	units := []struct {
+		unit   time.Duration
+		suffix string
+	}{
+		{time.Nanosecond, "n"},
+		{time.Microsecond, "u"},
+		{time.Millisecond, "m"},
+		{time.Second, "S"},
+		{time.Minute, "M"},
+		{time.Hour, "H"},
+	}
+	for _, u := range units {
+		// round up, so the deadline is never shorter than the budget.
+		n := (d + u.unit - 1) / u.unit
+		if n <= maxValue {
+			return strconv.FormatInt(int64(n), 10) + u.suffix
+		}
+	}
+	return strconv.Itoa(maxValue) + "H"
+}
+
+func parseDeadline(value, format string) (time.Duration, error) {
+	var d time.Duration
+	switch format {
+	case DeadlineFormatGRPC:
+		return parseGRPCTimeout(value)
+	case DeadlineFormatDuration:
+		var err error
+		if d, err = time.ParseDuration(value); err != nil {
+			return 0, err
+		}
+	case DeadlineFormatMilliseconds:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n > math.MaxInt64/int64(time.Millisecond) {
+			return 0, fmt.Errorf("invalid milliseconds %q", value)
+		}
+		d = time.Duration(n) * time.Millisecond
+	case DeadlineFormatSeconds:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(f) || f > math.MaxInt64/float64(time.Second) {
+			return 0, fmt.Errorf("invalid seconds %q", value)
+		}
+		d = time.Duration(f * float64(time.Second))
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative deadline %q", value)
+	}
+	return d, nil
+}
+
+func formatDeadline(d time.Duration, format string) string {
+	switch format {
+	case DeadlineFormatGRPC:
+		return formatGRPCTimeout(d)
+	case DeadlineFormatMilliseconds:
+		return strconv.FormatInt(int64((d+time.Millisecond-1)/time.Millisecond), 10)
+	case DeadlineFormatSeconds:
+		return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+	default:
+		return d.String()
+	}
+}
+
+// budget returns the budget of the client, which is the minimum of the
+// headers and MaxTimeout. It returns false if no header is present.
+func (dp *deadlinePropagator) budget(header http.Header) (time.Duration, bool) {
+	budget, found, invalid := time.Duration(0), false, false
+	for _, h := range dp.spec.Headers {
+		value := header.Get(h.Name)
+		if value == "" {
+			continue
+		}
+		d, err := parseDeadline(value, h.Format)
+		if err != nil {
+			invalid = true
+			continue
+		}
+		if !found || d < budget {
+			budget, found = d, true
+		}
+	}
+
+	if invalid {
+		atomic.AddUint64(&dp.invalid, 1)
+	}
+	if !found {
+		return 0, false
+	}
+	if dp.maxTimeout > 0 && budget > dp.maxTimeout {
+		budget = dp.maxTimeout
+	}
+	atomic.AddUint64(&dp.propagated, 1)
+	return budget, true
+}
+
+// withDeadline returns a context which is cancelled when the budget of the
+// client runs out, start is the time the budget starts.
+func (dp *deadlinePropagator) withDeadline(ctx stdcontext.Context, header http.Header, start time.Time) (stdcontext.Context, stdcontext.CancelFunc, bool) {
+	budget, ok := dp.budget(header)
+	if !ok {
+		return ctx, func() {}, false
+	}
+	ctx, cancel := stdcontext.WithDeadline(ctx, start.Add(budget))
+	return ctx, cancel, true
+}
+
+// propagate updates the deadline headers of the request to the backend to
+// the remaining budget of ctx, which is the minimum of the budget of the
+// client and the timeout of the pool.
+func (dp *deadlinePropagator) propagate(ctx stdcontext.Context, header http.Header, now time.Time) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+	remaining := deadline.Sub(now)
+	if remaining < 0 {
+		remaining = 0
+	}
+	for _, h := range dp.spec.Headers {
+		if header.Get(h.Name) != "" {
+			header.Set(h.Name, formatDeadline(remaining, h.Format))
+		}
+	}
+}
+
+func (dp *deadlinePropagator) status() *DeadlineStatus {
+	return &DeadlineStatus{
+		Propagated: atomic.LoadUint64(&dp.propagated),
+		Invalid:    atomic.LoadUint64(&dp.invalid),
+		Exceeded:   atomic.LoadUint64(&dp.exceeded),
+	}
+}
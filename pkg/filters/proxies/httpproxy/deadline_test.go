@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/v2/pkg/resilience"
+)
+
+func TestDeadlineFormats(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		value  string
+		format string
+		want   time.Duration
+	}{
+		{"100m", DeadlineFormatGRPC, 100 * time.Millisecond},
+		{"2S", DeadlineFormatGRPC, 2 * time.Second},
+		{"99999999H", DeadlineFormatGRPC, math.MaxInt64},
+		{"1.5s", DeadlineFormatDuration, 1500 * time.Millisecond},
+		{"1500", DeadlineFormatMilliseconds, 1500 * time.Millisecond},
+		{"1.5", DeadlineFormatSeconds, 1500 * time.Millisecond},
+	}
+	for _, c := range cases {
+		d, err := parseDeadline(c.value, c.format)
+		assert.NoError(err)
+		assert.Equal(c.want, d, c.value)
+	}
+
+	for _, c := range []struct{ value, format string }{
+		{"100", DeadlineFormatGRPC},
+		{"123456789m", DeadlineFormatGRPC},
+		{"-1m", DeadlineFormatGRPC},
+		{"10x", DeadlineFormatGRPC},
+		{"-1s", DeadlineFormatDuration},
+		{"1.5", DeadlineFormatMilliseconds},
+		{"NaN", DeadlineFormatSeconds},
+	} {
+		_, err := parseDeadline(c.value, c.format)
+		assert.Error(err, c.value)
+	}
+
+	assert.Equal("1500000u", formatDeadline(1500*time.Millisecond, DeadlineFormatGRPC))
+	assert.Equal("100000S", formatDeadline(100000*time.Second, DeadlineFormatGRPC))
+	assert.Equal("2", formatDeadline(1001*time.Microsecond, DeadlineFormatMilliseconds))
+	assert.Equal("1.5", formatDeadline(1500*time.Millisecond, DeadlineFormatSeconds))
+	assert.Equal("1.5s", formatDeadline(1500*time.Millisecond, DeadlineFormatDuration))
+
+	spec := &DeadlinePropagationSpec{Headers: []*DeadlineHeaderSpec{{Name: "X-Timeout", Format: "minutes"}}}
+	assert.Error(spec.Validate())
+	spec = &DeadlinePropagationSpec{Headers: []*DeadlineHeaderSpec{{Name: "X-Timeout", Format: "seconds"}}, MaxTimeout: "0s"}
+	assert.Error(spec.Validate())
+}
+
+func TestDeadlinePropagation(t *testing.T) {
+	assert := assert.New(t)
+
+	var header http.Header
+	var deadline time.Time
+	var hasDeadline bool
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		header = r.Header
+		deadline, hasDeadline = r.Context().Deadline()
+		// the backend is slow if X-Slow is set.
+		if r.Header.Get("X-Slow") != "" {
+			<-r.Context().Done()
+			return nil, r.Context().Err()
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("ok")),
+		}, nil
+	}
+
+	proxy := newTestProxy(`
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+  timeout: 10s
+  deadlinePropagation:
+    maxTimeout: 1m
+    headers:
+    - name: grpc-timeout
+      format: grpc
+    - name: X-Request-Timeout
+      format: milliseconds
+`, assert)
+	defer proxy.Close()
+	proxy.InjectResiliencePolicy(make(map[string]resilience.Policy))
+
+	handle := func(h map[string]string) string {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+		for k, v := range h {
+			stdr.Header.Set(k, v)
+		}
+		return proxy.Handle(getCtx(stdr))
+	}
+
+	// no client deadline, only the timeout of the pool.
+	start := time.Now()
+	assert.Equal("", handle(nil))
+	assert.True(hasDeadline)
+	assert.WithinDuration(start.Add(10*time.Second), deadline, time.Second)
+	assert.Equal("", header.Get("grpc-timeout"))
+
+	// the minimum of the client deadlines, and the headers are updated to
+	// the remaining budget.
+	start = time.Now()
+	assert.Equal("", handle(map[string]string{"grpc-timeout": "2S", "X-Request-Timeout": "3000"}))
+	assert.WithinDuration(start.Add(2*time.Second), deadline, 500*time.Millisecond)
+	d, err := parseDeadline(header.Get("grpc-timeout"), DeadlineFormatGRPC)
+	assert.NoError(err)
+	assert.True(d > time.Second && d <= 2*time.Second, d)
+	d, err = parseDeadline(header.Get("X-Request-Timeout"), DeadlineFormatMilliseconds)
+	assert.NoError(err)
+	assert.True(d > time.Second && d <= 2*time.Second, d)
+
+	// the timeout of the pool is smaller.
+	start = time.Now()
+	assert.Equal("", handle(map[string]string{"X-Request-Timeout": "3600000"}))
+	assert.WithinDuration(start.Add(10*time.Second), deadline, time.Second)
+
+	// invalid headers are ignored.
+	assert.Equal("", handle(map[string]string{"grpc-timeout": "forever"}))
+
+	// the request is cancelled when the budget runs out.
+	start = time.Now()
+	assert.Equal(resultTimeout, handle(map[string]string{"X-Request-Timeout": "50", "X-Slow": "1"}))
+	assert.Less(time.Since(start), 5*time.Second)
+
+	status := proxy.mainPool.status().Deadline
+	assert.Equal(uint64(3), status.Propagated)
+	assert.Equal(uint64(1), status.Invalid)
+	assert.Equal(uint64(1), status.Exceeded)
+}
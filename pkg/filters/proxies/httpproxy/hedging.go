@@ -0,0 +1,323 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	stdcontext "context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/util/fasttime"
+)
+
+const (
+	defaultHedgingDelay = 100 * time.Millisecond
+
+	// the number of latency samples kept to compute the adaptive delay,
+	// and the delay is recomputed every hedgingUpdateInterval samples.
+	hedgingSampleSize     = 1000
+	hedgingUpdateInterval = 100
+
+	// the max number of attempts to choose a server different from the
+	// primary one.
+	hedgingChooseAttempts = 3
+)
+
+// HedgingSpec is the spec of request hedging. If the primary request is
+// not responded within the delay, a hedged request is sent to another
+// server, the first response wins and the other request is cancelled.
+type HedgingSpec struct {
+	// Delay is the static delay, or the initial delay before there are
+	// enough samples if Percentile is set.
+	Delay string `json:"delay,omitempty" jsonschema:"format=duration"`
+	// Percentile makes the delay adaptive, which is the percentile of the
+	// observed latencies.
+	Percentile float64 `json:"percentile,omitempty" jsonschema:"minimum=0,maximum=100"`
+	// Methods are the methods to hedge, only safe methods should be
+	// hedged, which defaults to GET, HEAD and OPTIONS.
+	Methods []string `json:"methods,omitempty" jsonschema:"uniqueItems=true"`
+}
+
+// HedgingStatus is the status of request hedging.
+type HedgingStatus struct {
+	Requests  uint64 `json:"requests"`
+	Hedged    uint64 `json:"hedged"`
+	HedgeWins uint64 `json:"hedgeWins"`
+	Delay     string `json:"delay"`
+}
+
+type hedger struct {
+	spec    *HedgingSpec
+	methods map[string]bool
+
+	// delay is in nanoseconds.
+	delay int64
+
+	mutex   sync.Mutex
+	samples []time.Duration
+	next    int
+	count   int
+
+	requests  uint64
+	hedged    uint64
+	hedgeWins uint64
+}
+
+type hedgingResult struct {
+	index  int
+	svr    *Server
+	req    *http.Request
+	resp   *http.Response
+	err    error
+	cancel stdcontext.CancelFunc
+}
+
+// cancelOnClose cancels the context of the request when the body of the
+// response is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel stdcontext.CancelFunc
+}
+
+// Validate validates HedgingSpec.
+func (spec *HedgingSpec) Validate() error {
+	if spec.Delay != "" {
+		d, err := time.ParseDuration(spec.Delay)
+		if err != nil {
+			return fmt.Errorf("invalid delay %s: %v", spec.Delay, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("delay must be positive")
+		}
+	}
+	if spec.Percentile < 0 || spec.Percentile >= 100 {
+		return fmt.Errorf("percentile must be in [0, 100)")
+	}
+	return nil
+}
+
+func newHedger(spec *HedgingSpec) *hedger {
+	h := &hedger{
+		spec:    spec,
+		methods: map[string]bool{},
+		delay:   int64(defaultHedgingDelay),
+	}
+
+	if spec.Delay != "" {
+		d, _ := time.ParseDuration(spec.Delay)
+		h.delay = int64(d)
+	}
+
+	methods := spec.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+	}
+	for _, m := range methods {
+		h.methods[strings.ToUpper(m)] = true
+	}
+
+	if spec.Percentile > 0 {
+		h.samples = make([]time.Duration, hedgingSampleSize)
+	}
+	return h
+}
+
+// hedgeable returns whether the request could be hedged, stream requests
+// are never hedged because their body can't be sent twice.
+func (h *hedger) hedgeable(spCtx *serverPoolContext) bool {
+	return h.methods[spCtx.req.Method()] && !spCtx.req.IsStream()
+}
+
+func (h *hedger) currentDelay() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.delay))
+}
+
+// observe records the latency of a request, and updates the delay if the
+// delay is adaptive.
+func (h *hedger) observe(latency time.Duration) {
+	if h.samples == nil {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.samples[h.next] = latency
+	h.next = (h.next + 1) % len(h.samples)
+	h.count++
+	if h.count%hedgingUpdateInterval != 0 {
+		return
+	}
+
+	n := h.count
+	if n > len(h.samples) {
+		n = len(h.samples)
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, h.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(float64(n) * h.spec.Percentile / 100)
+	if idx >= n {
+		idx = n - 1
+	}
+	atomic.StoreInt64(&h.delay, int64(sorted[idx]))
+}
+
+func (h *hedger) status() *HedgingStatus {
+	return &HedgingStatus{
+		Requests:  atomic.LoadUint64(&h.requests),
+		Hedged:    atomic.LoadUint64(&h.hedged),
+		HedgeWins: atomic.LoadUint64(&h.hedgeWins),
+		Delay:     h.currentDelay().String(),
+	}
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// chooseHedgeServer chooses a server other than the primary one, it
+// returns nil if there's no such server.
+func (sp *ServerPool) chooseHedgeServer(spCtx *serverPoolContext, primary *Server) *Server {
+	for i := 0; i < hedgingChooseAttempts; i++ {
+		svr := sp.LoadBalancer().ChooseServer(spCtx.req)
+		if svr != nil && svr != primary {
+			return svr
+		}
+	}
+	return nil
+}
+
+// sendHedged sends the request to the primary server, and sends a hedged
+// request to another server if the primary one doesn't respond within the
+// delay. It returns the server of the winner, and spCtx.stdReq is set to
+// the request of the winner, or the last failed request if both failed.
+// The error is a serverPoolError if the request can't be prepared.
+//
+// The primary server is counted as in flight by the caller, and every
+// request is counted until it fails, loses, or the body of its response
+// is closed.
+//
+// The primary request carries the httpstat trace in ctx, the hedged
+// request is derived from baseCtx to avoid racing on the trace.
+func (sp *ServerPool) sendHedged(ctx, baseCtx stdcontext.Context, spCtx *serverPoolContext, primary *Server) (*Server, *http.Response, error) {
+	h := sp.hedger
+	atomic.AddUint64(&h.requests, 1)
+
+	results := make(chan *hedgingResult, 2)
+	var cancels []stdcontext.CancelFunc
+
+	send := func(parent stdcontext.Context, svr *Server) error {
+		reqCtx, cancel := stdcontext.WithCancel(parent)
+		if err := spCtx.prepareRequest(sp, svr, reqCtx, false); err != nil {
+			cancel()
+			svr.DecInflight()
+			return err
+		}
+
+		r := &hedgingResult{index: len(cancels), svr: svr, req: spCtx.stdReq, cancel: cancel}
+		cancels = append(cancels, cancel)
+		client := sp.httpClientFor(spCtx.Context)
+		start := fasttime.Now()
+		go func() {
+			r.resp, r.err = fnSendRequest(r.req, client)
+			if r.err == nil {
+				h.observe(fasttime.Since(start))
+			}
+			results <- r
+		}()
+		return nil
+	}
+
+	if err := send(ctx, primary); err != nil {
+		logger.Errorf("%s: failed to prepare request: %v", sp.Name, err)
+		return primary, nil, serverPoolError{http.StatusInternalServerError, resultInternalError}
+	}
+
+	timer := time.NewTimer(h.currentDelay())
+	defer timer.Stop()
+
+	var last *hedgingResult
+	for pending := 1; pending > 0; {
+		select {
+		case <-timer.C:
+			svr := sp.chooseHedgeServer(spCtx, primary)
+			if svr == nil {
+				continue
+			}
+			// a hedged request never waits for the backend limiter.
+			if sp.limiter != nil && !sp.limiter.Allow() {
+				continue
+			}
+			svr.IncInflight()
+			if err := send(baseCtx, svr); err != nil {
+				logger.Errorf("%s: failed to prepare hedged request: %v", sp.Name, err)
+				continue
+			}
+			pending++
+			atomic.AddUint64(&h.hedged, 1)
+
+		case r := <-results:
+			pending--
+			if r.err != nil {
+				r.cancel()
+				r.svr.DecInflight()
+				last = r
+				continue
+			}
+
+			if r.index > 0 {
+				atomic.AddUint64(&h.hedgeWins, 1)
+			}
+
+			// cancel the loser, and close its response in case it has
+			// been received.
+			for i, cancel := range cancels {
+				if i != r.index {
+					cancel()
+				}
+			}
+			for ; pending > 0; pending-- {
+				go func() {
+					loser := <-results
+					if loser.resp != nil {
+						loser.resp.Body.Close()
+					}
+					loser.svr.DecInflight()
+				}()
+			}
+
+			r.resp.Body = r.svr.InflightBody(&cancelOnClose{ReadCloser: r.resp.Body, cancel: r.cancel})
+			spCtx.stdReq = r.req
+			return r.svr, r.resp, nil
+		}
+	}
+
+	spCtx.stdReq = last.req
+	return last.svr, nil, last.err
+}
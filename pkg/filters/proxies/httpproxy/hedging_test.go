@@ -0,0 +1,152 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/resilience"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHedging(t *testing.T) {
+	assert := assert.New(t)
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	var cancelled int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+			w.Write([]byte("slow"))
+		case <-r.Context().Done():
+			atomic.AddInt32(&cancelled, 1)
+		}
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	yamlConfig := fmt.Sprintf(`
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: %s
+  - url: %s
+  loadBalance:
+    policy: roundRobin
+  hedging:
+    delay: 20ms
+`, slow.URL, fast.URL)
+	proxy := newTestProxy(yamlConfig, assert)
+	defer proxy.Close()
+	proxy.InjectResiliencePolicy(make(map[string]resilience.Policy))
+
+	// every request is served by the fast server, either directly or by
+	// the hedged request.
+	for i := 0; i < 4; i++ {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+		ctx := getCtx(stdr)
+		start := time.Now()
+		assert.Equal("", proxy.Handle(ctx))
+		assert.Less(time.Since(start), 500*time.Millisecond)
+
+		resp := ctx.GetOutputResponse().(*httpprot.Response)
+		body, err := io.ReadAll(resp.GetPayload())
+		assert.NoError(err)
+		assert.Equal("fast", string(body))
+		resp.Close()
+	}
+
+	status := proxy.Status().(*Status).MainPool.Hedging
+	assert.Equal(uint64(4), status.Requests)
+	assert.GreaterOrEqual(status.Hedged, uint64(1))
+	assert.Equal(status.Hedged, status.HedgeWins)
+	assert.Equal("20ms", status.Delay)
+	assert.Eventually(func() bool {
+		return atomic.LoadInt32(&cancelled) == int32(status.Hedged)
+	}, time.Second, 10*time.Millisecond)
+
+	// both the primary and the hedged requests are counted, and released
+	// after they complete.
+	assert.Eventually(func() bool {
+		for _, n := range proxy.Status().(*Status).MainPool.Inflights {
+			if n != 0 {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	// unsafe methods are not hedged.
+	stdr, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/", strings.NewReader("body"))
+	assert.False(proxy.mainPool.hedger.hedgeable(&serverPoolContext{req: getCtx(stdr).GetInputRequest().(*httpprot.Request)}))
+}
+
+func TestHedgingAdaptiveDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	h := newHedger(&HedgingSpec{Percentile: 90})
+	assert.Equal(defaultHedgingDelay, h.currentDelay())
+
+	for i := 1; i < hedgingUpdateInterval; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	assert.Equal(defaultHedgingDelay, h.currentDelay())
+	h.observe(100 * time.Millisecond)
+	assert.Equal(91*time.Millisecond, h.currentDelay())
+
+	// only the latest samples are used.
+	for i := 0; i < hedgingSampleSize; i++ {
+		h.observe(10 * time.Millisecond)
+	}
+	assert.Equal(10*time.Millisecond, h.currentDelay())
+
+	// the delay is static without percentile.
+	h = newHedger(&HedgingSpec{Delay: "50ms", Methods: []string{"post"}})
+	for i := 0; i < hedgingUpdateInterval; i++ {
+		h.observe(time.Millisecond)
+	}
+	assert.Equal(50*time.Millisecond, h.currentDelay())
+	assert.True(h.methods[http.MethodPost])
+	assert.False(h.methods[http.MethodGet])
+
+	for _, spec := range []*HedgingSpec{
+		{Delay: "abc"},
+		{Delay: "-1s"},
+		{Percentile: 100},
+		{Percentile: -1},
+	} {
+		assert.Error(spec.Validate())
+	}
+	assert.NoError((&HedgingSpec{Delay: "10ms", Percentile: 99}).Validate())
+}
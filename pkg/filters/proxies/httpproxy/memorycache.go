@@ -20,6 +20,7 @@ package httpproxy
 import (
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	cache "github.com/patrickmn/go-cache"
@@ -48,6 +49,10 @@ type (
 		MaxEntryBytes uint32   `json:"maxEntryBytes" jsonschema:"required,minimum=1"`
 		Codes         []int    `json:"codes" jsonschema:"required,minItems=1,uniqueItems=true,format=httpcode-array"`
 		Methods       []string `json:"methods" jsonschema:"required,minItems=1,uniqueItems=true,format=httpmethod-array"`
+		// Store is the name of the cache store, entries of all caches of
+		// the same store could be purged by the name, e.g. by the
+		// CacheInvalidator filter.
+		Store string `json:"store,omitempty"`
 	}
 
 	// CacheEntry is an item of the memory cache.
@@ -55,9 +60,34 @@ type (
 		StatusCode int
 		Header     http.Header
 		Body       []byte
+
+		path string
 	}
 )
 
+// cacheStores are the memory caches of the named stores.
+var cacheStores = struct {
+	sync.Mutex
+	caches map[string]map[*MemoryCache]struct{}
+}{caches: map[string]map[*MemoryCache]struct{}{}}
+
+// PurgeCacheStore purges the entries whose paths match from all memory
+// caches of the store, it returns the number of purged entries.
+func PurgeCacheStore(store string, match func(path string) bool) int {
+	cacheStores.Lock()
+	caches := make([]*MemoryCache, 0, len(cacheStores.caches[store]))
+	for mc := range cacheStores.caches[store] {
+		caches = append(caches, mc)
+	}
+	cacheStores.Unlock()
+
+	n := 0
+	for _, mc := range caches {
+		n += mc.Purge(match)
+	}
+	return n
+}
+
 // NewMemoryCache creates a MemoryCache.
 func NewMemoryCache(spec *MemoryCacheSpec) *MemoryCache {
 	expiration, err := time.ParseDuration(spec.Expiration)
@@ -72,10 +102,47 @@ func NewMemoryCache(spec *MemoryCacheSpec) *MemoryCache {
 	}
 	cache := cache.New(expiration, cleanupInterval)
 
-	return &MemoryCache{
+	mc := &MemoryCache{
 		spec:  spec,
 		cache: cache,
 	}
+
+	if spec.Store != "" {
+		cacheStores.Lock()
+		if cacheStores.caches[spec.Store] == nil {
+			cacheStores.caches[spec.Store] = map[*MemoryCache]struct{}{}
+		}
+		cacheStores.caches[spec.Store][mc] = struct{}{}
+		cacheStores.Unlock()
+	}
+	return mc
+}
+
+// Purge purges the entries whose paths match, it returns the number of
+// purged entries.
+func (mc *MemoryCache) Purge(match func(path string) bool) int {
+	n := 0
+	for key, item := range mc.cache.Items() {
+		if match(item.Object.(*CacheEntry).path) {
+			mc.cache.Delete(key)
+			n++
+		}
+	}
+	return n
+}
+
+// Close closes the MemoryCache.
+func (mc *MemoryCache) Close() {
+	if mc.spec.Store == "" {
+		return
+	}
+
+	cacheStores.Lock()
+	defer cacheStores.Unlock()
+	delete(cacheStores.caches[mc.spec.Store], mc)
+	if len(cacheStores.caches[mc.spec.Store]) == 0 {
+		delete(cacheStores.caches, mc.spec.Store)
+	}
 }
 
 func (mc *MemoryCache) key(req *httpprot.Request) string {
@@ -166,6 +233,7 @@ func (mc *MemoryCache) Store(req *httpprot.Request, resp *httpprot.Response) {
 		StatusCode: resp.StatusCode(),
 		Header:     resp.HTTPHeader().Clone(),
 		Body:       resp.RawPayload(),
+		path:       req.Path(),
 	}
 	mc.cache.SetDefault(key, entry)
 }
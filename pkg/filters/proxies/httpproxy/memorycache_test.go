@@ -77,3 +77,39 @@ func TestMemoryCache(t *testing.T) {
 	mc.Store(req, resp)
 	assert.NotNil(mc.Load(req))
 }
+
+func TestMemoryCachePurge(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &MemoryCacheSpec{
+		Expiration:    "1m",
+		MaxEntryBytes: 10,
+		Methods:       []string{http.MethodGet},
+		Codes:         []int{http.StatusOK},
+		Store:         "users",
+	}
+	mc1, mc2 := NewMemoryCache(spec), NewMemoryCache(spec)
+
+	store := func(mc *MemoryCache, url string) *httpprot.Request {
+		stdr, _ := http.NewRequest(http.MethodGet, url, nil)
+		req, _ := httpprot.NewRequest(stdr)
+		resp, _ := httpprot.NewResponse(nil)
+		resp.SetPayload([]byte("data"))
+		mc.Store(req, resp)
+		return req
+	}
+	req1 := store(mc1, "http://megaease.com/users/1")
+	req2 := store(mc2, "http://megaease.com/users/1")
+	req3 := store(mc2, "http://megaease.com/users/2")
+
+	match := func(path string) bool { return path == "/users/1" }
+	assert.Equal(0, PurgeCacheStore("orders", match))
+	assert.Equal(2, PurgeCacheStore("users", match))
+	assert.Nil(mc1.Load(req1))
+	assert.Nil(mc2.Load(req2))
+	assert.NotNil(mc2.Load(req3))
+
+	mc2.Close()
+	assert.Equal(0, PurgeCacheStore("users", func(string) bool { return true }))
+	mc1.Close()
+}
@@ -19,11 +19,15 @@ package httpproxy
 
 import (
 	stdcontext "context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/textproto"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	gohttpstat "github.com/tcnksm/go-httpstat"
@@ -34,7 +38,9 @@ import (
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot/httpstat"
 	"github.com/megaease/easegress/v2/pkg/resilience"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
 	"github.com/megaease/easegress/v2/pkg/tracing"
+	libcb "github.com/megaease/easegress/v2/pkg/util/circuitbreaker"
 	"github.com/megaease/easegress/v2/pkg/util/fasttime"
 	"github.com/megaease/easegress/v2/pkg/util/prometheushelper"
 	"github.com/megaease/easegress/v2/pkg/util/readers"
@@ -172,6 +178,10 @@ func (spCtx *serverPoolContext) prepareRequest(pool *ServerPool, svr *Server, ct
 		stdr.Header.Add("Host", svrHost)
 	}
 
+	if pool.deadline != nil {
+		pool.deadline.propagate(ctx, stdr.Header, fasttime.Now())
+	}
+
 	if spCtx.span != nil {
 		spCtx.span.InjectHTTP(stdr)
 	}
@@ -190,6 +200,21 @@ type ServerPool struct {
 	failureCodes map[int]struct{}
 
 	timeout               time.Duration
+	client                *http.Client
+	clientSpec            *HTTPClientSpec
+	certClients           sync.Map // cert key -> *http.Client
+	timeouts              TimeoutStatus
+	hedger                *hedger
+	ramp                  *ramp
+	deadline              *deadlinePropagator
+	responseBuffer        *responseBuffer
+	responseHeaderLimiter *responseHeaderLimiter
+	limiter               *supervisor.BackendLimiter
+	limiterTimeout        time.Duration
+	rateLimited           uint64
+	retryBudget           *supervisor.RetryBudget
+	retriesSuppressed     uint64
+	upstreamResets        UpstreamResetStatus
 	retryWrapper          resilience.Wrapper
 	circuitBreakerWrapper resilience.Wrapper
 
@@ -212,6 +237,51 @@ type ServerPoolSpec struct {
 	MemoryCache          *MemoryCacheSpec      `json:"memoryCache,omitempty"`
 	HealthCheck          *ProxyHealthCheckSpec `json:"healthCheck,omitempty"`
 
+	// DialTimeout, TLSHandshakeTimeout and ResponseHeaderTimeout are the
+	// timeouts of the phases of a request, while Timeout is the timeout of
+	// the whole request.
+	DialTimeout           string `json:"dialTimeout,omitempty" jsonschema:"format=duration"`
+	TLSHandshakeTimeout   string `json:"tlsHandshakeTimeout,omitempty" jsonschema:"format=duration"`
+	ResponseHeaderTimeout string `json:"responseHeaderTimeout,omitempty" jsonschema:"format=duration"`
+
+	// BackendProtocol is the HTTP version used to the backend, which could
+	// be different from the version used by the client.
+	BackendProtocol string `json:"backendProtocol,omitempty" jsonschema:"enum=,enum=http1,enum=http2,enum=auto"`
+
+	// Hedging sends a hedged request to another server if the primary
+	// one is slow, to reduce the tail latency.
+	Hedging *HedgingSpec `json:"hedging,omitempty"`
+
+	// RateLimit limits the rate of outbound requests to the backend, the
+	// limiter is shared by all pools with the same backend identity.
+	RateLimit *supervisor.BackendLimiterSpec `json:"rateLimit,omitempty"`
+
+	// RetryBudget limits the retries of RetryPolicy to a ratio of the
+	// requests, the budget is shared by all pools with the same backend
+	// identity.
+	RetryBudget *supervisor.RetryBudgetSpec `json:"retryBudget,omitempty"`
+
+	// Ramp ramps up the traffic to the pool gradually by increasing the
+	// permil of the filter, it is only for candidate pools.
+	Ramp *RampSpec `json:"ramp,omitempty"`
+
+	// UpstreamReset controls the behavior when the backend resets the
+	// connection in the middle of the response.
+	UpstreamReset *UpstreamResetSpec `json:"upstreamReset,omitempty"`
+
+	// DeadlinePropagation honors the deadline of the client, the request
+	// to the backend is cancelled when the budget of the client runs out.
+	DeadlinePropagation *DeadlinePropagationSpec `json:"deadlinePropagation,omitempty"`
+
+	// ResponseBuffering buffers the responses which are otherwise
+	// streamed, to release the backend connections before sending the
+	// responses to slow clients.
+	ResponseBuffering *ResponseBufferingSpec `json:"responseBuffering,omitempty"`
+
+	// ResponseHeaderLimits limits the headers of the backend responses,
+	// the oversized headers are stripped or the request fails.
+	ResponseHeaderLimits *ResponseHeaderLimitsSpec `json:"responseHeaderLimits,omitempty"`
+
 	// FailureCodes would be 5xx if it isn't assigned any value.
 	FailureCodes []int `json:"failureCodes,omitempty" jsonschema:"uniqueItems=true"`
 }
@@ -220,9 +290,46 @@ func (spec *ServerPoolSpec) Validate() error {
 	if err := spec.BaseServerPoolSpec.Validate(); err != nil {
 		return err
 	}
+	if err := validateBackendProtocol(spec); err != nil {
+		return err
+	}
 	if spec.ServiceName != "" && spec.HealthCheck != nil {
 		return fmt.Errorf("serviceName and healthCheck can't be set at the same time")
 	}
+	if spec.RateLimit != nil {
+		if err := spec.RateLimit.Validate(); err != nil {
+			return err
+		}
+	}
+	if spec.RetryBudget != nil {
+		if spec.RetryPolicy == "" {
+			return fmt.Errorf("retryBudget requires a retryPolicy")
+		}
+		if err := spec.RetryBudget.Validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Ramp != nil {
+		if spec.Filter == nil {
+			return fmt.Errorf("ramp requires a filter")
+		}
+		if p := spec.Filter.Policy; p == "" || p == "general" {
+			return fmt.Errorf("ramp requires a filter with a probability policy")
+		}
+		if err := spec.Ramp.Validate(); err != nil {
+			return err
+		}
+	}
+	if spec.DeadlinePropagation != nil {
+		if err := spec.DeadlinePropagation.Validate(); err != nil {
+			return err
+		}
+	}
+	if spec.ResponseHeaderLimits != nil {
+		if err := spec.ResponseHeaderLimits.Validate(); err != nil {
+			return err
+		}
+	}
 	if spec.HealthCheck != nil {
 		return spec.HealthCheck.Validate()
 	}
@@ -231,7 +338,28 @@ func (spec *ServerPoolSpec) Validate() error {
 
 // ServerPoolStatus is the status of Pool.
 type ServerPoolStatus struct {
-	Stat *httpstat.Status `json:"stat"`
+	Stat              *httpstat.Status                        `json:"stat"`
+	CircuitBreaker    string                                  `json:"circuitBreaker,omitempty"`
+	DynamicWeights    map[string]*proxies.DynamicWeightStatus `json:"dynamicWeights,omitempty"`
+	Inflights         map[string]int64                        `json:"inflights,omitempty"`
+	Timeouts          *TimeoutStatus                          `json:"timeouts,omitempty"`
+	Hedging           *HedgingStatus                          `json:"hedging,omitempty"`
+	RateLimited       uint64                                  `json:"rateLimited,omitempty"`
+	RetryBudget       *supervisor.RetryBudgetStatus           `json:"retryBudget,omitempty"`
+	RetriesSuppressed uint64                                  `json:"retriesSuppressed,omitempty"`
+	Ramp              *RampStatus                             `json:"ramp,omitempty"`
+	UpstreamResets    *UpstreamResetStatus                    `json:"upstreamResets,omitempty"`
+	Deadline          *DeadlineStatus                         `json:"deadline,omitempty"`
+	ResponseBuffering *ResponseBufferingStatus                `json:"responseBuffering,omitempty"`
+	ResponseHeaders   *ResponseHeaderLimitStatus              `json:"responseHeaders,omitempty"`
+}
+
+// TimeoutStatus is the number of timeouts of each phase of requests.
+type TimeoutStatus struct {
+	Dial           uint64 `json:"dial"`
+	TLSHandshake   uint64 `json:"tlsHandshake"`
+	ResponseHeader uint64 `json:"responseHeader"`
+	Request        uint64 `json:"request"`
 }
 
 // NewServerPool creates a new server pool according to spec.
@@ -255,6 +383,11 @@ func NewServerPool(proxy *Proxy, spec *ServerPoolSpec, name string) *ServerPool
 
 	sp.BaseServerPool.Init(sp, proxy.super, name, &spec.BaseServerPoolSpec)
 
+	if spec.Ramp != nil && spec.Filter != nil {
+		sp.ramp = newRamp(sp, spec.Ramp, spec.Filter)
+		sp.filter = sp.ramp
+	}
+
 	if spec.MemoryCache != nil {
 		sp.memoryCache = NewMemoryCache(spec.MemoryCache)
 	}
@@ -263,6 +396,43 @@ func NewServerPool(proxy *Proxy, spec *ServerPoolSpec, name string) *ServerPool
 		sp.timeout, _ = time.ParseDuration(spec.Timeout)
 	}
 
+	// create a dedicated client only if the pool has its own timeouts or
+	// backend protocol, the client of the proxy is used otherwise.
+	sp.clientSpec = proxy.httpClientSpec()
+	if spec.DialTimeout != "" || spec.TLSHandshakeTimeout != "" || spec.ResponseHeaderTimeout != "" ||
+		(spec.BackendProtocol != "" && spec.BackendProtocol != BackendProtocolHTTP1) {
+		sp.clientSpec.DialTimeout, _ = time.ParseDuration(spec.DialTimeout)
+		sp.clientSpec.TLSHandshakeTimeout, _ = time.ParseDuration(spec.TLSHandshakeTimeout)
+		sp.clientSpec.ResponseHeaderTimeout, _ = time.ParseDuration(spec.ResponseHeaderTimeout)
+		sp.clientSpec.BackendProtocol = spec.BackendProtocol
+		sp.client = HTTPClient(tlsConfig, sp.clientSpec, 0)
+	}
+
+	if spec.Hedging != nil {
+		sp.hedger = newHedger(spec.Hedging)
+	}
+
+	if spec.DeadlinePropagation != nil {
+		sp.deadline = newDeadlinePropagator(spec.DeadlinePropagation)
+	}
+
+	if spec.ResponseBuffering != nil {
+		sp.responseBuffer = newResponseBuffer(spec.ResponseBuffering)
+	}
+
+	if spec.ResponseHeaderLimits != nil {
+		sp.responseHeaderLimiter = newResponseHeaderLimiter(spec.ResponseHeaderLimits)
+	}
+
+	if spec.RateLimit != nil {
+		sp.limiter = proxy.super.BackendLimiter(spec.RateLimit)
+		sp.limiterTimeout = spec.RateLimit.TimeoutDuration()
+	}
+
+	if spec.RetryBudget != nil {
+		sp.retryBudget = proxy.super.RetryBudget(spec.RetryBudget)
+	}
+
 	sp.failureCodes = map[int]struct{}{}
 	for _, code := range spec.FailureCodes {
 		sp.failureCodes[code] = struct{}{}
@@ -275,15 +445,124 @@ func NewServerPool(proxy *Proxy, spec *ServerPoolSpec, name string) *ServerPool
 // CreateLoadBalancer creates a load balancer according to spec.
 func (sp *ServerPool) CreateLoadBalancer(spec *LoadBalanceSpec, servers []*Server) LoadBalancer {
 	lb := proxies.NewGeneralLoadBalancer(spec, servers)
+	lb.SetHealthListener(sp.publishHealthEvent)
 	lb.Init(proxies.NewHTTPSessionSticker, sp.healthChecker, nil)
 	return lb
 }
 
+func (sp *ServerPool) publishHealthEvent(svr *Server, healthy bool) {
+	event := &supervisor.StateEvent{
+		Kind:     supervisor.StateEventHealthCheck,
+		Source:   sp.Name,
+		Target:   svr.ID(),
+		OldState: "healthy",
+		NewState: "unhealthy",
+	}
+	if healthy {
+		event.OldState, event.NewState = event.NewState, event.OldState
+	}
+	sp.proxy.super.PublishStateEvent(event)
+}
+
 func (sp *ServerPool) status() *ServerPoolStatus {
 	s := &ServerPoolStatus{Stat: sp.httpStat.Status()}
+	if state, ok := sp.circuitBreakerState(); ok {
+		s.CircuitBreaker = state.String()
+	}
+	if glb, ok := sp.LoadBalancer().(*proxies.GeneralLoadBalancer); ok {
+		s.DynamicWeights = glb.DynamicWeights()
+		s.Inflights = glb.Inflights()
+	}
+	s.Timeouts = &TimeoutStatus{
+		Dial:           atomic.LoadUint64(&sp.timeouts.Dial),
+		TLSHandshake:   atomic.LoadUint64(&sp.timeouts.TLSHandshake),
+		ResponseHeader: atomic.LoadUint64(&sp.timeouts.ResponseHeader),
+		Request:        atomic.LoadUint64(&sp.timeouts.Request),
+	}
+	if sp.hedger != nil {
+		s.Hedging = sp.hedger.status()
+	}
+	s.RateLimited = atomic.LoadUint64(&sp.rateLimited)
+	if sp.retryBudget != nil {
+		s.RetryBudget = sp.retryBudget.Status()
+		s.RetriesSuppressed = atomic.LoadUint64(&sp.retriesSuppressed)
+	}
+	if sp.ramp != nil {
+		s.Ramp = sp.ramp.status()
+	}
+	if sp.deadline != nil {
+		s.Deadline = sp.deadline.status()
+	}
+	if sp.responseBuffer != nil {
+		s.ResponseBuffering = sp.responseBuffer.status()
+	}
+	if sp.responseHeaderLimiter != nil {
+		s.ResponseHeaders = sp.responseHeaderLimiter.status()
+	}
+	s.UpstreamResets = &UpstreamResetStatus{
+		BeforeFlush: atomic.LoadUint64(&sp.upstreamResets.BeforeFlush),
+		AfterFlush:  atomic.LoadUint64(&sp.upstreamResets.AfterFlush),
+	}
 	return s
 }
 
+// Close closes the server pool.
+func (sp *ServerPool) Close() {
+	sp.BaseServerPool.Close()
+	if sp.ramp != nil {
+		sp.ramp.close()
+	}
+	if sp.memoryCache != nil {
+		sp.memoryCache.Close()
+	}
+	if sp.client != nil {
+		sp.client.CloseIdleConnections()
+	}
+	sp.certClients.Range(func(key, value interface{}) bool {
+		value.(*http.Client).CloseIdleConnections()
+		return true
+	})
+}
+
+func (sp *ServerPool) httpClient() *http.Client {
+	if sp.client != nil {
+		return sp.client
+	}
+	return sp.proxy.client
+}
+
+// timeoutResult returns the result of a timeout error returned by the
+// transport, it returns an empty string if err is not such an error.
+func (sp *ServerPool) timeoutResult(err error) string {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout() {
+		atomic.AddUint64(&sp.timeouts.Dial, 1)
+		return resultDialTimeout
+	}
+
+	// errors of these timeouts are not exported by net/http.
+	msg := err.Error()
+	if strings.Contains(msg, "TLS handshake timeout") {
+		atomic.AddUint64(&sp.timeouts.TLSHandshake, 1)
+		return resultTLSHandshakeTimeout
+	}
+	if strings.Contains(msg, "timeout awaiting response headers") {
+		atomic.AddUint64(&sp.timeouts.ResponseHeader, 1)
+		return resultResponseHeaderTimeout
+	}
+	return ""
+}
+
+// circuitBreakerState returns the state of the circuit breaker, it returns
+// false if the pool has no circuit breaker.
+func (sp *ServerPool) circuitBreakerState() (libcb.State, bool) {
+	cb, ok := sp.circuitBreakerWrapper.(interface{ State() libcb.State })
+	if !ok {
+		return libcb.StateDisabled, false
+	}
+	return cb.State(), true
+}
+
 // InjectResiliencePolicy injects resilience policies to the server pool.
 func (sp *ServerPool) InjectResiliencePolicy(policies map[string]resilience.Policy) {
 	name := sp.spec.RetryPolicy
@@ -310,6 +589,20 @@ func (sp *ServerPool) InjectResiliencePolicy(policies map[string]resilience.Poli
 			panic(fmt.Errorf("policy %s is not a circuitBreaker policy", name))
 		}
 		sp.circuitBreakerWrapper = policy.CreateWrapper()
+		if cb, ok := sp.circuitBreakerWrapper.(interface {
+			SetStateListener(libcb.EventListenerFunc)
+		}); ok {
+			cb.SetStateListener(func(event *libcb.Event) {
+				sp.proxy.super.PublishStateEvent(&supervisor.StateEvent{
+					Time:     event.Time,
+					Kind:     supervisor.StateEventCircuitBreaker,
+					Source:   sp.Name,
+					OldState: event.OldState,
+					NewState: event.NewState,
+					Reason:   event.Reason,
+				})
+			})
+		}
 	}
 }
 
@@ -366,7 +659,7 @@ func (sp *ServerPool) handleMirror(spCtx *serverPoolContext) {
 		return
 	}
 
-	resp, err := fnSendRequest(spCtx.stdReq, sp.proxy.client)
+	resp, err := fnSendRequest(spCtx.stdReq, sp.httpClientFor(spCtx.Context))
 	if err != nil {
 		return
 	}
@@ -375,7 +668,7 @@ func (sp *ServerPool) handleMirror(spCtx *serverPoolContext) {
 	resp.Body.Close()
 }
 
-func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
+func (sp *ServerPool) handle(ctx *context.Context, mirror bool) (result string) {
 	spCtx := &serverPoolContext{
 		Context: ctx,
 		req:     ctx.GetInputRequest().(*httpprot.Request),
@@ -386,9 +679,26 @@ func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
 		return ""
 	}
 
+	if limit, _ := ctx.GetData(proxies.ResponseTimeLimitKey).(*proxies.ResponseTimeLimit); limit != nil {
+		return sp.handleWithResponseTimeLimit(spCtx, limit)
+	}
+
+	return sp.handleRequest(spCtx, spCtx.req.Context())
+}
+
+// handleRequest sends the request to the backend, stdctx is the base
+// context of the request.
+func (sp *ServerPool) handleRequest(spCtx *serverPoolContext, stdctx stdcontext.Context) (result string) {
 	spCtx.startTime = fasttime.Now()
 	defer sp.collectMetrics(spCtx)
 
+	// errors of the client are not the fault of the pool.
+	if sp.ramp != nil {
+		defer func() {
+			sp.ramp.observe(result != "" && result != resultClientError)
+		}()
+	}
+
 	if sp.buildResponseFromCache(spCtx) {
 		if sp.inFailureCodes(spCtx.resp.StatusCode()) {
 			return resultFailureCode
@@ -396,12 +706,34 @@ func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
 		return ""
 	}
 
+	// a timeout set by the previous filters overrides the one of the pool.
+	timeout := sp.timeout
+	if d, _ := spCtx.GetData(proxies.TimeoutKey).(time.Duration); d > 0 {
+		timeout = d
+	}
+
+	// the deadline of the client covers all the attempts, including
+	// retries, while the timeout is for every attempt.
+	if sp.deadline != nil {
+		var cancel stdcontext.CancelFunc
+		var ok bool
+		if stdctx, cancel, ok = sp.deadline.withDeadline(stdctx, spCtx.req.HTTPHeader(), spCtx.startTime); ok {
+			defer cancel()
+			clientCtx := stdctx
+			defer func() {
+				if clientCtx.Err() == stdcontext.DeadlineExceeded {
+					atomic.AddUint64(&sp.deadline.exceeded, 1)
+				}
+			}()
+		}
+	}
+
 	// wrap the handler function to meet the requirement of resilience
 	// wrappers.
 	handler := func(stdctx stdcontext.Context) error {
-		if sp.timeout > 0 {
+		if timeout > 0 {
 			var cancel stdcontext.CancelFunc
-			stdctx, cancel = stdcontext.WithTimeout(stdctx, sp.timeout)
+			stdctx, cancel = stdcontext.WithTimeout(stdctx, timeout)
 			defer cancel()
 		}
 
@@ -416,7 +748,7 @@ func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
 		if spanName == "" {
 			spanName = sp.Name
 		}
-		spCtx.span = ctx.Span().NewChild(spanName)
+		spCtx.span = spCtx.Span().NewChild(spanName)
 		defer spCtx.span.End()
 
 		return sp.doHandle(stdctx, spCtx)
@@ -426,13 +758,17 @@ func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
 	// request as its body can only be read once.
 	if sp.retryWrapper != nil && !spCtx.req.IsStream() {
 		handler = sp.retryWrapper.Wrap(handler)
+		if sp.retryBudget != nil {
+			sp.retryBudget.RecordRequest()
+			stdctx = resilience.WithRetryPermit(stdctx, sp.acquireRetry)
+		}
 	}
 	if sp.circuitBreakerWrapper != nil {
 		handler = sp.circuitBreakerWrapper.Wrap(handler)
 	}
 
 	// call the handler.
-	err := handler(spCtx.req.Context())
+	err := handler(stdctx)
 	if err == nil {
 		return ""
 	}
@@ -442,6 +778,7 @@ func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
 	if err == resilience.ErrShortCircuited {
 		logger.Errorf("%s: short circuited by circuit break policy", sp.Name)
 		spCtx.AddTag("short circuited")
+		spCtx.SetError(fmt.Errorf("%s: %v", sp.Name, err))
 		sp.buildFailureResponse(spCtx, http.StatusServiceUnavailable)
 		return resultShortCircuited
 	}
@@ -450,6 +787,7 @@ func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
 	// response in most cases, but for failure status codes, the
 	// response is already there.
 	if spe, ok := err.(serverPoolError); ok {
+		spCtx.SetError(fmt.Errorf("%s: %v", sp.Name, spe))
 		if spCtx.resp == nil {
 			sp.buildFailureResponse(spCtx, spe.code)
 		}
@@ -459,6 +797,20 @@ func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
 	panic(fmt.Errorf("should not reach here"))
 }
 
+// acquireRetry reports whether the retry budget allows a retry.
+func (sp *ServerPool) acquireRetry() bool {
+	if sp.retryBudget.AcquireRetry() {
+		return true
+	}
+	// log every 100 suppressions, to avoid flooding the log when the
+	// backend is failing.
+	if n := atomic.AddUint64(&sp.retriesSuppressed, 1); n%100 == 1 {
+		logger.Warnf("%s: retry suppressed by the budget of backend %s, %d retries suppressed",
+			sp.Name, sp.retryBudget.Backend(), n)
+	}
+	return false
+}
+
 func (sp *ServerPool) doHandle(stdctx stdcontext.Context, spCtx *serverPoolContext) error {
 	svr := sp.LoadBalancer().ChooseServer(spCtx.req)
 
@@ -467,27 +819,77 @@ func (sp *ServerPool) doHandle(stdctx stdcontext.Context, spCtx *serverPoolConte
 		logger.Errorf("%s: no available server", sp.Name)
 		return serverPoolError{http.StatusServiceUnavailable, resultInternalError}
 	}
+	// the request is in flight until the body of the response is closed,
+	// inflight is the server whose count should be decreased on return.
+	svr.IncInflight()
+	inflight := svr
+	defer func() {
+		if inflight != nil {
+			inflight.DecInflight()
+		}
+	}()
+
+	// the backend may have a quota, wait for the permission before dialing.
+	if sp.limiter != nil && !sp.limiter.Wait(stdctx, sp.limiterTimeout) {
+		// log every 100 rejections, to avoid flooding the log when the
+		// quota of the backend is exhausted.
+		if n := atomic.AddUint64(&sp.rateLimited, 1); n%100 == 1 {
+			logger.Warnf("%s: rate limited by backend %s, %d requests rate limited",
+				sp.Name, sp.limiter.Backend(), n)
+		}
+		return serverPoolError{http.StatusTooManyRequests, resultRateLimited}
+	}
 
 	// prepare the request to send.
-	statResult := &gohttpstat.Result{}
-	stdctx = gohttpstat.WithHTTPStat(stdctx, statResult)
-	if err := spCtx.prepareRequest(sp, svr, stdctx, false); err != nil {
-		logger.Errorf("%s: failed to prepare request: %v", sp.Name, err)
-		return serverPoolError{http.StatusInternalServerError, resultInternalError}
+	// the trace hooks of HTTP/2 are called concurrently from the read and
+	// write loops of the connection, which races with gohttpstat, so only
+	// requests sent over HTTP/1.1 are traced.
+	var statResult *gohttpstat.Result
+	baseCtx := stdctx
+	if sp.clientSpec.BackendProtocol == "" || sp.clientSpec.BackendProtocol == BackendProtocolHTTP1 {
+		statResult = &gohttpstat.Result{}
+		stdctx = gohttpstat.WithHTTPStat(stdctx, statResult)
 	}
 
-	resp, err := fnSendRequest(spCtx.stdReq, sp.proxy.client)
+	var resp *http.Response
+	var err error
+	if sp.hedger != nil && sp.hedger.hedgeable(spCtx) {
+		// the counts of all requests are maintained by hedging.
+		inflight = nil
+		svr, resp, err = sp.sendHedged(stdctx, baseCtx, spCtx, svr)
+		if spErr, ok := err.(serverPoolError); ok {
+			return spErr
+		}
+	} else {
+		if err = spCtx.prepareRequest(sp, svr, stdctx, false); err != nil {
+			logger.Errorf("%s: failed to prepare request: %v", sp.Name, err)
+			return serverPoolError{http.StatusInternalServerError, resultInternalError}
+		}
+		resp, err = fnSendRequest(spCtx.stdReq, sp.httpClientFor(spCtx.Context))
+		if err == nil {
+			resp.Body = svr.InflightBody(resp.Body)
+			inflight = nil
+		}
+	}
 	if err != nil {
 		logger.Errorf("%s: failed to send request: %v", sp.Name, err)
 
-		statResult.End(fasttime.Now())
-		spCtx.LazyAddTag(func() string {
-			return fmt.Sprintf("trace %v", statResult)
-		})
+		if statResult != nil {
+			statResult.End(fasttime.Now())
+			spCtx.LazyAddTag(func() string {
+				return fmt.Sprintf("trace %v", statResult)
+			})
+		}
 
-		if err := spCtx.stdReq.Context().Err(); err == nil {
+		// the context of the request may be cancelled by hedging, so check
+		// the context of the pool.
+		if ctxErr := stdctx.Err(); ctxErr == nil {
+			if result := sp.timeoutResult(err); result != "" {
+				return serverPoolError{http.StatusGatewayTimeout, result}
+			}
 			return serverPoolError{http.StatusServiceUnavailable, resultServerError}
-		} else if err == stdcontext.DeadlineExceeded {
+		} else if ctxErr == stdcontext.DeadlineExceeded {
+			atomic.AddUint64(&sp.timeouts.Request, 1)
 			return serverPoolError{http.StatusRequestTimeout, resultTimeout}
 		}
 
@@ -497,7 +899,15 @@ func (sp *ServerPool) doHandle(stdctx stdcontext.Context, spCtx *serverPoolConte
 	}
 
 	spCtx.stdResp = resp
+	if sp.responseHeaderLimiter != nil {
+		if err = sp.enforceResponseHeaderLimits(spCtx); err != nil {
+			return err
+		}
+	}
 	if err = sp.buildResponse(spCtx); err != nil {
+		if isUpstreamReset(stdctx, err) {
+			return sp.handleUpstreamReset(spCtx, err)
+		}
 		return serverPoolError{http.StatusInternalServerError, resultInternalError}
 	}
 
@@ -534,7 +944,8 @@ func (sp *ServerPool) mergeResponseHeader(dst, src http.Header) http.Header {
 func (sp *ServerPool) buildResponse(spCtx *serverPoolContext) (err error) {
 	removeHopByHopHeaders(spCtx.stdResp.Header)
 
-	body := readers.NewCallbackReader(spCtx.stdResp.Body)
+	rr := &resetReader{ReadCloser: spCtx.stdResp.Body, pool: sp, spCtx: spCtx}
+	body := readers.NewCallbackReader(rr)
 	spCtx.stdResp.Body = body
 	spCtx.respCallbackBody = body
 
@@ -555,7 +966,12 @@ func (sp *ServerPool) buildResponse(spCtx *serverPoolContext) (err error) {
 	if maxBodySize == 0 {
 		maxBodySize = sp.proxy.spec.ServerMaxBodySize
 	}
-	if err = resp.FetchPayload(maxBodySize); err != nil {
+	if maxBodySize < 0 && sp.responseBuffer != nil {
+		err = sp.responseBuffer.fetchPayload(resp)
+	} else {
+		err = resp.FetchPayload(maxBodySize)
+	}
+	if err != nil {
 		logger.Errorf("%s: failed to fetch response payload: %v, please consider to set serverMaxBodySize of Proxy to -1.", sp.Name, err)
 		body.Close()
 		return err
@@ -563,6 +979,8 @@ func (sp *ServerPool) buildResponse(spCtx *serverPoolContext) (err error) {
 
 	if !resp.IsStream() {
 		body.Close()
+	} else {
+		rr.streaming = true
 	}
 
 	if sp.memoryCache != nil {
@@ -664,6 +1082,8 @@ type (
 		ResponseBodySize           prometheus.ObserverVec
 		RequestBodySizePercentage  prometheus.ObserverVec
 		ResponseBodySizePercentage prometheus.ObserverVec
+		UpstreamResets             *prometheus.CounterVec
+		ResponseHeaderLimits       *prometheus.CounterVec
 	}
 )
 
@@ -713,10 +1133,16 @@ func (sp *ServerPool) newMetrics(name string) *metrics {
 				Objectives: prometheushelper.DefaultObjectives(),
 			},
 			proxyLabels).MustCurryWith(commonLabels),
+		UpstreamResets: prometheushelper.NewCounter("proxy_upstream_resets",
+			"the total count of connections reset by the backend in the middle of the response",
+			append(proxyLabels, "phase")).MustCurryWith(commonLabels),
+		ResponseHeaderLimits: prometheushelper.NewCounter("proxy_response_header_limit_exceeded",
+			"the total count of backend responses whose headers exceed the limits",
+			append(proxyLabels, "action")).MustCurryWith(commonLabels),
 	}
 }
 
-func (sp *ServerPool) exportPrometheusMetrics(stat *httpstat.Metric) {
+func (sp *ServerPool) metricLabels() prometheus.Labels {
 	labels := prometheus.Labels{
 		"loadBalancePolicy": "",
 		"filterPolicy":      "",
@@ -727,6 +1153,11 @@ func (sp *ServerPool) exportPrometheusMetrics(stat *httpstat.Metric) {
 	if sp.spec.Filter != nil {
 		labels["filterPolicy"] = sp.spec.Filter.Policy
 	}
+	return labels
+}
+
+func (sp *ServerPool) exportPrometheusMetrics(stat *httpstat.Metric) {
+	labels := sp.metricLabels()
 	sp.metrics.TotalConnections.With(labels).Inc()
 	if stat.StatusCode >= 400 {
 		sp.metrics.TotalErrorConnections.With(labels).Inc()
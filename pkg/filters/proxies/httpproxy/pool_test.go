@@ -18,10 +18,15 @@
 package httpproxy
 
 import (
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters/proxies"
 	"github.com/megaease/easegress/v2/pkg/option"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
 	"github.com/megaease/easegress/v2/pkg/resilience"
@@ -221,3 +226,184 @@ func TestInFailureCodes(t *testing.T) {
 	assert.False(sp.inFailureCodes(500))
 	assert.True(sp.inFailureCodes(400))
 }
+
+func TestServerPoolRateLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+
+	yamlConfig := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+  rateLimit:
+    backend: backend
+    rate: 0.01
+    burst: 2
+`
+	proxy1 := newTestProxy(yamlConfig, assert)
+	defer proxy1.Close()
+	proxy1.InjectResiliencePolicy(make(map[string]resilience.Policy))
+
+	// another proxy in another pipeline shares the limiter.
+	proxy2 := kind.CreateInstance(proxy1.spec).(*Proxy)
+	proxy2.super = proxy1.super
+	proxy2.Init()
+	defer proxy2.Close()
+	proxy2.InjectResiliencePolicy(make(map[string]resilience.Policy))
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	assert.Equal("", proxy1.Handle(getCtx(stdr)))
+	assert.Equal("", proxy2.Handle(getCtx(stdr)))
+
+	ctx := getCtx(stdr)
+	assert.Equal(resultRateLimited, proxy2.Handle(ctx))
+	assert.Equal(http.StatusTooManyRequests, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+	assert.Equal(uint64(1), proxy2.Status().(*Status).MainPool.RateLimited)
+	assert.Equal(uint64(0), proxy1.Status().(*Status).MainPool.RateLimited)
+
+	spec := &ServerPoolSpec{
+		BaseServerPoolSpec: BaseServerPoolSpec{
+			Servers: []*Server{{URL: "http://127.0.0.1:9095"}},
+		},
+		RateLimit: &supervisor.BackendLimiterSpec{Backend: "backend"},
+	}
+	assert.Error(spec.Validate())
+}
+
+func TestServerPoolRetryBudget(t *testing.T) {
+	assert := assert.New(t)
+
+	sent := 0
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		sent++
+		return nil, fmt.Errorf("mocked error")
+	}
+
+	yamlConfig := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+  retryPolicy: retry
+  retryBudget:
+    backend: backend
+    ratio: 0
+    minRetries: 1
+`
+	proxy := newTestProxy(yamlConfig, assert)
+	defer proxy.Close()
+	proxy.InjectResiliencePolicy(map[string]resilience.Policy{
+		"retry": &resilience.RetryPolicy{
+			RetryRule: resilience.RetryRule{MaxAttempts: 3, WaitDuration: "1ms"},
+		},
+	})
+
+	// the only retry allowed by the budget is taken by the first request.
+	stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	assert.Equal(resultServerError, proxy.Handle(getCtx(stdr)))
+	assert.Equal(2, sent)
+
+	assert.Equal(resultServerError, proxy.Handle(getCtx(stdr)))
+	assert.Equal(3, sent)
+
+	status := proxy.Status().(*Status).MainPool
+	assert.Equal(uint64(2), status.RetriesSuppressed)
+	assert.Equal(uint64(2), status.RetryBudget.Requests)
+	assert.Equal(uint64(1), status.RetryBudget.Retries)
+	assert.Equal(1.0, status.RetryBudget.Utilization)
+
+	spec := &ServerPoolSpec{
+		BaseServerPoolSpec: BaseServerPoolSpec{
+			Servers: []*Server{{URL: "http://127.0.0.1:9095"}},
+		},
+		RetryBudget: &supervisor.RetryBudgetSpec{Backend: "backend", Ratio: 0.2},
+	}
+	assert.Error(spec.Validate())
+}
+
+func TestServerPoolInflights(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlConfig := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+  - url: http://127.0.0.1:9096
+  loadBalance:
+    policy: leastConnections
+`
+	proxy := newTestProxy(yamlConfig, assert)
+	defer proxy.Close()
+	proxy.InjectResiliencePolicy(make(map[string]resilience.Policy))
+
+	// the in-flight requests are counted while sending, and released even
+	// if the sending fails.
+	var inflights map[string]int64
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		inflights = proxy.Status().(*Status).MainPool.Inflights
+		return nil, fmt.Errorf("mocked error")
+	}
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	assert.Equal(resultServerError, proxy.Handle(getCtx(stdr)))
+	assert.Equal(int64(1), inflights["http://127.0.0.1:9095"]+inflights["http://127.0.0.1:9096"])
+
+	status := proxy.Status().(*Status).MainPool
+	assert.Equal(map[string]int64{"http://127.0.0.1:9095": 0, "http://127.0.0.1:9096": 0}, status.Inflights)
+
+	// a streaming response is in flight until its body is closed.
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("hello"))}, nil
+	}
+	proxy.mainPool.spec.ServerMaxBodySize = -1
+	ctx := getCtx(stdr)
+	assert.Equal("", proxy.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.True(resp.IsStream())
+	status = proxy.Status().(*Status).MainPool
+	assert.Equal(int64(1), status.Inflights["http://127.0.0.1:9095"]+status.Inflights["http://127.0.0.1:9096"])
+
+	resp.Close()
+	status = proxy.Status().(*Status).MainPool
+	assert.Equal(map[string]int64{"http://127.0.0.1:9095": 0, "http://127.0.0.1:9096": 0}, status.Inflights)
+}
+
+func TestServerPoolTimeoutOverride(t *testing.T) {
+	assert := assert.New(t)
+
+	var remaining time.Duration
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		deadline, _ := r.Context().Deadline()
+		remaining = time.Until(deadline)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+
+	yamlConfig := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+  timeout: 1h
+`
+	proxy := newTestProxy(yamlConfig, assert)
+	defer proxy.Close()
+	proxy.InjectResiliencePolicy(make(map[string]resilience.Policy))
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	assert.Equal("", proxy.Handle(getCtx(stdr)))
+	assert.Greater(remaining, time.Minute)
+
+	ctx := getCtx(stdr)
+	ctx.SetData(proxies.TimeoutKey, time.Second)
+	assert.Equal("", proxy.Handle(ctx))
+	assert.LessOrEqual(remaining, time.Second)
+}
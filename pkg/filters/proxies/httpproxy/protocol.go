@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	stdctx "context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	// BackendProtocolHTTP1 uses HTTP/1.1 to the backend, this is the
+	// default.
+	BackendProtocolHTTP1 = "http1"
+	// BackendProtocolHTTP2 uses HTTP/2 to the backend, over TLS for https
+	// servers, and with prior knowledge (h2c) for http servers.
+	BackendProtocolHTTP2 = "http2"
+	// BackendProtocolAuto negotiates the protocol with https servers by
+	// ALPN, and uses HTTP/1.1 to http servers.
+	BackendProtocolAuto = "auto"
+)
+
+// http2RoundTripper sends requests over HTTP/2, the h2c transport is used
+// for http servers, and the TLS one for https servers.
+type http2RoundTripper struct {
+	h2  *http2.Transport
+	h2c *http2.Transport
+}
+
+// validateBackendProtocol validates the backend protocol of a pool.
+func validateBackendProtocol(spec *ServerPoolSpec) error {
+	switch spec.BackendProtocol {
+	case "", BackendProtocolHTTP1, BackendProtocolAuto:
+		return nil
+	case BackendProtocolHTTP2:
+		// the HTTP/2 transport has no counterpart of it.
+		if spec.ResponseHeaderTimeout != "" {
+			return fmt.Errorf("responseHeaderTimeout is not supported with backendProtocol %s", BackendProtocolHTTP2)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown backendProtocol %s", spec.BackendProtocol)
+}
+
+func newHTTP2RoundTripper(tlsCfg *tls.Config, spec *HTTPClientSpec, dialFunc func(stdctx.Context, string, string) (net.Conn, error)) *http2RoundTripper {
+	tlsHandshakeTimeout := spec.TLSHandshakeTimeout
+	if tlsHandshakeTimeout <= 0 {
+		tlsHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+
+	if tlsCfg == nil {
+		tlsCfg = &tls.Config{}
+	} else {
+		tlsCfg = tlsCfg.Clone()
+	}
+	tlsCfg.NextProtos = []string{http2.NextProtoTLS}
+
+	return &http2RoundTripper{
+		h2: &http2.Transport{
+			TLSClientConfig: tlsCfg,
+			DialTLSContext: func(ctx stdctx.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				conn, err := dialFunc(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+
+				ctx, cancel := stdctx.WithTimeout(ctx, tlsHandshakeTimeout)
+				defer cancel()
+				tlsConn := tls.Client(conn, cfg)
+				if err := tlsConn.HandshakeContext(ctx); err != nil {
+					conn.Close()
+					return nil, err
+				}
+				if p := tlsConn.ConnectionState().NegotiatedProtocol; p != http2.NextProtoTLS {
+					tlsConn.Close()
+					return nil, fmt.Errorf("backend doesn't support HTTP/2, negotiated protocol: %q", p)
+				}
+				return tlsConn, nil
+			},
+		},
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx stdctx.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialFunc(ctx, network, addr)
+			},
+		},
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rt *http2RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return rt.h2c.RoundTrip(req)
+	}
+	return rt.h2.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of both transports.
+func (rt *http2RoundTripper) CloseIdleConnections() {
+	rt.h2.CloseIdleConnections()
+	rt.h2c.CloseIdleConnections()
+}
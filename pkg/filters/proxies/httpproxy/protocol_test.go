@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/resilience"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+func TestValidateBackendProtocol(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, p := range []string{"", BackendProtocolHTTP1, BackendProtocolHTTP2, BackendProtocolAuto} {
+		assert.NoError(validateBackendProtocol(&ServerPoolSpec{BackendProtocol: p}), p)
+	}
+	assert.Error(validateBackendProtocol(&ServerPoolSpec{BackendProtocol: "http3"}))
+
+	yamlConfig := `
+servers:
+- url: https://192.168.1.1
+backendProtocol: http2
+responseHeaderTimeout: 1s
+`
+	spec := &ServerPoolSpec{}
+	assert.NoError(codectool.Unmarshal([]byte(yamlConfig), spec))
+	assert.Error(spec.Validate())
+
+	spec.BackendProtocol = BackendProtocolAuto
+	assert.NoError(spec.Validate())
+}
+
+func TestBackendProtocol(t *testing.T) {
+	assert := assert.New(t)
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+
+	h2cServer := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer h2cServer.Close()
+
+	tlsServer := httptest.NewUnstartedServer(handler)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	http1Server := httptest.NewTLSServer(handler)
+	defer http1Server.Close()
+
+	yamlConfig := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: %s
+  backendProtocol: %s
+`
+
+	tests := []struct {
+		url      string
+		protocol string
+		proto    string
+	}{
+		{h2cServer.URL, "", "HTTP/1.1"},
+		{h2cServer.URL, BackendProtocolHTTP2, "HTTP/2.0"},
+		{h2cServer.URL, BackendProtocolAuto, "HTTP/1.1"},
+		{tlsServer.URL, BackendProtocolHTTP1, "HTTP/1.1"},
+		{tlsServer.URL, BackendProtocolHTTP2, "HTTP/2.0"},
+		{tlsServer.URL, BackendProtocolAuto, "HTTP/2.0"},
+		{http1Server.URL, BackendProtocolAuto, "HTTP/1.1"},
+		// the backend doesn't support HTTP/2.
+		{http1Server.URL, BackendProtocolHTTP2, ""},
+	}
+
+	for _, test := range tests {
+		proxy := newTestProxy(fmt.Sprintf(yamlConfig, test.url, test.protocol), assert)
+		proxy.InjectResiliencePolicy(make(map[string]resilience.Policy))
+
+		stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+		ctx := getCtx(stdr)
+		result := proxy.Handle(ctx)
+		if test.proto == "" {
+			assert.Equal(resultServerError, result)
+		} else {
+			assert.Equal("", result)
+			resp := ctx.GetOutputResponse().(*httpprot.Response)
+			assert.Equal(test.proto, string(resp.RawPayload()), "%s %s", test.url, test.protocol)
+		}
+		proxy.Close()
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/megaease/easegress/v2/pkg/context"
@@ -34,6 +35,7 @@ import (
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
 	"github.com/megaease/easegress/v2/pkg/resilience"
 	"github.com/megaease/easegress/v2/pkg/supervisor"
+	libcb "github.com/megaease/easegress/v2/pkg/util/circuitbreaker"
 	"github.com/megaease/easegress/v2/pkg/util/easemonitor"
 )
 
@@ -49,6 +51,26 @@ const (
 	// result for resilience
 	resultTimeout        = "timeout"
 	resultShortCircuited = "shortCircuited"
+
+	// result for the rate limiter of the backend
+	resultRateLimited = "rateLimited"
+
+	// results for timeouts of the phases of a request, resultTimeout is
+	// for the timeout of the whole request.
+	resultDialTimeout           = "dialTimeout"
+	resultTLSHandshakeTimeout   = "tlsHandshakeTimeout"
+	resultResponseHeaderTimeout = "responseHeaderTimeout"
+
+	// result for the response time limit set by other filters, e.g. the
+	// Fallback.
+	resultResponseTimeExceeded = "responseTimeExceeded"
+
+	// result for the connection reset by the backend before the response
+	// is flushed.
+	resultUpstreamReset = "upstreamReset"
+
+	defaultDialTimeout         = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
 )
 
 var kind = &filters.Kind{
@@ -61,7 +83,14 @@ var kind = &filters.Kind{
 		resultFailureCode,
 		resultTimeout,
 		resultShortCircuited,
+		resultRateLimited,
+		resultDialTimeout,
+		resultTLSHandshakeTimeout,
+		resultResponseHeaderTimeout,
+		resultResponseTimeExceeded,
+		resultUpstreamReset,
 	},
+	RequestOnly: true,
 	DefaultSpec: func() filters.Spec {
 		return &Spec{
 			MaxIdleConns:        10240,
@@ -79,6 +108,7 @@ var kind = &filters.Kind{
 var (
 	_ filters.Filter      = (*Proxy)(nil)
 	_ filters.Resiliencer = (*Proxy)(nil)
+	_ filters.Recreator   = (*Proxy)(nil)
 )
 
 func init() {
@@ -138,6 +168,14 @@ type (
 		MaxIdleConns        int
 		MaxIdleConnsPerHost int
 		MaxRedirection      *int
+		// DialTimeout and TLSHandshakeTimeout use the default values if
+		// they are zero, ResponseHeaderTimeout means no timeout if it is
+		// zero.
+		DialTimeout           time.Duration
+		TLSHandshakeTimeout   time.Duration
+		ResponseHeaderTimeout time.Duration
+		// BackendProtocol is the HTTP version used to the backend.
+		BackendProtocol string
 	}
 
 	// Server is the backend server.
@@ -183,9 +221,18 @@ func (s *Spec) Validate() error {
 }
 
 func HTTPClient(tlsCfg *tls.Config, spec *HTTPClientSpec, timeout time.Duration) *http.Client {
+	dialTimeout := spec.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = defaultDialTimeout
+	}
+	tlsHandshakeTimeout := spec.TLSHandshakeTimeout
+	if tlsHandshakeTimeout <= 0 {
+		tlsHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+
 	dialFunc := func(ctx stdctx.Context, network, addr string) (net.Conn, error) {
 		return (&net.Dialer{
-			Timeout:   30 * time.Second,
+			Timeout:   dialTimeout,
 			KeepAlive: 60 * time.Second,
 		}).DialContext(ctx, network, addr)
 	}
@@ -193,7 +240,12 @@ func HTTPClient(tlsCfg *tls.Config, spec *HTTPClientSpec, timeout time.Duration)
 	client := &http.Client{
 		// NOTE: Timeout could be no limit, real client or server could cancel it.
 		Timeout: timeout,
-		Transport: &http.Transport{
+	}
+
+	if spec.BackendProtocol == BackendProtocolHTTP2 {
+		client.Transport = newHTTP2RoundTripper(tlsCfg, spec, dialFunc)
+	} else {
+		client.Transport = &http.Transport{
 			Proxy:              http.ProxyFromEnvironment,
 			DialContext:        dialFunc,
 			TLSClientConfig:    tlsCfg,
@@ -203,9 +255,13 @@ func HTTPClient(tlsCfg *tls.Config, spec *HTTPClientSpec, timeout time.Duration)
 			MaxIdleConns:          spec.MaxIdleConns,
 			MaxIdleConnsPerHost:   spec.MaxIdleConnsPerHost,
 			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
+			TLSHandshakeTimeout:   tlsHandshakeTimeout,
+			ResponseHeaderTimeout: spec.ResponseHeaderTimeout,
 			ExpectContinueTimeout: 1 * time.Second,
-		},
+			// HTTP/2 is only negotiated by ALPN with backendProtocol auto,
+			// as a custom TLS config disables it by default.
+			ForceAttemptHTTP2: spec.BackendProtocol == BackendProtocolAuto,
+		}
 	}
 	if spec.MaxRedirection != nil {
 		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
@@ -246,6 +302,24 @@ func (p *Proxy) Inherit(previousGeneration filters.Filter) {
 	p.reload()
 }
 
+// AlwaysRecreate implements filters.Recreator. The backend limiters and
+// retry budgets are shared by the pools of all pipelines, and the last
+// loaded pool decides their settings, so a proxy using them is recreated
+// on every reload to apply its settings again. So is a proxy discovering
+// servers from service registries, to list the servers again.
+func (p *Proxy) AlwaysRecreate() bool {
+	pools := p.spec.Pools
+	if p.spec.MirrorPool != nil {
+		pools = append(pools[:len(pools):len(pools)], p.spec.MirrorPool)
+	}
+	for _, spec := range pools {
+		if spec.RateLimit != nil || spec.RetryBudget != nil || spec.UseServiceRegistry() {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Proxy) tlsConfig() (*tls.Config, error) {
 	mtls := p.spec.MTLS
 
@@ -301,12 +375,15 @@ func (p *Proxy) reload() {
 	}
 
 	tlsCfg, _ := p.tlsConfig()
-	clientSpec := &HTTPClientSpec{
+	p.client = HTTPClient(tlsCfg, p.httpClientSpec(), 0)
+}
+
+func (p *Proxy) httpClientSpec() *HTTPClientSpec {
+	return &HTTPClientSpec{
 		MaxIdleConns:        p.spec.MaxIdleConns,
 		MaxIdleConnsPerHost: p.spec.MaxIdleConnsPerHost,
 		MaxRedirection:      &p.spec.MaxRedirection,
 	}
-	p.client = HTTPClient(tlsCfg, clientSpec, 0)
 }
 
 // Status returns Proxy status.
@@ -326,6 +403,39 @@ func (p *Proxy) Status() interface{} {
 	return s
 }
 
+// HealthReport reports the health of Proxy, it is degraded if the circuit
+// breaker of any pool is not closed.
+func (p *Proxy) HealthReport() *filters.HealthReport {
+	pools := append([]*ServerPool{p.mainPool}, p.candidatePools...)
+
+	var reasons []string
+	var requests, errCount uint64
+	for _, pool := range pools {
+		if state, ok := pool.circuitBreakerState(); ok {
+			switch state {
+			case libcb.StateOpen, libcb.StateHalfOpen, libcb.StateForceOpen:
+				reasons = append(reasons, fmt.Sprintf("circuit breaker of pool %s is %s", pool.Name, state))
+			}
+		}
+		stat := pool.httpStat.Status()
+		requests += stat.Count
+		errCount += stat.ErrCount
+	}
+
+	r := &filters.HealthReport{
+		Health: filters.HealthHealthy,
+		Metrics: map[string]interface{}{
+			"requests": requests,
+			"errors":   errCount,
+		},
+	}
+	if len(reasons) > 0 {
+		r.Health = filters.HealthDegraded
+		r.Reason = strings.Join(reasons, "; ")
+	}
+	return r
+}
+
 // Close closes Proxy.
 func (p *Proxy) Close() {
 	p.mainPool.Close()
@@ -343,6 +453,21 @@ func (p *Proxy) Close() {
 func (p *Proxy) Handle(ctx *context.Context) (result string) {
 	req := ctx.GetInputRequest().(*httpprot.Request)
 
+	// A deferred body is read here if no filter has read it. Failing to
+	// read it, e.g. the client disconnects or the body is too large, must
+	// fail the request instead of forwarding a truncated body.
+	if err := req.FetchDeferredPayload(); err != nil {
+		statusCode := http.StatusBadRequest
+		if err == httpprot.ErrRequestEntityTooLarge {
+			statusCode = http.StatusRequestEntityTooLarge
+		}
+		resp, _ := httpprot.NewResponse(nil)
+		resp.SetStatusCode(statusCode)
+		ctx.SetOutputResponse(resp)
+		ctx.AddTag(fmt.Sprintf("failed to read request body: %v", err))
+		return resultClientError
+	}
+
 	if p.mirrorPool != nil && p.mirrorPool.filter.Match(req) {
 		go p.mirrorPool.handle(ctx, true)
 	}
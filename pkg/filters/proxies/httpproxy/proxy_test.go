@@ -20,12 +20,15 @@ package httpproxy
 import (
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strings"
 	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/megaease/easegress/v2/pkg/context"
@@ -37,6 +40,7 @@ import (
 	"github.com/megaease/easegress/v2/pkg/resilience"
 	"github.com/megaease/easegress/v2/pkg/supervisor"
 	"github.com/megaease/easegress/v2/pkg/tracing"
+	libcb "github.com/megaease/easegress/v2/pkg/util/circuitbreaker"
 	"github.com/megaease/easegress/v2/pkg/util/codectool"
 	"github.com/stretchr/testify/assert"
 )
@@ -166,7 +170,11 @@ compression:
 	// direct set fnSendRequest to different function will cause data race since we use goroutine
 	// for mirror.
 	var fnKind int32
+	mirrored := make(chan struct{}, 1)
 	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		if strings.HasPrefix(r.URL.Host, "127.0.0.3") {
+			defer func() { mirrored <- struct{}{} }()
+		}
 		kind := atomic.LoadInt32(&fnKind)
 		switch kind {
 		case 0:
@@ -208,6 +216,7 @@ compression:
 		stdr.Header.Set("X-Test", "testheader")
 		ctx := getCtx(stdr)
 		assert.NotEqual("", proxy.Handle(ctx))
+		assert.Contains(ctx.GetError().Error(), "status code=503")
 	}
 
 	atomic.StoreInt32(&fnKind, 2)
@@ -231,6 +240,9 @@ compression:
 		assert.NotEmpty(ctx.Tags())
 	}
 
+	// wait for the mirror request, so it doesn't race with the tests
+	// replacing fnSendRequest.
+	<-mirrored
 	proxy.Close()
 }
 
@@ -396,3 +408,196 @@ func TestToMetrics(t *testing.T) {
 	metrics := s.ToMetrics("test")
 	assert.Equal(3, len(metrics))
 }
+
+func TestHealthReport(t *testing.T) {
+	assert := assert.New(t)
+
+	const yamlConfig = `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.2:9095
+  circuitBreakerPolicy: circuitBreaker
+`
+	proxy := newTestProxy(yamlConfig, assert)
+	defer proxy.Close()
+	proxy.InjectResiliencePolicy(map[string]resilience.Policy{
+		"circuitBreaker": &resilience.CircuitBreakerPolicy{},
+	})
+
+	r := proxy.HealthReport()
+	assert.Equal(filters.HealthHealthy, r.Health)
+	assert.Empty(r.Reason)
+	assert.Equal("Closed", proxy.Status().(*Status).MainPool.CircuitBreaker)
+
+	cb := proxy.mainPool.circuitBreakerWrapper.(interface{ SetState(libcb.State) })
+	cb.SetState(libcb.StateOpen)
+	r = proxy.HealthReport()
+	assert.Equal(filters.HealthDegraded, r.Health)
+	assert.Contains(r.Reason, "is Open")
+	assert.Equal("Open", proxy.Status().(*Status).MainPool.CircuitBreaker)
+}
+
+func TestPhaseTimeouts(t *testing.T) {
+	assert := assert.New(t)
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	// a server responds slowly.
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	// a server accepts connections but never completes the TLS handshake.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	yamlConfig := fmt.Sprintf(`
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: %s
+  responseHeaderTimeout: 50ms
+- servers:
+  - url: https://%s
+  filter:
+    headers:
+      X-Pool:
+        exact: tls
+  tlsHandshakeTimeout: 50ms
+- servers:
+  - url: %s
+  filter:
+    headers:
+      X-Pool:
+        exact: request
+  timeout: 50ms
+`, slow.URL, silent.Addr().String(), slow.URL)
+	proxy := newTestProxy(yamlConfig, assert)
+	defer proxy.Close()
+	proxy.InjectResiliencePolicy(make(map[string]resilience.Policy))
+	assert.Nil(proxy.candidatePools[1].client)
+
+	for _, c := range []struct {
+		pool   string
+		result string
+	}{
+		{"", resultResponseHeaderTimeout},
+		{"tls", resultTLSHandshakeTimeout},
+		{"request", resultTimeout},
+	} {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+		stdr.Header.Set("X-Pool", c.pool)
+		ctx := getCtx(stdr)
+		assert.Equal(c.result, proxy.Handle(ctx), c.pool)
+	}
+
+	status := proxy.Status().(*Status)
+	assert.Equal(uint64(1), status.MainPool.Timeouts.ResponseHeader)
+	assert.Equal(uint64(1), status.CandidatePools[0].Timeouts.TLSHandshake)
+	assert.Equal(uint64(1), status.CandidatePools[1].Timeouts.Request)
+
+	dialErr := &url.Error{Op: "Get", URL: "http://127.0.0.1/", Err: &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}}
+	assert.Equal(resultDialTimeout, proxy.mainPool.timeoutResult(dialErr))
+	assert.Equal(uint64(1), proxy.Status().(*Status).MainPool.Timeouts.Dial)
+	assert.Equal("", proxy.mainPool.timeoutResult(fmt.Errorf("connection refused")))
+}
+
+func TestDeferredPayloadError(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer server.Close()
+
+	proxy := newTestProxy(fmt.Sprintf(`
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: %s
+`, server.URL), assert)
+	defer proxy.Close()
+	proxy.InjectResiliencePolicy(make(map[string]resilience.Policy))
+
+	for _, c := range []struct {
+		body       io.Reader
+		statusCode int
+	}{
+		{strings.NewReader("hello"), http.StatusRequestEntityTooLarge},
+		{iotest.ErrReader(io.ErrUnexpectedEOF), http.StatusBadRequest},
+	} {
+		stdr, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/", c.body)
+		stdr.ContentLength = -1
+		ctx := getCtx(stdr)
+		req := ctx.GetInputRequest().(*httpprot.Request)
+		assert.NoError(req.DeferPayload(3))
+
+		assert.Equal(resultClientError, proxy.Handle(ctx))
+		assert.Equal(c.statusCode, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+	}
+	assert.Equal(int32(0), atomic.LoadInt32(&requests))
+}
+
+func TestAlwaysRecreate(t *testing.T) {
+	assert := assert.New(t)
+
+	proxy := newTestProxy(`
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+`, assert)
+	assert.False(proxy.AlwaysRecreate())
+	proxy.Close()
+
+	// the pools using the limiters shared by the supervisor.
+	for _, pool := range []string{`
+  rateLimit:
+    backend: backend
+    rate: 10
+`, `
+  retryPolicy: retry
+  retryBudget:
+    backend: backend
+    ratio: 0.2
+`} {
+		proxy = newTestProxy(`
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+mirrorPool:
+  filter:
+    headers:
+      X-Mirror:
+        exact: mirror
+  servers:
+  - url: http://127.0.0.1:9096`+pool, assert)
+		assert.True(proxy.AlwaysRecreate(), pool)
+		proxy.Close()
+	}
+
+	// the servers are discovered from a service registry.
+	spec := &Spec{Pools: []*ServerPoolSpec{{BaseServerPoolSpec: BaseServerPoolSpec{ServiceRegistry: "registry", ServiceName: "service"}}}}
+	assert.True((&Proxy{spec: spec}).AlwaysRecreate())
+}
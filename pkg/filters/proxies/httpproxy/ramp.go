@@ -0,0 +1,287 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/cluster"
+	"github.com/megaease/easegress/v2/pkg/filters/proxies"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+const (
+	// RampStateRamping means the traffic is being ramped up.
+	RampStateRamping = "Ramping"
+	// RampStateCompleted means the ramp reaches the last step.
+	RampStateCompleted = "Completed"
+	// RampStateRolledBack means the ramp is aborted because of errors, and
+	// no traffic goes to the pool.
+	RampStateRolledBack = "RolledBack"
+
+	defaultRampMinRequests = 10
+)
+
+type (
+	// RampSpec is the spec to ramp up the traffic to a candidate pool
+	// gradually, the permil of the filter of the pool increases step by
+	// step as long as the error rate of the pool is below the threshold.
+	RampSpec struct {
+		// Steps are the permils of the steps, in ascending order.
+		Steps []uint32 `json:"steps" jsonschema:"required,minItems=1"`
+		// Interval is the duration of every step.
+		Interval string `json:"interval" jsonschema:"required,format=duration"`
+		// MaxErrorRate is the max error rate of a step, the ramp is rolled
+		// back if the error rate exceeds it.
+		MaxErrorRate float64 `json:"maxErrorRate" jsonschema:"required"`
+		// MinRequests is the min number of requests of a step to evaluate
+		// the error rate.
+		MinRequests uint64 `json:"minRequests,omitempty"`
+	}
+
+	// RampStatus is the status of a ramp.
+	RampStatus struct {
+		State    string `json:"state"`
+		Step     int    `json:"step"`
+		Permil   uint32 `json:"permil"`
+		Requests uint64 `json:"requests"`
+		Errors   uint64 `json:"errors"`
+	}
+
+	// rampState is the state of a ramp persisted in the cluster, so that
+	// the ramp continues after restarts. Every member ramps by the requests
+	// it handles, so the state is persisted per member.
+	rampState struct {
+		// Spec is the spec of the ramp, the ramp restarts if it changes.
+		Spec  string `json:"spec"`
+		State string `json:"state"`
+		Step  int    `json:"step"`
+	}
+
+	// ramp is a request matcher whose permil changes over time.
+	ramp struct {
+		poolName    string
+		spec        *RampSpec
+		specJSON    string
+		matcherSpec RequestMatcherSpec
+		minRequests uint64
+
+		cls cluster.Cluster
+		key string
+
+		mutex    sync.Mutex
+		state    rampState
+		requests uint64
+		errors   uint64
+
+		matcher atomic.Value // proxies.RequestMatcher
+		done    chan struct{}
+	}
+)
+
+// Validate validates the RampSpec.
+func (spec *RampSpec) Validate() error {
+	for i, permil := range spec.Steps {
+		if permil == 0 || permil > 1000 {
+			return fmt.Errorf("invalid step %d, must be in (0, 1000]", permil)
+		}
+		if i > 0 && permil <= spec.Steps[i-1] {
+			return fmt.Errorf("steps must be in ascending order")
+		}
+	}
+	if d, err := time.ParseDuration(spec.Interval); err != nil || d <= 0 {
+		return fmt.Errorf("invalid interval %s", spec.Interval)
+	}
+	if spec.MaxErrorRate < 0 || spec.MaxErrorRate > 1 {
+		return fmt.Errorf("invalid max error rate %v, must be in [0, 1]", spec.MaxErrorRate)
+	}
+	return nil
+}
+
+func newRamp(sp *ServerPool, spec *RampSpec, matcherSpec *RequestMatcherSpec) *ramp {
+	r := &ramp{
+		poolName:    sp.Name,
+		spec:        spec,
+		matcherSpec: *matcherSpec,
+		minRequests: spec.MinRequests,
+		done:        make(chan struct{}),
+	}
+	if r.minRequests == 0 {
+		r.minRequests = defaultRampMinRequests
+	}
+	r.specJSON = string(codectool.MustMarshalJSON(spec))
+	r.state = rampState{Spec: r.specJSON, State: RampStateRamping}
+
+	if super := sp.proxy.super; super != nil && super.Cluster() != nil {
+		r.cls = super.Cluster()
+		r.key = r.cls.Layout().RampDataKey(sp.proxy.spec.Pipeline(), sp.Name)
+		r.load()
+	}
+	if r.state.State == RampStateRamping && r.state.Step == len(spec.Steps)-1 {
+		r.state.State = RampStateCompleted
+	}
+	r.setPermil(r.permil())
+
+	interval, _ := time.ParseDuration(spec.Interval)
+	go r.run(interval)
+	return r
+}
+
+// load loads the state persisted in the cluster, the state is dropped if
+// the spec has been changed.
+func (r *ramp) load() {
+	value, err := r.cls.Get(r.key)
+	if err != nil {
+		logger.Errorf("%s: failed to load ramp state: %v", r.poolName, err)
+		return
+	}
+	if value == nil {
+		return
+	}
+
+	state := rampState{}
+	if err = codectool.UnmarshalJSON([]byte(*value), &state); err != nil {
+		logger.Errorf("%s: failed to unmarshal ramp state: %v", r.poolName, err)
+		return
+	}
+	if state.Spec != r.specJSON || state.Step < 0 || state.Step >= len(r.spec.Steps) {
+		return
+	}
+	r.state = state
+}
+
+// save persists the state to the cluster, the caller must hold the lock.
+// It is called only when the state changes, which is rare, so it is fine
+// to save the state synchronously to keep the order of the changes.
+func (r *ramp) save() {
+	if r.cls == nil {
+		return
+	}
+	data := string(codectool.MustMarshalJSON(&r.state))
+	if err := r.cls.Put(r.key, data); err != nil {
+		logger.Errorf("%s: failed to save ramp state: %v", r.poolName, err)
+	}
+}
+
+// permil returns the permil of the current state, the caller must hold
+// the lock or make sure there's no concurrent access.
+func (r *ramp) permil() uint32 {
+	if r.state.State == RampStateRolledBack {
+		return 0
+	}
+	return r.spec.Steps[r.state.Step]
+}
+
+func (r *ramp) setPermil(permil uint32) {
+	spec := r.matcherSpec
+	spec.Permil = permil
+	r.matcher.Store(NewRequestMatcher(&spec))
+}
+
+// Match implements proxies.RequestMatcher.
+func (r *ramp) Match(req protocols.Request) bool {
+	return r.matcher.Load().(proxies.RequestMatcher).Match(req)
+}
+
+// observe records the result of a request sent to the pool, and rolls back
+// the ramp if the error rate exceeds the threshold.
+func (r *ramp) observe(failed bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.state.State != RampStateRamping {
+		return
+	}
+
+	r.requests++
+	if failed {
+		r.errors++
+	}
+	if r.requests < r.minRequests || r.errorRate() <= r.spec.MaxErrorRate {
+		return
+	}
+
+	logger.Warnf("%s: ramp rolled back at step %d, error rate %.4f exceeds %.4f",
+		r.poolName, r.state.Step, r.errorRate(), r.spec.MaxErrorRate)
+	r.state.State = RampStateRolledBack
+	r.setPermil(0)
+	r.save()
+}
+
+func (r *ramp) errorRate() float64 {
+	if r.requests == 0 {
+		return 0
+	}
+	return float64(r.errors) / float64(r.requests)
+}
+
+// advance moves the ramp to the next step if the current step is healthy.
+func (r *ramp) advance() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// wait for more requests to evaluate the error rate.
+	if r.state.State != RampStateRamping || r.requests < r.minRequests {
+		return
+	}
+
+	r.state.Step++
+	r.requests, r.errors = 0, 0
+	if r.state.Step == len(r.spec.Steps)-1 {
+		r.state.State = RampStateCompleted
+	}
+	logger.Infof("%s: ramp advanced to step %d, permil %d", r.poolName, r.state.Step, r.permil())
+	r.setPermil(r.permil())
+	r.save()
+}
+
+func (r *ramp) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.advance()
+		}
+	}
+}
+
+func (r *ramp) status() *RampStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return &RampStatus{
+		State:    r.state.State,
+		Step:     r.state.Step,
+		Permil:   r.permil(),
+		Requests: r.requests,
+		Errors:   r.errors,
+	}
+}
+
+func (r *ramp) close() {
+	close(r.done)
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/cluster"
+	"github.com/megaease/easegress/v2/pkg/cluster/clustertest"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/option"
+	"github.com/megaease/easegress/v2/pkg/resilience"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRampSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError((&RampSpec{Steps: []uint32{100, 1000}, Interval: "10m", MaxErrorRate: 0.1}).Validate())
+	assert.Error((&RampSpec{Steps: []uint32{0, 1000}, Interval: "10m"}).Validate())
+	assert.Error((&RampSpec{Steps: []uint32{100, 1001}, Interval: "10m"}).Validate())
+	assert.Error((&RampSpec{Steps: []uint32{500, 100}, Interval: "10m"}).Validate())
+	assert.Error((&RampSpec{Steps: []uint32{100}, Interval: "abc"}).Validate())
+	assert.Error((&RampSpec{Steps: []uint32{100}, Interval: "10m", MaxErrorRate: 2}).Validate())
+
+	spec := &ServerPoolSpec{
+		BaseServerPoolSpec: BaseServerPoolSpec{
+			Servers: []*Server{{URL: "http://127.0.0.1:9095"}},
+		},
+		Ramp: &RampSpec{Steps: []uint32{100}, Interval: "10m"},
+	}
+	assert.Error(spec.Validate())
+}
+
+func newRampTestCluster() *clustertest.MockedCluster {
+	var mutex sync.Mutex
+	kvs := map[string]string{}
+
+	cls := clustertest.NewMockedCluster()
+	cls.MockedLayout = func() *cluster.Layout {
+		return &cluster.Layout{}
+	}
+	cls.MockedGet = func(key string) (*string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if v, ok := kvs[key]; ok {
+			return &v, nil
+		}
+		return nil, nil
+	}
+	cls.MockedPut = func(key, value string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		kvs[key] = value
+		return nil
+	}
+	return cls
+}
+
+func newRampTestProxy(cls *clustertest.MockedCluster, yamlConfig string, assert *assert.Assertions) *Proxy {
+	rawSpec := make(map[string]interface{})
+	assert.NoError(codectool.Unmarshal([]byte(yamlConfig), &rawSpec))
+	spec, err := filters.NewSpec(nil, "pipeline", rawSpec)
+	assert.NoError(err)
+
+	proxy := kind.CreateInstance(spec).(*Proxy)
+	proxy.super = supervisor.NewMock(option.New(), cls, nil, nil, false, nil, nil)
+	proxy.Init()
+	proxy.InjectResiliencePolicy(make(map[string]resilience.Policy))
+	return proxy
+}
+
+func TestRamp(t *testing.T) {
+	assert := assert.New(t)
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+
+	yamlConfig := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+- servers:
+  - url: http://127.0.0.1:9096
+  filter:
+    policy: random
+    permil: 1
+  ramp:
+    steps: [100, 500, 1000]
+    interval: 1h
+    maxErrorRate: 0.5
+    minRequests: 2
+`
+	cls := newRampTestCluster()
+	proxy := newRampTestProxy(cls, yamlConfig, assert)
+	r := proxy.candidatePools[0].ramp
+	assert.Equal(&RampStatus{State: RampStateRamping, Permil: 100}, r.status())
+
+	// not enough requests to evaluate the step.
+	r.observe(false)
+	r.advance()
+	assert.Equal(0, r.status().Step)
+
+	r.observe(true)
+	r.advance()
+	assert.Equal(&RampStatus{State: RampStateRamping, Step: 1, Permil: 500}, r.status())
+	r.observe(false)
+	r.observe(false)
+	r.advance()
+	assert.Equal(&RampStatus{State: RampStateCompleted, Step: 2, Permil: 1000}, r.status())
+
+	// all traffic goes to the candidate pool now.
+	stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	for i := 0; i < 10; i++ {
+		assert.Equal("", proxy.Handle(getCtx(stdr)))
+	}
+	assert.Equal(uint64(10), proxy.Status().(*Status).CandidatePools[0].Stat.Count)
+	proxy.Close()
+
+	// the ramp continues after restarts.
+	proxy = newRampTestProxy(cls, yamlConfig, assert)
+	assert.Equal(&RampStatus{State: RampStateCompleted, Step: 2, Permil: 1000}, proxy.candidatePools[0].ramp.status())
+	proxy.Close()
+
+	// the ramp restarts if the spec changes, and it rolls back on errors.
+	yamlConfig = yamlConfig[:len(yamlConfig)-len("2\n")] + "4\n"
+	proxy = newRampTestProxy(cls, yamlConfig, assert)
+	defer proxy.Close()
+	r = proxy.candidatePools[0].ramp
+	assert.Equal(&RampStatus{State: RampStateRamping, Permil: 100}, r.status())
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusInternalServerError, Body: http.NoBody}, nil
+	}
+	for i := 0; i < 4; i++ {
+		assert.Equal(resultFailureCode, proxy.candidatePools[0].handle(getCtx(stdr), false))
+	}
+	assert.Equal(&RampStatus{State: RampStateRolledBack, Permil: 0, Requests: 4, Errors: 4}, r.status())
+	for i := 0; i < 10; i++ {
+		assert.False(r.Match(getCtx(stdr).GetInputRequest()))
+	}
+
+	proxy = newRampTestProxy(cls, yamlConfig, assert)
+	defer proxy.Close()
+	assert.Equal(RampStateRolledBack, proxy.candidatePools[0].ramp.status().State)
+}
@@ -28,12 +28,35 @@ import (
 type RequestMatcherSpec struct {
 	proxies.RequestMatcherBaseSpec `json:",inline"`
 	URLs                           []*MethodAndURLMatcher `json:"urls,omitempty"`
+	// Cookies are matched in the same way as Headers, and MatchAllHeaders
+	// applies to both of them.
+	Cookies map[string]*stringtool.StringMatcher `json:"cookies,omitempty"`
+}
+
+func (s *RequestMatcherSpec) isGeneral() bool {
+	return s.Policy == "" || s.Policy == "general"
+}
+
+// hasConditions returns whether there are conditions on headers, cookies
+// or URLs.
+func (s *RequestMatcherSpec) hasConditions() bool {
+	return len(s.Headers) > 0 || len(s.Cookies) > 0 || len(s.URLs) > 0
 }
 
 // Validate validates the RequestMatcherSpec.
 func (s *RequestMatcherSpec) Validate() error {
-	if err := s.RequestMatcherBaseSpec.Validate(); err != nil {
-		return err
+	// the base spec requires headers for the general policy, but cookies
+	// are fine as well.
+	if !s.isGeneral() || len(s.Headers) > 0 || len(s.Cookies) == 0 {
+		if err := s.RequestMatcherBaseSpec.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range s.Cookies {
+		if err := c.Validate(); err != nil {
+			return err
+		}
 	}
 
 	for _, r := range s.URLs {
@@ -45,34 +68,61 @@ func (s *RequestMatcherSpec) Validate() error {
 	return nil
 }
 
-// NewRequestMatcher creates a new traffic matcher according to spec.
+// NewRequestMatcher creates a new traffic matcher according to spec. For
+// the probability policies, a request must match the conditions on headers,
+// cookies and URLs, if any, before it is sampled.
 func NewRequestMatcher(spec *RequestMatcherSpec) proxies.RequestMatcher {
-	switch spec.Policy {
-	case "", "general":
-		matcher := &generalMatcher{
-			matchAllHeaders: spec.MatchAllHeaders,
-			headers:         spec.Headers,
-			urls:            spec.URLs,
-		}
-		matcher.init()
-		return matcher
-	default:
-		return proxies.NewRequestMatcher(&spec.RequestMatcherBaseSpec)
+	if spec.isGeneral() {
+		return newGeneralMatcher(spec)
+	}
+
+	sampler := proxies.NewRequestMatcher(&spec.RequestMatcherBaseSpec)
+	if !spec.hasConditions() {
+		return sampler
 	}
+	return &sampledMatcher{conditions: newGeneralMatcher(spec), sampler: sampler}
+}
+
+// sampledMatcher matches requests which match the conditions and then are
+// sampled by the probability policy.
+type sampledMatcher struct {
+	conditions proxies.RequestMatcher
+	sampler    proxies.RequestMatcher
+}
+
+// Match implements protocols.Matcher.
+func (sm *sampledMatcher) Match(req protocols.Request) bool {
+	return sm.conditions.Match(req) && sm.sampler.Match(req)
 }
 
 // generalMatcher implements general HTTP matcher.
 type generalMatcher struct {
 	matchAllHeaders bool
 	headers         map[string]*stringtool.StringMatcher
+	cookies         map[string]*stringtool.StringMatcher
 	urls            []*MethodAndURLMatcher
 }
 
+func newGeneralMatcher(spec *RequestMatcherSpec) *generalMatcher {
+	matcher := &generalMatcher{
+		matchAllHeaders: spec.MatchAllHeaders,
+		headers:         spec.Headers,
+		cookies:         spec.Cookies,
+		urls:            spec.URLs,
+	}
+	matcher.init()
+	return matcher
+}
+
 func (gm *generalMatcher) init() {
 	for _, h := range gm.headers {
 		h.Init()
 	}
 
+	for _, c := range gm.cookies {
+		c.Init()
+	}
+
 	for _, url := range gm.urls {
 		url.init()
 	}
@@ -85,11 +135,15 @@ func (gm *generalMatcher) Match(req protocols.Request) bool {
 		panic("BUG: not a http request")
 	}
 
-	matched := false
-	if gm.matchAllHeaders {
-		matched = gm.matchAllHeader(httpreq)
-	} else {
-		matched = gm.matchOneHeader(httpreq)
+	// there may be no conditions on headers and cookies when the matcher
+	// is used with a probability policy.
+	matched := true
+	if len(gm.headers) > 0 || len(gm.cookies) > 0 {
+		if gm.matchAllHeaders {
+			matched = gm.matchAllHeader(httpreq) && gm.matchAllCookie(httpreq)
+		} else {
+			matched = gm.matchOneHeader(httpreq) || gm.matchOneCookie(httpreq)
+		}
 	}
 
 	if matched && len(gm.urls) > 0 {
@@ -139,6 +193,31 @@ func (gm *generalMatcher) matchAllHeader(req *httpprot.Request) bool {
 	return true
 }
 
+func cookieValue(req *httpprot.Request, name string) string {
+	if c, err := req.Cookie(name); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+func (gm *generalMatcher) matchOneCookie(req *httpprot.Request) bool {
+	for name, rule := range gm.cookies {
+		if rule.Match(cookieValue(req, name)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (gm *generalMatcher) matchAllCookie(req *httpprot.Request) bool {
+	for name, rule := range gm.cookies {
+		if !rule.Match(cookieValue(req, name)) {
+			return false
+		}
+	}
+	return true
+}
+
 func (gm *generalMatcher) matchURL(req *httpprot.Request) bool {
 	for _, url := range gm.urls {
 		if url.Match(req) {
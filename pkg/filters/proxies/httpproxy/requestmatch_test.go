@@ -162,6 +162,122 @@ func TestGeneralMatche(t *testing.T) {
 	assert.False(rm.Match(req))
 }
 
+func TestCookieMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &RequestMatcherSpec{
+		Cookies: map[string]*stringtool.StringMatcher{
+			"user": {Prefix: "qa-"},
+		},
+	}
+	assert.NoError(spec.Validate())
+	spec.Cookies["user"] = &stringtool.StringMatcher{}
+	assert.Error(spec.Validate())
+
+	rm := NewRequestMatcher(&RequestMatcherSpec{
+		Cookies: map[string]*stringtool.StringMatcher{
+			"user": {Prefix: "qa-"},
+			"env":  {Exact: "shadow"},
+		},
+	})
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/abc", nil)
+	req, _ := httpprot.NewRequest(stdr)
+	assert.False(rm.Match(req))
+
+	stdr.AddCookie(&http.Cookie{Name: "user", Value: "qa-alice"})
+	assert.True(rm.Match(req))
+
+	// match all headers and cookies.
+	rm = NewRequestMatcher(&RequestMatcherSpec{
+		RequestMatcherBaseSpec: proxies.RequestMatcherBaseSpec{
+			MatchAllHeaders: true,
+			Headers: map[string]*stringtool.StringMatcher{
+				"X-Test": {Exact: "test"},
+			},
+		},
+		Cookies: map[string]*stringtool.StringMatcher{
+			"user": {Prefix: "qa-"},
+		},
+	})
+	assert.False(rm.Match(req))
+	stdr.Header.Set("X-Test", "test")
+	assert.True(rm.Match(req))
+}
+
+func TestSampledMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/abc", nil)
+	req, _ := httpprot.NewRequest(stdr)
+
+	// all requests matching the conditions are sampled.
+	spec := &RequestMatcherSpec{
+		RequestMatcherBaseSpec: proxies.RequestMatcherBaseSpec{
+			Policy: "random",
+			Permil: 1000,
+			Headers: map[string]*stringtool.StringMatcher{
+				"X-Test-User": {Prefix: "qa-"},
+			},
+		},
+	}
+	assert.NoError(spec.Validate())
+	rm := NewRequestMatcher(spec)
+	assert.False(rm.Match(req))
+	stdr.Header.Set("X-Test-User", "qa-alice")
+	assert.True(rm.Match(req))
+
+	// no requests are sampled.
+	spec.Permil = 0
+	rm = NewRequestMatcher(spec)
+	assert.False(rm.Match(req))
+
+	// the urls are conditions as well.
+	spec = &RequestMatcherSpec{
+		RequestMatcherBaseSpec: proxies.RequestMatcherBaseSpec{
+			Policy: "random",
+			Permil: 1000,
+		},
+		URLs: []*MethodAndURLMatcher{
+			{URL: &stringtool.StringMatcher{Exact: "/abcd"}},
+		},
+	}
+	rm = NewRequestMatcher(spec)
+	assert.False(rm.Match(req))
+	spec.URLs[0].URL = &stringtool.StringMatcher{Exact: "/abc"}
+	rm = NewRequestMatcher(spec)
+	assert.True(rm.Match(req))
+
+	// sampled by the hash of the header, so the result is deterministic.
+	spec = &RequestMatcherSpec{
+		RequestMatcherBaseSpec: proxies.RequestMatcherBaseSpec{
+			Policy:        "headerHash",
+			HeaderHashKey: "X-Test-User",
+			Permil:        500,
+		},
+		Cookies: map[string]*stringtool.StringMatcher{
+			"env": {Exact: "qa"},
+		},
+	}
+	rm = NewRequestMatcher(spec)
+	assert.False(rm.Match(req))
+	stdr.AddCookie(&http.Cookie{Name: "env", Value: "qa"})
+	first := rm.Match(req)
+	for i := 0; i < 10; i++ {
+		assert.Equal(first, rm.Match(req))
+	}
+
+	// no conditions.
+	rm = NewRequestMatcher(&RequestMatcherSpec{
+		RequestMatcherBaseSpec: proxies.RequestMatcherBaseSpec{
+			Policy: "random",
+			Permil: 1000,
+		},
+	})
+	_, ok := rm.(*sampledMatcher)
+	assert.False(ok)
+}
+
 func TestMethodAndURLMatcher(t *testing.T) {
 	assert := assert.New(t)
 
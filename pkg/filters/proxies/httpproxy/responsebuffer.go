@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"sync/atomic"
+
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const defaultResponseBufferMaxSize = 1024 * 1024
+
+type (
+	// ResponseBufferingSpec is the spec to buffer the backend responses
+	// which are otherwise streamed, that's when serverMaxBodySize is -1.
+	//
+	// A response not larger than MaxSize is read into memory before it is
+	// sent to the client, so the backend connection is released at once,
+	// no matter how slow the client reads. Larger responses fall back to
+	// streaming.
+	ResponseBufferingSpec struct {
+		// MaxSize is the max size of the responses to buffer, default is
+		// 1MiB.
+		MaxSize int64 `json:"maxSize,omitempty" jsonschema:"minimum=1"`
+	}
+
+	// ResponseBufferingStatus is the number of responses buffered and
+	// streamed.
+	ResponseBufferingStatus struct {
+		Buffered uint64 `json:"buffered"`
+		Streamed uint64 `json:"streamed"`
+	}
+
+	responseBuffer struct {
+		maxSize  int64
+		buffered uint64
+		streamed uint64
+	}
+)
+
+func newResponseBuffer(spec *ResponseBufferingSpec) *responseBuffer {
+	rb := &responseBuffer{maxSize: spec.MaxSize}
+	if rb.maxSize <= 0 {
+		rb.maxSize = defaultResponseBufferMaxSize
+	}
+	return rb
+}
+
+// fetchPayload reads the payload of resp into memory if it is not larger
+// than maxSize, and treats it as a stream otherwise.
+func (rb *responseBuffer) fetchPayload(resp *httpprot.Response) error {
+	if err := resp.BufferPayload(rb.maxSize); err != nil {
+		return err
+	}
+	if resp.IsStream() {
+		atomic.AddUint64(&rb.streamed, 1)
+	} else {
+		atomic.AddUint64(&rb.buffered, 1)
+	}
+	return nil
+}
+
+func (rb *responseBuffer) status() *ResponseBufferingStatus {
+	return &ResponseBufferingStatus{
+		Buffered: atomic.LoadUint64(&rb.buffered),
+		Streamed: atomic.LoadUint64(&rb.streamed),
+	}
+}
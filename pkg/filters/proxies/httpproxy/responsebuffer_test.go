@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/resilience"
+)
+
+type closeTracker struct {
+	io.Reader
+	closed int32
+}
+
+func (c *closeTracker) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return nil
+}
+
+func TestResponseBuffering(t *testing.T) {
+	assert := assert.New(t)
+
+	var body *closeTracker
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		body = &closeTracker{Reader: strings.NewReader(r.URL.Query().Get("body"))}
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{},
+			Body:          body,
+			ContentLength: -1,
+		}, nil
+	}
+
+	proxy := newTestProxy(`
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+  serverMaxBodySize: -1
+  responseBuffering:
+    maxSize: 10
+`, assert)
+	defer proxy.Close()
+	proxy.InjectResiliencePolicy(make(map[string]resilience.Policy))
+
+	handle := func(payload string) *httpprot.Response {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/?body="+payload, nil)
+		ctx := getCtx(stdr)
+		assert.Equal("", proxy.Handle(ctx))
+		return ctx.GetOutputResponse().(*httpprot.Response)
+	}
+
+	// the response is buffered, and the backend body is closed before the
+	// response is sent to the client.
+	resp := handle("0123456789")
+	assert.False(resp.IsStream())
+	assert.Equal("0123456789", string(resp.RawPayload()))
+	assert.Equal(int32(1), atomic.LoadInt32(&body.closed))
+
+	// the response is streamed.
+	resp = handle("0123456789a")
+	assert.True(resp.IsStream())
+	assert.Equal(int32(0), atomic.LoadInt32(&body.closed))
+	data, _ := io.ReadAll(resp.GetPayload())
+	assert.Equal("0123456789a", string(data))
+
+	status := proxy.mainPool.status().ResponseBuffering
+	assert.Equal(uint64(1), status.Buffered)
+	assert.Equal(uint64(1), status.Streamed)
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+)
+
+const (
+	// ResponseHeaderLimitReject fails the request with 502 if the
+	// response headers exceed the limits.
+	ResponseHeaderLimitReject = "reject"
+	// ResponseHeaderLimitStrip removes the header fields exceeding
+	// MaxHeaderSize, the request still fails if the remaining headers
+	// exceed MaxCount or MaxTotalSize.
+	ResponseHeaderLimitStrip = "strip"
+
+	defaultMaxResponseHeaderTotalSize = 64 * 1024
+	defaultMaxResponseHeaderSize      = 16 * 1024
+	defaultMaxResponseHeaderCount     = 100
+)
+
+type (
+	// ResponseHeaderLimitsSpec limits the headers of backend responses,
+	// to prevent backends emitting huge headers from breaking clients.
+	// The size of a header field is the length of its name plus the
+	// length of its value, and every value of a multi-value header is a
+	// field.
+	ResponseHeaderLimitsSpec struct {
+		// MaxTotalSize is the max total size of all header fields,
+		// default is 64KiB.
+		MaxTotalSize int `json:"maxTotalSize,omitempty" jsonschema:"minimum=1"`
+		// MaxHeaderSize is the max size of a header field, default is
+		// 16KiB.
+		MaxHeaderSize int `json:"maxHeaderSize,omitempty" jsonschema:"minimum=1"`
+		// MaxCount is the max number of header fields, default is 100.
+		MaxCount int `json:"maxCount,omitempty" jsonschema:"minimum=1"`
+		// Action is reject or strip, default is reject.
+		Action string `json:"action,omitempty" jsonschema:"enum=,enum=reject,enum=strip"`
+	}
+
+	// ResponseHeaderLimitStatus is the number of responses whose headers
+	// exceed the limits.
+	ResponseHeaderLimitStatus struct {
+		Stripped uint64 `json:"stripped"`
+		Rejected uint64 `json:"rejected"`
+	}
+
+	responseHeaderLimiter struct {
+		maxTotalSize  int
+		maxHeaderSize int
+		maxCount      int
+		strip         bool
+
+		stripped uint64
+		rejected uint64
+	}
+)
+
+// Validate validates the ResponseHeaderLimitsSpec.
+func (spec *ResponseHeaderLimitsSpec) Validate() error {
+	total := spec.MaxTotalSize
+	if total <= 0 {
+		total = defaultMaxResponseHeaderTotalSize
+	}
+	if spec.MaxHeaderSize > total {
+		return fmt.Errorf("maxHeaderSize must not exceed maxTotalSize")
+	}
+	return nil
+}
+
+func newResponseHeaderLimiter(spec *ResponseHeaderLimitsSpec) *responseHeaderLimiter {
+	l := &responseHeaderLimiter{
+		maxTotalSize:  spec.MaxTotalSize,
+		maxHeaderSize: spec.MaxHeaderSize,
+		maxCount:      spec.MaxCount,
+		strip:         spec.Action == ResponseHeaderLimitStrip,
+	}
+	if l.maxTotalSize <= 0 {
+		l.maxTotalSize = defaultMaxResponseHeaderTotalSize
+	}
+	if l.maxHeaderSize <= 0 {
+		l.maxHeaderSize = defaultMaxResponseHeaderSize
+	}
+	if l.maxCount <= 0 {
+		l.maxCount = defaultMaxResponseHeaderCount
+	}
+	return l
+}
+
+// check checks the headers against the limits, the oversized fields are
+// removed if the action is strip. It returns the names of the removed
+// headers, and false if the response should be rejected.
+func (l *responseHeaderLimiter) check(h http.Header) ([]string, bool) {
+	var stripped []string
+	total, count := 0, 0
+	for name, values := range h {
+		oversized := false
+		for _, v := range values {
+			size := len(name) + len(v)
+			if size > l.maxHeaderSize {
+				oversized = true
+				break
+			}
+		}
+		if oversized {
+			if !l.strip {
+				return nil, false
+			}
+			stripped = append(stripped, name)
+			continue
+		}
+		for _, v := range values {
+			total += len(name) + len(v)
+			count++
+		}
+	}
+
+	if count > l.maxCount || total > l.maxTotalSize {
+		return nil, false
+	}
+	for _, name := range stripped {
+		h.Del(name)
+	}
+	return stripped, true
+}
+
+func (l *responseHeaderLimiter) status() *ResponseHeaderLimitStatus {
+	return &ResponseHeaderLimitStatus{
+		Stripped: atomic.LoadUint64(&l.stripped),
+		Rejected: atomic.LoadUint64(&l.rejected),
+	}
+}
+
+// enforceResponseHeaderLimits enforces the limits on the headers of the
+// backend response, it returns an error if the response is rejected.
+func (sp *ServerPool) enforceResponseHeaderLimits(spCtx *serverPoolContext) error {
+	l := sp.responseHeaderLimiter
+	stripped, ok := l.check(spCtx.stdResp.Header)
+	if !ok {
+		atomic.AddUint64(&l.rejected, 1)
+		sp.countResponseHeaderLimit(ResponseHeaderLimitReject)
+		logger.Warnf("%s: response headers of the backend exceed the limits", sp.Name)
+		spCtx.LazyAddTag(func() string {
+			return "response headers exceed the limits"
+		})
+		spCtx.stdResp.Body.Close()
+		return serverPoolError{http.StatusBadGateway, resultServerError}
+	}
+
+	if len(stripped) > 0 {
+		atomic.AddUint64(&l.stripped, 1)
+		sp.countResponseHeaderLimit(ResponseHeaderLimitStrip)
+		spCtx.LazyAddTag(func() string {
+			return fmt.Sprintf("oversized response headers stripped: %v", stripped)
+		})
+	}
+	return nil
+}
+
+func (sp *ServerPool) countResponseHeaderLimit(action string) {
+	labels := sp.metricLabels()
+	labels["action"] = action
+	sp.metrics.ResponseHeaderLimits.With(labels).Inc()
+}
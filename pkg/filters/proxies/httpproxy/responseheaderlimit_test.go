@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/resilience"
+)
+
+func TestResponseHeaderLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	l := newResponseHeaderLimiter(&ResponseHeaderLimitsSpec{})
+	assert.Equal(defaultMaxResponseHeaderTotalSize, l.maxTotalSize)
+	assert.Equal(defaultMaxResponseHeaderSize, l.maxHeaderSize)
+	assert.Equal(defaultMaxResponseHeaderCount, l.maxCount)
+	assert.False(l.strip)
+
+	l = newResponseHeaderLimiter(&ResponseHeaderLimitsSpec{MaxTotalSize: 25, MaxHeaderSize: 10, MaxCount: 3})
+	_, ok := l.check(http.Header{"X-A": {"1234567"}, "X-B": {"1", "2"}})
+	assert.True(ok)
+	_, ok = l.check(http.Header{"X-A": {"12345678"}})
+	assert.False(ok)
+	_, ok = l.check(http.Header{"X-A": {"1", "2", "3", "4"}})
+	assert.False(ok)
+	_, ok = l.check(http.Header{"X-A": {"1234567"}, "X-B": {"1234567"}, "X-C": {"1234567"}})
+	assert.False(ok)
+
+	l.strip = true
+	h := http.Header{"X-A": {"1", "12345678"}, "X-B": {"1"}}
+	stripped, ok := l.check(h)
+	assert.True(ok)
+	assert.Equal([]string{"X-A"}, stripped)
+	assert.Equal(http.Header{"X-B": {"1"}}, h)
+
+	// the headers are not changed if the response is rejected.
+	h = http.Header{"X-A": {"12345678"}, "X-B": {"1", "2", "3", "4"}}
+	_, ok = l.check(h)
+	assert.False(ok)
+	assert.Len(h, 2)
+
+	assert.Error((&ResponseHeaderLimitsSpec{MaxTotalSize: 10, MaxHeaderSize: 20}).Validate())
+}
+
+func TestResponseHeaderLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header: http.Header{
+				"Content-Type": {"text/plain"},
+				"X-Debug":      {strings.Repeat("d", 100)},
+			},
+			Body:          io.NopCloser(strings.NewReader("ok")),
+			ContentLength: 2,
+		}, nil
+	}
+
+	proxy := newTestProxy(`
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+  responseHeaderLimits:
+    maxHeaderSize: 64
+- servers:
+  - url: http://127.0.0.1:9095
+  filter:
+    headers:
+      X-Pool:
+        exact: strip
+  responseHeaderLimits:
+    maxHeaderSize: 64
+    action: strip
+`, assert)
+	defer proxy.Close()
+	proxy.InjectResiliencePolicy(make(map[string]resilience.Policy))
+
+	handle := func(pool string) (string, *httpprot.Response) {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+		stdr.Header.Set("X-Pool", pool)
+		ctx := getCtx(stdr)
+		result := proxy.Handle(ctx)
+		resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+		return result, resp
+	}
+
+	result, resp := handle("")
+	assert.Equal(resultServerError, result)
+	assert.Equal(http.StatusBadGateway, resp.StatusCode())
+	assert.Equal(uint64(1), proxy.mainPool.status().ResponseHeaders.Rejected)
+
+	result, resp = handle("strip")
+	assert.Equal("", result)
+	assert.Equal(http.StatusOK, resp.StatusCode())
+	assert.Equal("", resp.HTTPHeader().Get("X-Debug"))
+	assert.Equal("text/plain", resp.HTTPHeader().Get("Content-Type"))
+	assert.Equal(uint64(1), proxy.candidatePools[0].status().ResponseHeaders.Stripped)
+}
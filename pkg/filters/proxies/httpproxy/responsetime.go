@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	stdcontext "context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters/proxies"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+// handleWithResponseTimeLimit handles the request, but stops waiting for
+// the response of the backend when the response time limit is exceeded.
+func (sp *ServerPool) handleWithResponseTimeLimit(spCtx *serverPoolContext, limit *proxies.ResponseTimeLimit) string {
+	remaining := limit.Remaining()
+	if remaining <= 0 {
+		return sp.exceedResponseTimeLimit(spCtx, limit)
+	}
+
+	// the body of a stream request can't be read by a background request
+	// after the limit is exceeded, so the request is always cancelled.
+	if !limit.CompleteInBackground || spCtx.req.IsStream() {
+		stdctx, cancel := stdcontext.WithTimeout(spCtx.req.Context(), remaining)
+		defer cancel()
+
+		result := sp.handleRequest(spCtx, stdctx)
+		if result != "" && stdctx.Err() == stdcontext.DeadlineExceeded {
+			return sp.exceedResponseTimeLimit(spCtx, limit)
+		}
+		return result
+	}
+
+	// the request is sent with a separate context, which is not cancelled
+	// when the client goes away, so that it could complete in the
+	// background without touching the context of the client request.
+	bgCtx := context.New(spCtx.Span())
+	bgCtx.SetInputRequest(spCtx.req)
+	bgSpCtx := &serverPoolContext{Context: bgCtx, req: spCtx.req}
+	stdctx := stdcontext.WithoutCancel(spCtx.req.Context())
+
+	var mu sync.Mutex
+	abandoned := false
+	done := make(chan string, 1)
+	go func() {
+		result := sp.handleRequest(bgSpCtx, stdctx)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if !abandoned {
+			done <- result
+			return
+		}
+		// the response is already in the cache if caching is enabled.
+		if resp := bgCtx.GetOutputResponse(); resp != nil {
+			resp.Close()
+		}
+	}()
+
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+
+	select {
+	case result := <-done:
+		sp.adoptResponse(spCtx, bgCtx)
+		return result
+	case <-timer.C:
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	select {
+	case result := <-done:
+		sp.adoptResponse(spCtx, bgCtx)
+		return result
+	default:
+		abandoned = true
+		return sp.exceedResponseTimeLimit(spCtx, limit)
+	}
+}
+
+// adoptResponse sets the response received by the background context to
+// the context of the client request.
+func (sp *ServerPool) adoptResponse(spCtx *serverPoolContext, bgCtx *context.Context) {
+	resp, _ := bgCtx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		return
+	}
+	if r, _ := spCtx.GetOutputResponse().(*httpprot.Response); r != nil {
+		sp.mergeResponseHeader(resp.HTTPHeader(), r.HTTPHeader())
+	}
+	spCtx.SetOutputResponse(resp)
+}
+
+func (sp *ServerPool) exceedResponseTimeLimit(spCtx *serverPoolContext, limit *proxies.ResponseTimeLimit) string {
+	limit.Exceed()
+	spCtx.AddTag("response time limit exceeded")
+	sp.buildFailureResponse(spCtx, http.StatusGatewayTimeout)
+	return resultResponseTimeExceeded
+}
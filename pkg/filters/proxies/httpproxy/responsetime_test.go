@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/filters/proxies"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/resilience"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseTimeLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	var completed, cancelled int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fast" {
+			w.Write([]byte("fast"))
+			return
+		}
+		select {
+		case <-time.After(200 * time.Millisecond):
+			w.Write([]byte("slow"))
+			atomic.AddInt32(&completed, 1)
+		case <-r.Context().Done():
+			atomic.AddInt32(&cancelled, 1)
+		}
+	}))
+	defer server.Close()
+
+	proxy := newTestProxy(fmt.Sprintf(`
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: %s
+`, server.URL), assert)
+	defer proxy.Close()
+	proxy.InjectResiliencePolicy(make(map[string]resilience.Policy))
+
+	handle := func(path string, completeInBackground bool) (string, *proxies.ResponseTimeLimit, *httpprot.Response) {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1"+path, nil)
+		ctx := getCtx(stdr)
+		limit := proxies.NewResponseTimeLimit(50*time.Millisecond, completeInBackground)
+		ctx.SetData(proxies.ResponseTimeLimitKey, limit)
+
+		start := time.Now()
+		result := proxy.Handle(ctx)
+		assert.Less(time.Since(start), 150*time.Millisecond)
+		return result, limit, ctx.GetOutputResponse().(*httpprot.Response)
+	}
+
+	for _, background := range []bool{false, true} {
+		result, limit, resp := handle("/fast", background)
+		assert.Equal("", result)
+		assert.False(limit.Exceeded())
+		body, _ := io.ReadAll(resp.GetPayload())
+		assert.Equal("fast", string(body))
+
+		result, limit, resp = handle("/slow", background)
+		assert.Equal(resultResponseTimeExceeded, result)
+		assert.True(limit.Exceeded())
+		assert.Equal(http.StatusGatewayTimeout, resp.StatusCode())
+	}
+
+	// the first slow request is cancelled, the second completes in the
+	// background.
+	assert.Eventually(func() bool {
+		return atomic.LoadInt32(&cancelled) == 1 && atomic.LoadInt32(&completed) == 1
+	}, time.Second, 10*time.Millisecond)
+}
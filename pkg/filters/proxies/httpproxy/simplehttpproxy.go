@@ -47,6 +47,7 @@ var simpleHTTPProxyKind = &filters.Kind{
 		resultFailureCode,
 		resultTimeout,
 	},
+	RequestOnly: true,
 	DefaultSpec: func() filters.Spec {
 		return &SimpleHTTPProxySpec{}
 	},
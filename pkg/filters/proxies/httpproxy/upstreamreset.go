@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// UpstreamResetAbort aborts the connection to the client if the
+	// backend resets the connection after the response is flushed.
+	UpstreamResetAbort = "abort"
+	// UpstreamResetEnd ends the response normally, the client gets a
+	// truncated response.
+	UpstreamResetEnd = "end"
+
+	upstreamResetBeforeFlush = "beforeFlush"
+	upstreamResetAfterFlush  = "afterFlush"
+)
+
+type (
+	// UpstreamResetSpec is the spec to handle the connection reset by the
+	// backend in the middle of the response.
+	//
+	// If the response is not flushed to the client yet, that's the body
+	// is being read by the pool, a failure response is returned, and the
+	// request could be retried by the retry policy, or handled by a
+	// fallback filter with the result. Otherwise, the status code and
+	// headers of the response have been sent, the response of a stream
+	// body is terminated according to AfterFlush.
+	UpstreamResetSpec struct {
+		// StatusCode is the status code of the failure response, default
+		// is 502.
+		StatusCode int `json:"statusCode,omitempty" jsonschema:"minimum=400,maximum=599"`
+		// AfterFlush is abort or end, default is abort.
+		AfterFlush string `json:"afterFlush,omitempty" jsonschema:"enum=,enum=abort,enum=end"`
+	}
+
+	// UpstreamResetStatus is the number of connections reset by the
+	// backend, before and after the response is flushed.
+	UpstreamResetStatus struct {
+		BeforeFlush uint64 `json:"beforeFlush"`
+		AfterFlush  uint64 `json:"afterFlush"`
+	}
+
+	// resetReader wraps the body of the backend response to detect
+	// connection resets after the response is flushed.
+	resetReader struct {
+		io.ReadCloser
+		pool  *ServerPool
+		spCtx *serverPoolContext
+		// streaming is set after the response is built, errors before
+		// that are handled by the pool.
+		streaming bool
+	}
+)
+
+func (spec *UpstreamResetSpec) statusCode() int {
+	if spec.StatusCode == 0 {
+		return http.StatusBadGateway
+	}
+	return spec.StatusCode
+}
+
+func (spec *UpstreamResetSpec) abort() bool {
+	return spec.AfterFlush != UpstreamResetEnd
+}
+
+// isUpstreamReset reports whether err, which is returned when reading the
+// body of the backend response, is caused by the backend. Errors caused by
+// the cancellation of the request are not.
+func isUpstreamReset(stdctx stdcontext.Context, err error) bool {
+	if err == nil || err == io.EOF || err == httpprot.ErrResponseEntityTooLarge {
+		return false
+	}
+	if stdctx.Err() != nil {
+		return false
+	}
+	return !errors.Is(err, stdcontext.Canceled) && !errors.Is(err, stdcontext.DeadlineExceeded)
+}
+
+func (sp *ServerPool) countUpstreamReset(phase string) {
+	if phase == upstreamResetBeforeFlush {
+		atomic.AddUint64(&sp.upstreamResets.BeforeFlush, 1)
+	} else {
+		atomic.AddUint64(&sp.upstreamResets.AfterFlush, 1)
+	}
+	labels := sp.metricLabels()
+	labels["phase"] = phase
+	sp.metrics.UpstreamResets.With(labels).Inc()
+}
+
+// handleUpstreamReset handles the connection reset by the backend before
+// the response is flushed.
+func (sp *ServerPool) handleUpstreamReset(spCtx *serverPoolContext, err error) error {
+	logger.Errorf("%s: connection reset by backend before response flushed: %v", sp.Name, err)
+	sp.countUpstreamReset(upstreamResetBeforeFlush)
+	spCtx.LazyAddTag(func() string {
+		return fmt.Sprintf("upstream reset before flush: %v", err)
+	})
+
+	// keep the previous behavior if not configured.
+	if sp.spec.UpstreamReset == nil {
+		return serverPoolError{http.StatusInternalServerError, resultInternalError}
+	}
+	return serverPoolError{sp.spec.UpstreamReset.statusCode(), resultUpstreamReset}
+}
+
+func (r *resetReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if !r.streaming || !isUpstreamReset(r.spCtx.req.Context(), err) {
+		return n, err
+	}
+
+	sp := r.pool
+	logger.Errorf("%s: connection reset by backend after response flushed: %v", sp.Name, err)
+	sp.countUpstreamReset(upstreamResetAfterFlush)
+	r.spCtx.LazyAddTag(func() string {
+		return fmt.Sprintf("upstream reset after flush: %v", err)
+	})
+
+	if spec := sp.spec.UpstreamReset; spec != nil && spec.abort() {
+		err = fmt.Errorf("%w: %w", httpprot.ErrResponseAborted, err)
+	}
+	return n, err
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/resilience"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamReset(t *testing.T) {
+	assert := assert.New(t)
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		body := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(syscall.ECONNRESET))
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{},
+			Body:          io.NopCloser(body),
+			ContentLength: -1,
+		}, nil
+	}
+
+	proxy := newTestProxy(`
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+- servers:
+  - url: http://127.0.0.1:9095
+  filter:
+    headers:
+      X-Pool:
+        exact: reset
+  upstreamReset:
+    statusCode: 503
+- servers:
+  - url: http://127.0.0.1:9095
+  filter:
+    headers:
+      X-Pool:
+        exact: stream
+  serverMaxBodySize: -1
+  upstreamReset: {}
+- servers:
+  - url: http://127.0.0.1:9095
+  filter:
+    headers:
+      X-Pool:
+        exact: end
+  serverMaxBodySize: -1
+  upstreamReset:
+    afterFlush: end
+`, assert)
+	defer proxy.Close()
+	proxy.InjectResiliencePolicy(make(map[string]resilience.Policy))
+
+	handle := func(pool string) (string, *httpprot.Response) {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+		stdr.Header.Set("X-Pool", pool)
+		ctx := getCtx(stdr)
+		result := proxy.Handle(ctx)
+		resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+		return result, resp
+	}
+
+	// reset before flush, the previous behavior is kept if not configured.
+	result, resp := handle("")
+	assert.Equal(resultInternalError, result)
+	assert.Equal(http.StatusInternalServerError, resp.StatusCode())
+	assert.Equal(uint64(1), proxy.mainPool.status().UpstreamResets.BeforeFlush)
+
+	result, resp = handle("reset")
+	assert.Equal(resultUpstreamReset, result)
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode())
+
+	// reset after flush.
+	result, resp = handle("stream")
+	assert.Equal("", result)
+	data, err := io.ReadAll(resp.GetPayload())
+	assert.Equal("partial", string(data))
+	assert.True(errors.Is(err, httpprot.ErrResponseAborted))
+	assert.True(errors.Is(err, syscall.ECONNRESET))
+	assert.Equal(uint64(1), proxy.candidatePools[1].status().UpstreamResets.AfterFlush)
+
+	result, resp = handle("end")
+	assert.Equal("", result)
+	_, err = io.ReadAll(resp.GetPayload())
+	assert.False(errors.Is(err, httpprot.ErrResponseAborted))
+	assert.True(errors.Is(err, syscall.ECONNRESET))
+
+	// the body is complete.
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{},
+			Body:          io.NopCloser(strings.NewReader("complete")),
+			ContentLength: -1,
+		}, nil
+	}
+	result, resp = handle("stream")
+	assert.Equal("", result)
+	data, err = io.ReadAll(resp.GetPayload())
+	assert.NoError(err)
+	assert.Equal("complete", string(data))
+	assert.Equal(uint64(1), proxy.candidatePools[1].status().UpstreamResets.AfterFlush)
+}
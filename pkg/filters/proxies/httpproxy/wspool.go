@@ -202,6 +202,8 @@ func (sp *WebSocketServerPool) handle(ctx *context.Context) (result string) {
 		metric.StatusCode = http.StatusServiceUnavailable
 		return resultInternalError
 	}
+	svr.IncInflight()
+	defer svr.DecInflight()
 
 	stdw, _ := ctx.GetData("HTTP_RESPONSE_WRITER").(http.ResponseWriter)
 	if stdw == nil {
@@ -319,7 +321,9 @@ func (sp *WebSocketServerPool) handle(ctx *context.Context) (result string) {
 }
 
 func (sp *WebSocketServerPool) status() *ServerPoolStatus {
-	return &ServerPoolStatus{
-		Stat: sp.httpStat.Status(),
+	s := &ServerPoolStatus{Stat: sp.httpStat.Status()}
+	if glb, ok := sp.LoadBalancer().(*proxies.GeneralLoadBalancer); ok {
+		s.Inflights = glb.Inflights()
 	}
+	return s
 }
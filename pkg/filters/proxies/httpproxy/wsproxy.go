@@ -36,6 +36,7 @@ var kindWebSocketProxy = &filters.Kind{
 		resultInternalError,
 		resultClientError,
 	},
+	RequestOnly: true,
 	DefaultSpec: func() filters.Spec {
 		return &WebSocketProxySpec{}
 	},
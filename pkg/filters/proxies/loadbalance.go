@@ -21,6 +21,8 @@ import (
 	"fmt"
 	"hash/fnv"
 	"math/rand"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -42,6 +44,12 @@ const (
 	// LoadBalancePolicyCookieHash is the load balance policy of HTTP cookie hash,
 	// which is the shorthand of headerHash with hash key Set-Cookie.
 	LoadBalancePolicyCookieHash = "cookieHash"
+	// LoadBalancePolicyPowerOfTwoChoices is the load balance policy of
+	// power of two choices.
+	LoadBalancePolicyPowerOfTwoChoices = "powerOfTwoChoices"
+	// LoadBalancePolicyLeastConnections is the load balance policy of
+	// least connections.
+	LoadBalancePolicyLeastConnections = "leastConnections"
 )
 
 // LoadBalancer is the interface of a load balancer.
@@ -61,6 +69,12 @@ type LoadBalanceSpec struct {
 	HeaderHashKey string             `json:"headerHashKey,omitempty"`
 	ForwardKey    string             `json:"forwardKey,omitempty"`
 	StickySession *StickySessionSpec `json:"stickySession,omitempty"`
+	DynamicWeight *DynamicWeightSpec `json:"dynamicWeight,omitempty"`
+	// SubsetHeader is the request header carrying the subset of the
+	// request, which is set by the SubsetRouter filter. Only the servers
+	// tagged with the subset are chosen, all servers are chosen if the
+	// header is empty or no healthy server is tagged with the subset.
+	SubsetHeader string `json:"subsetHeader,omitempty"`
 	// Deprecated: HealthCheck is protocol related. It should be moved to protocol spec.
 	// This one is kept for backward compatibility.
 	HealthCheck *HealthCheckSpec `json:"healthCheck,omitempty"`
@@ -71,11 +85,71 @@ type LoadBalancePolicy interface {
 	ChooseServer(req protocols.Request, sg *ServerGroup) *Server
 }
 
+// LoadBalancePolicyCreator creates a load balance policy from the spec.
+type LoadBalancePolicyCreator func(spec *LoadBalanceSpec) LoadBalancePolicy
+
+// loadBalancePolicies are the registered load balance policies, it is
+// only updated in init functions, so it is not protected by a lock.
+var loadBalancePolicies = map[string]LoadBalancePolicyCreator{}
+
+func init() {
+	RegisterLoadBalancePolicy(LoadBalancePolicyRoundRobin, func(spec *LoadBalanceSpec) LoadBalancePolicy {
+		return &RoundRobinLoadBalancePolicy{}
+	})
+	RegisterLoadBalancePolicy(LoadBalancePolicyRandom, func(spec *LoadBalanceSpec) LoadBalancePolicy {
+		return &RandomLoadBalancePolicy{}
+	})
+	RegisterLoadBalancePolicy(LoadBalancePolicyWeightedRandom, func(spec *LoadBalanceSpec) LoadBalancePolicy {
+		return &WeightedRandomLoadBalancePolicy{}
+	})
+	RegisterLoadBalancePolicy(LoadBalancePolicyIPHash, func(spec *LoadBalanceSpec) LoadBalancePolicy {
+		return &IPHashLoadBalancePolicy{}
+	})
+	RegisterLoadBalancePolicy(LoadBalancePolicyHeaderHash, func(spec *LoadBalanceSpec) LoadBalancePolicy {
+		return &HeaderHashLoadBalancePolicy{spec: spec}
+	})
+	RegisterLoadBalancePolicy(LoadBalancePolicyCookieHash, func(spec *LoadBalanceSpec) LoadBalancePolicy {
+		return &HeaderHashLoadBalancePolicy{spec: &LoadBalanceSpec{HeaderHashKey: "Cookie"}}
+	})
+	RegisterLoadBalancePolicy(LoadBalancePolicyPowerOfTwoChoices, func(spec *LoadBalanceSpec) LoadBalancePolicy {
+		return &PowerOfTwoChoicesLoadBalancePolicy{}
+	})
+	RegisterLoadBalancePolicy(LoadBalancePolicyLeastConnections, func(spec *LoadBalanceSpec) LoadBalancePolicy {
+		return &LeastConnectionsLoadBalancePolicy{}
+	})
+}
+
+// RegisterLoadBalancePolicy registers a load balance policy, so that it
+// can be referred to by name in LoadBalanceSpec. It should be called in
+// init functions, and it panics if the name is empty or registered.
+func RegisterLoadBalancePolicy(name string, creator LoadBalancePolicyCreator) {
+	if name == "" {
+		panic(fmt.Errorf("empty load balance policy name"))
+	}
+	if _, ok := loadBalancePolicies[name]; ok {
+		panic(fmt.Errorf("load balance policy %s is registered", name))
+	}
+	loadBalancePolicies[name] = creator
+}
+
+// LoadBalancePolicies returns the names of the registered load balance
+// policies in order.
+func LoadBalancePolicies() []string {
+	names := make([]string, 0, len(loadBalancePolicies))
+	for name := range loadBalancePolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // GeneralLoadBalancer implements a general purpose load balancer.
 type GeneralLoadBalancer struct {
 	spec           *LoadBalanceSpec
 	servers        []*Server
 	healthyServers atomic.Pointer[ServerGroup]
+	// mutex serializes the updates of healthyServers.
+	mutex sync.Mutex
 
 	done chan struct{}
 
@@ -83,6 +157,9 @@ type GeneralLoadBalancer struct {
 	ss     SessionSticker
 	hc     HealthChecker
 	hcSpec *HealthCheckSpec
+	dw     *dynamicWeighter
+
+	healthListener func(svr *Server, healthy bool)
 }
 
 // NewGeneralLoadBalancer creates a new GeneralLoadBalancer.
@@ -95,6 +172,12 @@ func NewGeneralLoadBalancer(spec *LoadBalanceSpec, servers []*Server) *GeneralLo
 	return lb
 }
 
+// SetHealthListener sets the function to be called when a server becomes
+// healthy or unhealthy, it must be called before Init.
+func (glb *GeneralLoadBalancer) SetHealthListener(fn func(svr *Server, healthy bool)) {
+	glb.healthListener = fn
+}
+
 // Init initializes the load balancer.
 func (glb *GeneralLoadBalancer) Init(
 	fnNewSessionSticker func(*StickySessionSpec) SessionSticker,
@@ -103,23 +186,16 @@ func (glb *GeneralLoadBalancer) Init(
 ) {
 	// load balance policy
 	if lbp == nil {
-		switch glb.spec.Policy {
-		case LoadBalancePolicyRoundRobin, "":
-			lbp = &RoundRobinLoadBalancePolicy{}
-		case LoadBalancePolicyRandom:
-			lbp = &RandomLoadBalancePolicy{}
-		case LoadBalancePolicyWeightedRandom:
-			lbp = &WeightedRandomLoadBalancePolicy{}
-		case LoadBalancePolicyIPHash:
-			lbp = &IPHashLoadBalancePolicy{}
-		case LoadBalancePolicyHeaderHash:
-			lbp = &HeaderHashLoadBalancePolicy{spec: glb.spec}
-		case LoadBalancePolicyCookieHash:
-			lbp = &HeaderHashLoadBalancePolicy{spec: &LoadBalanceSpec{HeaderHashKey: "Cookie"}}
-		default:
-			logger.Errorf("unsupported load balancing policy: %s", glb.spec.Policy)
-			lbp = &RoundRobinLoadBalancePolicy{}
+		policy := glb.spec.Policy
+		if policy == "" {
+			policy = LoadBalancePolicyRoundRobin
 		}
+		creator := loadBalancePolicies[policy]
+		if creator == nil {
+			logger.Errorf("unsupported load balancing policy: %s", policy)
+			creator = loadBalancePolicies[LoadBalancePolicyRoundRobin]
+		}
+		lbp = creator(glb.spec)
 	}
 	glb.lbp = lbp
 
@@ -130,6 +206,12 @@ func (glb *GeneralLoadBalancer) Init(
 		glb.ss = ss
 	}
 
+	// dynamic weight
+	if glb.spec.DynamicWeight != nil {
+		glb.dw = newDynamicWeighter(glb.spec.DynamicWeight, glb.servers)
+		glb.startAdjustWeights()
+	}
+
 	if hc == nil {
 		return
 	}
@@ -145,7 +227,9 @@ func (glb *GeneralLoadBalancer) Init(
 	glb.hcSpec = &spec
 
 	ticker := time.NewTicker(spec.GetInterval())
-	glb.done = make(chan struct{})
+	if glb.done == nil {
+		glb.done = make(chan struct{})
+	}
 	glb.checkServers()
 	go func() {
 		for {
@@ -175,6 +259,9 @@ func (glb *GeneralLoadBalancer) checkServers() {
 				logger.Warnf("server:%v becomes healthy.", svr.ID())
 				svr.Unhealth = false
 				changed = true
+				if glb.healthListener != nil {
+					glb.healthListener(svr, true)
+				}
 			}
 		} else {
 			if svr.HealthCounter > 0 {
@@ -185,6 +272,9 @@ func (glb *GeneralLoadBalancer) checkServers() {
 				logger.Warnf("server:%v becomes unhealthy.", svr.ID())
 				svr.Unhealth = true
 				changed = true
+				if glb.healthListener != nil {
+					glb.healthListener(svr, false)
+				}
 			}
 		}
 
@@ -197,12 +287,67 @@ func (glb *GeneralLoadBalancer) checkServers() {
 		return
 	}
 
-	glb.healthyServers.Store(newServerGroup(servers))
+	glb.mutex.Lock()
+	if glb.dw != nil {
+		glb.healthyServers.Store(glb.dw.serverGroup(servers))
+	} else {
+		glb.healthyServers.Store(newServerGroup(servers))
+	}
+	glb.mutex.Unlock()
+
 	if glb.ss != nil {
 		glb.ss.UpdateServers(servers)
 	}
 }
 
+func (glb *GeneralLoadBalancer) startAdjustWeights() {
+	if glb.done == nil {
+		glb.done = make(chan struct{})
+	}
+
+	ticker := time.NewTicker(glb.dw.interval)
+	go func() {
+		for {
+			select {
+			case <-glb.done:
+				ticker.Stop()
+				return
+			case <-ticker.C:
+				glb.adjustWeights()
+			}
+		}
+	}()
+}
+
+func (glb *GeneralLoadBalancer) adjustWeights() {
+	if !glb.dw.adjust() {
+		return
+	}
+
+	glb.mutex.Lock()
+	sg := glb.healthyServers.Load()
+	glb.healthyServers.Store(glb.dw.serverGroup(sg.Servers))
+	glb.mutex.Unlock()
+}
+
+// DynamicWeights returns the dynamic weight status of the servers, it
+// returns nil if dynamic weight is not enabled.
+func (glb *GeneralLoadBalancer) DynamicWeights() map[string]*DynamicWeightStatus {
+	if glb.dw == nil {
+		return nil
+	}
+	return glb.dw.status()
+}
+
+// Inflights returns the number of in-flight requests of the servers.
+func (glb *GeneralLoadBalancer) Inflights() map[string]int64 {
+	inflights := make(map[string]int64, len(glb.servers))
+	for _, svr := range glb.servers {
+		inflights[svr.ID()] = svr.Inflight()
+	}
+	return inflights
+}
+
 // ChooseServer chooses a server according to the load balancing spec.
 func (glb *GeneralLoadBalancer) ChooseServer(req protocols.Request) *Server {
 	sg := glb.healthyServers.Load()
@@ -210,6 +355,14 @@ func (glb *GeneralLoadBalancer) ChooseServer(req protocols.Request) *Server {
 		return nil
 	}
 
+	if glb.spec.SubsetHeader != "" {
+		if subset, _ := req.Header().Get(glb.spec.SubsetHeader).(string); subset != "" {
+			if ssg := sg.subset(subset); ssg != nil {
+				sg = ssg
+			}
+		}
+	}
+
 	if glb.ss != nil {
 		if svr := glb.ss.GetServer(req, sg); svr != nil {
 			return svr
@@ -224,12 +377,17 @@ func (glb *GeneralLoadBalancer) ReturnServer(server *Server, req protocols.Reque
 	if glb.ss != nil {
 		glb.ss.ReturnServer(server, req, resp)
 	}
+	if glb.dw != nil {
+		glb.dw.record(server, resp)
+	}
 }
 
 // Close closes the load balancer
 func (glb *GeneralLoadBalancer) Close() {
-	if glb.hc != nil {
+	if glb.done != nil {
 		close(glb.done)
+	}
+	if glb.hc != nil {
 		glb.hc.Close()
 	}
 	if glb.ss != nil {
@@ -262,8 +420,8 @@ type WeightedRandomLoadBalancePolicy struct{}
 // ChooseServer chooses a server randomly by weight.
 func (lbp *WeightedRandomLoadBalancePolicy) ChooseServer(req protocols.Request, sg *ServerGroup) *Server {
 	w := rand.Intn(sg.TotalWeight)
-	for _, svr := range sg.Servers {
-		w -= svr.Weight
+	for i, svr := range sg.Servers {
+		w -= sg.weight(i)
 		if w < 0 {
 			return svr
 		}
@@ -299,3 +457,64 @@ func (lbp *HeaderHashLoadBalancePolicy) ChooseServer(req protocols.Request, sg *
 	hash.Write([]byte(v))
 	return sg.Servers[hash.Sum32()%uint32(len(sg.Servers))]
 }
+
+// PowerOfTwoChoicesLoadBalancePolicy is a load balance policy that chooses
+// two servers randomly, and then chooses the one with fewer in-flight
+// requests.
+type PowerOfTwoChoicesLoadBalancePolicy struct{}
+
+// ChooseServer chooses a server by power of two choices.
+func (lbp *PowerOfTwoChoicesLoadBalancePolicy) ChooseServer(req protocols.Request, sg *ServerGroup) *Server {
+	n := len(sg.Servers)
+	if n == 1 {
+		return sg.Servers[0]
+	}
+
+	i := rand.Intn(n)
+	j := rand.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+
+	s1, s2 := sg.Servers[i], sg.Servers[j]
+	if s2.Inflight() < s1.Inflight() {
+		return s2
+	}
+	return s1
+}
+
+// LeastConnectionsLoadBalancePolicy is a load balance policy that chooses
+// the server with the fewest in-flight requests.
+type LeastConnectionsLoadBalancePolicy struct{}
+
+// ChooseServer chooses the server with the fewest in-flight requests, ties
+// are broken randomly by weight, or evenly if all of them have no weight.
+func (lbp *LeastConnectionsLoadBalancePolicy) ChooseServer(req protocols.Request, sg *ServerGroup) *Server {
+	var chosen *Server
+	var least int64
+	count, totalWeight := 0, 0
+
+	for i, svr := range sg.Servers {
+		n, w := svr.Inflight(), sg.weight(i)
+		if chosen == nil || n < least {
+			chosen, least, count, totalWeight = svr, n, 1, w
+			continue
+		}
+		if n > least {
+			continue
+		}
+
+		// weighted reservoir sampling of the servers with a tie.
+		count++
+		totalWeight += w
+		if totalWeight > 0 {
+			if w > 0 && rand.Intn(totalWeight) < w {
+				chosen = svr
+			}
+		} else if rand.Intn(count) == 0 {
+			chosen = svr
+		}
+	}
+
+	return chosen
+}
@@ -26,6 +26,7 @@ import (
 	"time"
 
 	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
 	"github.com/stretchr/testify/assert"
 )
@@ -180,3 +181,141 @@ func TestHeaderHashLoadBalancePolicy(t *testing.T) {
 		assert.GreaterOrEqual(t, counter[i], 1)
 	}
 }
+
+func TestPowerOfTwoChoicesLoadBalancePolicy(t *testing.T) {
+	counter := [10]int{}
+	servers := prepareServers(10)
+
+	lb := NewGeneralLoadBalancer(&LoadBalanceSpec{Policy: LoadBalancePolicyPowerOfTwoChoices}, servers)
+	lb.Init(nil, nil, nil)
+
+	// the busy server is never chosen as every other server is idle.
+	for i := 0; i < 100; i++ {
+		servers[0].IncInflight()
+	}
+	for i := 0; i < 10000; i++ {
+		svr := lb.ChooseServer(nil)
+		counter[svr.Weight-1]++
+	}
+	assert.Equal(t, 0, counter[0])
+	for i := 1; i < 10; i++ {
+		assert.Greater(t, counter[i], 0)
+	}
+
+	for i := 0; i < 100; i++ {
+		servers[0].DecInflight()
+	}
+	assert.Equal(t, int64(0), servers[0].Inflight())
+
+	lb = NewGeneralLoadBalancer(&LoadBalanceSpec{Policy: LoadBalancePolicyPowerOfTwoChoices}, servers[:1])
+	lb.Init(nil, nil, nil)
+	assert.Equal(t, servers[0], lb.ChooseServer(nil))
+}
+
+type firstLoadBalancePolicy struct{}
+
+func (lbp *firstLoadBalancePolicy) ChooseServer(req protocols.Request, sg *ServerGroup) *Server {
+	return sg.Servers[0]
+}
+
+func TestRegisterLoadBalancePolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Panics(func() {
+		RegisterLoadBalancePolicy(LoadBalancePolicyRoundRobin, nil)
+	})
+	assert.Panics(func() {
+		RegisterLoadBalancePolicy("", nil)
+	})
+
+	RegisterLoadBalancePolicy("first", func(spec *LoadBalanceSpec) LoadBalancePolicy {
+		return &firstLoadBalancePolicy{}
+	})
+	defer delete(loadBalancePolicies, "first")
+	assert.Contains(LoadBalancePolicies(), "first")
+	assert.Contains(LoadBalancePolicies(), LoadBalancePolicyPowerOfTwoChoices)
+
+	servers := prepareServers(10)
+	lb := NewGeneralLoadBalancer(&LoadBalanceSpec{Policy: "first"}, servers)
+	lb.Init(nil, nil, nil)
+	for i := 0; i < 10; i++ {
+		assert.Equal(servers[0], lb.ChooseServer(nil))
+	}
+}
+
+func TestLeastConnectionsLoadBalancePolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	servers := prepareServers(3)
+	lb := NewGeneralLoadBalancer(&LoadBalanceSpec{Policy: LoadBalancePolicyLeastConnections}, servers)
+	lb.Init(nil, nil, nil)
+
+	servers[0].IncInflight()
+	servers[1].IncInflight()
+	assert.Equal(servers[2], lb.ChooseServer(nil))
+
+	// ties are broken by weight.
+	servers[2].IncInflight()
+	counter := [3]int{}
+	for i := 0; i < 60000; i++ {
+		svr := lb.ChooseServer(nil)
+		counter[svr.Weight-1]++
+	}
+	assert.Less(counter[0], counter[1])
+	assert.Less(counter[1], counter[2])
+
+	// ties are broken evenly if no server has weight.
+	for _, svr := range servers {
+		svr.Weight = 0
+	}
+	lb = NewGeneralLoadBalancer(&LoadBalanceSpec{Policy: LoadBalancePolicyLeastConnections}, servers)
+	lb.Init(nil, nil, nil)
+	chosen := map[*Server]int{}
+	for i := 0; i < 3000; i++ {
+		chosen[lb.ChooseServer(nil)]++
+	}
+	assert.Len(chosen, 3)
+
+	assert.Equal(map[string]int64{
+		"192.168.1.1": 1,
+		"192.168.1.2": 1,
+		"192.168.1.3": 1,
+	}, lb.Inflights())
+}
+
+func TestSubsetHeader(t *testing.T) {
+	assert := assert.New(t)
+	servers := prepareServers(4)
+	servers[0].Tags = []string{"v1"}
+	servers[1].Tags = []string{"v1"}
+	servers[2].Tags = []string{"v2"}
+
+	lb := NewGeneralLoadBalancer(&LoadBalanceSpec{Policy: LoadBalancePolicyWeightedRandom, SubsetHeader: "X-Subset"}, servers)
+	lb.Init(nil, nil, nil)
+
+	choose := func(subset string) *Server {
+		req := &http.Request{Header: http.Header{}}
+		if subset != "" {
+			req.Header.Set("X-Subset", subset)
+		}
+		r, _ := httpprot.NewRequest(req)
+		return lb.ChooseServer(r)
+	}
+
+	for i := 0; i < 20; i++ {
+		assert.Contains(servers[:2], choose("v1"))
+		assert.Equal(servers[2], choose("v2"))
+	}
+
+	// all servers are chosen if no server is in the subset.
+	chosen := map[*Server]bool{}
+	for i := 0; i < 200; i++ {
+		chosen[choose("v3")] = true
+		chosen[choose("")] = true
+	}
+	assert.Len(chosen, 4)
+
+	sg := lb.healthyServers.Load()
+	assert.Equal(3, sg.subset("v1").TotalWeight)
+	assert.Nil(sg.subset("v3"))
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/util/fasttime"
+)
+
+// TimeoutKey is the key of the timeout of a request in the context data. It
+// is a time.Duration set by a filter before the proxy, e.g. the
+// DynamicTimeout, and overrides the timeout of the server pools.
+const TimeoutKey = "PROXY_TIMEOUT"
+
+// ResponseTimeLimitKey is the key of the ResponseTimeLimit in the context
+// data.
+const ResponseTimeLimitKey = "RESPONSE_TIME_LIMIT"
+
+// ResponseTimeLimit limits the response time of a request. It is set to the
+// context data by a filter before the proxy, e.g. the Fallback, and the proxy
+// stops waiting for the response of the backend when the limit is exceeded.
+type ResponseTimeLimit struct {
+	// Threshold is the max response time, counted from the creation of
+	// the limit.
+	Threshold time.Duration
+	// CompleteInBackground lets the request to the backend complete in the
+	// background after the limit is exceeded, e.g. to warm the cache.
+	CompleteInBackground bool
+
+	start    time.Time
+	exceeded int32
+}
+
+// NewResponseTimeLimit creates a ResponseTimeLimit starting from now.
+func NewResponseTimeLimit(threshold time.Duration, completeInBackground bool) *ResponseTimeLimit {
+	return &ResponseTimeLimit{
+		Threshold:            threshold,
+		CompleteInBackground: completeInBackground,
+		start:                fasttime.Now(),
+	}
+}
+
+// Remaining returns the remaining time before the limit is exceeded.
+func (l *ResponseTimeLimit) Remaining() time.Duration {
+	return l.Threshold - fasttime.Since(l.start)
+}
+
+// Exceed marks the limit as exceeded, it is called by the proxy.
+func (l *ResponseTimeLimit) Exceed() {
+	atomic.StoreInt32(&l.exceeded, 1)
+}
+
+// Exceeded returns whether the proxy stopped waiting for the response
+// because of the limit.
+func (l *ResponseTimeLimit) Exceeded() bool {
+	return atomic.LoadInt32(&l.exceeded) == 1
+}
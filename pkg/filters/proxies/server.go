@@ -19,9 +19,14 @@ package proxies
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/v2/pkg/util/stringtool"
 )
 
 // Server is a backend proxy server.
@@ -35,6 +40,17 @@ type Server struct {
 	// HealthCounter is used to count the number of successive health checks
 	// result, positive for healthy, negative for unhealthy
 	HealthCounter int `json:"-"`
+
+	// inflight is the number of in-flight requests to the server.
+	inflight int64
+}
+
+// inflightBody decreases the number of in-flight requests to the server
+// when it is closed.
+type inflightBody struct {
+	io.ReadCloser
+	svr  *Server
+	once sync.Once
 }
 
 // String implements the Stringer interface.
@@ -72,6 +88,37 @@ func (s *Server) CheckAddrPattern() {
 	s.AddrIsHostName = net.ParseIP(host) == nil
 }
 
+// Inflight returns the number of in-flight requests to the server.
+func (s *Server) Inflight() int64 {
+	return atomic.LoadInt64(&s.inflight)
+}
+
+// IncInflight increases the number of in-flight requests to the server,
+// it should be called by server pools before sending a request.
+func (s *Server) IncInflight() {
+	atomic.AddInt64(&s.inflight, 1)
+}
+
+// DecInflight decreases the number of in-flight requests to the server,
+// it should be called by server pools after a request completed.
+func (s *Server) DecInflight() {
+	atomic.AddInt64(&s.inflight, -1)
+}
+
+// InflightBody wraps the body of a response from the server, the request
+// is in flight until the body is closed, which decreases the number of
+// in-flight requests. This counts a streaming response until it is
+// consumed.
+func (s *Server) InflightBody(body io.ReadCloser) io.ReadCloser {
+	return &inflightBody{ReadCloser: body, svr: s}
+}
+
+// Close implements io.Closer.
+func (b *inflightBody) Close() error {
+	b.once.Do(b.svr.DecInflight)
+	return b.ReadCloser.Close()
+}
+
 // Healthy returns whether the server is healthy
 func (s *Server) Healthy() bool {
 	return !s.Unhealth
@@ -81,6 +128,49 @@ func (s *Server) Healthy() bool {
 type ServerGroup struct {
 	TotalWeight int
 	Servers     []*Server
+	// Weights are the effective weights of the servers, the weights of
+	// the servers are used if it is nil.
+	Weights []int
+
+	// subsets caches the server groups of the subsets, keyed by tag,
+	// only non-empty subsets are cached, so the number of entries is
+	// bounded by the number of tags of the servers.
+	subsets sync.Map
+}
+
+// weight returns the effective weight of the i-th server.
+func (sg *ServerGroup) weight(i int) int {
+	if sg.Weights == nil {
+		return sg.Servers[i].Weight
+	}
+	return sg.Weights[i]
+}
+
+// subset returns the group of the servers tagged with the tag, or nil if
+// no server has the tag.
+func (sg *ServerGroup) subset(tag string) *ServerGroup {
+	if v, ok := sg.subsets.Load(tag); ok {
+		return v.(*ServerGroup)
+	}
+
+	ssg := &ServerGroup{}
+	for i, svr := range sg.Servers {
+		if !stringtool.StrInSlice(tag, svr.Tags) {
+			continue
+		}
+		w := sg.weight(i)
+		ssg.Servers = append(ssg.Servers, svr)
+		ssg.TotalWeight += w
+		if sg.Weights != nil {
+			ssg.Weights = append(ssg.Weights, w)
+		}
+	}
+	if len(ssg.Servers) == 0 {
+		return nil
+	}
+
+	v, _ := sg.subsets.LoadOrStore(tag, ssg)
+	return v.(*ServerGroup)
 }
 
 func newServerGroup(servers []*Server) *ServerGroup {
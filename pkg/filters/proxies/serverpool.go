@@ -53,6 +53,12 @@ type ServerPoolBaseSpec struct {
 	LoadBalance     *LoadBalanceSpec `json:"loadBalance,omitempty"`
 }
 
+// UseServiceRegistry returns whether the servers are discovered from a
+// service registry.
+func (sps *ServerPoolBaseSpec) UseServiceRegistry() bool {
+	return sps.ServiceRegistry != "" && sps.ServiceName != ""
+}
+
 // Validate validates ServerPoolSpec.
 func (sps *ServerPoolBaseSpec) Validate() error {
 	if sps.ServiceName == "" && len(sps.Servers) == 0 {
@@ -74,6 +80,10 @@ func (sps *ServerPoolBaseSpec) Validate() error {
 		return fmt.Errorf("can not open health check for service discovery")
 	}
 
+	if lb := sps.LoadBalance; lb != nil && lb.DynamicWeight != nil && lb.Policy != LoadBalancePolicyWeightedRandom {
+		return fmt.Errorf("dynamic weight requires load balance policy %s", LoadBalancePolicyWeightedRandom)
+	}
+
 	return nil
 }
 
@@ -83,7 +93,7 @@ func (spb *ServerPoolBase) Init(spImpl ServerPoolImpl, super *supervisor.Supervi
 	spb.Name = name
 	spb.done = make(chan struct{})
 
-	if spec.ServiceRegistry == "" || spec.ServiceName == "" {
+	if !spec.UseServiceRegistry() {
 		spb.createLoadBalancer(spec.LoadBalance, spec.Servers)
 		return
 	}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package querynormalizer implements a filter which normalizes duplicate
+// query parameters.
+package querynormalizer
+
+import (
+	"net/http"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	libqn "github.com/megaease/easegress/v2/pkg/util/querynormalizer"
+)
+
+const (
+	// Kind is the kind of QueryNormalizer.
+	Kind = "QueryNormalizer"
+
+	resultInvalidQuery = "invalidQuery"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "QueryNormalizer normalizes duplicate query parameters to prevent parameter pollution.",
+	Results:     []string{resultInvalidQuery},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &QueryNormalizer{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// QueryNormalizer is the filter QueryNormalizer. Note that the filter
+	// runs after routing, please use the queryNormalizer of the HTTPServer
+	// to normalize queries before routing.
+	QueryNormalizer struct {
+		spec       *Spec
+		normalizer *libqn.QueryNormalizer
+	}
+
+	// Spec is the spec of QueryNormalizer.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+		libqn.Spec       `json:",inline"`
+	}
+)
+
+// Name returns the name of the QueryNormalizer filter instance.
+func (qn *QueryNormalizer) Name() string {
+	return qn.spec.Name()
+}
+
+// Kind returns the kind of QueryNormalizer.
+func (qn *QueryNormalizer) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the QueryNormalizer
+func (qn *QueryNormalizer) Spec() filters.Spec {
+	return qn.spec
+}
+
+// Init initializes QueryNormalizer.
+func (qn *QueryNormalizer) Init() {
+	qn.normalizer = libqn.New(&qn.spec.Spec)
+}
+
+// Inherit inherits previous generation of QueryNormalizer.
+func (qn *QueryNormalizer) Inherit(previousGeneration filters.Filter) {
+	qn.Init()
+}
+
+// Handle normalizes the query of the request.
+func (qn *QueryNormalizer) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	if qn.normalizer.NormalizeRequest(req.Std()) {
+		return ""
+	}
+
+	ctx.AddTag("queryNormalizer: invalid query")
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(http.StatusBadRequest)
+	ctx.SetOutputResponse(resp)
+	return resultInvalidQuery
+}
+
+// Status returns status.
+func (qn *QueryNormalizer) Status() interface{} {
+	return nil
+}
+
+// Close closes QueryNormalizer.
+func (qn *QueryNormalizer) Close() {
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package querynormalizer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestQueryNormalizer(t *testing.T, yamlConfig string) *QueryNormalizer {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	assert.Nil(t, err)
+	qn := kind.CreateInstance(spec).(*QueryNormalizer)
+	qn.Init()
+	return qn
+}
+
+func newContext(uri string) *context.Context {
+	stdReq := httptest.NewRequest(http.MethodGet, uri, nil)
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestQueryNormalizer(t *testing.T) {
+	assert := assert.New(t)
+
+	qn := newTestQueryNormalizer(t, `
+kind: QueryNormalizer
+name: normalizer
+policy: last
+params:
+  role: reject
+`)
+	assert.Equal(kind, qn.Kind())
+	assert.Nil(qn.Status())
+
+	ctx := newContext("/users?id=1&%69d=2")
+	assert.Equal("", qn.Handle(ctx))
+	assert.Equal("2", ctx.GetInputRequest().(*httpprot.Request).Std().URL.Query().Get("id"))
+
+	ctx = newContext("/users?role=user&role=admin")
+	assert.Equal(resultInvalidQuery, qn.Handle(ctx))
+	assert.Equal(http.StatusBadRequest, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	ctx = newContext("/users?role=user;role=admin")
+	assert.Equal(resultInvalidQuery, qn.Handle(ctx))
+
+	newQn := kind.CreateInstance(qn.spec).(*QueryNormalizer)
+	newQn.Inherit(qn)
+	qn.Close()
+}
+
+func TestSpecValidate(t *testing.T) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(`
+kind: QueryNormalizer
+name: normalizer
+params:
+  role: merge
+`), &rawSpec)
+	_, err := filters.NewSpec(nil, "", rawSpec)
+	assert.Error(t, err)
+}
@@ -0,0 +1,280 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package quota implements a filter which enforces per-client request quotas
+// over long windows like a day or a month.
+package quota
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/megaease/easegress/v2/pkg/cluster"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/builder"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of Quota.
+	Kind = "Quota"
+
+	resultQuotaExceeded = "quotaExceeded"
+	resultInvalidKey    = "invalidKey"
+
+	// PeriodDaily resets the quota at the beginning of every day.
+	PeriodDaily = "Daily"
+	// PeriodMonthly resets the quota at the beginning of every month.
+	PeriodMonthly = "Monthly"
+
+	headerLimit     = "X-Quota-Limit"
+	headerRemaining = "X-Quota-Remaining"
+	headerReset     = "X-Quota-Reset"
+	headerRetry     = "Retry-After"
+
+	windowFormat = "2006-01-02"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "Quota enforces per-client request quotas over daily or monthly windows.",
+	Results:     []string{resultQuotaExceeded, resultInvalidKey},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{Period: PeriodMonthly}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &Quota{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// Quota is the Quota filter. The counters are kept in the cluster, so
+	// they are shared by all members and survive restarts.
+	//
+	// When there's no response in the context, Quota checks and consumes
+	// the quota of the request, otherwise, it sets the usage headers of the
+	// response if UsageHeaders is true. So it should be put before the
+	// proxy, and optionally after the proxy (with an alias) to expose the
+	// usage to clients.
+	Quota struct {
+		spec *Spec
+
+		keyTemplate *builder.Template
+		location    *time.Location
+		cls         cluster.Cluster
+		prefix      string
+	}
+
+	// Spec is the spec of Quota.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Key is a template to extract the client key, e.g. the API key.
+		Key          string `json:"key" jsonschema:"required"`
+		Limit        int64  `json:"limit" jsonschema:"required,minimum=1"`
+		Period       string `json:"period,omitempty" jsonschema:"enum=Daily,enum=Monthly"`
+		TimeZone     string `json:"timeZone,omitempty"`
+		UsageHeaders bool   `json:"usageHeaders,omitempty"`
+	}
+
+	// usage is the quota usage of a client in the current window.
+	usage struct {
+		count   int64
+		resetAt time.Time
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if _, err := builder.NewTemplate(spec.Key); err != nil {
+		return fmt.Errorf("invalid key: %v", err)
+	}
+	if _, err := time.LoadLocation(spec.TimeZone); err != nil {
+		return fmt.Errorf("invalid timeZone: %v", err)
+	}
+	return nil
+}
+
+// Name returns the name of the Quota filter instance.
+func (q *Quota) Name() string {
+	return q.spec.Name()
+}
+
+// Kind returns the kind of Quota.
+func (q *Quota) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the Quota
+func (q *Quota) Spec() filters.Spec {
+	return q.spec
+}
+
+// Init initializes Quota.
+func (q *Quota) Init() {
+	q.reload()
+}
+
+// Inherit inherits previous generation of Quota.
+func (q *Quota) Inherit(previousGeneration filters.Filter) {
+	q.reload()
+}
+
+func (q *Quota) reload() {
+	q.keyTemplate = builder.MustNewTemplate(q.spec.Key)
+	q.location, _ = time.LoadLocation(q.spec.TimeZone)
+
+	if super := q.spec.Super(); super != nil && super.Cluster() != nil {
+		q.cls = super.Cluster()
+		q.prefix = q.cls.Layout().QuotaDataPrefix(q.spec.Pipeline(), q.spec.Name())
+	} else {
+		logger.Errorf("%s: no cluster, quota is not enforced", q.Name())
+	}
+}
+
+// window returns the start of the current window and the time the window
+// resets, according to the calendar of the configured time zone.
+func (q *Quota) window(now time.Time) (time.Time, time.Time) {
+	now = now.In(q.location)
+	y, m, d := now.Date()
+
+	if q.spec.Period == PeriodDaily {
+		start := time.Date(y, m, d, 0, 0, 0, 0, q.location)
+		return start, start.AddDate(0, 0, 1)
+	}
+
+	start := time.Date(y, m, 1, 0, 0, 0, 0, q.location)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// consume increases the counter of the key in the current window, the
+// counter is stored as "window:count", and it restarts from zero when the
+// window changes. The counter is not increased if the quota is exhausted.
+func (q *Quota) consume(key string, now time.Time) (*usage, error) {
+	start, resetAt := q.window(now)
+	window := start.Format(windowFormat)
+	u := &usage{resetAt: resetAt}
+
+	err := q.cls.STM(func(stm concurrency.STM) error {
+		u.count = 0
+		if w, c, ok := strings.Cut(stm.Get(q.prefix+key), ":"); ok && w == window {
+			u.count, _ = strconv.ParseInt(c, 10, 64)
+		}
+		if u.count >= q.spec.Limit {
+			// NOTE: count may exceed the limit if the limit was decreased.
+			u.count = q.spec.Limit + 1
+			return nil
+		}
+		u.count++
+		stm.Put(q.prefix+key, window+":"+strconv.FormatInt(u.count, 10))
+		return nil
+	})
+
+	return u, err
+}
+
+func (q *Quota) dataKey() string {
+	return "QUOTA_USAGE_" + q.Name()
+}
+
+func (q *Quota) setUsageHeaders(h http.Header, u *usage) {
+	remaining := q.spec.Limit - u.count
+	if remaining < 0 {
+		remaining = 0
+	}
+	h.Set(headerLimit, strconv.FormatInt(q.spec.Limit, 10))
+	h.Set(headerRemaining, strconv.FormatInt(remaining, 10))
+	h.Set(headerReset, u.resetAt.Format(time.RFC3339))
+}
+
+// Handle consumes the quota of the request, or sets the usage headers of
+// the response.
+func (q *Quota) Handle(ctx *context.Context) string {
+	if resp := ctx.GetInputResponse(); resp != nil {
+		if u, ok := ctx.GetData(q.dataKey()).(*usage); ok && q.spec.UsageHeaders {
+			q.setUsageHeaders(resp.(*httpprot.Response).HTTPHeader(), u)
+		}
+		return ""
+	}
+
+	// the missing cluster is logged in reload.
+	if q.cls == nil {
+		return ""
+	}
+
+	// requests without a key are rejected, otherwise they would share
+	// the quota of the empty key.
+	key, err := q.keyTemplate.Render(ctx)
+	if err == nil && key == "" {
+		err = fmt.Errorf("empty key")
+	}
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("quota: invalid key: %v", err))
+		resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+		if resp == nil {
+			resp, _ = httpprot.NewResponse(nil)
+		}
+		resp.SetStatusCode(http.StatusBadRequest)
+		ctx.SetOutputResponse(resp)
+		return resultInvalidKey
+	}
+
+	now := time.Now()
+	u, err := q.consume(key, now)
+	if err != nil {
+		// fail open, a cluster failure should not block all requests.
+		logger.Errorf("%s: failed to consume quota of %s: %v", q.Name(), key, err)
+		return ""
+	}
+	ctx.SetData(q.dataKey(), u)
+
+	if u.count <= q.spec.Limit {
+		return ""
+	}
+
+	ctx.AddTag(fmt.Sprintf("quota: %s exceeded", key))
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(http.StatusTooManyRequests)
+	q.setUsageHeaders(resp.HTTPHeader(), u)
+	retryAfter := int64(u.resetAt.Sub(now).Seconds()) + 1
+	resp.HTTPHeader().Set(headerRetry, strconv.FormatInt(retryAfter, 10))
+	ctx.SetOutputResponse(resp)
+	return resultQuotaExceeded
+}
+
+// Status returns status.
+func (q *Quota) Status() interface{} {
+	return nil
+}
+
+// Close closes Quota.
+func (q *Quota) Close() {
+}
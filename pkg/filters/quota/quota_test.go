@@ -0,0 +1,204 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package quota
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/megaease/easegress/v2/pkg/cluster/clustertest"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newMockedCluster() (*clustertest.MockedCluster, map[string]string) {
+	var mutex sync.Mutex
+	kvs := map[string]string{}
+
+	cls := clustertest.NewMockedCluster()
+	cls.MockedSTM = func(apply func(concurrency.STM) error) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		stm := &clustertest.MockedSTM{
+			MockedGet: func(key ...string) string {
+				return kvs[key[0]]
+			},
+			MockedPut: func(key, val string, opts ...clientv3.OpOption) {
+				kvs[key] = val
+			},
+		}
+		return apply(stm)
+	}
+	return cls, kvs
+}
+
+func newTestQuota(t *testing.T, cls *clustertest.MockedCluster, yamlConfig string) *Quota {
+	super := supervisor.NewMock(nil, cls, nil, nil, false, nil, nil)
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(super, "pipeline", rawSpec)
+	assert.Nil(t, err)
+	q := kind.CreateInstance(spec).(*Quota)
+	q.Init()
+	return q
+}
+
+func newContext(apiKey string) *context.Context {
+	stdReq, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	stdReq.Header.Set("X-Api-Key", apiKey)
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &Spec{Key: "{{.req.Host"}
+	assert.NotNil(spec.Validate())
+
+	spec.Key = "abc"
+	spec.TimeZone = "Mars/Olympus"
+	assert.NotNil(spec.Validate())
+
+	spec.TimeZone = "Asia/Shanghai"
+	assert.Nil(spec.Validate())
+}
+
+func TestWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	q := &Quota{spec: &Spec{Period: PeriodDaily}}
+	q.location, _ = time.LoadLocation("Asia/Shanghai")
+
+	// 2023-12-31 20:00 UTC is 2024-01-01 04:00 in Shanghai.
+	now := time.Date(2023, 12, 31, 20, 0, 0, 0, time.UTC)
+	start, reset := q.window(now)
+	assert.Equal("2024-01-01T00:00:00+08:00", start.Format(time.RFC3339))
+	assert.Equal("2024-01-02T00:00:00+08:00", reset.Format(time.RFC3339))
+
+	q.spec.Period = PeriodMonthly
+	start, reset = q.window(now)
+	assert.Equal("2024-01-01T00:00:00+08:00", start.Format(time.RFC3339))
+	assert.Equal("2024-02-01T00:00:00+08:00", reset.Format(time.RFC3339))
+
+	q.location = time.UTC
+	start, reset = q.window(now)
+	assert.Equal("2023-12-01T00:00:00Z", start.Format(time.RFC3339))
+	assert.Equal("2024-01-01T00:00:00Z", reset.Format(time.RFC3339))
+}
+
+func TestQuota(t *testing.T) {
+	assert := assert.New(t)
+
+	cls, kvs := newMockedCluster()
+	q := newTestQuota(t, cls, `
+kind: Quota
+name: quota
+key: '{{.req.Header.Get "X-Api-Key"}}'
+limit: 2
+period: Daily
+usageHeaders: true
+`)
+	assert.Equal(kind, q.Kind())
+	assert.Nil(q.Status())
+	defer q.Close()
+
+	for i := 0; i < 2; i++ {
+		ctx := newContext("key1")
+		assert.Equal("", q.Handle(ctx))
+
+		resp, _ := httpprot.NewResponse(nil)
+		ctx.SetInputResponse(resp)
+		assert.Equal("", q.Handle(ctx))
+		assert.Equal("2", resp.HTTPHeader().Get(headerLimit))
+		assert.Equal(fmt.Sprint(1-i), resp.HTTPHeader().Get(headerRemaining))
+	}
+
+	ctx := newContext("key1")
+	assert.Equal(resultQuotaExceeded, q.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusTooManyRequests, resp.StatusCode())
+	assert.Equal("0", resp.HTTPHeader().Get(headerRemaining))
+	assert.NotEmpty(resp.HTTPHeader().Get(headerReset))
+	assert.NotEmpty(resp.HTTPHeader().Get(headerRetry))
+
+	// other clients are not affected.
+	assert.Equal("", q.Handle(newContext("key2")))
+
+	// requests without a key are rejected.
+	ctx = newContext("")
+	assert.Equal(resultInvalidKey, q.Handle(ctx))
+	assert.Equal(http.StatusBadRequest, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+	assert.NotContains(kvs, q.prefix)
+
+	// the counter restarts in a new window.
+	key := q.prefix + "key1"
+	assert.Contains(kvs[key], ":2")
+	kvs[key] = "2000-01-01:2"
+	assert.Equal("", q.Handle(newContext("key1")))
+	assert.Contains(kvs[key], ":1")
+
+	// fail open if the cluster fails.
+	cls.MockedSTM = func(apply func(concurrency.STM) error) error {
+		return fmt.Errorf("mocked error")
+	}
+	assert.Equal("", q.Handle(newContext("key1")))
+
+	newQ := kind.CreateInstance(q.spec).(*Quota)
+	newQ.Inherit(q)
+	assert.Equal(q.prefix, newQ.prefix)
+}
+
+func TestQuotaNoCluster(t *testing.T) {
+	assert := assert.New(t)
+
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(`
+kind: Quota
+name: quota
+key: '{{.req.Header.Get "X-Api-Key"}}'
+limit: 1
+`), &rawSpec)
+	spec, err := filters.NewSpec(nil, "pipeline", rawSpec)
+	assert.Nil(err)
+	q := kind.CreateInstance(spec).(*Quota)
+	q.Init()
+	assert.Nil(q.cls)
+
+	// the quota is not enforced.
+	for i := 0; i < 3; i++ {
+		assert.Equal("", q.Handle(newContext("")))
+	}
+}
@@ -22,12 +22,14 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters"
 	"github.com/megaease/easegress/v2/pkg/logger"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
 	librl "github.com/megaease/easegress/v2/pkg/util/ratelimiter"
 	"github.com/megaease/easegress/v2/pkg/util/urlrule"
 )
@@ -42,6 +44,7 @@ var kind = &filters.Kind{
 	Name:        Kind,
 	Description: "RateLimiter implements a rate limiter for http request.",
 	Results:     []string{resultRateLimited},
+	RequestOnly: true,
 	DefaultSpec: func() filters.Spec {
 		return &Spec{}
 	},
@@ -157,6 +160,13 @@ func (rl *RateLimiter) setStateListenerForURL(u *URLRule) {
 			event.State,
 			event.Time.UnixNano()/1e6,
 		)
+		rl.spec.Super().PublishStateEvent(&supervisor.StateEvent{
+			Time:     event.Time,
+			Kind:     supervisor.StateEventRateLimiter,
+			Source:   rl.spec.Name(),
+			Target:   u.ID(),
+			NewState: event.State,
+		})
 	})
 }
 
@@ -292,6 +302,30 @@ func (rl *RateLimiter) Status() interface{} {
 	return nil
 }
 
+// HealthReport reports the health of RateLimiter, it is degraded if any of
+// the URLs is being limited.
+func (rl *RateLimiter) HealthReport() *filters.HealthReport {
+	var limiting []string
+	for _, u := range rl.spec.URLs {
+		if u.rl != nil && u.rl.State() == librl.StateLimiting {
+			limiting = append(limiting, u.ID())
+		}
+	}
+
+	r := &filters.HealthReport{
+		Health: filters.HealthHealthy,
+		Metrics: map[string]interface{}{
+			"urls":         len(rl.spec.URLs),
+			"limitingURLs": len(limiting),
+		},
+	}
+	if len(limiting) > 0 {
+		r.Health = filters.HealthDegraded
+		r.Reason = fmt.Sprintf("limiting URLs: %s", strings.Join(limiting, ", "))
+	}
+	return r
+}
+
 // Close closes RateLimiter.
 func (rl *RateLimiter) Close() {
 }
@@ -0,0 +1,297 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package recordprocessor implements a filter which processes the records
+// of streaming request bodies, like NDJSON, one by one.
+package recordprocessor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/xeipuuv/gojsonschema"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/builder"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/readers"
+)
+
+const (
+	// Kind is the kind of RecordProcessor.
+	Kind = "RecordProcessor"
+
+	resultMalformedRecord     = "malformedRecord"
+	resultUnsupportedEncoding = "unsupportedEncoding"
+
+	// FormatNDJSON is the format of newline delimited JSON records.
+	FormatNDJSON = "ndjson"
+	// FormatLines is the format of plain text lines.
+	FormatLines = "lines"
+
+	// OnMalformedFail fails the request on a malformed record.
+	OnMalformedFail = "fail"
+	// OnMalformedDrop drops malformed records.
+	OnMalformedDrop = "drop"
+
+	defaultMaxRecordSize = 1024 * 1024
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "RecordProcessor validates and transforms the records of request bodies one by one, without buffering the whole body if it is a stream.",
+	Results:     []string{resultMalformedRecord, resultUnsupportedEncoding},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{
+			Format:      FormatNDJSON,
+			OnMalformed: OnMalformedFail,
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &RecordProcessor{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// RecordProcessor is the filter RecordProcessor.
+	RecordProcessor struct {
+		spec *Spec
+
+		schema   *gojsonschema.Schema
+		template *builder.Template
+
+		records uint64
+		dropped uint64
+		failed  uint64
+	}
+
+	// Spec is the spec of RecordProcessor.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		Format string `json:"format,omitempty" jsonschema:"enum=ndjson,enum=lines"`
+		// Schema is the JSON schema of the NDJSON records.
+		Schema string `json:"schema,omitempty"`
+		// Template transforms a record, the record and its index are
+		// available as .record and .index, and the record is dropped if
+		// the result is empty.
+		Template      string `json:"template,omitempty"`
+		OnMalformed   string `json:"onMalformed,omitempty" jsonschema:"enum=fail,enum=drop"`
+		MaxRecordSize int    `json:"maxRecordSize,omitempty" jsonschema:"minimum=0"`
+	}
+
+	// Status is the status of RecordProcessor.
+	Status struct {
+		Records uint64 `json:"records"`
+		Dropped uint64 `json:"dropped"`
+		Failed  uint64 `json:"failed"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if spec.Schema != "" {
+		if spec.Format != FormatNDJSON {
+			return fmt.Errorf("schema requires format %s", FormatNDJSON)
+		}
+		if _, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(spec.Schema)); err != nil {
+			return fmt.Errorf("invalid schema: %v", err)
+		}
+	}
+	if spec.Template != "" {
+		if _, err := builder.NewTemplate(spec.Template); err != nil {
+			return fmt.Errorf("invalid template: %v", err)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the RecordProcessor filter instance.
+func (rp *RecordProcessor) Name() string {
+	return rp.spec.Name()
+}
+
+// Kind returns the kind of RecordProcessor.
+func (rp *RecordProcessor) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the RecordProcessor
+func (rp *RecordProcessor) Spec() filters.Spec {
+	return rp.spec
+}
+
+// Init initializes RecordProcessor.
+func (rp *RecordProcessor) Init() {
+	rp.reload()
+}
+
+// Inherit inherits previous generation of RecordProcessor.
+func (rp *RecordProcessor) Inherit(previousGeneration filters.Filter) {
+	rp.reload()
+}
+
+func (rp *RecordProcessor) reload() {
+	if rp.spec.Schema != "" {
+		rp.schema, _ = gojsonschema.NewSchema(gojsonschema.NewStringLoader(rp.spec.Schema))
+	}
+	if rp.spec.Template != "" {
+		rp.template = builder.MustNewTemplate(rp.spec.Template)
+	}
+}
+
+func (rp *RecordProcessor) maxRecordSize() int {
+	if rp.spec.MaxRecordSize > 0 {
+		return rp.spec.MaxRecordSize
+	}
+	return defaultMaxRecordSize
+}
+
+// process validates and transforms a record.
+func (rp *RecordProcessor) process(data map[string]interface{}, index int, line []byte) ([]byte, error) {
+	var record interface{} = string(line)
+
+	if rp.spec.Format == FormatNDJSON {
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		if err := dec.Decode(&record); err != nil {
+			return nil, fmt.Errorf("record %d: invalid JSON: %v", index, err)
+		}
+		if dec.More() {
+			return nil, fmt.Errorf("record %d: extra data after JSON", index)
+		}
+
+		if rp.schema != nil {
+			res, err := rp.schema.Validate(gojsonschema.NewBytesLoader(line))
+			if err != nil {
+				return nil, fmt.Errorf("record %d: %v", index, err)
+			}
+			if !res.Valid() {
+				return nil, fmt.Errorf("record %d: %v", index, res.Errors()[0])
+			}
+		}
+	}
+
+	if rp.template == nil {
+		return line, nil
+	}
+
+	data["record"] = record
+	data["index"] = index
+	out, err := rp.template.Execute(data)
+	if err != nil {
+		return nil, fmt.Errorf("record %d: %v", index, err)
+	}
+	out = strings.TrimSpace(out)
+	if out == "" || rp.spec.Format != FormatNDJSON {
+		return []byte(out), nil
+	}
+
+	// a record must be in a single line.
+	var buf bytes.Buffer
+	if err = json.Compact(&buf, []byte(out)); err != nil {
+		return nil, fmt.Errorf("record %d: invalid JSON of template: %v", index, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Handle processes the records of the request body.
+func (rp *RecordProcessor) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	if ce := req.HTTPHeader().Get("Content-Encoding"); ce != "" && ce != "identity" {
+		ctx.AddTag(fmt.Sprintf("recordProcessor: unsupported encoding %q", ce))
+		return rp.reject(ctx, http.StatusUnsupportedMediaType, resultUnsupportedEncoding)
+	}
+
+	var data map[string]interface{}
+	if rp.template != nil {
+		var err error
+		if data, err = rp.template.Data(ctx); err != nil {
+			ctx.AddTag(fmt.Sprintf("recordProcessor: failed to prepare data: %v", err))
+			return rp.reject(ctx, http.StatusBadRequest, resultMalformedRecord)
+		}
+	}
+
+	// the records are processed one by one by the reader, so data is never
+	// used concurrently.
+	fn := func(index int, line []byte) ([]byte, error) {
+		atomic.AddUint64(&rp.records, 1)
+		out, err := rp.process(data, index, line)
+		if err == nil {
+			return out, nil
+		}
+		if rp.spec.OnMalformed == OnMalformedDrop {
+			atomic.AddUint64(&rp.dropped, 1)
+			return nil, nil
+		}
+		atomic.AddUint64(&rp.failed, 1)
+		return nil, err
+	}
+
+	r := readers.NewLineTransformReader(req.GetPayload(), rp.maxRecordSize(), fn)
+	if req.IsStream() {
+		// errors are reported when the stream is read by the next
+		// filters, e.g. the proxy.
+		req.SetPayload(r)
+		req.ContentLength = -1
+		req.HTTPHeader().Del("Content-Length")
+		return ""
+	}
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("recordProcessor: %v", err))
+		return rp.reject(ctx, http.StatusBadRequest, resultMalformedRecord)
+	}
+	req.SetPayload(body)
+	req.ContentLength = int64(len(body))
+	req.HTTPHeader().Set("Content-Length", strconv.Itoa(len(body)))
+	return ""
+}
+
+func (rp *RecordProcessor) reject(ctx *context.Context, code int, result string) string {
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(code)
+	ctx.SetOutputResponse(resp)
+	return result
+}
+
+// Status returns status.
+func (rp *RecordProcessor) Status() interface{} {
+	return &Status{
+		Records: atomic.LoadUint64(&rp.records),
+		Dropped: atomic.LoadUint64(&rp.dropped),
+		Failed:  atomic.LoadUint64(&rp.failed),
+	}
+}
+
+// Close closes RecordProcessor.
+func (rp *RecordProcessor) Close() {
+}
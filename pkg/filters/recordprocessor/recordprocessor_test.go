@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package recordprocessor
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestFilter(yamlConfig string) *RecordProcessor {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		panic(err)
+	}
+	rp := kind.CreateInstance(spec).(*RecordProcessor)
+	rp.Init()
+	return rp
+}
+
+func newContext(body string, stream bool) (*context.Context, *httpprot.Request) {
+	stdr, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/", bytes.NewReader([]byte(body)))
+	req, _ := httpprot.NewRequest(stdr)
+	if stream {
+		req.FetchPayload(-1)
+	} else {
+		req.FetchPayload(0)
+	}
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, req
+}
+
+const testSchema = `{
+  "type": "object",
+  "properties": {"id": {"type": "integer"}},
+  "required": ["id"]
+}`
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, yamlConfig := range []string{`
+kind: RecordProcessor
+name: rp
+format: lines
+schema: '{"type": "object"}'
+`, `
+kind: RecordProcessor
+name: rp
+schema: '{"type": 1}'
+`, `
+kind: RecordProcessor
+name: rp
+template: '{{.record'
+`} {
+		rawSpec := map[string]interface{}{}
+		codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+		_, err := filters.NewSpec(nil, "", rawSpec)
+		assert.Error(err)
+	}
+}
+
+func TestBuffered(t *testing.T) {
+	assert := assert.New(t)
+
+	rp := newTestFilter(`
+kind: RecordProcessor
+name: rp
+schema: '` + testSchema + `'
+template: '{"id": {{.record.id}}, "index": {{.index}}}'
+`)
+	assert.Equal(kind, rp.Kind())
+	assert.Equal("rp", rp.Name())
+
+	ctx, req := newContext("{\"id\": 1}\r\n\n{\"id\": 2, \"x\": \"y\"}\n", false)
+	assert.Empty(rp.Handle(ctx))
+	expected := "{\"id\":1,\"index\":0}\n{\"id\":2,\"index\":1}\n"
+	assert.Equal(expected, string(req.RawPayload()))
+	assert.Equal(int64(len(expected)), req.ContentLength)
+
+	ctx, _ = newContext("{\"id\": 1}\n{\"id\": \"a\"}\n", false)
+	assert.Equal(resultMalformedRecord, rp.Handle(ctx))
+	assert.Equal(http.StatusBadRequest, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	ctx, _ = newContext("{\"id\": 1", false)
+	assert.Equal(resultMalformedRecord, rp.Handle(ctx))
+
+	ctx, req = newContext("{\"id\": 1}\n", false)
+	req.HTTPHeader().Set("Content-Encoding", "gzip")
+	assert.Equal(resultUnsupportedEncoding, rp.Handle(ctx))
+	assert.Equal(http.StatusUnsupportedMediaType, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	status := rp.Status().(*Status)
+	assert.Equal(uint64(5), status.Records)
+	assert.Equal(uint64(2), status.Failed)
+	assert.Equal(uint64(0), status.Dropped)
+}
+
+func TestStreamDrop(t *testing.T) {
+	assert := assert.New(t)
+
+	rp := newTestFilter(`
+kind: RecordProcessor
+name: rp
+schema: '` + testSchema + `'
+onMalformed: drop
+`)
+
+	ctx, req := newContext("{\"id\": 1}\nnot json\n{\"id\": \"a\"}\n{\"id\": 3}", true)
+	assert.Empty(rp.Handle(ctx))
+	assert.True(req.IsStream())
+	assert.Equal(int64(-1), req.ContentLength)
+
+	data, err := io.ReadAll(req.GetPayload())
+	assert.NoError(err)
+	assert.Equal("{\"id\": 1}\n{\"id\": 3}\n", string(data))
+
+	status := rp.Status().(*Status)
+	assert.Equal(uint64(4), status.Records)
+	assert.Equal(uint64(2), status.Dropped)
+}
+
+func TestStreamFail(t *testing.T) {
+	assert := assert.New(t)
+
+	rp := newTestFilter(`
+kind: RecordProcessor
+name: rp
+maxRecordSize: 16
+`)
+
+	ctx, req := newContext("{\"id\": 1}\n{\"id\": \"very long record\"}\n", true)
+	assert.Empty(rp.Handle(ctx))
+	_, err := io.ReadAll(req.GetPayload())
+	assert.Error(err)
+}
+
+func TestLines(t *testing.T) {
+	assert := assert.New(t)
+
+	rp := newTestFilter(`
+kind: RecordProcessor
+name: rp
+format: lines
+template: '{{if ne .record "skip"}}{{.index}}:{{upper .record}}{{end}}'
+`)
+
+	ctx, req := newContext("a\nskip\nb\n", false)
+	assert.Empty(rp.Handle(ctx))
+	assert.Equal("0:A\n2:B\n", string(req.RawPayload()))
+}
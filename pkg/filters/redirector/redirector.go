@@ -55,6 +55,7 @@ var kind = &filters.Kind{
 	Name:        Kind,
 	Description: "Redirector redirect HTTP requests.",
 	Results:     []string{resultRedirected},
+	RequestOnly: true,
 	DefaultSpec: func() filters.Spec {
 		return &Spec{
 			MatchPart:  matchPartURI,
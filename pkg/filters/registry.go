@@ -19,7 +19,12 @@ package filters
 
 import (
 	"fmt"
+	"reflect"
 	"sort"
+
+	"github.com/invopop/jsonschema"
+
+	"github.com/megaease/easegress/v2/pkg/v"
 )
 
 // kinds is the filter kind registry.
@@ -76,6 +81,13 @@ func GetKind(name string) *Kind {
 	return kinds[name]
 }
 
+// Schema returns the JSON schema of the spec of the filter kind, which is
+// generated from the json and jsonschema tags of the spec, including the
+// required fields and enums. The caller should never modify the return value.
+func (k *Kind) Schema() (*jsonschema.Schema, error) {
+	return v.GetSchema(reflect.TypeOf(k.DefaultSpec()))
+}
+
 // Create creates a filter instance of kind.
 func Create(spec Spec) Filter {
 	k := kinds[spec.Kind()]
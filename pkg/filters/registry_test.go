@@ -73,3 +73,29 @@ func TestRegister(t *testing.T) {
 		},
 	}))
 }
+
+func TestKindSchema(t *testing.T) {
+	assert := assert.New(t)
+
+	type enumSpec struct {
+		BaseSpec `json:",inline"`
+		Mode     string `json:"mode,omitempty" jsonschema:"enum=a,enum=b"`
+		Field    string `json:"field" jsonschema:"required"`
+	}
+	k := &Kind{
+		Name:           "Enum",
+		DefaultSpec:    func() Spec { return &enumSpec{} },
+		CreateInstance: func(spec Spec) Filter { return nil },
+	}
+
+	schema, err := k.Schema()
+	assert.Nil(err)
+	assert.Contains(schema.Required, "name")
+	assert.Contains(schema.Required, "kind")
+	assert.Contains(schema.Required, "field")
+	assert.NotContains(schema.Required, "mode")
+
+	mode, ok := schema.Properties.Get("mode")
+	assert.True(ok)
+	assert.Equal([]interface{}{"a", "b"}, mode.Enum)
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package replayguard
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/megaease/easegress/v2/pkg/cluster"
+	"github.com/megaease/easegress/v2/pkg/logger"
+)
+
+type (
+	// nonceStore tracks the nonces seen in the last ttl.
+	nonceStore interface {
+		// claim records the nonce, it returns false if the nonce has been
+		// seen and not expired.
+		claim(nonce string, now time.Time) (bool, error)
+	}
+
+	memoryNonceStore struct {
+		ttl       time.Duration
+		maxNonces int
+
+		mutex  sync.Mutex
+		nonces map[string]*list.Element
+		// queue keeps nonces in the order of expiration, as all nonces
+		// have the same ttl.
+		queue *list.List
+	}
+
+	nonceEntry struct {
+		nonce    string
+		expireAt time.Time
+	}
+
+	clusterNonceStore struct {
+		cls    cluster.Cluster
+		prefix string
+		ttl    time.Duration
+	}
+)
+
+func newMemoryNonceStore(ttl time.Duration, maxNonces int) *memoryNonceStore {
+	return &memoryNonceStore{
+		ttl:       ttl,
+		maxNonces: maxNonces,
+		nonces:    map[string]*list.Element{},
+		queue:     list.New(),
+	}
+}
+
+// purge removes expired nonces, and the oldest nonces if the store is full.
+func (ms *memoryNonceStore) purge(now time.Time) {
+	for e := ms.queue.Front(); e != nil; e = ms.queue.Front() {
+		entry := e.Value.(*nonceEntry)
+		if len(ms.nonces) < ms.maxNonces && now.Before(entry.expireAt) {
+			return
+		}
+		ms.queue.Remove(e)
+		delete(ms.nonces, entry.nonce)
+	}
+}
+
+func (ms *memoryNonceStore) claim(nonce string, now time.Time) (bool, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if e := ms.nonces[nonce]; e != nil && now.Before(e.Value.(*nonceEntry).expireAt) {
+		return false, nil
+	}
+
+	// an expired entry of the nonce is removed by purge as it is at the
+	// front of the queue.
+	ms.purge(now)
+	ms.nonces[nonce] = ms.queue.PushBack(&nonceEntry{nonce: nonce, expireAt: now.Add(ms.ttl)})
+	return true, nil
+}
+
+func (ms *memoryNonceStore) len() int {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return len(ms.nonces)
+}
+
+func newClusterNonceStore(cls cluster.Cluster, prefix string, ttl time.Duration) *clusterNonceStore {
+	return &clusterNonceStore{cls: cls, prefix: prefix, ttl: ttl}
+}
+
+// claim claims the nonce in an STM, so that a nonce is claimed only once
+// across members. The value is the expiration time of the nonce, it is put
+// again under a lease after the claim, so that the key is removed by the
+// cluster after the ttl.
+func (cs *clusterNonceStore) claim(nonce string, now time.Time) (bool, error) {
+	key := cs.prefix + nonce
+	value := strconv.FormatInt(now.Add(cs.ttl).UnixNano(), 10)
+
+	claimed := false
+	err := cs.cls.STM(func(stm concurrency.STM) error {
+		claimed = false
+		// the lease may not be revoked in time, so check the expiration.
+		if v := stm.Get(key); v != "" {
+			if expireAt, _ := strconv.ParseInt(v, 10, 64); now.UnixNano() < expireAt {
+				return nil
+			}
+		}
+		stm.Put(key, value)
+		claimed = true
+		return nil
+	})
+	if err != nil || !claimed {
+		return false, err
+	}
+
+	// etcd leases are in seconds, round up to avoid a zero TTL.
+	ttl := cs.ttl
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	if err = cs.cls.PutUnderTimeout(key, value, ttl); err != nil {
+		// the nonce has been claimed, it only lives longer in the cluster.
+		logger.Warnf("failed to put nonce %s under lease: %v", nonce, err)
+	}
+	return true, nil
+}
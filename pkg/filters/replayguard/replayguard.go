@@ -0,0 +1,239 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package replayguard implements a filter which protects APIs from replayed
+// requests by tracking request nonces.
+package replayguard
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of ReplayGuard.
+	Kind = "ReplayGuard"
+
+	resultInvalid = "invalid"
+
+	defaultNonceHeader = "X-Nonce"
+	defaultWindow      = 5 * time.Minute
+	defaultMaxNonces   = 100000
+
+	// maxNonceLength limits the memory used by a single nonce.
+	maxNonceLength = 256
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "ReplayGuard rejects requests with repeated nonces.",
+	Results:     []string{resultInvalid},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{
+			NonceHeader: defaultNonceHeader,
+			MaxNonces:   defaultMaxNonces,
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &ReplayGuard{spec: spec.(*Spec)}
+	},
+}
+
+// now is the function to get the current time, tests could replace it.
+var now = time.Now
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// ReplayGuard is the filter ReplayGuard.
+	//
+	// Nonces are tracked for the window, or twice the window if the
+	// timestamp is checked, as a request is accepted when its timestamp is
+	// within the window before or after the current time.
+	ReplayGuard struct {
+		spec   *Spec
+		window time.Duration
+		store  nonceStore
+	}
+
+	// Spec is the spec of ReplayGuard.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		NonceHeader string `json:"nonceHeader,omitempty"`
+		// TimestampHeader is the header of the request timestamp in Unix
+		// seconds, the timestamp is not checked if it is empty.
+		TimestampHeader string `json:"timestampHeader,omitempty"`
+		Window          string `json:"window,omitempty" jsonschema:"format=duration"`
+		// MaxNonces is the maximum number of nonces kept in memory, the
+		// oldest nonces are evicted when it is reached.
+		MaxNonces int `json:"maxNonces,omitempty" jsonschema:"minimum=1"`
+		// Cluster tracks the nonces in the cluster, so that the protection
+		// holds across members.
+		Cluster bool `json:"cluster,omitempty"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if spec.Window != "" {
+		if d, err := time.ParseDuration(spec.Window); err != nil {
+			return fmt.Errorf("invalid window: %v", err)
+		} else if d <= 0 {
+			return fmt.Errorf("window must be positive")
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the ReplayGuard filter instance.
+func (rg *ReplayGuard) Name() string {
+	return rg.spec.Name()
+}
+
+// Kind returns the kind of ReplayGuard.
+func (rg *ReplayGuard) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the ReplayGuard
+func (rg *ReplayGuard) Spec() filters.Spec {
+	return rg.spec
+}
+
+// Init initializes ReplayGuard.
+func (rg *ReplayGuard) Init() {
+	rg.reload(nil)
+}
+
+// Inherit inherits previous generation of ReplayGuard.
+func (rg *ReplayGuard) Inherit(previousGeneration filters.Filter) {
+	rg.reload(previousGeneration.(*ReplayGuard))
+}
+
+func (rg *ReplayGuard) reload(previousGeneration *ReplayGuard) {
+	if rg.spec.NonceHeader == "" {
+		rg.spec.NonceHeader = defaultNonceHeader
+	}
+	if rg.spec.MaxNonces <= 0 {
+		rg.spec.MaxNonces = defaultMaxNonces
+	}
+	rg.window = defaultWindow
+	if d, err := time.ParseDuration(rg.spec.Window); err == nil && d > 0 {
+		rg.window = d
+	}
+
+	ttl := rg.window
+	if rg.spec.TimestampHeader != "" {
+		ttl = 2 * rg.window
+	}
+
+	if rg.spec.Cluster {
+		if super := rg.spec.Super(); super != nil && super.Cluster() != nil {
+			cls := super.Cluster()
+			prefix := cls.Layout().NonceDataPrefix(rg.spec.Pipeline(), rg.spec.Name())
+			rg.store = newClusterNonceStore(cls, prefix, ttl)
+			return
+		}
+		logger.Errorf("%s: no cluster, nonces are tracked in memory", rg.Name())
+	}
+
+	// keep the nonces of the previous generation, otherwise, they could be
+	// replayed after the update of the pipeline.
+	if previousGeneration != nil {
+		if ms, ok := previousGeneration.store.(*memoryNonceStore); ok {
+			ms.mutex.Lock()
+			ms.ttl, ms.maxNonces = ttl, rg.spec.MaxNonces
+			ms.mutex.Unlock()
+			rg.store = ms
+			return
+		}
+	}
+	rg.store = newMemoryNonceStore(ttl, rg.spec.MaxNonces)
+}
+
+func (rg *ReplayGuard) reject(ctx *context.Context, code int, msg string) string {
+	ctx.AddTag("replayGuard: " + msg)
+
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(code)
+	ctx.SetOutputResponse(resp)
+	return resultInvalid
+}
+
+// checkTimestamp checks whether the timestamp is within the window.
+func (rg *ReplayGuard) checkTimestamp(value string, now time.Time) bool {
+	ts, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false
+	}
+	d := now.Sub(time.Unix(ts, 0))
+	return d <= rg.window && d >= -rg.window
+}
+
+// Handle rejects the request if its nonce has been seen in the window.
+func (rg *ReplayGuard) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	now := now()
+
+	nonce := req.HTTPHeader().Get(rg.spec.NonceHeader)
+	if nonce == "" {
+		return rg.reject(ctx, http.StatusBadRequest, "missing nonce")
+	}
+	if len(nonce) > maxNonceLength {
+		return rg.reject(ctx, http.StatusBadRequest, "nonce too long")
+	}
+
+	if rg.spec.TimestampHeader != "" {
+		if !rg.checkTimestamp(req.HTTPHeader().Get(rg.spec.TimestampHeader), now) {
+			return rg.reject(ctx, http.StatusBadRequest, "invalid or stale timestamp")
+		}
+	}
+
+	claimed, err := rg.store.claim(nonce, now)
+	if err != nil {
+		// fail closed, as replayed requests can't be detected.
+		logger.Errorf("%s: failed to claim nonce: %v", rg.Name(), err)
+		return rg.reject(ctx, http.StatusServiceUnavailable, "failed to claim nonce")
+	}
+	if !claimed {
+		return rg.reject(ctx, http.StatusConflict, "replayed nonce")
+	}
+	return ""
+}
+
+// Status returns status.
+func (rg *ReplayGuard) Status() interface{} {
+	return nil
+}
+
+// Close closes ReplayGuard.
+func (rg *ReplayGuard) Close() {
+}
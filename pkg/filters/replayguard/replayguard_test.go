@@ -0,0 +1,228 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package replayguard
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/megaease/easegress/v2/pkg/cluster/clustertest"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestReplayGuard(t *testing.T, super *supervisor.Supervisor, yamlConfig string) *ReplayGuard {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(super, "pipeline", rawSpec)
+	assert.Nil(t, err)
+	rg := kind.CreateInstance(spec).(*ReplayGuard)
+	rg.Init()
+	return rg
+}
+
+func newContext(nonce string, timestamp string) *context.Context {
+	stdReq, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	if nonce != "" {
+		stdReq.Header.Set("X-Nonce", nonce)
+	}
+	if timestamp != "" {
+		stdReq.Header.Set("X-Timestamp", timestamp)
+	}
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func statusCode(ctx *context.Context) int {
+	return ctx.GetOutputResponse().(*httpprot.Response).StatusCode()
+}
+
+func TestReplayGuard(t *testing.T) {
+	assert := assert.New(t)
+
+	current := time.Unix(1700000000, 0)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	rg := newTestReplayGuard(t, nil, `
+kind: ReplayGuard
+name: guard
+timestampHeader: X-Timestamp
+window: 1m
+`)
+	assert.Equal(kind, rg.Kind())
+	assert.Nil(rg.Status())
+	ts := strconv.FormatInt(current.Unix(), 10)
+
+	ctx := newContext("n1", ts)
+	assert.Equal("", rg.Handle(ctx))
+
+	ctx = newContext("n1", ts)
+	assert.Equal(resultInvalid, rg.Handle(ctx))
+	assert.Equal(http.StatusConflict, statusCode(ctx))
+
+	for _, c := range []struct{ nonce, ts string }{
+		{"", ts},
+		{strings.Repeat("n", maxNonceLength+1), ts},
+		{"n2", ""},
+		{"n2", "abc"},
+		{"n2", strconv.FormatInt(current.Unix()-61, 10)},
+		{"n2", strconv.FormatInt(current.Unix()+61, 10)},
+	} {
+		ctx = newContext(c.nonce, c.ts)
+		assert.Equal(resultInvalid, rg.Handle(ctx))
+		assert.Equal(http.StatusBadRequest, statusCode(ctx))
+	}
+
+	// nonces are tracked for twice the window when timestamps are checked.
+	current = current.Add(time.Minute + time.Second)
+	ctx = newContext("n1", strconv.FormatInt(current.Unix()-60, 10))
+	assert.Equal(resultInvalid, rg.Handle(ctx))
+
+	current = current.Add(time.Minute)
+	ctx = newContext("n1", strconv.FormatInt(current.Unix(), 10))
+	assert.Equal("", rg.Handle(ctx))
+
+	// nonces are kept after the pipeline is updated.
+	newRg := kind.CreateInstance(rg.spec).(*ReplayGuard)
+	newRg.Inherit(rg)
+	ctx = newContext("n1", strconv.FormatInt(current.Unix(), 10))
+	assert.Equal(resultInvalid, newRg.Handle(ctx))
+	newRg.Close()
+}
+
+func TestMemoryNonceStore(t *testing.T) {
+	assert := assert.New(t)
+
+	current := time.Unix(1700000000, 0)
+	ms := newMemoryNonceStore(time.Minute, 3)
+
+	for i := 0; i < 3; i++ {
+		claimed, _ := ms.claim(fmt.Sprintf("n%d", i), current)
+		assert.True(claimed)
+	}
+	claimed, _ := ms.claim("n0", current)
+	assert.False(claimed)
+
+	// the oldest nonce is evicted when the store is full.
+	claimed, _ = ms.claim("n3", current)
+	assert.True(claimed)
+	assert.Equal(3, ms.len())
+	claimed, _ = ms.claim("n0", current)
+	assert.True(claimed)
+
+	// expired nonces are purged.
+	current = current.Add(time.Minute)
+	claimed, _ = ms.claim("n3", current)
+	assert.True(claimed)
+	assert.Equal(1, ms.len())
+}
+
+func TestClusterNonceStore(t *testing.T) {
+	assert := assert.New(t)
+
+	var mutex sync.Mutex
+	kvs := map[string]string{}
+	leases := map[string]time.Duration{}
+
+	cls := clustertest.NewMockedCluster()
+	cls.MockedSTM = func(apply func(concurrency.STM) error) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		stm := &clustertest.MockedSTM{
+			MockedGet: func(key ...string) string {
+				return kvs[key[0]]
+			},
+			MockedPut: func(key, val string, opts ...clientv3.OpOption) {
+				kvs[key] = val
+			},
+		}
+		return apply(stm)
+	}
+	cls.MockedPutUnderTimeout = func(key, value string, timeout time.Duration) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		kvs[key] = value
+		leases[key] = timeout
+		return nil
+	}
+
+	super := supervisor.NewMock(nil, cls, nil, nil, false, nil, nil)
+	rg := newTestReplayGuard(t, super, `
+kind: ReplayGuard
+name: guard
+window: 100ms
+cluster: true
+`)
+
+	assert.Equal("", rg.Handle(newContext("n1", "")))
+	assert.Equal(resultInvalid, rg.Handle(newContext("n1", "")))
+	key := "/nonce/data/pipeline/guard/n1"
+	assert.Contains(kvs, key)
+	assert.Equal(time.Second, leases[key])
+
+	// the nonce expires even if the lease is not revoked in time.
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal("", rg.Handle(newContext("n1", "")))
+
+	cls.MockedSTM = func(apply func(concurrency.STM) error) error {
+		return fmt.Errorf("mocked error")
+	}
+	ctx := newContext("n2", "")
+	assert.Equal(resultInvalid, rg.Handle(ctx))
+	assert.Equal(http.StatusServiceUnavailable, statusCode(ctx))
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, window := range []string{"abc", "-1s"} {
+		rawSpec := map[string]interface{}{"kind": Kind, "name": "guard", "window": window}
+		_, err := filters.NewSpec(nil, "", rawSpec)
+		assert.NotNil(err, window)
+	}
+
+	// fall back to the memory store without a cluster.
+	rg := newTestReplayGuard(t, nil, `
+kind: ReplayGuard
+name: guard
+cluster: true
+`)
+	_, ok := rg.store.(*memoryNonceStore)
+	assert.True(ok)
+	assert.Equal(defaultWindow, rg.window)
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package requestdecompressor implements a filter which decompresses the
+// body of requests according to their Content-Encoding.
+package requestdecompressor
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of RequestDecompressor.
+	Kind = "RequestDecompressor"
+
+	resultUnsupportedEncoding = "unsupportedEncoding"
+	resultDecompressFailed    = "decompressFailed"
+	resultTooLarge            = "tooLarge"
+
+	keyContentEncoding = "Content-Encoding"
+	keyContentLength   = "Content-Length"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "RequestDecompressor decompresses the body of requests according to their Content-Encoding.",
+	Results:     []string{resultUnsupportedEncoding, resultDecompressFailed, resultTooLarge},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &RequestDecompressor{spec: spec.(*Spec)}
+	},
+}
+
+// decoders are the supported encodings, "x-gzip" is an alias of "gzip"
+// per RFC 9110, and "deflate" is the zlib format.
+var decoders = map[string]func(io.Reader) (io.ReadCloser, error){
+	"gzip": func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	"x-gzip": func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	"deflate": func(r io.Reader) (io.ReadCloser, error) {
+		return zlib.NewReader(r)
+	},
+	"zstd": func(r io.Reader) (io.ReadCloser, error) {
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	},
+}
+
+var errTooLarge = errors.New("decompressed body is too large")
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// RequestDecompressor is the filter RequestDecompressor.
+	RequestDecompressor struct {
+		spec *Spec
+
+		decompressed uint64
+		rejected     uint64
+	}
+
+	// Spec is the spec of RequestDecompressor.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// MaxDecompressedSize is the max size of the decompressed body,
+		// to guard against decompression bombs.
+		MaxDecompressedSize int64 `json:"maxDecompressedSize,omitempty" jsonschema:"minimum=0"`
+	}
+
+	// Status is the status of RequestDecompressor.
+	Status struct {
+		Decompressed uint64 `json:"decompressed"`
+		Rejected     uint64 `json:"rejected"`
+	}
+
+	// limitedReader is like io.LimitedReader, but it fails when the
+	// limit is exceeded, instead of returning io.EOF.
+	limitedReader struct {
+		r io.Reader
+		n int64
+	}
+
+	// streamReader closes the decompressors when it is closed.
+	streamReader struct {
+		io.Reader
+		close func()
+	}
+)
+
+// Name returns the name of the RequestDecompressor filter instance.
+func (rd *RequestDecompressor) Name() string {
+	return rd.spec.Name()
+}
+
+// Kind returns the kind of RequestDecompressor.
+func (rd *RequestDecompressor) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the RequestDecompressor
+func (rd *RequestDecompressor) Spec() filters.Spec {
+	return rd.spec
+}
+
+// Init initializes RequestDecompressor.
+func (rd *RequestDecompressor) Init() {
+}
+
+// Inherit inherits previous generation of RequestDecompressor.
+func (rd *RequestDecompressor) Inherit(previousGeneration filters.Filter) {
+	rd.Init()
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.n -= int64(n)
+	if lr.n < 0 {
+		return n, errTooLarge
+	}
+	return n, err
+}
+
+func (rd *RequestDecompressor) maxDecompressedSize() int64 {
+	if rd.spec.MaxDecompressedSize > 0 {
+		return rd.spec.MaxDecompressedSize
+	}
+	return httpprot.DefaultMaxPayloadSize
+}
+
+// encodings returns the encodings of the header in the order they were
+// applied, the identity encoding is skipped.
+func encodings(header http.Header) []string {
+	var result []string
+	for _, v := range header.Values(keyContentEncoding) {
+		for _, e := range strings.Split(v, ",") {
+			e = strings.ToLower(strings.TrimSpace(e))
+			if e != "" && e != "identity" {
+				result = append(result, e)
+			}
+		}
+	}
+	return result
+}
+
+func (rd *RequestDecompressor) reject(ctx *context.Context, code int, result, msg string) string {
+	atomic.AddUint64(&rd.rejected, 1)
+	ctx.AddTag("requestDecompressor: " + msg)
+
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(code)
+	ctx.SetOutputResponse(resp)
+	return result
+}
+
+// Handle decompresses the body of the request, and removes the
+// Content-Encoding header.
+func (rd *RequestDecompressor) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	encs := encodings(req.HTTPHeader())
+	if len(encs) == 0 {
+		return ""
+	}
+
+	for _, e := range encs {
+		if _, ok := decoders[e]; !ok {
+			msg := fmt.Sprintf("unsupported encoding %q", e)
+			return rd.reject(ctx, http.StatusUnsupportedMediaType, resultUnsupportedEncoding, msg)
+		}
+	}
+
+	// the encodings are removed in the reverse order of applying.
+	var r io.Reader = req.GetPayload()
+	var closers []io.Closer
+	closeAll := func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}
+	for i := len(encs) - 1; i >= 0; i-- {
+		zr, err := decoders[encs[i]](r)
+		if err != nil {
+			closeAll()
+			msg := fmt.Sprintf("failed to decompress %s body: %v", encs[i], err)
+			return rd.reject(ctx, http.StatusBadRequest, resultDecompressFailed, msg)
+		}
+		closers = append(closers, zr)
+		r = zr
+	}
+	r = &limitedReader{r: r, n: rd.maxDecompressedSize()}
+
+	if req.IsStream() {
+		// errors are reported when the stream is read by the next
+		// filters, e.g. the proxy.
+		req.SetPayload(&streamReader{Reader: r, close: closeAll})
+		req.ContentLength = -1
+		req.HTTPHeader().Del(keyContentLength)
+	} else {
+		data, err := io.ReadAll(r)
+		closeAll()
+		if err == errTooLarge {
+			return rd.reject(ctx, http.StatusRequestEntityTooLarge, resultTooLarge, err.Error())
+		}
+		if err != nil {
+			msg := fmt.Sprintf("failed to decompress body: %v", err)
+			return rd.reject(ctx, http.StatusBadRequest, resultDecompressFailed, msg)
+		}
+		req.SetPayload(data)
+		req.ContentLength = int64(len(data))
+		req.HTTPHeader().Set(keyContentLength, strconv.Itoa(len(data)))
+	}
+
+	req.HTTPHeader().Del(keyContentEncoding)
+	atomic.AddUint64(&rd.decompressed, 1)
+	return ""
+}
+
+// Close implements io.Closer.
+func (sr *streamReader) Close() error {
+	sr.close()
+	return nil
+}
+
+// Status returns status.
+func (rd *RequestDecompressor) Status() interface{} {
+	return &Status{
+		Decompressed: atomic.LoadUint64(&rd.decompressed),
+		Rejected:     atomic.LoadUint64(&rd.rejected),
+	}
+}
+
+// Close closes RequestDecompressor.
+func (rd *RequestDecompressor) Close() {
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package requestdecompressor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestFilter(yamlConfig string) *RequestDecompressor {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		panic(err)
+	}
+	rd := kind.CreateInstance(spec).(*RequestDecompressor)
+	rd.Init()
+	return rd
+}
+
+func gzipData(data []byte) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func zlibData(data []byte) []byte {
+	buf := &bytes.Buffer{}
+	w := zlib.NewWriter(buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func zstdData(data []byte) []byte {
+	buf := &bytes.Buffer{}
+	w, _ := zstd.NewWriter(buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func newContext(body []byte, encoding string, stream bool) (*context.Context, *httpprot.Request) {
+	stdr, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/", bytes.NewReader(body))
+	if encoding != "" {
+		stdr.Header.Set("Content-Encoding", encoding)
+	}
+	stdr.Header.Set("Content-Length", "1")
+	req, _ := httpprot.NewRequest(stdr)
+	if stream {
+		req.FetchPayload(-1)
+	} else {
+		req.FetchPayload(0)
+	}
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, req
+}
+
+func TestRequestDecompressor(t *testing.T) {
+	assert := assert.New(t)
+
+	rd := newTestFilter(`
+kind: RequestDecompressor
+name: rd
+maxDecompressedSize: 100
+`)
+	assert.Equal(kind, rd.Kind())
+	assert.Equal("rd", rd.Name())
+
+	plain := []byte(strings.Repeat("hello ", 10))
+
+	for _, c := range []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", plain},
+		{"identity", plain},
+		{"gzip", gzipData(plain)},
+		{"X-GZIP", gzipData(plain)},
+		{"deflate", zlibData(plain)},
+		{"zstd", zstdData(plain)},
+		{"deflate, gzip", gzipData(zlibData(plain))},
+	} {
+		ctx, req := newContext(c.body, c.encoding, false)
+		assert.Equal("", rd.Handle(ctx), c.encoding)
+		assert.Equal(plain, req.RawPayload(), c.encoding)
+		if c.encoding != "" && c.encoding != "identity" {
+			assert.Equal("", req.HTTPHeader().Get("Content-Encoding"))
+			assert.Equal("60", req.HTTPHeader().Get("Content-Length"))
+			assert.Equal(int64(60), req.ContentLength)
+		}
+	}
+
+	ctx, req := newContext(gzipData(plain), "gzip", true)
+	assert.Equal("", rd.Handle(ctx))
+	data, err := io.ReadAll(req.GetPayload())
+	assert.NoError(err)
+	assert.Equal(plain, data)
+	assert.Equal(int64(-1), req.ContentLength)
+	assert.Equal("", req.HTTPHeader().Get("Content-Length"))
+	req.Close()
+
+	// unsupported encoding.
+	ctx, req = newContext(plain, "br", false)
+	assert.Equal(resultUnsupportedEncoding, rd.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusUnsupportedMediaType, resp.StatusCode())
+	assert.Equal("br", req.HTTPHeader().Get("Content-Encoding"))
+
+	// corrupted body.
+	ctx, _ = newContext(plain, "gzip", false)
+	assert.Equal(resultDecompressFailed, rd.Handle(ctx))
+	resp = ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode())
+
+	body := gzipData(plain)
+	ctx, _ = newContext(body[:len(body)-8], "gzip", false)
+	assert.Equal(resultDecompressFailed, rd.Handle(ctx))
+
+	// decompression bomb.
+	bomb := gzipData(make([]byte, 1<<20))
+	ctx, _ = newContext(bomb, "gzip", false)
+	assert.Equal(resultTooLarge, rd.Handle(ctx))
+	resp = ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusRequestEntityTooLarge, resp.StatusCode())
+
+	ctx, req = newContext(bomb, "gzip", true)
+	assert.Equal("", rd.Handle(ctx))
+	_, err = io.ReadAll(req.GetPayload())
+	assert.Equal(errTooLarge, err)
+
+	status := rd.Status().(*Status)
+	assert.Equal(uint64(7), status.Decompressed)
+	assert.Equal(uint64(4), status.Rejected)
+
+	rd.Inherit(rd)
+	rd.Close()
+}
@@ -0,0 +1,309 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package requestsigner implements a filter which signs requests, so that
+// backends can verify the requests come through Easegress.
+package requestsigner
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of RequestSigner.
+	Kind = "RequestSigner"
+
+	// AlgorithmEd25519 signs requests with Ed25519.
+	AlgorithmEd25519 = "Ed25519"
+	// AlgorithmRS256 signs requests with RSASSA-PKCS1-v1_5 using SHA-256.
+	AlgorithmRS256 = "RS256"
+
+	// DefaultSignatureHeader is the default header to carry the signature.
+	DefaultSignatureHeader = "X-Eg-Signature"
+
+	resultSignFailed = "signFailed"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "RequestSigner signs requests with the private key of the gateway.",
+	Results:     []string{resultSignFailed},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{Header: DefaultSignatureHeader}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &RequestSigner{spec: spec.(*Spec)}
+	},
+}
+
+// now is replaced in tests.
+var now = time.Now
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// RequestSigner is the filter RequestSigner.
+	RequestSigner struct {
+		spec    *Spec
+		keyID   string
+		key     crypto.Signer
+		headers []string
+	}
+
+	// Spec is the spec of RequestSigner.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		Algorithm string `json:"algorithm" jsonschema:"required,enum=Ed25519,enum=RS256"`
+		Keys      []*Key `json:"keys" jsonschema:"required,minItems=1"`
+		// ActiveKeyID is the ID of the key to sign requests, the first
+		// key is used if it is empty. Keys are rotated by adding the new
+		// key, switching ActiveKeyID to it, and removing the old key
+		// after backends trust the new one.
+		ActiveKeyID   string   `json:"activeKeyID,omitempty"`
+		SignedHeaders []string `json:"signedHeaders,omitempty"`
+		Header        string   `json:"header,omitempty"`
+	}
+
+	// Key is a private key to sign requests.
+	Key struct {
+		ID string `json:"id" jsonschema:"required"`
+		// PrivateKey is the PEM encoded private key, in PKCS #8 format,
+		// or PKCS #1 format for RSA keys.
+		PrivateKey string `json:"privateKey" jsonschema:"required"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	ids := map[string]bool{}
+	for _, k := range spec.Keys {
+		if ids[k.ID] {
+			return fmt.Errorf("duplicated key id %s", k.ID)
+		}
+		ids[k.ID] = true
+
+		if _, err := parsePrivateKey(spec.Algorithm, k.PrivateKey); err != nil {
+			return fmt.Errorf("key %s: %v", k.ID, err)
+		}
+	}
+
+	if spec.ActiveKeyID != "" && !ids[spec.ActiveKeyID] {
+		return fmt.Errorf("active key %s not found", spec.ActiveKeyID)
+	}
+
+	for _, h := range spec.SignedHeaders {
+		if strings.TrimSpace(h) == "" {
+			return fmt.Errorf("empty signed header")
+		}
+	}
+
+	return nil
+}
+
+func parsePrivateKey(algorithm, data string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM data")
+	}
+
+	var key interface{}
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		if algorithm == AlgorithmEd25519 {
+			return k, nil
+		}
+	case *rsa.PrivateKey:
+		if algorithm == AlgorithmRS256 {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("key type %T does not match algorithm %s", key, algorithm)
+}
+
+// Name returns the name of the RequestSigner filter instance.
+func (rs *RequestSigner) Name() string {
+	return rs.spec.Name()
+}
+
+// Kind returns the kind of RequestSigner.
+func (rs *RequestSigner) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the RequestSigner
+func (rs *RequestSigner) Spec() filters.Spec {
+	return rs.spec
+}
+
+// Init initializes RequestSigner.
+func (rs *RequestSigner) Init() {
+	rs.reload()
+}
+
+// Inherit inherits previous generation of RequestSigner.
+func (rs *RequestSigner) Inherit(previousGeneration filters.Filter) {
+	rs.Init()
+}
+
+func (rs *RequestSigner) reload() {
+	key := rs.spec.Keys[0]
+	for _, k := range rs.spec.Keys {
+		if k.ID == rs.spec.ActiveKeyID {
+			key = k
+			break
+		}
+	}
+
+	// the key has been verified in Validate, so no error here.
+	rs.keyID = key.ID
+	rs.key, _ = parsePrivateKey(rs.spec.Algorithm, key.PrivateKey)
+
+	for _, h := range rs.spec.SignedHeaders {
+		rs.headers = append(rs.headers, strings.ToLower(strings.TrimSpace(h)))
+	}
+
+	if rs.spec.Header == "" {
+		rs.spec.Header = DefaultSignatureHeader
+	}
+}
+
+// canonicalize builds the string to sign, it consists of the lines below
+// joined by '\n' (no trailing newline):
+//
+//	<upper case method>
+//	<escaped path>
+//	<raw query>
+//	<timestamp in unix seconds>
+//	<header name in lower case>:<values joined by ','>   (one per signed header)
+//
+// Header values are trimmed, a missing header has an empty value, and the
+// Host header is taken from the host of the request.
+func canonicalize(req *httpprot.Request, headers []string, timestamp int64) string {
+	std := req.Std()
+
+	var sb strings.Builder
+	sb.WriteString(strings.ToUpper(std.Method))
+	sb.WriteByte('\n')
+	sb.WriteString(std.URL.EscapedPath())
+	sb.WriteByte('\n')
+	sb.WriteString(std.URL.RawQuery)
+	sb.WriteByte('\n')
+	sb.WriteString(strconv.FormatInt(timestamp, 10))
+
+	for _, name := range headers {
+		var values []string
+		if name == "host" {
+			values = []string{req.Host()}
+		} else {
+			// copy the values to avoid modifying the header.
+			values = append(values, std.Header.Values(name)...)
+		}
+		for i := range values {
+			values[i] = strings.TrimSpace(values[i])
+		}
+
+		sb.WriteByte('\n')
+		sb.WriteString(name)
+		sb.WriteByte(':')
+		sb.WriteString(strings.Join(values, ","))
+	}
+
+	return sb.String()
+}
+
+func (rs *RequestSigner) sign(data string) (string, error) {
+	var sig []byte
+	var err error
+
+	switch rs.spec.Algorithm {
+	case AlgorithmEd25519:
+		sig, err = rs.key.Sign(rand.Reader, []byte(data), crypto.Hash(0))
+	case AlgorithmRS256:
+		digest := sha256.Sum256([]byte(data))
+		sig, err = rs.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Handle signs the request.
+func (rs *RequestSigner) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	timestamp := now().Unix()
+
+	sig, err := rs.sign(canonicalize(req, rs.headers, timestamp))
+	if err != nil {
+		logger.Errorf("%s: sign request failed: %v", rs.Name(), err)
+		ctx.AddTag("requestSigner: sign failed")
+		resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+		if resp == nil {
+			resp, _ = httpprot.NewResponse(nil)
+		}
+		resp.SetStatusCode(http.StatusInternalServerError)
+		ctx.SetOutputResponse(resp)
+		return resultSignFailed
+	}
+
+	// Set overwrites the header sent by the client, so it can't be forged.
+	value := fmt.Sprintf(`keyId="%s",algorithm="%s",timestamp="%d",headers="%s",signature="%s"`,
+		rs.keyID, rs.spec.Algorithm, timestamp, strings.Join(rs.headers, " "), sig)
+	req.HTTPHeader().Set(rs.spec.Header, value)
+	return ""
+}
+
+// Status returns status.
+func (rs *RequestSigner) Status() interface{} {
+	return nil
+}
+
+// Close closes RequestSigner.
+func (rs *RequestSigner) Close() {
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package requestsigner
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+	now = func() time.Time { return time.Unix(1700000000, 0) }
+}
+
+func pemPKCS8(t *testing.T, key interface{}) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.Nil(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func newTestRequestSigner(t *testing.T, rawSpec map[string]interface{}) (*RequestSigner, error) {
+	rawSpec["name"] = "signer"
+	rawSpec["kind"] = Kind
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	rs := kind.CreateInstance(spec).(*RequestSigner)
+	rs.Init()
+	return rs, nil
+}
+
+func newContext() (*context.Context, *http.Request) {
+	stdReq := httptest.NewRequest(http.MethodPost, "http://example.com/api/v1/users%2Fx?b=2&a=1", nil)
+	stdReq.Header.Add("X-User", " alice ")
+	stdReq.Header.Add("X-User", "bob")
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, stdReq
+}
+
+var sigRegexp = regexp.MustCompile(`^keyId="([^"]*)",algorithm="([^"]*)",timestamp="(\d+)",headers="([^"]*)",signature="([^"]*)"$`)
+
+func TestCanonicalize(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, stdReq := newContext()
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	got := canonicalize(req, []string{"host", "x-user", "x-missing"}, 1700000000)
+	expected := "POST\n/api/v1/users%2Fx\nb=2&a=1\n1700000000\nhost:example.com\nx-user:alice,bob\nx-missing:"
+	assert.Equal(expected, got)
+
+	// the header must not be modified.
+	assert.Equal(" alice ", stdReq.Header.Values("X-User")[0])
+}
+
+func TestEd25519(t *testing.T) {
+	assert := assert.New(t)
+
+	pub1, priv1, _ := ed25519.GenerateKey(rand.Reader)
+	pub2, priv2, _ := ed25519.GenerateKey(rand.Reader)
+	rs, err := newTestRequestSigner(t, map[string]interface{}{
+		"algorithm":     AlgorithmEd25519,
+		"signedHeaders": []string{"Host", "X-User"},
+		"keys": []map[string]interface{}{
+			{"id": "k1", "privateKey": pemPKCS8(t, priv1)},
+			{"id": "k2", "privateKey": pemPKCS8(t, priv2)},
+		},
+	})
+	assert.Nil(err)
+	assert.Equal(kind, rs.Kind())
+	assert.Nil(rs.Status())
+
+	ctx, stdReq := newContext()
+	stdReq.Header.Set(DefaultSignatureHeader, "forged")
+	assert.Equal("", rs.Handle(ctx))
+
+	m := sigRegexp.FindStringSubmatch(stdReq.Header.Get(DefaultSignatureHeader))
+	assert.NotNil(m)
+	assert.Equal("k1", m[1])
+	assert.Equal(AlgorithmEd25519, m[2])
+	assert.Equal("1700000000", m[3])
+	assert.Equal("host x-user", m[4])
+
+	sig, err := base64.RawURLEncoding.DecodeString(m[5])
+	assert.Nil(err)
+	data := canonicalize(ctx.GetInputRequest().(*httpprot.Request), rs.headers, 1700000000)
+	assert.True(ed25519.Verify(pub1, []byte(data), sig))
+
+	// rotate to the second key.
+	rs.spec.ActiveKeyID = "k2"
+	newRs := kind.CreateInstance(rs.spec).(*RequestSigner)
+	newRs.Inherit(rs)
+	rs.Close()
+
+	ctx, stdReq = newContext()
+	assert.Equal("", newRs.Handle(ctx))
+	m = sigRegexp.FindStringSubmatch(stdReq.Header.Get(DefaultSignatureHeader))
+	assert.Equal("k2", m[1])
+	sig, _ = base64.RawURLEncoding.DecodeString(m[5])
+	assert.True(ed25519.Verify(pub2, []byte(data), sig))
+	assert.False(ed25519.Verify(pub1, []byte(data), sig))
+}
+
+func TestRS256(t *testing.T) {
+	assert := assert.New(t)
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	pkcs1 := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(priv),
+	}))
+
+	rs, err := newTestRequestSigner(t, map[string]interface{}{
+		"algorithm": AlgorithmRS256,
+		"header":    "X-Signature",
+		"keys": []map[string]interface{}{
+			{"id": "rsa", "privateKey": pkcs1},
+		},
+	})
+	assert.Nil(err)
+
+	ctx, stdReq := newContext()
+	assert.Equal("", rs.Handle(ctx))
+	m := sigRegexp.FindStringSubmatch(stdReq.Header.Get("X-Signature"))
+	assert.NotNil(m)
+	assert.Equal("", m[4])
+
+	sig, _ := base64.RawURLEncoding.DecodeString(m[5])
+	digest := sha256.Sum256([]byte("POST\n/api/v1/users%2Fx\nb=2&a=1\n1700000000"))
+	assert.Nil(rsa.VerifyPKCS1v15(&priv.PublicKey, crypto.SHA256, digest[:], sig))
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	_, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	edKey := pemPKCS8(t, edPriv)
+
+	// algorithm mismatch
+	_, err := newTestRequestSigner(t, map[string]interface{}{
+		"algorithm": AlgorithmRS256,
+		"keys":      []map[string]interface{}{{"id": "k1", "privateKey": edKey}},
+	})
+	assert.NotNil(err)
+
+	// invalid PEM
+	_, err = newTestRequestSigner(t, map[string]interface{}{
+		"algorithm": AlgorithmEd25519,
+		"keys":      []map[string]interface{}{{"id": "k1", "privateKey": "invalid"}},
+	})
+	assert.NotNil(err)
+
+	// duplicated key id
+	_, err = newTestRequestSigner(t, map[string]interface{}{
+		"algorithm": AlgorithmEd25519,
+		"keys": []map[string]interface{}{
+			{"id": "k1", "privateKey": edKey},
+			{"id": "k1", "privateKey": edKey},
+		},
+	})
+	assert.NotNil(err)
+
+	// active key not found
+	_, err = newTestRequestSigner(t, map[string]interface{}{
+		"algorithm":   AlgorithmEd25519,
+		"activeKeyID": "k2",
+		"keys":        []map[string]interface{}{{"id": "k1", "privateKey": edKey}},
+	})
+	assert.NotNil(err)
+
+	// no keys
+	_, err = newTestRequestSigner(t, map[string]interface{}{
+		"algorithm": AlgorithmEd25519,
+	})
+	assert.NotNil(err)
+}
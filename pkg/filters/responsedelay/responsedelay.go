@@ -0,0 +1,252 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package responsedelay implements a filter which delays responses to
+// simulate latency.
+package responsedelay
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of ResponseDelay.
+	Kind = "ResponseDelay"
+
+	resultCancelled = "cancelled"
+
+	// DistributionFixed delays all requests by the same duration.
+	DistributionFixed = "fixed"
+	// DistributionUniform draws the delay uniformly from [min, max].
+	DistributionUniform = "uniform"
+	// DistributionNormal draws the delay from a normal distribution.
+	DistributionNormal = "normal"
+	// DistributionExponential draws the delay from an exponential
+	// distribution.
+	DistributionExponential = "exponential"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "ResponseDelay delays responses by a fixed or random duration to simulate latency.",
+	Results:     []string{resultCancelled},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{Distribution: DistributionFixed, Percentage: 100}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &ResponseDelay{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// ResponseDelay is the filter ResponseDelay.
+	ResponseDelay struct {
+		spec *Spec
+
+		delay, min, max, mean, stdDev time.Duration
+
+		delayed    uint64
+		cancelled  uint64
+		totalDelay int64
+	}
+
+	// Spec is the spec of ResponseDelay.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		Distribution string `json:"distribution,omitempty" jsonschema:"enum=fixed,enum=uniform,enum=normal,enum=exponential"`
+		// Delay is the delay of the fixed distribution.
+		Delay string `json:"delay,omitempty" jsonschema:"format=duration"`
+		// Min and Max are the range of the uniform distribution, they
+		// also bound the delays of other random distributions.
+		Min string `json:"min,omitempty" jsonschema:"format=duration"`
+		Max string `json:"max,omitempty" jsonschema:"format=duration"`
+		// Mean is the mean of the normal and exponential distributions.
+		Mean   string `json:"mean,omitempty" jsonschema:"format=duration"`
+		StdDev string `json:"stdDev,omitempty" jsonschema:"format=duration"`
+		// Percentage is the percentage of requests to delay.
+		Percentage float64 `json:"percentage,omitempty" jsonschema:"minimum=0,maximum=100"`
+	}
+
+	// Status is the status of ResponseDelay.
+	Status struct {
+		Delayed      uint64 `json:"delayed"`
+		Cancelled    uint64 `json:"cancelled"`
+		AverageDelay string `json:"averageDelay"`
+	}
+)
+
+func parseDuration(name, s string, required bool) (time.Duration, error) {
+	if s == "" {
+		if required {
+			return 0, fmt.Errorf("%s is required", name)
+		}
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, s)
+	}
+	return d, nil
+}
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	dist := spec.Distribution
+	if _, err := parseDuration("delay", spec.Delay, dist == "" || dist == DistributionFixed); err != nil {
+		return err
+	}
+
+	min, err := parseDuration("min", spec.Min, dist == DistributionUniform)
+	if err != nil {
+		return err
+	}
+	max, err := parseDuration("max", spec.Max, dist == DistributionUniform)
+	if err != nil {
+		return err
+	}
+	if spec.Max != "" && min > max {
+		return fmt.Errorf("min is greater than max")
+	}
+
+	random := dist == DistributionNormal || dist == DistributionExponential
+	if _, err := parseDuration("mean", spec.Mean, random); err != nil {
+		return err
+	}
+	if _, err := parseDuration("stdDev", spec.StdDev, dist == DistributionNormal); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Name returns the name of the ResponseDelay filter instance.
+func (rd *ResponseDelay) Name() string {
+	return rd.spec.Name()
+}
+
+// Kind returns the kind of ResponseDelay.
+func (rd *ResponseDelay) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the ResponseDelay
+func (rd *ResponseDelay) Spec() filters.Spec {
+	return rd.spec
+}
+
+// Init initializes ResponseDelay.
+func (rd *ResponseDelay) Init() {
+	rd.reload()
+}
+
+// Inherit inherits previous generation of ResponseDelay.
+func (rd *ResponseDelay) Inherit(previousGeneration filters.Filter) {
+	rd.reload()
+}
+
+func (rd *ResponseDelay) reload() {
+	rd.delay, _ = time.ParseDuration(rd.spec.Delay)
+	rd.min, _ = time.ParseDuration(rd.spec.Min)
+	rd.max, _ = time.ParseDuration(rd.spec.Max)
+	rd.mean, _ = time.ParseDuration(rd.spec.Mean)
+	rd.stdDev, _ = time.ParseDuration(rd.spec.StdDev)
+}
+
+// nextDelay returns the delay of the next request, delays of the random
+// distributions are bounded by min and max.
+func (rd *ResponseDelay) nextDelay() time.Duration {
+	var d time.Duration
+	switch rd.spec.Distribution {
+	case DistributionUniform:
+		d = rd.min + time.Duration(rand.Int63n(int64(rd.max-rd.min)+1))
+	case DistributionNormal:
+		d = rd.mean + time.Duration(rand.NormFloat64()*float64(rd.stdDev))
+	case DistributionExponential:
+		d = time.Duration(rand.ExpFloat64() * float64(rd.mean))
+	default:
+		return rd.delay
+	}
+
+	if d < rd.min {
+		d = rd.min
+	}
+	if rd.max > 0 && d > rd.max {
+		d = rd.max
+	}
+	return d
+}
+
+// Handle delays the request, it stops waiting if the client disconnects.
+func (rd *ResponseDelay) Handle(ctx *context.Context) string {
+	if rd.spec.Percentage < 100 && rand.Float64()*100 >= rd.spec.Percentage {
+		return ""
+	}
+
+	d := rd.nextDelay()
+	if d <= 0 {
+		return ""
+	}
+
+	atomic.AddUint64(&rd.delayed, 1)
+	atomic.AddInt64(&rd.totalDelay, int64(d))
+
+	// use a timer instead of time.After, so that it is released at once
+	// if the client disconnects.
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	select {
+	case <-timer.C:
+		return ""
+	case <-req.Context().Done():
+		logger.Debugf("%s: request cancelled in the middle of delay", rd.Name())
+		atomic.AddUint64(&rd.cancelled, 1)
+		ctx.AddTag("responseDelay: cancelled")
+		return resultCancelled
+	}
+}
+
+// Status returns status.
+func (rd *ResponseDelay) Status() interface{} {
+	s := &Status{
+		Delayed:   atomic.LoadUint64(&rd.delayed),
+		Cancelled: atomic.LoadUint64(&rd.cancelled),
+	}
+	avg := time.Duration(0)
+	if s.Delayed > 0 {
+		avg = time.Duration(atomic.LoadInt64(&rd.totalDelay) / int64(s.Delayed))
+	}
+	s.AverageDelay = avg.String()
+	return s
+}
+
+// Close closes ResponseDelay.
+func (rd *ResponseDelay) Close() {
+}
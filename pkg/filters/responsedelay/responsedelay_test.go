@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package responsedelay
+
+import (
+	stdcontext "context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func createResponseDelay(t *testing.T, yamlConfig string) *ResponseDelay {
+	rawSpec := make(map[string]interface{})
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		t.Fatalf("failed to create spec: %v", err)
+	}
+	rd := kind.CreateInstance(spec).(*ResponseDelay)
+	rd.Init()
+	return rd
+}
+
+func newContext(t *testing.T, stdctx stdcontext.Context) *context.Context {
+	stdr, _ := http.NewRequestWithContext(stdctx, http.MethodGet, "http://127.0.0.1/", nil)
+	req, err := httpprot.NewRequest(stdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	invalid := []string{
+		"kind: ResponseDelay\nname: rd",
+		"kind: ResponseDelay\nname: rd\ndelay: abc",
+		"kind: ResponseDelay\nname: rd\ndelay: -1s",
+		"kind: ResponseDelay\nname: rd\ndistribution: uniform\nmin: 10ms",
+		"kind: ResponseDelay\nname: rd\ndistribution: uniform\nmin: 10ms\nmax: 5ms",
+		"kind: ResponseDelay\nname: rd\ndistribution: normal\nmean: 10ms",
+		"kind: ResponseDelay\nname: rd\ndistribution: exponential",
+		"kind: ResponseDelay\nname: rd\ndistribution: pareto\ndelay: 10ms",
+	}
+	for _, c := range invalid {
+		rawSpec := make(map[string]interface{})
+		codectool.MustUnmarshal([]byte(c), &rawSpec)
+		_, err := filters.NewSpec(nil, "", rawSpec)
+		assert.Error(err, c)
+	}
+}
+
+func TestNextDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	rd := createResponseDelay(t, "kind: ResponseDelay\nname: rd\ndelay: 10ms")
+	assert.Equal(10*time.Millisecond, rd.nextDelay())
+
+	rd = createResponseDelay(t, `
+kind: ResponseDelay
+name: rd
+distribution: uniform
+min: 10ms
+max: 20ms
+`)
+	for i := 0; i < 100; i++ {
+		d := rd.nextDelay()
+		assert.GreaterOrEqual(d, 10*time.Millisecond)
+		assert.LessOrEqual(d, 20*time.Millisecond)
+	}
+
+	rd = createResponseDelay(t, `
+kind: ResponseDelay
+name: rd
+distribution: normal
+mean: 50ms
+stdDev: 100ms
+min: 20ms
+max: 80ms
+`)
+	for i := 0; i < 100; i++ {
+		d := rd.nextDelay()
+		assert.GreaterOrEqual(d, 20*time.Millisecond)
+		assert.LessOrEqual(d, 80*time.Millisecond)
+	}
+
+	rd = createResponseDelay(t, `
+kind: ResponseDelay
+name: rd
+distribution: exponential
+mean: 50ms
+max: 100ms
+`)
+	for i := 0; i < 100; i++ {
+		d := rd.nextDelay()
+		assert.GreaterOrEqual(d, time.Duration(0))
+		assert.LessOrEqual(d, 100*time.Millisecond)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	assert := assert.New(t)
+
+	rd := createResponseDelay(t, "kind: ResponseDelay\nname: rd\ndelay: 20ms")
+	assert.Equal(kind, rd.Kind())
+	assert.Equal("rd", rd.Name())
+
+	start := time.Now()
+	ctx := newContext(t, stdcontext.Background())
+	assert.Equal("", rd.Handle(ctx))
+	assert.GreaterOrEqual(time.Since(start), 20*time.Millisecond)
+
+	rd2 := createResponseDelay(t, "kind: ResponseDelay\nname: rd\ndelay: 10s")
+	rd2.Inherit(rd)
+
+	stdctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	start = time.Now()
+	ctx = newContext(t, stdctx)
+	assert.Equal(resultCancelled, rd2.Handle(ctx))
+	assert.Less(time.Since(start), 5*time.Second)
+
+	status := rd2.Status().(*Status)
+	assert.Equal(uint64(1), status.Delayed)
+	assert.Equal(uint64(1), status.Cancelled)
+	assert.Equal("10s", status.AverageDelay)
+
+	rd3 := createResponseDelay(t, "kind: ResponseDelay\nname: rd\ndelay: 10s\npercentage: 0.000001")
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		rd3.Handle(newContext(t, stdcontext.Background()))
+	}
+	assert.Less(time.Since(start), 5*time.Second)
+
+	rd.Close()
+}
@@ -0,0 +1,309 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package schedulewindow implements a filter which only allows requests of
+// some clients to some routes during scheduled windows.
+package schedulewindow
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/builder"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of ScheduleWindow.
+	Kind = "ScheduleWindow"
+
+	resultOutsideWindow = "outsideWindow"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "ScheduleWindow only allows requests of some clients to some routes during scheduled windows.",
+	Results:     []string{resultOutsideWindow},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &ScheduleWindow{spec: spec.(*Spec)}
+	},
+}
+
+// now is replaced in tests.
+var now = time.Now
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// ScheduleWindow is the filter ScheduleWindow.
+	ScheduleWindow struct {
+		spec *Spec
+
+		keyTemplate *builder.Template
+		location    *time.Location
+		rules       []*rule
+
+		allowed  uint64
+		rejected uint64
+	}
+
+	// Spec is the spec of ScheduleWindow.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Key is a template to extract the client key, e.g. the API key.
+		Key      string  `json:"key" jsonschema:"required"`
+		TimeZone string  `json:"timeZone,omitempty"`
+		Rules    []*Rule `json:"rules" jsonschema:"required,minItems=1"`
+	}
+
+	// Rule restricts the requests of the keys to the paths and methods
+	// to the windows. Empty keys, paths or methods match everything.
+	Rule struct {
+		Keys    []string                    `json:"keys,omitempty"`
+		Methods []string                    `json:"methods,omitempty"`
+		Paths   []*stringtool.StringMatcher `json:"paths,omitempty"`
+		Windows []*WindowSpec               `json:"windows" jsonschema:"required,minItems=1"`
+	}
+
+	// WindowSpec is a window which opens at the times of the cron
+	// expression, and lasts for the duration.
+	WindowSpec struct {
+		Schedule string `json:"schedule" jsonschema:"required"`
+		Duration string `json:"duration" jsonschema:"required,format=duration"`
+	}
+
+	// Status is the status of ScheduleWindow.
+	Status struct {
+		Allowed  uint64 `json:"allowed"`
+		Rejected uint64 `json:"rejected"`
+	}
+
+	rule struct {
+		spec    *Rule
+		keys    map[string]struct{}
+		windows []*window
+	}
+
+	window struct {
+		schedule cron.Schedule
+		duration time.Duration
+	}
+
+	// nextWindow is the next window in the rejection response.
+	nextWindow struct {
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+	}
+
+	rejection struct {
+		Error      string      `json:"error"`
+		NextWindow *nextWindow `json:"nextWindow,omitempty"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if _, err := builder.NewTemplate(spec.Key); err != nil {
+		return fmt.Errorf("invalid key: %v", err)
+	}
+	if _, err := time.LoadLocation(spec.TimeZone); err != nil {
+		return fmt.Errorf("invalid timeZone: %v", err)
+	}
+	return nil
+}
+
+// Validate validates the window.
+func (spec *WindowSpec) Validate() error {
+	if _, err := cron.ParseStandard(spec.Schedule); err != nil {
+		return fmt.Errorf("invalid schedule %q: %v", spec.Schedule, err)
+	}
+	d, err := time.ParseDuration(spec.Duration)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %v", spec.Duration, err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("duration %q must be positive", spec.Duration)
+	}
+	return nil
+}
+
+// Name returns the name of the ScheduleWindow filter instance.
+func (sw *ScheduleWindow) Name() string {
+	return sw.spec.Name()
+}
+
+// Kind returns the kind of ScheduleWindow.
+func (sw *ScheduleWindow) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the ScheduleWindow
+func (sw *ScheduleWindow) Spec() filters.Spec {
+	return sw.spec
+}
+
+// Init initializes ScheduleWindow.
+func (sw *ScheduleWindow) Init() {
+	sw.reload()
+}
+
+// Inherit inherits previous generation of ScheduleWindow.
+func (sw *ScheduleWindow) Inherit(previousGeneration filters.Filter) {
+	sw.reload()
+}
+
+func (sw *ScheduleWindow) reload() {
+	sw.keyTemplate = builder.MustNewTemplate(sw.spec.Key)
+	sw.location, _ = time.LoadLocation(sw.spec.TimeZone)
+
+	sw.rules = nil
+	for _, spec := range sw.spec.Rules {
+		r := &rule{spec: spec}
+		if len(spec.Keys) > 0 {
+			r.keys = make(map[string]struct{}, len(spec.Keys))
+			for _, k := range spec.Keys {
+				r.keys[k] = struct{}{}
+			}
+		}
+		for _, p := range spec.Paths {
+			p.Init()
+		}
+		for _, ws := range spec.Windows {
+			schedule, _ := cron.ParseStandard(ws.Schedule)
+			d, _ := time.ParseDuration(ws.Duration)
+			r.windows = append(r.windows, &window{schedule: schedule, duration: d})
+		}
+		sw.rules = append(sw.rules, r)
+	}
+}
+
+func (r *rule) match(key string, req *httpprot.Request) bool {
+	if r.keys != nil {
+		if _, ok := r.keys[key]; !ok {
+			return false
+		}
+	}
+
+	if len(r.spec.Methods) > 0 && !stringtool.StrInSlice(req.Method(), r.spec.Methods) {
+		return false
+	}
+
+	if len(r.spec.Paths) == 0 {
+		return true
+	}
+	for _, p := range r.spec.Paths {
+		if p.Match(req.Path()) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns whether t is inside the window. A window containing t
+// must open in (t-duration, t], that's, the first opening after
+// t-duration is not later than t.
+func (w *window) contains(t time.Time) bool {
+	start := w.schedule.Next(t.Add(-w.duration))
+	return !start.IsZero() && !start.After(t)
+}
+
+// next returns the earliest window of the rule opening after t, it returns
+// nil if none of the windows opens again.
+func (r *rule) next(t time.Time) *nextWindow {
+	var nw *nextWindow
+	for _, w := range r.windows {
+		start := w.schedule.Next(t)
+		if start.IsZero() {
+			continue
+		}
+		if nw == nil || start.Before(nw.Start) {
+			nw = &nextWindow{Start: start, End: start.Add(w.duration)}
+		}
+	}
+	return nw
+}
+
+// Handle allows the request if it is inside a window of the first rule
+// matching it, or no rule matches it.
+func (sw *ScheduleWindow) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	key, err := sw.keyTemplate.Render(ctx)
+	if err != nil {
+		logger.Warnf("%s: failed to render key: %v", sw.Name(), err)
+	}
+
+	var r *rule
+	for _, candidate := range sw.rules {
+		if candidate.match(key, req) {
+			r = candidate
+			break
+		}
+	}
+	if r == nil {
+		return ""
+	}
+
+	t := now().In(sw.location)
+	for _, w := range r.windows {
+		if w.contains(t) {
+			atomic.AddUint64(&sw.allowed, 1)
+			return ""
+		}
+	}
+
+	atomic.AddUint64(&sw.rejected, 1)
+	ctx.AddTag(fmt.Sprintf("scheduleWindow: %s is outside of the scheduled windows", key))
+
+	body, _ := json.Marshal(&rejection{
+		Error:      "outside of the scheduled windows",
+		NextWindow: r.next(t),
+	})
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(http.StatusForbidden)
+	resp.HTTPHeader().Set("Content-Type", "application/json")
+	resp.SetPayload(body)
+	ctx.SetOutputResponse(resp)
+	return resultOutsideWindow
+}
+
+// Status returns status.
+func (sw *ScheduleWindow) Status() interface{} {
+	return &Status{
+		Allowed:  atomic.LoadUint64(&sw.allowed),
+		Rejected: atomic.LoadUint64(&sw.rejected),
+	}
+}
+
+// Close closes ScheduleWindow.
+func (sw *ScheduleWindow) Close() {
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schedulewindow
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestFilter(yamlConfig string) *ScheduleWindow {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		panic(err)
+	}
+	sw := kind.CreateInstance(spec).(*ScheduleWindow)
+	sw.Init()
+	return sw
+}
+
+func newContext(method, path, key string) *context.Context {
+	stdr, _ := http.NewRequest(method, "http://127.0.0.1"+path, nil)
+	if key != "" {
+		stdr.Header.Set("X-Api-Key", key)
+	}
+	req, _ := httpprot.NewRequest(stdr)
+	req.FetchPayload(0)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpl := `
+kind: ScheduleWindow
+name: sw
+key: '{{.req.Header.Get "X-Api-Key"}}'
+timeZone: %s
+rules:
+- windows:
+  - schedule: "%s"
+    duration: %s
+`
+	newSpec := func(tz, schedule, duration string) error {
+		rawSpec := map[string]interface{}{}
+		codectool.MustUnmarshal([]byte(fmt.Sprintf(tmpl, tz, schedule, duration)), &rawSpec)
+		_, err := filters.NewSpec(nil, "", rawSpec)
+		return err
+	}
+
+	assert.NoError(newSpec("UTC", "0 22 * * *", "8h"))
+	assert.Error(newSpec("Mars/Olympus", "0 22 * * *", "8h"))
+	assert.Error(newSpec("UTC", "0 25 * * *", "8h"))
+	assert.Error(newSpec("UTC", "0 22 * * *", "-1h"))
+}
+
+func TestScheduleWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	sw := newTestFilter(`
+kind: ScheduleWindow
+name: sw
+key: '{{.req.Header.Get "X-Api-Key"}}'
+timeZone: Asia/Shanghai
+rules:
+- keys: ["batch"]
+  methods: ["POST"]
+  paths:
+  - prefix: /bulk
+  windows:
+  - schedule: "0 22 * * *"
+    duration: 8h
+  - schedule: "0 12 * * 6"
+    duration: 1h
+- keys: ["batch"]
+  windows:
+  - schedule: "0 0 1 1 *"
+    duration: 1m
+`)
+	assert.Equal(kind, sw.Kind())
+	assert.Equal("sw", sw.Name())
+
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	setNow := func(s string) {
+		tm, _ := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		now = func() time.Time { return tm }
+	}
+	defer func() { now = time.Now }()
+
+	// 2024-03-06 is a Wednesday.
+	setNow("2024-03-06 23:00")
+	assert.Equal("", sw.Handle(newContext(http.MethodPost, "/bulk/import", "batch")))
+
+	// the window opened the day before.
+	setNow("2024-03-07 05:59")
+	assert.Equal("", sw.Handle(newContext(http.MethodPost, "/bulk/import", "batch")))
+
+	// other keys are not restricted.
+	setNow("2024-03-07 10:00")
+	assert.Equal("", sw.Handle(newContext(http.MethodPost, "/bulk/import", "other")))
+	assert.Equal("", sw.Handle(newContext(http.MethodPost, "/bulk/import", "")))
+
+	ctx := newContext(http.MethodPost, "/bulk/import", "batch")
+	assert.Equal(resultOutsideWindow, sw.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusForbidden, resp.StatusCode())
+
+	r := &rejection{}
+	assert.NoError(json.Unmarshal(resp.RawPayload(), r))
+	assert.Equal("2024-03-07T22:00:00+08:00", r.NextWindow.Start.Format(time.RFC3339))
+	assert.Equal("2024-03-08T06:00:00+08:00", r.NextWindow.End.Format(time.RFC3339))
+
+	// the saturday window is earlier.
+	setNow("2024-03-09 10:00")
+	ctx = newContext(http.MethodPost, "/bulk/import", "batch")
+	assert.Equal(resultOutsideWindow, sw.Handle(ctx))
+	resp = ctx.GetOutputResponse().(*httpprot.Response)
+	r = &rejection{}
+	assert.NoError(json.Unmarshal(resp.RawPayload(), r))
+	assert.Equal("2024-03-09T12:00:00+08:00", r.NextWindow.Start.Format(time.RFC3339))
+
+	setNow("2024-03-09 12:30")
+	assert.Equal("", sw.Handle(newContext(http.MethodPost, "/bulk/import", "batch")))
+
+	// the second rule applies to other requests of the key.
+	assert.Equal(resultOutsideWindow, sw.Handle(newContext(http.MethodGet, "/bulk/import", "batch")))
+	setNow("2025-01-01 00:00")
+	assert.Equal("", sw.Handle(newContext(http.MethodGet, "/users", "batch")))
+
+	status := sw.Status().(*Status)
+	assert.Equal(uint64(4), status.Allowed)
+	assert.Equal(uint64(3), status.Rejected)
+
+	sw.Inherit(sw)
+	sw.Close()
+}
@@ -0,0 +1,236 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schemaguard
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/xeipuuv/gojsonschema"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/megaease/easegress/v2/pkg/util/fasttime"
+)
+
+const (
+	schemaTypeJSON = "JSON"
+	// schemaTypeAvro is the default schema type of the registry.
+	schemaTypeAvro = "AVRO"
+)
+
+type (
+	// registryClient is the client of a schema registry compatible with
+	// the API of the Confluent Schema Registry.
+	registryClient struct {
+		spec   *RegistrySpec
+		client *http.Client
+		ttl    time.Duration
+
+		mu sync.Mutex
+		// schemas are cached by ID forever, as a schema never changes
+		// after it is registered.
+		schemas map[int]*schema
+		// failures are the errors of getting schemas by ID, which are
+		// cached for negativeCacheTTL.
+		failures *lru.Cache
+		// versions are the cached versions of the subject.
+		versions        []int
+		versionsFetched time.Time
+
+		// group merges the concurrent requests to the registry.
+		group singleflight.Group
+	}
+
+	failure struct {
+		err     error
+		expires time.Time
+	}
+
+	schema struct {
+		id     int
+		schema *gojsonschema.Schema
+	}
+
+	schemaResponse struct {
+		Schema     string `json:"schema"`
+		SchemaType string `json:"schemaType"`
+		ID         int    `json:"id"`
+	}
+)
+
+func newRegistryClient(spec *RegistrySpec) *registryClient {
+	timeout := defaultRegistryTimeout
+	if spec.Timeout != "" {
+		timeout, _ = time.ParseDuration(spec.Timeout)
+	}
+	ttl := defaultCacheTTL
+	if spec.CacheTTL != "" {
+		ttl, _ = time.ParseDuration(spec.CacheTTL)
+	}
+	failures, _ := lru.New(negativeCacheSize)
+	return &registryClient{
+		spec:     spec,
+		client:   &http.Client{Timeout: timeout},
+		ttl:      ttl,
+		schemas:  map[int]*schema{},
+		failures: failures,
+	}
+}
+
+func (rc *registryClient) get(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(rc.spec.URL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json, application/json")
+	if rc.spec.Username != "" {
+		req.SetBasicAuth(rc.spec.Username, rc.spec.Password)
+	}
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status code %d: %s", path, resp.StatusCode, body)
+	}
+	return codectool.UnmarshalJSON(body, v)
+}
+
+func compileSchema(id int, sr *schemaResponse) (*schema, error) {
+	schemaType := sr.SchemaType
+	if schemaType == "" {
+		schemaType = schemaTypeAvro
+	}
+	if schemaType != schemaTypeJSON {
+		return nil, fmt.Errorf("%w %d: unsupported schema type %s", errInvalidSchema, id, schemaType)
+	}
+
+	s, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(sr.Schema))
+	if err != nil {
+		return nil, fmt.Errorf("%w %d: %v", errInvalidSchema, id, err)
+	}
+	return &schema{id: id, schema: s}, nil
+}
+
+// schemaByID returns the schema of the ID.
+func (rc *registryClient) schemaByID(id int) (*schema, error) {
+	rc.mu.Lock()
+	s := rc.schemas[id]
+	rc.mu.Unlock()
+	if s != nil {
+		return s, nil
+	}
+	if v, ok := rc.failures.Get(id); ok {
+		if f := v.(*failure); fasttime.Now().Before(f.expires) {
+			return nil, f.err
+		}
+	}
+
+	v, err, _ := rc.group.Do("id/"+strconv.Itoa(id), func() (interface{}, error) {
+		sr := &schemaResponse{}
+		err := rc.get(fmt.Sprintf("/schemas/ids/%d", id), sr)
+		var s *schema
+		if err == nil {
+			s, err = compileSchema(id, sr)
+		}
+		if err != nil {
+			rc.failures.Add(id, &failure{err: err, expires: fasttime.Now().Add(negativeCacheTTL)})
+			return nil, err
+		}
+
+		rc.mu.Lock()
+		rc.schemas[id] = s
+		rc.mu.Unlock()
+		rc.failures.Remove(id)
+		return s, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*schema), nil
+}
+
+// subjectSchemas returns the schema IDs of the latest versions of the
+// subject, the latest first, n is the max number of versions to return.
+func (rc *registryClient) subjectSchemas(subject string, n int) ([]int, error) {
+	rc.mu.Lock()
+	if rc.versions != nil && fasttime.Since(rc.versionsFetched) < rc.ttl {
+		ids := rc.versions
+		rc.mu.Unlock()
+		return ids[:min(n, len(ids))], nil
+	}
+	rc.mu.Unlock()
+
+	v, err, _ := rc.group.Do("subject/"+subject, func() (interface{}, error) {
+		return rc.fetchSubjectSchemas(subject)
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := v.([]int)
+	return ids[:min(n, len(ids))], nil
+}
+
+// fetchSubjectSchemas fetches the schema IDs of the latest versions of the
+// subject, the latest first.
+func (rc *registryClient) fetchSubjectSchemas(subject string) ([]int, error) {
+	versions := []int{}
+	path := "/subjects/" + url.PathEscape(subject) + "/versions"
+	if err := rc.get(path, &versions); err != nil {
+		return nil, err
+	}
+
+	// the versions are in ascending order, only the latest versions are
+	// needed to check the compatibility.
+	ids := []int{}
+	for i := len(versions) - 1; i >= 0 && len(ids) < maxCheckedVersions; i-- {
+		sr := &schemaResponse{}
+		if err := rc.get(fmt.Sprintf("%s/%d", path, versions[i]), sr); err != nil {
+			return nil, err
+		}
+		ids = append(ids, sr.ID)
+
+		rc.mu.Lock()
+		if rc.schemas[sr.ID] == nil {
+			if s, err := compileSchema(sr.ID, sr); err == nil {
+				rc.schemas[sr.ID] = s
+			}
+		}
+		rc.mu.Unlock()
+	}
+
+	rc.mu.Lock()
+	rc.versions, rc.versionsFetched = ids, fasttime.Now()
+	rc.mu.Unlock()
+	return ids, nil
+}
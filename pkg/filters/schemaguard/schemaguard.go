@@ -0,0 +1,325 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package schemaguard implements a filter which validates that the payloads
+// are compatible with the schemas registered in a schema registry.
+package schemaguard
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/xeipuuv/gojsonschema"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+const (
+	// Kind is the kind of SchemaGuard.
+	Kind = "SchemaGuard"
+
+	// CompatibilityNone only validates the payload against its own schema.
+	CompatibilityNone = "NONE"
+	// CompatibilityBackward also validates the payload against the latest
+	// schema of the subject, so that it is readable by upgraded consumers.
+	CompatibilityBackward = "BACKWARD"
+	// CompatibilityForward also validates the payload against the schema
+	// before the latest one, so that it is readable by consumers which
+	// are not upgraded yet.
+	CompatibilityForward = "FORWARD"
+	// CompatibilityFull is both CompatibilityBackward and
+	// CompatibilityForward.
+	CompatibilityFull = "FULL"
+
+	resultInvalid       = "invalid"
+	resultRegistryError = "registryError"
+
+	// wireFormatMagicByte is the first byte of the payloads in the wire
+	// format, which is followed by the 4 bytes schema ID.
+	wireFormatMagicByte = 0
+
+	defaultRegistryTimeout = 5 * time.Second
+	defaultCacheTTL        = time.Minute
+	maxCheckedVersions     = 2
+	// failures to get schemas by ID are cached for a short time, so that
+	// payloads of a bad schema ID don't flood the registry.
+	negativeCacheTTL  = 5 * time.Second
+	negativeCacheSize = 1000
+)
+
+var kind = &filters.Kind{
+	Name:              Kind,
+	Description:       "SchemaGuard validates payloads are compatible with the schemas in a schema registry.",
+	Results:           []string{resultInvalid, resultRegistryError},
+	RequestOnly:       true,
+	RequiresBuffering: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{Compatibility: CompatibilityBackward}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &SchemaGuard{spec: spec.(*Spec)}
+	},
+}
+
+var errInvalidSchema = errors.New("invalid schema")
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// SchemaGuard is the filter SchemaGuard.
+	SchemaGuard struct {
+		spec     *Spec
+		registry *registryClient
+
+		valid          uint64
+		invalid        uint64
+		registryErrors uint64
+	}
+
+	// Spec is the spec of SchemaGuard.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		Registry *RegistrySpec `json:"registry" jsonschema:"required"`
+		Subject  string        `json:"subject" jsonschema:"required"`
+		// Compatibility is the compatibility mode, default is BACKWARD.
+		Compatibility string `json:"compatibility,omitempty" jsonschema:"enum=NONE,enum=BACKWARD,enum=FORWARD,enum=FULL"`
+		// SchemaIDHeader is the request header carrying the schema ID of
+		// the payload, the payload is in the wire format of the registry,
+		// i.e. prefixed by a magic byte and the schema ID, if it is empty.
+		SchemaIDHeader string `json:"schemaIDHeader,omitempty"`
+	}
+
+	// RegistrySpec describes the schema registry.
+	RegistrySpec struct {
+		URL      string `json:"url" jsonschema:"required,format=uri"`
+		Username string `json:"username,omitempty"`
+		Password string `json:"password,omitempty"`
+		Timeout  string `json:"timeout,omitempty" jsonschema:"format=duration"`
+		// CacheTTL is the time to cache the versions of the subject, the
+		// schemas are cached by ID forever.
+		CacheTTL string `json:"cacheTTL,omitempty" jsonschema:"format=duration"`
+	}
+
+	// Status is the status of SchemaGuard.
+	Status struct {
+		Valid          uint64 `json:"valid"`
+		Invalid        uint64 `json:"invalid"`
+		RegistryErrors uint64 `json:"registryErrors"`
+	}
+
+	// Err is the error of SchemaGuard.
+	Err struct {
+		Err     string   `json:"err"`
+		Details []string `json:"details,omitempty"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	for _, d := range []string{spec.Registry.Timeout, spec.Registry.CacheTTL} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return fmt.Errorf("invalid duration %s", d)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the SchemaGuard filter instance.
+func (sg *SchemaGuard) Name() string {
+	return sg.spec.Name()
+}
+
+// Kind returns the kind of SchemaGuard.
+func (sg *SchemaGuard) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the SchemaGuard
+func (sg *SchemaGuard) Spec() filters.Spec {
+	return sg.spec
+}
+
+// Init initializes SchemaGuard.
+func (sg *SchemaGuard) Init() {
+	sg.registry = newRegistryClient(sg.spec.Registry)
+}
+
+// Inherit inherits previous generation of SchemaGuard.
+func (sg *SchemaGuard) Inherit(previousGeneration filters.Filter) {
+	sg.Init()
+
+	// the schemas never change, so the cache could be reused if the
+	// registry is not changed.
+	prev := previousGeneration.(*SchemaGuard)
+	if prev.spec.Registry.URL == sg.spec.Registry.URL {
+		prev.registry.mu.Lock()
+		for id, s := range prev.registry.schemas {
+			sg.registry.schemas[id] = s
+		}
+		prev.registry.mu.Unlock()
+	}
+}
+
+func (sg *SchemaGuard) reject(ctx *context.Context, result string, statusCode int, e *Err) string {
+	ctx.AddTag("schemaGuard: " + e.Err)
+
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(statusCode)
+	resp.HTTPHeader().Set("Content-Type", "application/json")
+	body, _ := codectool.MarshalJSON(e)
+	resp.SetPayload(body)
+	ctx.SetOutputResponse(resp)
+	return result
+}
+
+func (sg *SchemaGuard) invalidPayload(ctx *context.Context, msg string, details ...string) string {
+	atomic.AddUint64(&sg.invalid, 1)
+	return sg.reject(ctx, resultInvalid, http.StatusBadRequest, &Err{Err: msg, Details: details})
+}
+
+// schemaID returns the schema ID of the payload, and the payload without
+// the wire format prefix.
+func (sg *SchemaGuard) schemaID(req *httpprot.Request, body []byte) (int, []byte, error) {
+	if sg.spec.SchemaIDHeader != "" {
+		value := req.HTTPHeader().Get(sg.spec.SchemaIDHeader)
+		id, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid schema ID %q", value)
+		}
+		return id, body, nil
+	}
+
+	if len(body) < 5 || body[0] != wireFormatMagicByte {
+		return 0, nil, fmt.Errorf("payload is not in the wire format")
+	}
+	return int(binary.BigEndian.Uint32(body[1:5])), body[5:], nil
+}
+
+// schemasToCheck returns the schemas of the subject to check according to
+// the compatibility mode.
+func (sg *SchemaGuard) schemasToCheck() ([]int, error) {
+	switch sg.spec.Compatibility {
+	case CompatibilityNone:
+		return nil, nil
+	case CompatibilityForward, CompatibilityFull:
+		ids, err := sg.registry.subjectSchemas(sg.spec.Subject, maxCheckedVersions)
+		if err != nil {
+			return nil, err
+		}
+		if sg.spec.Compatibility == CompatibilityForward && len(ids) > 1 {
+			ids = ids[1:]
+		}
+		return ids, nil
+	default:
+		return sg.registry.subjectSchemas(sg.spec.Subject, 1)
+	}
+}
+
+func validate(s *schema, data []byte) []string {
+	res, err := s.schema.Validate(gojsonschema.NewBytesLoader(data))
+	if err != nil {
+		return []string{fmt.Sprintf("schema %d: %v", s.id, err)}
+	}
+
+	var details []string
+	for _, e := range res.Errors() {
+		details = append(details, fmt.Sprintf("schema %d: %s", s.id, e.String()))
+	}
+	return details
+}
+
+// Handle validates the payload of the request.
+func (sg *SchemaGuard) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	if req.IsStream() {
+		return sg.invalidPayload(ctx, "payload too large to validate")
+	}
+
+	id, data, err := sg.schemaID(req, req.RawPayload())
+	if err != nil {
+		return sg.invalidPayload(ctx, err.Error())
+	}
+
+	ids, err := sg.schemasToCheck()
+	if err == nil {
+		ids = append([]int{id}, ids...)
+	}
+
+	var details []string
+	checked := map[int]bool{}
+	for _, id := range ids {
+		if checked[id] {
+			continue
+		}
+		checked[id] = true
+
+		var s *schema
+		s, err = sg.registry.schemaByID(id)
+		if err != nil {
+			break
+		}
+		details = append(details, validate(s, data)...)
+	}
+
+	if errors.Is(err, errInvalidSchema) {
+		return sg.invalidPayload(ctx, err.Error())
+	}
+	if err != nil {
+		logger.Errorf("%s: failed to get schemas from the registry: %v", sg.Name(), err)
+		atomic.AddUint64(&sg.registryErrors, 1)
+		return sg.reject(ctx, resultRegistryError, http.StatusServiceUnavailable, &Err{Err: "schema registry unavailable"})
+	}
+
+	if len(details) > 0 {
+		return sg.invalidPayload(ctx, "payload is incompatible with the schemas", details...)
+	}
+
+	atomic.AddUint64(&sg.valid, 1)
+	return ""
+}
+
+// Status returns status.
+func (sg *SchemaGuard) Status() interface{} {
+	return &Status{
+		Valid:          atomic.LoadUint64(&sg.valid),
+		Invalid:        atomic.LoadUint64(&sg.invalid),
+		RegistryErrors: atomic.LoadUint64(&sg.registryErrors),
+	}
+}
+
+// Close closes SchemaGuard.
+func (sg *SchemaGuard) Close() {
+}
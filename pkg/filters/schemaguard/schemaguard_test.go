@@ -0,0 +1,253 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schemaguard
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+// the schemas of the subject orders-value, version 1 requires id, version
+// 2 adds the optional field amount, and version 3 requires amount.
+var schemas = map[int]string{
+	1: `{"type": "object", "required": ["id"], "properties": {"id": {"type": "string"}}}`,
+	2: `{"type": "object", "required": ["id"], "properties": {"id": {"type": "string"}, "amount": {"type": "number"}}}`,
+	3: `{"type": "object", "required": ["id", "amount"], "properties": {"id": {"type": "string"}, "amount": {"type": "number"}}}`,
+	// an Avro schema, which is not supported.
+	4: `{"type": "record", "name": "order", "fields": []}`,
+}
+
+func newRegistry(versions []int, requests *int32) *httptest.Server {
+	schemaResponse := func(id int) map[string]interface{} {
+		r := map[string]interface{}{"id": id, "schema": schemas[id]}
+		if id != 4 {
+			r["schemaType"] = "JSON"
+		}
+		return r
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+
+		var v interface{}
+		switch {
+		case r.URL.Path == "/subjects/orders-value/versions":
+			v = versions
+		case strings.HasPrefix(r.URL.Path, "/subjects/orders-value/versions/"):
+			var version int
+			fmt.Sscanf(r.URL.Path, "/subjects/orders-value/versions/%d", &version)
+			v = schemaResponse(version)
+		case strings.HasPrefix(r.URL.Path, "/schemas/ids/"):
+			var id int
+			fmt.Sscanf(r.URL.Path, "/schemas/ids/%d", &id)
+			if schemas[id] == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			v = schemaResponse(id)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(codectool.MustMarshalJSON(v))
+	}))
+}
+
+func createSchemaGuard(yamlConfig string, prev *SchemaGuard) (*SchemaGuard, error) {
+	rawSpec := make(map[string]interface{})
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	sg := kind.CreateInstance(spec).(*SchemaGuard)
+	if prev == nil {
+		sg.Init()
+	} else {
+		sg.Inherit(prev)
+	}
+	return sg, nil
+}
+
+func handle(sg *SchemaGuard, header string, body []byte) (string, *httpprot.Response) {
+	stdReq, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/orders", nil)
+	if header != "" {
+		stdReq.Header.Set("X-Schema-Id", header)
+	}
+	req, _ := httpprot.NewRequest(stdReq)
+	req.SetPayload(body)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	result := sg.Handle(ctx)
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	return result, resp
+}
+
+func wireFormat(id int, payload string) []byte {
+	return append([]byte{0, 0, 0, 0, byte(id)}, payload...)
+}
+
+func TestCompatibility(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests int32
+	registry := newRegistry([]int{1, 2, 3}, &requests)
+	defer registry.Close()
+
+	cases := []struct {
+		compatibility string
+		id            int
+		payload       string
+		result        string
+	}{
+		{"NONE", 2, `{"id": "1"}`, ""},
+		{"NONE", 2, `{"id": 1}`, resultInvalid},
+		// readable by consumers of version 3, which requires amount.
+		{"BACKWARD", 2, `{"id": "1"}`, resultInvalid},
+		{"BACKWARD", 2, `{"id": "1", "amount": 1}`, ""},
+		// readable by consumers of version 2, which don't know amount.
+		{"FORWARD", 3, `{"id": "1", "amount": 1}`, ""},
+		{"FORWARD", 3, `{"id": "1", "amount": "1"}`, resultInvalid},
+		{"FULL", 1, `{"id": "1"}`, resultInvalid},
+		{"FULL", 3, `{"id": "1", "amount": 1}`, ""},
+		{"NONE", 4, `{}`, resultInvalid},
+	}
+
+	for _, c := range cases {
+		sg, err := createSchemaGuard(fmt.Sprintf(`
+kind: SchemaGuard
+name: sg
+subject: orders-value
+compatibility: %s
+registry:
+  url: %s
+`, c.compatibility, registry.URL), nil)
+		assert.Nil(err)
+
+		result, resp := handle(sg, "", wireFormat(c.id, c.payload))
+		assert.Equal(c.result, result, "%s %d %s", c.compatibility, c.id, c.payload)
+		if result != "" {
+			assert.Equal(http.StatusBadRequest, resp.StatusCode())
+			e := &Err{}
+			codectool.MustUnmarshal(resp.RawPayload(), e)
+			assert.NotEmpty(e.Err)
+		}
+	}
+
+	// the schemas are cached.
+	sg, err := createSchemaGuard(fmt.Sprintf(`
+kind: SchemaGuard
+name: sg
+subject: orders-value
+schemaIDHeader: X-Schema-Id
+registry:
+  url: %s
+`, registry.URL), nil)
+	assert.Nil(err)
+	result, _ := handle(sg, "3", []byte(`{"id": "1", "amount": 1}`))
+	assert.Equal("", result)
+	n := atomic.LoadInt32(&requests)
+	// schema 1 is not one of the latest versions which are fetched with
+	// the versions.
+	result, _ = handle(sg, "1", []byte(`{"id": "1", "amount": 1}`))
+	assert.Equal("", result)
+	assert.Equal(n+1, atomic.LoadInt32(&requests))
+
+	sg2, _ := createSchemaGuard(fmt.Sprintf(`
+kind: SchemaGuard
+name: sg
+subject: orders-value
+compatibility: NONE
+schemaIDHeader: X-Schema-Id
+registry:
+  url: %s
+`, registry.URL), sg)
+	result, _ = handle(sg2, "1", []byte(`{"id": "1"}`))
+	assert.Equal("", result)
+	assert.Equal(n+1, atomic.LoadInt32(&requests))
+
+	// invalid schema IDs and payloads.
+	result, _ = handle(sg2, "abc", []byte(`{"id": "1"}`))
+	assert.Equal(resultInvalid, result)
+	result, _ = handle(sg, "", []byte(`{"id": "1"}`))
+	assert.Equal(resultInvalid, result)
+
+	status := sg2.Status().(*Status)
+	assert.Equal(uint64(1), status.Valid)
+	assert.Equal(uint64(1), status.Invalid)
+}
+
+func TestRegistryError(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests int32
+	registry := newRegistry([]int{1}, &requests)
+	defer registry.Close()
+
+	sg, err := createSchemaGuard(fmt.Sprintf(`
+kind: SchemaGuard
+name: sg
+subject: orders-value
+registry:
+  url: %s
+  timeout: 1s
+`, registry.URL), nil)
+	assert.Nil(err)
+
+	// schema 5 is not found, and the failure is cached.
+	result, resp := handle(sg, "", wireFormat(5, `{"id": "1"}`))
+	assert.Equal(resultRegistryError, result)
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode())
+	assert.Equal(uint64(1), sg.Status().(*Status).RegistryErrors)
+	n := atomic.LoadInt32(&requests)
+	result, _ = handle(sg, "", wireFormat(5, `{"id": "1"}`))
+	assert.Equal(resultRegistryError, result)
+	assert.Equal(n, atomic.LoadInt32(&requests))
+
+	// the failure expires.
+	sg.registry.failures.Add(5, &failure{err: errInvalidSchema, expires: time.Now()})
+	handle(sg, "", wireFormat(5, `{"id": "1"}`))
+	assert.Equal(n+1, atomic.LoadInt32(&requests))
+
+	_, err = createSchemaGuard(`
+kind: SchemaGuard
+name: sg
+subject: orders-value
+registry:
+  url: http://127.0.0.1:8081
+  cacheTTL: -1s
+`, nil)
+	assert.Error(err)
+}
@@ -0,0 +1,202 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sequencer
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// queues are the queues of all clients.
+	queues struct {
+		mutex    sync.Mutex
+		maxQueue int
+		queues   map[string]*queue
+
+		outOfOrder uint64
+		overflow   uint64
+		timeout    uint64
+	}
+
+	// queue is the queue of a client, only one request of it is processed
+	// at a time.
+	queue struct {
+		key     string
+		busy    bool
+		waiters *list.List
+
+		// started is whether a request with sequence number has been
+		// processed, and next is the expected sequence number if so.
+		started bool
+		next    int64
+
+		lastActive time.Time
+	}
+
+	// waiter is a request waiting in the queue, seq is -1 if the request
+	// has no sequence number.
+	waiter struct {
+		seq     int64
+		ready   chan struct{}
+		granted bool
+	}
+)
+
+func newQueues(maxQueue int) *queues {
+	return &queues{
+		maxQueue: maxQueue,
+		queues:   map[string]*queue{},
+	}
+}
+
+func (qs *queues) setMaxQueue(maxQueue int) {
+	qs.mutex.Lock()
+	qs.maxQueue = maxQueue
+	qs.mutex.Unlock()
+}
+
+// eligible returns whether the waiter could be processed next.
+func (q *queue) eligible(w *waiter) bool {
+	return w.seq < 0 || !q.started || w.seq == q.next
+}
+
+// grant makes the waiter the one being processed.
+func (q *queue) grant(w *waiter) {
+	q.busy = true
+	if w.seq >= 0 {
+		q.started = true
+		q.next = w.seq + 1
+	}
+	w.granted = true
+}
+
+// acquire waits until the request could be processed, it returns the queue
+// of the client and an empty result if the request is granted.
+func (qs *queues) acquire(key string, w *waiter, timeout time.Duration, done <-chan struct{}) (*queue, string) {
+	qs.mutex.Lock()
+
+	q := qs.queues[key]
+	if q == nil {
+		q = &queue{key: key, waiters: list.New()}
+		qs.queues[key] = q
+	}
+	q.lastActive = time.Now()
+
+	if w.seq >= 0 && q.started && w.seq < q.next {
+		qs.mutex.Unlock()
+		atomic.AddUint64(&qs.outOfOrder, 1)
+		return nil, resultOutOfOrder
+	}
+
+	// eligible waiters are always granted on release, so there's no one
+	// to wait for if the queue is not busy.
+	if !q.busy && q.eligible(w) {
+		q.grant(w)
+		qs.mutex.Unlock()
+		return q, ""
+	}
+
+	if w.seq >= 0 {
+		for e := q.waiters.Front(); e != nil; e = e.Next() {
+			if e.Value.(*waiter).seq == w.seq {
+				qs.mutex.Unlock()
+				atomic.AddUint64(&qs.outOfOrder, 1)
+				return nil, resultOutOfOrder
+			}
+		}
+	}
+
+	if q.waiters.Len() >= qs.maxQueue {
+		qs.mutex.Unlock()
+		atomic.AddUint64(&qs.overflow, 1)
+		return nil, resultOverflow
+	}
+
+	w.ready = make(chan struct{})
+	e := q.waiters.PushBack(w)
+	qs.mutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-w.ready:
+		return q, ""
+	case <-timer.C:
+	case <-done:
+	}
+
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+
+	// the waiter may be granted just after the timeout.
+	if w.granted {
+		return q, ""
+	}
+	q.waiters.Remove(e)
+	atomic.AddUint64(&qs.timeout, 1)
+	return nil, resultTimeout
+}
+
+// release releases the queue, and grants the first eligible waiter.
+func (qs *queues) release(q *queue) {
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+
+	q.busy = false
+	q.lastActive = time.Now()
+
+	for e := q.waiters.Front(); e != nil; e = e.Next() {
+		w := e.Value.(*waiter)
+		if q.eligible(w) {
+			q.waiters.Remove(e)
+			q.grant(w)
+			close(w.ready)
+			return
+		}
+	}
+}
+
+// removeIdle removes the queues which have been idle since before.
+func (qs *queues) removeIdle(before time.Time) {
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+
+	for key, q := range qs.queues {
+		if !q.busy && q.waiters.Len() == 0 && q.lastActive.Before(before) {
+			delete(qs.queues, key)
+		}
+	}
+}
+
+func (qs *queues) status() *Status {
+	qs.mutex.Lock()
+	s := &Status{Clients: len(qs.queues)}
+	for _, q := range qs.queues {
+		s.Queued += q.waiters.Len()
+	}
+	qs.mutex.Unlock()
+
+	s.OutOfOrder = atomic.LoadUint64(&qs.outOfOrder)
+	s.Overflow = atomic.LoadUint64(&qs.overflow)
+	s.Timeout = atomic.LoadUint64(&qs.timeout)
+	return s
+}
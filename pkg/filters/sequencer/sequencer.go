@@ -0,0 +1,249 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package sequencer implements a filter which serializes the requests of
+// every client.
+package sequencer
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/builder"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of Sequencer.
+	Kind = "Sequencer"
+
+	resultOutOfOrder = "outOfOrder"
+	resultOverflow   = "overflow"
+	resultTimeout    = "timeout"
+
+	defaultMaxQueue    = 10
+	defaultTimeout     = 10 * time.Second
+	defaultIdleTimeout = time.Minute
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "Sequencer serializes the requests of every client, a request waits until the previous one completes.",
+	Results:     []string{resultOutOfOrder, resultOverflow, resultTimeout},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{MaxQueue: defaultMaxQueue}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &Sequencer{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// Sequencer is the filter Sequencer.
+	//
+	// It keeps a queue for every client key, and only one request of a
+	// client is processed at a time, the others wait in the queue in the
+	// order of arrival, or in the order of the sequence numbers if
+	// SequenceHeader is set.
+	Sequencer struct {
+		spec *Spec
+
+		keyTemplate *builder.Template
+		timeout     time.Duration
+		idleTimeout time.Duration
+		queues      *queues
+		done        chan struct{}
+	}
+
+	// Spec is the spec of Sequencer.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Key is a template to extract the client key.
+		Key string `json:"key" jsonschema:"required"`
+		// SequenceHeader is the header carrying the sequence number of
+		// requests, requests are processed in the order of arrival if it
+		// is empty or the header is absent.
+		SequenceHeader string `json:"sequenceHeader,omitempty"`
+		// MaxQueue is the max number of requests waiting for a client.
+		MaxQueue int `json:"maxQueue,omitempty" jsonschema:"minimum=0"`
+		// Timeout is the max time a request waits in the queue.
+		Timeout string `json:"timeout,omitempty" jsonschema:"format=duration"`
+		// IdleTimeout is the time to keep the queue of an idle client,
+		// the sequence number restarts after the queue is removed.
+		IdleTimeout string `json:"idleTimeout,omitempty" jsonschema:"format=duration"`
+	}
+
+	// Status is the status of Sequencer.
+	Status struct {
+		Clients    int    `json:"clients"`
+		Queued     int    `json:"queued"`
+		OutOfOrder uint64 `json:"outOfOrder"`
+		Overflow   uint64 `json:"overflow"`
+		Timeout    uint64 `json:"timeout"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if _, err := builder.NewTemplate(spec.Key); err != nil {
+		return fmt.Errorf("invalid key: %v", err)
+	}
+	for _, d := range []string{spec.Timeout, spec.IdleTimeout} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return fmt.Errorf("invalid duration %s", d)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the Sequencer filter instance.
+func (s *Sequencer) Name() string {
+	return s.spec.Name()
+}
+
+// Kind returns the kind of Sequencer.
+func (s *Sequencer) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the Sequencer
+func (s *Sequencer) Spec() filters.Spec {
+	return s.spec
+}
+
+// Init initializes Sequencer.
+func (s *Sequencer) Init() {
+	s.reload(nil)
+}
+
+// Inherit inherits previous generation of Sequencer.
+func (s *Sequencer) Inherit(previousGeneration filters.Filter) {
+	s.reload(previousGeneration.(*Sequencer))
+}
+
+func (s *Sequencer) reload(previous *Sequencer) {
+	s.keyTemplate = builder.MustNewTemplate(s.spec.Key)
+
+	s.timeout, _ = time.ParseDuration(s.spec.Timeout)
+	if s.timeout <= 0 {
+		s.timeout = defaultTimeout
+	}
+	s.idleTimeout, _ = time.ParseDuration(s.spec.IdleTimeout)
+	if s.idleTimeout <= 0 {
+		s.idleTimeout = defaultIdleTimeout
+	}
+
+	// keep the queues, so that the order of requests holds on reloading.
+	if previous != nil {
+		s.queues = previous.queues
+		s.queues.setMaxQueue(s.spec.MaxQueue)
+	} else {
+		s.queues = newQueues(s.spec.MaxQueue)
+	}
+
+	s.done = make(chan struct{})
+	go s.cleanup()
+}
+
+// cleanup removes the queues of idle clients periodically.
+func (s *Sequencer) cleanup() {
+	ticker := time.NewTicker(s.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.queues.removeIdle(now.Add(-s.idleTimeout))
+		}
+	}
+}
+
+func (s *Sequencer) reject(ctx *context.Context, key, result string, statusCode int) string {
+	ctx.AddTag(fmt.Sprintf("sequencer: %s %s", key, result))
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(statusCode)
+	ctx.SetOutputResponse(resp)
+	return result
+}
+
+// Handle waits until the previous requests of the client complete, the
+// next request is allowed when the request finishes.
+func (s *Sequencer) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	key, err := s.keyTemplate.Render(ctx)
+	if err != nil {
+		logger.Warnf("%s: failed to render key: %v", s.Name(), err)
+	}
+
+	w := &waiter{seq: -1}
+	if s.spec.SequenceHeader != "" {
+		if v := req.HTTPHeader().Get(s.spec.SequenceHeader); v != "" {
+			seq, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || seq < 0 {
+				atomic.AddUint64(&s.queues.outOfOrder, 1)
+				return s.reject(ctx, key, resultOutOfOrder, http.StatusBadRequest)
+			}
+			w.seq = seq
+		}
+	}
+
+	q, result := s.queues.acquire(key, w, s.timeout, req.Context().Done())
+	switch result {
+	case resultOutOfOrder:
+		return s.reject(ctx, key, result, http.StatusConflict)
+	case resultOverflow:
+		return s.reject(ctx, key, result, http.StatusTooManyRequests)
+	case resultTimeout:
+		return s.reject(ctx, key, result, http.StatusServiceUnavailable)
+	}
+
+	ctx.OnFinish(func() {
+		s.queues.release(q)
+	})
+	return ""
+}
+
+// Status returns status.
+func (s *Sequencer) Status() interface{} {
+	return s.queues.status()
+}
+
+// Close closes Sequencer.
+func (s *Sequencer) Close() {
+	close(s.done)
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sequencer
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestSequencer(yamlConfig string) (*Sequencer, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	s := kind.CreateInstance(spec).(*Sequencer)
+	s.Init()
+	return s, nil
+}
+
+func newContext(client, seq string) *context.Context {
+	stdReq, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	stdReq.Header.Set("X-Client", client)
+	if seq != "" {
+		stdReq.Header.Set("X-Sequence", seq)
+	}
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+// handle handles the request in a goroutine, and returns a channel to
+// receive the result.
+func handle(s *Sequencer, ctx *context.Context) chan string {
+	ch := make(chan string, 1)
+	go func() {
+		ch <- s.Handle(ctx)
+	}()
+	return ch
+}
+
+func assertPending(t *testing.T, ch chan string) {
+	select {
+	case r := <-ch:
+		t.Fatalf("unexpected result %q", r)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func statusCode(ctx *context.Context) int {
+	return ctx.GetOutputResponse().(*httpprot.Response).StatusCode()
+}
+
+const testConfig = `
+kind: Sequencer
+name: sequencer
+key: '{{.req.Header.Get "X-Client"}}'
+sequenceHeader: X-Sequence
+maxQueue: 2
+timeout: 100ms
+`
+
+func TestSequencer(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := newTestSequencer(testConfig)
+	assert.Nil(err)
+	defer s.Close()
+	assert.Equal(kind, s.Kind())
+
+	// requests of a client are processed one by one.
+	ctx1 := newContext("alice", "")
+	assert.Equal("", s.Handle(ctx1))
+	assert.Equal("", s.Handle(newContext("bob", "")))
+
+	ctx2 := newContext("alice", "")
+	ch2 := handle(s, ctx2)
+	assertPending(t, ch2)
+
+	// overflow.
+	ch3 := handle(s, newContext("alice", ""))
+	assertPending(t, ch3)
+	ctx := newContext("alice", "")
+	assert.Equal(resultOverflow, s.Handle(ctx))
+	assert.Equal(http.StatusTooManyRequests, statusCode(ctx))
+
+	// in the order of arrival.
+	ctx1.Finish()
+	assert.Equal("", <-ch2)
+	assertPending(t, ch3)
+	ctx2.Finish()
+	assert.Equal("", <-ch3)
+
+	// timeout.
+	ctx = newContext("alice", "")
+	assert.Equal(resultTimeout, s.Handle(ctx))
+	assert.Equal(http.StatusServiceUnavailable, statusCode(ctx))
+
+	status := s.Status().(*Status)
+	assert.Equal(2, status.Clients)
+	assert.Equal(0, status.Queued)
+	assert.Equal(uint64(1), status.Overflow)
+	assert.Equal(uint64(1), status.Timeout)
+}
+
+func TestSequenceNumber(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := newTestSequencer(testConfig)
+	assert.Nil(err)
+	defer s.Close()
+
+	ctx1 := newContext("alice", "1")
+	assert.Equal("", s.Handle(ctx1))
+
+	// request 3 waits for request 2 even after request 1 completes.
+	ctx3 := newContext("alice", "3")
+	ch3 := handle(s, ctx3)
+	assertPending(t, ch3)
+	ctx2 := newContext("alice", "2")
+	ch2 := handle(s, ctx2)
+	assertPending(t, ch2)
+
+	// duplicated request.
+	ctx := newContext("alice", "3")
+	assert.Equal(resultOutOfOrder, s.Handle(ctx))
+	assert.Equal(http.StatusConflict, statusCode(ctx))
+
+	ctx1.Finish()
+	assert.Equal("", <-ch2)
+	assertPending(t, ch3)
+	ctx2.Finish()
+	assert.Equal("", <-ch3)
+	ctx3.Finish()
+
+	// stale and invalid sequence numbers.
+	ctx = newContext("alice", "2")
+	assert.Equal(resultOutOfOrder, s.Handle(ctx))
+	assert.Equal(http.StatusConflict, statusCode(ctx))
+	ctx = newContext("alice", "abc")
+	assert.Equal(resultOutOfOrder, s.Handle(ctx))
+	assert.Equal(http.StatusBadRequest, statusCode(ctx))
+
+	// a missing request blocks the following ones until timeout.
+	assert.Equal(resultTimeout, s.Handle(newContext("alice", "5")))
+	assert.Equal(uint64(3), s.Status().(*Status).OutOfOrder)
+
+	// the queues are kept on reloading, and removed when idle.
+	newS := kind.CreateInstance(s.spec).(*Sequencer)
+	newS.Inherit(s)
+	defer newS.Close()
+	assert.Equal(resultOutOfOrder, newS.Handle(newContext("alice", "3")))
+	newS.queues.removeIdle(time.Now().Add(time.Second))
+	assert.Equal(0, newS.Status().(*Status).Clients)
+	assert.Equal("", newS.Handle(newContext("alice", "3")))
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []string{
+		"key: '{{.req'",
+		"key: k\ntimeout: abc",
+		"key: k\nidleTimeout: -1s",
+	} {
+		_, err := newTestSequencer("kind: Sequencer\nname: sequencer\n" + c)
+		assert.NotNil(err, c)
+	}
+}
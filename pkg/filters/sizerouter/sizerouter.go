@@ -0,0 +1,223 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package sizerouter implements a filter which makes routing decisions
+// by the payload size of requests.
+package sizerouter
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/routeheader"
+)
+
+const (
+	// Kind is the kind of SizeRouter.
+	Kind = "SizeRouter"
+
+	defaultMaxProbeSize = 1024 * 1024
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "SizeRouter sets the routing decision by the payload size of the request.",
+	Results:     []string{},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &SizeRouter{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// SizeRouter is the filter SizeRouter.
+	SizeRouter struct {
+		spec   *Spec
+		routes []*Route
+		// probeSize is the number of bytes to read to decide the route
+		// of a request without Content-Length, 0 means no probing.
+		probeSize int64
+	}
+
+	// Spec is the spec of SizeRouter.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Header is the request header to carry the routing decision.
+		Header routeheader.Header `json:"header" jsonschema:"required,minLength=1"`
+		Routes []*Route           `json:"routes" jsonschema:"required,minItems=1"`
+		// DefaultRoute is the route of requests smaller than the MinSize
+		// of all routes.
+		DefaultRoute string `json:"defaultRoute,omitempty"`
+		// UnknownSizeRoute is the route of requests whose size is unknown,
+		// that's the streamed requests without Content-Length, it is
+		// DefaultRoute if empty. It is not used when Probe is true.
+		UnknownSizeRoute string `json:"unknownSizeRoute,omitempty"`
+		// Probe reads the head of requests whose size is unknown, until
+		// the largest MinSize or the end of the payload, to decide their
+		// routes. The bytes read are kept and sent to the backend.
+		Probe bool `json:"probe,omitempty"`
+		// MaxProbeSize is the limit of the largest MinSize when Probe is
+		// true, default is 1MiB.
+		MaxProbeSize int64 `json:"maxProbeSize,omitempty" jsonschema:"minimum=1"`
+	}
+
+	// Route is selected by requests not smaller than MinSize in bytes, if
+	// multiple routes are matched, the one with the largest MinSize wins.
+	Route struct {
+		Name    string `json:"name" jsonschema:"required"`
+		MinSize int64  `json:"minSize" jsonschema:"required,minimum=1"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if spec.Probe {
+		maxProbeSize := spec.MaxProbeSize
+		if maxProbeSize <= 0 {
+			maxProbeSize = defaultMaxProbeSize
+		}
+		for _, r := range spec.Routes {
+			if r.MinSize > maxProbeSize {
+				return fmt.Errorf("route %s: minSize %d exceeds maxProbeSize %d", r.Name, r.MinSize, maxProbeSize)
+			}
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the SizeRouter filter instance.
+func (sr *SizeRouter) Name() string {
+	return sr.spec.Name()
+}
+
+// Kind returns the kind of SizeRouter.
+func (sr *SizeRouter) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the SizeRouter
+func (sr *SizeRouter) Spec() filters.Spec {
+	return sr.spec
+}
+
+// Init initializes SizeRouter.
+func (sr *SizeRouter) Init() {
+	sr.reload()
+}
+
+// Inherit inherits previous generation of SizeRouter.
+func (sr *SizeRouter) Inherit(previousGeneration filters.Filter) {
+	sr.Init()
+}
+
+func (sr *SizeRouter) reload() {
+	// sort the routes by MinSize in descending order, so the first
+	// matching route is the one with the largest MinSize.
+	sr.routes = append([]*Route(nil), sr.spec.Routes...)
+	sort.SliceStable(sr.routes, func(i, j int) bool {
+		return sr.routes[i].MinSize > sr.routes[j].MinSize
+	})
+
+	sr.probeSize = 0
+	if sr.spec.Probe {
+		sr.probeSize = sr.routes[0].MinSize
+	}
+}
+
+// payloadSize returns the payload size of the request, or -1 if the size
+// is unknown.
+func (sr *SizeRouter) payloadSize(req *httpprot.Request) int64 {
+	// check Content-Length first, which doesn't read the payload.
+	if cl := req.Std().ContentLength; cl >= 0 {
+		return cl
+	}
+
+	// the payload has been read into memory, the size is observed.
+	if !req.IsStream() {
+		return req.PayloadSize()
+	}
+
+	// a stream which has been partially read can't be probed.
+	if sr.probeSize == 0 || req.PayloadSize() > 0 {
+		return -1
+	}
+
+	// the payload is buffered if it is smaller than probeSize, otherwise
+	// it is still a stream with the bytes read kept at its head, and all
+	// we know is that it is not smaller than probeSize, which is enough
+	// to select the route with the largest MinSize.
+	if err := req.BufferPayload(sr.probeSize - 1); err != nil {
+		return -1
+	}
+	if req.IsStream() {
+		return sr.probeSize
+	}
+	return req.PayloadSize()
+}
+
+// route returns the routing decision of the request.
+func (sr *SizeRouter) route(req *httpprot.Request) string {
+	size := sr.payloadSize(req)
+	if size < 0 {
+		if sr.spec.UnknownSizeRoute != "" {
+			return sr.spec.UnknownSizeRoute
+		}
+		return sr.spec.DefaultRoute
+	}
+
+	for _, r := range sr.routes {
+		if size >= r.MinSize {
+			return r.Name
+		}
+	}
+	return sr.spec.DefaultRoute
+}
+
+// Handle sets the routing decision to the request header.
+func (sr *SizeRouter) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	sr.spec.Header.Reset(req.HTTPHeader())
+
+	if route := sr.route(req); route != "" {
+		sr.spec.Header.Set(req.HTTPHeader(), route)
+		ctx.LazyAddTag(func() string {
+			return "sizeRouter: " + route
+		})
+	}
+	return ""
+}
+
+// Status returns status.
+func (sr *SizeRouter) Status() interface{} {
+	return nil
+}
+
+// Close closes SizeRouter.
+func (sr *SizeRouter) Close() {
+}
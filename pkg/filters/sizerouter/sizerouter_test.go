@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sizerouter
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestSizeRouter(yamlConfig string) (*SizeRouter, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	sr := kind.CreateInstance(spec).(*SizeRouter)
+	sr.Init()
+	return sr, nil
+}
+
+// newContext creates a context of a request with a body of size bytes,
+// the Content-Length is unknown if chunked is true, and the payload is
+// a stream if maxPayloadSize is negative.
+func newContext(size int, chunked bool, maxPayloadSize int64) (*context.Context, *httpprot.Request) {
+	var body io.Reader = strings.NewReader(strings.Repeat("a", size))
+	if chunked {
+		// hide the length from httptest.NewRequest.
+		body = io.MultiReader(body)
+	}
+	stdReq := httptest.NewRequest(http.MethodPost, "http://localhost/upload", body)
+	stdReq.Header.Set("X-Route", "forged")
+	req, _ := httpprot.NewRequest(stdReq)
+	req.FetchPayload(maxPayloadSize)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx, req
+}
+
+const testConfig = `
+kind: SizeRouter
+name: router
+header: X-Route
+defaultRoute: small
+unknownSizeRoute: unknown
+routes:
+- name: large
+  minSize: 100
+- name: medium
+  minSize: 10
+`
+
+func TestSizeRouter(t *testing.T) {
+	assert := assert.New(t)
+
+	sr, err := newTestSizeRouter(testConfig)
+	assert.Nil(err)
+	assert.Equal(kind, sr.Kind())
+	assert.Equal("router", sr.Name())
+	assert.NotNil(sr.Spec())
+
+	cases := []struct {
+		size           int
+		chunked        bool
+		maxPayloadSize int64
+		route          string
+	}{
+		{0, false, -1, "small"},
+		{9, false, -1, "small"},
+		{10, false, -1, "medium"},
+		{99, false, 1024, "medium"},
+		{100, false, -1, "large"},
+		{1000, false, -1, "large"},
+		// the observed size of buffered payloads.
+		{5, true, 1024, "small"},
+		{500, true, 1024, "large"},
+		// streams without Content-Length.
+		{5, true, -1, "unknown"},
+		{500, true, -1, "unknown"},
+	}
+	for _, c := range cases {
+		ctx, req := newContext(c.size, c.chunked, c.maxPayloadSize)
+		assert.Equal("", sr.Handle(ctx))
+		assert.Equal(c.route, req.HTTPHeader().Get("X-Route"), c)
+	}
+}
+
+func TestSizeRouterProbe(t *testing.T) {
+	assert := assert.New(t)
+
+	sr, err := newTestSizeRouter(testConfig + "probe: true\n")
+	assert.Nil(err)
+
+	cases := []struct {
+		size   int
+		route  string
+		stream bool
+	}{
+		{0, "small", false},
+		{9, "small", false},
+		{10, "medium", false},
+		{99, "medium", false},
+		{100, "large", true},
+		{1000, "large", true},
+	}
+	for _, c := range cases {
+		ctx, req := newContext(c.size, true, -1)
+		assert.Equal("", sr.Handle(ctx))
+		assert.Equal(c.route, req.HTTPHeader().Get("X-Route"), c)
+		assert.Equal(c.stream, req.IsStream())
+
+		// the bytes read by the probe are kept.
+		data, err := io.ReadAll(req.GetPayload())
+		assert.Nil(err)
+		assert.Equal(c.size, len(data))
+	}
+
+	// a partially read stream is not probed.
+	ctx, req := newContext(500, true, -1)
+	req.GetPayload().Read(make([]byte, 1))
+	sr.Handle(ctx)
+	assert.Equal("unknown", req.HTTPHeader().Get("X-Route"))
+}
+
+func TestSizeRouterNoDefault(t *testing.T) {
+	assert := assert.New(t)
+
+	sr, err := newTestSizeRouter(`
+kind: SizeRouter
+name: router
+header: X-Route
+routes:
+- name: large
+  minSize: 100
+`)
+	assert.Nil(err)
+
+	// the header is removed.
+	ctx, req := newContext(10, false, -1)
+	sr.Handle(ctx)
+	assert.Equal("", req.HTTPHeader().Get("X-Route"))
+
+	ctx, req = newContext(10, true, -1)
+	sr.Handle(ctx)
+	assert.Equal("", req.HTTPHeader().Get("X-Route"))
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, yamlConfig := range []string{`
+kind: SizeRouter
+name: router
+header: "X Route"
+routes:
+- name: large
+  minSize: 100
+`, `
+kind: SizeRouter
+name: router
+header: ""
+routes:
+- name: large
+  minSize: 100
+`, `
+kind: SizeRouter
+name: router
+header: X-Route
+routes:
+- name: large
+  minSize: 0
+`, `
+kind: SizeRouter
+name: router
+header: X-Route
+probe: true
+maxProbeSize: 50
+routes:
+- name: large
+  minSize: 100
+`, `
+kind: SizeRouter
+name: router
+header: X-Route
+routes: []
+`} {
+		_, err := newTestSizeRouter(yamlConfig)
+		assert.Error(err)
+	}
+}
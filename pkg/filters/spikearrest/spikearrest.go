@@ -0,0 +1,212 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package spikearrest implements a filter which smooths traffic spikes by
+// enforcing a minimum interval between requests.
+package spikearrest
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/builder"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of SpikeArrest.
+	Kind = "SpikeArrest"
+
+	resultSpikeArrested = "spikeArrested"
+
+	defaultMaxKeys = 10000
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "SpikeArrest rejects requests arriving sooner than the minimum interval after the previous one.",
+	Results:     []string{resultSpikeArrested},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{MaxKeys: defaultMaxKeys}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &SpikeArrest{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// SpikeArrest is the filter SpikeArrest.
+	//
+	// Unlike RateLimiter, which allows bursts as long as the average rate
+	// is under the limit, SpikeArrest allows at most one request in every
+	// interval for each key, so the traffic is smoothed.
+	SpikeArrest struct {
+		spec *Spec
+
+		interval    time.Duration
+		keyTemplate *builder.Template
+
+		// mutex protects the check-and-set of the last times, the cache
+		// itself is thread safe.
+		mutex sync.Mutex
+		// lastTimes is the time of the last allowed request of each key,
+		// the least recently used keys are evicted when it's full.
+		lastTimes *lru.Cache
+	}
+
+	// Spec is the spec of SpikeArrest.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Interval is the minimum interval between two requests of a key.
+		Interval string `json:"interval" jsonschema:"required,format=duration"`
+		// Key is a template to extract the key, all requests share the
+		// same key if it's empty.
+		Key string `json:"key,omitempty"`
+		// MaxKeys is the max number of keys to track.
+		MaxKeys int `json:"maxKeys,omitempty" jsonschema:"minimum=1"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	interval, err := time.ParseDuration(spec.Interval)
+	if err != nil {
+		return fmt.Errorf("invalid interval %s: %v", spec.Interval, err)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	if spec.Key != "" {
+		if _, err := builder.NewTemplate(spec.Key); err != nil {
+			return fmt.Errorf("invalid key: %v", err)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the SpikeArrest filter instance.
+func (sa *SpikeArrest) Name() string {
+	return sa.spec.Name()
+}
+
+// Kind returns the kind of SpikeArrest.
+func (sa *SpikeArrest) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the SpikeArrest
+func (sa *SpikeArrest) Spec() filters.Spec {
+	return sa.spec
+}
+
+// Init initializes SpikeArrest.
+func (sa *SpikeArrest) Init() {
+	sa.reload(nil)
+}
+
+// Inherit inherits previous generation of SpikeArrest.
+func (sa *SpikeArrest) Inherit(previousGeneration filters.Filter) {
+	sa.reload(previousGeneration.(*SpikeArrest))
+}
+
+func (sa *SpikeArrest) reload(previous *SpikeArrest) {
+	if sa.spec.MaxKeys <= 0 {
+		sa.spec.MaxKeys = defaultMaxKeys
+	}
+
+	sa.interval, _ = time.ParseDuration(sa.spec.Interval)
+	if sa.spec.Key != "" {
+		sa.keyTemplate = builder.MustNewTemplate(sa.spec.Key)
+	}
+
+	// keep the last times, so that reloading doesn't open a window for
+	// spikes.
+	if previous != nil && previous.lastTimes != nil {
+		sa.lastTimes = previous.lastTimes
+		sa.lastTimes.Resize(sa.spec.MaxKeys)
+	} else {
+		sa.lastTimes, _ = lru.New(sa.spec.MaxKeys)
+	}
+}
+
+// allow returns whether the request of the key is allowed at now, and the
+// time to wait before the next request is allowed if not.
+func (sa *SpikeArrest) allow(key string, now time.Time) (bool, time.Duration) {
+	sa.mutex.Lock()
+	defer sa.mutex.Unlock()
+
+	if v, ok := sa.lastTimes.Get(key); ok {
+		if wait := v.(time.Time).Add(sa.interval).Sub(now); wait > 0 {
+			return false, wait
+		}
+	}
+
+	sa.lastTimes.Add(key, now)
+	return true, 0
+}
+
+// Handle rejects the request if it arrives too soon after the previous
+// request of the same key.
+func (sa *SpikeArrest) Handle(ctx *context.Context) string {
+	key := ""
+	if sa.keyTemplate != nil {
+		var err error
+		if key, err = sa.keyTemplate.Render(ctx); err != nil {
+			logger.Warnf("%s: failed to render key: %v", sa.Name(), err)
+		}
+	}
+
+	ok, wait := sa.allow(key, time.Now())
+	if ok {
+		return ""
+	}
+
+	ctx.AddTag(fmt.Sprintf("spikeArrest: %q arrested", key))
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(http.StatusTooManyRequests)
+	retryAfter := int64(math.Ceil(wait.Seconds()))
+	resp.HTTPHeader().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	ctx.SetOutputResponse(resp)
+	return resultSpikeArrested
+}
+
+// Status returns status.
+func (sa *SpikeArrest) Status() interface{} {
+	return nil
+}
+
+// Close closes SpikeArrest.
+func (sa *SpikeArrest) Close() {
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spikearrest
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestSpikeArrest(yamlConfig string) (*SpikeArrest, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	sa := kind.CreateInstance(spec).(*SpikeArrest)
+	sa.Init()
+	return sa, nil
+}
+
+func newContext(client string) *context.Context {
+	stdReq, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	stdReq.Header.Set("X-Client", client)
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestSpikeArrest(t *testing.T) {
+	assert := assert.New(t)
+
+	sa, err := newTestSpikeArrest(`
+kind: SpikeArrest
+name: spikeArrest
+interval: 100ms
+key: '{{.req.Header.Get "X-Client"}}'
+`)
+	assert.Nil(err)
+	assert.Equal(kind, sa.Kind())
+	assert.Nil(sa.Status())
+
+	assert.Equal("", sa.Handle(newContext("alice")))
+	assert.Equal("", sa.Handle(newContext("bob")))
+
+	ctx := newContext("alice")
+	assert.Equal(resultSpikeArrested, sa.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusTooManyRequests, resp.StatusCode())
+	assert.Equal("1", resp.HTTPHeader().Get("Retry-After"))
+
+	// rejected requests don't delay the next allowed request.
+	time.Sleep(110 * time.Millisecond)
+	assert.Equal("", sa.Handle(newContext("alice")))
+
+	// the last times are kept on reloading.
+	newSa := kind.CreateInstance(sa.spec).(*SpikeArrest)
+	newSa.Inherit(sa)
+	assert.Equal(resultSpikeArrested, newSa.Handle(newContext("alice")))
+	sa.Close()
+}
+
+func TestAllow(t *testing.T) {
+	assert := assert.New(t)
+
+	sa, err := newTestSpikeArrest(`
+kind: SpikeArrest
+name: spikeArrest
+interval: 50ms
+maxKeys: 2
+`)
+	assert.Nil(err)
+
+	now := time.Now()
+	ok, _ := sa.allow("", now)
+	assert.True(ok)
+	ok, wait := sa.allow("", now.Add(20*time.Millisecond))
+	assert.False(ok)
+	assert.Equal(30*time.Millisecond, wait)
+	ok, _ = sa.allow("", now.Add(50*time.Millisecond))
+	assert.True(ok)
+
+	// the least recently used keys are evicted.
+	for i := 0; i < 3; i++ {
+		ok, _ = sa.allow(fmt.Sprint(i), now)
+		assert.True(ok)
+	}
+	assert.Equal(2, sa.lastTimes.Len())
+	ok, _ = sa.allow("0", now)
+	assert.True(ok)
+	ok, _ = sa.allow("2", now)
+	assert.False(ok)
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []string{
+		"interval: abc",
+		"interval: 0s",
+		"interval: 1s\nkey: '{{.req'",
+	} {
+		_, err := newTestSpikeArrest("kind: SpikeArrest\nname: spikeArrest\n" + c)
+		assert.NotNil(err, c)
+	}
+}
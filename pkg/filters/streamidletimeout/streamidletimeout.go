@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package streamidletimeout implements a filter which aborts streaming
+// responses when no bytes flow for a while.
+package streamidletimeout
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/prometheushelper"
+	"github.com/megaease/easegress/v2/pkg/util/readers"
+)
+
+const (
+	// Kind is the kind of StreamIdleTimeout.
+	Kind = "StreamIdleTimeout"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "StreamIdleTimeout aborts streaming responses which are idle for longer than the timeout.",
+	Results:     []string{},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &StreamIdleTimeout{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// StreamIdleTimeout is the filter StreamIdleTimeout.
+	StreamIdleTimeout struct {
+		spec *Spec
+
+		timeout      time.Duration
+		aborted      uint64
+		abortedTotal *prometheus.CounterVec
+	}
+
+	// Spec is the spec of StreamIdleTimeout.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// IdleTimeout is the max time to wait for the next bytes of the
+		// streaming response.
+		IdleTimeout string `json:"idleTimeout" jsonschema:"required,format=duration"`
+	}
+
+	// Status is the status of StreamIdleTimeout.
+	Status struct {
+		Aborted uint64 `json:"aborted"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if d, err := time.ParseDuration(spec.IdleTimeout); err != nil || d <= 0 {
+		return fmt.Errorf("invalid idleTimeout %s", spec.IdleTimeout)
+	}
+	return nil
+}
+
+// Name returns the name of the StreamIdleTimeout filter instance.
+func (s *StreamIdleTimeout) Name() string {
+	return s.spec.Name()
+}
+
+// Kind returns the kind of StreamIdleTimeout.
+func (s *StreamIdleTimeout) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the StreamIdleTimeout
+func (s *StreamIdleTimeout) Spec() filters.Spec {
+	return s.spec
+}
+
+// Init initializes StreamIdleTimeout.
+func (s *StreamIdleTimeout) Init() {
+	// the timeout is validated, so there's no error.
+	s.timeout, _ = time.ParseDuration(s.spec.IdleTimeout)
+	s.abortedTotal = s.newAbortedCounter()
+}
+
+// Inherit inherits previous generation of StreamIdleTimeout.
+func (s *StreamIdleTimeout) Inherit(previousGeneration filters.Filter) {
+	s.Init()
+}
+
+func (s *StreamIdleTimeout) newAbortedCounter() *prometheus.CounterVec {
+	labels := prometheus.Labels{
+		"filterName":   s.Name(),
+		"kind":         Kind,
+		"clusterName":  "",
+		"clusterRole":  "",
+		"instanceName": "",
+	}
+	if super := s.spec.Super(); super != nil && super.Options() != nil {
+		labels["clusterName"] = super.Options().ClusterName
+		labels["clusterRole"] = super.Options().ClusterRole
+		labels["instanceName"] = super.Options().Name
+	}
+	return prometheushelper.NewCounter("streamidletimeout_aborted_streams",
+		"the total count of streaming responses aborted for the idle timeout",
+		[]string{"clusterName", "clusterRole", "instanceName", "filterName", "kind"},
+	).MustCurryWith(labels)
+}
+
+// Handle wraps the body of the streaming response, so that it is aborted
+// if no bytes flow for the idle timeout when it is sent to the client.
+func (s *StreamIdleTimeout) Handle(ctx *context.Context) string {
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil || !resp.IsStream() {
+		return ""
+	}
+
+	body, ok := resp.GetPayload().(io.ReadCloser)
+	if !ok {
+		return ""
+	}
+
+	req, _ := ctx.GetInputRequest().(*httpprot.Request)
+	r := readers.NewIdleTimeoutReader(body, s.timeout, func(bytesRead int) {
+		atomic.AddUint64(&s.aborted, 1)
+		s.abortedTotal.WithLabelValues().Inc()
+		path := ""
+		if req != nil {
+			path = req.Path()
+		}
+		logger.Warnf("%s: streaming response of %s aborted after idle for %s, %d bytes sent",
+			s.Name(), path, s.timeout, bytesRead)
+	})
+	resp.SetPayload(r)
+	ctx.AddTag("streamIdleTimeout: " + s.timeout.String())
+	return ""
+}
+
+// Status returns status.
+func (s *StreamIdleTimeout) Status() interface{} {
+	return &Status{Aborted: atomic.LoadUint64(&s.aborted)}
+}
+
+// Close closes StreamIdleTimeout.
+func (s *StreamIdleTimeout) Close() {
+}
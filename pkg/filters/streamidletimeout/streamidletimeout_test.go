@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package streamidletimeout
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestFilter(yamlConfig string) (*StreamIdleTimeout, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	s := kind.CreateInstance(spec).(*StreamIdleTimeout)
+	s.Init()
+	return s, nil
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newTestFilter(`
+kind: StreamIdleTimeout
+name: idle
+idleTimeout: 0s
+`)
+	assert.NotNil(err)
+
+	s, err := newTestFilter(`
+kind: StreamIdleTimeout
+name: idle
+idleTimeout: 50ms
+`)
+	assert.Nil(err)
+	assert.Equal(kind, s.Kind())
+	assert.Equal("idle", s.Name())
+
+	stdReq, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/events", nil)
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+
+	// no response, or not a stream.
+	assert.Equal("", s.Handle(ctx))
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetPayload([]byte("hello"))
+	ctx.SetOutputResponse(resp)
+	assert.Equal("", s.Handle(ctx))
+	assert.Equal("hello", string(resp.RawPayload()))
+
+	pr, pw := io.Pipe()
+	resp.SetPayload(pr)
+	assert.Equal("", s.Handle(ctx))
+
+	go func() {
+		for i := 0; i < 4; i++ {
+			pw.Write([]byte("data: x\n\n"))
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+
+	// the stream is sent until it is idle for the timeout.
+	data, err := io.ReadAll(resp.GetPayload())
+	assert.NotNil(err)
+	assert.Equal(36, len(data))
+	assert.Equal(uint64(1), s.Status().(*Status).Aborted)
+
+	resp.Close()
+	newFilter := kind.CreateInstance(s.spec).(*StreamIdleTimeout)
+	newFilter.Inherit(s)
+	assert.Equal(50*time.Millisecond, newFilter.timeout)
+	s.Close()
+	newFilter.Close()
+}
@@ -0,0 +1,632 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package streamprocessor implements a filter which streams the bodies of
+// requests and responses through an external HTTP service in chunks.
+package streamprocessor
+
+import (
+	"bytes"
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+const (
+	// Kind is the kind of StreamProcessor.
+	Kind = "StreamProcessor"
+
+	resultFailed         = "failed"
+	resultShortCircuited = "shortCircuited"
+
+	failureModeOpen   = "open"
+	failureModeClosed = "closed"
+
+	phaseRequestHeaders  = "requestHeaders"
+	phaseRequestBody     = "requestBody"
+	phaseResponseHeaders = "responseHeaders"
+	phaseResponseBody    = "responseBody"
+
+	actionContinue     = "continue"
+	actionMoreData     = "moreData"
+	actionShortCircuit = "shortCircuit"
+
+	defaultTimeout       = time.Second
+	defaultChunkSize     = 64 * 1024
+	defaultMaxBufferSize = 1024 * 1024
+	maxMaxBufferSize     = 4 * 1024 * 1024
+	// maxCalloutResponseSize is the max size of the response of the
+	// external service, the body in it is base64 encoded.
+	maxCalloutResponseSize = 8 * 1024 * 1024
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "StreamProcessor streams the bodies of requests and responses through an external HTTP service in chunks.",
+	Results:     []string{resultFailed, resultShortCircuited},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{
+			Timeout:       defaultTimeout.String(),
+			FailureMode:   failureModeClosed,
+			ProcessBody:   true,
+			ChunkSize:     defaultChunkSize,
+			MaxBufferSize: defaultMaxBufferSize,
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &StreamProcessor{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+// errShortCircuited is returned by the body reader when the external
+// service short circuits in the middle of a body which is in memory.
+var errShortCircuited = errors.New("short circuited by the external service")
+
+type (
+	// StreamProcessor is the filter StreamProcessor.
+	StreamProcessor struct {
+		spec   *Spec
+		client *http.Client
+
+		sessions       uint64
+		chunks         uint64
+		shortCircuited uint64
+		failures       uint64
+	}
+
+	// Spec is the spec of StreamProcessor.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// URL is the address of the external service.
+		URL string `json:"url" jsonschema:"required,format=uri"`
+		// Timeout is the timeout of a callout.
+		Timeout string `json:"timeout,omitempty" jsonschema:"format=duration"`
+		// FailureMode decides what to do if a callout fails, "open" to
+		// continue with the unprocessed data, "closed" to fail it.
+		FailureMode string `json:"failureMode,omitempty" jsonschema:"enum=open,enum=closed"`
+		// ProcessBody streams the body through the external service, only
+		// the headers are processed if it is false.
+		ProcessBody bool `json:"processBody,omitempty"`
+		// ChunkSize is the max size of a chunk read from the body.
+		ChunkSize int `json:"chunkSize,omitempty" jsonschema:"minimum=1"`
+		// MaxBufferSize is the max size of the data buffered when the
+		// external service requests more data.
+		MaxBufferSize int `json:"maxBufferSize,omitempty" jsonschema:"minimum=1"`
+
+		timeout time.Duration
+	}
+
+	// Status is the status of StreamProcessor.
+	Status struct {
+		Sessions       uint64 `json:"sessions"`
+		Chunks         uint64 `json:"chunks"`
+		ShortCircuited uint64 `json:"shortCircuited"`
+		Failures       uint64 `json:"failures"`
+	}
+
+	// calloutMessage is sent to the external service.
+	calloutMessage struct {
+		// Session identifies the messages of the same request or response.
+		Session string `json:"session"`
+		// Phase is one of requestHeaders, requestBody, responseHeaders
+		// and responseBody.
+		Phase    string `json:"phase"`
+		Sequence int    `json:"sequence"`
+
+		Method     string      `json:"method,omitempty"`
+		Path       string      `json:"path,omitempty"`
+		Query      string      `json:"query,omitempty"`
+		StatusCode int         `json:"statusCode,omitempty"`
+		Header     http.Header `json:"header,omitempty"`
+		// Body is the data buffered since the last decision of the
+		// external service, in the body phases.
+		Body        []byte `json:"body,omitempty"`
+		EndOfStream bool   `json:"endOfStream,omitempty"`
+	}
+
+	// calloutReply is the reply of the external service.
+	calloutReply struct {
+		// Action is one of continue, moreData and shortCircuit, default
+		// is continue.
+		Action string `json:"action,omitempty"`
+
+		// SetHeaders and RemoveHeaders mutate the headers in the headers
+		// phases.
+		SetHeaders    map[string]string `json:"setHeaders,omitempty"`
+		RemoveHeaders []string          `json:"removeHeaders,omitempty"`
+		// Body replaces the buffered data in the body phases if it is
+		// not nil.
+		Body *[]byte `json:"body,omitempty"`
+		// Response is the response to short circuit with.
+		Response *immediateResponse `json:"response,omitempty"`
+	}
+
+	immediateResponse struct {
+		StatusCode int         `json:"statusCode"`
+		Header     http.Header `json:"header,omitempty"`
+		Body       []byte      `json:"body,omitempty"`
+	}
+
+	// session is the processing of a request or a response.
+	session struct {
+		sp     *StreamProcessor
+		stdctx stdcontext.Context
+		id     string
+		seq    int
+	}
+
+	// bodyReader reads the body from src, streams it through the external
+	// service and returns the processed data.
+	bodyReader struct {
+		s     *session
+		phase string
+		src   io.Reader
+		buf   []byte
+
+		pending     []byte
+		out         []byte
+		eof         bool
+		passthrough bool
+		err         error
+		response    *immediateResponse
+
+		// stream is true if the body is a stream, the immediate response
+		// can't be delivered in its middle, so a short circuit is handled
+		// as a failure.
+		stream bool
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if spec.Timeout != "" {
+		if d, err := time.ParseDuration(spec.Timeout); err != nil {
+			return err
+		} else if d <= 0 {
+			return fmt.Errorf("timeout must be positive")
+		}
+	}
+	switch spec.FailureMode {
+	case "", failureModeOpen, failureModeClosed:
+	default:
+		return fmt.Errorf("invalid failureMode %s", spec.FailureMode)
+	}
+	if spec.MaxBufferSize > maxMaxBufferSize {
+		return fmt.Errorf("maxBufferSize must not be larger than %d", maxMaxBufferSize)
+	}
+	if spec.ChunkSize > 0 && spec.MaxBufferSize > 0 && spec.ChunkSize > spec.MaxBufferSize {
+		return fmt.Errorf("chunkSize must not be larger than maxBufferSize")
+	}
+	return nil
+}
+
+// Name returns the name of the StreamProcessor filter instance.
+func (sp *StreamProcessor) Name() string {
+	return sp.spec.Name()
+}
+
+// Kind returns the kind of StreamProcessor.
+func (sp *StreamProcessor) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the StreamProcessor
+func (sp *StreamProcessor) Spec() filters.Spec {
+	return sp.spec
+}
+
+// Init initializes StreamProcessor.
+func (sp *StreamProcessor) Init() {
+	sp.reload()
+}
+
+// Inherit inherits previous generation of StreamProcessor.
+func (sp *StreamProcessor) Inherit(previousGeneration filters.Filter) {
+	sp.reload()
+}
+
+func (sp *StreamProcessor) reload() {
+	sp.spec.timeout, _ = time.ParseDuration(sp.spec.Timeout)
+	if sp.spec.timeout <= 0 {
+		sp.spec.timeout = defaultTimeout
+	}
+	if sp.spec.ChunkSize <= 0 {
+		sp.spec.ChunkSize = defaultChunkSize
+	}
+	if sp.spec.MaxBufferSize <= 0 {
+		sp.spec.MaxBufferSize = defaultMaxBufferSize
+	}
+	sp.client = &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Handle processes the request, or the response if there's one.
+func (sp *StreamProcessor) Handle(ctx *context.Context) string {
+	atomic.AddUint64(&sp.sessions, 1)
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	s := &session{sp: sp, stdctx: req.Context(), id: uuid.NewString()}
+
+	if resp := ctx.GetInputResponse(); resp != nil {
+		return sp.handleResponse(ctx, s)
+	}
+	return sp.handleRequest(ctx, s, req)
+}
+
+func (sp *StreamProcessor) handleRequest(ctx *context.Context, s *session, req *httpprot.Request) string {
+	hasBody := req.IsStream() || len(req.RawPayload()) > 0
+	reply, err := s.callout(&calloutMessage{
+		Phase:       phaseRequestHeaders,
+		Method:      req.Method(),
+		Path:        req.Path(),
+		Query:       req.URL().RawQuery,
+		Header:      req.HTTPHeader(),
+		EndOfStream: !hasBody,
+	})
+	if err != nil {
+		return sp.fail(ctx, err)
+	}
+	if reply.Action == actionShortCircuit {
+		atomic.AddUint64(&sp.shortCircuited, 1)
+		return sp.shortCircuit(ctx, reply.Response)
+	}
+	applyHeaderMutation(req.HTTPHeader(), reply)
+
+	if !sp.spec.ProcessBody || !hasBody {
+		return ""
+	}
+
+	h := req.HTTPHeader()
+	if req.IsStream() {
+		req.SetPayload(s.newBodyReader(phaseRequestBody, req.GetPayload(), true))
+		req.ContentLength = -1
+		h.Del("Content-Length")
+		return ""
+	}
+
+	body, result := sp.processBody(ctx, s, phaseRequestBody, req.RawPayload())
+	if body != nil {
+		req.SetPayload(body)
+		req.ContentLength = int64(len(body))
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return result
+}
+
+func (sp *StreamProcessor) handleResponse(ctx *context.Context, s *session) string {
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		return ""
+	}
+
+	hasBody := resp.IsStream() || len(resp.RawPayload()) > 0
+	reply, err := s.callout(&calloutMessage{
+		Phase:       phaseResponseHeaders,
+		StatusCode:  resp.StatusCode(),
+		Header:      resp.HTTPHeader(),
+		EndOfStream: !hasBody,
+	})
+	if err != nil {
+		return sp.fail(ctx, err)
+	}
+	if reply.Action == actionShortCircuit {
+		atomic.AddUint64(&sp.shortCircuited, 1)
+		closePayload(resp.GetPayload(), resp.IsStream())
+		return sp.shortCircuit(ctx, reply.Response)
+	}
+	applyHeaderMutation(resp.HTTPHeader(), reply)
+
+	if !sp.spec.ProcessBody || !hasBody {
+		return ""
+	}
+
+	h := resp.HTTPHeader()
+	if resp.IsStream() {
+		resp.SetPayload(s.newBodyReader(phaseResponseBody, resp.GetPayload(), true))
+		resp.Std().ContentLength = -1
+		h.Del("Content-Length")
+		return ""
+	}
+
+	body, result := sp.processBody(ctx, s, phaseResponseBody, resp.RawPayload())
+	if body != nil {
+		resp.SetPayload(body)
+		resp.Std().ContentLength = int64(len(body))
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return result
+}
+
+// processBody processes a body which is already in memory, in the same
+// way as a stream, so that the external service sees the same protocol.
+// It returns nil if the body is not changed.
+func (sp *StreamProcessor) processBody(ctx *context.Context, s *session, phase string, body []byte) ([]byte, string) {
+	br := s.newBodyReader(phase, bytes.NewReader(body), false)
+	data, err := io.ReadAll(br)
+	if err == nil {
+		return data, ""
+	}
+	if br.response != nil {
+		return nil, sp.shortCircuit(ctx, br.response)
+	}
+	ctx.AddTag(fmt.Sprintf("streamProcessor: %v", err))
+	return nil, sp.failedResponse(ctx)
+}
+
+// fail handles the failure of a headers phase according to the failure
+// mode.
+func (sp *StreamProcessor) fail(ctx *context.Context, err error) string {
+	atomic.AddUint64(&sp.failures, 1)
+	if sp.spec.FailureMode == failureModeOpen {
+		logger.Warnf("%s: callout failed, continue without processing: %v", sp.Name(), err)
+		return ""
+	}
+	logger.Errorf("%s: callout failed: %v", sp.Name(), err)
+	ctx.AddTag(fmt.Sprintf("streamProcessor: callout failed: %v", err))
+	return sp.failedResponse(ctx)
+}
+
+func (sp *StreamProcessor) failedResponse(ctx *context.Context) string {
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(http.StatusServiceUnavailable)
+	ctx.SetOutputResponse(resp)
+	return resultFailed
+}
+
+// shortCircuit replaces the response with the immediate response, the
+// short circuit is counted by the caller.
+func (sp *StreamProcessor) shortCircuit(ctx *context.Context, ir *immediateResponse) string {
+	ctx.AddTag(fmt.Sprintf("streamProcessor: short circuited with status code %d", ir.StatusCode))
+
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(ir.StatusCode)
+	for k, vs := range ir.Header {
+		for _, v := range vs {
+			resp.HTTPHeader().Add(k, v)
+		}
+	}
+	resp.SetPayload(ir.Body)
+	ctx.SetOutputResponse(resp)
+	return resultShortCircuited
+}
+
+func applyHeaderMutation(h http.Header, reply *calloutReply) {
+	for _, k := range reply.RemoveHeaders {
+		h.Del(k)
+	}
+	for k, v := range reply.SetHeaders {
+		h.Set(k, v)
+	}
+}
+
+func closePayload(payload io.Reader, isStream bool) {
+	if !isStream {
+		return
+	}
+	if c, ok := payload.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// callout sends a message to the external service and returns its reply,
+// a 204 reply means continue without any change.
+func (s *session) callout(msg *calloutMessage) (*calloutReply, error) {
+	s.seq++
+	msg.Session, msg.Sequence = s.id, s.seq
+
+	data, err := codectool.MarshalJSON(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	stdctx, cancel := stdcontext.WithTimeout(s.stdctx, s.sp.spec.timeout)
+	defer cancel()
+	stdReq, err := http.NewRequestWithContext(stdctx, http.MethodPost, s.sp.spec.URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	stdReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.sp.client.Do(stdReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return &calloutReply{Action: actionContinue}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	data, err = io.ReadAll(io.LimitReader(resp.Body, maxCalloutResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxCalloutResponseSize {
+		return nil, fmt.Errorf("response is larger than %d bytes", maxCalloutResponseSize)
+	}
+
+	reply := &calloutReply{}
+	if err = codectool.UnmarshalJSON(data, reply); err != nil {
+		return nil, err
+	}
+	switch reply.Action {
+	case "":
+		reply.Action = actionContinue
+	case actionContinue, actionMoreData:
+	case actionShortCircuit:
+		ir := reply.Response
+		if ir == nil {
+			return nil, fmt.Errorf("short circuit without a response")
+		}
+		if ir.StatusCode < 200 || ir.StatusCode > 599 {
+			return nil, fmt.Errorf("invalid status code %d of the immediate response", ir.StatusCode)
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %s", reply.Action)
+	}
+	return reply, nil
+}
+
+func (s *session) newBodyReader(phase string, src io.Reader, stream bool) *bodyReader {
+	return &bodyReader{
+		s:      s,
+		phase:  phase,
+		src:    src,
+		buf:    make([]byte, s.sp.spec.ChunkSize),
+		stream: stream,
+	}
+}
+
+// Read implements io.Reader.
+func (br *bodyReader) Read(p []byte) (int, error) {
+	for len(br.out) == 0 {
+		if br.err != nil {
+			return 0, br.err
+		}
+		if br.eof {
+			return 0, io.EOF
+		}
+		br.next()
+	}
+
+	n := copy(p, br.out)
+	br.out = br.out[n:]
+	return n, nil
+}
+
+// Close implements io.Closer, it closes the source.
+func (br *bodyReader) Close() error {
+	if c, ok := br.src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// next reads a chunk from the source and sends the buffered data to the
+// external service.
+func (br *bodyReader) next() {
+	n, err := br.src.Read(br.buf)
+	br.pending = append(br.pending, br.buf[:n]...)
+	if err == io.EOF {
+		br.eof = true
+	} else if err != nil {
+		br.err = err
+		return
+	} else if n == 0 {
+		return
+	}
+
+	if br.passthrough {
+		br.flush(nil)
+		return
+	}
+	if len(br.pending) > br.s.sp.spec.MaxBufferSize {
+		br.fail(fmt.Errorf("buffered data exceeds %d bytes", br.s.sp.spec.MaxBufferSize))
+		return
+	}
+
+	atomic.AddUint64(&br.s.sp.chunks, 1)
+	reply, err := br.s.callout(&calloutMessage{
+		Phase:       br.phase,
+		Body:        br.pending,
+		EndOfStream: br.eof,
+	})
+	if err != nil {
+		br.fail(err)
+		return
+	}
+
+	switch reply.Action {
+	case actionMoreData:
+		if br.eof {
+			br.fail(fmt.Errorf("more data requested at the end of the stream"))
+		}
+	case actionShortCircuit:
+		if br.stream {
+			br.fail(fmt.Errorf("short circuit is not supported in the body of a stream"))
+			return
+		}
+		atomic.AddUint64(&br.s.sp.shortCircuited, 1)
+		br.response = reply.Response
+		br.err = errShortCircuited
+	default:
+		br.flush(reply.Body)
+	}
+}
+
+// flush moves the buffered data, or its replacement, to the output.
+func (br *bodyReader) flush(replacement *[]byte) {
+	if replacement != nil {
+		br.out = *replacement
+	} else {
+		br.out = br.pending
+	}
+	br.pending = nil
+}
+
+// fail handles the failure of a body phase according to the failure mode,
+// the rest of the body is passed through in the open mode.
+func (br *bodyReader) fail(err error) {
+	sp := br.s.sp
+	atomic.AddUint64(&sp.failures, 1)
+	if sp.spec.FailureMode == failureModeOpen {
+		logger.Warnf("%s: %s failed, continue without processing: %v", sp.Name(), br.phase, err)
+		br.passthrough = true
+		br.flush(nil)
+		return
+	}
+	logger.Errorf("%s: %s failed: %v", sp.Name(), br.phase, err)
+	br.err = fmt.Errorf("%s failed: %v", br.phase, err)
+}
+
+// Status returns status.
+func (sp *StreamProcessor) Status() interface{} {
+	return &Status{
+		Sessions:       atomic.LoadUint64(&sp.sessions),
+		Chunks:         atomic.LoadUint64(&sp.chunks),
+		ShortCircuited: atomic.LoadUint64(&sp.shortCircuited),
+		Failures:       atomic.LoadUint64(&sp.failures),
+	}
+}
+
+// Close closes StreamProcessor.
+func (sp *StreamProcessor) Close() {
+	sp.client.CloseIdleConnections()
+}
@@ -0,0 +1,337 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package streamprocessor
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestProcessor(yamlConfig string) (*StreamProcessor, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	sp := kind.CreateInstance(spec).(*StreamProcessor)
+	sp.Init()
+	return sp, nil
+}
+
+func newContext(body string, stream bool) *context.Context {
+	stdReq, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/api", strings.NewReader(body))
+	stdReq.Header.Set("X-Remove", "true")
+	req, _ := httpprot.NewRequest(stdReq)
+	if stream {
+		req.SetPayload(io.NopCloser(strings.NewReader(body)))
+	} else {
+		req.FetchPayload(1024)
+	}
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+// testService is an external service which upper cases the data, and
+// requests more data until it has at least minData bytes.
+type testService struct {
+	lock     sync.Mutex
+	minData  int
+	messages []*calloutMessage
+	reply    func(msg *calloutMessage) string
+}
+
+func (ts *testService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	msg := &calloutMessage{}
+	codectool.MustUnmarshal(data, msg)
+
+	ts.lock.Lock()
+	ts.messages = append(ts.messages, msg)
+	ts.lock.Unlock()
+
+	if ts.reply != nil {
+		if reply := ts.reply(msg); reply != "" {
+			w.Write([]byte(reply))
+			return
+		}
+	}
+
+	switch msg.Phase {
+	case phaseRequestHeaders, phaseResponseHeaders:
+		w.Write([]byte(`{"setHeaders": {"X-Processed": "true"}, "removeHeaders": ["X-Remove"]}`))
+	default:
+		if len(msg.Body) < ts.minData && !msg.EndOfStream {
+			w.Write([]byte(`{"action": "moreData"}`))
+			return
+		}
+		body := bytes.ToUpper(msg.Body)
+		w.Write(codectool.MustMarshalJSON(&calloutReply{Body: &body}))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, cfg := range []string{
+		"timeout: 0s",
+		"failureMode: unknown",
+		"maxBufferSize: 8388608",
+		"chunkSize: 16\nmaxBufferSize: 8",
+	} {
+		_, err := newTestProcessor("kind: StreamProcessor\nname: sp\nurl: http://127.0.0.1\n" + cfg)
+		assert.NotNil(err, cfg)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := &testService{minData: 6}
+	svr := httptest.NewServer(ts)
+	defer svr.Close()
+
+	sp, err := newTestProcessor(`
+kind: StreamProcessor
+name: sp
+url: ` + svr.URL + `
+chunkSize: 4
+`)
+	assert.Nil(err)
+	defer sp.Close()
+
+	// buffered body.
+	ctx := newContext("hello world", false)
+	assert.Equal("", sp.Handle(ctx))
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	assert.Equal("HELLO WORLD", string(req.RawPayload()))
+	assert.Equal("11", req.HTTPHeader().Get("Content-Length"))
+	assert.Equal("true", req.HTTPHeader().Get("X-Processed"))
+	assert.Equal("", req.HTTPHeader().Get("X-Remove"))
+
+	assert.Equal(phaseRequestHeaders, ts.messages[0].Phase)
+	assert.Equal("/api", ts.messages[0].Path)
+	assert.Equal("hell", string(ts.messages[1].Body))
+	assert.Equal("hello wo", string(ts.messages[2].Body))
+	for i, msg := range ts.messages {
+		assert.Equal(ts.messages[0].Session, msg.Session)
+		assert.Equal(i+1, msg.Sequence)
+	}
+	last := ts.messages[len(ts.messages)-1]
+	assert.True(last.EndOfStream)
+
+	// stream body.
+	ts.messages = nil
+	ctx = newContext("hello stream", true)
+	assert.Equal("", sp.Handle(ctx))
+	req = ctx.GetInputRequest().(*httpprot.Request)
+	assert.True(req.IsStream())
+	assert.Equal("", req.HTTPHeader().Get("Content-Length"))
+	// nothing is sent before the body is read.
+	assert.Equal(1, len(ts.messages))
+	data, err := io.ReadAll(req.GetPayload())
+	assert.Nil(err)
+	assert.Equal("HELLO STREAM", string(data))
+
+	status := sp.Status().(*Status)
+	assert.Equal(uint64(2), status.Sessions)
+	assert.Equal(uint64(0), status.Failures)
+}
+
+func TestResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := &testService{}
+	svr := httptest.NewServer(ts)
+	defer svr.Close()
+
+	sp, err := newTestProcessor(`
+kind: StreamProcessor
+name: sp
+url: ` + svr.URL + `
+`)
+	assert.Nil(err)
+	defer sp.Close()
+
+	ctx := newContext("", false)
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(http.StatusCreated)
+	resp.SetPayload([]byte("created"))
+	ctx.SetOutputResponse(resp)
+
+	assert.Equal("", sp.Handle(ctx))
+	resp = ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal("CREATED", string(resp.RawPayload()))
+	assert.Equal("true", resp.HTTPHeader().Get("X-Processed"))
+	assert.Equal(phaseResponseHeaders, ts.messages[0].Phase)
+	assert.Equal(http.StatusCreated, ts.messages[0].StatusCode)
+	assert.Equal(phaseResponseBody, ts.messages[1].Phase)
+}
+
+func TestShortCircuit(t *testing.T) {
+	assert := assert.New(t)
+
+	const denied = `{"action": "shortCircuit", "response": {"statusCode": 403, "body": "ZGVuaWVk"}}`
+	ts := &testService{}
+	svr := httptest.NewServer(ts)
+	defer svr.Close()
+
+	sp, err := newTestProcessor(`
+kind: StreamProcessor
+name: sp
+url: ` + svr.URL + `
+chunkSize: 4
+`)
+	assert.Nil(err)
+	defer sp.Close()
+
+	// short circuit the headers.
+	ts.reply = func(msg *calloutMessage) string {
+		if msg.Phase == phaseRequestHeaders {
+			return denied
+		}
+		return ""
+	}
+	ctx := newContext("hello", false)
+	assert.Equal(resultShortCircuited, sp.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusForbidden, resp.StatusCode())
+	assert.Equal("denied", string(resp.RawPayload()))
+
+	// short circuit in the middle of the body.
+	ts.reply = func(msg *calloutMessage) string {
+		if bytes.Contains(msg.Body, []byte("o")) {
+			return denied
+		}
+		return ""
+	}
+	ctx = newContext("hello world", false)
+	assert.Equal(resultShortCircuited, sp.Handle(ctx))
+	resp = ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusForbidden, resp.StatusCode())
+
+	status := sp.Status().(*Status)
+	assert.Equal(uint64(2), status.ShortCircuited)
+	assert.Equal(uint64(0), status.Failures)
+}
+
+func TestShortCircuitStream(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := &testService{}
+	ts.reply = func(msg *calloutMessage) string {
+		if bytes.Contains(msg.Body, []byte("o")) {
+			return `{"action": "shortCircuit", "response": {"statusCode": 403}}`
+		}
+		return ""
+	}
+	svr := httptest.NewServer(ts)
+	defer svr.Close()
+
+	sp, err := newTestProcessor(`
+kind: StreamProcessor
+name: sp
+url: ` + svr.URL + `
+chunkSize: 4
+`)
+	assert.Nil(err)
+	defer sp.Close()
+
+	// a short circuit in the middle of a stream fails the stream in the
+	// closed mode.
+	ctx := newContext("hello world", true)
+	assert.Equal("", sp.Handle(ctx))
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	data, err := io.ReadAll(req.GetPayload())
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "short circuit is not supported")
+	assert.Equal("HELL", string(data))
+
+	// the rest of the stream is passed through in the open mode.
+	sp.spec.FailureMode = failureModeOpen
+	ctx = newContext("hello world", true)
+	assert.Equal("", sp.Handle(ctx))
+	req = ctx.GetInputRequest().(*httpprot.Request)
+	data, err = io.ReadAll(req.GetPayload())
+	assert.Nil(err)
+	assert.Equal("HELLo world", string(data))
+
+	status := sp.Status().(*Status)
+	assert.Equal(uint64(0), status.ShortCircuited)
+	assert.Equal(uint64(2), status.Failures)
+}
+
+func TestFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := &testService{minData: 100}
+	svr := httptest.NewServer(ts)
+	defer svr.Close()
+
+	// the buffered data exceeds the limit.
+	sp, err := newTestProcessor(`
+kind: StreamProcessor
+name: sp
+url: ` + svr.URL + `
+chunkSize: 4
+maxBufferSize: 8
+`)
+	assert.Nil(err)
+	defer sp.Close()
+
+	ctx := newContext("hello world", false)
+	assert.Equal(resultFailed, sp.Handle(ctx))
+	assert.Equal(http.StatusServiceUnavailable, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	// the rest of the body is passed through in the open mode.
+	sp.spec.FailureMode = failureModeOpen
+	ctx = newContext("hello world", false)
+	assert.Equal("", sp.Handle(ctx))
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	assert.Equal("hello world", string(req.RawPayload()))
+
+	// the external service is down.
+	svr.Close()
+	ctx = newContext("hello world", false)
+	assert.Equal("", sp.Handle(ctx))
+	req = ctx.GetInputRequest().(*httpprot.Request)
+	assert.Equal("hello world", string(req.RawPayload()))
+
+	sp.spec.FailureMode = failureModeClosed
+	ctx = newContext("hello world", false)
+	assert.Equal(resultFailed, sp.Handle(ctx))
+
+	assert.Equal(uint64(4), sp.Status().(*Status).Failures)
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package subsetrouter implements a filter which selects the backend subset
+// by the routing headers of a service mesh.
+package subsetrouter
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of SubsetRouter.
+	Kind = "SubsetRouter"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "SubsetRouter selects the backend subset by the routing headers of a service mesh.",
+	Results:     []string{},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &SubsetRouter{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// SubsetRouter is the filter SubsetRouter.
+	SubsetRouter struct {
+		spec    *Spec
+		subsets map[string]*subset
+	}
+
+	// Spec is the spec of SubsetRouter.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// SourceHeader is the mesh routing header, its value is the name
+		// of a subset, or labels of a subset like version=v1,zone=a.
+		SourceHeader string `json:"sourceHeader" jsonschema:"required"`
+		// Header is the request header to carry the selected subset,
+		// Proxy pools choose the servers tagged with the subset by it if
+		// it is the subsetHeader of their load balancers.
+		Header        string    `json:"header" jsonschema:"required"`
+		Subsets       []*Subset `json:"subsets" jsonschema:"required,minItems=1"`
+		DefaultSubset string    `json:"defaultSubset,omitempty"`
+	}
+
+	// Subset is a subset of the backend servers.
+	Subset struct {
+		Name   string            `json:"name" jsonschema:"required"`
+		Labels map[string]string `json:"labels,omitempty"`
+	}
+
+	// Status is the status of SubsetRouter.
+	Status struct {
+		// Requests are the number of requests routed to every subset.
+		Requests map[string]uint64 `json:"requests"`
+	}
+
+	subset struct {
+		*Subset
+		requests uint64
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	names := map[string]bool{}
+	for _, s := range spec.Subsets {
+		if names[s.Name] {
+			return fmt.Errorf("duplicated subset %s", s.Name)
+		}
+		names[s.Name] = true
+	}
+	if spec.DefaultSubset != "" && !names[spec.DefaultSubset] {
+		return fmt.Errorf("default subset %s not found", spec.DefaultSubset)
+	}
+	return nil
+}
+
+// Name returns the name of the SubsetRouter filter instance.
+func (sr *SubsetRouter) Name() string {
+	return sr.spec.Name()
+}
+
+// Kind returns the kind of SubsetRouter.
+func (sr *SubsetRouter) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the SubsetRouter
+func (sr *SubsetRouter) Spec() filters.Spec {
+	return sr.spec
+}
+
+// Init initializes SubsetRouter.
+func (sr *SubsetRouter) Init() {
+	sr.subsets = make(map[string]*subset, len(sr.spec.Subsets))
+	for _, s := range sr.spec.Subsets {
+		sr.subsets[s.Name] = &subset{Subset: s}
+	}
+}
+
+// Inherit inherits previous generation of SubsetRouter.
+func (sr *SubsetRouter) Inherit(previousGeneration filters.Filter) {
+	sr.Init()
+}
+
+// parseLabels parses labels like version=v1,zone=a, it returns nil if the
+// value is not in this format.
+func parseLabels(value string) map[string]string {
+	labels := map[string]string{}
+	for _, kv := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil
+		}
+		labels[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return labels
+}
+
+// match returns the subset matching the value of the mesh routing header,
+// which is the first subset whose labels are all in the labels of the value
+// if the value is not the name of a subset.
+func (sr *SubsetRouter) match(value string) *subset {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	if s := sr.subsets[value]; s != nil {
+		return s
+	}
+
+	labels := parseLabels(value)
+	if labels == nil {
+		return nil
+	}
+	for _, s := range sr.spec.Subsets {
+		if len(s.Labels) == 0 {
+			continue
+		}
+		matched := true
+		for k, v := range s.Labels {
+			if labels[k] != v {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return sr.subsets[s.Name]
+		}
+	}
+	return nil
+}
+
+// Handle sets the selected subset to the request header.
+func (sr *SubsetRouter) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	header := req.HTTPHeader()
+
+	s := sr.match(header.Get(sr.spec.SourceHeader))
+	// remove the header sent by the client, so the subset can't be forged.
+	header.Del(sr.spec.Header)
+	if s == nil {
+		s = sr.subsets[sr.spec.DefaultSubset]
+	}
+	if s == nil {
+		return ""
+	}
+
+	atomic.AddUint64(&s.requests, 1)
+	header.Set(sr.spec.Header, s.Name)
+	ctx.LazyAddTag(func() string {
+		return "subsetRouter: " + s.Name
+	})
+	return ""
+}
+
+// Status returns status.
+func (sr *SubsetRouter) Status() interface{} {
+	s := &Status{Requests: make(map[string]uint64, len(sr.subsets))}
+	for name, subset := range sr.subsets {
+		s.Requests[name] = atomic.LoadUint64(&subset.requests)
+	}
+	return s
+}
+
+// Close closes SubsetRouter.
+func (sr *SubsetRouter) Close() {
+}
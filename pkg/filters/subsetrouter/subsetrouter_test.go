@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package subsetrouter
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func createSubsetRouter(yamlConfig string) (*SubsetRouter, error) {
+	rawSpec := make(map[string]interface{})
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	sr := kind.CreateInstance(spec).(*SubsetRouter)
+	sr.Init()
+	return sr, nil
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := createSubsetRouter(`
+kind: SubsetRouter
+name: sr
+sourceHeader: X-Destination-Subset
+header: X-Subset
+subsets:
+- name: v1
+- name: v1
+`)
+	assert.Error(err)
+
+	_, err = createSubsetRouter(`
+kind: SubsetRouter
+name: sr
+sourceHeader: X-Destination-Subset
+header: X-Subset
+subsets:
+- name: v1
+defaultSubset: v2
+`)
+	assert.Error(err)
+}
+
+func TestHandle(t *testing.T) {
+	assert := assert.New(t)
+
+	sr, err := createSubsetRouter(`
+kind: SubsetRouter
+name: sr
+sourceHeader: X-Destination-Subset
+header: X-Subset
+subsets:
+- name: v1
+  labels:
+    version: v1
+- name: v2-canary
+  labels:
+    version: v2
+    track: canary
+- name: v2
+  labels:
+    version: v2
+defaultSubset: v1
+`)
+	assert.Nil(err)
+
+	handle := func(source, forged string) string {
+		stdReq, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1", nil)
+		if source != "" {
+			stdReq.Header.Set("X-Destination-Subset", source)
+		}
+		if forged != "" {
+			stdReq.Header.Set("X-Subset", forged)
+		}
+		req, _ := httpprot.NewRequest(stdReq)
+		ctx := context.New(nil)
+		ctx.SetInputRequest(req)
+		assert.Equal("", sr.Handle(ctx))
+		return req.HTTPHeader().Get("X-Subset")
+	}
+
+	assert.Equal("v2", handle("v2", ""))
+	assert.Equal("v2-canary", handle("version=v2, track=canary", ""))
+	assert.Equal("v2", handle("version=v2,zone=a", ""))
+	assert.Equal("v1", handle("version=v3", ""))
+	assert.Equal("v1", handle("unknown", "v2"))
+	assert.Equal("v1", handle("", ""))
+
+	status := sr.Status().(*Status)
+	assert.Equal(uint64(2), status.Requests["v2"])
+	assert.Equal(uint64(1), status.Requests["v2-canary"])
+	assert.Equal(uint64(3), status.Requests["v1"])
+
+	// without default subset, the forged header is removed.
+	sr.spec.DefaultSubset = ""
+	sr.Inherit(sr)
+	assert.Equal("", handle("unknown", "v2"))
+	sr.Close()
+}
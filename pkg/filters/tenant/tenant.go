@@ -0,0 +1,452 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tenant implements a filter which resolves the tenant of requests
+// and isolates the rate limits, quotas and concurrency limits of tenants.
+package tenant
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/time/rate"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of Tenant.
+	Kind = "Tenant"
+
+	// DataKey is the key of the tenant ID in the context data, filters
+	// after Tenant could use it in their key templates, like
+	// {{.data.TENANT_ID}}. It is the default tenant for tenants without a
+	// policy.
+	DataKey = "TENANT_ID"
+
+	// SourceHeader resolves the tenant ID from a header.
+	SourceHeader = "header"
+	// SourceJWTClaim resolves the tenant ID from a claim of the JWT in the
+	// Authorization header.
+	SourceJWTClaim = "jwtClaim"
+	// SourceSubdomain resolves the tenant ID from the subdomain of the host.
+	SourceSubdomain = "subdomain"
+
+	resultRateLimited         = "rateLimited"
+	resultQuotaExceeded       = "quotaExceeded"
+	resultConcurrencyExceeded = "concurrencyExceeded"
+
+	defaultHeader        = "X-Tenant-Id"
+	defaultClaim         = "tenant"
+	defaultTenant        = "default"
+	defaultQuotaPeriod   = time.Hour
+	defaultMaxConcurrent = 100
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "Tenant resolves the tenant of requests and enforces the rate limits, quotas and concurrency limits of each tenant.",
+	Results:     []string{resultRateLimited, resultQuotaExceeded, resultConcurrencyExceeded},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{Source: SourceHeader}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &Tenant{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// Tenant is the filter Tenant.
+	//
+	// It keeps a bucket for every configured tenant, and all other tenants,
+	// including requests whose tenant can't be resolved, share the default
+	// bucket. So forged tenant IDs can't create unbounded buckets, and
+	// can't affect the configured tenants.
+	Tenant struct {
+		spec *Spec
+
+		policies map[string]*Policy
+		buckets  sync.Map
+	}
+
+	// Spec is the spec of Tenant.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		Source string `json:"source,omitempty" jsonschema:"enum=header,enum=jwtClaim,enum=subdomain"`
+		// Header is the header of the tenant ID for source header.
+		Header string `json:"header,omitempty"`
+		// Claim is the claim of the tenant ID for source jwtClaim.
+		Claim string `json:"claim,omitempty"`
+		// Domain is the parent domain for source subdomain, the tenant ID
+		// of host "t1.api.example.com" is "t1" if the domain is
+		// "api.example.com".
+		Domain        string    `json:"domain,omitempty"`
+		Tenants       []*Policy `json:"tenants,omitempty"`
+		DefaultPolicy *Policy   `json:"defaultPolicy,omitempty"`
+	}
+
+	// Policy is the limits of a tenant, a zero limit means unlimited.
+	Policy struct {
+		Name string `json:"name,omitempty"`
+		// RateLimit is the max requests per second.
+		RateLimit float64 `json:"rateLimit,omitempty" jsonschema:"minimum=0"`
+		Burst     int     `json:"burst,omitempty" jsonschema:"minimum=0"`
+		// Quota is the max requests in every QuotaPeriod.
+		Quota          int64  `json:"quota,omitempty" jsonschema:"minimum=0"`
+		QuotaPeriod    string `json:"quotaPeriod,omitempty" jsonschema:"format=duration"`
+		MaxConcurrency int32  `json:"maxConcurrency,omitempty" jsonschema:"minimum=0"`
+	}
+
+	// Status is the status of Tenant.
+	Status struct {
+		Tenants map[string]*TenantStatus `json:"tenants"`
+	}
+
+	// TenantStatus is the utilization of a tenant.
+	TenantStatus struct {
+		Requests           uint64  `json:"requests"`
+		RateLimited        uint64  `json:"rateLimited"`
+		QuotaExceeded      uint64  `json:"quotaExceeded"`
+		ConcurrencyLimited uint64  `json:"concurrencyLimited"`
+		Active             int32   `json:"active"`
+		QuotaUsed          int64   `json:"quotaUsed"`
+		QuotaUtilization   float64 `json:"quotaUtilization"`
+		Utilization        float64 `json:"utilization"`
+	}
+
+	// bucket is the limiters of a tenant.
+	bucket struct {
+		policy      *Policy
+		limiter     *rate.Limiter
+		quotaPeriod time.Duration
+
+		mutex       sync.Mutex
+		quotaUsed   int64
+		quotaWindow time.Time
+
+		active             int32
+		requests           uint64
+		rateLimited        uint64
+		quotaExceeded      uint64
+		concurrencyLimited uint64
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if spec.Source == SourceSubdomain && spec.Domain == "" {
+		return fmt.Errorf("domain is required for source subdomain")
+	}
+
+	names := map[string]struct{}{}
+	for _, p := range spec.Tenants {
+		if p.Name == "" {
+			return fmt.Errorf("name of tenant is required")
+		}
+		if p.Name == defaultTenant {
+			return fmt.Errorf("tenant name %s is reserved", defaultTenant)
+		}
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("duplicated tenant: %s", p.Name)
+		}
+		names[p.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+// Validate validates the policy.
+func (p *Policy) Validate() error {
+	if p.QuotaPeriod == "" {
+		return nil
+	}
+	if d, err := time.ParseDuration(p.QuotaPeriod); err != nil || d <= 0 {
+		return fmt.Errorf("invalid quotaPeriod %q", p.QuotaPeriod)
+	}
+	return nil
+}
+
+func newBucket(policy *Policy) *bucket {
+	b := &bucket{policy: policy, quotaPeriod: defaultQuotaPeriod}
+
+	if policy.RateLimit > 0 {
+		burst := policy.Burst
+		if burst <= 0 {
+			burst = int(policy.RateLimit)
+			if burst < 1 {
+				burst = 1
+			}
+		}
+		b.limiter = rate.NewLimiter(rate.Limit(policy.RateLimit), burst)
+	}
+
+	if policy.QuotaPeriod != "" {
+		b.quotaPeriod, _ = time.ParseDuration(policy.QuotaPeriod)
+	}
+
+	return b
+}
+
+// acquire acquires a concurrency slot of the bucket.
+func (b *bucket) acquire() bool {
+	if b.policy.MaxConcurrency <= 0 {
+		atomic.AddInt32(&b.active, 1)
+		return true
+	}
+	if atomic.AddInt32(&b.active, 1) > b.policy.MaxConcurrency {
+		atomic.AddInt32(&b.active, -1)
+		return false
+	}
+	return true
+}
+
+func (b *bucket) release() {
+	atomic.AddInt32(&b.active, -1)
+}
+
+// consumeQuota consumes the quota of the current window, the window is
+// aligned to the quota period, and the usage restarts from zero when the
+// window changes.
+func (b *bucket) consumeQuota(now time.Time) bool {
+	if b.policy.Quota <= 0 {
+		return true
+	}
+
+	window := now.Truncate(b.quotaPeriod)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !window.Equal(b.quotaWindow) {
+		b.quotaWindow = window
+		b.quotaUsed = 0
+	}
+	if b.quotaUsed >= b.policy.Quota {
+		return false
+	}
+	b.quotaUsed++
+	return true
+}
+
+func (b *bucket) status() *TenantStatus {
+	s := &TenantStatus{
+		Requests:           atomic.LoadUint64(&b.requests),
+		RateLimited:        atomic.LoadUint64(&b.rateLimited),
+		QuotaExceeded:      atomic.LoadUint64(&b.quotaExceeded),
+		ConcurrencyLimited: atomic.LoadUint64(&b.concurrencyLimited),
+		Active:             atomic.LoadInt32(&b.active),
+	}
+
+	if b.policy.Quota > 0 {
+		b.mutex.Lock()
+		if b.quotaWindow.Equal(time.Now().Truncate(b.quotaPeriod)) {
+			s.QuotaUsed = b.quotaUsed
+		}
+		b.mutex.Unlock()
+		s.QuotaUtilization = float64(s.QuotaUsed) / float64(b.policy.Quota)
+	}
+	if b.policy.MaxConcurrency > 0 {
+		s.Utilization = float64(s.Active) / float64(b.policy.MaxConcurrency)
+	}
+
+	return s
+}
+
+// Name returns the name of the Tenant filter instance.
+func (t *Tenant) Name() string {
+	return t.spec.Name()
+}
+
+// Kind returns the kind of Tenant.
+func (t *Tenant) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the Tenant
+func (t *Tenant) Spec() filters.Spec {
+	return t.spec
+}
+
+// Init initializes Tenant.
+func (t *Tenant) Init() {
+	t.reload()
+}
+
+// Inherit inherits previous generation of Tenant.
+func (t *Tenant) Inherit(previousGeneration filters.Filter) {
+	t.reload()
+}
+
+func (t *Tenant) reload() {
+	t.policies = map[string]*Policy{}
+	for _, p := range t.spec.Tenants {
+		t.policies[p.Name] = p
+	}
+	if t.spec.DefaultPolicy == nil {
+		t.spec.DefaultPolicy = &Policy{MaxConcurrency: defaultMaxConcurrent}
+	}
+	if t.spec.Header == "" {
+		t.spec.Header = defaultHeader
+	}
+	if t.spec.Claim == "" {
+		t.spec.Claim = defaultClaim
+	}
+	t.spec.Domain = strings.ToLower(strings.Trim(t.spec.Domain, "."))
+}
+
+// resolve resolves the tenant ID of the request, it returns an empty
+// string if the tenant can't be resolved.
+func (t *Tenant) resolve(req *httpprot.Request) string {
+	switch t.spec.Source {
+	case SourceJWTClaim:
+		return t.resolveJWTClaim(req)
+	case SourceSubdomain:
+		return t.resolveSubdomain(req)
+	default:
+		return req.HTTPHeader().Get(t.spec.Header)
+	}
+}
+
+// resolveJWTClaim gets the tenant ID from a claim of the JWT. The token is
+// NOT verified, a Validator should be put before Tenant to verify it.
+func (t *Tenant) resolveJWTClaim(req *httpprot.Request) string {
+	const prefix = "Bearer "
+	authHdr := req.HTTPHeader().Get("Authorization")
+	if !strings.HasPrefix(authHdr, prefix) {
+		return ""
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(authHdr[len(prefix):], claims); err != nil {
+		return ""
+	}
+
+	switch v := claims[t.spec.Claim].(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+func (t *Tenant) resolveSubdomain(req *httpprot.Request) string {
+	host := req.Host()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	sub, ok := strings.CutSuffix(host, "."+t.spec.Domain)
+	if !ok {
+		return ""
+	}
+	// only the label right before the domain is the tenant.
+	if i := strings.LastIndexByte(sub, '.'); i >= 0 {
+		sub = sub[i+1:]
+	}
+	return sub
+}
+
+// getBucket returns the bucket of the tenant and the name of the bucket,
+// tenants without a policy share the default bucket.
+func (t *Tenant) getBucket(tenant string) (*bucket, string) {
+	policy, ok := t.policies[tenant]
+	if !ok {
+		tenant, policy = defaultTenant, t.spec.DefaultPolicy
+	}
+
+	if b, ok := t.buckets.Load(tenant); ok {
+		return b.(*bucket), tenant
+	}
+	b, _ := t.buckets.LoadOrStore(tenant, newBucket(policy))
+	return b.(*bucket), tenant
+}
+
+func (t *Tenant) reject(ctx *context.Context, tenant, reason string, statusCode int) {
+	ctx.AddTag(fmt.Sprintf("tenant: %s %s", tenant, reason))
+
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(statusCode)
+	ctx.SetOutputResponse(resp)
+}
+
+// Handle resolves the tenant of the request, and checks the limits of the
+// tenant.
+func (t *Tenant) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	// the ID is unverified unless it is from a JWT, so tenants without a
+	// policy are saved as the default tenant, to keep the keys of the
+	// filters after Tenant bounded.
+	b, name := t.getBucket(t.resolve(req))
+	ctx.SetData(DataKey, name)
+	atomic.AddUint64(&b.requests, 1)
+
+	if !b.acquire() {
+		atomic.AddUint64(&b.concurrencyLimited, 1)
+		t.reject(ctx, name, "concurrency exceeded", http.StatusServiceUnavailable)
+		return resultConcurrencyExceeded
+	}
+
+	if b.limiter != nil && !b.limiter.Allow() {
+		b.release()
+		atomic.AddUint64(&b.rateLimited, 1)
+		t.reject(ctx, name, "rate limited", http.StatusTooManyRequests)
+		return resultRateLimited
+	}
+
+	if !b.consumeQuota(time.Now()) {
+		b.release()
+		atomic.AddUint64(&b.quotaExceeded, 1)
+		t.reject(ctx, name, "quota exceeded", http.StatusTooManyRequests)
+		return resultQuotaExceeded
+	}
+
+	ctx.OnFinish(b.release)
+	return ""
+}
+
+// Status returns the utilization of all tenants.
+func (t *Tenant) Status() interface{} {
+	s := &Status{Tenants: map[string]*TenantStatus{}}
+	t.buckets.Range(func(key, value interface{}) bool {
+		s.Tenants[key.(string)] = value.(*bucket).status()
+		return true
+	})
+	return s
+}
+
+// Close closes Tenant.
+func (t *Tenant) Close() {
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tenant
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestFilter(yamlConfig string) *Tenant {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		panic(err)
+	}
+	t := kind.CreateInstance(spec).(*Tenant)
+	t.Init()
+	return t
+}
+
+func newContext(host string, header http.Header) *context.Context {
+	stdr, _ := http.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+	for k, v := range header {
+		stdr.Header[k] = v
+	}
+	req, _ := httpprot.NewRequest(stdr)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &Spec{Source: SourceSubdomain}
+	assert.Error(spec.Validate())
+
+	spec = &Spec{Tenants: []*Policy{{Name: "t1"}, {Name: "t1"}}}
+	assert.Error(spec.Validate())
+
+	spec = &Spec{Tenants: []*Policy{{Name: defaultTenant}}}
+	assert.Error(spec.Validate())
+
+	spec = &Spec{Tenants: []*Policy{{}}}
+	assert.Error(spec.Validate())
+
+	p := &Policy{QuotaPeriod: "-1s"}
+	assert.Error(p.Validate())
+
+	spec = &Spec{Source: SourceSubdomain, Domain: "example.com", Tenants: []*Policy{{Name: "t1"}}}
+	assert.NoError(spec.Validate())
+}
+
+func TestResolve(t *testing.T) {
+	assert := assert.New(t)
+
+	tn := newTestFilter(`
+kind: Tenant
+name: tenant
+`)
+	assert.Equal(kind, tn.Kind())
+	assert.Equal("tenant", tn.Name())
+
+	resolve := func(ctx *context.Context) string {
+		return tn.resolve(ctx.GetInputRequest().(*httpprot.Request))
+	}
+
+	ctx := newContext("127.0.0.1", http.Header{"X-Tenant-Id": {"t1"}})
+	assert.Equal("t1", resolve(ctx))
+	// the tenant has no policy, so it is saved as the default tenant.
+	assert.Empty(tn.Handle(ctx))
+	assert.Equal(defaultTenant, ctx.GetData(DataKey))
+
+	ctx = newContext("127.0.0.1", nil)
+	assert.Equal("", resolve(ctx))
+
+	tn = newTestFilter(`
+kind: Tenant
+name: tenant
+source: subdomain
+domain: api.example.com
+`)
+	for host, expected := range map[string]string{
+		"t1.api.example.com:8080": "t1",
+		"a.T2.api.example.com":    "t2",
+		"api.example.com":         "",
+		"t1.example.org":          "",
+	} {
+		ctx = newContext(host, nil)
+		assert.Equal(expected, resolve(ctx), host)
+	}
+
+	tn = newTestFilter(`
+kind: Tenant
+name: tenant
+source: jwtClaim
+claim: org
+`)
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"org": "t3"}).SignedString([]byte("secret"))
+	ctx = newContext("127.0.0.1", http.Header{"Authorization": {"Bearer " + token}})
+	assert.Equal("t3", resolve(ctx))
+
+	ctx = newContext("127.0.0.1", http.Header{"Authorization": {"Bearer invalid"}})
+	assert.Equal("", resolve(ctx))
+}
+
+func TestLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	tn := newTestFilter(`
+kind: Tenant
+name: tenant
+tenants:
+- name: t1
+  maxConcurrency: 2
+- name: t2
+  rateLimit: 0.001
+  burst: 2
+- name: t3
+  quota: 2
+  quotaPeriod: 24h
+defaultPolicy:
+  maxConcurrency: 1
+`)
+
+	// concurrency
+	var ctxs []*context.Context
+	for i := 0; i < 2; i++ {
+		ctx := newContext("127.0.0.1", http.Header{"X-Tenant-Id": {"t1"}})
+		assert.Empty(tn.Handle(ctx))
+		ctxs = append(ctxs, ctx)
+	}
+	ctx := newContext("127.0.0.1", http.Header{"X-Tenant-Id": {"t1"}})
+	assert.Equal(resultConcurrencyExceeded, tn.Handle(ctx))
+	assert.Equal(http.StatusServiceUnavailable, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	// other tenants are not affected.
+	for _, id := range []string{"t2", "t3", "unknown"} {
+		ctx = newContext("127.0.0.1", http.Header{"X-Tenant-Id": {id}})
+		assert.Empty(tn.Handle(ctx), id)
+		ctx.Finish()
+	}
+
+	status := tn.Status().(*Status)
+	assert.Equal(int32(2), status.Tenants["t1"].Active)
+	assert.Equal(1.0, status.Tenants["t1"].Utilization)
+	assert.Equal(uint64(1), status.Tenants["t1"].ConcurrencyLimited)
+	assert.Equal(int32(0), status.Tenants[defaultTenant].Active)
+
+	ctxs[0].Finish()
+	ctx = newContext("127.0.0.1", http.Header{"X-Tenant-Id": {"t1"}})
+	assert.Empty(tn.Handle(ctx))
+
+	// rate limit
+	ctx = newContext("127.0.0.1", http.Header{"X-Tenant-Id": {"t2"}})
+	assert.Empty(tn.Handle(ctx))
+	ctx = newContext("127.0.0.1", http.Header{"X-Tenant-Id": {"t2"}})
+	assert.Equal(resultRateLimited, tn.Handle(ctx))
+	assert.Equal(http.StatusTooManyRequests, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	// quota
+	ctx = newContext("127.0.0.1", http.Header{"X-Tenant-Id": {"t3"}})
+	assert.Empty(tn.Handle(ctx))
+	ctx = newContext("127.0.0.1", http.Header{"X-Tenant-Id": {"t3"}})
+	assert.Equal(resultQuotaExceeded, tn.Handle(ctx))
+
+	status = tn.Status().(*Status)
+	assert.Equal(int64(2), status.Tenants["t3"].QuotaUsed)
+	assert.Equal(1.0, status.Tenants["t3"].QuotaUtilization)
+	assert.Equal(uint64(1), status.Tenants["t3"].QuotaExceeded)
+	assert.Equal(int32(1), status.Tenants["t3"].Active)
+
+	// unknown tenants share the default bucket.
+	ctx = newContext("127.0.0.1", http.Header{"X-Tenant-Id": {"x"}})
+	assert.Empty(tn.Handle(ctx))
+	ctx2 := newContext("127.0.0.1", http.Header{"X-Tenant-Id": {"y"}})
+	assert.Equal(resultConcurrencyExceeded, tn.Handle(ctx2))
+	assert.Equal(defaultTenant, ctx2.GetData(DataKey))
+	ctx.Finish()
+	tn.Close()
+}
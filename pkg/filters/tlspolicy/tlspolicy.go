@@ -0,0 +1,240 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tlspolicy implements a filter which enforces the TLS version and
+// cipher suite of requests.
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/prometheushelper"
+)
+
+const (
+	// Kind is the kind of TLSPolicy.
+	Kind = "TLSPolicy"
+
+	resultNotTLS      = "notTLS"
+	resultInsecureTLS = "insecureTLS"
+
+	reasonNotTLS  = "notTLS"
+	reasonVersion = "version"
+	reasonCipher  = "cipher"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "TLSPolicy rejects requests whose TLS version or cipher suite doesn't meet the policy.",
+	Results:     []string{resultNotTLS, resultInsecureTLS},
+	RequestOnly: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{MinVersion: "TLS1.2"}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &TLSPolicy{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+// versions are the names of the supported TLS versions.
+var versions = map[string]uint16{
+	"TLS1.0": tls.VersionTLS10,
+	"TLS1.1": tls.VersionTLS11,
+	"TLS1.2": tls.VersionTLS12,
+	"TLS1.3": tls.VersionTLS13,
+}
+
+type (
+	// TLSPolicy is the filter TLSPolicy.
+	TLSPolicy struct {
+		spec *Spec
+
+		minVersion   uint16
+		cipherSuites map[uint16]struct{}
+		rejected     *prometheus.CounterVec
+
+		notTLS          uint64
+		insecureVersion uint64
+		insecureCipher  uint64
+	}
+
+	// Spec is the spec of TLSPolicy.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// MinVersion is the min TLS version, like TLS1.2.
+		MinVersion string `json:"minVersion,omitempty" jsonschema:"enum=TLS1.0,enum=TLS1.1,enum=TLS1.2,enum=TLS1.3"`
+		// CipherSuites are the allowed cipher suites, all cipher suites
+		// are allowed if it is empty.
+		CipherSuites []string `json:"cipherSuites,omitempty" jsonschema:"uniqueItems=true"`
+	}
+
+	// Status is the status of TLSPolicy.
+	Status struct {
+		NotTLS          uint64 `json:"notTLS"`
+		InsecureVersion uint64 `json:"insecureVersion"`
+		InsecureCipher  uint64 `json:"insecureCipher"`
+	}
+)
+
+// cipherSuiteID returns the ID of the cipher suite.
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, s := range suites {
+			if s.Name == name {
+				return s.ID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if _, ok := versions[spec.MinVersion]; spec.MinVersion != "" && !ok {
+		return fmt.Errorf("unknown TLS version %s", spec.MinVersion)
+	}
+	for _, name := range spec.CipherSuites {
+		if _, ok := cipherSuiteID(name); !ok {
+			return fmt.Errorf("unknown cipher suite %s", name)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the TLSPolicy filter instance.
+func (p *TLSPolicy) Name() string {
+	return p.spec.Name()
+}
+
+// Kind returns the kind of TLSPolicy.
+func (p *TLSPolicy) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the TLSPolicy
+func (p *TLSPolicy) Spec() filters.Spec {
+	return p.spec
+}
+
+// Init initializes TLSPolicy.
+func (p *TLSPolicy) Init() {
+	p.reload()
+}
+
+// Inherit inherits previous generation of TLSPolicy.
+func (p *TLSPolicy) Inherit(previousGeneration filters.Filter) {
+	p.Init()
+}
+
+func (p *TLSPolicy) reload() {
+	p.minVersion = versions[p.spec.MinVersion]
+	if p.minVersion == 0 {
+		p.minVersion = tls.VersionTLS12
+	}
+
+	p.cipherSuites = nil
+	if len(p.spec.CipherSuites) > 0 {
+		p.cipherSuites = make(map[uint16]struct{}, len(p.spec.CipherSuites))
+		for _, name := range p.spec.CipherSuites {
+			id, _ := cipherSuiteID(name)
+			p.cipherSuites[id] = struct{}{}
+		}
+	}
+
+	p.rejected = p.newRejectedCounter()
+}
+
+func (p *TLSPolicy) newRejectedCounter() *prometheus.CounterVec {
+	labels := prometheus.Labels{
+		"filterName":   p.Name(),
+		"kind":         Kind,
+		"clusterName":  "",
+		"clusterRole":  "",
+		"instanceName": "",
+	}
+	if super := p.spec.Super(); super != nil && super.Options() != nil {
+		labels["clusterName"] = super.Options().ClusterName
+		labels["clusterRole"] = super.Options().ClusterRole
+		labels["instanceName"] = super.Options().Name
+	}
+	return prometheushelper.NewCounter("tlspolicy_rejected_requests",
+		"the total count of requests rejected by the TLS policy",
+		[]string{"clusterName", "clusterRole", "instanceName", "filterName", "kind", "reason"},
+	).MustCurryWith(labels)
+}
+
+func (p *TLSPolicy) reject(ctx *context.Context, result, reason string, counter *uint64) string {
+	atomic.AddUint64(counter, 1)
+	p.rejected.WithLabelValues(reason).Inc()
+
+	ctx.AddTag(fmt.Sprintf("tlsPolicy: rejected by %s", reason))
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(http.StatusForbidden)
+	ctx.SetOutputResponse(resp)
+	return result
+}
+
+// Handle checks the TLS connection state of the request.
+func (p *TLSPolicy) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	cs := req.Std().TLS
+	if cs == nil {
+		return p.reject(ctx, resultNotTLS, reasonNotTLS, &p.notTLS)
+	}
+
+	if cs.Version < p.minVersion {
+		return p.reject(ctx, resultInsecureTLS, reasonVersion, &p.insecureVersion)
+	}
+
+	if p.cipherSuites != nil {
+		if _, ok := p.cipherSuites[cs.CipherSuite]; !ok {
+			return p.reject(ctx, resultInsecureTLS, reasonCipher, &p.insecureCipher)
+		}
+	}
+
+	return ""
+}
+
+// Status returns status.
+func (p *TLSPolicy) Status() interface{} {
+	return &Status{
+		NotTLS:          atomic.LoadUint64(&p.notTLS),
+		InsecureVersion: atomic.LoadUint64(&p.insecureVersion),
+		InsecureCipher:  atomic.LoadUint64(&p.insecureCipher),
+	}
+}
+
+// Close closes TLSPolicy.
+func (p *TLSPolicy) Close() {
+}
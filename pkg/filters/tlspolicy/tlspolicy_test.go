@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestPolicy(yamlConfig string) (*TLSPolicy, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+	p := kind.CreateInstance(spec).(*TLSPolicy)
+	p.Init()
+	return p, nil
+}
+
+func newContext(cs *tls.ConnectionState) *context.Context {
+	stdReq, _ := http.NewRequest(http.MethodGet, "https://127.0.0.1/", nil)
+	stdReq.TLS = cs
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestTLSPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := newTestPolicy(`
+kind: TLSPolicy
+name: tls-policy
+minVersion: TLS1.2
+cipherSuites:
+- TLS_AES_128_GCM_SHA256
+- TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+`)
+	assert.Nil(err)
+	assert.Equal(kind, p.Kind())
+
+	cases := []struct {
+		cs     *tls.ConnectionState
+		result string
+	}{
+		{&tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256}, ""},
+		{&tls.ConnectionState{Version: tls.VersionTLS12, CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, ""},
+		{&tls.ConnectionState{Version: tls.VersionTLS11, CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, resultInsecureTLS},
+		{&tls.ConnectionState{Version: tls.VersionTLS12, CipherSuite: tls.TLS_RSA_WITH_AES_128_CBC_SHA}, resultInsecureTLS},
+		{nil, resultNotTLS},
+	}
+	for i, c := range cases {
+		ctx := newContext(c.cs)
+		assert.Equal(c.result, p.Handle(ctx), i)
+		if c.result != "" {
+			assert.Equal(http.StatusForbidden, ctx.GetOutputResponse().(*httpprot.Response).StatusCode(), i)
+		}
+	}
+
+	assert.Equal(&Status{NotTLS: 1, InsecureVersion: 1, InsecureCipher: 1}, p.Status())
+	assert.Equal(1.0, testutil.ToFloat64(p.rejected.WithLabelValues(reasonCipher)))
+
+	// TLS 1.3 only, and any cipher suite.
+	p, err = newTestPolicy("kind: TLSPolicy\nname: tls13\nminVersion: TLS1.3")
+	assert.Nil(err)
+	assert.Equal(resultInsecureTLS, p.Handle(newContext(&tls.ConnectionState{Version: tls.VersionTLS12})))
+	assert.Equal("", p.Handle(newContext(&tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_CHACHA20_POLY1305_SHA256})))
+
+	newP := kind.CreateInstance(p.spec).(*TLSPolicy)
+	newP.Inherit(p)
+	assert.Equal(uint16(tls.VersionTLS13), newP.minVersion)
+	p.Close()
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []string{"minVersion: TLS2.0", "cipherSuites: [NOT_EXIST]"} {
+		_, err := newTestPolicy("kind: TLSPolicy\nname: tls-policy\n" + c)
+		assert.NotNil(err, c)
+	}
+}
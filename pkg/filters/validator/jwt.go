@@ -66,6 +66,13 @@ type JWTValidator struct {
 
 // Validate validates the JWT token of a http request
 func (v *JWTValidator) Validate(req *httpprot.Request) error {
+	_, err := v.validate(req)
+	return err
+}
+
+// validate validates the JWT token of a http request and returns its
+// claims.
+func (v *JWTValidator) validate(req *httpprot.Request) (jwt.MapClaims, error) {
 	var token string
 
 	if v.spec.CookieName != "" {
@@ -78,7 +85,7 @@ func (v *JWTValidator) Validate(req *httpprot.Request) error {
 		const prefix = "Bearer "
 		authHdr := req.HTTPHeader().Get("Authorization")
 		if !strings.HasPrefix(authHdr, prefix) {
-			return fmt.Errorf("unexpected authorization header: %s", authHdr)
+			return nil, fmt.Errorf("unexpected authorization header: %s", authHdr)
 		}
 		token = authHdr[len(prefix):]
 	}
@@ -90,10 +97,11 @@ func (v *JWTValidator) Validate(req *httpprot.Request) error {
 		return v.key, nil
 	})
 	if e != nil {
-		return e
+		return nil, e
 	}
 	if !t.Valid {
-		return fmt.Errorf("invalid jwt token")
+		return nil, fmt.Errorf("invalid jwt token")
 	}
-	return nil
+	claims, _ := t.Claims.(jwt.MapClaims)
+	return claims, nil
 }
@@ -36,12 +36,18 @@ const (
 	Kind = "Validator"
 
 	resultInvalid = "invalid"
+
+	// JWTClaimsDataKey is the key of the claims of the validated JWT in
+	// the context data, the claims are a map[string]interface{}, filters
+	// like ClaimRouter use them after the Validator.
+	JWTClaimsDataKey = "JWT_CLAIMS"
 )
 
 var kind = &filters.Kind{
 	Name:        Kind,
 	Description: "Validator validates HTTP request.",
 	Results:     []string{resultInvalid},
+	RequestOnly: true,
 	DefaultSpec: func() filters.Spec {
 		return &Spec{}
 	},
@@ -151,10 +157,12 @@ func (v *Validator) Handle(ctx *context.Context) string {
 		}
 	}
 	if v.jwt != nil {
-		if err := v.jwt.Validate(req); err != nil {
+		claims, err := v.jwt.validate(req)
+		if err != nil {
 			prepareErrorResponse(http.StatusUnauthorized, "JWT validator: ", err)
 			return resultInvalid
 		}
+		ctx.SetData(JWTClaimsDataKey, map[string]interface{}(claims))
 	}
 	if v.signer != nil {
 		vCtx := v.signer.NewVerificationContext()
@@ -172,6 +172,8 @@ jwt:
 		if !assert.Equal(result, "") {
 			t.Errorf("the jwt token in header should be valid")
 		}
+		claims := ctx.GetData(JWTClaimsDataKey).(map[string]interface{})
+		assert.Equal("John Doe", claims["name"])
 
 		req.Header.Set("Authorization", "Bearer "+tc.jwtToken+"abc")
 		result = v.Handle(ctx)
@@ -0,0 +1,394 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package webhook implements a filter which verifies the signatures of
+// webhook requests.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of Webhook.
+	Kind = "Webhook"
+
+	resultInvalidSignature = "invalidSignature"
+
+	// SchemeHMAC verifies the HMAC of the signed payload.
+	SchemeHMAC = "hmac"
+	// SchemeToken compares the header with the secret.
+	SchemeToken = "token"
+)
+
+var kind = &filters.Kind{
+	Name:              Kind,
+	Description:       "Webhook verifies the signatures of webhook requests.",
+	Results:           []string{resultInvalidSignature},
+	RequestOnly:       true,
+	RequiresBuffering: true,
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &Webhook{spec: spec.(*Spec)}
+	},
+}
+
+// providers are the built-in profiles of the providers.
+var providers = map[string]*Profile{
+	// https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries
+	"github": {
+		Scheme:          SchemeHMAC,
+		SignatureHeader: "X-Hub-Signature-256",
+		SignaturePrefix: "sha256=",
+		Algorithm:       "sha256",
+		Encoding:        "hex",
+	},
+	// https://docs.gitlab.com/ee/user/project/integrations/webhooks.html
+	"gitlab": {
+		Scheme:          SchemeToken,
+		SignatureHeader: "X-Gitlab-Token",
+	},
+	// https://docs.stripe.com/webhooks#verify-manually
+	"stripe": {
+		Scheme:          SchemeHMAC,
+		SignatureHeader: "Stripe-Signature",
+		SignatureKey:    "v1",
+		TimestampKey:    "t",
+		Algorithm:       "sha256",
+		Encoding:        "hex",
+		SignedPayload:   "{timestamp}.{body}",
+		Tolerance:       "5m",
+	},
+	// https://api.slack.com/authentication/verifying-requests-from-slack
+	"slack": {
+		Scheme:          SchemeHMAC,
+		SignatureHeader: "X-Slack-Signature",
+		SignaturePrefix: "v0=",
+		TimestampHeader: "X-Slack-Request-Timestamp",
+		Algorithm:       "sha256",
+		Encoding:        "hex",
+		SignedPayload:   "v0:{timestamp}:{body}",
+		Tolerance:       "5m",
+	},
+}
+
+var hashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// now is replaced in tests.
+var now = time.Now
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// Webhook is the filter Webhook.
+	Webhook struct {
+		spec *Spec
+
+		profile   *Profile
+		tolerance time.Duration
+
+		verified uint64
+		rejected uint64
+	}
+
+	// Spec is the spec of Webhook.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		Provider string   `json:"provider,omitempty" jsonschema:"enum=,enum=github,enum=gitlab,enum=stripe,enum=slack"`
+		Profile  *Profile `json:"profile,omitempty"`
+		Secret   string   `json:"secret" jsonschema:"required"`
+
+		// Tolerance overrides the timestamp tolerance of the profile.
+		Tolerance string `json:"tolerance,omitempty" jsonschema:"format=duration"`
+	}
+
+	// Profile is the signature scheme of a provider.
+	Profile struct {
+		Scheme          string `json:"scheme,omitempty" jsonschema:"enum=,enum=hmac,enum=token"`
+		SignatureHeader string `json:"signatureHeader" jsonschema:"required"`
+		SignaturePrefix string `json:"signaturePrefix,omitempty"`
+
+		// SignatureKey and TimestampKey are the keys of the signature
+		// and timestamp if the header is a list of key=value pairs.
+		SignatureKey    string `json:"signatureKey,omitempty"`
+		TimestampKey    string `json:"timestampKey,omitempty"`
+		TimestampHeader string `json:"timestampHeader,omitempty"`
+
+		Algorithm string `json:"algorithm,omitempty" jsonschema:"enum=,enum=sha1,enum=sha256,enum=sha512"`
+		Encoding  string `json:"encoding,omitempty" jsonschema:"enum=,enum=hex,enum=base64"`
+
+		// SignedPayload is the payload to sign, {timestamp} and {body}
+		// are replaced by the timestamp and the body.
+		SignedPayload string `json:"signedPayload,omitempty"`
+		Tolerance     string `json:"tolerance,omitempty" jsonschema:"format=duration"`
+	}
+
+	// Status is the status of Webhook.
+	Status struct {
+		Verified uint64 `json:"verified"`
+		Rejected uint64 `json:"rejected"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	if (spec.Provider == "") == (spec.Profile == nil) {
+		return fmt.Errorf("exactly one of provider and profile must be set")
+	}
+	return nil
+}
+
+// Validate validates the profile.
+func (p *Profile) Validate() error {
+	if p.TimestampKey != "" && p.SignatureKey == "" {
+		return fmt.Errorf("timestampKey requires signatureKey")
+	}
+	if p.TimestampKey != "" && p.TimestampHeader != "" {
+		return fmt.Errorf("timestampKey and timestampHeader are exclusive")
+	}
+	hasTimestamp := p.TimestampKey != "" || p.TimestampHeader != ""
+	if strings.Contains(p.SignedPayload, "{timestamp}") && !hasTimestamp {
+		return fmt.Errorf("signedPayload refers to the timestamp, but there's no timestamp")
+	}
+	if p.Tolerance != "" && !hasTimestamp {
+		return fmt.Errorf("tolerance requires a timestamp")
+	}
+	return nil
+}
+
+// Name returns the name of the Webhook filter instance.
+func (w *Webhook) Name() string {
+	return w.spec.Name()
+}
+
+// Kind returns the kind of Webhook.
+func (w *Webhook) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the Webhook
+func (w *Webhook) Spec() filters.Spec {
+	return w.spec
+}
+
+// Init initializes Webhook.
+func (w *Webhook) Init() {
+	w.reload()
+}
+
+// Inherit inherits previous generation of Webhook.
+func (w *Webhook) Inherit(previousGeneration filters.Filter) {
+	w.reload()
+}
+
+func (w *Webhook) reload() {
+	p := w.spec.Profile
+	if p == nil {
+		p = providers[w.spec.Provider]
+	}
+
+	profile := *p
+	if profile.Scheme == "" {
+		profile.Scheme = SchemeHMAC
+	}
+	if profile.Algorithm == "" {
+		profile.Algorithm = "sha256"
+	}
+	if profile.Encoding == "" {
+		profile.Encoding = "hex"
+	}
+	if profile.SignedPayload == "" {
+		profile.SignedPayload = "{body}"
+	}
+	w.profile = &profile
+
+	tolerance := profile.Tolerance
+	if w.spec.Tolerance != "" {
+		tolerance = w.spec.Tolerance
+	}
+	w.tolerance, _ = time.ParseDuration(tolerance)
+}
+
+// parseKeyValues parses a header like "t=123,v1=abc,v1=def".
+func parseKeyValues(header string) map[string][]string {
+	result := map[string][]string{}
+	for _, kv := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if ok {
+			result[k] = append(result[k], v)
+		}
+	}
+	return result
+}
+
+// extract returns the signatures and the timestamp of the request.
+func (w *Webhook) extract(h http.Header) ([]string, string) {
+	p := w.profile
+	header := h.Get(p.SignatureHeader)
+	if header == "" {
+		return nil, ""
+	}
+
+	var signatures []string
+	var timestamp string
+	if p.SignatureKey != "" {
+		kvs := parseKeyValues(header)
+		signatures = kvs[p.SignatureKey]
+		if p.TimestampKey != "" && len(kvs[p.TimestampKey]) > 0 {
+			timestamp = kvs[p.TimestampKey][0]
+		}
+	} else {
+		signatures = []string{header}
+	}
+
+	if p.TimestampHeader != "" {
+		timestamp = h.Get(p.TimestampHeader)
+	}
+	return signatures, timestamp
+}
+
+func (w *Webhook) checkTimestamp(timestamp string) error {
+	p := w.profile
+	if p.TimestampKey == "" && p.TimestampHeader == "" {
+		return nil
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+	if w.tolerance <= 0 {
+		return nil
+	}
+
+	d := now().Sub(time.Unix(ts, 0))
+	if d < 0 {
+		d = -d
+	}
+	if d > w.tolerance {
+		return fmt.Errorf("timestamp %s is out of tolerance", timestamp)
+	}
+	return nil
+}
+
+func (w *Webhook) sign(timestamp string, body []byte) string {
+	p := w.profile
+	before, after, _ := strings.Cut(p.SignedPayload, "{body}")
+
+	mac := hmac.New(hashes[p.Algorithm], []byte(w.spec.Secret))
+	mac.Write([]byte(strings.ReplaceAll(before, "{timestamp}", timestamp)))
+	mac.Write(body)
+	mac.Write([]byte(strings.ReplaceAll(after, "{timestamp}", timestamp)))
+	sum := mac.Sum(nil)
+
+	if p.Encoding == "base64" {
+		return base64.StdEncoding.EncodeToString(sum)
+	}
+	return hex.EncodeToString(sum)
+}
+
+func (w *Webhook) verify(req *httpprot.Request) error {
+	signatures, timestamp := w.extract(req.HTTPHeader())
+	if len(signatures) == 0 {
+		return fmt.Errorf("no signature")
+	}
+
+	if w.profile.Scheme == SchemeToken {
+		if subtle.ConstantTimeCompare([]byte(signatures[0]), []byte(w.spec.Secret)) == 1 {
+			return nil
+		}
+		return fmt.Errorf("token mismatch")
+	}
+
+	if err := w.checkTimestamp(timestamp); err != nil {
+		return err
+	}
+
+	if req.IsStream() {
+		return fmt.Errorf("the body is a stream")
+	}
+
+	expected := w.sign(timestamp, req.RawPayload())
+	for _, s := range signatures {
+		s, ok := strings.CutPrefix(s, w.profile.SignaturePrefix)
+		if !ok {
+			continue
+		}
+		if w.profile.Encoding == "hex" {
+			s = strings.ToLower(s)
+		}
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature mismatch")
+}
+
+// Handle verifies the signature of the request.
+func (w *Webhook) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	if err := w.verify(req); err != nil {
+		atomic.AddUint64(&w.rejected, 1)
+		ctx.AddTag(fmt.Sprintf("webhook: %v", err))
+
+		resp, _ := httpprot.NewResponse(nil)
+		resp.SetStatusCode(http.StatusUnauthorized)
+		ctx.SetOutputResponse(resp)
+		return resultInvalidSignature
+	}
+
+	atomic.AddUint64(&w.verified, 1)
+	return ""
+}
+
+// Status returns status.
+func (w *Webhook) Status() interface{} {
+	return &Status{
+		Verified: atomic.LoadUint64(&w.verified),
+		Rejected: atomic.LoadUint64(&w.rejected),
+	}
+}
+
+// Close closes Webhook.
+func (w *Webhook) Close() {
+}
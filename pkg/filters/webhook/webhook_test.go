@@ -0,0 +1,253 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newSpec(yamlConfig string) (filters.Spec, error) {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	return filters.NewSpec(nil, "", rawSpec)
+}
+
+func newTestFilter(yamlConfig string) *Webhook {
+	spec, err := newSpec(yamlConfig)
+	if err != nil {
+		panic(err)
+	}
+	w := kind.CreateInstance(spec).(*Webhook)
+	w.Init()
+	return w
+}
+
+func newContext(body string, header map[string]string) *context.Context {
+	stdr, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/hooks", strings.NewReader(body))
+	for k, v := range header {
+		stdr.Header.Set(k, v)
+	}
+	req, _ := httpprot.NewRequest(stdr)
+	req.FetchPayload(0)
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func setNow(unix int64) func() {
+	now = func() time.Time { return time.Unix(unix, 0) }
+	return func() { now = time.Now }
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newSpec(`
+kind: Webhook
+name: webhook
+secret: abc
+`)
+	assert.Error(err)
+
+	_, err = newSpec(`
+kind: Webhook
+name: webhook
+provider: github
+secret: abc
+profile:
+  signatureHeader: X-Signature
+`)
+	assert.Error(err)
+
+	_, err = newSpec(`
+kind: Webhook
+name: webhook
+secret: abc
+profile:
+  signatureHeader: X-Signature
+  signedPayload: "{timestamp}.{body}"
+`)
+	assert.Error(err)
+}
+
+// The example of
+// https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries
+func TestGitHub(t *testing.T) {
+	assert := assert.New(t)
+
+	w := newTestFilter(`
+kind: Webhook
+name: webhook
+provider: github
+secret: "It's a Secret to Everybody"
+`)
+	assert.Equal(kind, w.Kind())
+	assert.Equal("webhook", w.Name())
+
+	sig := "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	ctx := newContext("Hello, World!", map[string]string{"X-Hub-Signature-256": sig})
+	assert.Equal("", w.Handle(ctx))
+
+	ctx = newContext("Hello, World?", map[string]string{"X-Hub-Signature-256": sig})
+	assert.Equal(resultInvalidSignature, w.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusUnauthorized, resp.StatusCode())
+
+	ctx = newContext("Hello, World!", nil)
+	assert.Equal(resultInvalidSignature, w.Handle(ctx))
+
+	ctx = newContext("Hello, World!", map[string]string{"X-Hub-Signature-256": sig[len("sha256="):]})
+	assert.Equal(resultInvalidSignature, w.Handle(ctx))
+
+	status := w.Status().(*Status)
+	assert.Equal(uint64(1), status.Verified)
+	assert.Equal(uint64(3), status.Rejected)
+}
+
+func TestGitLab(t *testing.T) {
+	assert := assert.New(t)
+
+	w := newTestFilter(`
+kind: Webhook
+name: webhook
+provider: gitlab
+secret: my-token
+`)
+	ctx := newContext(`{"object_kind":"push"}`, map[string]string{"X-Gitlab-Token": "my-token"})
+	assert.Equal("", w.Handle(ctx))
+
+	ctx = newContext(`{"object_kind":"push"}`, map[string]string{"X-Gitlab-Token": "my-token2"})
+	assert.Equal(resultInvalidSignature, w.Handle(ctx))
+}
+
+// The example of
+// https://api.slack.com/authentication/verifying-requests-from-slack
+func TestSlack(t *testing.T) {
+	assert := assert.New(t)
+
+	w := newTestFilter(`
+kind: Webhook
+name: webhook
+provider: slack
+secret: 8f742231b10e8888abcd99yyyzzz85a5
+`)
+
+	body := "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+	header := map[string]string{
+		"X-Slack-Request-Timestamp": "1531420618",
+		"X-Slack-Signature":         "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503",
+	}
+
+	defer setNow(1531420618 + 60)()
+	assert.Equal("", w.Handle(newContext(body, header)))
+
+	// replayed after the tolerance.
+	setNow(1531420618 + 600)
+	assert.Equal(resultInvalidSignature, w.Handle(newContext(body, header)))
+
+	// the tolerance could be overridden.
+	w = newTestFilter(`
+kind: Webhook
+name: webhook
+provider: slack
+secret: 8f742231b10e8888abcd99yyyzzz85a5
+tolerance: 15m
+`)
+	assert.Equal("", w.Handle(newContext(body, header)))
+
+	header["X-Slack-Request-Timestamp"] = "1531420619"
+	assert.Equal(resultInvalidSignature, w.Handle(newContext(body, header)))
+}
+
+func TestStripe(t *testing.T) {
+	assert := assert.New(t)
+
+	secret := "whsec_test_secret"
+	w := newTestFilter(`
+kind: Webhook
+name: webhook
+provider: stripe
+secret: ` + secret)
+
+	body := `{"id": "evt_test_webhook", "object": "event"}`
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("1492774577." + body))
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	defer setNow(1492774577)()
+
+	// any of the v1 signatures could match, as secrets are rolled.
+	header := map[string]string{
+		"Stripe-Signature": "t=1492774577,v1=" + strings.Repeat("0", 64) + ",v1=" + sig + ",v0=6ffbb59b2300aae63f272406069a9788598b792a944a07aba816edb039989a39",
+	}
+	assert.Equal("", w.Handle(newContext(body, header)))
+
+	header["Stripe-Signature"] = "t=1492774578,v1=" + sig
+	assert.Equal(resultInvalidSignature, w.Handle(newContext(body, header)))
+
+	header["Stripe-Signature"] = "v1=" + sig
+	assert.Equal(resultInvalidSignature, w.Handle(newContext(body, header)))
+
+	setNow(1492774577 + 301)
+	header["Stripe-Signature"] = "t=1492774577,v1=" + sig
+	assert.Equal(resultInvalidSignature, w.Handle(newContext(body, header)))
+}
+
+func TestCustomProfile(t *testing.T) {
+	assert := assert.New(t)
+
+	w := newTestFilter(`
+kind: Webhook
+name: webhook
+secret: custom
+profile:
+  signatureHeader: X-Signature
+  timestampHeader: X-Timestamp
+  algorithm: sha512
+  encoding: base64
+  signedPayload: "{body}|{timestamp}"
+`)
+
+	// generated by: printf 'hello|1700000000' | openssl dgst -sha512 -hmac custom -binary | base64
+	sig := "cuj4t+9jenfhXPiI949tuh3DeIKYp48+gCc9rqZqVeteUVuuUboz0AYh1GdWSRoK7quJduqecQD2k5zwd5X4Qg=="
+	header := map[string]string{"X-Signature": sig, "X-Timestamp": "1700000000"}
+	assert.Equal("", w.Handle(newContext("hello", header)))
+
+	header["X-Timestamp"] = "abc"
+	assert.Equal(resultInvalidSignature, w.Handle(newContext("hello", header)))
+
+	w.Inherit(w)
+	w.Close()
+}
@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultAccessLogMaxBodySize = 4096
+
+	redactedValue = "***"
+)
+
+type (
+	// AccessLogBodySpec is the spec to log the response bodies in the
+	// access log, which are sampled by the rules. Response bodies may carry
+	// credentials or personal data, which should be masked by RedactFields
+	// and RedactPatterns.
+	AccessLogBodySpec struct {
+		// MaxBodySize is the max size of a body in the log, the body is
+		// truncated if it exceeds the size.
+		MaxBodySize int `json:"maxBodySize,omitempty" jsonschema:"minimum=0"`
+		// Rules are checked in order, and the first matched one decides
+		// whether to log the body, bodies matching no rule are not logged.
+		Rules []*BodySamplingRule `json:"rules" jsonschema:"required"`
+		// RedactFields are the names of the JSON fields whose values are
+		// masked, at any depth of the body.
+		RedactFields []string `json:"redactFields,omitempty"`
+		// RedactPatterns are regular expressions, the matched text of the
+		// body is masked.
+		RedactPatterns []string `json:"redactPatterns,omitempty"`
+	}
+
+	// BodySamplingRule is a rule to sample the response bodies.
+	BodySamplingRule struct {
+		// Status is a status code like 404, or a status class like 5xx,
+		// the rule matches all status codes if it is empty.
+		Status string `json:"status,omitempty"`
+		// MinSize is the min size of the response body to match the rule.
+		MinSize int64 `json:"minSize,omitempty" jsonschema:"minimum=0"`
+		// SampleRate is the ratio of the bodies to log, in [0, 1].
+		SampleRate float64 `json:"sampleRate" jsonschema:"required"`
+	}
+
+	// bodySampler decides whether to log the response bodies.
+	bodySampler struct {
+		maxBodySize int
+		rules       []*BodySamplingRule
+		fields      *regexp.Regexp
+		patterns    []*regexp.Regexp
+	}
+
+	// bodyCapture keeps the first maxSize bytes of the response body, and
+	// counts the size of the whole body.
+	bodyCapture struct {
+		maxSize int
+		data    []byte
+		size    int64
+	}
+)
+
+// Validate validates the AccessLogBodySpec.
+func (spec *AccessLogBodySpec) Validate() error {
+	for _, r := range spec.Rules {
+		if _, _, err := parseStatus(r.Status); err != nil {
+			return err
+		}
+		if r.SampleRate < 0 || r.SampleRate > 1 {
+			return fmt.Errorf("invalid sample rate %v, must be in [0, 1]", r.SampleRate)
+		}
+	}
+	for _, p := range spec.RedactPatterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid redact pattern %q: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// redactFieldsRegexp returns the regular expression matching the values of
+// the JSON fields, strings and scalars are matched, and so are the strings
+// cut by the truncation.
+func redactFieldsRegexp(fields []string) *regexp.Regexp {
+	if len(fields) == 0 {
+		return nil
+	}
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = regexp.QuoteMeta(f)
+	}
+	return regexp.MustCompile(`("(?:` + strings.Join(names, "|") + `)"\s*:\s*)(?:"(?:[^"\\]|\\.)*"?|[^\s,}\]]+)`)
+}
+
+// parseStatus parses a status code or a status class to a range [min, max].
+func parseStatus(status string) (int, int, error) {
+	if status == "" {
+		return 0, 999, nil
+	}
+
+	if len(status) == 3 && strings.HasSuffix(strings.ToLower(status), "xx") {
+		if c := status[0]; c >= '1' && c <= '5' {
+			min := int(c-'0') * 100
+			return min, min + 99, nil
+		}
+	} else if code, err := strconv.Atoi(status); err == nil && code >= 100 && code <= 599 {
+		return code, code, nil
+	}
+
+	return 0, 0, fmt.Errorf("invalid status %q, must be a status code or a status class like 5xx", status)
+}
+
+func (r *BodySamplingRule) matchStatus(statusCode int) bool {
+	min, max, _ := parseStatus(r.Status)
+	return statusCode >= min && statusCode <= max
+}
+
+func newBodySampler(spec *AccessLogBodySpec) *bodySampler {
+	if spec == nil {
+		return nil
+	}
+	bs := &bodySampler{
+		maxBodySize: spec.MaxBodySize,
+		rules:       spec.Rules,
+		fields:      redactFieldsRegexp(spec.RedactFields),
+	}
+	for _, p := range spec.RedactPatterns {
+		bs.patterns = append(bs.patterns, regexp.MustCompile(p))
+	}
+	if bs.maxBodySize <= 0 {
+		bs.maxBodySize = defaultAccessLogMaxBodySize
+	}
+	return bs
+}
+
+// newCapture returns a bodyCapture if the body of the response may be
+// logged, it returns nil if no rule could match the status code.
+func (bs *bodySampler) newCapture(statusCode int) *bodyCapture {
+	for _, r := range bs.rules {
+		if r.SampleRate > 0 && r.matchStatus(statusCode) {
+			return &bodyCapture{maxSize: bs.maxBodySize}
+		}
+	}
+	return nil
+}
+
+// sample returns the captured body to log, or an empty string if the body
+// is not sampled.
+func (bs *bodySampler) sample(bc *bodyCapture, statusCode int) string {
+	if bc == nil {
+		return ""
+	}
+
+	for _, r := range bs.rules {
+		if !r.matchStatus(statusCode) || bc.size < r.MinSize {
+			continue
+		}
+		if r.SampleRate < 1 && rand.Float64() >= r.SampleRate {
+			return ""
+		}
+		return bc.string(bs.redact(bc.data))
+	}
+
+	return ""
+}
+
+// redact masks the sensitive data of the body.
+func (bs *bodySampler) redact(data []byte) []byte {
+	if bs.fields != nil {
+		data = bs.fields.ReplaceAll(data, []byte(`${1}"`+redactedValue+`"`))
+	}
+	for _, p := range bs.patterns {
+		data = p.ReplaceAllLiteral(data, []byte(redactedValue))
+	}
+	return data
+}
+
+// Write implements io.Writer.
+func (bc *bodyCapture) Write(p []byte) (int, error) {
+	bc.size += int64(len(p))
+	if n := bc.maxSize - len(bc.data); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		bc.data = append(bc.data, p[:n]...)
+	}
+	return len(p), nil
+}
+
+// String returns the quoted body, so that it is always in one line.
+func (bc *bodyCapture) String() string {
+	return bc.string(bc.data)
+}
+
+// string returns the quoted data, with the size of the truncated part of
+// the body.
+func (bc *bodyCapture) string(data []byte) string {
+	s := strconv.Quote(string(data))
+	if truncated := bc.size - int64(len(bc.data)); truncated > 0 {
+		s += fmt.Sprintf("...(%d bytes truncated)", truncated)
+	}
+	return s
+}
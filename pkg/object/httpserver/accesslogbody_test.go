@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLogBodySpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, status := range []string{"", "5xx", "2XX", "404"} {
+		spec := &AccessLogBodySpec{Rules: []*BodySamplingRule{{Status: status, SampleRate: 1}}}
+		assert.NoError(spec.Validate(), status)
+	}
+	for _, status := range []string{"6xx", "x5x", "600", "abc"} {
+		spec := &AccessLogBodySpec{Rules: []*BodySamplingRule{{Status: status, SampleRate: 1}}}
+		assert.Error(spec.Validate(), status)
+	}
+
+	spec := &AccessLogBodySpec{Rules: []*BodySamplingRule{{SampleRate: 1.5}}}
+	assert.Error(spec.Validate())
+	assert.Error((&Spec{AccessLogBody: spec}).Validate())
+
+	spec = &AccessLogBodySpec{Rules: []*BodySamplingRule{{SampleRate: 1}}, RedactPatterns: []string{"("}}
+	assert.Error(spec.Validate())
+}
+
+func TestBodySampler(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(newBodySampler(nil))
+
+	bs := newBodySampler(&AccessLogBodySpec{
+		MaxBodySize: 8,
+		Rules: []*BodySamplingRule{
+			{Status: "5xx", SampleRate: 1},
+			{Status: "404", SampleRate: 0},
+			{Status: "2xx", MinSize: 10, SampleRate: 1},
+		},
+	})
+
+	capture := func(statusCode int, body string) *bodyCapture {
+		bc := bs.newCapture(statusCode)
+		if bc != nil {
+			for _, s := range strings.SplitAfter(body, "\n") {
+				bc.Write([]byte(s))
+			}
+		}
+		return bc
+	}
+
+	// errors are always logged, and the body is truncated.
+	bc := capture(503, "error\nmessage")
+	assert.Equal(`"error\nme"...(5 bytes truncated)`, bs.sample(bc, 503))
+
+	// no rule matches or the sample rate is 0, no need to capture.
+	assert.Nil(capture(302, "found"))
+	assert.Nil(capture(404, "not found"))
+	assert.Equal("", bs.sample(nil, 404))
+
+	// small bodies are not logged.
+	bc = capture(200, "ok")
+	assert.NotNil(bc)
+	assert.Equal("", bs.sample(bc, 200))
+	bc = capture(200, "0123456789")
+	assert.Equal(`"01234567"...(2 bytes truncated)`, bs.sample(bc, 200))
+	bc = capture(201, "01234567")
+	assert.Equal("", bs.sample(bc, 201))
+
+	// sampling.
+	bs = newBodySampler(&AccessLogBodySpec{
+		Rules: []*BodySamplingRule{{SampleRate: 0.5}},
+	})
+	assert.Equal(defaultAccessLogMaxBodySize, bs.maxBodySize)
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		if bs.sample(capture(200, "body"), 200) != "" {
+			sampled++
+		}
+	}
+	assert.Greater(sampled, 300)
+	assert.Less(sampled, 700)
+}
+
+func TestBodySamplerRedact(t *testing.T) {
+	assert := assert.New(t)
+
+	bs := newBodySampler(&AccessLogBodySpec{
+		MaxBodySize:    80,
+		Rules:          []*BodySamplingRule{{SampleRate: 1}},
+		RedactFields:   []string{"token", "password"},
+		RedactPatterns: []string{`\d{4}-\d{4}-\d{4}-\d{4}`},
+	})
+
+	bc := bs.newCapture(200)
+	bc.Write([]byte(`{"user":{"password": "p\"w"},"token":123,"card":"1234-5678-9012-3456"}`))
+	assert.Equal(`"{\"user\":{\"password\": \"***\"},\"token\":\"***\",\"card\":\"***\"}"`, bs.sample(bc, 200))
+
+	// the value cut by the truncation is masked too.
+	bc = bs.newCapture(200)
+	bc.Write([]byte(`{"user":"alice","token":"` + strings.Repeat("x", 100) + `"}`))
+	assert.Equal(`"{\"user\":\"alice\",\"token\":\"***\""...(47 bytes truncated)`, bs.sample(bc, 200))
+}
+
+func TestAccessLogRespBody(t *testing.T) {
+	assert := assert.New(t)
+
+	log := &accessLog{Method: "GET", RespBody: `"body"`}
+	formatter := newAccessLogFormatter("{{Method}}", true)
+	assert.Equal(`GET ["body"]`, formatter.format(log))
+	formatter = newAccessLogFormatter("{{Method}} {{RespBody}}", true)
+	assert.Equal(`GET "body"`, formatter.format(log))
+}
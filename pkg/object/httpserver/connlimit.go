@@ -0,0 +1,186 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// ConnectionsPerIPSpec limits the concurrent connections of a client IP.
+	ConnectionsPerIPSpec struct {
+		Max uint32 `json:"max" jsonschema:"required,minimum=1"`
+		// TrustedProxies are the IPs or CIDRs of the proxies in front of
+		// the server, their connections carry requests of many clients,
+		// so they are not limited.
+		TrustedProxies []string `json:"trustedProxies,omitempty"`
+	}
+
+	// connLimiter limits the concurrent connections of every client IP,
+	// its config could be updated without restarting the server.
+	connLimiter struct {
+		config   atomic.Pointer[connLimitConfig]
+		onReject func()
+
+		mu    sync.Mutex
+		conns map[string]uint32
+	}
+
+	connLimitConfig struct {
+		max            uint32
+		trustedProxies []*net.IPNet
+	}
+
+	// connLimitListener refuses the connections exceeding the limit of
+	// their client IPs.
+	connLimitListener struct {
+		net.Listener
+		limiter *connLimiter
+	}
+
+	connLimitConn struct {
+		net.Conn
+		ip          string
+		limiter     *connLimiter
+		releaseOnce sync.Once
+	}
+)
+
+// Validate validates ConnectionsPerIPSpec.
+func (spec *ConnectionsPerIPSpec) Validate() error {
+	_, err := spec.trustedProxies()
+	return err
+}
+
+func (spec *ConnectionsPerIPSpec) trustedProxies() ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, s := range spec.TrustedProxies {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %s", s)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s: %v", s, err)
+		}
+		result = append(result, ipNet)
+	}
+	return result, nil
+}
+
+func newConnLimiter(onReject func()) *connLimiter {
+	return &connLimiter{
+		onReject: onReject,
+		conns:    map[string]uint32{},
+	}
+}
+
+// update updates the config of the limiter, the limit is disabled if spec
+// is nil.
+func (l *connLimiter) update(spec *ConnectionsPerIPSpec) {
+	if spec == nil {
+		l.config.Store(nil)
+		return
+	}
+	// the spec is validated, so there's no error.
+	trustedProxies, _ := spec.trustedProxies()
+	l.config.Store(&connLimitConfig{max: spec.Max, trustedProxies: trustedProxies})
+}
+
+// acquire returns whether a new connection of ip is allowed, and whether it
+// is counted.
+func (l *connLimiter) acquire(ip string) (allowed bool, counted bool) {
+	config := l.config.Load()
+	if config == nil || ip == "" {
+		return true, false
+	}
+
+	if parsed := net.ParseIP(ip); parsed != nil {
+		for _, n := range config.trustedProxies {
+			if n.Contains(parsed) {
+				return true, false
+			}
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip] >= config.max {
+		return false, false
+	}
+	l.conns[ip]++
+	return true, true
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n := l.conns[ip]; n <= 1 {
+		delete(l.conns, ip)
+	} else {
+		l.conns[ip] = n - 1
+	}
+}
+
+// Accept implements net.Listener.
+func (l *connLimitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+		allowed, counted := l.limiter.acquire(ip)
+		if !allowed {
+			// refuse the connection and wait for the next one, returning
+			// an error stops the HTTP server.
+			c.Close()
+			if l.limiter.onReject != nil {
+				l.limiter.onReject()
+			}
+			continue
+		}
+		if !counted {
+			return c, nil
+		}
+		return &connLimitConn{Conn: c, ip: ip, limiter: l.limiter}, nil
+	}
+}
+
+// Close implements net.Conn.
+func (c *connLimitConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(func() {
+		c.limiter.release(c.ip)
+	})
+	return err
+}
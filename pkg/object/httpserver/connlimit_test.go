@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnLimitListener(t *testing.T) {
+	assert := assert.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+
+	var rejected int32
+	limiter := newConnLimiter(func() { atomic.AddInt32(&rejected, 1) })
+	limiter.update(&ConnectionsPerIPSpec{Max: 1})
+	cl := &connLimitListener{Listener: l, limiter: limiter}
+	defer cl.Close()
+
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			c, err := cl.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", l.Addr().String())
+		assert.Nil(err)
+		return c
+	}
+	// isRefused returns whether the connection is closed by the server.
+	isRefused := func(c net.Conn) bool {
+		c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err := c.Read(make([]byte, 1))
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return false
+		}
+		return true
+	}
+
+	c1 := dial()
+	defer c1.Close()
+	s1 := <-accepted
+
+	c2 := dial()
+	defer c2.Close()
+	assert.True(isRefused(c2))
+	assert.Equal(int32(1), atomic.LoadInt32(&rejected))
+
+	// the connection is allowed after the previous one is closed.
+	s1.Close()
+	assert.Eventually(func() bool {
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		return len(limiter.conns) == 0
+	}, time.Second, 10*time.Millisecond)
+	c3 := dial()
+	defer c3.Close()
+	s3 := <-accepted
+	defer s3.Close()
+	assert.False(isRefused(c3))
+
+	// connections of trusted proxies are not limited.
+	limiter.update(&ConnectionsPerIPSpec{Max: 1, TrustedProxies: []string{"127.0.0.0/8"}})
+	c4 := dial()
+	defer c4.Close()
+	s4 := <-accepted
+	defer s4.Close()
+	assert.False(isRefused(c4))
+
+	// the limit is disabled.
+	limiter.update(nil)
+	allowed, counted := limiter.acquire("10.0.0.1")
+	assert.True(allowed)
+	assert.False(counted)
+}
+
+func TestConnectionsPerIPSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &ConnectionsPerIPSpec{Max: 1, TrustedProxies: []string{"10.0.0.1", "192.168.0.0/16", "::1"}}
+	assert.Nil(spec.Validate())
+	nets, _ := spec.trustedProxies()
+	assert.True(nets[0].Contains(net.ParseIP("10.0.0.1")))
+	assert.False(nets[0].Contains(net.ParseIP("10.0.0.2")))
+	assert.True(nets[2].Contains(net.ParseIP("::1")))
+
+	spec.TrustedProxies = []string{"10.0.0"}
+	assert.NotNil(spec.Validate())
+
+	s := &Spec{HTTP3: true, HTTPS: true, ConnectionsPerIP: &ConnectionsPerIPSpec{Max: 1}}
+	assert.NotNil(s.Validate())
+}
@@ -0,0 +1,527 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// SmugglingDefenseStrict rejects all requests with ambiguous framing.
+	SmugglingDefenseStrict = "strict"
+	// SmugglingDefenseLenient rejects requests whose framing is invalid
+	// or may be interpreted differently by Easegress and other servers,
+	// but tolerates ambiguities resolved by RFC 9112, for example, the
+	// Transfer-Encoding overrides the Content-Length.
+	SmugglingDefenseLenient = "lenient"
+
+	reasonDuplicateContentLength = "duplicateContentLength"
+	reasonInvalidContentLength   = "invalidContentLength"
+	reasonContentLengthWithTE    = "contentLengthWithTransferEncoding"
+	reasonInvalidTE              = "invalidTransferEncoding"
+	reasonInvalidHeader          = "invalidHeader"
+	reasonInvalidRequestLine     = "invalidRequestLine"
+	reasonHeadTooLarge           = "headTooLarge"
+	reasonInvalidChunk           = "invalidChunk"
+
+	// maxHeadSize is the same as the limit of the standard library, heads
+	// and trailers exceeding it can't be checked, so they are rejected.
+	maxHeadSize = http.DefaultMaxHeaderBytes + 4096
+	// maxChunkLineSize is the max size of a chunk size line.
+	maxChunkLineSize = 4096
+)
+
+const (
+	stateHead = iota
+	stateBody
+	stateChunkSize
+	stateChunkData
+	stateChunkDataEnd
+	stateTrailer
+	statePassthrough
+)
+
+// states of the protocol switching of a connection.
+const (
+	switchNone int32 = iota
+	// switchUpgrade is waiting for the response of an Upgrade request.
+	switchUpgrade
+	// switchConnect is waiting for the response of a CONNECT request.
+	switchConnect
+	// switchDone means the server has agreed to take over the connection.
+	switchDone
+)
+
+// statusLineSize is the size of "HTTP/1.1 101".
+const statusLineSize = 12
+
+type (
+	// framingError is the error of a request with ambiguous framing. The
+	// HTTP server replies 400 to it if it is returned when the server is
+	// reading the request head.
+	framingError struct {
+		reason string
+		detail string
+	}
+
+	// framingListener wraps the connections accepted by a listener to
+	// inspect the framing of HTTP/1.x requests.
+	framingListener struct {
+		net.Listener
+		strict   bool
+		onReject func(reason string)
+	}
+
+	// framingConn inspects the framing of the requests read from the
+	// connection. The head of a request is held until it is complete and
+	// validated, so a request with ambiguous framing never reaches the
+	// HTTP server, and the body is tracked to find the next head. The
+	// connection is passed through only after the server agrees to switch
+	// the protocol by a 101 response to an Upgrade request or a 2xx
+	// response to a CONNECT request.
+	framingConn struct {
+		net.Conn
+		strict   bool
+		onReject func(reason string)
+
+		state   int
+		remain  uint64
+		scanned int
+		in      []byte
+		out     []byte
+		err     error
+		buf     []byte
+
+		// switching is updated by the reader when a request may switch
+		// the protocol, and by the writer when the response is written.
+		switching atomic.Int32
+		// status is the head of the status line being written.
+		status []byte
+	}
+)
+
+func (e *framingError) Error() string {
+	return fmt.Sprintf("http: ambiguous request framing: %s", e.detail)
+}
+
+func newFramingListener(l net.Listener, mode string, onReject func(reason string)) net.Listener {
+	return &framingListener{
+		Listener: l,
+		strict:   mode == SmugglingDefenseStrict,
+		onReject: onReject,
+	}
+}
+
+// Accept implements net.Listener.
+func (l *framingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &framingConn{Conn: c, strict: l.strict, onReject: l.onReject}, nil
+}
+
+// Read implements net.Conn.
+func (c *framingConn) Read(p []byte) (int, error) {
+	if c.state != statePassthrough && c.switching.Load() == switchDone {
+		// the input after the switching request belongs to the new
+		// protocol.
+		c.state = statePassthrough
+		c.release(len(c.in))
+	}
+
+	if len(c.out) == 0 && len(c.in) == 0 && c.err == nil {
+		// read directly to p to avoid copying bodies.
+		switch c.state {
+		case statePassthrough:
+			return c.Conn.Read(p)
+		case stateBody:
+			if uint64(len(p)) > c.remain {
+				p = p[:c.remain]
+			}
+			n, err := c.Conn.Read(p)
+			c.remain -= uint64(n)
+			if c.remain == 0 {
+				c.state = stateHead
+			}
+			return n, err
+		}
+	}
+
+	for len(c.out) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+
+		if c.buf == nil {
+			c.buf = make([]byte, 4096)
+		}
+		n, err := c.Conn.Read(c.buf)
+		c.in = append(c.in, c.buf[:n]...)
+
+		if ferr := c.inspect(); ferr != nil {
+			c.err = ferr
+			c.in, c.out = nil, nil
+			if c.onReject != nil {
+				c.onReject(ferr.reason)
+			}
+		} else if isTimeout(err) {
+			// the deadline may be reset, e.g. the server aborts its
+			// background read by a deadline in the past, so it is not
+			// kept like other errors.
+			if len(c.out) == 0 {
+				return 0, err
+			}
+		} else if err != nil {
+			// release the remaining bytes, the HTTP server handles the
+			// incomplete request.
+			c.out = append(c.out, c.in...)
+			c.in = nil
+			c.err = err
+		}
+	}
+
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	if len(c.out) == 0 {
+		c.out = nil
+	}
+	return n, nil
+}
+
+// Write implements net.Conn.
+func (c *framingConn) Write(p []byte) (int, error) {
+	if s := c.switching.Load(); s == switchUpgrade || s == switchConnect {
+		c.checkStatus(s, p)
+	}
+	return c.Conn.Write(p)
+}
+
+// checkStatus checks the status of the response to a request which may
+// switch the protocol.
+func (c *framingConn) checkStatus(switching int32, p []byte) {
+	need := statusLineSize - len(c.status)
+	if len(p) < need {
+		c.status = append(c.status, p...)
+		return
+	}
+	status := append(c.status, p[:need]...)
+	c.status = nil
+	if !bytes.HasPrefix(status, []byte("HTTP/1.")) {
+		return
+	}
+
+	code := string(status[statusLineSize-3:])
+	switch {
+	case code == "101" && switching == switchUpgrade:
+		c.switching.Store(switchDone)
+	case code[0] == '2' && switching == switchConnect:
+		c.switching.Store(switchDone)
+	case code[0] == '1':
+		// an interim response, wait for the final one.
+	default:
+		c.switching.Store(switchNone)
+	}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// release releases the first n bytes of the input to the HTTP server.
+func (c *framingConn) release(n int) {
+	c.out = append(c.out, c.in[:n]...)
+	c.in = c.in[n:]
+	c.scanned = 0
+}
+
+// line returns the first line of the input without the line ending, and
+// the size of the line including the line ending.
+func (c *framingConn) line() (line []byte, size int, err *framingError) {
+	idx := bytes.IndexByte(c.in[c.scanned:], '\n')
+	if idx < 0 {
+		c.scanned = len(c.in)
+		return nil, 0, nil
+	}
+	idx += c.scanned
+	line = c.in[:idx]
+	if l := len(line); l > 0 && line[l-1] == '\r' {
+		line = line[:l-1]
+	} else if c.strict {
+		return nil, 0, &framingError{reasonInvalidChunk, "bare LF in chunked body"}
+	}
+	return line, idx + 1, nil
+}
+
+// inspect inspects the input and releases the validated bytes.
+func (c *framingConn) inspect() *framingError {
+	for len(c.in) > 0 {
+		switch c.state {
+		case stateHead:
+			// the head is searched from where the last search stopped,
+			// so a large head is not scanned again on every read.
+			from := c.scanned - 2
+			if from < 0 {
+				from = 0
+			}
+			end := bytes.Index(c.in[from:], []byte("\n\r\n"))
+			if idx := bytes.Index(c.in[from:], []byte("\n\n")); idx >= 0 && (end < 0 || idx < end) {
+				end = idx
+			}
+			if end < 0 {
+				c.scanned = len(c.in)
+				if len(c.in) > maxHeadSize {
+					return &framingError{reasonHeadTooLarge, "request head too large"}
+				}
+				return nil
+			}
+			// end is the index of the last byte of the head.
+			end += from
+			if c.in[end+1] == '\r' {
+				end += 2
+			} else {
+				end++
+			}
+			if err := c.checkHead(c.in[:end+1]); err != nil {
+				return err
+			}
+			c.release(end + 1)
+
+		case stateBody, stateChunkData:
+			n := uint64(len(c.in))
+			if n > c.remain {
+				n = c.remain
+			}
+			c.release(int(n))
+			c.remain -= n
+			if c.remain == 0 {
+				if c.state == stateBody {
+					c.state = stateHead
+				} else {
+					c.state = stateChunkDataEnd
+				}
+			}
+
+		case stateChunkSize:
+			line, size, err := c.line()
+			if err != nil {
+				return err
+			}
+			if size == 0 {
+				if len(c.in) > maxChunkLineSize {
+					return &framingError{reasonInvalidChunk, "chunk size line too long"}
+				}
+				return nil
+			}
+			n, err := c.parseChunkSize(line)
+			if err != nil {
+				return err
+			}
+			c.release(size)
+			if n == 0 {
+				c.state = stateTrailer
+			} else {
+				c.remain, c.state = n, stateChunkData
+			}
+
+		case stateChunkDataEnd:
+			if c.in[0] == '\n' && !c.strict {
+				c.release(1)
+				c.state = stateChunkSize
+				continue
+			}
+			if len(c.in) < 2 {
+				return nil
+			}
+			if c.in[0] != '\r' || c.in[1] != '\n' {
+				return &framingError{reasonInvalidChunk, "missing CRLF after chunk data"}
+			}
+			c.release(2)
+			c.state = stateChunkSize
+
+		case stateTrailer:
+			line, size, err := c.line()
+			if err != nil {
+				return err
+			}
+			if size == 0 {
+				if len(c.in) > maxHeadSize {
+					return &framingError{reasonHeadTooLarge, "trailer too large"}
+				}
+				return nil
+			}
+			c.release(size)
+			if len(line) == 0 {
+				c.state = stateHead
+			}
+
+		case statePassthrough:
+			c.release(len(c.in))
+		}
+	}
+
+	return nil
+}
+
+func (c *framingConn) parseChunkSize(line []byte) (uint64, *framingError) {
+	if idx := bytes.IndexByte(line, ';'); idx >= 0 {
+		line = line[:idx]
+	}
+	if !c.strict {
+		line = bytes.TrimRight(line, " \t")
+	}
+	if len(line) == 0 || len(line) > 16 {
+		return 0, &framingError{reasonInvalidChunk, "invalid chunk size"}
+	}
+
+	var n uint64
+	for _, b := range line {
+		switch {
+		case b >= '0' && b <= '9':
+			b -= '0'
+		case b >= 'a' && b <= 'f':
+			b -= 'a' - 10
+		case b >= 'A' && b <= 'F':
+			b -= 'A' - 10
+		default:
+			return 0, &framingError{reasonInvalidChunk, "invalid chunk size"}
+		}
+		n = n<<4 | uint64(b)
+	}
+	return n, nil
+}
+
+// checkHead checks the framing of the request head, and decides the state
+// after the head.
+func (c *framingConn) checkHead(head []byte) *framingError {
+	lines := strings.Split(string(head), "\n")
+	// the last two are the empty line and the empty string after it.
+	lines = lines[:len(lines)-2]
+
+	for i, line := range lines {
+		if strings.HasSuffix(line, "\r") {
+			lines[i] = line[:len(line)-1]
+		} else if c.strict {
+			return &framingError{reasonInvalidHeader, "bare LF in request head"}
+		}
+	}
+
+	// the HTTP server rejects malformed requests and closes the
+	// connection, so they have no body, but they are rejected here in
+	// strict mode, as servers behind may read them differently.
+	c.state = stateHead
+	fields := strings.Split(lines[0], " ")
+	if len(fields) != 3 || !strings.HasPrefix(fields[2], "HTTP/1.") {
+		if c.strict {
+			return &framingError{reasonInvalidRequestLine, "malformed request line"}
+		}
+		return nil
+	}
+	method, proto := fields[0], fields[2]
+
+	var contentLengths, transferEncodings []string
+	upgrade := false
+	for i := 1; i < len(lines); i++ {
+		line := lines[i]
+		if line == "" {
+			continue
+		}
+
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 {
+			if line[0] == ' ' || line[0] == '\t' {
+				return &framingError{reasonInvalidHeader, "obsolete line folding"}
+			}
+			if c.strict {
+				return &framingError{reasonInvalidHeader, "malformed header line"}
+			}
+			return nil
+		}
+		name, value := line[:colon], line[colon+1:]
+		if strings.ContainsAny(name, " \t") {
+			return &framingError{reasonInvalidHeader, "whitespace in header name"}
+		}
+
+		// obsolete line folding, which is joined by the HTTP server.
+		for i+1 < len(lines) && lines[i+1] != "" && (lines[i+1][0] == ' ' || lines[i+1][0] == '\t') {
+			if c.strict {
+				return &framingError{reasonInvalidHeader, "obsolete line folding"}
+			}
+			i++
+			value += " " + lines[i]
+		}
+		value = strings.Trim(value, " \t")
+
+		switch strings.ToLower(name) {
+		case "content-length":
+			contentLengths = append(contentLengths, value)
+		case "transfer-encoding":
+			transferEncodings = append(transferEncodings, value)
+		case "upgrade":
+			upgrade = true
+		}
+	}
+
+	var contentLength int64
+	for i, cl := range contentLengths {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 || cl[0] == '+' {
+			return &framingError{reasonInvalidContentLength, "invalid Content-Length"}
+		}
+		if i > 0 && (c.strict || cl != contentLengths[0]) {
+			return &framingError{reasonDuplicateContentLength, "multiple Content-Length"}
+		}
+		contentLength = n
+	}
+
+	// the connection is passed through after the server agrees to switch
+	// the protocol, the requests are checked until then.
+	switch {
+	case method == http.MethodConnect:
+		c.switching.Store(switchConnect)
+	case upgrade:
+		c.switching.Store(switchUpgrade)
+	}
+
+	switch {
+	case len(transferEncodings) > 0:
+		if proto == "HTTP/1.0" {
+			return &framingError{reasonInvalidTE, "Transfer-Encoding in HTTP/1.0"}
+		}
+		// chunked is the only transfer coding supported by the server.
+		if len(transferEncodings) > 1 || !strings.EqualFold(transferEncodings[0], "chunked") {
+			return &framingError{reasonInvalidTE, "unsupported Transfer-Encoding"}
+		}
+		if len(contentLengths) > 0 && c.strict {
+			return &framingError{reasonContentLengthWithTE, "both Content-Length and Transfer-Encoding"}
+		}
+		c.state = stateChunkSize
+	case contentLength > 0:
+		c.state, c.remain = stateBody, uint64(contentLength)
+	}
+
+	return nil
+}
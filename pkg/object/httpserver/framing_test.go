@@ -0,0 +1,366 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// payloadConn is a net.Conn which reads the payload in small pieces, to
+// test the requests split into multiple reads.
+type payloadConn struct {
+	net.Conn
+	r *bytes.Reader
+}
+
+func (c *payloadConn) Read(p []byte) (int, error) {
+	if len(p) > 7 {
+		p = p[:7]
+	}
+	return c.r.Read(p)
+}
+
+func inspectPayload(payload string, mode string) (string, string) {
+	reason := ""
+	c := &framingConn{
+		Conn:     &payloadConn{r: bytes.NewReader([]byte(payload))},
+		strict:   mode == SmugglingDefenseStrict,
+		onReject: func(r string) { reason = r },
+	}
+	data, _ := io.ReadAll(c)
+	return string(data), reason
+}
+
+func TestFramingCorpus(t *testing.T) {
+	assert := assert.New(t)
+
+	const head = "POST / HTTP/1.1\r\nHost: example.com\r\n"
+
+	cases := []struct {
+		name    string
+		payload string
+		strict  string
+		lenient string
+	}{
+		{
+			name:    "CL.TE",
+			payload: head + "Content-Length: 13\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nSMUGGLED",
+			strict:  reasonContentLengthWithTE,
+		},
+		{
+			name:    "TE.CL",
+			payload: head + "Transfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n8\r\nSMUGGLED\r\n0\r\n\r\n",
+			strict:  reasonContentLengthWithTE,
+		},
+		{
+			name:    "different Content-Length",
+			payload: head + "Content-Length: 0\r\nContent-Length: 5\r\n\r\nGPOST",
+			strict:  reasonDuplicateContentLength,
+			lenient: reasonDuplicateContentLength,
+		},
+		{
+			name:    "same Content-Length",
+			payload: head + "Content-Length: 5\r\nContent-Length: 5\r\n\r\nhello",
+			strict:  reasonDuplicateContentLength,
+		},
+		{
+			name:    "Content-Length list",
+			payload: head + "Content-Length: 5, 5\r\n\r\nhello",
+			strict:  reasonInvalidContentLength,
+			lenient: reasonInvalidContentLength,
+		},
+		{
+			name:    "signed Content-Length",
+			payload: head + "Content-Length: +5\r\n\r\nhello",
+			strict:  reasonInvalidContentLength,
+			lenient: reasonInvalidContentLength,
+		},
+		{
+			name:    "hex Content-Length",
+			payload: head + "Content-Length: 0x5\r\n\r\nhello",
+			strict:  reasonInvalidContentLength,
+			lenient: reasonInvalidContentLength,
+		},
+		{
+			name:    "obfuscated Transfer-Encoding value",
+			payload: head + "Transfer-Encoding: xchunked\r\n\r\n0\r\n\r\n",
+			strict:  reasonInvalidTE,
+			lenient: reasonInvalidTE,
+		},
+		{
+			name:    "Transfer-Encoding list",
+			payload: head + "Transfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\n",
+			strict:  reasonInvalidTE,
+			lenient: reasonInvalidTE,
+		},
+		{
+			name:    "duplicate Transfer-Encoding",
+			payload: head + "Transfer-Encoding: chunked\r\nTransfer-Encoding: identity\r\n\r\n0\r\n\r\n",
+			strict:  reasonInvalidTE,
+			lenient: reasonInvalidTE,
+		},
+		{
+			name:    "space before colon",
+			payload: head + "Transfer-Encoding : chunked\r\nContent-Length: 4\r\n\r\n0\r\n\r\n",
+			strict:  reasonInvalidHeader,
+			lenient: reasonInvalidHeader,
+		},
+		{
+			name:    "Transfer-Encoding in HTTP/1.0",
+			payload: "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\n0\r\n\r\n",
+			strict:  reasonInvalidTE,
+			lenient: reasonInvalidTE,
+		},
+		{
+			name:    "obsolete line folding",
+			payload: head + "Transfer-Encoding:\r\n chunked\r\n\r\n0\r\n\r\n",
+			strict:  reasonInvalidHeader,
+		},
+		{
+			name:    "bare LF",
+			payload: "POST / HTTP/1.1\nHost: example.com\nContent-Length: 5\n\nhello",
+			strict:  reasonInvalidHeader,
+		},
+		{
+			name:    "invalid chunk size",
+			payload: head + "Transfer-Encoding: chunked\r\n\r\nzz\r\nhello\r\n0\r\n\r\n",
+			strict:  reasonInvalidChunk,
+			lenient: reasonInvalidChunk,
+		},
+		{
+			name:    "chunk size overflow",
+			payload: head + "Transfer-Encoding: chunked\r\n\r\n10000000000000005\r\nhello\r\n0\r\n\r\n",
+			strict:  reasonInvalidChunk,
+			lenient: reasonInvalidChunk,
+		},
+		{
+			name:    "chunk size with whitespace",
+			payload: head + "Transfer-Encoding: chunked\r\n\r\n5 \r\nhello\r\n0\r\n\r\n",
+			strict:  reasonInvalidChunk,
+		},
+		{
+			name:    "oversized chunk",
+			payload: head + "Transfer-Encoding: chunked\r\n\r\n3\r\nhello\r\n0\r\n\r\n",
+			strict:  reasonInvalidChunk,
+			lenient: reasonInvalidChunk,
+		},
+		{
+			name:    "bare LF in chunked body",
+			payload: head + "Transfer-Encoding: chunked\r\n\r\n5\nhello\n0\n\n",
+			strict:  reasonInvalidChunk,
+		},
+		{
+			name: "valid pipelined requests",
+			payload: "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n" +
+				head + "Content-Length: 5\r\n\r\nhello" +
+				head + "Transfer-Encoding: Chunked\r\n\r\n5;ext=1\r\nhello\r\n0\r\nX-Trailer: 1\r\n\r\n" +
+				"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		},
+		{
+			name:    "upgrade",
+			payload: "GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n\x81\x05hello",
+		},
+		{
+			name:    "smuggling after upgrade without 101",
+			payload: "GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: foo\r\n\r\n" + head + "Content-Length: 0\r\nContent-Length: 5\r\n\r\nGPOST",
+			strict:  reasonDuplicateContentLength,
+			lenient: reasonDuplicateContentLength,
+		},
+		{
+			name:    "smuggling after CONNECT without 2xx",
+			payload: "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n" + head + "Content-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nG",
+			strict:  reasonContentLengthWithTE,
+		},
+		{
+			name:    "HTTP/2 preface",
+			payload: "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n\x00\x00",
+			strict:  reasonInvalidRequestLine,
+		},
+		{
+			name:    "smuggling after malformed request line",
+			payload: "GARBAGE\r\n\r\n" + head + "Content-Length: 0\r\nContent-Length: 5\r\n\r\nGPOST",
+			strict:  reasonInvalidRequestLine,
+			lenient: reasonDuplicateContentLength,
+		},
+		{
+			name:    "smuggling after malformed header line",
+			payload: head + "Malformed\r\n\r\n" + head + "Content-Length: 0\r\nContent-Length: 5\r\n\r\nGPOST",
+			strict:  reasonInvalidHeader,
+			lenient: reasonDuplicateContentLength,
+		},
+		{
+			name:    "oversized head",
+			payload: head + "X-Large: " + strings.Repeat("a", maxHeadSize) + "\r\n\r\n",
+			strict:  reasonHeadTooLarge,
+			lenient: reasonHeadTooLarge,
+		},
+		{
+			name:    "oversized trailer",
+			payload: head + "Transfer-Encoding: chunked\r\n\r\n0\r\nX-Large: " + strings.Repeat("a", maxHeadSize) + "\r\n\r\n",
+			strict:  reasonHeadTooLarge,
+			lenient: reasonHeadTooLarge,
+		},
+	}
+
+	for _, c := range cases {
+		for _, mode := range []string{SmugglingDefenseStrict, SmugglingDefenseLenient} {
+			expected := c.strict
+			if mode == SmugglingDefenseLenient {
+				expected = c.lenient
+			}
+
+			data, reason := inspectPayload(c.payload, mode)
+			assert.Equal(expected, reason, "%s in %s mode", c.name, mode)
+			if expected == "" {
+				assert.Equal(c.payload, data, "%s in %s mode", c.name, mode)
+			} else if expected != reasonInvalidChunk && !strings.HasPrefix(c.name, "smuggling") && c.name != "oversized trailer" {
+				// the head of the request is never released, errors in
+				// chunked bodies are found after the head is released.
+				assert.False(strings.HasPrefix(data, head), "%s in %s mode", c.name, mode)
+			}
+		}
+	}
+}
+
+func TestFramingListener(t *testing.T) {
+	assert := assert.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+
+	reasons := make(chan string, 10)
+	fl := newFramingListener(l, SmugglingDefenseStrict, func(reason string) {
+		reasons <- reason
+	})
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("ok"))
+	})}
+	go srv.Serve(fl)
+	defer srv.Close()
+
+	send := func(payload string) string {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		assert.Nil(err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		_, err = conn.Write([]byte(payload))
+		assert.Nil(err)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		assert.Nil(err)
+		defer resp.Body.Close()
+		return resp.Status
+	}
+
+	status := send("POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\nhello")
+	assert.Equal("200 OK", status)
+
+	status = send("POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nG")
+	assert.Equal("400 Bad Request", status)
+	assert.Equal(reasonContentLengthWithTE, <-reasons)
+}
+
+func TestSmugglingDefenseSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &Spec{SmugglingDefense: SmugglingDefenseStrict}
+	assert.Nil(spec.Validate())
+
+	spec.HTTPS = true
+	spec.AutoCert = true
+	assert.NotNil(spec.Validate())
+}
+
+func TestFramingSwitchProtocols(t *testing.T) {
+	assert := assert.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+
+	reasons := make(chan string, 10)
+	fl := newFramingListener(l, SmugglingDefenseStrict, func(reason string) {
+		reasons <- reason
+	})
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			w.Write([]byte("ok"))
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		io.Copy(conn, rw)
+	})}
+	go srv.Serve(fl)
+	defer srv.Close()
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		assert.Nil(err)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn, bufio.NewReader(conn)
+	}
+
+	// requests are checked after an Upgrade request the server doesn't
+	// agree to, the server closes the connection on rejections of
+	// requests after the first one.
+	conn, br := dial()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: foo\r\n\r\n"))
+	resp, err := http.ReadResponse(br, nil)
+	assert.Nil(err)
+	assert.Equal("200 OK", resp.Status)
+	io.Copy(io.Discard, resp.Body)
+
+	conn.Write([]byte("POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 0\r\nContent-Length: 5\r\n\r\nGPOST"))
+	_, err = http.ReadResponse(br, nil)
+	assert.Error(err)
+	assert.Equal(reasonDuplicateContentLength, <-reasons)
+	conn.Close()
+
+	// the connection is passed through after 101.
+	conn, br = dial()
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n"))
+	resp, err = http.ReadResponse(br, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusSwitchingProtocols, resp.StatusCode)
+
+	data := "POST / HTTP/1.1\r\nContent-Length: 0\r\nContent-Length: 5\r\n\r\n"
+	conn.Write([]byte(data))
+	echo := make([]byte, len(data))
+	_, err = io.ReadFull(br, echo)
+	assert.Nil(err)
+	assert.Equal(data, string(echo))
+	assert.Empty(reasons)
+}
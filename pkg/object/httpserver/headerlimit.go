@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	defaultMaxHeaderTotalSize = 32 * 1024
+	defaultMaxHeaderSize      = 8 * 1024
+	defaultMaxHeaderCount     = 100
+
+	// maxHeaderTotalSize is the max of MaxTotalSize, which is the limit
+	// of Go's HTTP server by default.
+	maxHeaderTotalSize = http.DefaultMaxHeaderBytes
+	// maxHeaderCount is the max of MaxCount.
+	maxHeaderCount = 1000
+
+	// hpackEntryOverhead is the overhead of every header field counted by
+	// HTTP/2 and HTTP/3 in the size of a header list.
+	hpackEntryOverhead = 32
+
+	// reasons of the rejections.
+	headerLimitTotalSize  = "totalSize"
+	headerLimitHeaderSize = "headerSize"
+	headerLimitCount      = "count"
+)
+
+// HeaderLimitsSpec limits the request headers to defend against header
+// based DoS attacks. The size of a header field is the length of its name
+// plus the length of its value, and every value of a multi-value header is
+// a field.
+type HeaderLimitsSpec struct {
+	// MaxTotalSize is the max total size of all header fields, default
+	// is 32KiB.
+	MaxTotalSize int `json:"maxTotalSize,omitempty" jsonschema:"minimum=1,maximum=1048576"`
+	// MaxHeaderSize is the max size of a header field, default is 8KiB.
+	MaxHeaderSize int `json:"maxHeaderSize,omitempty" jsonschema:"minimum=1"`
+	// MaxCount is the max number of header fields, default is 100.
+	MaxCount int `json:"maxCount,omitempty" jsonschema:"minimum=1,maximum=1000"`
+}
+
+// Validate validates HeaderLimitsSpec.
+func (spec *HeaderLimitsSpec) Validate() error {
+	if spec.MaxTotalSize > maxHeaderTotalSize {
+		return fmt.Errorf("maxTotalSize must not exceed %d", maxHeaderTotalSize)
+	}
+	if spec.MaxCount > maxHeaderCount {
+		return fmt.Errorf("maxCount must not exceed %d", maxHeaderCount)
+	}
+	if spec.MaxHeaderSize > spec.maxTotalSize() {
+		return fmt.Errorf("maxHeaderSize must not exceed maxTotalSize")
+	}
+	return nil
+}
+
+func (spec *HeaderLimitsSpec) maxTotalSize() int {
+	if spec.MaxTotalSize <= 0 {
+		return defaultMaxHeaderTotalSize
+	}
+	return spec.MaxTotalSize
+}
+
+func (spec *HeaderLimitsSpec) maxHeaderSize() int {
+	if spec.MaxHeaderSize <= 0 {
+		return defaultMaxHeaderSize
+	}
+	return spec.MaxHeaderSize
+}
+
+func (spec *HeaderLimitsSpec) maxCount() int {
+	if spec.MaxCount <= 0 {
+		return defaultMaxHeaderCount
+	}
+	return spec.MaxCount
+}
+
+// maxHeaderBytes returns the MaxHeaderBytes of the underlying servers. It
+// is large enough for every request within the limits on all the HTTP
+// versions, so that the violations are rejected and counted by the mux
+// consistently, while abusive requests far beyond the limits are still
+// rejected by the servers without reading them into memory. It never
+// exceeds maxHeadSize, beyond which heads are rejected by the framing
+// check anyway.
+func (spec *HeaderLimitsSpec) maxHeaderBytes() int {
+	return min(spec.maxTotalSize()+spec.maxCount()*hpackEntryOverhead, maxHeadSize)
+}
+
+// check checks the request headers against the limits, it returns the
+// reason of the violation, or an empty string if there's none.
+func (spec *HeaderLimitsSpec) check(h http.Header) string {
+	maxTotal, maxSize, maxCount := spec.maxTotalSize(), spec.maxHeaderSize(), spec.maxCount()
+
+	total, count := 0, 0
+	for name, values := range h {
+		for _, v := range values {
+			size := len(name) + len(v)
+			if size > maxSize {
+				return headerLimitHeaderSize
+			}
+			total += size
+			count++
+		}
+	}
+
+	if count > maxCount {
+		return headerLimitCount
+	}
+	if total > maxTotal {
+		return headerLimitTotalSize
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderLimitsSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &HeaderLimitsSpec{}
+	assert.NoError(spec.Validate())
+	assert.Equal(defaultMaxHeaderTotalSize+defaultMaxHeaderCount*hpackEntryOverhead, spec.maxHeaderBytes())
+
+	assert.Error((&HeaderLimitsSpec{MaxTotalSize: 2 << 20}).Validate())
+	assert.Error((&HeaderLimitsSpec{MaxTotalSize: 100, MaxHeaderSize: 200}).Validate())
+	assert.Error((&HeaderLimitsSpec{MaxHeaderSize: 64 * 1024}).Validate())
+	assert.NoError((&HeaderLimitsSpec{MaxTotalSize: 200, MaxHeaderSize: 200}).Validate())
+	assert.Error((&HeaderLimitsSpec{MaxCount: maxHeaderCount + 1}).Validate())
+
+	spec = &HeaderLimitsSpec{MaxTotalSize: maxHeaderTotalSize, MaxCount: maxHeaderCount}
+	assert.NoError(spec.Validate())
+	assert.Equal(maxHeadSize, spec.maxHeaderBytes())
+}
+
+func TestHeaderLimitsCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &HeaderLimitsSpec{MaxTotalSize: 20, MaxHeaderSize: 10, MaxCount: 3}
+	assert.Equal("", spec.check(http.Header{"X-A": {"1234567"}}))
+	assert.Equal(headerLimitHeaderSize, spec.check(http.Header{"X-A": {"12345678"}}))
+	assert.Equal(headerLimitCount, spec.check(http.Header{"X-A": {"1", "2"}, "X-B": {"3", "4"}}))
+	assert.Equal(headerLimitTotalSize, spec.check(http.Header{"X-A": {"1234567"}, "X-B": {"1234567"}, "X-C": {"1"}}))
+	assert.Equal("", spec.check(http.Header{}))
+}
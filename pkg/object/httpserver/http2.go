@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// defaultHTTP2WindowSize is the default flow-control window of Go's HTTP/2
+// server.
+const defaultHTTP2WindowSize = 1 << 20
+
+type (
+	// HTTP2Spec tunes the stream limits and the flow control of HTTP/2
+	// connections. The flow-control windows are the request body bytes a
+	// client could send before they are read by the server, which are
+	// buffered in memory, so a connection may hold up to ConnectionWindowSize
+	// bytes, and larger windows mean more memory under high concurrency.
+	HTTP2Spec struct {
+		// MaxConcurrentStreams is the max number of concurrent streams of
+		// a connection, default is 250.
+		MaxConcurrentStreams uint32 `json:"maxConcurrentStreams,omitempty" jsonschema:"minimum=1"`
+		// StreamWindowSize is the flow-control window of a stream, default
+		// is 1MiB.
+		StreamWindowSize int32 `json:"streamWindowSize,omitempty" jsonschema:"minimum=65535"`
+		// ConnectionWindowSize is the flow-control window of a connection,
+		// which is shared by its streams, default is 1MiB.
+		ConnectionWindowSize int32 `json:"connectionWindowSize,omitempty" jsonschema:"minimum=65535"`
+		// MaxReadFrameSize is the largest frame the server is willing to
+		// read, default is 1MiB.
+		MaxReadFrameSize uint32 `json:"maxReadFrameSize,omitempty" jsonschema:"minimum=16384,maximum=16777215"`
+	}
+
+	// HTTP2Status is the status of the HTTP/2 streams being handled.
+	HTTP2Status struct {
+		Streams     int `json:"streams"`
+		Connections int `json:"connections"`
+		// TopConnections are the connections with the most streams.
+		TopConnections []*HTTP2ConnectionStatus `json:"topConnections"`
+	}
+
+	// HTTP2ConnectionStatus is the status of an HTTP/2 connection.
+	HTTP2ConnectionStatus struct {
+		RemoteAddr string `json:"remoteAddr"`
+		Streams    int    `json:"streams"`
+	}
+
+	// streamCounter counts the HTTP/2 streams being handled of every
+	// connection, the connections are identified by the remote addresses.
+	streamCounter struct {
+		mu    sync.Mutex
+		conns map[string]int
+	}
+)
+
+// Validate validates HTTP2Spec.
+func (spec *HTTP2Spec) Validate() error {
+	if spec.StreamWindowSize > spec.connectionWindowSize() {
+		return fmt.Errorf("streamWindowSize must not exceed connectionWindowSize")
+	}
+	return nil
+}
+
+func (spec *HTTP2Spec) connectionWindowSize() int32 {
+	if spec.ConnectionWindowSize <= 0 {
+		return defaultHTTP2WindowSize
+	}
+	return spec.ConnectionWindowSize
+}
+
+// server returns the HTTP/2 server configured by the spec, zero values
+// are the defaults of Go.
+func (spec *HTTP2Spec) server() *http2.Server {
+	return &http2.Server{
+		MaxConcurrentStreams:         spec.MaxConcurrentStreams,
+		MaxUploadBufferPerStream:     spec.StreamWindowSize,
+		MaxUploadBufferPerConnection: spec.ConnectionWindowSize,
+		MaxReadFrameSize:             spec.MaxReadFrameSize,
+	}
+}
+
+// configure configures HTTP/2 of the server, it must be called after the
+// TLS config of the server is set, as it adds HTTP/2 to the protocols of
+// the TLS config, and it fails if the TLS config is not compatible with
+// HTTP/2, e.g. the cipher suites required by HTTP/2 are missing.
+func (spec *HTTP2Spec) configure(srv *http.Server) error {
+	return http2.ConfigureServer(srv, spec.server())
+}
+
+func newStreamCounter() *streamCounter {
+	return &streamCounter{conns: map[string]int{}}
+}
+
+// handler wraps next to count the HTTP/2 streams it is handling.
+func (c *streamCounter) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor != 2 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		c.acquire(req.RemoteAddr)
+		defer c.release(req.RemoteAddr)
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (c *streamCounter) acquire(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conns[addr]++
+}
+
+func (c *streamCounter) release(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := c.conns[addr]; n <= 1 {
+		delete(c.conns, addr)
+	} else {
+		c.conns[addr] = n - 1
+	}
+}
+
+// status returns the status of the streams, or nil if there's none.
+func (c *streamCounter) status() *HTTP2Status {
+	c.mu.Lock()
+	if len(c.conns) == 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	status := &HTTP2Status{Connections: len(c.conns)}
+	conns := make([]*HTTP2ConnectionStatus, 0, len(c.conns))
+	for addr, n := range c.conns {
+		status.Streams += n
+		conns = append(conns, &HTTP2ConnectionStatus{RemoteAddr: addr, Streams: n})
+	}
+	c.mu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool {
+		if conns[i].Streams != conns[j].Streams {
+			return conns[i].Streams > conns[j].Streams
+		}
+		return conns[i].RemoteAddr < conns[j].RemoteAddr
+	})
+	if len(conns) > topNum {
+		conns = conns[:topNum]
+	}
+	status.TopConnections = conns
+	return status
+}
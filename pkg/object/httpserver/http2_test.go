@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+)
+
+func TestHTTP2Spec(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &HTTP2Spec{}
+	assert.NoError(spec.Validate())
+	assert.Equal(&http2.Server{}, spec.server())
+
+	spec = &HTTP2Spec{MaxConcurrentStreams: 1000, StreamWindowSize: 4 << 20, ConnectionWindowSize: 16 << 20, MaxReadFrameSize: 1 << 16}
+	assert.NoError(spec.Validate())
+	s := spec.server()
+	assert.Equal(uint32(1000), s.MaxConcurrentStreams)
+	assert.Equal(int32(4<<20), s.MaxUploadBufferPerStream)
+	assert.Equal(int32(16<<20), s.MaxUploadBufferPerConnection)
+	assert.Equal(uint32(1<<16), s.MaxReadFrameSize)
+
+	assert.Error((&HTTP2Spec{StreamWindowSize: 2 << 20}).Validate())
+	assert.Error((&HTTP2Spec{StreamWindowSize: 2 << 20, ConnectionWindowSize: 1 << 20}).Validate())
+
+	// the cipher suites required by HTTP/2 are missing.
+	srv := &http.Server{TLSConfig: &tls.Config{CipherSuites: []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA}}}
+	assert.Error(spec.configure(srv))
+	srv = &http.Server{TLSConfig: &tls.Config{}}
+	assert.NoError(spec.configure(srv))
+	assert.Contains(srv.TLSConfig.NextProtos, "h2")
+}
+
+func TestHTTP2ServerSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &Spec{HTTP2: &HTTP2Spec{}}
+	assert.NotNil(spec.Validate())
+
+	spec.HTTPS = true
+	spec.AutoCert = true
+	assert.Nil(spec.Validate())
+
+	spec.HTTP3 = true
+	assert.NotNil(spec.Validate())
+
+	spec.HTTP3 = false
+	spec.HTTP2.StreamWindowSize = 2 << 20
+	assert.NotNil(spec.Validate())
+}
+
+func TestStreamCounter(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newStreamCounter()
+	assert.Nil(c.status())
+
+	for i := 0; i < topNum+2; i++ {
+		c.acquire(fmt.Sprintf("10.0.0.%d:1000", i))
+	}
+	c.acquire("10.0.0.1:1000")
+	c.acquire("10.0.0.1:1000")
+
+	status := c.status()
+	assert.Equal(topNum+4, status.Streams)
+	assert.Equal(topNum+2, status.Connections)
+	assert.Len(status.TopConnections, topNum)
+	assert.Equal(&HTTP2ConnectionStatus{RemoteAddr: "10.0.0.1:1000", Streams: 3}, status.TopConnections[0])
+
+	c.release("10.0.0.1:1000")
+	c.release("10.0.0.1:1000")
+	for i := 0; i < topNum+2; i++ {
+		c.release(fmt.Sprintf("10.0.0.%d:1000", i))
+	}
+	assert.Nil(c.status())
+}
+
+func TestStreamCounterHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newStreamCounter()
+	block := make(chan struct{})
+	var started sync.WaitGroup
+	server := httptest.NewUnstartedServer(c.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started.Done()
+		<-block
+	})))
+	server.EnableHTTP2 = true
+	assert.NoError(http2.ConfigureServer(server.Config, (&HTTP2Spec{MaxConcurrentStreams: 10}).server()))
+	server.StartTLS()
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	client := server.Client()
+	for i := 0; i < 2; i++ {
+		started.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(server.URL)
+			if assert.NoError(err) {
+				assert.Equal(2, resp.ProtoMajor)
+				resp.Body.Close()
+			}
+		}()
+		// the second request reuses the connection of the first one.
+		started.Wait()
+	}
+
+	status := c.status()
+	assert.Equal(2, status.Streams)
+	assert.Equal(1, status.Connections)
+
+	close(block)
+	wg.Wait()
+	assert.Eventually(func() bool { return c.status() == nil }, time.Second, 10*time.Millisecond)
+}
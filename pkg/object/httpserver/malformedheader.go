@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MalformedHeadersReject rejects requests with malformed header values
+	// with status code 400, it is the default.
+	MalformedHeadersReject = "reject"
+	// MalformedHeadersStrip removes the malformed characters from header
+	// values.
+	MalformedHeadersStrip = "strip"
+	// MalformedHeadersEncode percent-encodes the malformed bytes of header
+	// values.
+	MalformedHeadersEncode = "encode"
+	// MalformedHeadersAllow passes header values through as is.
+	MalformedHeadersAllow = "allow"
+)
+
+// malformedHeaders returns the handling of malformed header values.
+func (spec *Spec) malformedHeaders() string {
+	if spec.MalformedHeaders == "" {
+		return MalformedHeadersReject
+	}
+	return spec.MalformedHeaders
+}
+
+// isMalformedByte reports whether b is a control character other than the
+// horizontal tab, which may be used for header injection.
+func isMalformedByte(b byte) bool {
+	return (b < 0x20 && b != '\t') || b == 0x7f
+}
+
+// isValidHeaderValue reports whether v is valid UTF-8 without control
+// characters.
+func isValidHeaderValue(v string) bool {
+	for i := 0; i < len(v); {
+		if b := v[i]; b < utf8.RuneSelf {
+			if isMalformedByte(b) {
+				return false
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(v[i:])
+		if r == utf8.RuneError && size == 1 {
+			return false
+		}
+		i += size
+	}
+	return true
+}
+
+// fixHeaderValue strips or percent-encodes the invalid UTF-8 bytes and the
+// control characters of v.
+func fixHeaderValue(v string, encode bool) string {
+	var sb strings.Builder
+	sb.Grow(len(v))
+	for len(v) > 0 {
+		r, size := utf8.DecodeRuneInString(v)
+		malformed := (r == utf8.RuneError && size == 1) || (r < utf8.RuneSelf && isMalformedByte(byte(r)))
+		if !malformed {
+			sb.WriteString(v[:size])
+		} else if encode {
+			fmt.Fprintf(&sb, "%%%02X", v[0])
+		}
+		v = v[size:]
+	}
+	return sb.String()
+}
+
+// sanitizeHeaders handles the malformed header values according to mode,
+// it returns the name of the first malformed header, or an empty string if
+// there is none. The header is fixed in place if mode is strip or encode.
+func sanitizeHeaders(h http.Header, mode string) string {
+	if mode == MalformedHeadersAllow {
+		return ""
+	}
+
+	malformed := ""
+	for name, values := range h {
+		for i, v := range values {
+			if isValidHeaderValue(v) {
+				continue
+			}
+			if malformed == "" {
+				malformed = name
+			}
+			switch mode {
+			case MalformedHeadersStrip:
+				values[i] = fixHeaderValue(v, false)
+			case MalformedHeadersEncode:
+				values[i] = fixHeaderValue(v, true)
+			default:
+				return name
+			}
+		}
+	}
+	return malformed
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsValidHeaderValue(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, v := range []string{"", "abc", "a\tb", "héllo", "日本語", "a b;c=\"d\""} {
+		assert.True(isValidHeaderValue(v), v)
+	}
+	for _, v := range []string{"a\rb", "a\nb", "\x00", "a\x7f", "\xff", "héllo\xe6", "日本\x01"} {
+		assert.False(isValidHeaderValue(v), v)
+	}
+}
+
+func TestFixHeaderValue(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("héllo", fixHeaderValue("hé\xffllo", false))
+	assert.Equal("hé%FFllo", fixHeaderValue("hé\xffllo", true))
+	assert.Equal("ab", fixHeaderValue("a\r\nb", false))
+	assert.Equal("a%0D%0Ab", fixHeaderValue("a\r\nb", true))
+	assert.Equal("a\tb", fixHeaderValue("a\tb", true))
+}
+
+func TestSanitizeHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	newHeader := func() http.Header {
+		return http.Header{
+			"X-Good": {"good"},
+			"X-Bad":  {"ok", "b\xffad"},
+		}
+	}
+
+	h := newHeader()
+	assert.Equal("X-Bad", sanitizeHeaders(h, MalformedHeadersReject))
+	assert.Equal(newHeader(), h)
+
+	h = newHeader()
+	assert.Equal("X-Bad", sanitizeHeaders(h, MalformedHeadersStrip))
+	assert.Equal([]string{"ok", "bad"}, h["X-Bad"])
+
+	h = newHeader()
+	assert.Equal("X-Bad", sanitizeHeaders(h, MalformedHeadersEncode))
+	assert.Equal([]string{"ok", "b%FFad"}, h["X-Bad"])
+
+	h = newHeader()
+	assert.Equal("", sanitizeHeaders(h, MalformedHeadersAllow))
+	assert.Equal(newHeader(), h)
+
+	assert.Equal("", sanitizeHeaders(http.Header{"X-Good": {"good"}}, MalformedHeadersReject))
+}
@@ -27,7 +27,7 @@ func newMockMetrics() *metrics {
 		"httpServerName": "name",
 		"kind":           Kind,
 	}
-	mockLabels := []string{"httpServerName", "kind", "routerKind", "backend"}
+	mockLabels := []string{"httpServerName", "kind", "routerKind", "backend", "path"}
 	return &metrics{
 		Health: prometheushelper.NewGauge("mock_httpserver_health",
 			"show the status for the http server: 1 for ready, 0 for down",
@@ -86,5 +86,13 @@ func newMockMetrics() *metrics {
 				Objectives: prometheushelper.DefaultObjectives(),
 			},
 			mockLabels).MustCurryWith(commonLabels),
+		MalformedHeaders: prometheushelper.NewCounter(
+			"mock_httpserver_malformed_header_requests",
+			"the total count of http requests with malformed header values",
+			append(mockLabels[:2:2], "action")).MustCurryWith(commonLabels),
+		HeaderLimitRejected: prometheushelper.NewCounter(
+			"mock_httpserver_header_limit_rejected_requests",
+			"the total count of http requests rejected for exceeding the limits of headers",
+			append(mockLabels[:2:2], "reason")).MustCurryWith(commonLabels),
 	}
 }
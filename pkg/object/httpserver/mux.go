@@ -19,10 +19,12 @@ package httpserver
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
+	"net/url"
 	"reflect"
 	"regexp"
 	"strings"
@@ -44,6 +46,8 @@ import (
 	"github.com/megaease/easegress/v2/pkg/tracing"
 	"github.com/megaease/easegress/v2/pkg/util/fasttime"
 	"github.com/megaease/easegress/v2/pkg/util/ipfilter"
+	"github.com/megaease/easegress/v2/pkg/util/pathnormalizer"
+	"github.com/megaease/easegress/v2/pkg/util/querynormalizer"
 	"github.com/megaease/easegress/v2/pkg/util/readers"
 	"github.com/megaease/easegress/v2/pkg/util/stringtool"
 	"github.com/prometheus/client_golang/prometheus"
@@ -68,13 +72,16 @@ type (
 		topN               *httpstat.TopN
 		metrics            *metrics
 		accessLogFormatter *accessLogFormatter
+		bodySampler        *bodySampler
 
 		muxMapper context.MuxMapper
 
 		cache *lru.ARCCache
 
-		tracer   *tracing.Tracer
-		ipFilter *ipfilter.IPFilter
+		tracer          *tracing.Tracer
+		ipFilter        *ipfilter.IPFilter
+		pathNormalizer  *pathnormalizer.PathNormalizer
+		queryNormalizer *querynormalizer.QueryNormalizer
 
 		router routers.Router
 	}
@@ -101,6 +108,7 @@ type (
 		RespSize    uint64
 		ReqHeaders  string
 		RespHeaders string
+		RespBody    string
 		Tags        string
 	}
 )
@@ -110,6 +118,8 @@ var (
 	forbidden        = &cachedRoute{code: http.StatusForbidden}
 	methodNotAllowed = &cachedRoute{code: http.StatusMethodNotAllowed}
 	badRequest       = &cachedRoute{code: http.StatusBadRequest}
+	redirected       = &cachedRoute{code: http.StatusMovedPermanently}
+	headerTooLarge   = &cachedRoute{code: http.StatusRequestHeaderFieldsTooLarge}
 )
 
 func (mi *muxInstance) getRouteFromCache(req *httpprot.Request) *cachedRoute {
@@ -184,8 +194,14 @@ func (m *mux) reload(superSpec *supervisor.Spec, muxMapper context.MuxMapper) {
 		topN:               m.topN,
 		metrics:            oldInst.metrics,
 		ipFilter:           ipfilter.New(spec.IPFilter),
+		pathNormalizer:     pathnormalizer.New(spec.PathNormalizer),
+		queryNormalizer:    querynormalizer.New(spec.QueryNormalizer),
 		tracer:             tracer,
-		accessLogFormatter: newAccessLogFormatter(spec.AccessLogFormat),
+		accessLogFormatter: newAccessLogFormatter(spec.AccessLogFormat, spec.AccessLogBody != nil),
+		bodySampler:        newBodySampler(spec.AccessLogBody),
+	}
+	if spec.PathMatching != nil && spec.PathMatching.CaseInsensitive {
+		spec.Rules.FoldCase()
 	}
 	spec.Rules.Init()
 	inst.router = routers.Create(routerKind, spec.Rules)
@@ -229,7 +245,9 @@ func buildFailureResponse(ctx *context.Context, statusCode int) *httpprot.Respon
 	return resp
 }
 
-func (mi *muxInstance) sendResponse(ctx *context.Context, stdw http.ResponseWriter) (int, uint64, http.Header) {
+// sendResponse sends the response to the client, the returned bool is true
+// if the payload reports the response is aborted.
+func (mi *muxInstance) sendResponse(ctx *context.Context, stdw http.ResponseWriter) (int, uint64, http.Header, *bodyCapture, bool) {
 	var resp *httpprot.Response
 	if v := ctx.GetResponse(context.DefaultNamespace); v == nil {
 		logger.Errorf("%s: response is nil", mi.superSpec.Name())
@@ -254,9 +272,18 @@ func (mi *muxInstance) sendResponse(ctx *context.Context, stdw http.ResponseWrit
 	} else {
 		writer = stdw
 	}
-	respBodySize, _ := io.Copy(writer, resp.GetPayload())
 
-	return resp.StatusCode(), uint64(respBodySize) + uint64(resp.MetaSize()), header
+	var capture *bodyCapture
+	if mi.bodySampler != nil {
+		capture = mi.bodySampler.newCapture(resp.StatusCode())
+		if capture != nil {
+			writer = io.MultiWriter(writer, capture)
+		}
+	}
+	respBodySize, err := io.Copy(writer, resp.GetPayload())
+	aborted := errors.Is(err, httpprot.ErrResponseAborted)
+
+	return resp.StatusCode(), uint64(respBodySize) + uint64(resp.MetaSize()), header, capture, aborted
 }
 
 // ResponseFlushWriter is a wrapper of http.ResponseWriter, which flushes the
@@ -319,25 +346,83 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 	reqMetaSize := req.MetaSize()
 	ctx.SetRequest(context.DefaultNamespace, req)
 
-	// get topN here, as the path could be modified later.
-	topN := mi.topN.Stat(req.Path())
+	// Check the limits of headers before anything else, so that abusive
+	// requests cost as little as possible.
+	headerLimited := false
+	if limits := mi.spec.HeaderLimits; limits != nil {
+		if reason := limits.check(stdr.Header); reason != "" {
+			mi.metrics.HeaderLimitRejected.WithLabelValues(reason).Inc()
+			ctx.AddTag("header limit exceeded: " + reason)
+			headerLimited = true
+		}
+	}
+
+	// Handle malformed header values before routing, so that filters
+	// never see them.
+	headerValid := !headerLimited
+	mode := mi.spec.malformedHeaders()
+	if headerValid {
+		if name := sanitizeHeaders(stdr.Header, mode); name != "" {
+			mi.metrics.MalformedHeaders.WithLabelValues(mode).Inc()
+			ctx.AddTag(fmt.Sprintf("malformed header %s: %s", name, mode))
+			headerValid = mode != MalformedHeadersReject
+		}
+	}
+
+	// Normalize the path and the query before routing, so that they can't
+	// be bypassed.
+	pathValid := headerValid && mi.pathNormalizer.NormalizeRequest(stdr) &&
+		mi.queryNormalizer.NormalizeRequest(stdr)
+
+	// Apply the trailing slash policy before routing too.
+	redirectTo := ""
+	if pathValid {
+		redirectTo = mi.canonicalizePath(stdr)
+	}
+
+	// get topN and the path here, as the path could be modified later.
+	path := req.Path()
+	topN := mi.topN.Stat(path)
 
 	routeCtx := routers.NewContext(req)
-	route := mi.search(routeCtx)
+	if mi.spec.PathMatching != nil && mi.spec.PathMatching.CaseInsensitive {
+		routeCtx.FoldCase()
+	}
+	route := badRequest
+	if headerLimited {
+		route = headerTooLarge
+	} else if redirectTo != "" {
+		route = redirected
+	} else if pathValid {
+		route = mi.search(routeCtx)
+	}
 	ctx.SetRoute(route.route)
 
 	var respHeader http.Header
 
+	// drain is false if the client is waiting for a 100 Continue, but the
+	// request is rejected before approving its body.
+	drain := true
+
+	// aborted is true if the response is incomplete, and the connection
+	// to the client must be aborted.
+	aborted := false
+
 	defer func() {
 		metric, _ := ctx.GetData("HTTP_METRIC").(*httpstat.Metric)
 
+		var respBody *bodyCapture
 		if metric == nil {
-			statusCode, respSize, header := mi.sendResponse(ctx, stdw)
+			statusCode, respSize, header, capture, abort := mi.sendResponse(ctx, stdw)
+			aborted = abort
 			ctx.Finish()
 
 			// Drain off the body if it has not been, so that we can get the
-			// correct body size.
-			io.Copy(io.Discard, body)
+			// correct body size. But a deferred body is never approved, and
+			// the client should not be asked to send it.
+			if drain && !req.PayloadDeferred() {
+				io.Copy(io.Discard, body)
+			}
 
 			metric = &httpstat.Metric{
 				StatusCode: statusCode,
@@ -345,6 +430,7 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 				RespSize:   respSize,
 			}
 			respHeader = header
+			respBody = capture
 		} else { // hijacked, websocket and etc.
 			ctx.Finish()
 		}
@@ -353,7 +439,7 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 		topN.Stat(metric)
 		mi.httpStat.Stat(metric)
 		if route.code == 0 {
-			mi.exportPrometheusMetrics(metric, route.route.GetBackend())
+			mi.exportPrometheusMetrics(metric, route.route, path)
 		}
 
 		span.End()
@@ -375,10 +461,24 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 				ReqHeaders:  printHeader(stdr.Header),
 				RespHeaders: printHeader(respHeader),
 			}
+			if mi.bodySampler != nil {
+				log.RespBody = mi.bodySampler.sample(respBody, metric.StatusCode)
+			}
 			return mi.accessLogFormatter.format(log)
 		})
+
+		// abort the connection so that the client knows the response is
+		// incomplete, net/http recovers this panic silently.
+		if aborted {
+			panic(http.ErrAbortHandler)
+		}
 	}()
 
+	if route == redirected {
+		buildRedirectResponse(ctx, stdr, redirectTo)
+		return
+	}
+
 	if route.code != 0 {
 		logger.Errorf("%s: status code of result route for [%s %s]: %d", mi.superSpec.Name(), req.Method(), req.RequestURI, route.code)
 		buildFailureResponse(ctx, route.code)
@@ -403,7 +503,45 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 	if maxBodySize == 0 {
 		maxBodySize = mi.spec.ClientMaxBodySize
 	}
-	err := req.FetchPayload(maxBodySize)
+
+	// the pipeline may choose its own strategy to read the body.
+	strategy, threshold := "", int64(0)
+	if rb, ok := handler.(context.RequestBufferer); ok {
+		strategy, threshold = rb.RequestBuffering()
+	}
+
+	deferContinue := mi.spec.DeferContinue && expectContinue(stdr)
+	var err error
+	switch {
+	case strategy == httpprot.BufferingStream:
+		// a stream is read on demand, so the 100 Continue is deferred
+		// until the body is read, and it must not be drained.
+		if err = req.LimitPayload(maxBodySize); err == nil {
+			err = req.FetchPayload(-1)
+			drain = !deferContinue
+		}
+	case strategy == httpprot.BufferingThreshold:
+		if err = req.LimitPayload(maxBodySize); err != nil {
+			break
+		}
+		if deferContinue {
+			err = req.DeferBufferPayload(threshold)
+			drain = err == nil
+		} else {
+			err = req.BufferPayload(threshold)
+		}
+	default:
+		// the full strategy always buffers the body.
+		if strategy == httpprot.BufferingFull && maxBodySize < 0 {
+			maxBodySize = 0
+		}
+		if deferContinue {
+			err = req.DeferPayload(maxBodySize)
+			drain = err == nil
+		} else {
+			err = req.FetchPayload(maxBodySize)
+		}
+	}
 	if err == httpprot.ErrRequestEntityTooLarge {
 		logger.Errorf("%s: %s, you may need to increase 'clientMaxBodySize' or set it to -1", mi.superSpec.Name(), err.Error())
 		buildFailureResponse(ctx, http.StatusRequestEntityTooLarge)
@@ -424,6 +562,51 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 	}
 }
 
+// canonicalizePath applies the trailing slash policy to the path of the
+// request. It returns the canonical path if the request should be
+// redirected, otherwise, the path is rewritten to the canonical form if
+// required, and an empty string is returned.
+func (mi *muxInstance) canonicalizePath(stdr *http.Request) string {
+	pm := mi.spec.PathMatching
+	p := pm.Canonical(stdr.URL.Path)
+	if p == stdr.URL.Path {
+		return ""
+	}
+
+	// a path like "//example.com" is a protocol-relative URL in the
+	// Location header, so it is never redirected to.
+	if pm.TrailingSlash == routers.TrailingSlashRedirect && !strings.HasPrefix(p, "//") {
+		return p
+	}
+
+	stdr.URL.Path = p
+	stdr.URL.RawPath = ""
+	return ""
+}
+
+// buildRedirectResponse redirects the request to path with the original
+// query. 301 is used for GET and HEAD requests, and 308 for others, so that
+// the method and body are preserved.
+func buildRedirectResponse(ctx *context.Context, stdr *http.Request, path string) {
+	statusCode := http.StatusPermanentRedirect
+	if stdr.Method == http.MethodGet || stdr.Method == http.MethodHead {
+		statusCode = http.StatusMovedPermanently
+	}
+
+	u := &url.URL{Path: path, RawQuery: stdr.URL.RawQuery}
+	resp := buildFailureResponse(ctx, statusCode)
+	resp.HTTPHeader().Set("Location", u.RequestURI())
+}
+
+// expectContinue returns whether the client is waiting for a 100 Continue
+// response before sending the request body.
+func expectContinue(stdr *http.Request) bool {
+	if stdr.ContentLength == 0 {
+		return false
+	}
+	return strings.EqualFold(stdr.Header.Get("Expect"), "100-continue")
+}
+
 func (mi *muxInstance) search(context *routers.RouteContext) *cachedRoute {
 	req := context.Request
 	ip := req.RealIP()
@@ -509,10 +692,20 @@ func (m *mux) close() {
 	m.inst.Load().(*muxInstance).close()
 }
 
-func (mi *muxInstance) exportPrometheusMetrics(stat *httpstat.Metric, backend string) {
+// metricsPath returns the path label of metrics, path is the concrete
+// path of the request before rewriting.
+func (mi *muxInstance) metricsPath(route routers.Route, path string) string {
+	if mi.spec.MetricsPathLabel == MetricsPathLabelPath {
+		return path
+	}
+	return route.GetPathTemplate()
+}
+
+func (mi *muxInstance) exportPrometheusMetrics(stat *httpstat.Metric, route routers.Route, path string) {
 	labels := prometheus.Labels{
 		"routerKind": mi.spec.RouterKind,
-		"backend":    backend,
+		"backend":    route.GetBackend(),
+		"path":       mi.metricsPath(route, path),
 	}
 	mi.metrics.TotalRequests.With(labels).Inc()
 	mi.metrics.TotalResponses.With(labels).Inc()
@@ -527,10 +720,13 @@ func (mi *muxInstance) exportPrometheusMetrics(stat *httpstat.Metric, backend st
 	mi.metrics.ResponseSizeBytesPercentage.With(labels).Observe(float64(stat.RespSize))
 }
 
-func newAccessLogFormatter(format string) *accessLogFormatter {
+func newAccessLogFormatter(format string, logBody bool) *accessLogFormatter {
 	if format == "" {
 		format = defaultAccessLogFormat
 	}
+	if logBody && !strings.Contains(format, "{{RespBody}}") {
+		format += " [{{RespBody}}]"
+	}
 	varReg := regexp.MustCompile(`\{\{([a-zA-z]*)\}\}`)
 	expr := varReg.ReplaceAllString(format, "{{.$1}}")
 	escapeReg := regexp.MustCompile(`(\[|\])`)
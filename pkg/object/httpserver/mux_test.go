@@ -19,6 +19,7 @@ package httpserver
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -40,6 +41,9 @@ import (
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot/httpstat"
 	"github.com/megaease/easegress/v2/pkg/supervisor"
 	"github.com/megaease/easegress/v2/pkg/tracing"
+	"github.com/megaease/easegress/v2/pkg/util/readers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -103,6 +107,24 @@ func TestBuildFailureResponse(t *testing.T) {
 	assert.Equal(http.StatusNotFound, resp.StatusCode())
 }
 
+func TestSendResponseAborted(t *testing.T) {
+	assert := assert.New(t)
+	mi := &muxInstance{}
+
+	ctx := context.New(tracing.NoopSpan)
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetPayload(io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(httpprot.ErrResponseAborted)))
+	ctx.SetResponse(context.DefaultNamespace, resp)
+	w := httptest.NewRecorder()
+	_, _, _, _, aborted := mi.sendResponse(ctx, w)
+	assert.True(aborted)
+	assert.Equal("partial", w.Body.String())
+
+	resp.SetPayload(iotest.ErrReader(fmt.Errorf("other error")))
+	_, _, _, _, aborted = mi.sendResponse(ctx, httptest.NewRecorder())
+	assert.False(aborted)
+}
+
 func TestAppendXForwardFor(t *testing.T) {
 	const xForwardedFor = "X-Forwarded-For"
 
@@ -227,6 +249,415 @@ rules:
 	assert.Equal(http.StatusBadRequest, stdw.Code)
 }
 
+func TestServeHTTPPathNormalizer(t *testing.T) {
+	assert := assert.New(t)
+
+	var path string
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				path = ctx.GetInputRequest().(*httpprot.Request).Path()
+				buildFailureResponse(ctx, http.StatusOK)
+				return ""
+			},
+		}, true
+	}
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), newMockMetrics(), mm)
+
+	yamlConfig := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+pathNormalizer:
+  action: %s
+rules:
+- paths:
+  - path: /admin
+    backend: admin-pipeline
+`
+	superSpec, err := supervisor.NewSpec(fmt.Sprintf(yamlConfig, "Normalize"))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	stdr := httptest.NewRequest(http.MethodGet, "/static/%2e%2e//admin", http.NoBody)
+	stdw := httptest.NewRecorder()
+	m.ServeHTTP(stdw, stdr)
+	assert.Equal(http.StatusOK, stdw.Code)
+	assert.Equal("/admin", path)
+
+	superSpec, err = supervisor.NewSpec(fmt.Sprintf(yamlConfig, "Reject"))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	path = ""
+	stdr = httptest.NewRequest(http.MethodGet, "/static/%2e%2e//admin", http.NoBody)
+	stdw = httptest.NewRecorder()
+	m.ServeHTTP(stdw, stdr)
+	assert.Equal(http.StatusBadRequest, stdw.Code)
+	assert.Equal("", path)
+
+	stdr = httptest.NewRequest(http.MethodGet, "//admin", http.NoBody)
+	stdw = httptest.NewRecorder()
+	m.ServeHTTP(stdw, stdr)
+	assert.Equal(http.StatusOK, stdw.Code)
+	assert.Equal("/admin", path)
+}
+
+func TestServeHTTPQueryNormalizer(t *testing.T) {
+	assert := assert.New(t)
+
+	var backend, query string
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				backend = name
+				query = ctx.GetInputRequest().(*httpprot.Request).Std().URL.RawQuery
+				buildFailureResponse(ctx, http.StatusOK)
+				return ""
+			},
+		}, true
+	}
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), newMockMetrics(), mm)
+
+	yamlConfig := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+queryNormalizer:
+  policy: %s
+rules:
+- paths:
+  - path: /users
+    queries:
+    - key: role
+      values: [user]
+    backend: user-pipeline
+`
+	superSpec, err := supervisor.NewSpec(fmt.Sprintf(yamlConfig, "first"))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	// the router matches the first value, while a backend may take the
+	// last one, the request must not reach the backend with role=admin.
+	stdr := httptest.NewRequest(http.MethodGet, "/users?role=user&%72ole=admin", http.NoBody)
+	stdw := httptest.NewRecorder()
+	m.ServeHTTP(stdw, stdr)
+	assert.Equal(http.StatusOK, stdw.Code)
+	assert.Equal("user-pipeline", backend)
+	assert.Equal("role=user", query)
+
+	superSpec, err = supervisor.NewSpec(fmt.Sprintf(yamlConfig, "reject"))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	backend = ""
+	stdr = httptest.NewRequest(http.MethodGet, "/users?role=user&%72ole=admin", http.NoBody)
+	stdw = httptest.NewRecorder()
+	m.ServeHTTP(stdw, stdr)
+	assert.Equal(http.StatusBadRequest, stdw.Code)
+	assert.Equal("", backend)
+
+	stdr = httptest.NewRequest(http.MethodGet, "/users?role=user;role=admin", http.NoBody)
+	stdw = httptest.NewRecorder()
+	m.ServeHTTP(stdw, stdr)
+	assert.Equal(http.StatusBadRequest, stdw.Code)
+	assert.Equal("", backend)
+}
+
+func TestServeHTTPMalformedHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	var value string
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				value = ctx.GetInputRequest().(*httpprot.Request).HTTPHeader().Get("X-Test")
+				buildFailureResponse(ctx, http.StatusOK)
+				return ""
+			},
+		}, true
+	}
+	metrics := newMockMetrics()
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), metrics, mm)
+
+	yamlConfig := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+malformedHeaders: %s
+rules:
+- paths:
+  - pathPrefix: /
+    backend: header-pipeline
+`
+	serve := func(header string) int {
+		value = ""
+		stdr := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		stdr.Header.Set("X-Test", header)
+		stdw := httptest.NewRecorder()
+		m.ServeHTTP(stdw, stdr)
+		return stdw.Code
+	}
+	count := func(action string) float64 {
+		return testutil.ToFloat64(metrics.MalformedHeaders.WithLabelValues(action))
+	}
+
+	// reject by default
+	superSpec, err := supervisor.NewSpec(fmt.Sprintf(yamlConfig, `""`))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+	assert.Equal(http.StatusOK, serve("héllo\tworld"))
+	assert.Equal("héllo\tworld", value)
+	assert.Equal(http.StatusBadRequest, serve("a\xffb"))
+	assert.Equal(http.StatusBadRequest, serve("a\x01b"))
+	assert.Equal("", value)
+	assert.Equal(float64(2), count(MalformedHeadersReject))
+
+	superSpec, err = supervisor.NewSpec(fmt.Sprintf(yamlConfig, "strip"))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+	assert.Equal(http.StatusOK, serve("a\xffb\x7fc"))
+	assert.Equal("abc", value)
+	assert.Equal(float64(1), count(MalformedHeadersStrip))
+
+	superSpec, err = supervisor.NewSpec(fmt.Sprintf(yamlConfig, "encode"))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+	assert.Equal(http.StatusOK, serve("a\xffb\x00c"))
+	assert.Equal("a%FFb%00c", value)
+
+	superSpec, err = supervisor.NewSpec(fmt.Sprintf(yamlConfig, "allow"))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+	assert.Equal(http.StatusOK, serve("a\xffb"))
+	assert.Equal("a\xffb", value)
+}
+
+func TestServeHTTPHeaderLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				buildFailureResponse(ctx, http.StatusOK)
+				return ""
+			},
+		}, true
+	}
+	metrics := newMockMetrics()
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), metrics, mm)
+
+	superSpec, err := supervisor.NewSpec(`
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+headerLimits:
+  maxTotalSize: 100
+  maxHeaderSize: 40
+  maxCount: 5
+rules:
+- paths:
+  - pathPrefix: /
+    backend: header-pipeline
+`)
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	serve := func(header http.Header) int {
+		stdr := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		stdr.Header = header
+		stdw := httptest.NewRecorder()
+		m.ServeHTTP(stdw, stdr)
+		return stdw.Code
+	}
+	count := func(reason string) float64 {
+		return testutil.ToFloat64(metrics.HeaderLimitRejected.WithLabelValues(reason))
+	}
+
+	assert.Equal(http.StatusOK, serve(http.Header{"X-A": {strings.Repeat("a", 37)}}))
+	assert.Equal(http.StatusRequestHeaderFieldsTooLarge, serve(http.Header{"X-A": {strings.Repeat("a", 38)}}))
+	assert.Equal(float64(1), count(headerLimitHeaderSize))
+
+	// every value of a multi-value header is counted.
+	assert.Equal(http.StatusOK, serve(http.Header{"X-A": {"1", "2", "3", "4", "5"}}))
+	assert.Equal(http.StatusRequestHeaderFieldsTooLarge, serve(http.Header{"X-A": {"1", "2", "3", "4", "5", "6"}}))
+	assert.Equal(float64(1), count(headerLimitCount))
+
+	header := http.Header{}
+	for _, name := range []string{"X-A", "X-B", "X-C"} {
+		header.Set(name, strings.Repeat("a", 35))
+	}
+	assert.Equal(http.StatusRequestHeaderFieldsTooLarge, serve(header))
+	assert.Equal(float64(1), count(headerLimitTotalSize))
+
+	// the limits are checked before malformed headers.
+	malformed := testutil.ToFloat64(metrics.MalformedHeaders.WithLabelValues(MalformedHeadersReject))
+	assert.Equal(http.StatusRequestHeaderFieldsTooLarge, serve(http.Header{"X-A": {"\x01" + strings.Repeat("a", 40)}}))
+	assert.Equal(float64(2), count(headerLimitHeaderSize))
+	assert.Equal(malformed, testutil.ToFloat64(metrics.MalformedHeaders.WithLabelValues(MalformedHeadersReject)))
+}
+
+func TestServeHTTPPathMatching(t *testing.T) {
+	assert := assert.New(t)
+
+	var path string
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				path = ctx.GetInputRequest().(*httpprot.Request).Path()
+				buildFailureResponse(ctx, http.StatusOK)
+				return ""
+			},
+		}, true
+	}
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), newMockMetrics(), mm)
+
+	yamlConfig := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+routerKind: RadixTree
+pathMatching:
+  trailingSlash: %s
+  appendSlash: %v
+  caseInsensitive: %v
+rules:
+- paths:
+  - path: /users
+    backend: profile-pipeline
+  - path: /users/{id}
+    rewriteTarget: /profiles/{id}
+    backend: profile-pipeline
+`
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		path = ""
+		stdr := httptest.NewRequest(method, target, http.NoBody)
+		stdw := httptest.NewRecorder()
+		m.ServeHTTP(stdw, stdr)
+		return stdw
+	}
+
+	// distinct
+	superSpec, err := supervisor.NewSpec(fmt.Sprintf(yamlConfig, "distinct", false, false))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+	assert.Equal(http.StatusOK, serve(http.MethodGet, "/users").Code)
+	assert.Equal(http.StatusNotFound, serve(http.MethodGet, "/users/").Code)
+	assert.Equal(http.StatusNotFound, serve(http.MethodGet, "/USERS").Code)
+
+	// equivalent, the path parameter doesn't capture the trailing slash.
+	superSpec, err = supervisor.NewSpec(fmt.Sprintf(yamlConfig, "equivalent", false, false))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+	assert.Equal(http.StatusOK, serve(http.MethodGet, "/users/").Code)
+	assert.Equal("/users", path)
+	assert.Equal(http.StatusOK, serve(http.MethodGet, "/users/42/").Code)
+	assert.Equal("/profiles/42", path)
+
+	// redirect
+	superSpec, err = supervisor.NewSpec(fmt.Sprintf(yamlConfig, "redirect", false, false))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+	stdw := serve(http.MethodGet, "/users/42/?a=1")
+	assert.Equal(http.StatusMovedPermanently, stdw.Code)
+	assert.Equal("/users/42?a=1", stdw.Header().Get("Location"))
+	assert.Equal("", path)
+	stdw = serve(http.MethodPost, "/users/")
+	assert.Equal(http.StatusPermanentRedirect, stdw.Code)
+	assert.Equal("/users", stdw.Header().Get("Location"))
+	// never redirect to a protocol-relative URL.
+	stdw = serve(http.MethodGet, "//example.com/")
+	assert.Empty(stdw.Header().Get("Location"))
+	assert.Equal(http.StatusOK, serve(http.MethodGet, "/users/42").Code)
+
+	// redirect to the path with a trailing slash.
+	superSpec, err = supervisor.NewSpec(fmt.Sprintf(yamlConfig, "redirect", true, false))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+	stdw = serve(http.MethodGet, "/users/42")
+	assert.Equal(http.StatusMovedPermanently, stdw.Code)
+	assert.Equal("/users/42/", stdw.Header().Get("Location"))
+
+	// case-insensitive, the case of path parameters is preserved.
+	superSpec, err = supervisor.NewSpec(fmt.Sprintf(yamlConfig, "equivalent", false, true))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+	assert.Equal(http.StatusOK, serve(http.MethodGet, "/USERS/").Code)
+	assert.Equal("/USERS", path)
+	assert.Equal(http.StatusOK, serve(http.MethodGet, "/Users/Alice/").Code)
+	assert.Equal("/profiles/Alice", path)
+}
+
+func TestMetricsPathLabel(t *testing.T) {
+	assert := assert.New(t)
+
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				buildFailureResponse(ctx, http.StatusOK)
+				return ""
+			},
+		}, true
+	}
+	metrics := newMockMetrics()
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), metrics, mm)
+
+	yamlConfig := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+routerKind: RadixTree
+metricsPathLabel: %s
+rules:
+- paths:
+  - path: /users/{id}
+    backend: users-pipeline
+    rewriteTarget: /v1/users/{id}
+`
+	count := func(path string) float64 {
+		labels := prometheus.Labels{"routerKind": "RadixTree", "backend": "users-pipeline", "path": path}
+		return testutil.ToFloat64(metrics.TotalRequests.With(labels))
+	}
+
+	// the path template is used by default.
+	superSpec, err := supervisor.NewSpec(fmt.Sprintf(yamlConfig, `""`))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+	for _, path := range []string{"/users/1", "/users/2"} {
+		stdw := httptest.NewRecorder()
+		m.ServeHTTP(stdw, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+		assert.Equal(http.StatusOK, stdw.Code)
+	}
+	assert.Equal(2.0, count("/users/{id}"))
+
+	// the concrete path before rewriting.
+	superSpec, err = supervisor.NewSpec(fmt.Sprintf(yamlConfig, MetricsPathLabelPath))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+	stdw := httptest.NewRecorder()
+	m.ServeHTTP(stdw, httptest.NewRequest(http.MethodGet, "/users/3", http.NoBody))
+	assert.Equal(1.0, count("/users/3"))
+	assert.Equal(2.0, count("/users/{id}"))
+}
+
 func TestMuxInstanceSearch(t *testing.T) {
 	assert := assert.New(t)
 
@@ -651,7 +1082,7 @@ func TestAccessLog(t *testing.T) {
 		URI:     "127.0.0.1",
 		ReqSize: 100,
 	}
-	formatter := newAccessLogFormatter("{{Method}} {{URI}} [{{ReqSize}}]")
+	formatter := newAccessLogFormatter("{{Method}} {{URI}} [{{ReqSize}}]", false)
 	s := formatter.format(log)
 	assert.Equal(t, "GET 127.0.0.1 [100]", s)
 }
@@ -666,3 +1097,199 @@ func TestPrintHeader(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestServeHTTPDeferContinue(t *testing.T) {
+	assert := assert.New(t)
+
+	approve := false
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				if !approve {
+					buildFailureResponse(ctx, http.StatusUnauthorized)
+					return ""
+				}
+				req := ctx.GetInputRequest().(*httpprot.Request)
+				if err := req.FetchDeferredPayload(); err != nil {
+					buildFailureResponse(ctx, http.StatusBadRequest)
+					return ""
+				}
+				buildFailureResponse(ctx, http.StatusOK)
+				return ""
+			},
+		}, true
+	}
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), newMockMetrics(), mm)
+
+	yamlConfig := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+deferContinue: true
+clientMaxBodySize: 10
+rules:
+- paths:
+  - path: /upload
+    backend: upload-pipeline
+`
+	superSpec, err := supervisor.NewSpec(yamlConfig)
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	newRequest := func(body *readers.ByteCountReader, size int64) *http.Request {
+		stdr := httptest.NewRequest(http.MethodPut, "/upload", body)
+		stdr.ContentLength = size
+		stdr.Header.Set("Expect", "100-continue")
+		return stdr
+	}
+
+	// rejected, the body is never read.
+	body := readers.NewByteCountReader(strings.NewReader("hello"))
+	stdw := httptest.NewRecorder()
+	m.ServeHTTP(stdw, newRequest(body, 5))
+	assert.Equal(http.StatusUnauthorized, stdw.Code)
+	assert.Equal(0, body.BytesRead())
+
+	// too large, rejected before the pipeline.
+	approve = true
+	body = readers.NewByteCountReader(strings.NewReader("hello world"))
+	stdw = httptest.NewRecorder()
+	m.ServeHTTP(stdw, newRequest(body, 11))
+	assert.Equal(http.StatusRequestEntityTooLarge, stdw.Code)
+	assert.Equal(0, body.BytesRead())
+
+	// approved.
+	body = readers.NewByteCountReader(strings.NewReader("hello"))
+	stdw = httptest.NewRecorder()
+	m.ServeHTTP(stdw, newRequest(body, 5))
+	assert.Equal(http.StatusOK, stdw.Code)
+	assert.Equal(5, body.BytesRead())
+
+	// requests without the Expect header are not deferred.
+	approve = false
+	body = readers.NewByteCountReader(strings.NewReader("hello"))
+	stdr := newRequest(body, 5)
+	stdr.Header.Del("Expect")
+	stdw = httptest.NewRecorder()
+	m.ServeHTTP(stdw, stdr)
+	assert.Equal(http.StatusUnauthorized, stdw.Code)
+	assert.Equal(5, body.BytesRead())
+}
+
+type mockedBufferingHandler struct {
+	contexttest.MockedHandler
+	strategy  string
+	threshold int64
+}
+
+func (h *mockedBufferingHandler) RequestBuffering() (string, int64) {
+	return h.strategy, h.threshold
+}
+
+func TestServeHTTPRequestBuffering(t *testing.T) {
+	assert := assert.New(t)
+
+	reject := false
+	handler := &mockedBufferingHandler{}
+	handler.MockedHandle = func(ctx *context.Context) string {
+		req := ctx.GetInputRequest().(*httpprot.Request)
+		if reject {
+			buildFailureResponse(ctx, http.StatusUnauthorized)
+		} else if req.FetchDeferredPayload() == httpprot.ErrRequestEntityTooLarge {
+			buildFailureResponse(ctx, http.StatusRequestEntityTooLarge)
+		} else if req.IsStream() {
+			if _, err := io.ReadAll(req.GetPayload()); err == httpprot.ErrRequestEntityTooLarge {
+				buildFailureResponse(ctx, http.StatusRequestEntityTooLarge)
+			} else {
+				buildFailureResponse(ctx, http.StatusAccepted)
+			}
+		} else {
+			buildFailureResponse(ctx, http.StatusOK)
+		}
+		return ""
+	}
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return handler, true
+	}
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), newMockMetrics(), mm)
+
+	yamlConfig := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+clientMaxBodySize: 10
+rules:
+- paths:
+  - path: /upload
+    backend: upload-pipeline
+`
+	superSpec, err := supervisor.NewSpec(yamlConfig)
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	serve := func(body string, size int64) int {
+		stdr := httptest.NewRequest(http.MethodPut, "/upload", strings.NewReader(body))
+		stdr.ContentLength = size
+		stdw := httptest.NewRecorder()
+		m.ServeHTTP(stdw, stdr)
+		return stdw.Code
+	}
+
+	// the clientMaxBodySize decides the strategy.
+	assert.Equal(http.StatusOK, serve("hello", 5))
+	assert.Equal(http.StatusRequestEntityTooLarge, serve("hello world", 11))
+
+	// the clientMaxBodySize is enforced for streams too.
+	handler.strategy = httpprot.BufferingStream
+	assert.Equal(http.StatusAccepted, serve("hello", 5))
+	assert.Equal(http.StatusRequestEntityTooLarge, serve("hello world", 11))
+	assert.Equal(http.StatusRequestEntityTooLarge, serve("hello world", -1))
+
+	handler.strategy = httpprot.BufferingFull
+	assert.Equal(http.StatusOK, serve("hello", 5))
+	assert.Equal(http.StatusRequestEntityTooLarge, serve("hello world", 11))
+
+	handler.strategy, handler.threshold = httpprot.BufferingThreshold, 4
+	assert.Equal(http.StatusAccepted, serve("hello", 5))
+	assert.Equal(http.StatusOK, serve("hell", 4))
+	assert.Equal(http.StatusAccepted, serve("hello", -1))
+	assert.Equal(http.StatusOK, serve("hel", -1))
+	assert.Equal(http.StatusRequestEntityTooLarge, serve("hello world", 11))
+	assert.Equal(http.StatusRequestEntityTooLarge, serve("hello world", -1))
+
+	// the body is not read if the request is rejected before approving it.
+	superSpec, err = supervisor.NewSpec(yamlConfig + "deferContinue: true\n")
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	serveExpect := func(body string, size int64) (int, int) {
+		bcr := readers.NewByteCountReader(strings.NewReader(body))
+		stdr := httptest.NewRequest(http.MethodPut, "/upload", bcr)
+		stdr.ContentLength = size
+		stdr.Header.Set("Expect", "100-continue")
+		stdw := httptest.NewRecorder()
+		m.ServeHTTP(stdw, stdr)
+		return stdw.Code, bcr.BytesRead()
+	}
+
+	for _, strategy := range []string{httpprot.BufferingStream, httpprot.BufferingThreshold} {
+		handler.strategy = strategy
+		reject = true
+		code, n := serveExpect("hello", 5)
+		assert.Equal(http.StatusUnauthorized, code, strategy)
+		assert.Equal(0, n, strategy)
+
+		reject = false
+		code, n = serveExpect("hello", 5)
+		assert.Equal(http.StatusAccepted, code, strategy)
+		assert.Equal(5, n, strategy)
+		code, _ = serveExpect("hello world", -1)
+		assert.Equal(http.StatusRequestEntityTooLarge, code, strategy)
+	}
+}
@@ -114,6 +114,8 @@ func (mp *muxPath) Rewrite(context *routers.RouteContext) {
 	}
 	r := context.Request
 	path := context.Path
+	// the rest of the path keeps its case if the case is folded.
+	original := context.OriginalPath()
 
 	if mp.Path.Path != "" && mp.Path.Path == path {
 		r.SetPath(mp.RewriteTarget)
@@ -121,13 +123,14 @@ func (mp *muxPath) Rewrite(context *routers.RouteContext) {
 	}
 
 	if mp.PathPrefix != "" && strings.HasPrefix(path, mp.PathPrefix) {
-		path = mp.RewriteTarget + path[len(mp.PathPrefix):]
+		path = mp.RewriteTarget + original[len(mp.PathPrefix):]
 		r.SetPath(path)
 		return
 	}
 
-	// sure (mp.pathRE != nil && mp.pathRE.MatchString(path)) is true
-	path = mp.pathRE.ReplaceAllString(path, mp.RewriteTarget)
+	// sure (mp.pathRE != nil && mp.pathRE.MatchString(path)) is true, and
+	// the regexp is case-insensitive if the case is folded.
+	path = mp.pathRE.ReplaceAllString(original, mp.RewriteTarget)
 	r.SetPath(path)
 }
 
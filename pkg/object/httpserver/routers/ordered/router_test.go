@@ -278,3 +278,57 @@ func TestRewrite(t *testing.T) {
 		assert.Equal("/bafo", req.Path())
 	})
 }
+
+func TestCaseInsensitive(t *testing.T) {
+	assert := assert.New(t)
+
+	rules := routers.Rules{{
+		Paths: []*routers.Path{
+			{
+				Path:    "/Foo",
+				Backend: "foo",
+			},
+			{
+				PathPrefix:    "/API/",
+				RewriteTarget: "/v1/",
+				Backend:       "api",
+			},
+			{
+				PathRegexp:    `^/Users/(\w+)$`,
+				RewriteTarget: "/u/$1",
+				Backend:       "users",
+			},
+		},
+	}}
+	rules.FoldCase()
+	rules.Init()
+	router := kind.CreateInstance(rules)
+
+	tests := []struct {
+		path    string
+		backend string
+		result  string
+	}{
+		{path: "/FOO", backend: "foo", result: "/FOO"},
+		{path: "/api/Items/X1", backend: "api", result: "/v1/Items/X1"},
+		{path: "/users/Alice", backend: "users", result: "/u/Alice"},
+		{path: "/bar"},
+	}
+
+	for _, test := range tests {
+		stdr, _ := http.NewRequest(http.MethodGet, test.path, nil)
+		req, _ := httpprot.NewRequest(stdr)
+		context := routers.NewContext(req)
+		context.FoldCase()
+		router.Search(context)
+
+		if test.backend == "" {
+			assert.Nil(context.Route, test.path)
+			continue
+		}
+		assert.NotNil(context.Route, test.path)
+		assert.Equal(test.backend, context.Route.GetBackend(), test.path)
+		context.Route.Rewrite(context)
+		assert.Equal(test.result, req.Path(), test.path)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package routers
+
+import (
+	"strings"
+)
+
+const (
+	// TrailingSlashDistinct treats paths with and without a trailing slash
+	// as different paths, this is the default.
+	TrailingSlashDistinct = "distinct"
+	// TrailingSlashEquivalent rewrites paths to the canonical form before
+	// routing, so both forms are routed as the canonical one.
+	TrailingSlashEquivalent = "equivalent"
+	// TrailingSlashRedirect redirects requests to the canonical form of
+	// their paths.
+	TrailingSlashRedirect = "redirect"
+)
+
+// PathMatching configures how paths are normalized and matched in routing.
+type PathMatching struct {
+	TrailingSlash string `json:"trailingSlash,omitempty" jsonschema:"enum=,enum=distinct,enum=equivalent,enum=redirect"`
+	// AppendSlash makes the path with a trailing slash the canonical form,
+	// the canonical form is the path without a trailing slash by default.
+	AppendSlash bool `json:"appendSlash,omitempty"`
+	// CaseInsensitive matches paths case-insensitively, only ASCII letters
+	// are folded.
+	CaseInsensitive bool `json:"caseInsensitive,omitempty"`
+}
+
+// Canonical returns the canonical form of path according to the trailing
+// slash configuration, the root path is always "/". It is safe to call it
+// on a nil PathMatching, which returns path as is.
+func (pm *PathMatching) Canonical(path string) string {
+	if pm == nil || pm.TrailingSlash == "" || pm.TrailingSlash == TrailingSlashDistinct {
+		return path
+	}
+	if path == "/" || path == "" {
+		return path
+	}
+
+	if pm.AppendSlash {
+		if !strings.HasSuffix(path, "/") {
+			return path + "/"
+		}
+		return path
+	}
+	if p := strings.TrimRight(path, "/"); p != "" {
+		return p
+	}
+	return "/"
+}
+
+// FoldCase prepares the rules for case-insensitive matching: the exact
+// paths and path prefixes are lowercased except their parameters, and the
+// path regexps are made case-insensitive. It must be called before Init.
+func (rules Rules) FoldCase() {
+	for _, rule := range rules {
+		for _, p := range rule.Paths {
+			p.Path = foldPattern(p.Path)
+			p.PathPrefix = foldPattern(p.PathPrefix)
+			if p.PathRegexp != "" && !strings.HasPrefix(p.PathRegexp, "(?i)") {
+				p.PathRegexp = "(?i)" + p.PathRegexp
+			}
+		}
+	}
+}
+
+// lowerASCII lowercases the ASCII letters of s, it keeps the length of s,
+// so the offsets of the result are also offsets of s.
+func lowerASCII(s string) string {
+	i := 0
+	for i < len(s) && (s[i] < 'A' || s[i] > 'Z') {
+		i++
+	}
+	if i == len(s) {
+		return s
+	}
+
+	b := []byte(s)
+	for ; i < len(b); i++ {
+		if 'A' <= b[i] && b[i] <= 'Z' {
+			b[i] += 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// foldPattern lowercases the ASCII letters of a path pattern, except the
+// parameters in braces like {userID} or {id:[A-Z]+}.
+func foldPattern(pattern string) string {
+	var sb strings.Builder
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case depth == 0 && 'A' <= c && c <= 'Z':
+			c += 'a' - 'A'
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package routers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathMatchingCanonical(t *testing.T) {
+	assert := assert.New(t)
+
+	var pm *PathMatching
+	assert.Equal("/users/", pm.Canonical("/users/"))
+
+	pm = &PathMatching{TrailingSlash: TrailingSlashDistinct}
+	assert.Equal("/users/", pm.Canonical("/users/"))
+
+	pm = &PathMatching{TrailingSlash: TrailingSlashEquivalent}
+	assert.Equal("/users", pm.Canonical("/users/"))
+	assert.Equal("/users", pm.Canonical("/users//"))
+	assert.Equal("/users", pm.Canonical("/users"))
+	assert.Equal("/", pm.Canonical("/"))
+	assert.Equal("/", pm.Canonical("//"))
+
+	pm = &PathMatching{TrailingSlash: TrailingSlashRedirect, AppendSlash: true}
+	assert.Equal("/users/", pm.Canonical("/users"))
+	assert.Equal("/users/", pm.Canonical("/users/"))
+	assert.Equal("/", pm.Canonical("/"))
+}
+
+func TestRulesFoldCase(t *testing.T) {
+	assert := assert.New(t)
+
+	rules := Rules{{
+		Paths: Paths{
+			{Path: "/Users/{userID}/Orders/{orderID:[A-Z]+}"},
+			{PathPrefix: "/API/"},
+			{PathRegexp: "^/Admin"},
+			{PathRegexp: "(?i)^/Admin"},
+		},
+	}}
+	rules.FoldCase()
+
+	paths := rules[0].Paths
+	assert.Equal("/users/{userID}/orders/{orderID:[A-Z]+}", paths[0].Path)
+	assert.Equal("/api/", paths[1].PathPrefix)
+	assert.Equal("(?i)^/Admin", paths[2].PathRegexp)
+	assert.Equal("(?i)^/Admin", paths[3].PathRegexp)
+}
+
+func TestRouteContextFoldCase(t *testing.T) {
+	assert := assert.New(t)
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://www.megaease.com/Users/Alice/é", nil)
+	req, _ := httpprot.NewRequest(stdr)
+	ctx := NewContext(req)
+	assert.Equal("Alice", ctx.ParamValue(ctx.Path[7:], 5))
+
+	ctx.FoldCase()
+	ctx.FoldCase()
+	assert.Equal("/users/alice/é", ctx.Path)
+	assert.Equal("/Users/Alice/é", ctx.OriginalPath())
+	assert.Equal("Alice", ctx.ParamValue(ctx.Path[7:], 5))
+	assert.Equal("é", ctx.ParamValue(ctx.Path[13:], 2))
+}
@@ -403,7 +403,7 @@ func (n *node) find(path string, context *routers.RouteContext) *muxPath {
 				}
 
 				if ntype == ntRegexp {
-					if !xn.rex.MatchString(path[:p]) {
+					if !xn.rex.MatchString(context.ParamValue(path, p)) {
 						continue
 					}
 				} else if strings.IndexByte(path[:p], '/') != -1 {
@@ -411,7 +411,7 @@ func (n *node) find(path string, context *routers.RouteContext) *muxPath {
 				}
 
 				prevlen := len(context.Params.Values)
-				context.Params.Values = append(context.Params.Values, path[:p])
+				context.Params.Values = append(context.Params.Values, context.ParamValue(path, p))
 
 				search := path[p:]
 				if len(search) == 0 && xn.isLeaf() {
@@ -429,7 +429,7 @@ func (n *node) find(path string, context *routers.RouteContext) *muxPath {
 		default:
 			xn := nds[0]
 			if r := xn.match(context); r != nil {
-				context.Params.Values = append(context.Params.Values, path)
+				context.Params.Values = append(context.Params.Values, context.ParamValue(path, len(path)))
 				return r
 			}
 		}
@@ -963,3 +963,62 @@ func TestRouteRewrite(t *testing.T) {
 
 	}
 }
+
+func TestCaseInsensitive(t *testing.T) {
+	assert := assert.New(t)
+
+	rules := routers.Rules{{
+		Paths: routers.Paths{
+			{
+				Path:    "/Users/{userID}/Orders/{orderID:[A-Z0-9]+}",
+				Backend: "orders",
+			},
+			{
+				Path:          "/Users/{userID}",
+				RewriteTarget: "/profiles/{userID}",
+				Backend:       "users",
+			},
+			{
+				Path:    "/Static/*",
+				Backend: "static",
+			},
+		},
+	}}
+	rules.FoldCase()
+	rules.Init()
+	router := kind.CreateInstance(rules)
+
+	tests := []struct {
+		path    string
+		backend string
+		values  []string
+	}{
+		{path: "/users/Alice/orders/AB12", backend: "orders", values: []string{"Alice", "AB12"}},
+		{path: "/USERS/Alice/ORDERS/AB12", backend: "orders", values: []string{"Alice", "AB12"}},
+		// the regexp of the parameter matches the original value.
+		{path: "/users/Alice/orders/ab12"},
+		{path: "/uSers/Bob", backend: "users", values: []string{"Bob"}},
+		{path: "/STATIC/CSS/Main.css", backend: "static", values: []string{"CSS/Main.css"}},
+	}
+
+	for _, test := range tests {
+		stdr, _ := http.NewRequest(http.MethodGet, test.path, nil)
+		req, _ := httpprot.NewRequest(stdr)
+		context := routers.NewContext(req)
+		context.FoldCase()
+		router.Search(context)
+
+		if test.backend == "" {
+			assert.Nil(context.Route, test.path)
+			continue
+		}
+		assert.NotNil(context.Route, test.path)
+		assert.Equal(test.backend, context.Route.GetBackend(), test.path)
+		assert.Equal(test.values, context.Params.Values, test.path)
+
+		context.Route.Rewrite(context)
+		if test.backend == "users" {
+			assert.Equal("/profiles/Bob", req.Path())
+		}
+	}
+}
@@ -68,6 +68,9 @@ type (
 		GetPathPrefix() string
 		// GetPathRegexp is used to get the path regexp corresponding to the route.
 		GetPathRegexp() string
+		// GetPathTemplate is used to get the path template corresponding to the route,
+		// which has a low cardinality and could be used as a metric label.
+		GetPathTemplate() string
 	}
 
 	// Params are used to store the variables in the search path and their corresponding values.
@@ -79,8 +82,12 @@ type (
 	RouteContext struct {
 		// Request is httpprot.Request.
 		Request *httpprot.Request
-		// Path represents the path of the request.
+		// Path represents the path of the request, which is lowercased if
+		// the case is folded.
 		Path string
+		// rawPath is the path before folding the case, it is empty if the
+		// case is not folded.
+		rawPath string
 		// Method represents the MethodType corresponding to the http method.
 		Method  MethodType
 		host    string
@@ -192,6 +199,35 @@ func (ctx *RouteContext) GetCaptures() map[string]string {
 	return ctx.captures
 }
 
+// FoldCase lowercases the path for case-insensitive matching, the original
+// path is kept for path parameters and rewriting.
+func (ctx *RouteContext) FoldCase() {
+	if ctx.rawPath != "" {
+		return
+	}
+	ctx.rawPath = ctx.Path
+	ctx.Path = lowerASCII(ctx.Path)
+}
+
+// OriginalPath returns the path before folding the case.
+func (ctx *RouteContext) OriginalPath() string {
+	if ctx.rawPath != "" {
+		return ctx.rawPath
+	}
+	return ctx.Path
+}
+
+// ParamValue returns the value of a path parameter, which is the first n
+// bytes of rest, and rest must be a suffix of Path. The value is taken from
+// the original path, so its case is preserved even if the case is folded.
+func (ctx *RouteContext) ParamValue(rest string, n int) string {
+	if ctx.rawPath == "" {
+		return rest[:n]
+	}
+	start := len(ctx.Path) - len(rest)
+	return ctx.rawPath[start : start+n]
+}
+
 // GetHost is used to get and cache host.
 func (ctx *RouteContext) GetHost() string {
 	if ctx.host != "" {
@@ -290,6 +290,22 @@ func (p *Path) GetPathRegexp() string {
 	return p.PathRegexp
 }
 
+// GetPathTemplate returns the path template of the route, which is the
+// exact path (it may contain parameters like /users/{id} in RadixTree), the
+// path prefix followed by a "*", or the path regexp. It returns "*" if the
+// route matches all paths.
+func (p *Path) GetPathTemplate() string {
+	switch {
+	case p.Path != "":
+		return p.Path
+	case p.PathPrefix != "":
+		return p.PathPrefix + "*"
+	case p.PathRegexp != "":
+		return p.PathRegexp
+	}
+	return "*"
+}
+
 func (hs Headers) init() {
 	for _, h := range hs {
 		if h.Regexp != "" {
@@ -237,6 +237,20 @@ func TestPathValidate(t *testing.T) {
 	assert.NoError(t, p.Validate())
 }
 
+func TestPathGetPathTemplate(t *testing.T) {
+	p := &Path{}
+	assert.Equal(t, "*", p.GetPathTemplate())
+
+	p.PathRegexp = "^/foo/[0-9]+$"
+	assert.Equal(t, "^/foo/[0-9]+$", p.GetPathTemplate())
+
+	p.PathPrefix = "/foo/"
+	assert.Equal(t, "/foo/*", p.GetPathTemplate())
+
+	p.Path = "/foo/{id}"
+	assert.Equal(t, "/foo/{id}", p.GetPathTemplate())
+}
+
 func TestPathInit2(t *testing.T) {
 	assert := assert.New(t)
 
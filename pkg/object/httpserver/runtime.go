@@ -22,6 +22,7 @@ import (
 	stdcontext "context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"reflect"
@@ -92,6 +93,8 @@ type (
 		topN          *httpstat.TopN
 		metrics       *metrics
 		limitListener *limitlistener.LimitListener
+		connLimiter   *connLimiter
+		streams       *streamCounter
 	}
 
 	// Status contains all status generated by runtime, for displaying to users.
@@ -104,6 +107,10 @@ type (
 
 		*httpstat.Status
 		TopN []*httpstat.Item `json:"topN"`
+
+		// HTTP2 is the status of the HTTP/2 streams being handled, it is
+		// nil if there's none.
+		HTTP2 *HTTP2Status `json:"http2,omitempty"`
 	}
 )
 
@@ -116,6 +123,8 @@ func newRuntime(superSpec *supervisor.Spec, muxMapper context.MuxMapper) *runtim
 	}
 
 	r.metrics = r.newMetrics(r.superSpec.Name())
+	r.connLimiter = newConnLimiter(r.onConnectionRejected)
+	r.streams = newStreamCounter()
 	r.mux = newMux(r.httpStat, r.topN, r.metrics, muxMapper)
 	r.setState(stateNil)
 	r.setError(errNil)
@@ -145,6 +154,7 @@ func (r *runtime) Status() *Status {
 		Error:  r.getError().Error(),
 		Status: status,
 		TopN:   r.topN.Status(),
+		HTTP2:  r.streams.status(),
 	}
 }
 
@@ -180,6 +190,9 @@ func (r *runtime) reload(nextSuperSpec *supervisor.Spec, muxMapper context.MuxMa
 	if nextSpec != nil && r.limitListener != nil {
 		r.limitListener.SetMaxConnection(nextSpec.MaxConnections)
 	}
+	if nextSpec != nil {
+		r.connLimiter.update(nextSpec.ConnectionsPerIP)
+	}
 
 	// NOTE: Due to the mechanism of supervisor,
 	// nextSpec must not be nil, just defensive programming here.
@@ -237,6 +250,7 @@ func (r *runtime) needRestartServer(nextSpec *Spec) bool {
 
 	// The change of options below need not restart the HTTP server.
 	x.MaxConnections, y.MaxConnections = 0, 0
+	x.ConnectionsPerIP, y.ConnectionsPerIP = nil, nil
 	x.CacheSize, y.CacheSize = 0, 0
 	x.XForwardedFor, y.XForwardedFor = false, false
 	x.Tracing, y.Tracing = nil, nil
@@ -278,6 +292,9 @@ func (r *runtime) startHTTP3Server() {
 	if r.spec.KeepAlive {
 		r.server3.QuicConfig.KeepAlivePeriod = keepAliveTimeout
 	}
+	if r.spec.HeaderLimits != nil {
+		r.server3.MaxHeaderBytes = r.spec.HeaderLimits.maxHeaderBytes()
+	}
 
 	// to avoid data race
 	roundNum := r.roundNum
@@ -302,13 +319,22 @@ func (r *runtime) startHTTP1And2Server() {
 	fw := filterwriter.New(os.Stderr, func(p []byte) bool {
 		return !bytes.Contains(p, []byte("TLS handshake error"))
 	})
+	// the streams are counted only if HTTP/2 is configured explicitly.
+	var handler http.Handler = r.mux
+	if r.spec.HTTP2 != nil {
+		handler = r.streams.handler(r.mux)
+	}
 	r.server = &http.Server{
 		Addr:        fmt.Sprintf("%s:%d", r.spec.Address, r.spec.Port),
-		Handler:     r.mux,
+		Handler:     handler,
 		IdleTimeout: keepAliveTimeout,
 		ErrorLog:    log.New(fw, "", log.LstdFlags),
 	}
 	r.server.SetKeepAlivesEnabled(r.spec.KeepAlive)
+	// it also limits the size of header lists of HTTP/2.
+	if r.spec.HeaderLimits != nil {
+		r.server.MaxHeaderBytes = r.spec.HeaderLimits.maxHeaderBytes()
+	}
 
 	listener, err := gnet.Listen("tcp", fmt.Sprintf("%s:%d", r.spec.Address, r.spec.Port))
 	if err != nil {
@@ -320,6 +346,13 @@ func (r *runtime) startHTTP1And2Server() {
 	limitListener := limitlistener.NewLimitListener(listener, r.spec.MaxConnections)
 	r.limitListener = limitListener
 
+	// the listener is always wrapped, so that the limit of connections
+	// per IP could be enabled without restarting the server.
+	var srvListener net.Listener = &connLimitListener{Listener: limitListener, limiter: r.connLimiter}
+	if r.spec.SmugglingDefense != "" {
+		srvListener = newFramingListener(srvListener, r.spec.SmugglingDefense, r.onSmugglingRejected)
+	}
+
 	// to avoid data race
 	spec := r.spec
 	roundNum := r.roundNum
@@ -330,9 +363,14 @@ func (r *runtime) startHTTP1And2Server() {
 		if spec.HTTPS {
 			tlsConfig, _ := spec.tlsConfig()
 			srv.TLSConfig = tlsConfig
-			err = srv.ServeTLS(limitListener, "", "")
+			if spec.HTTP2 != nil {
+				err = spec.HTTP2.configure(srv)
+			}
+			if err == nil {
+				err = srv.ServeTLS(srvListener, "", "")
+			}
 		} else {
-			err = srv.Serve(limitListener)
+			err = srv.Serve(srvListener)
 		}
 		if err != http.ErrServerClosed {
 			r.eventChan <- &eventServeFailed{
@@ -343,6 +381,19 @@ func (r *runtime) startHTTP1And2Server() {
 	}()
 }
 
+// onSmugglingRejected is called when a request is rejected for ambiguous
+// framing.
+func (r *runtime) onSmugglingRejected(reason string) {
+	logger.Debugf("httpserver %s rejected a request for ambiguous framing: %s", r.superSpec.Name(), reason)
+	r.metrics.SmugglingRejected.WithLabelValues(reason).Inc()
+}
+
+// onConnectionRejected is called when a connection is refused for exceeding
+// the limit of connections per IP.
+func (r *runtime) onConnectionRejected() {
+	r.metrics.ConnectionsRejected.WithLabelValues().Inc()
+}
+
 func (r *runtime) closeServer() {
 	if r.server3 != nil {
 		err := r.server3.Close()
@@ -460,6 +511,11 @@ type (
 		P999          *prometheus.GaugeVec
 		ReqSize       *prometheus.GaugeVec
 		RespSize      *prometheus.GaugeVec
+
+		SmugglingRejected   *prometheus.CounterVec
+		ConnectionsRejected *prometheus.CounterVec
+		MalformedHeaders    *prometheus.CounterVec
+		HeaderLimitRejected *prometheus.CounterVec
 	}
 )
 
@@ -474,7 +530,7 @@ func (r *runtime) newMetrics(name string) *metrics {
 	}
 	httpserverLabels := []string{
 		"clusterName", "clusterRole",
-		"instanceName", "httpServerName", "kind", "routerKind", "backend",
+		"instanceName", "httpServerName", "kind", "routerKind", "backend", "path",
 	}
 	return &metrics{
 		Health: prometheushelper.NewGauge(
@@ -619,6 +675,22 @@ func (r *runtime) newMetrics(name string) *metrics {
 			"httpserver_resp_size",
 			"The total size of the http responses in this statistic window",
 			httpserverLabels[:5]).MustCurryWith(commonLabels),
+		SmugglingRejected: prometheushelper.NewCounter(
+			"httpserver_smuggling_rejected_requests",
+			"the total count of http requests rejected for ambiguous framing",
+			append(httpserverLabels[:5:5], "reason")).MustCurryWith(commonLabels),
+		ConnectionsRejected: prometheushelper.NewCounter(
+			"httpserver_rejected_connections",
+			"the total count of connections refused for exceeding the limit of connections per IP",
+			httpserverLabels[:5]).MustCurryWith(commonLabels),
+		MalformedHeaders: prometheushelper.NewCounter(
+			"httpserver_malformed_header_requests",
+			"the total count of http requests with malformed header values",
+			append(httpserverLabels[:5:5], "action")).MustCurryWith(commonLabels),
+		HeaderLimitRejected: prometheushelper.NewCounter(
+			"httpserver_header_limit_rejected_requests",
+			"the total count of http requests rejected for exceeding the limits of headers",
+			append(httpserverLabels[:5:5], "reason")).MustCurryWith(commonLabels),
 	}
 }
 
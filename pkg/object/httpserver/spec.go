@@ -22,11 +22,14 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"fmt"
+	"net/http"
 
 	"github.com/megaease/easegress/v2/pkg/object/autocertmanager"
 	"github.com/megaease/easegress/v2/pkg/object/httpserver/routers"
 	"github.com/megaease/easegress/v2/pkg/tracing"
 	"github.com/megaease/easegress/v2/pkg/util/ipfilter"
+	"github.com/megaease/easegress/v2/pkg/util/pathnormalizer"
+	"github.com/megaease/easegress/v2/pkg/util/querynormalizer"
 )
 
 type (
@@ -58,17 +61,99 @@ type (
 
 		RouterKind string `json:"routerKind,omitempty" jsonschema:"enum=,enum=Ordered,enum=RadixTree"`
 
-		IPFilter *ipfilter.Spec `json:"ipFilter,omitempty"`
-		Rules    routers.Rules  `json:"rules,omitempty"`
+		IPFilter        *ipfilter.Spec        `json:"ipFilter,omitempty"`
+		PathNormalizer  *pathnormalizer.Spec  `json:"pathNormalizer,omitempty"`
+		QueryNormalizer *querynormalizer.Spec `json:"queryNormalizer,omitempty"`
+		PathMatching    *routers.PathMatching `json:"pathMatching,omitempty"`
+		Rules           routers.Rules         `json:"rules,omitempty"`
 
 		GlobalFilter string `json:"globalFilter,omitempty"`
 
-		AccessLogFormat string `json:"accessLogFormat,omitempty"`
+		AccessLogFormat string             `json:"accessLogFormat,omitempty"`
+		AccessLogBody   *AccessLogBodySpec `json:"accessLogBody,omitempty"`
+
+		// MetricsPathLabel is the source of the path label of metrics,
+		// which is the path template of the matched route by default, as
+		// the concrete path may explode the cardinality of metrics.
+		MetricsPathLabel string `json:"metricsPathLabel,omitempty" jsonschema:"enum=,enum=template,enum=path"`
+
+		// SmugglingDefense rejects HTTP/1.x requests with ambiguous framing
+		// before routing, it is disabled if empty.
+		SmugglingDefense string `json:"smugglingDefense,omitempty" jsonschema:"enum=,enum=strict,enum=lenient"`
+
+		// MalformedHeaders is the handling of header values with invalid
+		// UTF-8 or control characters, it is applied before routing, so
+		// filters never see malformed values.
+		MalformedHeaders string `json:"malformedHeaders,omitempty" jsonschema:"enum=,enum=reject,enum=strip,enum=encode,enum=allow"`
+
+		// HeaderLimits limits the size and count of request headers,
+		// requests violating the limits are rejected before routing.
+		HeaderLimits *HeaderLimitsSpec `json:"headerLimits,omitempty"`
+
+		// ConnectionsPerIP limits the concurrent connections of a client
+		// IP, connections exceeding the limit are refused.
+		ConnectionsPerIP *ConnectionsPerIPSpec `json:"connectionsPerIP,omitempty"`
+
+		// DeferContinue defers the 100 Continue response of requests with
+		// "Expect: 100-continue" until the body is approved by a filter,
+		// so that rejected clients never upload their bodies.
+		DeferContinue bool `json:"deferContinue,omitempty"`
+
+		// HTTP2 tunes the stream limits and the flow control of HTTP/2
+		// connections, Go's defaults are used if it is nil.
+		HTTP2 *HTTP2Spec `json:"http2,omitempty"`
 	}
 )
 
+const (
+	// MetricsPathLabelTemplate uses the path template of the matched route
+	// as the path label of metrics.
+	MetricsPathLabelTemplate = "template"
+	// MetricsPathLabelPath uses the concrete path of the request as the
+	// path label of metrics.
+	MetricsPathLabelPath = "path"
+)
+
 // Validate validates HTTPServerSpec.
 func (spec *Spec) Validate() error {
+	if spec.AccessLogBody != nil {
+		if err := spec.AccessLogBody.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if spec.HeaderLimits != nil {
+		if err := spec.HeaderLimits.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if spec.ConnectionsPerIP != nil {
+		if spec.HTTP3 {
+			// QUIC connections are not accepted by a net.Listener.
+			return fmt.Errorf("connectionsPerIP is not supported when http3 enabled")
+		}
+		if err := spec.ConnectionsPerIP.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if spec.HTTP2 != nil {
+		// HTTP/2 is negotiated by TLS, and HTTP/3 doesn't use it.
+		if !spec.HTTPS || spec.HTTP3 {
+			return fmt.Errorf("http2 requires https and is not supported when http3 enabled")
+		}
+		if err := spec.HTTP2.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if spec.SmugglingDefense != "" && spec.HTTPS {
+		// the requests can't be inspected without taking over the TLS
+		// connections from the HTTP server, which breaks HTTP/2.
+		return fmt.Errorf("smugglingDefense is not supported when https enabled")
+	}
+
 	if !spec.HTTPS {
 		if spec.HTTP3 {
 			return fmt.Errorf("https is disabled when http3 enabled")
@@ -79,8 +164,17 @@ func (spec *Spec) Validate() error {
 	if spec.CertBase64 == "" && spec.KeyBase64 == "" && len(spec.Certs) == 0 && len(spec.Keys) == 0 && !spec.AutoCert {
 		return fmt.Errorf("certBase64/keyBase64, certs/keys are both empty and autocert is disabled when https enabled")
 	}
-	_, err := spec.tlsConfig()
-	return err
+	tlsConfig, err := spec.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	if spec.HTTP2 != nil {
+		if err = spec.HTTP2.configure(&http.Server{TLSConfig: tlsConfig}); err != nil {
+			return fmt.Errorf("invalid http2: %v", err)
+		}
+	}
+	return nil
 }
 
 func tryDecodeBase64Pem(pem string) []byte {
@@ -0,0 +1,369 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/megaease/easegress/v2/pkg/api"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+)
+
+const (
+	// resultUnresolved is the result of the pipeline when the included
+	// fragments are unavailable.
+	resultUnresolved = "unresolved"
+
+	// FragmentCategory is the category of PipelineFragment.
+	FragmentCategory = supervisor.CategoryBusinessController
+
+	// FragmentKind is the kind of PipelineFragment.
+	FragmentKind = "PipelineFragment"
+)
+
+func init() {
+	supervisor.Register(&Fragment{})
+	api.RegisterObject(&api.APIResource{
+		Category: FragmentCategory,
+		Kind:     FragmentKind,
+		Name:     strings.ToLower(FragmentKind),
+		Aliases:  []string{"pipelinefragments", "plf"},
+	})
+}
+
+type (
+	// Fragment is the business controller PipelineFragment, it holds a
+	// group of filters which could be included by pipelines.
+	Fragment struct {
+		superSpec *supervisor.Spec
+		spec      *FragmentSpec
+	}
+
+	// FragmentSpec describes the PipelineFragment.
+	FragmentSpec struct {
+		Filters []map[string]interface{} `json:"filters" jsonschema:"required"`
+		// Flow is the flow of the filters, the filters are run in the
+		// order of their definition if it is empty. The targets of the
+		// JumpIfs must be in the fragment too, or be END.
+		Flow []FlowNode `json:"flow,omitempty"`
+	}
+
+	// Include describes a fragment included by a pipeline.
+	Include struct {
+		Fragment string `json:"fragment" jsonschema:"required,format=urlname"`
+		// Overrides are the per-pipeline tweaks of the filters in the
+		// fragment, an override is matched with a filter by name, and
+		// replaces the top-level fields of the filter spec. The kind of
+		// a filter can't be overridden.
+		Overrides []map[string]interface{} `json:"overrides,omitempty"`
+	}
+
+	// fragmentLookup returns the spec of the fragment.
+	fragmentLookup func(name string) (*FragmentSpec, error)
+
+	// fragmentUsers records the pipelines including each fragment.
+	fragmentUsers struct {
+		mu    sync.Mutex
+		users map[string]map[*Pipeline]struct{}
+	}
+)
+
+// newFragmentLookup returns the lookup used to load pipelines, it is a
+// variable so that tests could replace it.
+var newFragmentLookup = superFragmentLookup
+
+// users records the pipelines including the fragments, so that fragments
+// in use are not deleted.
+var users = &fragmentUsers{users: map[string]map[*Pipeline]struct{}{}}
+
+// Validate validates FragmentSpec.
+func (s *FragmentSpec) Validate() error {
+	spec := &Spec{Filters: s.Filters, Flow: s.Flow}
+	return spec.Validate()
+}
+
+// flow returns the flow of the fragment.
+func (s *FragmentSpec) flow() []FlowNode {
+	if len(s.Flow) > 0 {
+		return append([]FlowNode(nil), s.Flow...)
+	}
+
+	flow := make([]FlowNode, 0, len(s.Filters))
+	for _, f := range s.Filters {
+		name, _ := f["name"].(string)
+		flow = append(flow, FlowNode{FilterName: name})
+	}
+	return flow
+}
+
+// filters returns the filters of the fragment with the overrides applied.
+func (inc *Include) filters(fragment *FragmentSpec) ([]map[string]interface{}, error) {
+	overrides := map[string]map[string]interface{}{}
+	for _, o := range inc.Overrides {
+		name, _ := o["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("fragment %s: override without filter name", inc.Fragment)
+		}
+		if _, ok := overrides[name]; ok {
+			return nil, fmt.Errorf("fragment %s: duplicated override of filter %s", inc.Fragment, name)
+		}
+		overrides[name] = o
+	}
+
+	result := make([]map[string]interface{}, 0, len(fragment.Filters))
+	for _, f := range fragment.Filters {
+		name, _ := f["name"].(string)
+		o := overrides[name]
+		if o == nil {
+			result = append(result, f)
+			continue
+		}
+		delete(overrides, name)
+
+		if kind, ok := o["kind"]; ok && kind != f["kind"] {
+			return nil, fmt.Errorf("fragment %s: can't override the kind of filter %s", inc.Fragment, name)
+		}
+
+		merged := make(map[string]interface{}, len(f)+len(o))
+		for k, v := range f {
+			merged[k] = v
+		}
+		for k, v := range o {
+			merged[k] = v
+		}
+		result = append(result, merged)
+	}
+
+	for name := range overrides {
+		return nil, fmt.Errorf("fragment %s: filter %s to override not found", inc.Fragment, name)
+	}
+	return result, nil
+}
+
+// expand returns a copy of the spec with the included fragments expanded,
+// or the spec itself if it includes nothing.
+//
+// The filters of the fragments are added before the filters of the
+// pipeline, and a flow node whose filter is the name of an included
+// fragment is replaced by the flow of the fragment. If the pipeline does
+// not define a flow, the fragments are run first, in the order they are
+// included.
+func (s *Spec) expand(lookup fragmentLookup) (*Spec, error) {
+	if len(s.Includes) == 0 {
+		return s, nil
+	}
+
+	expanded := *s
+	expanded.Includes = nil
+	expanded.Filters = nil
+
+	ownFilters := map[string]bool{}
+	for _, f := range s.Filters {
+		name, _ := f["name"].(string)
+		ownFilters[name] = true
+	}
+
+	fragmentFlows := map[string][]FlowNode{}
+	var flow []FlowNode
+	for _, inc := range s.Includes {
+		if _, ok := fragmentFlows[inc.Fragment]; ok {
+			return nil, fmt.Errorf("fragment %s is included more than once", inc.Fragment)
+		}
+		if ownFilters[inc.Fragment] {
+			return nil, fmt.Errorf("fragment %s conflicts with the filter of the same name", inc.Fragment)
+		}
+
+		fragment, err := lookup(inc.Fragment)
+		if err != nil {
+			return nil, err
+		}
+		filters, err := inc.filters(fragment)
+		if err != nil {
+			return nil, err
+		}
+
+		expanded.Filters = append(expanded.Filters, filters...)
+		fragmentFlows[inc.Fragment] = fragment.flow()
+		flow = append(flow, fragmentFlows[inc.Fragment]...)
+	}
+	expanded.Filters = append(expanded.Filters, s.Filters...)
+
+	expandFlow := func(flow []FlowNode) ([]FlowNode, error) {
+		result := make([]FlowNode, 0, len(flow))
+		for _, node := range flow {
+			nodes, ok := fragmentFlows[node.FilterName]
+			if !ok {
+				result = append(result, node)
+				continue
+			}
+			if node.FilterAlias != "" || len(node.JumpIf) > 0 {
+				return nil, fmt.Errorf("fragment %s: alias and jumpIf are not allowed", node.FilterName)
+			}
+			result = append(result, nodes...)
+		}
+		return result, nil
+	}
+
+	var err error
+	if len(s.Flow) == 0 {
+		// build the flow here, or the flows of the fragments are lost.
+		inResponseFlow := map[string]bool{}
+		for i := range s.ResponseFlow {
+			inResponseFlow[s.ResponseFlow[i].FilterName] = true
+		}
+		for _, f := range s.Filters {
+			name, _ := f["name"].(string)
+			if !inResponseFlow[name] {
+				flow = append(flow, FlowNode{FilterName: name})
+			}
+		}
+		expanded.Flow = flow
+	} else if expanded.Flow, err = expandFlow(s.Flow); err != nil {
+		return nil, err
+	}
+	if expanded.ResponseFlow, err = expandFlow(s.ResponseFlow); err != nil {
+		return nil, err
+	}
+
+	if err = expanded.Validate(); err != nil {
+		return nil, err
+	}
+	return &expanded, nil
+}
+
+// superFragmentLookup returns the lookup of the fragments running in the
+// supervisor.
+func superFragmentLookup(super *supervisor.Supervisor) fragmentLookup {
+	return func(name string) (*FragmentSpec, error) {
+		if super == nil {
+			return nil, fmt.Errorf("fragment %s not found", name)
+		}
+		entity, exists := super.GetBusinessController(name)
+		if !exists {
+			return nil, fmt.Errorf("fragment %s not found", name)
+		}
+		fragment, ok := entity.Instance().(*Fragment)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a %s", name, FragmentKind)
+		}
+		return fragment.spec, nil
+	}
+}
+
+// add records the pipeline includes its fragments.
+func (fu *fragmentUsers) add(p *Pipeline) {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+
+	for _, inc := range p.spec.Includes {
+		pipelines := fu.users[inc.Fragment]
+		if pipelines == nil {
+			pipelines = map[*Pipeline]struct{}{}
+			fu.users[inc.Fragment] = pipelines
+		}
+		pipelines[p] = struct{}{}
+	}
+}
+
+// remove removes the records of the pipeline.
+func (fu *fragmentUsers) remove(p *Pipeline) {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+
+	for _, inc := range p.spec.Includes {
+		pipelines := fu.users[inc.Fragment]
+		delete(pipelines, p)
+		if len(pipelines) == 0 {
+			delete(fu.users, inc.Fragment)
+		}
+	}
+}
+
+// get returns the sorted names of the pipelines including the fragment.
+func (fu *fragmentUsers) get(fragment string) []string {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+
+	names := make([]string, 0, len(fu.users[fragment]))
+	for p := range fu.users[fragment] {
+		names = append(names, p.superSpec.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateHook validates the included fragments exist, and the expanded
+// pipeline is valid. It also prevents deleting the fragments which are
+// still included by pipelines.
+func validateHook(operationType api.OperationType, spec *supervisor.Spec) error {
+	if operationType == api.OperationTypeDelete {
+		if spec.Kind() != FragmentKind {
+			return nil
+		}
+		if names := users.get(spec.Name()); len(names) > 0 {
+			return fmt.Errorf("fragment %s is included by pipelines %v", spec.Name(), names)
+		}
+		return nil
+	}
+
+	if spec.Kind() != Kind {
+		return nil
+	}
+	_, err := spec.ObjectSpec().(*Spec).expand(superFragmentLookup(supervisor.GetGlobalSuper()))
+	return err
+}
+
+// Category returns the category of PipelineFragment.
+func (f *Fragment) Category() supervisor.ObjectCategory {
+	return FragmentCategory
+}
+
+// Kind returns the kind of PipelineFragment.
+func (f *Fragment) Kind() string {
+	return FragmentKind
+}
+
+// DefaultSpec returns the default spec of PipelineFragment.
+func (f *Fragment) DefaultSpec() interface{} {
+	return &FragmentSpec{}
+}
+
+// Status returns the status of PipelineFragment.
+func (f *Fragment) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: struct{}{},
+	}
+}
+
+// Init initializes PipelineFragment.
+func (f *Fragment) Init(superSpec *supervisor.Spec) {
+	f.superSpec, f.spec = superSpec, superSpec.ObjectSpec().(*FragmentSpec)
+}
+
+// Inherit inherits previous generation of PipelineFragment. The pipelines
+// including the fragment are not changed until they are reloaded.
+func (f *Fragment) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	f.Init(superSpec)
+}
+
+// Close closes PipelineFragment.
+func (f *Fragment) Close() {
+}
@@ -0,0 +1,280 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pipeline
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/api"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/tracing"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncludeFragments(t *testing.T) {
+	assert := assert.New(t)
+
+	filters.Register(MockFilterKind("Auth", []string{"unauthorized"}))
+	filters.Register(MockFilterKind("RateLimiter", nil))
+	filters.Register(MockFilterKind("Proxy", nil))
+	defer cleanup()
+
+	fragments := map[string]*FragmentSpec{}
+	addFragment := func(name, yamlConfig string) {
+		spec, err := supervisor.NewSpec(yamlConfig)
+		assert.Nil(err)
+		fragments[name] = spec.ObjectSpec().(*FragmentSpec)
+	}
+	lookup := func(name string) (*FragmentSpec, error) {
+		if f := fragments[name]; f != nil {
+			return f, nil
+		}
+		return nil, fmt.Errorf("fragment %s not found", name)
+	}
+
+	addFragment("common", `
+name: common
+kind: PipelineFragment
+flow:
+- filter: auth
+  jumpIf: { unauthorized: END }
+- filter: limiter
+filters:
+- name: auth
+  kind: Auth
+- name: limiter
+  kind: RateLimiter
+  rate: 10
+`)
+
+	// the targets of the JumpIfs must be in the fragment.
+	_, err := supervisor.NewSpec(`
+name: bad
+kind: PipelineFragment
+flow:
+- filter: auth
+  jumpIf: { unauthorized: proxy }
+filters:
+- name: auth
+  kind: Auth
+`)
+	assert.NotNil(err)
+
+	newSpec := func(yamlConfig string) *Spec {
+		spec, err := supervisor.NewSpec(yamlConfig)
+		assert.Nil(err)
+		return spec.ObjectSpec().(*Spec)
+	}
+
+	// no flow, the fragments run first.
+	spec := newSpec(`
+name: pipeline
+kind: Pipeline
+includes:
+- fragment: common
+filters:
+- name: proxy
+  kind: Proxy
+`)
+	expanded, err := spec.expand(lookup)
+	assert.Nil(err)
+	assert.Equal(3, len(expanded.Filters))
+	assert.Equal([]string{"auth", "limiter", "proxy"}, flowNames(expanded.Flow))
+	assert.Equal("END", expanded.Flow[0].JumpIf["unauthorized"])
+	assert.Equal(1, len(spec.Filters))
+
+	// the fragment is expanded in place, with the overrides applied.
+	spec = newSpec(`
+name: pipeline
+kind: Pipeline
+includes:
+- fragment: common
+  overrides:
+  - name: limiter
+    rate: 100
+flow:
+- filter: proxy
+  alias: first
+- filter: common
+- filter: proxy
+filters:
+- name: proxy
+  kind: Proxy
+`)
+	expanded, err = spec.expand(lookup)
+	assert.Nil(err)
+	assert.Equal([]string{"proxy", "auth", "limiter", "proxy"}, flowNames(expanded.Flow))
+	assert.EqualValues(100, expanded.Filters[1]["rate"])
+	assert.EqualValues(10, fragments["common"].Filters[1]["rate"])
+
+	// the spec itself is returned if it includes nothing.
+	spec = newSpec(`
+name: pipeline
+kind: Pipeline
+filters:
+- name: proxy
+  kind: Proxy
+`)
+	expanded, err = spec.expand(lookup)
+	assert.Nil(err)
+	assert.Same(spec, expanded)
+
+	// invalid includes.
+	for _, includes := range []string{
+		`[{fragment: missing}]`,
+		`[{fragment: common}, {fragment: common}]`,
+		`[{fragment: proxy}]`,
+		`[{fragment: common, overrides: [{name: unknown, rate: 1}]}]`,
+		`[{fragment: common, overrides: [{name: limiter, kind: Auth}]}]`,
+		`[{fragment: common, overrides: [{rate: 1}]}]`,
+	} {
+		spec := &Spec{}
+		codectool.MustUnmarshal([]byte(`
+includes: `+includes+`
+filters:
+- name: proxy
+  kind: Proxy
+`), spec)
+		_, err := spec.expand(lookup)
+		assert.NotNil(err, includes)
+	}
+
+	// a filter of the fragment conflicts with the filter of the pipeline.
+	spec = newSpec(`
+name: pipeline
+kind: Pipeline
+includes:
+- fragment: common
+filters:
+- name: auth
+  kind: Proxy
+`)
+	_, err = spec.expand(lookup)
+	assert.NotNil(err)
+
+	// jumpIf to a fragment is not allowed.
+	spec = newSpec(`
+name: pipeline
+kind: Pipeline
+includes:
+- fragment: common
+flow:
+- filter: common
+  jumpIf: { unauthorized: END }
+filters:
+- name: proxy
+  kind: Proxy
+`)
+	_, err = spec.expand(lookup)
+	assert.NotNil(err)
+
+	// the fragment is not running.
+	spec = newSpec(`
+name: pipeline
+kind: Pipeline
+includes:
+- fragment: common
+filters:
+- name: proxy
+  kind: Proxy
+`)
+	_, err = spec.expand(superFragmentLookup(nil))
+	assert.NotNil(err)
+}
+
+func flowNames(flow []FlowNode) []string {
+	names := make([]string, 0, len(flow))
+	for _, node := range flow {
+		names = append(names, node.FilterName)
+	}
+	return names
+}
+
+func TestUnresolvedFragments(t *testing.T) {
+	assert := assert.New(t)
+
+	filters.Register(MockFilterKind("Auth", nil))
+	filters.Register(MockFilterKind("Proxy", nil))
+	defer cleanup()
+
+	fragments := map[string]*FragmentSpec{}
+	newFragmentLookup = func(super *supervisor.Supervisor) fragmentLookup {
+		return func(name string) (*FragmentSpec, error) {
+			if f := fragments[name]; f != nil {
+				return f, nil
+			}
+			return nil, fmt.Errorf("fragment %s not found", name)
+		}
+	}
+	defer func() { newFragmentLookup = superFragmentLookup }()
+
+	superSpec, err := supervisor.NewSpec(`
+name: pipeline
+kind: Pipeline
+includes:
+- fragment: common
+filters:
+- name: proxy
+  kind: Proxy
+`)
+	assert.Nil(err)
+
+	// the pipeline is created before the fragment.
+	pipeline := &Pipeline{}
+	assert.NotPanics(func() { pipeline.Init(superSpec, nil) })
+
+	newCtx := func() *context.Context {
+		stdReq, _ := http.NewRequest(http.MethodGet, "http://localhost:9095", nil)
+		req, _ := httpprot.NewRequest(stdReq)
+		ctx := context.New(tracing.NoopSpan)
+		ctx.SetRequest(context.DefaultNamespace, req)
+		return ctx
+	}
+
+	ctx := newCtx()
+	assert.Equal(resultUnresolved, pipeline.Handle(ctx))
+	assert.Equal(http.StatusServiceUnavailable, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+	assert.Empty(pipeline.Status().ObjectStatus.(*Status).Filters)
+
+	// the fragment in use can't be deleted.
+	fragmentSpec, err := supervisor.NewSpec(`
+name: common
+kind: PipelineFragment
+filters:
+- name: auth
+  kind: Auth
+`)
+	assert.Nil(err)
+	assert.NotNil(validateHook(api.OperationTypeDelete, fragmentSpec))
+
+	// the pipeline is loaded after the fragment is available.
+	fragments["common"] = fragmentSpec.ObjectSpec().(*FragmentSpec)
+	ctx = newCtx()
+	assert.Equal("", pipeline.Handle(ctx))
+	assert.Equal([]string{"auth", "proxy"}, flowNames(pipeline.flow))
+	assert.Len(pipeline.Status().ObjectStatus.(*Status).Filters, 2)
+
+	pipeline.Close()
+	assert.Nil(validateHook(api.OperationTypeDelete, fragmentSpec))
+}
@@ -0,0 +1,265 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	libcb "github.com/megaease/easegress/v2/pkg/util/circuitbreaker"
+)
+
+const (
+	// resultGuardRejected is the result of the pipeline when the request
+	// is rejected by the guard.
+	resultGuardRejected = "guardRejected"
+
+	defaultGuardFailureRateThreshold = 50
+	defaultGuardMinimumNumberOfCalls = 100
+	defaultGuardWindow               = 30 * time.Second
+	defaultGuardProbeInterval        = 30 * time.Second
+	defaultGuardProbeRequests        = 10
+)
+
+type (
+	// GuardSpec describes the guard of the pipeline, it is the last resort
+	// circuit breaker of the whole pipeline, which fails fast for all
+	// requests when the error rate of the pipeline is too high, and probes
+	// for the recovery periodically.
+	GuardSpec struct {
+		// FailureResults are the filter results counted as failures, a
+		// request fails if any filter in the flows returns one of them.
+		FailureResults []string `json:"failureResults" jsonschema:"required,minItems=1"`
+		// FailureRateThreshold is the failure rate in percentage to open
+		// the guard, default is 50.
+		FailureRateThreshold uint8 `json:"failureRateThreshold,omitempty" jsonschema:"minimum=1,maximum=100"`
+		// MinimumNumberOfCalls is the minimum number of requests in the
+		// window before the failure rate is calculated, default is 100.
+		MinimumNumberOfCalls uint32 `json:"minimumNumberOfCalls,omitempty"`
+		// Window is the period the failure rate must be sustained for,
+		// default is 30s.
+		Window string `json:"window,omitempty" jsonschema:"format=duration"`
+		// ProbeInterval is the time the guard stays open before probing
+		// for the recovery, default is 30s.
+		ProbeInterval string `json:"probeInterval,omitempty" jsonschema:"format=duration"`
+		// ProbeRequests is the number of requests let through to probe
+		// for the recovery, default is 10.
+		ProbeRequests uint32 `json:"probeRequests,omitempty"`
+		// Response is the response of rejected requests.
+		Response *GuardResponseSpec `json:"response,omitempty"`
+	}
+
+	// GuardResponseSpec describes the response of requests rejected by the
+	// guard.
+	GuardResponseSpec struct {
+		// StatusCode is the status code of the response, default is 503.
+		StatusCode int               `json:"statusCode,omitempty" jsonschema:"minimum=200,maximum=599"`
+		Headers    map[string]string `json:"headers,omitempty"`
+		Body       string            `json:"body,omitempty"`
+	}
+
+	// GuardStatus is the status of the guard.
+	GuardStatus struct {
+		// State is one of Closed, Open and HalfOpen.
+		State string `json:"state"`
+		// Rejected is the number of requests rejected by the guard.
+		Rejected uint64 `json:"rejected"`
+		// LastTransition is the time of the last state transition.
+		LastTransition string `json:"lastTransition,omitempty"`
+		// Reason is the reason of the last state transition.
+		Reason string `json:"reason,omitempty"`
+	}
+
+	guard struct {
+		spec           *GuardSpec
+		cb             *libcb.CircuitBreaker
+		failureResults map[string]bool
+		rejected       uint64
+
+		lock      sync.Mutex
+		lastEvent *libcb.Event
+	}
+)
+
+// Validate validates GuardSpec.
+func (spec *GuardSpec) Validate() error {
+	if spec.Window != "" {
+		d, err := time.ParseDuration(spec.Window)
+		if err != nil {
+			return fmt.Errorf("invalid window: %v", err)
+		}
+		if d < time.Second {
+			return fmt.Errorf("window must be at least 1s")
+		}
+	}
+	if spec.ProbeInterval != "" {
+		d, err := time.ParseDuration(spec.ProbeInterval)
+		if err != nil {
+			return fmt.Errorf("invalid probeInterval: %v", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("probeInterval must be positive")
+		}
+	}
+	return nil
+}
+
+// newGuard creates the guard, the state of the guard of the previous
+// generation is inherited if the spec is not changed, so that updating
+// other parts of the pipeline doesn't close an open guard.
+func newGuard(super *supervisor.Supervisor, pipelineName string, spec *GuardSpec, prev *guard) *guard {
+	if spec == nil {
+		return nil
+	}
+	if prev != nil && reflect.DeepEqual(prev.spec, spec) {
+		return prev
+	}
+
+	policy := &libcb.Policy{
+		FailureRateThreshold:             defaultGuardFailureRateThreshold,
+		SlidingWindowType:                libcb.TimeBased,
+		SlidingWindowSize:                uint32(defaultGuardWindow / time.Second),
+		PermittedNumberOfCallsInHalfOpen: defaultGuardProbeRequests,
+		MinimumNumberOfCalls:             defaultGuardMinimumNumberOfCalls,
+		WaitDurationInOpen:               defaultGuardProbeInterval,
+		// slow requests are not failures of the guard.
+		SlowCallRateThreshold:     100,
+		SlowCallDurationThreshold: math.MaxInt64,
+	}
+	if spec.FailureRateThreshold > 0 {
+		policy.FailureRateThreshold = spec.FailureRateThreshold
+	}
+	if spec.MinimumNumberOfCalls > 0 {
+		policy.MinimumNumberOfCalls = spec.MinimumNumberOfCalls
+	}
+	if spec.Window != "" {
+		d, _ := time.ParseDuration(spec.Window)
+		policy.SlidingWindowSize = uint32(d / time.Second)
+	}
+	if spec.ProbeInterval != "" {
+		policy.WaitDurationInOpen, _ = time.ParseDuration(spec.ProbeInterval)
+	}
+	if spec.ProbeRequests > 0 {
+		policy.PermittedNumberOfCallsInHalfOpen = spec.ProbeRequests
+	}
+
+	g := &guard{
+		spec:           spec,
+		cb:             libcb.New(policy),
+		failureResults: map[string]bool{},
+	}
+	for _, r := range spec.FailureResults {
+		g.failureResults[r] = true
+	}
+
+	g.cb.SetStateListener(func(event *libcb.Event) {
+		logger.Warnf("pipeline %s: guard transits from %s to %s: %s",
+			pipelineName, event.OldState, event.NewState, event.Reason)
+		super.PublishStateEvent(&supervisor.StateEvent{
+			Time:     event.Time,
+			Kind:     supervisor.StateEventPipelineGuard,
+			Source:   pipelineName,
+			OldState: event.OldState,
+			NewState: event.NewState,
+			Reason:   event.Reason,
+		})
+		g.lock.Lock()
+		g.lastEvent = event
+		g.lock.Unlock()
+	})
+
+	return g
+}
+
+// acquire reports whether the request is permitted, the returned state id
+// must be passed to record.
+func (g *guard) acquire() (bool, uint32) {
+	permitted, stateID := g.cb.AcquirePermission()
+	if !permitted {
+		atomic.AddUint64(&g.rejected, 1)
+	}
+	return permitted, stateID
+}
+
+// record records the result of a permitted request by the stats of the
+// filters it visited.
+func (g *guard) record(stateID uint32, stats []FilterStat) {
+	failed := false
+	for i := range stats {
+		if g.failureResults[stats[i].Result] {
+			failed = true
+			break
+		}
+	}
+	g.cb.RecordResult(stateID, failed, 0)
+}
+
+// reject builds the response of a rejected request, only HTTP requests get
+// a response.
+func (g *guard) reject(ctx *context.Context) string {
+	ctx.AddTag("pipeline guard rejected")
+
+	if _, ok := ctx.GetInputRequest().(*httpprot.Request); !ok {
+		return resultGuardRejected
+	}
+
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(http.StatusServiceUnavailable)
+	if r := g.spec.Response; r != nil {
+		if r.StatusCode != 0 {
+			resp.SetStatusCode(r.StatusCode)
+		}
+		for k, v := range r.Headers {
+			resp.Header().Set(k, v)
+		}
+		if r.Body != "" {
+			resp.SetPayload([]byte(r.Body))
+		}
+	}
+	ctx.SetOutputResponse(resp)
+	return resultGuardRejected
+}
+
+// isOpen reports whether the guard is rejecting requests.
+func (g *guard) isOpen() bool {
+	return g.cb.State() != libcb.StateClosed
+}
+
+func (g *guard) status() *GuardStatus {
+	s := &GuardStatus{
+		State:    g.cb.State().String(),
+		Rejected: atomic.LoadUint64(&g.rejected),
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if e := g.lastEvent; e != nil {
+		s.LastTransition = e.Time.Format(time.RFC3339)
+		s.Reason = e.Reason
+	}
+	return s
+}
@@ -19,13 +19,23 @@
 package pipeline
 
 import (
+	stdcontext "context"
+	"crypto/subtle"
 	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/megaease/easegress/v2/pkg/api"
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
 	"github.com/megaease/easegress/v2/pkg/resilience"
 	"github.com/megaease/easegress/v2/pkg/supervisor"
 	"github.com/megaease/easegress/v2/pkg/util/easemonitor"
@@ -42,6 +52,8 @@ const (
 
 	// BuiltInFilterEnd is the name of the build-in end filter.
 	BuiltInFilterEnd = "END"
+
+	defaultDrainTimeout = 10 * time.Second
 )
 
 func init() {
@@ -51,6 +63,8 @@ func init() {
 		Kind:     Kind,
 		Name:     strings.ToLower(Kind),
 		Aliases:  []string{"pipelines", "pl"},
+
+		ValiateHook: validateHook,
 	})
 }
 
@@ -64,17 +78,95 @@ type (
 		superSpec *supervisor.Spec
 		spec      *Spec
 
-		filters    map[string]filters.Filter
-		flow       []FlowNode
-		resilience map[string]resilience.Policy
+		filters      map[string]filters.Filter
+		flow         []FlowNode
+		responseFlow []FlowNode
+		resilience   map[string]resilience.Policy
+		usage        map[string]*filterUsage
+		guard        *guard
+		// handedOver are the filters reused by the next generation, they
+		// are not drained or closed when the pipeline is closed.
+		handedOver map[string]bool
+		// rawFilters are the specs of the filters, including the filters
+		// of the included fragments.
+		rawFilters []map[string]interface{}
+
+		// unresolved is true if the included fragments were unavailable
+		// when the pipeline was loaded, the pipeline is loaded by the
+		// first request after they are available.
+		unresolved  atomic.Bool
+		resolveLock sync.Mutex
+		closed      bool
 	}
 
 	// Spec describes the Pipeline.
 	Spec struct {
-		Flow       []FlowNode               `json:"flow,omitempty"`
-		Filters    []map[string]interface{} `json:"filters" jsonschema:"required"`
-		Resilience []map[string]interface{} `json:"resilience,omitempty"`
-		Data       map[string]interface{}   `json:"data,omitempty"`
+		// Includes are the fragments included by the pipeline, they are
+		// expanded when the pipeline is loaded.
+		Includes []*Include `json:"includes,omitempty"`
+		Flow     []FlowNode `json:"flow,omitempty"`
+		// ResponseFlow runs after Flow, it makes the processing of the
+		// response explicit and separated from the processing of the
+		// request. Request-only filters can't be used in it.
+		ResponseFlow []FlowNode               `json:"responseFlow,omitempty"`
+		Filters      []map[string]interface{} `json:"filters" jsonschema:"required"`
+		Resilience   []map[string]interface{} `json:"resilience,omitempty"`
+		Data         map[string]interface{}   `json:"data,omitempty"`
+		// DrainTimeout is the max time to wait for filters to finish their
+		// pending work when the pipeline is reloaded or closed.
+		DrainTimeout string `json:"drainTimeout,omitempty" jsonschema:"format=duration"`
+		// Trace enables the execution trace of requests for debugging.
+		Trace *TraceSpec `json:"trace,omitempty"`
+		// ResponseDefaults are applied to every response of the pipeline
+		// after the flows.
+		ResponseDefaults *ResponseDefaultsSpec `json:"responseDefaults,omitempty"`
+		// Guard fails fast for all requests when the error rate of the
+		// pipeline is too high.
+		Guard *GuardSpec `json:"guard,omitempty"`
+		// RequestBuffering is the strategy to read the body of requests,
+		// the clientMaxBodySize of the HTTP server decides it if not set.
+		RequestBuffering *RequestBufferingSpec `json:"requestBuffering,omitempty"`
+	}
+
+	// RequestBufferingSpec describes how the body of requests is read.
+	RequestBufferingSpec struct {
+		// Strategy is full, stream or threshold. Full reads the whole body
+		// into memory, which enables retries and transformations of the
+		// body. Stream never buffers the body, and threshold buffers the
+		// body if it is not larger than Threshold, and streams it
+		// otherwise.
+		Strategy  string `json:"strategy" jsonschema:"required,enum=full,enum=stream,enum=threshold"`
+		Threshold int64  `json:"threshold,omitempty" jsonschema:"minimum=0"`
+	}
+
+	// ResponseDefaultsSpec describes the defaults of responses.
+	ResponseDefaultsSpec struct {
+		// Headers are set to the responses which don't have them, so the
+		// headers set by filters or backends win.
+		Headers map[string]string `json:"headers,omitempty"`
+		// BodyPrefix and BodySuffix wrap the body of responses. Empty
+		// bodies, streams and encoded bodies are not wrapped.
+		BodyPrefix string `json:"bodyPrefix,omitempty"`
+		BodySuffix string `json:"bodySuffix,omitempty"`
+	}
+
+	// TraceSpec describes the execution trace of requests, which records
+	// the filters visited, their results, durations and the jumps taken.
+	// The trace contains no data of requests or responses.
+	TraceSpec struct {
+		// Header is the name of the request header which enables the
+		// trace of a request, all requests are traced if it is empty. The
+		// header is removed from the request if the trace is enabled.
+		Header string `json:"header,omitempty"`
+		// Value is the value the header must have, any non-empty value
+		// enables the trace if it is empty. It should be a secret to keep
+		// clients from discovering the internals of the pipeline.
+		Value string `json:"value,omitempty"`
+		// ResponseHeader is the name of the response header to return the
+		// trace, the trace is not returned if it is empty.
+		ResponseHeader string `json:"responseHeader,omitempty"`
+		// Log logs the trace.
+		Log bool `json:"log,omitempty"`
 	}
 
 	// FlowNode describes one node of the pipeline flow.
@@ -84,6 +176,7 @@ type (
 		Namespace   string            `json:"namespace,omitempty"`
 		JumpIf      map[string]string `json:"jumpIf,omitempty"`
 		filter      filters.Filter
+		usage       *filterUsage
 	}
 
 	// FilterStat records the statistics of a filter.
@@ -92,12 +185,31 @@ type (
 		Kind     string
 		Result   string
 		Duration time.Duration
+		// Jump is the target of the jump of jumpIf taken after the filter,
+		// it is empty if no jump is taken.
+		Jump string
 	}
 
 	// Status is the status of Pipeline.
 	Status struct {
+		// Health is degraded if any filter is degraded, for example, a
+		// circuit breaker is open or a rate limiter is limiting.
 		Health  string                 `json:"health"`
 		Filters map[string]interface{} `json:"filters"`
+		// Summary is the health summary of all filters sorted by name,
+		// to build dashboards without knowing the status of each kind.
+		Summary []*FilterHealth `json:"summary"`
+		// Usage is the handling time of the filters, keyed by filter name.
+		Usage map[string]*FilterUsage `json:"usage"`
+		// Guard is the status of the guard, it is nil if there's no guard.
+		Guard *GuardStatus `json:"guard,omitempty"`
+	}
+
+	// FilterHealth is the health summary of a filter.
+	FilterHealth struct {
+		Name                 string `json:"name"`
+		Kind                 string `json:"kind"`
+		filters.HealthReport `json:",inline"`
 	}
 )
 
@@ -108,11 +220,28 @@ func (fn *FlowNode) filterAlias() string {
 	return fn.FilterName
 }
 
-// ValidateJumpIf validates whether the target of JumpIfs are valid or not.
+// ValidateJumpIf validates whether the target of JumpIfs are valid or not,
+// it also validates there's no request-only filter in the response flow.
 func (s *Spec) ValidateJumpIf(specs map[string]filters.Spec) {
+	validateFlow(s.Flow, specs)
+	validateFlow(s.ResponseFlow, specs)
+
+	for i := range s.ResponseFlow {
+		node := &s.ResponseFlow[i]
+		if node.FilterName == BuiltInFilterEnd {
+			continue
+		}
+		if filters.GetKind(specs[node.FilterName].Kind()).RequestOnly {
+			msgFmt := "filter %s: request-only filter can't be in the response flow"
+			panic(fmt.Errorf(msgFmt, node.FilterName))
+		}
+	}
+}
+
+func validateFlow(flow []FlowNode, specs map[string]filters.Spec) {
 	validTargets := map[string]int{BuiltInFilterEnd: 1}
-	for i := len(s.Flow) - 1; i >= 0; i-- {
-		node := &s.Flow[i]
+	for i := len(flow) - 1; i >= 0; i-- {
+		node := &flow[i]
 		if node.FilterName == BuiltInFilterEnd {
 			continue
 		}
@@ -167,11 +296,31 @@ func (s *Spec) Validate() (err error) {
 		specs[name] = spec
 	}
 
-	// 2: validate flow
+	// 2: validate flow, the flow referring to the included fragments is
+	// validated after expanding.
 	errPrefix = "flow"
-	s.ValidateJumpIf(specs)
+	if len(s.Includes) == 0 {
+		s.ValidateJumpIf(specs)
+	}
+
+	// 3: validate request buffering, filters requiring buffering can't
+	// be used in a streaming pipeline.
+	errPrefix = "requestBuffering"
+	if rb := s.RequestBuffering; rb != nil {
+		if rb.Strategy == httpprot.BufferingThreshold && rb.Threshold <= 0 {
+			panic(fmt.Errorf("threshold must be positive"))
+		}
+		if rb.Strategy == httpprot.BufferingStream {
+			for _, f := range s.Filters {
+				spec := specs[f["name"].(string)]
+				if filters.GetKind(spec.Kind()).RequiresBuffering {
+					panic(fmt.Errorf("filter %s: %s requires buffering, it can't be used in a streaming pipeline", spec.Name(), spec.Kind()))
+				}
+			}
+		}
+	}
 
-	// 3: validate resilience
+	// 4: validate resilience
 	for _, r := range s.Resilience {
 		_, err := resilience.NewPolicy(r)
 		if err != nil {
@@ -179,6 +328,27 @@ func (s *Spec) Validate() (err error) {
 		}
 	}
 
+	// 5: validate trace
+	errPrefix = "trace"
+	if t := s.Trace; t != nil {
+		if t.ResponseHeader == "" && !t.Log {
+			panic(fmt.Errorf("at least one of responseHeader and log must be set"))
+		}
+		if t.Value != "" && t.Header == "" {
+			panic(fmt.Errorf("value requires a header"))
+		}
+	}
+
+	// 6: validate drain timeout
+	errPrefix = "drainTimeout"
+	if s.DrainTimeout != "" {
+		if d, err := time.ParseDuration(s.DrainTimeout); err != nil {
+			panic(err)
+		} else if d <= 0 {
+			panic(fmt.Errorf("must be positive"))
+		}
+	}
+
 	return nil
 }
 
@@ -204,6 +374,10 @@ func (p *Pipeline) serializeStats(stats []FilterStat) string {
 			sb.WriteByte(',')
 		}
 		sb.WriteString(stat.Duration.String())
+		if stat.Jump != "" {
+			sb.WriteString(",jump:")
+			sb.WriteString(stat.Jump)
+		}
 		sb.WriteByte(')')
 	}
 
@@ -241,12 +415,32 @@ func (p *Pipeline) Inherit(superSpec *supervisor.Spec, previousGeneration superv
 func (p *Pipeline) reload(previousGeneration *Pipeline) {
 	p.filters = make(map[string]filters.Filter)
 	p.resilience = make(map[string]resilience.Policy)
+	p.usage = make(map[string]*filterUsage)
+	p.handedOver = make(map[string]bool)
+
+	users.add(p)
+
+	// expand the included fragments. They may be unavailable for now,
+	// e.g. the pipeline is created before the fragments at startup, the
+	// pipeline is loaded after they are available in this case.
+	spec, err := p.spec.expand(newFragmentLookup(p.superSpec.Super()))
+	if err != nil {
+		logger.Errorf("pipeline %s: %v, it is loaded when the fragments are available",
+			p.superSpec.Name(), err)
+		p.unresolved.Store(true)
+		return
+	}
+	p.load(spec, previousGeneration)
+}
 
+// load creates the filters and the flows of the expanded spec.
+func (p *Pipeline) load(spec *Spec, previousGeneration *Pipeline) {
 	super := p.superSpec.Super()
 	pipelineName := p.superSpec.Name()
+	p.rawFilters = spec.Filters
 
 	// create resilience
-	for _, r := range p.spec.Resilience {
+	for _, r := range spec.Resilience {
 		policy, err := resilience.NewPolicy(r)
 		if err != nil {
 			panic(err)
@@ -255,61 +449,173 @@ func (p *Pipeline) reload(previousGeneration *Pipeline) {
 	}
 
 	// create a flow in case the pipeline spec does not define one.
-	flow := p.spec.Flow
+	flow := spec.Flow
 	if len(flow) == 0 {
-		flow = make([]FlowNode, 0, len(p.spec.Filters))
+		flow = make([]FlowNode, 0, len(spec.Filters))
+	}
+
+	// filters in the response flow are excluded from the created flow.
+	inResponseFlow := map[string]bool{}
+	for i := range spec.ResponseFlow {
+		inResponseFlow[spec.ResponseFlow[i].FilterName] = true
 	}
 
-	for _, rawSpec := range p.spec.Filters {
+	for _, rawSpec := range spec.Filters {
 		// build the filter spec.
-		spec, err := filters.NewSpec(super, pipelineName, rawSpec)
+		filterSpec, err := filters.NewSpec(super, pipelineName, rawSpec)
 		if err != nil {
 			panic(err)
 		}
 
-		// create filter instance.
-		filter := filters.Create(spec)
+		// reuse the previous instance if its spec is not changed, so that
+		// reloading the pipeline doesn't disturb the unchanged filters.
+		filter := p.reuseFilter(previousGeneration, filterSpec.Name(), rawSpec)
 		if filter == nil {
-			panic(fmt.Errorf("kind %s not found", spec.Kind()))
-		}
-
-		// init or inherit from previous instance.
-		var prev filters.Filter
-		if previousGeneration != nil {
-			prev = previousGeneration.getFilter(spec.Name())
-		}
-		if prev == nil {
-			filter.Init()
-		} else {
-			filter.Inherit(prev)
-		}
-		if r, ok := filter.(filters.Resiliencer); ok {
-			r.InjectResiliencePolicy(p.resilience)
+			filter = p.createFilter(previousGeneration, filterSpec)
 		}
 
 		// add the filter to pipeline, and if the pipeline does not define a
 		// flow, append it to the flow we just created.
-		p.filters[spec.Name()] = filter
-		if len(p.spec.Flow) == 0 {
-			flow = append(flow, FlowNode{FilterName: spec.Name()})
+		p.filters[filterSpec.Name()] = filter
+		p.usage[filterSpec.Name()] = p.inheritUsage(previousGeneration, filterSpec.Name())
+		if len(spec.Flow) == 0 && !inResponseFlow[filterSpec.Name()] {
+			flow = append(flow, FlowNode{FilterName: filterSpec.Name()})
 		}
 	}
 
 	p.flow = flow
+	p.responseFlow = spec.ResponseFlow
+
+	var prevGuard *guard
+	if previousGeneration != nil {
+		prevGuard = previousGeneration.guard
+	}
+	p.guard = newGuard(super, pipelineName, p.spec.Guard, prevGuard)
 
 	// bind filter instance to flow node.
-	for i := range flow {
-		node := &flow[i]
-		if node.FilterName != BuiltInFilterEnd {
-			node.filter = p.filters[node.FilterName]
+	for _, flow := range [][]FlowNode{p.flow, p.responseFlow} {
+		for i := range flow {
+			node := &flow[i]
+			if node.FilterName != BuiltInFilterEnd {
+				node.filter = p.filters[node.FilterName]
+				node.usage = p.usage[node.FilterName]
+			}
 		}
 	}
 }
 
+// resolve loads the pipeline if the included fragments were unavailable
+// when it was loaded, it returns false if they are still unavailable.
+func (p *Pipeline) resolve() bool {
+	if !p.unresolved.Load() {
+		return true
+	}
+
+	p.resolveLock.Lock()
+	defer p.resolveLock.Unlock()
+
+	if !p.unresolved.Load() {
+		return true
+	}
+	if p.closed {
+		return false
+	}
+
+	spec, err := p.spec.expand(newFragmentLookup(p.superSpec.Super()))
+	if err != nil {
+		return false
+	}
+	p.load(spec, nil)
+	p.unresolved.Store(false)
+	logger.Infof("pipeline %s: the included fragments are resolved", p.superSpec.Name())
+	return true
+}
+
+// handleUnresolved fails the request as the included fragments are
+// unavailable.
+func (p *Pipeline) handleUnresolved(ctx *context.Context) string {
+	ctx.AddTag("pipeline fragments unresolved")
+	if _, ok := ctx.GetInputRequest().(*httpprot.Request); ok {
+		resp, _ := httpprot.NewResponse(nil)
+		resp.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.SetOutputResponse(resp)
+	}
+	return resultUnresolved
+}
+
 func (p *Pipeline) getFilter(name string) filters.Filter {
 	return p.filters[name]
 }
 
+// createFilter creates the filter, and initializes it or inherits the
+// previous instance.
+func (p *Pipeline) createFilter(previousGeneration *Pipeline, spec filters.Spec) filters.Filter {
+	filter := filters.Create(spec)
+	if filter == nil {
+		panic(fmt.Errorf("kind %s not found", spec.Kind()))
+	}
+
+	var prev filters.Filter
+	if previousGeneration != nil {
+		prev = previousGeneration.getFilter(spec.Name())
+	}
+	if prev == nil {
+		filter.Init()
+	} else {
+		filter.Inherit(prev)
+	}
+	if r, ok := filter.(filters.Resiliencer); ok {
+		r.InjectResiliencePolicy(p.resilience)
+	}
+	return filter
+}
+
+// reuseFilter returns the filter instance of the previous generation if
+// its spec is not changed, it returns nil if the filter must be recreated.
+// The returned filter is handed over to this generation.
+func (p *Pipeline) reuseFilter(previousGeneration *Pipeline, name string, rawSpec map[string]interface{}) filters.Filter {
+	if previousGeneration == nil {
+		return nil
+	}
+	prev := previousGeneration.getFilter(name)
+	if prev == nil {
+		return nil
+	}
+	if r, ok := prev.(filters.Recreator); ok && r.AlwaysRecreate() {
+		return nil
+	}
+
+	// the resilience policies are injected on creation.
+	if _, ok := prev.(filters.Resiliencer); ok {
+		if !reflect.DeepEqual(p.spec.Resilience, previousGeneration.spec.Resilience) {
+			return nil
+		}
+	}
+
+	for _, prevRawSpec := range previousGeneration.rawFilters {
+		if prevRawSpec["name"] != name {
+			continue
+		}
+		if !reflect.DeepEqual(rawSpec, prevRawSpec) {
+			return nil
+		}
+		previousGeneration.handedOver[name] = true
+		return prev
+	}
+	return nil
+}
+
+// inheritUsage returns the usage of the filter in the previous generation,
+// so that the usage is not reset by updating the pipeline.
+func (p *Pipeline) inheritUsage(previousGeneration *Pipeline, name string) *filterUsage {
+	if previousGeneration != nil {
+		if u := previousGeneration.usage[name]; u != nil {
+			return u
+		}
+	}
+	return &filterUsage{}
+}
+
 // HandleWithBeforeAfterOption is the option of HandleWithBeforeAfter.
 // FallthroughBefore: if true, the pipeline will be executed even if the before pipeline ends.
 // FallthroughPipeline: if true, the after pipeline will be executed even if the pipeline ends.
@@ -319,19 +625,34 @@ type HandleWithBeforeAfterOption struct {
 }
 
 // HandleWithBeforeAfter handles the request, with additional flow defined by
-// the before/after pipeline.
+// the before/after pipeline. The response flows run in the reverse order of
+// the request flows, that's the after pipeline, the pipeline and the before
+// pipeline, and the response flow of the after pipeline runs only if its
+// request flow runs.
 func (p *Pipeline) HandleWithBeforeAfter(ctx *context.Context, before, after *Pipeline, option HandleWithBeforeAfterOption) string {
+	if !p.resolve() || (before != nil && !before.resolve()) || (after != nil && !after.resolve()) {
+		return p.handleUnresolved(ctx)
+	}
+
 	if len(p.spec.Data) > 0 {
 		ctx.SetData("PIPELINE", p.spec.Data)
 	}
 
+	permitted, guardStateID := true, uint32(0)
+	if p.guard != nil {
+		if permitted, guardStateID = p.guard.acquire(); !permitted {
+			return p.handleGuardRejected(ctx)
+		}
+	}
+
+	traced := p.traceEnabled(ctx)
 	result, sawEnd := "", false
-	flowLen := len(p.flow)
+	flowLen := len(p.flow) + len(p.responseFlow)
 	if before != nil {
-		flowLen += len(before.flow)
+		flowLen += len(before.flow) + len(before.responseFlow)
 	}
 	if after != nil {
-		flowLen += len(after.flow)
+		flowLen += len(after.flow) + len(after.responseFlow)
 	}
 	stats := make([]FilterStat, 0, flowLen)
 
@@ -345,23 +666,65 @@ func (p *Pipeline) HandleWithBeforeAfter(ctx *context.Context, before, after *Pi
 
 	if (after != nil) && (!sawEnd || option.FallthroughPipeline) {
 		result, stats, _ = p.doHandle(ctx, after.flow, stats)
+		result, stats = p.doHandleResponse(ctx, after.responseFlow, result, stats)
 	}
 
+	result, stats = p.doHandleResponse(ctx, p.responseFlow, result, stats)
+	if before != nil {
+		result, stats = p.doHandleResponse(ctx, before.responseFlow, result, stats)
+	}
+	p.applyResponseDefaults(ctx)
+	if p.guard != nil {
+		p.guard.record(guardStateID, stats)
+	}
+
+	if traced {
+		p.writeTrace(ctx, stats)
+	}
 	ctx.LazyAddTag(func() string {
 		return p.serializeStats(stats)
 	})
 	return result
 }
 
+// RequestBuffering returns the strategy to read the body of requests, it
+// implements context.RequestBufferer.
+func (p *Pipeline) RequestBuffering() (string, int64) {
+	if rb := p.spec.RequestBuffering; rb != nil {
+		return rb.Strategy, rb.Threshold
+	}
+	return "", 0
+}
+
 // Handle is the handler to deal with the request.
 func (p *Pipeline) Handle(ctx *context.Context) string {
+	if !p.resolve() {
+		return p.handleUnresolved(ctx)
+	}
+
 	if len(p.spec.Data) > 0 {
 		ctx.SetData("PIPELINE", p.spec.Data)
 	}
 
-	stats := make([]FilterStat, 0, len(p.flow))
+	permitted, guardStateID := true, uint32(0)
+	if p.guard != nil {
+		if permitted, guardStateID = p.guard.acquire(); !permitted {
+			return p.handleGuardRejected(ctx)
+		}
+	}
+
+	traced := p.traceEnabled(ctx)
+	stats := make([]FilterStat, 0, len(p.flow)+len(p.responseFlow))
 	result, stats, _ := p.doHandle(ctx, p.flow, stats)
+	result, stats = p.doHandleResponse(ctx, p.responseFlow, result, stats)
+	p.applyResponseDefaults(ctx)
+	if p.guard != nil {
+		p.guard.record(guardStateID, stats)
+	}
 
+	if traced {
+		p.writeTrace(ctx, stats)
+	}
 	ctx.LazyAddTag(func() string {
 		return p.serializeStats(stats)
 	})
@@ -388,10 +751,15 @@ func (p *Pipeline) doHandle(ctx *context.Context, flow []FlowNode, stats []Filte
 		ctx.UseNamespace(node.Namespace)
 
 		result = node.filter.Handle(ctx)
+		if result != "" {
+			ctx.SetResult(result)
+		}
+		duration := fasttime.Since(start)
+		node.usage.record(duration)
 		stats = append(stats, FilterStat{
 			Name:     alias,
 			Kind:     node.filter.Kind().Name,
-			Duration: fasttime.Since(start),
+			Duration: duration,
 			Result:   result,
 		})
 
@@ -399,6 +767,11 @@ func (p *Pipeline) doHandle(ctx *context.Context, flow []FlowNode, stats []Filte
 		if next, ok = node.JumpIf[result]; result != "" && !ok {
 			next = BuiltInFilterEnd
 		}
+		// only the jumps of jumpIf are recorded, ending the flow by an
+		// unhandled result is not a jump.
+		if ok {
+			stats[len(stats)-1].Jump = next
+		}
 
 		if next == BuiltInFilterEnd {
 			sawEnd = true
@@ -409,14 +782,147 @@ func (p *Pipeline) doHandle(ctx *context.Context, flow []FlowNode, stats []Filte
 	return result, stats, sawEnd
 }
 
+// traceEnabled reports whether to trace the request, it removes the header
+// enabling the trace from the request, so that it is not sent to backends.
+func (p *Pipeline) traceEnabled(ctx *context.Context) bool {
+	t := p.spec.Trace
+	if t == nil {
+		return false
+	}
+	if t.Header == "" {
+		return true
+	}
+
+	h := ctx.GetInputRequest().Header()
+	v, _ := h.Get(t.Header).(string)
+	if v == "" {
+		return false
+	}
+	// the value is a secret, compare it in constant time.
+	if t.Value != "" && subtle.ConstantTimeCompare([]byte(v), []byte(t.Value)) != 1 {
+		return false
+	}
+	h.Del(t.Header)
+	return true
+}
+
+// writeTrace returns the trace in the response header and logs it
+// according to the spec.
+func (p *Pipeline) writeTrace(ctx *context.Context, stats []FilterStat) {
+	t, trace := p.spec.Trace, p.serializeStats(stats)
+	if t.Log {
+		logger.Infof("trace of %s", trace)
+	}
+	if t.ResponseHeader == "" {
+		return
+	}
+	if resp := ctx.GetOutputResponse(); resp != nil {
+		resp.Header().Set(t.ResponseHeader, trace)
+	}
+}
+
+// doHandleResponse runs the response flow, it runs even if the request flow
+// ends early, so that responses built by filters like Mock are processed as
+// well. A non-empty result of the response flow overrides result.
+func (p *Pipeline) doHandleResponse(ctx *context.Context, responseFlow []FlowNode, result string, stats []FilterStat) (string, []FilterStat) {
+	if len(responseFlow) == 0 {
+		return result, stats
+	}
+
+	respResult, stats, _ := p.doHandle(ctx, responseFlow, stats)
+	if respResult != "" {
+		result = respResult
+	}
+	return result, stats
+}
+
+// handleGuardRejected handles the request rejected by the guard, none of
+// the filters are executed, but the response defaults are still applied.
+func (p *Pipeline) handleGuardRejected(ctx *context.Context) string {
+	result := p.guard.reject(ctx)
+	p.applyResponseDefaults(ctx)
+	return result
+}
+
+// applyResponseDefaults applies the response defaults to the response, it
+// runs after the response flow, so filters can't see the defaults.
+func (p *Pipeline) applyResponseDefaults(ctx *context.Context) {
+	d := p.spec.ResponseDefaults
+	if d == nil {
+		return
+	}
+	resp := ctx.GetOutputResponse()
+	if resp == nil {
+		return
+	}
+
+	h := resp.Header()
+	for k, v := range d.Headers {
+		if existing, _ := h.Get(k).(string); existing == "" {
+			h.Set(k, v)
+		}
+	}
+
+	if d.BodyPrefix == "" && d.BodySuffix == "" {
+		return
+	}
+	if resp.IsStream() || resp.PayloadSize() == 0 {
+		return
+	}
+	if ce, _ := h.Get("Content-Encoding").(string); ce != "" && ce != "identity" {
+		return
+	}
+
+	body := resp.RawPayload()
+	wrapped := make([]byte, 0, len(d.BodyPrefix)+len(body)+len(d.BodySuffix))
+	wrapped = append(wrapped, d.BodyPrefix...)
+	wrapped = append(wrapped, body...)
+	wrapped = append(wrapped, d.BodySuffix...)
+	resp.SetPayload(wrapped)
+
+	if cl, _ := h.Get("Content-Length").(string); cl != "" {
+		h.Set("Content-Length", strconv.Itoa(len(wrapped)))
+	}
+}
+
 // Status returns Status generated by Runtime.
 func (p *Pipeline) Status() *supervisor.Status {
+	p.resolveLock.Lock()
+	defer p.resolveLock.Unlock()
+
 	s := &Status{
+		Health:  filters.HealthHealthy,
 		Filters: make(map[string]interface{}),
 	}
 
 	for name, filter := range p.filters {
 		s.Filters[name] = filter.Status()
+
+		fh := &FilterHealth{Name: name, Kind: filter.Kind().Name}
+		if hr, ok := filter.(filters.HealthReporter); ok {
+			if r := hr.HealthReport(); r != nil {
+				fh.HealthReport = *r
+			}
+		}
+		if fh.Health == "" {
+			fh.Health = filters.HealthHealthy
+		}
+		if fh.Health == filters.HealthDegraded {
+			s.Health = filters.HealthDegraded
+		}
+		s.Summary = append(s.Summary, fh)
+	}
+
+	sort.Slice(s.Summary, func(i, j int) bool {
+		return s.Summary[i].Name < s.Summary[j].Name
+	})
+	s.Usage = usageStatus(p.usage)
+
+	if p.guard != nil {
+		s.Guard = p.guard.status()
+		if p.guard.isOpen() {
+			s.Health = filters.HealthDegraded
+		}
 	}
 
 	return &supervisor.Status{
@@ -424,11 +930,52 @@ func (p *Pipeline) Status() *supervisor.Status {
 	}
 }
 
-// Close closes Pipeline.
+// Close closes Pipeline, filters implementing filters.Drainer are drained
+// concurrently before all filters are closed. Filters reused by the next
+// generation are neither drained nor closed.
 func (p *Pipeline) Close() {
-	for _, filter := range p.filters {
-		filter.Close()
+	p.resolveLock.Lock()
+	defer p.resolveLock.Unlock()
+
+	p.closed = true
+	users.remove(p)
+	p.drain()
+	for name, filter := range p.filters {
+		if !p.handedOver[name] {
+			filter.Close()
+		}
+	}
+}
+
+func (p *Pipeline) drain() {
+	timeout := defaultDrainTimeout
+	if p.spec != nil && p.spec.DrainTimeout != "" {
+		timeout, _ = time.ParseDuration(p.spec.DrainTimeout)
+	}
+
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for name, filter := range p.filters {
+		if p.handedOver[name] {
+			continue
+		}
+		drainer, ok := filter.(filters.Drainer)
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(name string, drainer filters.Drainer) {
+			defer wg.Done()
+			if err := drainer.Drain(ctx); err != nil {
+				logger.Warnf("pipeline %s: drain filter %s failed, pending work may be dropped: %v",
+					p.superSpec.Name(), name, err)
+			}
+		}(filter.Name(), drainer)
 	}
+	wg.Wait()
 }
 
 // ToMetrics implements easemonitor.Metricer.
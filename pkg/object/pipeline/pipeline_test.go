@@ -18,10 +18,14 @@
 package pipeline
 
 import (
+	stdcontext "context"
 	"fmt"
+	"io"
 	"net/http"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters"
@@ -37,13 +41,15 @@ func init() {
 }
 
 type MockedFilter struct {
-	kind  *filters.Kind
-	spec  *MockedSpec
-	count int
+	kind   *filters.Kind
+	spec   *MockedSpec
+	count  int
+	closed bool
 }
 
 type MockedSpec struct {
 	filters.BaseSpec `json:",inline"`
+	Value            string `json:"value,omitempty"`
 }
 
 type MockedStatus struct {
@@ -53,7 +59,7 @@ type MockedStatus struct {
 func (m *MockedFilter) Name() string                              { return m.spec.Name() }
 func (m *MockedFilter) Kind() *filters.Kind                       { return m.kind }
 func (m *MockedFilter) Spec() filters.Spec                        { return nil }
-func (m *MockedFilter) Close()                                    {}
+func (m *MockedFilter) Close()                                    { m.closed = true }
 func (m *MockedFilter) Init()                                     {}
 func (m *MockedFilter) Inherit(previousGeneration filters.Filter) {}
 
@@ -71,6 +77,7 @@ func (m *MockedFilter) Handle(ctx *context.Context) (result string) {
 	if r, ok := req.(*httpprot.Request); ok {
 		k, v := m.HeaderKV()
 		r.HTTPHeader().Set(k, v)
+		return r.HTTPHeader().Get("X-Mock-Result")
 	}
 	return ""
 }
@@ -187,6 +194,56 @@ filters:
 		_, err := supervisor.NewSpec(spec)
 		assert.NotNil(t, err, "invalid spec")
 	})
+
+	t.Run("request buffering", func(t *testing.T) {
+		cleanup()
+		filters.Register(MockFilterKind("mock-filter", nil))
+		k := MockFilterKind("mock-buffering-filter", nil)
+		k.RequiresBuffering = true
+		filters.Register(k)
+
+		spec := `name: pipeline
+kind: Pipeline
+requestBuffering:
+  strategy: stream
+filters:
+- name: filter-1
+  kind: mock-filter`
+		_, err := supervisor.NewSpec(spec)
+		assert.Nil(t, err, "valid spec")
+
+		spec = `name: pipeline
+kind: Pipeline
+requestBuffering:
+  strategy: stream
+filters:
+- name: filter-1
+  kind: mock-filter
+- name: filter-2
+  kind: mock-buffering-filter`
+		_, err = supervisor.NewSpec(spec)
+		assert.NotNil(t, err, "buffering filter in streaming pipeline")
+
+		spec = `name: pipeline
+kind: Pipeline
+requestBuffering:
+  strategy: full
+filters:
+- name: filter-2
+  kind: mock-buffering-filter`
+		_, err = supervisor.NewSpec(spec)
+		assert.Nil(t, err, "valid spec")
+
+		spec = `name: pipeline
+kind: Pipeline
+requestBuffering:
+  strategy: threshold
+filters:
+- name: filter-1
+  kind: mock-filter`
+		_, err = supervisor.NewSpec(spec)
+		assert.NotNil(t, err, "threshold is required")
+	})
 }
 
 func TestRegistry(t *testing.T) {
@@ -282,7 +339,7 @@ filters:
 	if err != nil {
 		t.Errorf("failed to create spec %s", err)
 	}
-	pipeline := Pipeline{nil, nil, map[string]filters.Filter{}, nil, nil}
+	pipeline := Pipeline{filters: map[string]filters.Filter{}}
 	pipeline.Init(superSpec, nil)
 	pipeline.Inherit(superSpec, &pipeline, nil)
 
@@ -330,7 +387,7 @@ filters:
 	if err != nil {
 		t.Errorf("failed to create spec %s", err)
 	}
-	pipeline := Pipeline{nil, nil, map[string]filters.Filter{}, nil, nil}
+	pipeline := Pipeline{filters: map[string]filters.Filter{}}
 	pipeline.Init(superSpec, nil)
 	pipeline.Inherit(superSpec, &pipeline, nil)
 
@@ -497,4 +554,729 @@ filters:
 	assert.Contains(tags, "filter1")
 	assert.NotContains(tags, "filter2")
 	assert.NotContains(tags, "filter3")
+
+	// the response flows run in the reverse order of the request flows.
+	newPipeline := func(yamlConfig string) *Pipeline {
+		spec, err := supervisor.NewSpec(yamlConfig)
+		assert.Nil(err)
+		p := &Pipeline{}
+		p.Init(spec, nil)
+		return p
+	}
+	before = newPipeline(`
+name: http-pipeline-before
+kind: Pipeline
+flow:
+  - filter: filter1
+responseFlow:
+  - filter: beforeResponse
+filters:
+  - name: filter1
+    kind: Filter1
+  - name: beforeResponse
+    kind: Filter1
+`)
+	defer before.Close()
+	after = newPipeline(`
+name: http-pipeline-after
+kind: Pipeline
+flow:
+  - filter: filter3
+responseFlow:
+  - filter: afterResponse
+filters:
+  - name: filter3
+    kind: Filter1
+  - name: afterResponse
+    kind: Filter1
+`)
+	defer after.Close()
+
+	ctx = context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	pipeline.HandleWithBeforeAfter(ctx, before, after, HandleWithBeforeAfterOption{})
+	tags = ctx.Tags()
+	assert.Contains(tags, "afterResponse")
+	assert.Contains(tags, "beforeResponse")
+	assert.Less(strings.Index(tags, "filter3"), strings.Index(tags, "afterResponse"))
+	assert.Less(strings.Index(tags, "afterResponse"), strings.Index(tags, "beforeResponse"))
+
+	// the response flow of the after pipeline doesn't run if its request
+	// flow doesn't.
+	beforeEnd := newPipeline(`
+name: http-pipeline-before
+kind: Pipeline
+flow:
+  - filter: filter1
+  - filter: END
+responseFlow:
+  - filter: beforeResponse
+filters:
+  - name: filter1
+    kind: Filter1
+  - name: beforeResponse
+    kind: Filter1
+`)
+	defer beforeEnd.Close()
+
+	ctx = context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	pipeline.HandleWithBeforeAfter(ctx, beforeEnd, after, HandleWithBeforeAfterOption{})
+	tags = ctx.Tags()
+	assert.NotContains(tags, "filter3")
+	assert.NotContains(tags, "afterResponse")
+	assert.Contains(tags, "beforeResponse")
+}
+
+func TestHandleResponseFlow(t *testing.T) {
+	assert := assert.New(t)
+
+	filters.Register(MockFilterKind("Filter1", nil))
+	reqOnly := MockFilterKind("Filter2", nil)
+	reqOnly.RequestOnly = true
+	filters.Register(reqOnly)
+	defer cleanup()
+
+	yamlConfig := `
+name: http-pipeline-test
+kind: Pipeline
+responseFlow:
+  - filter: filter2
+filters:
+  - name: filter1
+    kind: Filter2
+  - name: filter2
+    kind: Filter1
+`
+	spec, err := supervisor.NewSpec(yamlConfig)
+	assert.Nil(err)
+
+	pipeline := &Pipeline{}
+	pipeline.Init(spec, nil)
+	defer pipeline.Close()
+
+	stdReq, err := http.NewRequest(http.MethodGet, "http://localhost:9095", nil)
+	assert.Nil(err)
+	req, err := httpprot.NewRequest(stdReq)
+	assert.Nil(err)
+
+	ctx := context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	pipeline.Handle(ctx)
+
+	// filter2 is in the response flow, so it is excluded from the
+	// default flow and runs only once.
+	assert.Equal(1, len(pipeline.flow))
+	assert.Equal(1, MockGetFilter(pipeline, "filter1").(*MockedFilter).count)
+	assert.Equal(1, MockGetFilter(pipeline, "filter2").(*MockedFilter).count)
+	tags := ctx.Tags()
+	assert.Contains(tags, "filter1")
+	assert.Contains(tags, "filter2")
+
+	ctx = context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	pipeline.HandleWithBeforeAfter(ctx, nil, nil, HandleWithBeforeAfterOption{})
+	assert.Equal(2, MockGetFilter(pipeline, "filter2").(*MockedFilter).count)
+
+	// request-only filters are not allowed in the response flow.
+	yamlConfig = `
+name: http-pipeline-test
+kind: Pipeline
+flow:
+  - filter: filter2
+responseFlow:
+  - filter: filter1
+filters:
+  - name: filter1
+    kind: Filter2
+  - name: filter2
+    kind: Filter1
+`
+	_, err = supervisor.NewSpec(yamlConfig)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "response flow")
+}
+
+type DrainableFilter struct {
+	MockedFilter
+	delay  time.Duration
+	err    error
+	closed bool
+}
+
+func (d *DrainableFilter) Drain(ctx stdcontext.Context) error {
+	select {
+	case <-time.After(d.delay):
+		d.err = nil
+	case <-ctx.Done():
+		d.err = ctx.Err()
+	}
+	return d.err
+}
+
+func (d *DrainableFilter) Close() {
+	d.closed = true
+}
+
+func TestDrain(t *testing.T) {
+	assert := assert.New(t)
+
+	k := MockFilterKind("Drainable", nil)
+	k.CreateInstance = func(spec filters.Spec) filters.Filter {
+		return &DrainableFilter{MockedFilter: MockedFilter{kind: k, spec: spec.(*MockedSpec)}}
+	}
+	filters.Register(k)
+	filters.Register(MockFilterKind("Filter1", nil))
+	defer cleanup()
+
+	yamlConfig := `
+name: http-pipeline-test
+kind: Pipeline
+drainTimeout: 50ms
+filters:
+  - name: fast
+    kind: Drainable
+  - name: slow
+    kind: Drainable
+  - name: filter1
+    kind: Filter1
+`
+	spec, err := supervisor.NewSpec(yamlConfig)
+	assert.Nil(err)
+
+	pipeline := &Pipeline{}
+	pipeline.Init(spec, nil)
+	fast := MockGetFilter(pipeline, "fast").(*DrainableFilter)
+	slow := MockGetFilter(pipeline, "slow").(*DrainableFilter)
+	slow.delay = time.Hour
+
+	start := time.Now()
+	pipeline.Close()
+	assert.Less(time.Since(start), time.Second)
+	assert.Nil(fast.err)
+	assert.Equal(stdcontext.DeadlineExceeded, slow.err)
+	assert.True(fast.closed)
+	assert.True(slow.closed)
+
+	_, err = supervisor.NewSpec(`
+name: http-pipeline-test
+kind: Pipeline
+drainTimeout: -1s
+filters:
+  - name: filter1
+    kind: Filter1
+`)
+	assert.NotNil(err)
+}
+
+type HealthReportingFilter struct {
+	MockedFilter
+	report *filters.HealthReport
+}
+
+func (h *HealthReportingFilter) HealthReport() *filters.HealthReport {
+	return h.report
+}
+
+func TestStatusHealth(t *testing.T) {
+	assert := assert.New(t)
+
+	k := MockFilterKind("HealthReporting", nil)
+	k.CreateInstance = func(spec filters.Spec) filters.Filter {
+		return &HealthReportingFilter{MockedFilter: MockedFilter{kind: k, spec: spec.(*MockedSpec)}}
+	}
+	filters.Register(k)
+	filters.Register(MockFilterKind("Filter1", nil))
+	defer cleanup()
+
+	yamlConfig := `
+name: http-pipeline-test
+kind: Pipeline
+filters:
+  - name: reporter
+    kind: HealthReporting
+  - name: filter1
+    kind: Filter1
+`
+	spec, err := supervisor.NewSpec(yamlConfig)
+	assert.Nil(err)
+
+	pipeline := &Pipeline{}
+	pipeline.Init(spec, nil)
+	defer pipeline.Close()
+
+	status := pipeline.Status().ObjectStatus.(*Status)
+	assert.Equal(filters.HealthHealthy, status.Health)
+	assert.Len(status.Summary, 2)
+	assert.Equal("filter1", status.Summary[0].Name)
+	assert.Equal("Filter1", status.Summary[0].Kind)
+	assert.Equal(filters.HealthHealthy, status.Summary[0].Health)
+	assert.Equal("reporter", status.Summary[1].Name)
+	assert.Equal(filters.HealthHealthy, status.Summary[1].Health)
+
+	reporter := MockGetFilter(pipeline, "reporter").(*HealthReportingFilter)
+	reporter.report = &filters.HealthReport{
+		Health:  filters.HealthDegraded,
+		Reason:  "circuit breaker is open",
+		Metrics: map[string]interface{}{"requests": 10},
+	}
+	status = pipeline.Status().ObjectStatus.(*Status)
+	assert.Equal(filters.HealthDegraded, status.Health)
+	assert.Equal(filters.HealthHealthy, status.Summary[0].Health)
+	assert.Equal(filters.HealthDegraded, status.Summary[1].Health)
+	assert.Equal("circuit breaker is open", status.Summary[1].Reason)
+	assert.Equal(10, status.Summary[1].Metrics["requests"])
+}
+
+func TestStatusUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	filters.Register(MockFilterKind("Filter1", nil))
+	filters.Register(MockFilterKind("Filter2", nil))
+	defer cleanup()
+
+	yamlConfig := `
+name: http-pipeline-test
+kind: Pipeline
+flow:
+  - filter: filter1
+  - filter: filter2
+    jumpIf:
+      "": END
+  - filter: filter3
+filters:
+  - name: filter1
+    kind: Filter1
+  - name: filter2
+    kind: Filter2
+  - name: filter3
+    kind: Filter2
+`
+	superSpec, err := supervisor.NewSpec(yamlConfig)
+	assert.Nil(err)
+
+	pipeline := &Pipeline{}
+	pipeline.Init(superSpec, nil)
+
+	for i := 0; i < 3; i++ {
+		stdReq, err := http.NewRequest(http.MethodGet, "http://localhost:9095", nil)
+		assert.Nil(err)
+		req, err := httpprot.NewRequest(stdReq)
+		assert.Nil(err)
+		ctx := context.New(tracing.NoopSpan)
+		ctx.SetRequest(context.DefaultNamespace, req)
+		pipeline.Handle(ctx)
+	}
+
+	status := pipeline.Status().ObjectStatus.(*Status)
+	assert.Len(status.Usage, 3)
+	assert.Equal(uint64(3), status.Usage["filter1"].Requests)
+	assert.Equal(uint64(3), status.Usage["filter2"].Requests)
+	assert.Equal(uint64(0), status.Usage["filter3"].Requests)
+	assert.Equal("0s", status.Usage["filter3"].AverageElapsed)
+	assert.Equal(float64(0), status.Usage["filter3"].Share)
+
+	// usage is kept after the pipeline is updated.
+	newPipeline := &Pipeline{}
+	newPipeline.Inherit(superSpec, pipeline, nil)
+	defer newPipeline.Close()
+	status = newPipeline.Status().ObjectStatus.(*Status)
+	assert.Equal(uint64(3), status.Usage["filter1"].Requests)
+}
+
+func TestFilterUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	usage := map[string]*filterUsage{"a": {}, "b": {}}
+	usage["a"].record(time.Millisecond)
+	usage["a"].record(3 * time.Millisecond)
+	usage["b"].record(4 * time.Millisecond)
+	usage["b"].record(-time.Millisecond)
+
+	status := usageStatus(usage)
+	assert.Equal(uint64(2), status["a"].Requests)
+	assert.Equal("4ms", status["a"].TotalElapsed)
+	assert.Equal("2ms", status["a"].AverageElapsed)
+	assert.Equal("3ms", status["a"].MaxElapsed)
+	assert.Equal(float64(50), status["a"].Share)
+	assert.Equal(uint64(2), status["b"].Requests)
+	assert.Equal("4ms", status["b"].MaxElapsed)
+	assert.Equal(float64(50), status["b"].Share)
+}
+
+func TestTrace(t *testing.T) {
+	assert := assert.New(t)
+
+	filters.Register(MockFilterKind("Filter1", nil))
+	defer cleanup()
+
+	yamlConfig := `
+name: http-pipeline-test
+kind: Pipeline
+trace:
+  header: X-Debug
+  value: secret
+  responseHeader: X-Trace
+  log: true
+filters:
+  - name: filter1
+    kind: Filter1
+  - name: filter2
+    kind: Filter1
+`
+	spec, err := supervisor.NewSpec(yamlConfig)
+	assert.Nil(err)
+
+	pipeline := &Pipeline{}
+	pipeline.Init(spec, nil)
+	defer pipeline.Close()
+
+	handle := func(debug string) (*httpprot.Request, *httpprot.Response) {
+		stdReq, _ := http.NewRequest(http.MethodGet, "http://localhost:9095", nil)
+		if debug != "" {
+			stdReq.Header.Set("X-Debug", debug)
+		}
+		req, _ := httpprot.NewRequest(stdReq)
+		resp, _ := httpprot.NewResponse(nil)
+
+		ctx := context.New(tracing.NoopSpan)
+		ctx.SetRequest(context.DefaultNamespace, req)
+		ctx.SetResponse(context.DefaultNamespace, resp)
+		pipeline.Handle(ctx)
+		return req, resp
+	}
+
+	_, resp := handle("")
+	assert.Equal("", resp.HTTPHeader().Get("X-Trace"))
+
+	req, resp := handle("wrong")
+	assert.Equal("", resp.HTTPHeader().Get("X-Trace"))
+	assert.Equal("wrong", req.HTTPHeader().Get("X-Debug"))
+
+	req, resp = handle("secret")
+	trace := resp.HTTPHeader().Get("X-Trace")
+	assert.Contains(trace, "pipeline(http-pipeline-test): filter1(")
+	assert.Contains(trace, "->filter2(")
+	assert.Equal("", req.HTTPHeader().Get("X-Debug"))
+
+	// the jumps are recorded.
+	stats := []FilterStat{
+		{Name: "filter1", Result: "invalid", Duration: time.Millisecond, Jump: "filter3"},
+		{Name: "filter3", Duration: time.Millisecond},
+	}
+	assert.Equal("pipeline(http-pipeline-test): filter1(invalid,1ms,jump:filter3)->filter3(1ms)", pipeline.serializeStats(stats))
+
+	// invalid trace specs.
+	yamlConfig = `
+name: http-pipeline-test
+kind: Pipeline
+trace:
+  header: X-Debug
+filters:
+  - name: filter1
+    kind: Filter1
+`
+	_, err = supervisor.NewSpec(yamlConfig)
+	assert.NotNil(err)
+
+	yamlConfig = `
+name: http-pipeline-test
+kind: Pipeline
+trace:
+  value: secret
+  log: true
+filters:
+  - name: filter1
+    kind: Filter1
+`
+	_, err = supervisor.NewSpec(yamlConfig)
+	assert.NotNil(err)
+}
+
+func TestResponseDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	filters.Register(MockFilterKind("Filter1", nil))
+	defer cleanup()
+
+	yamlConfig := `
+name: http-pipeline-test
+kind: Pipeline
+responseDefaults:
+  headers:
+    Cache-Control: no-store
+    X-Gateway: easegress
+  bodyPrefix: '{"data":'
+  bodySuffix: '}'
+filters:
+  - name: filter1
+    kind: Filter1
+`
+	spec, err := supervisor.NewSpec(yamlConfig)
+	assert.Nil(err)
+
+	pipeline := &Pipeline{}
+	pipeline.Init(spec, nil)
+	defer pipeline.Close()
+
+	handle := func(resp *httpprot.Response) {
+		stdReq, _ := http.NewRequest(http.MethodGet, "http://localhost:9095", nil)
+		req, _ := httpprot.NewRequest(stdReq)
+		ctx := context.New(tracing.NoopSpan)
+		ctx.SetRequest(context.DefaultNamespace, req)
+		ctx.SetResponse(context.DefaultNamespace, resp)
+		pipeline.Handle(ctx)
+	}
+
+	resp, _ := httpprot.NewResponse(nil)
+	resp.HTTPHeader().Set("Cache-Control", "max-age=60")
+	resp.HTTPHeader().Set("Content-Length", "6")
+	resp.SetPayload([]byte(`[1, 2]`))
+	handle(resp)
+	assert.Equal("max-age=60", resp.HTTPHeader().Get("Cache-Control"))
+	assert.Equal("easegress", resp.HTTPHeader().Get("X-Gateway"))
+	assert.Equal(`{"data":[1, 2]}`, string(resp.RawPayload()))
+	assert.Equal("15", resp.HTTPHeader().Get("Content-Length"))
+
+	// empty, encoded and stream bodies are not wrapped.
+	resp, _ = httpprot.NewResponse(nil)
+	handle(resp)
+	assert.Equal("no-store", resp.HTTPHeader().Get("Cache-Control"))
+	assert.Equal(int64(0), resp.PayloadSize())
+
+	resp, _ = httpprot.NewResponse(nil)
+	resp.HTTPHeader().Set("Content-Encoding", "gzip")
+	resp.SetPayload([]byte("gzipped"))
+	handle(resp)
+	assert.Equal("gzipped", string(resp.RawPayload()))
+
+	resp, _ = httpprot.NewResponse(nil)
+	resp.SetPayload(strings.NewReader("stream"))
+	handle(resp)
+	data, _ := io.ReadAll(resp.GetPayload())
+	assert.Equal("stream", string(data))
+}
+
+func TestGuard(t *testing.T) {
+	assert := assert.New(t)
+
+	filters.Register(MockFilterKind("Filter1", []string{"serverError"}))
+	defer cleanup()
+
+	yamlConfig := `
+name: http-pipeline-test
+kind: Pipeline
+guard:
+  failureResults: [serverError]
+  minimumNumberOfCalls: 4
+  probeInterval: 50ms
+  probeRequests: 1
+  response:
+    statusCode: 502
+    headers:
+      X-Guard: open
+    body: broken
+filters:
+  - name: filter1
+    kind: Filter1
+`
+	spec, err := supervisor.NewSpec(yamlConfig)
+	assert.Nil(err)
+
+	pipeline := &Pipeline{}
+	pipeline.Init(spec, nil)
+	defer pipeline.Close()
+
+	handle := func(result string) (string, *httpprot.Response) {
+		stdReq, _ := http.NewRequest(http.MethodGet, "http://localhost:9095", nil)
+		stdReq.Header.Set("X-Mock-Result", result)
+		req, _ := httpprot.NewRequest(stdReq)
+		ctx := context.New(tracing.NoopSpan)
+		ctx.SetRequest(context.DefaultNamespace, req)
+		result = pipeline.Handle(ctx)
+		resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+		return result, resp
+	}
+
+	guardStatus := func() *GuardStatus {
+		return pipeline.Status().ObjectStatus.(*Status).Guard
+	}
+
+	handle("")
+	handle("")
+	handle("serverError")
+	assert.Equal("Closed", guardStatus().State)
+	handle("serverError")
+	assert.Equal("Open", guardStatus().State)
+	assert.Equal(filters.HealthDegraded, pipeline.Status().ObjectStatus.(*Status).Health)
+
+	filter := pipeline.getFilter("filter1").(*MockedFilter)
+	count := filter.count
+	result, resp := handle("")
+	assert.Equal(resultGuardRejected, result)
+	assert.Equal(count, filter.count)
+	assert.Equal(502, resp.StatusCode())
+	assert.Equal("open", resp.HTTPHeader().Get("X-Guard"))
+	assert.Equal("broken", string(resp.RawPayload()))
+	assert.Equal(uint64(1), guardStatus().Rejected)
+
+	// the guard state is inherited if the guard is not changed.
+	newPipeline := &Pipeline{}
+	newPipeline.Inherit(spec, pipeline, nil)
+	pipeline = newPipeline
+	assert.Equal("Open", guardStatus().State)
+
+	// a failed probe opens the guard again.
+	time.Sleep(60 * time.Millisecond)
+	result, _ = handle("serverError")
+	assert.Equal("serverError", result)
+	assert.Equal("Open", guardStatus().State)
+	result, _ = handle("")
+	assert.Equal(resultGuardRejected, result)
+
+	// a successful probe closes the guard.
+	time.Sleep(60 * time.Millisecond)
+	result, _ = handle("")
+	assert.Equal("", result)
+	assert.Equal("Closed", guardStatus().State)
+	result, _ = handle("")
+	assert.Equal("", result)
+
+	// invalid guard specs.
+	for _, guard := range []string{
+		"failureResults: []",
+		"{failureResults: [serverError], window: 100ms}",
+		"{failureResults: [serverError], probeInterval: -1s}",
+	} {
+		_, err = supervisor.NewSpec(`
+name: http-pipeline-test
+kind: Pipeline
+guard: ` + guard + `
+filters:
+  - name: filter1
+    kind: Filter1
+`)
+		assert.NotNil(err, guard)
+	}
+}
+
+type MockedRecreatorFilter struct {
+	MockedFilter
+}
+
+func (m *MockedRecreatorFilter) AlwaysRecreate() bool { return true }
+
+func TestReuseFilters(t *testing.T) {
+	assert := assert.New(t)
+
+	filters.Register(MockFilterKind("Filter1", nil))
+	recreatorKind := MockFilterKind("Recreator", nil)
+	recreatorKind.CreateInstance = func(spec filters.Spec) filters.Filter {
+		return &MockedRecreatorFilter{MockedFilter{kind: recreatorKind, spec: spec.(*MockedSpec)}}
+	}
+	filters.Register(recreatorKind)
+	defer cleanup()
+
+	newSpec := func(value string) *supervisor.Spec {
+		spec, err := supervisor.NewSpec(`
+name: http-pipeline-test
+kind: Pipeline
+filters:
+  - name: filter1
+    kind: Filter1
+  - name: filter2
+    kind: Filter1
+    value: ` + value + `
+  - name: filter3
+    kind: Recreator
+`)
+		assert.Nil(err)
+		return spec
+	}
+
+	handle := func(p *Pipeline) {
+		stdReq, _ := http.NewRequest(http.MethodGet, "http://localhost:9095", nil)
+		req, _ := httpprot.NewRequest(stdReq)
+		ctx := context.New(tracing.NoopSpan)
+		ctx.SetRequest(context.DefaultNamespace, req)
+		p.Handle(ctx)
+	}
+
+	pipeline := &Pipeline{}
+	pipeline.Init(newSpec("a"), nil)
+	handle(pipeline)
+
+	f1 := pipeline.getFilter("filter1").(*MockedFilter)
+	f2 := pipeline.getFilter("filter2").(*MockedFilter)
+	f3 := pipeline.getFilter("filter3").(*MockedRecreatorFilter)
+
+	newPipeline := &Pipeline{}
+	newPipeline.Inherit(newSpec("b"), pipeline, nil)
+	defer newPipeline.Close()
+
+	// the unchanged filter is reused with its state and is not closed.
+	assert.Same(f1, newPipeline.getFilter("filter1"))
+	assert.False(f1.closed)
+	assert.Equal(1, f1.count)
+
+	// the changed filter and the recreator are recreated.
+	assert.NotSame(f2, newPipeline.getFilter("filter2"))
+	assert.True(f2.closed)
+	assert.NotSame(f3, newPipeline.getFilter("filter3"))
+	assert.True(f3.closed)
+
+	handle(newPipeline)
+	assert.Equal(2, f1.count)
+	assert.Equal(1, newPipeline.getFilter("filter2").(*MockedFilter).count)
+}
+
+func TestHandleRecordResult(t *testing.T) {
+	assert := assert.New(t)
+
+	filters.Register(MockFilterKind("Filter1", []string{"invalid"}))
+	defer cleanup()
+
+	yamlConfig := `
+name: http-pipeline-test
+kind: Pipeline
+flow:
+  - filter: filter1
+    jumpIf: {invalid: filter2}
+  - filter: filter2
+filters:
+  - name: filter1
+    kind: Filter1
+  - name: filter2
+    kind: Filter1
+`
+	spec, err := supervisor.NewSpec(yamlConfig)
+	assert.Nil(err)
+
+	pipeline := &Pipeline{}
+	pipeline.Init(spec, nil)
+	defer pipeline.Close()
+
+	stdReq, err := http.NewRequest(http.MethodGet, "http://localhost:9095", nil)
+	assert.Nil(err)
+	req, err := httpprot.NewRequest(stdReq)
+	assert.Nil(err)
+
+	ctx := context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	assert.Equal("", pipeline.Handle(ctx))
+	assert.Equal("", ctx.GetResult())
+
+	stdReq.Header.Set("X-Mock-Result", "invalid")
+	ctx = context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	assert.Equal("invalid", pipeline.Handle(ctx))
+	assert.Equal("invalid", ctx.GetResult())
+
+	// only the jump of jumpIf is recorded, not the end of the flow.
+	ctx = context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	_, stats, _ := pipeline.doHandle(ctx, pipeline.flow, nil)
+	assert.Len(stats, 2)
+	assert.Equal("filter2", stats[0].Jump)
+	assert.Equal("", stats[1].Jump)
 }
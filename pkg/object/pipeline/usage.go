@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pipeline
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// FilterUsage is the accumulated handling time of a filter, it helps
+	// operators to find the slow filters of a pipeline. It is not resource
+	// accounting: the elapsed time is wall-clock time, not CPU time, it
+	// includes the time waiting for I/O and the time of the filters called
+	// by the filter, the time a Proxy waits for the backends for example,
+	// and memory allocations are not measured.
+	FilterUsage struct {
+		// Requests is the number of requests handled by the filter.
+		Requests uint64 `json:"requests"`
+		// TotalElapsed is the total elapsed time of the Handle calls of
+		// the filter.
+		TotalElapsed string `json:"totalElapsed"`
+		// AverageElapsed is the average elapsed time of one Handle call.
+		AverageElapsed string `json:"averageElapsed"`
+		// MaxElapsed is the max elapsed time of one Handle call.
+		MaxElapsed string `json:"maxElapsed"`
+		// Share is the percentage of TotalElapsed in the total elapsed
+		// time of all filters of the pipeline.
+		Share float64 `json:"share"`
+	}
+
+	// filterUsage accumulates the handling time of a filter.
+	filterUsage struct {
+		requests     uint64
+		totalElapsed uint64
+		maxElapsed   uint64
+	}
+)
+
+func (u *filterUsage) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	atomic.AddUint64(&u.requests, 1)
+	atomic.AddUint64(&u.totalElapsed, uint64(d))
+
+	for {
+		max := atomic.LoadUint64(&u.maxElapsed)
+		if uint64(d) <= max || atomic.CompareAndSwapUint64(&u.maxElapsed, max, uint64(d)) {
+			return
+		}
+	}
+}
+
+// usageStatus returns the usage of all filters, keyed by filter name.
+func usageStatus(usage map[string]*filterUsage) map[string]*FilterUsage {
+	result := make(map[string]*FilterUsage, len(usage))
+
+	var sum uint64
+	for name, u := range usage {
+		requests := atomic.LoadUint64(&u.requests)
+		total := atomic.LoadUint64(&u.totalElapsed)
+		fu := &FilterUsage{
+			Requests:       requests,
+			TotalElapsed:   time.Duration(total).String(),
+			AverageElapsed: time.Duration(0).String(),
+			MaxElapsed:     time.Duration(atomic.LoadUint64(&u.maxElapsed)).String(),
+		}
+		if requests > 0 {
+			fu.AverageElapsed = time.Duration(total / requests).String()
+		}
+		// Share temporarily holds the total time to avoid loading it again,
+		// which may be changed by concurrent requests.
+		fu.Share = float64(total)
+		sum += total
+		result[name] = fu
+	}
+
+	for _, fu := range result {
+		if sum == 0 {
+			fu.Share = 0
+		} else {
+			fu.Share = fu.Share * 100 / float64(sum)
+		}
+	}
+
+	return result
+}
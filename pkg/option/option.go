@@ -79,6 +79,11 @@ type Options struct {
 	ObjectsDumpInterval      string            `yaml:"objects-dump-interval"`
 	BasicAuth                map[string]string `yaml:"basic-auth"`
 
+	// State events of breakers, rate limiters and health checkers.
+	StateEventLog            bool     `yaml:"state-event-log"`
+	StateEventWebhooks       []string `yaml:"state-event-webhooks"`
+	StateEventWebhookTimeout string   `yaml:"state-event-webhook-timeout"`
+
 	// cluster options
 	UseStandaloneEtcd     bool           `yaml:"use-standalone-etcd"`
 	ClusterName           string         `yaml:"cluster-name"`
@@ -150,6 +155,9 @@ func New() *Options {
 	opt.flags.StringSliceVar(&opt.InitialObjectConfigFiles, "initial-object-config-files", nil, "List of configuration files for initial objects, these objects will be created at startup if not already exist.")
 	opt.flags.StringVar(&opt.ObjectsDumpInterval, "objects-dump-interval", "", "The time interval to dump running objects config, for example: 30m")
 	opt.flags.BoolVar(&opt.DisableAccessLog, "disable-access", false, "Flag to set whether to disable access logs")
+	opt.flags.BoolVar(&opt.StateEventLog, "state-event-log", false, "Flag to log state transitions of circuit breakers, rate limiters and health checkers as structured events.")
+	opt.flags.StringSliceVar(&opt.StateEventWebhooks, "state-event-webhooks", nil, "List of URLs to post state transition events to.")
+	opt.flags.StringVar(&opt.StateEventWebhookTimeout, "state-event-webhook-timeout", "5s", "Timeout to post a state transition event to a webhook.")
 	opt.flags.StringVar(&opt.HomeDir, "home-dir", "./", "Path to the home directory.")
 	opt.flags.StringVar(&opt.DataDir, "data-dir", "data", "Path to the data directory.")
 	opt.flags.StringVar(&opt.WALDir, "wal-dir", "", "Path to the WAL directory.")
@@ -340,6 +348,16 @@ func (opt *Options) validate() error {
 		return fmt.Errorf("invalid api-addr: %v", err)
 	}
 
+	// state events
+	if _, err := ParseURLs(opt.StateEventWebhooks); err != nil {
+		return fmt.Errorf("invalid state-event-webhooks: %v", err)
+	}
+	if opt.StateEventWebhookTimeout != "" {
+		if _, err := time.ParseDuration(opt.StateEventWebhookTimeout); err != nil {
+			return fmt.Errorf("invalid state-event-webhook-timeout: %v", err)
+		}
+	}
+
 	// dirs
 	if opt.HomeDir == "" {
 		return fmt.Errorf("empty home-dir")
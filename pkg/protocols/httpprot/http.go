@@ -20,8 +20,8 @@ package httpprot
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/megaease/easegress/v2/pkg/protocols"
@@ -35,6 +35,38 @@ func init() {
 	protocols.Register("http", &Protocol{})
 }
 
+// payloadFetcher is the payload methods shared by Request and Response.
+type payloadFetcher interface {
+	FetchPayload(maxPayloadSize int64) error
+	SetPayload(payload interface{})
+}
+
+// bufferPayload implements BufferPayload of Request and Response, body and
+// contentLength are the body and the content length of the message.
+func bufferPayload(p payloadFetcher, body io.Reader, contentLength, threshold int64) error {
+	if contentLength > threshold {
+		return p.FetchPayload(-1)
+	}
+	if contentLength >= 0 {
+		return p.FetchPayload(threshold)
+	}
+
+	// the length is unknown, read one more byte to see whether the
+	// payload exceeds the threshold.
+	payload, err := io.ReadAll(io.LimitReader(body, threshold+1))
+	if err != nil {
+		p.SetPayload(payload)
+		return err
+	}
+	if int64(len(payload)) <= threshold {
+		p.SetPayload(payload)
+		return nil
+	}
+
+	p.SetPayload(io.MultiReader(bytes.NewReader(payload), body))
+	return nil
+}
+
 // Header wraps the http header.
 type Header struct {
 	http.Header
@@ -109,9 +141,7 @@ func (p *Protocol) CreateResponse(resp interface{}) (protocols.Response, error)
 
 func parseJSONBody(body []byte) (interface{}, error) {
 	var v interface{}
-	d := json.NewDecoder(bytes.NewReader(body))
-	d.UseNumber()
-	if err := d.Decode(&v); err != nil {
+	if err := codectool.UnmarshalJSONNumber(body, &v); err != nil {
 		return nil, err
 	}
 	return v, nil
@@ -18,6 +18,7 @@
 package httpprot
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -172,6 +173,19 @@ func TestProtocol(t *testing.T) {
 	}
 }
 
+func TestParseJSONBody(t *testing.T) {
+	assert := assert.New(t)
+
+	v, err := parseJSONBody([]byte(` {"id": 12345678901234567890} `))
+	assert.Nil(err)
+	assert.Equal("12345678901234567890", v.(map[string]interface{})["id"].(json.Number).String())
+
+	for _, body := range []string{`{"id": 1}{"id": 2}`, `{"id": 1} x`, `1 2`, `{"id": 1}]`} {
+		_, err = parseJSONBody([]byte(body))
+		assert.NotNil(err, body)
+	}
+}
+
 func TestParseYAMLBody(t *testing.T) {
 	assert := assert.New(t)
 	{
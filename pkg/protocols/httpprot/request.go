@@ -45,7 +45,32 @@ type Request struct {
 	stream  *readers.ByteCountReader
 	payload []byte
 	realIP  string
-}
+
+	// deferred is the max payload size if fetching the payload is
+	// deferred, and zero if not. It is the threshold if deferBuffer is
+	// true.
+	deferred    int64
+	deferBuffer bool
+	deferErr    error
+}
+
+// limitedBody is a body which fails with ErrRequestEntityTooLarge if more
+// than n bytes are read.
+type limitedBody struct {
+	io.ReadCloser
+	n int64
+}
+
+// Strategies to read the payload of requests.
+const (
+	// BufferingFull reads the whole payload into memory.
+	BufferingFull = "full"
+	// BufferingStream treats the payload as a stream.
+	BufferingStream = "stream"
+	// BufferingThreshold reads the payload into memory if it is not
+	// larger than a threshold, and treats it as a stream otherwise.
+	BufferingThreshold = "threshold"
+)
 
 var (
 	// ErrRequestEntityTooLarge means the request entity is too large.
@@ -81,9 +106,111 @@ func NewRequest(stdr *http.Request) (*Request, error) {
 
 // IsStream returns whether the payload of the request is a stream.
 func (r *Request) IsStream() bool {
+	r.fetchDeferredPayload()
 	return r.stream != nil
 }
 
+// DeferPayload is the same as FetchPayload, except that it only checks
+// the Content-Length, and defers reading the body until the payload is
+// accessed or FetchDeferredPayload is called.
+//
+// For a request with "Expect: 100-continue", this defers the sending of
+// the 100 Continue response, as it is sent on the first read of the body.
+func (r *Request) DeferPayload(maxPayloadSize int64) error {
+	if maxPayloadSize == 0 {
+		maxPayloadSize = DefaultMaxPayloadSize
+	}
+
+	// a stream is read on demand, nothing to defer.
+	if maxPayloadSize < 0 {
+		return r.FetchPayload(maxPayloadSize)
+	}
+
+	if r.Request.ContentLength > maxPayloadSize {
+		return ErrRequestEntityTooLarge
+	}
+
+	r.deferred = maxPayloadSize
+	return nil
+}
+
+// DeferBufferPayload is the same as BufferPayload, except that reading
+// the body is deferred like DeferPayload.
+func (r *Request) DeferBufferPayload(threshold int64) error {
+	if threshold <= 0 {
+		return r.BufferPayload(threshold)
+	}
+	r.deferred = threshold
+	r.deferBuffer = true
+	return nil
+}
+
+// LimitPayload limits the body of the underlying http.Request to
+// maxPayloadSize bytes, reading more fails with ErrRequestEntityTooLarge.
+// It must be called before the payload is fetched, and is useful for
+// streams, whose size is unknown before being read. It does nothing if
+// maxPayloadSize is not positive.
+func (r *Request) LimitPayload(maxPayloadSize int64) error {
+	if maxPayloadSize <= 0 {
+		return nil
+	}
+	if r.Request.ContentLength > maxPayloadSize {
+		return ErrRequestEntityTooLarge
+	}
+	r.Request.Body = &limitedBody{ReadCloser: r.Request.Body, n: maxPayloadSize}
+	return nil
+}
+
+// Read implements io.Reader.
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.n < 0 {
+		return 0, ErrRequestEntityTooLarge
+	}
+
+	// read one more byte to know whether the body is too large.
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.n {
+		n, b.n = int(b.n), -1
+		return n, ErrRequestEntityTooLarge
+	}
+	b.n -= int64(n)
+	return n, err
+}
+
+// PayloadDeferred returns whether the payload is deferred and has not
+// been fetched yet.
+func (r *Request) PayloadDeferred() bool {
+	return r.deferred != 0
+}
+
+// FetchDeferredPayload fetches the deferred payload, it returns the
+// result of the fetching, and does nothing if the payload is not deferred
+// or has been fetched.
+func (r *Request) FetchDeferredPayload() error {
+	if r.deferred != 0 {
+		maxPayloadSize, buffer := r.deferred, r.deferBuffer
+		r.deferred, r.deferBuffer = 0, false
+		if buffer {
+			r.deferErr = r.BufferPayload(maxPayloadSize)
+		} else {
+			r.deferErr = r.FetchPayload(maxPayloadSize)
+		}
+	}
+	return r.deferErr
+}
+
+// fetchDeferredPayload fetches the deferred payload on its first access.
+// The error is kept and returned by FetchDeferredPayload, the payload is
+// what has been read in this case.
+func (r *Request) fetchDeferredPayload() {
+	if r.deferred != 0 {
+		r.FetchDeferredPayload()
+	}
+}
+
 // FetchPayload reads the body of the underlying http.Request and initializes
 // the payload.
 //
@@ -143,12 +270,21 @@ func (r *Request) FetchPayload(maxPayloadSize int64) error {
 	return err
 }
 
+// BufferPayload is the same as FetchPayload, except that a payload larger
+// than threshold is treated as a stream instead of being rejected, the
+// bytes have been read are kept at the head of the stream.
+func (r *Request) BufferPayload(threshold int64) error {
+	return bufferPayload(r, r.Request.Body, r.Request.ContentLength, threshold)
+}
+
 // SetPayload set the payload of the request to payload. The payload
 // could be a string, a byte slice, or an io.Reader, and if it is an
 // io.Reader, it will be treated as a stream, if this is not desired,
 // please read the data to a byte slice, and set the byte slice as
 // the payload.
 func (r *Request) SetPayload(payload interface{}) {
+	// the original body is not needed anymore.
+	r.deferred, r.deferBuffer = 0, false
 	r.stream = nil
 	r.payload = nil
 
@@ -176,6 +312,7 @@ func (r *Request) SetPayload(payload interface{}) {
 // returned reader is always a new one, which contains the full data.
 // For stream payload, the function always returns the same reader.
 func (r *Request) GetPayload() io.Reader {
+	r.fetchDeferredPayload()
 	if r.stream != nil {
 		return r.stream
 	}
@@ -188,6 +325,7 @@ func (r *Request) GetPayload() io.Reader {
 // RawPayload returns the payload in []byte, the caller should not
 // modify its content. The function panic if the payload is a stream.
 func (r *Request) RawPayload() []byte {
+	r.fetchDeferredPayload()
 	if r.stream == nil {
 		return r.payload
 	}
@@ -198,6 +336,7 @@ func (r *Request) RawPayload() []byte {
 // stream, it returns the bytes count that have been currently read
 // out.
 func (r *Request) PayloadSize() int64 {
+	r.fetchDeferredPayload()
 	if r.stream == nil {
 		return int64(len(r.payload))
 	}
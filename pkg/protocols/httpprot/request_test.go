@@ -265,3 +265,136 @@ kind: Test
 		assert.Equal("Test", yamlMap["kind"])
 	}
 }
+
+func TestDeferPayload(t *testing.T) {
+	assert := assert.New(t)
+
+	body := readers.NewByteCountReader(strings.NewReader("hello"))
+	stdr, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:8888", body)
+	stdr.ContentLength = 5
+	request, _ := NewRequest(stdr)
+
+	assert.Equal(ErrRequestEntityTooLarge, request.DeferPayload(3))
+	assert.NoError(request.DeferPayload(10))
+	assert.True(request.PayloadDeferred())
+	assert.Equal(0, body.BytesRead())
+
+	// accessing the payload fetches it.
+	assert.Equal([]byte("hello"), request.RawPayload())
+	assert.False(request.PayloadDeferred())
+	assert.NoError(request.FetchDeferredPayload())
+
+	// setting the payload drops the deferred one.
+	body = readers.NewByteCountReader(strings.NewReader("hello"))
+	stdr, _ = http.NewRequest(http.MethodPost, "http://127.0.0.1:8888", body)
+	request, _ = NewRequest(stdr)
+	stdr.ContentLength = -1
+	assert.NoError(request.DeferPayload(3))
+	request.SetPayload("world")
+	assert.False(request.PayloadDeferred())
+	assert.Equal(0, body.BytesRead())
+	assert.Equal([]byte("world"), request.RawPayload())
+
+	// the error is kept.
+	stdr, _ = http.NewRequest(http.MethodPost, "http://127.0.0.1:8888", strings.NewReader("hello"))
+	stdr.ContentLength = -1
+	request, _ = NewRequest(stdr)
+	assert.NoError(request.DeferPayload(3))
+	assert.Equal(ErrRequestEntityTooLarge, request.FetchDeferredPayload())
+	assert.Equal(ErrRequestEntityTooLarge, request.FetchDeferredPayload())
+
+	// streams are not deferred.
+	stdr, _ = http.NewRequest(http.MethodPost, "http://127.0.0.1:8888", strings.NewReader("hello"))
+	request, _ = NewRequest(stdr)
+	assert.NoError(request.DeferPayload(-1))
+	assert.False(request.PayloadDeferred())
+	assert.True(request.IsStream())
+}
+
+func TestBufferPayload(t *testing.T) {
+	assert := assert.New(t)
+
+	newRequest := func(body string, contentLength int64) *Request {
+		stdr, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:8888", strings.NewReader(body))
+		stdr.ContentLength = contentLength
+		request, _ := NewRequest(stdr)
+		return request
+	}
+
+	// the Content-Length is known.
+	request := newRequest("hello", 5)
+	assert.NoError(request.BufferPayload(5))
+	assert.False(request.IsStream())
+	assert.Equal([]byte("hello"), request.RawPayload())
+
+	request = newRequest("hello", 5)
+	assert.NoError(request.BufferPayload(4))
+	assert.True(request.IsStream())
+	data, _ := io.ReadAll(request.GetPayload())
+	assert.Equal("hello", string(data))
+
+	// the Content-Length is unknown.
+	request = newRequest("hello", -1)
+	assert.NoError(request.BufferPayload(5))
+	assert.False(request.IsStream())
+	assert.Equal([]byte("hello"), request.RawPayload())
+
+	request = newRequest("hello", -1)
+	assert.NoError(request.BufferPayload(3))
+	assert.True(request.IsStream())
+	data, _ = io.ReadAll(request.GetPayload())
+	assert.Equal("hello", string(data))
+	assert.Equal(int64(5), request.PayloadSize())
+}
+
+func TestDeferBufferPayload(t *testing.T) {
+	assert := assert.New(t)
+
+	body := readers.NewByteCountReader(strings.NewReader("hello"))
+	stdr, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:8888", body)
+	stdr.ContentLength = -1
+	request, _ := NewRequest(stdr)
+
+	assert.NoError(request.DeferBufferPayload(3))
+	assert.True(request.PayloadDeferred())
+	assert.Equal(0, body.BytesRead())
+
+	assert.True(request.IsStream())
+	assert.False(request.PayloadDeferred())
+	data, _ := io.ReadAll(request.GetPayload())
+	assert.Equal("hello", string(data))
+}
+
+func TestLimitPayload(t *testing.T) {
+	assert := assert.New(t)
+
+	newRequest := func(body string, contentLength int64) *Request {
+		stdr, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:8888", strings.NewReader(body))
+		stdr.ContentLength = contentLength
+		request, _ := NewRequest(stdr)
+		return request
+	}
+
+	request := newRequest("hello", 5)
+	assert.Equal(ErrRequestEntityTooLarge, request.LimitPayload(4))
+
+	request = newRequest("hello", -1)
+	assert.NoError(request.LimitPayload(5))
+	assert.NoError(request.FetchPayload(-1))
+	data, err := io.ReadAll(request.GetPayload())
+	assert.NoError(err)
+	assert.Equal("hello", string(data))
+
+	request = newRequest("hello", -1)
+	assert.NoError(request.LimitPayload(4))
+	assert.NoError(request.FetchPayload(-1))
+	data, err = io.ReadAll(request.GetPayload())
+	assert.Equal(ErrRequestEntityTooLarge, err)
+	assert.Equal("hell", string(data))
+
+	// not limited.
+	request = newRequest("hello", -1)
+	assert.NoError(request.LimitPayload(-1))
+	assert.NoError(request.BufferPayload(10))
+	assert.Equal([]byte("hello"), request.RawPayload())
+}
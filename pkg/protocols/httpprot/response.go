@@ -47,6 +47,11 @@ type Response struct {
 // ErrResponseEntityTooLarge means the request entity is too large.
 var ErrResponseEntityTooLarge = fmt.Errorf("response entity too large, you may need to increase 'serverMaxBodySize' or set it to -1")
 
+// ErrResponseAborted is returned, maybe wrapped, by the reader of a stream
+// payload to tell the server to abort the connection to the client, instead
+// of ending the response normally, because the payload is incomplete.
+var ErrResponseAborted = fmt.Errorf("response aborted")
+
 var _ protocols.Response = (*Response)(nil)
 
 // NewResponse creates a new response from a standard response. If stdr is not
@@ -131,6 +136,13 @@ func (r *Response) FetchPayload(maxPayloadSize int64) error {
 	return err
 }
 
+// BufferPayload is the same as FetchPayload, except that a payload larger
+// than threshold is treated as a stream instead of being rejected, the
+// bytes have been read are kept at the head of the stream.
+func (r *Response) BufferPayload(threshold int64) error {
+	return bufferPayload(r, r.Response.Body, r.Response.ContentLength, threshold)
+}
+
 // SetPayload set the payload of the response to payload. The payload
 // could be a string, a byte slice, or an io.Reader, and if it is an
 // io.Reader, it will be treated as a stream, if this is not desired,
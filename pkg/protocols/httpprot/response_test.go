@@ -236,3 +236,41 @@ func TestBuilderResponse(t *testing.T) {
 		assert.NotNil(builderResp)
 	}
 }
+
+func TestResponseBufferPayload(t *testing.T) {
+	assert := assert.New(t)
+
+	newResponse := func(body string, contentLength int64) *Response {
+		stdr := &http.Response{
+			Header:        http.Header{},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: contentLength,
+		}
+		response, _ := NewResponse(stdr)
+		return response
+	}
+
+	// the Content-Length is known.
+	response := newResponse("hello", 5)
+	assert.NoError(response.BufferPayload(5))
+	assert.False(response.IsStream())
+	assert.Equal([]byte("hello"), response.RawPayload())
+
+	response = newResponse("hello", 5)
+	assert.NoError(response.BufferPayload(4))
+	assert.True(response.IsStream())
+	data, _ := io.ReadAll(response.GetPayload())
+	assert.Equal("hello", string(data))
+
+	// the Content-Length is unknown.
+	response = newResponse("hello", -1)
+	assert.NoError(response.BufferPayload(5))
+	assert.False(response.IsStream())
+	assert.Equal([]byte("hello"), response.RawPayload())
+
+	response = newResponse("hello", -1)
+	assert.NoError(response.BufferPayload(3))
+	assert.True(response.IsStream())
+	data, _ = io.ReadAll(response.GetPayload())
+	assert.Equal("hello", string(data))
+}
@@ -20,28 +20,83 @@ package registry
 
 import (
 	// Filters
+	_ "github.com/megaease/easegress/v2/pkg/filters/apiversion"
+	_ "github.com/megaease/easegress/v2/pkg/filters/baggage"
+	_ "github.com/megaease/easegress/v2/pkg/filters/bluegreen"
+	_ "github.com/megaease/easegress/v2/pkg/filters/bodyaggregator"
+	_ "github.com/megaease/easegress/v2/pkg/filters/bodyguard"
+	_ "github.com/megaease/easegress/v2/pkg/filters/bodypatcher"
+	_ "github.com/megaease/easegress/v2/pkg/filters/bodypresence"
 	_ "github.com/megaease/easegress/v2/pkg/filters/builder"
+	_ "github.com/megaease/easegress/v2/pkg/filters/bulkhead"
+	_ "github.com/megaease/easegress/v2/pkg/filters/cacheinvalidator"
 	_ "github.com/megaease/easegress/v2/pkg/filters/certextractor"
+	_ "github.com/megaease/easegress/v2/pkg/filters/charsetnormalizer"
+	_ "github.com/megaease/easegress/v2/pkg/filters/claimrouter"
+	_ "github.com/megaease/easegress/v2/pkg/filters/classifier"
+	_ "github.com/megaease/easegress/v2/pkg/filters/clientcertselector"
+	_ "github.com/megaease/easegress/v2/pkg/filters/cloudsigner"
 	_ "github.com/megaease/easegress/v2/pkg/filters/connectcontrol"
+	_ "github.com/megaease/easegress/v2/pkg/filters/contentlengthguard"
+	_ "github.com/megaease/easegress/v2/pkg/filters/contentnegotiation"
+	_ "github.com/megaease/easegress/v2/pkg/filters/contentrouter"
+	_ "github.com/megaease/easegress/v2/pkg/filters/cookieprotector"
 	_ "github.com/megaease/easegress/v2/pkg/filters/corsadaptor"
+	_ "github.com/megaease/easegress/v2/pkg/filters/dynamictimeout"
+	_ "github.com/megaease/easegress/v2/pkg/filters/enricher"
+	_ "github.com/megaease/easegress/v2/pkg/filters/etaggenerator"
+	_ "github.com/megaease/easegress/v2/pkg/filters/expectcontinue"
+	_ "github.com/megaease/easegress/v2/pkg/filters/externalprocessor"
 	_ "github.com/megaease/easegress/v2/pkg/filters/fallback"
+	_ "github.com/megaease/easegress/v2/pkg/filters/faultinjection"
+	_ "github.com/megaease/easegress/v2/pkg/filters/fragmentcomposer"
+	_ "github.com/megaease/easegress/v2/pkg/filters/graphqlguard"
+	_ "github.com/megaease/easegress/v2/pkg/filters/grpcstatusmapper"
 	_ "github.com/megaease/easegress/v2/pkg/filters/headerlookup"
 	_ "github.com/megaease/easegress/v2/pkg/filters/headertojson"
+	_ "github.com/megaease/easegress/v2/pkg/filters/hostadaptor"
+	_ "github.com/megaease/easegress/v2/pkg/filters/jsontoheader"
 	_ "github.com/megaease/easegress/v2/pkg/filters/kafka"
 	_ "github.com/megaease/easegress/v2/pkg/filters/kafkabackend"
 	_ "github.com/megaease/easegress/v2/pkg/filters/meshadaptor"
+	_ "github.com/megaease/easegress/v2/pkg/filters/metadatainjector"
+	_ "github.com/megaease/easegress/v2/pkg/filters/methodrouter"
 	_ "github.com/megaease/easegress/v2/pkg/filters/mock"
 	_ "github.com/megaease/easegress/v2/pkg/filters/mqttclientauth"
+	_ "github.com/megaease/easegress/v2/pkg/filters/multishadow"
+	_ "github.com/megaease/easegress/v2/pkg/filters/oauth2client"
 	_ "github.com/megaease/easegress/v2/pkg/filters/oidcadaptor"
 	_ "github.com/megaease/easegress/v2/pkg/filters/opafilter"
+	_ "github.com/megaease/easegress/v2/pkg/filters/originguard"
+	_ "github.com/megaease/easegress/v2/pkg/filters/paginator"
+	_ "github.com/megaease/easegress/v2/pkg/filters/pathnormalizer"
+	_ "github.com/megaease/easegress/v2/pkg/filters/prototranscoder"
 	_ "github.com/megaease/easegress/v2/pkg/filters/proxies/grpcproxy"
 	_ "github.com/megaease/easegress/v2/pkg/filters/proxies/httpproxy"
+	_ "github.com/megaease/easegress/v2/pkg/filters/querynormalizer"
+	_ "github.com/megaease/easegress/v2/pkg/filters/quota"
 	_ "github.com/megaease/easegress/v2/pkg/filters/ratelimiter"
+	_ "github.com/megaease/easegress/v2/pkg/filters/recordprocessor"
 	_ "github.com/megaease/easegress/v2/pkg/filters/redirector"
 	_ "github.com/megaease/easegress/v2/pkg/filters/remotefilter"
+	_ "github.com/megaease/easegress/v2/pkg/filters/replayguard"
+	_ "github.com/megaease/easegress/v2/pkg/filters/requestdecompressor"
+	_ "github.com/megaease/easegress/v2/pkg/filters/requestsigner"
+	_ "github.com/megaease/easegress/v2/pkg/filters/responsedelay"
+	_ "github.com/megaease/easegress/v2/pkg/filters/schedulewindow"
+	_ "github.com/megaease/easegress/v2/pkg/filters/schemaguard"
+	_ "github.com/megaease/easegress/v2/pkg/filters/sequencer"
+	_ "github.com/megaease/easegress/v2/pkg/filters/sizerouter"
+	_ "github.com/megaease/easegress/v2/pkg/filters/spikearrest"
+	_ "github.com/megaease/easegress/v2/pkg/filters/streamidletimeout"
+	_ "github.com/megaease/easegress/v2/pkg/filters/streamprocessor"
+	_ "github.com/megaease/easegress/v2/pkg/filters/subsetrouter"
+	_ "github.com/megaease/easegress/v2/pkg/filters/tenant"
+	_ "github.com/megaease/easegress/v2/pkg/filters/tlspolicy"
 	_ "github.com/megaease/easegress/v2/pkg/filters/topicmapper"
 	_ "github.com/megaease/easegress/v2/pkg/filters/validator"
 	_ "github.com/megaease/easegress/v2/pkg/filters/wasmhost"
+	_ "github.com/megaease/easegress/v2/pkg/filters/webhook"
 
 	// Objects
 	_ "github.com/megaease/easegress/v2/pkg/object/autocertmanager"
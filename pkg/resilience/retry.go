@@ -57,6 +57,20 @@ type (
 	}
 )
 
+type retryPermitKey struct{}
+
+// WithRetryPermit returns a copy of ctx carrying permit, which is called
+// before every retry, the retry is given up and the last error is returned
+// if it returns false.
+func WithRetryPermit(ctx context.Context, permit func() bool) context.Context {
+	return context.WithValue(ctx, retryPermitKey{}, permit)
+}
+
+func retryPermitted(ctx context.Context) bool {
+	permit, _ := ctx.Value(retryPermitKey{}).(func() bool)
+	return permit == nil || permit()
+}
+
 // Validate validates the retry policy.
 func (p *RetryPolicy) Validate() error {
 	// TODO
@@ -85,6 +99,9 @@ func (p *RetryPolicy) Wrap(handler HandlerFunc) HandlerFunc {
 			if err == nil {
 				return nil
 			}
+			if attempt+1 < p.MaxAttempts && !retryPermitted(ctx) {
+				return err
+			}
 
 			delta := base * p.RandomizationFactor
 			d := base - delta + float64(rand.Intn(int(delta*2+1)))
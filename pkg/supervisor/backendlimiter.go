@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type (
+	// BackendLimiterSpec describes a rate limiter of the outbound requests
+	// to a backend.
+	BackendLimiterSpec struct {
+		// Backend is the identity of the backend, all users of the same
+		// backend share one limiter, no matter which pipeline they are in.
+		Backend string `json:"backend" jsonschema:"required"`
+		// Rate is the max number of requests per second.
+		Rate float64 `json:"rate" jsonschema:"required"`
+		// Burst is the max number of requests allowed at once, it is the
+		// ceiling of Rate if not set.
+		Burst int `json:"burst,omitempty" jsonschema:"minimum=0"`
+		// Timeout is the max time a request waits for permission, requests
+		// fail at once if it is not set.
+		Timeout string `json:"timeout,omitempty" jsonschema:"format=duration"`
+	}
+
+	// BackendLimiter limits the rate of outbound requests to a backend.
+	BackendLimiter struct {
+		backend string
+		limiter *rate.Limiter
+	}
+)
+
+// Validate validates the BackendLimiterSpec.
+func (spec *BackendLimiterSpec) Validate() error {
+	if spec.Rate <= 0 {
+		return fmt.Errorf("rate must be positive")
+	}
+	if spec.Timeout != "" {
+		if d, err := time.ParseDuration(spec.Timeout); err != nil {
+			return err
+		} else if d < 0 {
+			return fmt.Errorf("timeout must not be negative")
+		}
+	}
+	return nil
+}
+
+func (spec *BackendLimiterSpec) burst() int {
+	if spec.Burst > 0 {
+		return spec.Burst
+	}
+	return int(math.Ceil(spec.Rate))
+}
+
+// TimeoutDuration returns the timeout of the spec.
+func (spec *BackendLimiterSpec) TimeoutDuration() time.Duration {
+	d, _ := time.ParseDuration(spec.Timeout)
+	return d
+}
+
+// BackendLimiter returns the limiter of the backend in the spec, the limiter
+// is created if it does not exist, or its rate and burst are updated to the
+// spec otherwise, that's the last one wins if users of the same backend
+// have different settings.
+func (s *Supervisor) BackendLimiter(spec *BackendLimiterSpec) *BackendLimiter {
+	limit, burst := rate.Limit(spec.Rate), spec.burst()
+
+	bl := &BackendLimiter{
+		backend: spec.Backend,
+		limiter: rate.NewLimiter(limit, burst),
+	}
+	if v, loaded := s.backendLimiters.LoadOrStore(spec.Backend, bl); loaded {
+		bl = v.(*BackendLimiter)
+		bl.limiter.SetLimit(limit)
+		bl.limiter.SetBurst(burst)
+	}
+
+	return bl
+}
+
+// Backend returns the identity of the backend.
+func (bl *BackendLimiter) Backend() string {
+	return bl.backend
+}
+
+// Allow reports whether a request can be sent to the backend now.
+func (bl *BackendLimiter) Allow() bool {
+	return bl.limiter.Allow()
+}
+
+// Wait waits until a request can be sent to the backend. It returns false at
+// once if the request cannot be sent within timeout or before ctx is done.
+func (bl *BackendLimiter) Wait(ctx context.Context, timeout time.Duration) bool {
+	if timeout <= 0 {
+		return bl.limiter.Allow()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return bl.limiter.Wait(ctx) == nil
+}
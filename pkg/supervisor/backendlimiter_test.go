@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package supervisor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackendLimiterSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError((&BackendLimiterSpec{Backend: "b", Rate: 0.5, Timeout: "1s"}).Validate())
+	assert.Error((&BackendLimiterSpec{Backend: "b", Rate: 0}).Validate())
+	assert.Error((&BackendLimiterSpec{Backend: "b", Rate: 1, Timeout: "abc"}).Validate())
+	assert.Error((&BackendLimiterSpec{Backend: "b", Rate: 1, Timeout: "-1s"}).Validate())
+}
+
+func TestBackendLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewDefaultMock()
+	spec := &BackendLimiterSpec{Backend: "backend", Rate: 1, Burst: 2}
+	bl1 := s.BackendLimiter(spec)
+	assert.Equal("backend", bl1.Backend())
+
+	// limiters of the same backend are shared.
+	bl2 := s.BackendLimiter(spec)
+	assert.Same(bl1, bl2)
+	assert.True(bl1.Allow())
+	assert.True(bl2.Allow())
+	assert.False(bl1.Allow())
+	assert.False(bl2.Wait(context.Background(), 0))
+
+	// but not with other backends.
+	bl3 := s.BackendLimiter(&BackendLimiterSpec{Backend: "other", Rate: 1})
+	assert.NotSame(bl1, bl3)
+	assert.True(bl3.Allow())
+
+	// the last spec wins.
+	s.BackendLimiter(&BackendLimiterSpec{Backend: "backend", Rate: 100})
+	start := time.Now()
+	assert.True(bl1.Wait(context.Background(), time.Second))
+	assert.Less(time.Since(start), 500*time.Millisecond)
+
+	// cannot get the permission within the timeout.
+	s.BackendLimiter(&BackendLimiterSpec{Backend: "backend", Rate: 0.01})
+	bl1.Allow()
+	start = time.Now()
+	assert.False(bl1.Wait(context.Background(), 100*time.Millisecond))
+	assert.Less(time.Since(start), 50*time.Millisecond)
+}
@@ -0,0 +1,217 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package supervisor
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// retryBudgetBuckets is the number of buckets of the sliding window.
+const retryBudgetBuckets = 10
+
+type (
+	// RetryBudgetSpec describes a retry budget of a backend, which limits
+	// the retries to a ratio of the requests in a sliding window, to
+	// prevent retry storms from overloading a backend which is already in
+	// trouble.
+	RetryBudgetSpec struct {
+		// Backend is the identity of the backend, all users of the same
+		// backend share one budget, no matter which pipeline they are in.
+		Backend string `json:"backend" jsonschema:"required"`
+		// Ratio is the max ratio of retries to requests in the window.
+		Ratio float64 `json:"ratio" jsonschema:"required,minimum=0,maximum=1"`
+		// MinRetries is the number of retries always allowed in the window
+		// regardless of Ratio, so that a backend with little traffic could
+		// still be retried.
+		MinRetries int `json:"minRetries,omitempty" jsonschema:"minimum=0"`
+		// Window is the length of the sliding window, default is 10s.
+		Window string `json:"window,omitempty" jsonschema:"format=duration"`
+	}
+
+	// RetryBudget limits the retries of outbound requests to a backend.
+	RetryBudget struct {
+		backend string
+
+		lock       sync.Mutex
+		ratio      float64
+		minRetries int
+		bucketSize time.Duration
+		buckets    [retryBudgetBuckets]retryBudgetBucket
+		suppressed uint64
+	}
+
+	retryBudgetBucket struct {
+		slot     int64
+		requests uint64
+		retries  uint64
+	}
+
+	// RetryBudgetStatus is the status of a RetryBudget.
+	RetryBudgetStatus struct {
+		Backend  string `json:"backend"`
+		Requests uint64 `json:"requests"`
+		Retries  uint64 `json:"retries"`
+		// Budget is the number of retries allowed in current window.
+		Budget uint64 `json:"budget"`
+		// Utilization is Retries / Budget, it is 0 if there are no
+		// retries, or 1 if Budget is zero.
+		Utilization float64 `json:"utilization"`
+		// Suppressed is the total number of retries rejected by the budget.
+		Suppressed uint64 `json:"suppressed"`
+	}
+)
+
+// Validate validates the RetryBudgetSpec.
+func (spec *RetryBudgetSpec) Validate() error {
+	if spec.Ratio < 0 || spec.Ratio > 1 {
+		return fmt.Errorf("ratio must be in [0, 1]")
+	}
+	if spec.MinRetries < 0 {
+		return fmt.Errorf("minRetries must not be negative")
+	}
+	if spec.Window != "" {
+		if d, err := time.ParseDuration(spec.Window); err != nil {
+			return err
+		} else if d < retryBudgetBuckets*time.Millisecond {
+			return fmt.Errorf("window must be at least %dms", retryBudgetBuckets)
+		}
+	}
+	return nil
+}
+
+func (spec *RetryBudgetSpec) window() time.Duration {
+	d, _ := time.ParseDuration(spec.Window)
+	if d <= 0 {
+		d = 10 * time.Second
+	}
+	return d
+}
+
+// RetryBudget returns the retry budget of the backend in the spec, the
+// budget is created if it does not exist, or its settings are updated to
+// the spec otherwise, that's the last one wins if users of the same backend
+// have different settings.
+func (s *Supervisor) RetryBudget(spec *RetryBudgetSpec) *RetryBudget {
+	rb := &RetryBudget{backend: spec.Backend}
+	if v, loaded := s.retryBudgets.LoadOrStore(spec.Backend, rb); loaded {
+		rb = v.(*RetryBudget)
+	}
+	rb.update(spec)
+	return rb
+}
+
+func (rb *RetryBudget) update(spec *RetryBudgetSpec) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	rb.ratio = spec.Ratio
+	rb.minRetries = spec.MinRetries
+
+	// the counts are meaningless with a different window, drop them.
+	bucketSize := spec.window() / retryBudgetBuckets
+	if bucketSize != rb.bucketSize {
+		rb.bucketSize = bucketSize
+		rb.buckets = [retryBudgetBuckets]retryBudgetBucket{}
+	}
+}
+
+// Backend returns the identity of the backend.
+func (rb *RetryBudget) Backend() string {
+	return rb.backend
+}
+
+// currentBucket returns the bucket of now, the caller must hold the lock.
+func (rb *RetryBudget) currentBucket(now time.Time) *retryBudgetBucket {
+	slot := now.UnixNano() / int64(rb.bucketSize)
+	b := &rb.buckets[slot%retryBudgetBuckets]
+	if b.slot != slot {
+		*b = retryBudgetBucket{slot: slot}
+	}
+	return b
+}
+
+// sum returns the number of requests and retries in the window, the caller
+// must hold the lock.
+func (rb *RetryBudget) sum(now time.Time) (requests, retries uint64) {
+	slot := now.UnixNano() / int64(rb.bucketSize)
+	for i := range rb.buckets {
+		b := &rb.buckets[i]
+		if b.slot > slot-retryBudgetBuckets && b.slot <= slot {
+			requests += b.requests
+			retries += b.retries
+		}
+	}
+	return
+}
+
+// budget returns the number of retries allowed for requests.
+func (rb *RetryBudget) budget(requests uint64) uint64 {
+	budget := uint64(math.Floor(float64(requests) * rb.ratio))
+	if min := uint64(rb.minRetries); budget < min {
+		budget = min
+	}
+	return budget
+}
+
+// RecordRequest records a request, retries are not requests.
+func (rb *RetryBudget) RecordRequest() {
+	rb.lock.Lock()
+	rb.currentBucket(time.Now()).requests++
+	rb.lock.Unlock()
+}
+
+// AcquireRetry reports whether a retry is allowed, and records it if so.
+func (rb *RetryBudget) AcquireRetry() bool {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	now := time.Now()
+	requests, retries := rb.sum(now)
+	if retries >= rb.budget(requests) {
+		rb.suppressed++
+		return false
+	}
+	rb.currentBucket(now).retries++
+	return true
+}
+
+// Status returns the status of the retry budget.
+func (rb *RetryBudget) Status() *RetryBudgetStatus {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	requests, retries := rb.sum(time.Now())
+	s := &RetryBudgetStatus{
+		Backend:    rb.backend,
+		Requests:   requests,
+		Retries:    retries,
+		Budget:     rb.budget(requests),
+		Suppressed: rb.suppressed,
+	}
+	switch {
+	case retries == 0:
+	case s.Budget == 0:
+		s.Utilization = 1
+	default:
+		s.Utilization = float64(retries) / float64(s.Budget)
+	}
+	return s
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBudgetSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError((&RetryBudgetSpec{Backend: "b", Ratio: 0.2, MinRetries: 3, Window: "1s"}).Validate())
+	assert.Error((&RetryBudgetSpec{Backend: "b", Ratio: 1.5}).Validate())
+	assert.Error((&RetryBudgetSpec{Backend: "b", Ratio: 0.2, MinRetries: -1}).Validate())
+	assert.Error((&RetryBudgetSpec{Backend: "b", Ratio: 0.2, Window: "abc"}).Validate())
+	assert.Error((&RetryBudgetSpec{Backend: "b", Ratio: 0.2, Window: "1ms"}).Validate())
+}
+
+func TestRetryBudget(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewDefaultMock()
+	spec := &RetryBudgetSpec{Backend: "backend", Ratio: 0.5, MinRetries: 1}
+	rb1 := s.RetryBudget(spec)
+	assert.Equal("backend", rb1.Backend())
+	assert.Equal(0.0, rb1.Status().Utilization)
+
+	// budgets of the same backend are shared.
+	rb2 := s.RetryBudget(spec)
+	assert.Same(rb1, rb2)
+
+	// the floor.
+	assert.True(rb1.AcquireRetry())
+	assert.False(rb2.AcquireRetry())
+
+	// the ratio.
+	for i := 0; i < 4; i++ {
+		rb1.RecordRequest()
+	}
+	assert.True(rb2.AcquireRetry())
+	assert.False(rb1.AcquireRetry())
+
+	status := rb1.Status()
+	assert.Equal(uint64(4), status.Requests)
+	assert.Equal(uint64(2), status.Retries)
+	assert.Equal(uint64(2), status.Budget)
+	assert.Equal(1.0, status.Utilization)
+	assert.Equal(uint64(2), status.Suppressed)
+
+	// but not with other backends.
+	rb3 := s.RetryBudget(&RetryBudgetSpec{Backend: "other", Ratio: 0.5})
+	assert.NotSame(rb1, rb3)
+	assert.False(rb3.AcquireRetry())
+	assert.Equal(uint64(1), rb3.Status().Suppressed)
+
+	// the last spec wins, and a new window drops the counts.
+	s.RetryBudget(&RetryBudgetSpec{Backend: "backend", Ratio: 0.5, MinRetries: 1, Window: "100ms"})
+	assert.Equal(uint64(0), rb1.Status().Requests)
+	assert.True(rb1.AcquireRetry())
+	assert.False(rb1.AcquireRetry())
+
+	// the counts expire with the window.
+	time.Sleep(150 * time.Millisecond)
+	assert.True(rb1.AcquireRetry())
+}
@@ -0,0 +1,383 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package supervisor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/cluster"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+const (
+	// SessionStoreTypeMemory keeps sessions in the memory of the local node.
+	SessionStoreTypeMemory = "Memory"
+	// SessionStoreTypeCluster keeps sessions in the cluster, so they are
+	// shared by all members.
+	SessionStoreTypeCluster = "Cluster"
+
+	// DefaultSessionCookieName is the default name of the session cookie.
+	DefaultSessionCookieName = "EG_SID"
+
+	defaultSessionTTL = 30 * time.Minute
+	sessionIDLen      = 16
+)
+
+type (
+	// SessionStore stores per-session data with TTL for stateful filters.
+	SessionStore interface {
+		// Get returns the data of the session, it returns nil without
+		// error if the session does not exist or has expired.
+		Get(id string) (map[string]string, error)
+
+		// Set stores the data of the session, the session expires after
+		// ttl, the default TTL of the store is used if ttl is zero.
+		Set(id string, data map[string]string, ttl time.Duration) error
+
+		// Delete deletes the session.
+		Delete(id string) error
+
+		// Close closes the store.
+		Close()
+	}
+
+	// SessionStoreSpec describes a session store.
+	SessionStoreSpec struct {
+		// Name is the name of the store, stores with the same name share
+		// sessions in cluster mode.
+		Name string `json:"name" jsonschema:"required"`
+		Type string `json:"type" jsonschema:"required,enum=Memory,enum=Cluster"`
+		// TTL is the default time-to-live of sessions.
+		TTL string `json:"ttl,omitempty" jsonschema:"format=duration"`
+		// CookieName is the name of the cookie which carries the session ID.
+		CookieName string `json:"cookieName,omitempty"`
+		// SecretKey is used to sign the session cookie. It is required by
+		// the Cluster store, because the cookies must be valid on all
+		// members. A random key is generated for the Memory store if it
+		// is empty, which means cookies are only valid on the current
+		// node until it restarts.
+		SecretKey string `json:"secretKey,omitempty"`
+	}
+
+	// SessionCookie signs and verifies the session cookies, so that a
+	// client cannot forge a session ID.
+	SessionCookie struct {
+		name string
+		ttl  time.Duration
+		key  []byte
+	}
+
+	memorySession struct {
+		data     map[string]string
+		expireAt time.Time
+	}
+
+	memorySessionStore struct {
+		mutex      sync.Mutex
+		sessions   map[string]*memorySession
+		defaultTTL time.Duration
+		done       chan struct{}
+	}
+
+	clusterSession struct {
+		Data     map[string]string `json:"data"`
+		ExpireAt time.Time         `json:"expireAt"`
+	}
+
+	clusterSessionStore struct {
+		cls        cluster.Cluster
+		prefix     string
+		defaultTTL time.Duration
+	}
+)
+
+// Validate validates the SessionStoreSpec.
+func (spec *SessionStoreSpec) Validate() error {
+	if spec.Type == SessionStoreTypeCluster && spec.SecretKey == "" {
+		return fmt.Errorf("secretKey is required by the %s session store", SessionStoreTypeCluster)
+	}
+	if spec.TTL != "" {
+		if ttl, err := time.ParseDuration(spec.TTL); err != nil {
+			return err
+		} else if ttl <= 0 {
+			return fmt.Errorf("ttl must be positive")
+		}
+	}
+	return nil
+}
+
+func (spec *SessionStoreSpec) ttl() time.Duration {
+	ttl, _ := time.ParseDuration(spec.TTL)
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	return ttl
+}
+
+// NewSessionStore creates a session store according to the spec.
+func (s *Supervisor) NewSessionStore(spec *SessionStoreSpec) (SessionStore, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("session store %s: %v", spec.Name, err)
+	}
+
+	switch spec.Type {
+	case SessionStoreTypeMemory:
+		return NewMemorySessionStore(spec.ttl()), nil
+	case SessionStoreTypeCluster:
+		if s.Cluster() == nil {
+			return nil, fmt.Errorf("cluster session store %s: no cluster", spec.Name)
+		}
+		prefix := s.Cluster().Layout().SessionDataPrefix(spec.Name)
+		return NewClusterSessionStore(s.Cluster(), prefix, spec.ttl()), nil
+	default:
+		return nil, fmt.Errorf("unknown session store type %s", spec.Type)
+	}
+}
+
+// NewSessionCookie creates a SessionCookie according to the spec.
+func NewSessionCookie(spec *SessionStoreSpec) *SessionCookie {
+	sc := &SessionCookie{
+		name: spec.CookieName,
+		ttl:  spec.ttl(),
+		key:  []byte(spec.SecretKey),
+	}
+
+	if sc.name == "" {
+		sc.name = DefaultSessionCookieName
+	}
+
+	if len(sc.key) == 0 {
+		sc.key = make([]byte, sha256.Size)
+		rand.Read(sc.key)
+	}
+
+	return sc
+}
+
+// NewSessionID generates a new random session ID.
+func NewSessionID() string {
+	buf := make([]byte, sessionIDLen)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (sc *SessionCookie) mac(id string) string {
+	mac := hmac.New(sha256.New, sc.key)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Name returns the name of the cookie.
+func (sc *SessionCookie) Name() string {
+	return sc.name
+}
+
+// Sign returns the signed cookie value of the session ID.
+func (sc *SessionCookie) Sign(id string) string {
+	return id + "." + sc.mac(id)
+}
+
+// Verify verifies the signed cookie value and returns the session ID.
+func (sc *SessionCookie) Verify(value string) (string, bool) {
+	id, mac, ok := strings.Cut(value, ".")
+	if !ok || id == "" {
+		return "", false
+	}
+	if !hmac.Equal([]byte(mac), []byte(sc.mac(id))) {
+		return "", false
+	}
+	return id, true
+}
+
+// SessionID returns the session ID carried by the request, it returns
+// false if there's no cookie or the cookie has been tampered.
+func (sc *SessionCookie) SessionID(req *http.Request) (string, bool) {
+	c, err := req.Cookie(sc.name)
+	if err != nil {
+		return "", false
+	}
+	return sc.Verify(c.Value)
+}
+
+// Cookie creates the cookie which carries the signed session ID.
+func (sc *SessionCookie) Cookie(id string) *http.Cookie {
+	return &http.Cookie{
+		Name:     sc.name,
+		Value:    sc.Sign(id),
+		Path:     "/",
+		HttpOnly: true,
+		Expires:  time.Now().Add(sc.ttl),
+	}
+}
+
+// NewMemorySessionStore creates a session store which keeps sessions in
+// memory.
+func NewMemorySessionStore(defaultTTL time.Duration) SessionStore {
+	ms := &memorySessionStore{
+		sessions:   map[string]*memorySession{},
+		defaultTTL: defaultTTL,
+		done:       make(chan struct{}),
+	}
+	go ms.run()
+	return ms
+}
+
+func (ms *memorySessionStore) run() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ms.done:
+			return
+		case now := <-ticker.C:
+			ms.purge(now)
+		}
+	}
+}
+
+func (ms *memorySessionStore) purge(now time.Time) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for id, s := range ms.sessions {
+		if now.After(s.expireAt) {
+			delete(ms.sessions, id)
+		}
+	}
+}
+
+func (ms *memorySessionStore) Get(id string) (map[string]string, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	s := ms.sessions[id]
+	if s == nil {
+		return nil, nil
+	}
+	if time.Now().After(s.expireAt) {
+		delete(ms.sessions, id)
+		return nil, nil
+	}
+
+	data := make(map[string]string, len(s.data))
+	for k, v := range s.data {
+		data[k] = v
+	}
+	return data, nil
+}
+
+func (ms *memorySessionStore) Set(id string, data map[string]string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = ms.defaultTTL
+	}
+
+	s := &memorySession{
+		data:     make(map[string]string, len(data)),
+		expireAt: time.Now().Add(ttl),
+	}
+	for k, v := range data {
+		s.data[k] = v
+	}
+
+	ms.mutex.Lock()
+	ms.sessions[id] = s
+	ms.mutex.Unlock()
+	return nil
+}
+
+func (ms *memorySessionStore) Delete(id string) error {
+	ms.mutex.Lock()
+	delete(ms.sessions, id)
+	ms.mutex.Unlock()
+	return nil
+}
+
+func (ms *memorySessionStore) Close() {
+	close(ms.done)
+}
+
+// NewClusterSessionStore creates a session store which keeps sessions in
+// the cluster, keys of the sessions are put under prefix.
+func NewClusterSessionStore(cls cluster.Cluster, prefix string, defaultTTL time.Duration) SessionStore {
+	return &clusterSessionStore{
+		cls:        cls,
+		prefix:     prefix,
+		defaultTTL: defaultTTL,
+	}
+}
+
+func (cs *clusterSessionStore) Get(id string) (map[string]string, error) {
+	value, err := cs.cls.Get(cs.prefix + id)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	s := &clusterSession{}
+	if err = codectool.UnmarshalJSON([]byte(*value), s); err != nil {
+		return nil, err
+	}
+
+	// the lease may not be revoked in time, so double check it.
+	if time.Now().After(s.ExpireAt) {
+		if err = cs.cls.Delete(cs.prefix + id); err != nil {
+			logger.Warnf("delete expired session %s failed: %v", id, err)
+		}
+		return nil, nil
+	}
+
+	return s.Data, nil
+}
+
+func (cs *clusterSessionStore) Set(id string, data map[string]string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = cs.defaultTTL
+	}
+
+	s := &clusterSession{Data: data, ExpireAt: time.Now().Add(ttl)}
+	buf, err := codectool.MarshalJSON(s)
+	if err != nil {
+		return err
+	}
+
+	// etcd leases are in seconds, round up to avoid a zero TTL.
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return cs.cls.PutUnderTimeout(cs.prefix+id, string(buf), ttl)
+}
+
+func (cs *clusterSessionStore) Delete(id string) error {
+	return cs.cls.Delete(cs.prefix + id)
+}
+
+func (cs *clusterSessionStore) Close() {
+}
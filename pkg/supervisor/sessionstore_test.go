@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package supervisor
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/cluster/clustertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionCookie(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewSessionCookie(&SessionStoreSpec{SecretKey: "secret"})
+	assert.Equal(DefaultSessionCookieName, sc.Name())
+
+	id := NewSessionID()
+	value := sc.Sign(id)
+	got, ok := sc.Verify(value)
+	assert.True(ok)
+	assert.Equal(id, got)
+
+	_, ok = sc.Verify("forged." + value[len(id)+1:])
+	assert.False(ok)
+	_, ok = sc.Verify(id)
+	assert.False(ok)
+
+	other := NewSessionCookie(&SessionStoreSpec{SecretKey: "other"})
+	_, ok = other.Verify(value)
+	assert.False(ok)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost", nil)
+	_, ok = sc.SessionID(req)
+	assert.False(ok)
+	req.AddCookie(sc.Cookie(id))
+	got, ok = sc.SessionID(req)
+	assert.True(ok)
+	assert.Equal(id, got)
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	assert := assert.New(t)
+
+	ms := NewMemorySessionStore(time.Minute)
+	defer ms.Close()
+
+	data, err := ms.Get("id")
+	assert.Nil(err)
+	assert.Nil(data)
+
+	assert.Nil(ms.Set("id", map[string]string{"user": "bob"}, 0))
+	data, err = ms.Get("id")
+	assert.Nil(err)
+	assert.Equal("bob", data["user"])
+
+	assert.Nil(ms.Set("short", map[string]string{"a": "b"}, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	data, _ = ms.Get("short")
+	assert.Nil(data)
+
+	assert.Nil(ms.Delete("id"))
+	data, _ = ms.Get("id")
+	assert.Nil(data)
+}
+
+func TestClusterSessionStore(t *testing.T) {
+	assert := assert.New(t)
+
+	var mutex sync.Mutex
+	kvs := map[string]string{}
+	cls := clustertest.NewMockedCluster()
+	cls.MockedGet = func(key string) (*string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if v, ok := kvs[key]; ok {
+			return &v, nil
+		}
+		return nil, nil
+	}
+	cls.MockedPutUnderTimeout = func(key, value string, timeout time.Duration) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		assert.GreaterOrEqual(timeout, time.Second)
+		kvs[key] = value
+		return nil
+	}
+	cls.MockedDelete = func(key string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		delete(kvs, key)
+		return nil
+	}
+
+	cs := NewClusterSessionStore(cls, "/session/data/test/", time.Minute)
+	defer cs.Close()
+
+	assert.Nil(cs.Set("id", map[string]string{"user": "bob"}, 0))
+	assert.Contains(kvs, "/session/data/test/id")
+	data, err := cs.Get("id")
+	assert.Nil(err)
+	assert.Equal("bob", data["user"])
+
+	assert.Nil(cs.Set("short", map[string]string{"a": "b"}, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	data, _ = cs.Get("short")
+	assert.Nil(data)
+	assert.NotContains(kvs, "/session/data/test/short")
+
+	assert.Nil(cs.Delete("id"))
+	data, _ = cs.Get("id")
+	assert.Nil(data)
+}
+
+func TestNewSessionStore(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &SessionStoreSpec{Name: "test", Type: "Unknown", TTL: "-1s"}
+	assert.NotNil(spec.Validate())
+	spec.TTL = "1m"
+	assert.Nil(spec.Validate())
+
+	s := NewDefaultMock()
+	_, err := s.NewSessionStore(spec)
+	assert.NotNil(err)
+
+	// the secret key is required by the cluster store.
+	spec.Type = SessionStoreTypeCluster
+	assert.NotNil(spec.Validate())
+	_, err = s.NewSessionStore(spec)
+	assert.NotNil(err)
+
+	// no cluster.
+	spec.SecretKey = "secret"
+	assert.Nil(spec.Validate())
+	_, err = s.NewSessionStore(spec)
+	assert.NotNil(err)
+
+	spec.Type = SessionStoreTypeMemory
+	ss, err := s.NewSessionStore(spec)
+	assert.Nil(err)
+	ss.Close()
+}
@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package supervisor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/option"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+// kinds of the components publishing state events.
+const (
+	StateEventCircuitBreaker = "circuitBreaker"
+	StateEventRateLimiter    = "rateLimiter"
+	StateEventHealthCheck    = "healthCheck"
+	StateEventPipelineGuard  = "pipelineGuard"
+)
+
+const (
+	// stateEventQueueSize is the max number of events waiting to be sent
+	// to a sink, events are dropped if the queue of the sink is full.
+	stateEventQueueSize = 1024
+
+	defaultStateEventWebhookTimeout = 5 * time.Second
+)
+
+type (
+	// StateEvent is the event of a state transition of a stateful
+	// component, like a circuit breaker, a rate limiter or a health
+	// checker.
+	StateEvent struct {
+		Time time.Time `json:"time"`
+		// Node is the name of the Easegress instance, it is filled by the
+		// supervisor.
+		Node string `json:"node"`
+		// Kind is the kind of the component, e.g. circuitBreaker.
+		Kind string `json:"kind"`
+		// Source is the name of the component, e.g. the name of the filter.
+		Source string `json:"source"`
+		// Target is what the state is about, e.g. the URL of a server, it
+		// is empty if the state is about the whole component.
+		Target   string `json:"target,omitempty"`
+		OldState string `json:"oldState,omitempty"`
+		NewState string `json:"newState"`
+		Reason   string `json:"reason,omitempty"`
+	}
+
+	// StateEventSink sends state events to somewhere.
+	StateEventSink interface {
+		Send(event *StateEvent) error
+	}
+
+	// StateEventNotifier sends state events to the sinks asynchronously,
+	// so publishers, which are usually in the path of requests, are never
+	// blocked by the sinks. Every sink has its own queue and goroutine, so
+	// a slow sink never delays the others.
+	StateEventNotifier struct {
+		node    string
+		sinks   []*stateEventSinkQueue
+		done    chan struct{}
+		wg      sync.WaitGroup
+		dropped uint64
+	}
+
+	// stateEventSinkQueue is a sink and the events waiting to be sent to it.
+	stateEventSinkQueue struct {
+		sink  StateEventSink
+		queue chan *StateEvent
+	}
+
+	logStateEventSink struct{}
+
+	webhookStateEventSink struct {
+		url    string
+		client *http.Client
+	}
+)
+
+// Send implements StateEventSink.
+func (s logStateEventSink) Send(event *StateEvent) error {
+	data, err := codectool.MarshalJSON(event)
+	if err != nil {
+		return err
+	}
+	logger.Infof("state event: %s", data)
+	return nil
+}
+
+// Send implements StateEventSink.
+func (s *webhookStateEventSink) Send(event *StateEvent) error {
+	data, err := codectool.MarshalJSON(event)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: status code %d", s.url, resp.StatusCode)
+	}
+	return nil
+}
+
+// NewStateEventNotifier creates a StateEventNotifier sending events to the
+// sinks.
+func NewStateEventNotifier(node string, sinks ...StateEventSink) *StateEventNotifier {
+	n := &StateEventNotifier{
+		node: node,
+		done: make(chan struct{}),
+	}
+	for _, sink := range sinks {
+		sq := &stateEventSinkQueue{
+			sink:  sink,
+			queue: make(chan *StateEvent, stateEventQueueSize),
+		}
+		n.sinks = append(n.sinks, sq)
+		n.wg.Add(1)
+		go n.run(sq)
+	}
+	return n
+}
+
+func newStateEventNotifier(opt *option.Options) *StateEventNotifier {
+	var sinks []StateEventSink
+	if opt.StateEventLog {
+		sinks = append(sinks, logStateEventSink{})
+	}
+
+	timeout := defaultStateEventWebhookTimeout
+	if opt.StateEventWebhookTimeout != "" {
+		if d, err := time.ParseDuration(opt.StateEventWebhookTimeout); err == nil && d > 0 {
+			timeout = d
+		}
+	}
+	for _, url := range opt.StateEventWebhooks {
+		sinks = append(sinks, &webhookStateEventSink{url: url, client: &http.Client{Timeout: timeout}})
+	}
+
+	if len(sinks) == 0 {
+		return nil
+	}
+	return NewStateEventNotifier(opt.Name, sinks...)
+}
+
+func (n *StateEventNotifier) run(sq *stateEventSinkQueue) {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.done:
+			return
+		case event := <-sq.queue:
+			if err := sq.sink.Send(event); err != nil {
+				logger.Warnf("failed to send state event: %v", err)
+			}
+		}
+	}
+}
+
+// Publish publishes a state event, it never blocks, and the event is
+// dropped for a sink if there are too many events waiting to be sent to
+// the sink.
+func (n *StateEventNotifier) Publish(event *StateEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Node = n.node
+
+	for _, sq := range n.sinks {
+		select {
+		case sq.queue <- event:
+		default:
+			if atomic.AddUint64(&n.dropped, 1)%100 == 1 {
+				logger.Warnf("state event queue is full, events are dropped")
+			}
+		}
+	}
+}
+
+// Dropped returns the number of dropped events, an event dropped for
+// several sinks is counted once for each of them.
+func (n *StateEventNotifier) Dropped() uint64 {
+	return atomic.LoadUint64(&n.dropped)
+}
+
+// Close closes the notifier, events waiting to be sent are discarded.
+func (n *StateEventNotifier) Close() {
+	close(n.done)
+	n.wg.Wait()
+}
+
+// PublishStateEvent publishes a state event to the configured sinks. It is
+// safe to call it on a nil supervisor or a supervisor without sinks, which
+// makes stateful filters easier to test.
+func (s *Supervisor) PublishStateEvent(event *StateEvent) {
+	if s == nil || s.stateEvents == nil {
+		return
+	}
+	s.stateEvents.Publish(event)
+}
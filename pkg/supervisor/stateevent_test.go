@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package supervisor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/option"
+	"github.com/stretchr/testify/assert"
+)
+
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (s *blockingSink) Send(event *StateEvent) error {
+	<-s.release
+	return nil
+}
+
+func TestStateEventWebhook(t *testing.T) {
+	assert := assert.New(t)
+	logger.InitNop()
+
+	events := make(chan *StateEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := &StateEvent{}
+		assert.NoError(json.NewDecoder(r.Body).Decode(e))
+		assert.Equal("application/json", r.Header.Get("Content-Type"))
+		events <- e
+	}))
+	defer server.Close()
+
+	opt := &option.Options{Name: "eg-1", StateEventLog: true, StateEventWebhooks: []string{server.URL}}
+	s := NewDefaultMock()
+	s.stateEvents = newStateEventNotifier(opt)
+	defer s.stateEvents.Close()
+
+	s.PublishStateEvent(&StateEvent{
+		Kind:     StateEventCircuitBreaker,
+		Source:   "proxy#main",
+		OldState: "CLOSED",
+		NewState: "OPEN",
+		Reason:   "failure rate exceeded",
+	})
+
+	select {
+	case e := <-events:
+		assert.Equal("eg-1", e.Node)
+		assert.Equal(StateEventCircuitBreaker, e.Kind)
+		assert.Equal("OPEN", e.NewState)
+		assert.False(e.Time.IsZero())
+	case <-time.After(5 * time.Second):
+		t.Fatal("state event not received")
+	}
+
+	// no sinks, no notifier.
+	assert.Nil(newStateEventNotifier(&option.Options{}))
+	NewDefaultMock().PublishStateEvent(&StateEvent{})
+	(*Supervisor)(nil).PublishStateEvent(&StateEvent{})
+}
+
+func TestStateEventNotBlocking(t *testing.T) {
+	assert := assert.New(t)
+	logger.InitNop()
+
+	sink := &blockingSink{release: make(chan struct{})}
+	n := NewStateEventNotifier("eg-1", sink)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < stateEventQueueSize+10; i++ {
+			n.Publish(&StateEvent{NewState: "OPEN"})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing is blocked by the sink")
+	}
+	assert.True(n.Dropped() > 0)
+
+	close(sink.release)
+	n.Close()
+}
+
+type chanSink struct {
+	events chan *StateEvent
+}
+
+func (s *chanSink) Send(event *StateEvent) error {
+	s.events <- event
+	return nil
+}
+
+func TestStateEventSlowSink(t *testing.T) {
+	assert := assert.New(t)
+	logger.InitNop()
+
+	slow := &blockingSink{release: make(chan struct{})}
+	fast := &chanSink{events: make(chan *StateEvent, 10)}
+	n := NewStateEventNotifier("eg-1", slow, fast)
+
+	// the fast sink is not delayed by the slow one.
+	n.Publish(&StateEvent{NewState: "OPEN"})
+	n.Publish(&StateEvent{NewState: "CLOSED"})
+	for _, state := range []string{"OPEN", "CLOSED"} {
+		select {
+		case e := <-fast.events:
+			assert.Equal(state, e.NewState)
+		case <-time.After(5 * time.Second):
+			t.Fatal("state event is delayed by the slow sink")
+		}
+	}
+	assert.Equal(uint64(0), n.Dropped())
+
+	close(slow.release)
+	n.Close()
+}
@@ -43,6 +43,17 @@ type (
 		businessControllers sync.Map
 		systemControllers   sync.Map
 
+		// backendLimiters are the rate limiters of outbound requests, which
+		// are shared by all pipelines, the key is the identity of the backend.
+		backendLimiters sync.Map
+		// retryBudgets are the retry budgets of outbound requests, which
+		// are shared by all pipelines, the key is the identity of the backend.
+		retryBudgets sync.Map
+
+		// stateEvents sends the state events published by stateful
+		// components, it is nil if no sink is configured.
+		stateEvents *StateEventNotifier
+
 		objectRegistry  *ObjectRegistry
 		watcher         *ObjectEntityWatcher
 		firstHandle     bool
@@ -90,6 +101,8 @@ func MustNew(opt *option.Options, cls cluster.Cluster) *Supervisor {
 		done:            make(chan struct{}),
 	}
 
+	s.stateEvents = newStateEventNotifier(opt)
+
 	initObjs := loadInitialObjects(s, opt.InitialObjectConfigFiles)
 
 	s.objectRegistry = newObjectRegistry(s, initObjs, opt.ObjectsDumpInterval)
@@ -333,5 +346,9 @@ func (s *Supervisor) close() {
 		value.(*ObjectEntity).CloseWithRecovery()
 	}
 
+	if s.stateEvents != nil {
+		s.stateEvents.Close()
+	}
+
 	close(s.done)
 }
@@ -280,6 +280,11 @@ func (spec *JaegerSpec) Validate() error {
 	return nil
 }
 
+// CorrelationHeader is the conventional header of the correlation ID of a
+// request, which is propagated to the asynchronous messages published for
+// the request, so that their consumers could correlate them to the request.
+const CorrelationHeader = "X-Correlation-Id"
+
 // NoopTracer is the tracer doing nothing.
 var NoopTracer *Tracer
 
@@ -566,7 +571,23 @@ func (s *Span) newChildWithStart(name string, startAt time.Time) *Span {
 
 // InjectHTTP injects span context into an HTTP request.
 func (s *Span) InjectHTTP(r *http.Request) {
-	s.tracer.propagator.Inject(s.ctx, propagation.HeaderCarrier(r.Header))
+	s.Inject(propagation.HeaderCarrier(r.Header))
+}
+
+// Inject injects span context into a carrier, like the headers of a
+// message to be published.
+func (s *Span) Inject(carrier propagation.TextMapCarrier) {
+	s.tracer.propagator.Inject(s.ctx, carrier)
+}
+
+// TraceID returns the trace ID of the span, it returns an empty string if
+// the span doesn't have one, e.g. a noop span.
+func (s *Span) TraceID() string {
+	sc := s.SpanContext()
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
 }
 
 // End completes the Span. Override trace.Span.End function.
@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bodycodec provides the codecs which convert the bodies of requests
+// and responses in different formats to and from a common representation,
+// so that the transformation filters are not limited to JSON.
+//
+// The common representation is the same as decoding JSON into an
+// interface{}, that's, it consists of map[string]interface{},
+// []interface{}, and scalars.
+package bodycodec
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+// Codec decodes a body into the common representation, and encodes the
+// common representation into a body.
+type Codec interface {
+	// Name returns the name of the format, e.g. json.
+	Name() string
+	// ContentType returns the MIME type of the format.
+	ContentType() string
+	// Decode decodes the body.
+	Decode(body []byte) (interface{}, error)
+	// Encode encodes the value into a body.
+	Encode(v interface{}) ([]byte, error)
+}
+
+var (
+	lock   sync.RWMutex
+	codecs = map[string]Codec{}
+)
+
+func init() {
+	Register(jsonCodec{})
+	Register(xmlCodec{})
+	Register(formCodec{})
+}
+
+// Register registers a codec, it panics if the name of the codec is empty
+// or duplicated.
+func Register(c Codec) {
+	if c.Name() == "" {
+		panic(fmt.Errorf("%T: empty codec name", c))
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, ok := codecs[c.Name()]; ok {
+		panic(fmt.Errorf("duplicated codec %s", c.Name()))
+	}
+	codecs[c.Name()] = c
+}
+
+// Get returns the codec of the format, or nil if it is not registered.
+func Get(name string) Codec {
+	lock.RLock()
+	defer lock.RUnlock()
+	return codecs[name]
+}
+
+// Names returns the names of the registered codecs in ascending order.
+func Names() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate returns an error if the format is not registered.
+func Validate(name string) error {
+	if Get(name) == nil {
+		return fmt.Errorf("unknown body format %s, available formats are %v", name, Names())
+	}
+	return nil
+}
+
+// Decode decodes the body in the format.
+func Decode(format string, body []byte) (interface{}, error) {
+	if err := Validate(format); err != nil {
+		return nil, err
+	}
+	return Get(format).Decode(body)
+}
+
+// Encode encodes the value into a body in the format.
+func Encode(format string, v interface{}) ([]byte, error) {
+	if err := Validate(format); err != nil {
+		return nil, err
+	}
+	return Get(format).Encode(v)
+}
+
+// jsonCodec is the codec of JSON, numbers are decoded as json.Number to
+// keep their original text.
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string        { return "json" }
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Decode(body []byte) (interface{}, error) {
+	if len(body) == 0 {
+		return nil, nil
+	}
+	var v interface{}
+	if err := codectool.UnmarshalJSONNumber(body, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return codectool.MarshalJSON(v)
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bodycodec
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{"form", "json", "xml"}, Names())
+	assert.NotNil(Get("xml"))
+	assert.Nil(Get("msgpack"))
+	assert.NotNil(Validate("msgpack"))
+	assert.Panics(func() { Register(jsonCodec{}) })
+
+	_, err := Decode("msgpack", nil)
+	assert.NotNil(err)
+	_, err = Encode("msgpack", nil)
+	assert.NotNil(err)
+}
+
+func TestJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	v, err := Decode("json", []byte(`{"id":9007199254740993}`))
+	assert.Nil(err)
+	assert.Equal(json.Number("9007199254740993"), v.(map[string]interface{})["id"])
+
+	b, err := Encode("json", v)
+	assert.Nil(err)
+	assert.Equal(`{"id":9007199254740993}`, string(b))
+
+	v, err = Decode("json", nil)
+	assert.Nil(err)
+	assert.Nil(v)
+	_, err = Decode("json", []byte("{"))
+	assert.NotNil(err)
+}
+
+func TestForm(t *testing.T) {
+	assert := assert.New(t)
+
+	v, err := Decode("form", []byte("name=Tom&tag=a&tag=b"))
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{
+		"name": "Tom",
+		"tag":  []interface{}{"a", "b"},
+	}, v)
+
+	b, err := Encode("form", v)
+	assert.Nil(err)
+	assert.Equal("name=Tom&tag=a&tag=b", string(b))
+
+	b, err = Encode("form", map[string]interface{}{"n": json.Number("1"), "e": nil})
+	assert.Nil(err)
+	assert.Equal("e=&n=1", string(b))
+
+	_, err = Decode("form", []byte("%zz"))
+	assert.NotNil(err)
+	_, err = Encode("form", "a")
+	assert.NotNil(err)
+	_, err = Encode("form", map[string]interface{}{"a": map[string]interface{}{}})
+	assert.NotNil(err)
+	_, err = Encode("form", map[string]interface{}{"a": []interface{}{[]interface{}{}}})
+	assert.NotNil(err)
+}
+
+func TestXML(t *testing.T) {
+	assert := assert.New(t)
+
+	body := `<?xml version="1.0"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+  <soap:Body>
+    <GetUser lang="en">
+      <id>42</id>
+      <tag>a</tag>
+      <tag>b &amp; c</tag>
+      <note priority="high">hello</note>
+    </GetUser>
+  </soap:Body>
+</soap:Envelope>`
+
+	v, err := Decode("xml", []byte(body))
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{
+		"soap:Envelope": map[string]interface{}{
+			"-xmlns:soap": "http://schemas.xmlsoap.org/soap/envelope/",
+			"soap:Body": map[string]interface{}{
+				"GetUser": map[string]interface{}{
+					"-lang": "en",
+					"id":    "42",
+					"tag":   []interface{}{"a", "b & c"},
+					"note": map[string]interface{}{
+						"-priority": "high",
+						"#text":     "hello",
+					},
+				},
+			},
+		},
+	}, v)
+
+	b, err := Encode("xml", v)
+	assert.Nil(err)
+	expected := `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">` +
+		`<soap:Body><GetUser lang="en"><id>42</id><note priority="high">hello</note>` +
+		`<tag>a</tag><tag>b &amp; c</tag></GetUser></soap:Body></soap:Envelope>`
+	assert.Equal(expected, string(b))
+
+	// the encoded document is decoded to the same value.
+	v2, err := Decode("xml", b)
+	assert.Nil(err)
+	assert.Equal(v, v2)
+
+	v, err = Decode("xml", []byte("  "))
+	assert.Nil(err)
+	assert.Nil(v)
+
+	for _, body := range []string{"<a>", "<a></b>", "<a/><b/>", "text", "</a>"} {
+		_, err = Decode("xml", []byte(body))
+		assert.NotNil(err, body)
+	}
+
+	b, err = Encode("xml", map[string]interface{}{"a": nil})
+	assert.Nil(err)
+	assert.Equal("<a></a>", string(b))
+
+	for _, v := range []interface{}{
+		"a",
+		map[string]interface{}{"a": 1, "b": 2},
+		map[string]interface{}{"a b": 1},
+		map[string]interface{}{"a": []interface{}{[]interface{}{}}},
+		map[string]interface{}{"a": map[string]interface{}{"-attr": []interface{}{}}},
+	} {
+		_, err = Encode("xml", v)
+		assert.NotNil(err, v)
+	}
+}
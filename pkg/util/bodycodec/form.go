@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bodycodec
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// formCodec is the codec of application/x-www-form-urlencoded. A field is
+// decoded to a string, or a []interface{} of strings if it has multiple
+// values.
+type formCodec struct{}
+
+func (formCodec) Name() string        { return "form" }
+func (formCodec) ContentType() string { return "application/x-www-form-urlencoded" }
+
+func (formCodec) Decode(body []byte) (interface{}, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]interface{}, len(values))
+	for k, vs := range values {
+		if len(vs) == 1 {
+			result[k] = vs[0]
+			continue
+		}
+		a := make([]interface{}, 0, len(vs))
+		for _, v := range vs {
+			a = append(a, v)
+		}
+		result[k] = a
+	}
+	return result, nil
+}
+
+func (formCodec) Encode(v interface{}) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("form: value must be an object, but got %T", v)
+	}
+
+	values := url.Values{}
+	for k, v := range m {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, x := range v {
+				if !isScalar(x) {
+					return nil, fmt.Errorf("form: field %s: nested value is not supported", k)
+				}
+				values.Add(k, scalarString(x))
+			}
+		default:
+			if !isScalar(v) {
+				return nil, fmt.Errorf("form: field %s: nested value is not supported", k)
+			}
+			values.Add(k, scalarString(v))
+		}
+	}
+	// url.Values.Encode sorts the fields by key.
+	return []byte(values.Encode()), nil
+}
+
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return true
+}
+
+func scalarString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,242 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bodycodec
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	// xmlAttrPrefix is the prefix of the keys of the attributes.
+	xmlAttrPrefix = "-"
+	// xmlTextKey is the key of the text of an element which also has
+	// attributes or child elements.
+	xmlTextKey = "#text"
+)
+
+// xmlCodec is the codec of XML.
+//
+// The document is decoded to an object whose only key is the name of the
+// root element. An element without attributes and child elements is
+// decoded to its text, otherwise it is decoded to an object, whose keys
+// are the names of the child elements, the names of the attributes
+// prefixed by "-", and "#text" for the text. Child elements with the same
+// name are decoded to an array. Names keep their namespace prefixes, e.g.
+// "soap:Envelope", and all values are strings.
+//
+// The child elements are encoded in the order of their names, as the order
+// is not kept in objects.
+type xmlCodec struct{}
+
+func (xmlCodec) Name() string        { return "xml" }
+func (xmlCodec) ContentType() string { return "application/xml" }
+
+type xmlElement struct {
+	name     string
+	attrs    []xml.Attr
+	text     strings.Builder
+	children map[string]interface{}
+}
+
+func xmlName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+func (e *xmlElement) value() interface{} {
+	text := e.text.String()
+	if len(e.attrs) == 0 && len(e.children) == 0 {
+		return text
+	}
+
+	m := make(map[string]interface{}, len(e.attrs)+len(e.children)+1)
+	for _, a := range e.attrs {
+		m[xmlAttrPrefix+xmlName(a.Name)] = a.Value
+	}
+	for k, v := range e.children {
+		m[k] = v
+	}
+	if strings.TrimSpace(text) != "" {
+		m[xmlTextKey] = text
+	}
+	return m
+}
+
+func (e *xmlElement) addChild(name string, v interface{}) {
+	if e.children == nil {
+		e.children = map[string]interface{}{}
+	}
+
+	old, ok := e.children[name]
+	if !ok {
+		e.children[name] = v
+		return
+	}
+	if a, ok := old.([]interface{}); ok {
+		e.children[name] = append(a, v)
+		return
+	}
+	e.children[name] = []interface{}{old, v}
+}
+
+func (xmlCodec) Decode(body []byte) (interface{}, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	root := &xmlElement{}
+	stack := []*xmlElement{root}
+	for {
+		// RawToken keeps the namespace prefixes, so that the document
+		// could be encoded as it is.
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("xml: %v", err)
+		}
+
+		top := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			if top == root && len(root.children) > 0 {
+				return nil, fmt.Errorf("xml: multiple root elements")
+			}
+			stack = append(stack, &xmlElement{name: xmlName(t.Name), attrs: t.Copy().Attr})
+		case xml.EndElement:
+			if top == root || top.name != xmlName(t.Name) {
+				return nil, fmt.Errorf("xml: unexpected end element %s", xmlName(t.Name))
+			}
+			stack = stack[:len(stack)-1]
+			stack[len(stack)-1].addChild(top.name, top.value())
+		case xml.CharData:
+			if top != root {
+				top.text.Write(t)
+			}
+		}
+	}
+
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("xml: unexpected EOF")
+	}
+	if len(root.children) == 0 {
+		return nil, fmt.Errorf("xml: no root element")
+	}
+	return root.children, nil
+}
+
+func (xmlCodec) Encode(v interface{}) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return nil, fmt.Errorf("xml: value must be an object with only the root element")
+	}
+
+	buf := &bytes.Buffer{}
+	for name, v := range m {
+		if err := encodeXMLElement(buf, name, v); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func encodeXMLElement(buf *bytes.Buffer, name string, v interface{}) error {
+	if name == "" || strings.ContainsAny(name, " <>&\"'") {
+		return fmt.Errorf("xml: invalid element name %q", name)
+	}
+
+	switch v := v.(type) {
+	case []interface{}:
+		for _, x := range v {
+			if _, ok := x.([]interface{}); ok {
+				return fmt.Errorf("xml: element %s: nested array is not supported", name)
+			}
+			if err := encodeXMLElement(buf, name, x); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case map[string]interface{}:
+		keys := sortedKeys(v)
+		buf.WriteByte('<')
+		buf.WriteString(name)
+		for _, k := range keys {
+			if !strings.HasPrefix(k, xmlAttrPrefix) {
+				continue
+			}
+			if !isScalar(v[k]) {
+				return fmt.Errorf("xml: attribute %s of element %s: must be a scalar", k, name)
+			}
+			buf.WriteByte(' ')
+			buf.WriteString(strings.TrimPrefix(k, xmlAttrPrefix))
+			buf.WriteString(`="`)
+			xml.EscapeText(buf, []byte(scalarString(v[k])))
+			buf.WriteByte('"')
+		}
+		buf.WriteByte('>')
+
+		if text, ok := v[xmlTextKey]; ok {
+			xml.EscapeText(buf, []byte(scalarString(text)))
+		}
+		for _, k := range keys {
+			if k == xmlTextKey || strings.HasPrefix(k, xmlAttrPrefix) {
+				continue
+			}
+			if err := encodeXMLElement(buf, k, v[k]); err != nil {
+				return err
+			}
+		}
+
+		buf.WriteString("</")
+		buf.WriteString(name)
+		buf.WriteByte('>')
+		return nil
+
+	default:
+		buf.WriteByte('<')
+		buf.WriteString(name)
+		buf.WriteByte('>')
+		xml.EscapeText(buf, []byte(scalarString(v)))
+		buf.WriteString("</")
+		buf.WriteString(name)
+		buf.WriteByte('>')
+		return nil
+	}
+}
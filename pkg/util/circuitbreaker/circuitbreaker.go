@@ -307,6 +307,11 @@ var stateStrings = []string{
 	"ForceOpen",
 }
 
+// String returns the string representation of the state.
+func (s State) String() string {
+	return stateStrings[s]
+}
+
 // NewPolicy create and initialize a policy
 func NewPolicy(failureRateThreshold, slowCallRateThreshold, slidingWindowType uint8,
 	slidingWindowSize, permittedNumberOfCallsInHalfOpen, minimumNumberOfCalls uint32,
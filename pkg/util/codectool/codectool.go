@@ -19,6 +19,7 @@
 package codectool
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -72,6 +73,22 @@ func UnmarshalJSON(data []byte, v interface{}) error {
 	return json.Unmarshal(data, v)
 }
 
+// UnmarshalJSONNumber is like UnmarshalJSON, but it decodes numbers into
+// interface{} values as json.Number instead of float64, so that the numbers
+// keep their precision and original literals when marshaled again. Like
+// UnmarshalJSON, it fails if there's data after the JSON value.
+func UnmarshalJSONNumber(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(v); err != nil {
+		return err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return fmt.Errorf("invalid character after top-level value")
+	}
+	return nil
+}
+
 // MustUnmarshalJSON wraps json.Unmarshal.
 // It panics if an error occurs.
 func MustUnmarshalJSON(data []byte, v interface{}) {
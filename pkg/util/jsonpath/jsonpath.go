@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package jsonpath provides a minimal JSONPath implementation, which only
+// supports the child operators.
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Path is a parsed JSONPath.
+	Path []elem
+
+	// elem is an element of a JSONPath, it is either an object key or
+	// an array index.
+	elem struct {
+		key   string
+		index int
+	}
+)
+
+// Parse parses the JSONPath, only child operators are supported, that's
+// the path looks like $.a.b, $.a[0].b or $['a']['b'].
+func Parse(path string) (Path, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid JSONPath %s: must start with $", path)
+	}
+
+	var elems Path
+	p := path[1:]
+	for len(p) > 0 {
+		switch {
+		case p[0] == '.':
+			p = p[1:]
+			end := strings.IndexAny(p, ".[")
+			if end == -1 {
+				end = len(p)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid JSONPath %s: empty key", path)
+			}
+			elems = append(elems, elem{key: p[:end], index: -1})
+			p = p[end:]
+
+		case strings.HasPrefix(p, "['"):
+			end := strings.Index(p, "']")
+			if end == -1 {
+				return nil, fmt.Errorf("invalid JSONPath %s: unclosed bracket", path)
+			}
+			elems = append(elems, elem{key: p[2:end], index: -1})
+			p = p[end+2:]
+
+		case p[0] == '[':
+			end := strings.IndexByte(p, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("invalid JSONPath %s: unclosed bracket", path)
+			}
+			index, err := strconv.Atoi(p[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid JSONPath %s: invalid index %s", path, p[1:end])
+			}
+			elems = append(elems, elem{index: index})
+			p = p[end+1:]
+
+		default:
+			return nil, fmt.Errorf("invalid JSONPath %s", path)
+		}
+	}
+
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("invalid JSONPath %s: no field", path)
+	}
+	return elems, nil
+}
+
+// Lookup returns the value at the path of v, which is a value decoded from
+// JSON or a compatible format, it returns false if the value is not found.
+func (p Path) Lookup(v interface{}) (interface{}, bool) {
+	for _, e := range p {
+		if e.index < 0 {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[e.key]; !ok {
+				return nil, false
+			}
+		} else {
+			a, ok := v.([]interface{})
+			if !ok || e.index >= len(a) {
+				return nil, false
+			}
+			v = a[e.index]
+		}
+	}
+	return v, true
+}
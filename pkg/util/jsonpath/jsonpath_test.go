@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jsonpath
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	elems, err := Parse("$.a['b.c'][2].d")
+	assert.Nil(err)
+	assert.Equal(Path{
+		{key: "a", index: -1},
+		{key: "b.c", index: -1},
+		{index: 2},
+		{key: "d", index: -1},
+	}, elems)
+
+	for _, p := range []string{"", "$", "a.b", "$.", "$..a", "$[a]", "$[-1]", "$['a'", "$[1", "$a"} {
+		_, err = Parse(p)
+		assert.NotNil(err, p)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	assert := assert.New(t)
+
+	v := map[string]interface{}{
+		"a": map[string]interface{}{
+			"b.c": []interface{}{"x", "y", map[string]interface{}{"d": 1.5}},
+		},
+	}
+
+	p, _ := Parse("$.a['b.c'][2].d")
+	got, ok := p.Lookup(v)
+	assert.True(ok)
+	assert.Equal(1.5, got)
+
+	p, _ = Parse("$.a['b.c']")
+	got, ok = p.Lookup(v)
+	assert.True(ok)
+	assert.Len(got, 3)
+
+	for _, path := range []string{"$.b", "$.a['b.c'][3]", "$.a[0]", "$.a['b.c'].d", "$.a['b.c'][0].d"} {
+		p, _ = Parse(path)
+		_, ok = p.Lookup(v)
+		assert.False(ok, path)
+	}
+}
@@ -0,0 +1,212 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package pathnormalizer normalizes request paths to prevent path traversal
+// and router bypass attacks.
+package pathnormalizer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// ActionNormalize normalizes suspicious paths.
+	ActionNormalize = "Normalize"
+	// ActionReject rejects suspicious paths.
+	ActionReject = "Reject"
+
+	// maxDecodeRounds is the max rounds of percent decoding, to handle
+	// multiple encoded payloads like %25252e.
+	maxDecodeRounds = 3
+)
+
+type (
+	// Spec describes the PathNormalizer.
+	Spec struct {
+		Action string `json:"action,omitempty" jsonschema:"enum=,enum=Normalize,enum=Reject"`
+	}
+
+	// PathNormalizer normalizes request paths.
+	PathNormalizer struct {
+		spec *Spec
+	}
+)
+
+// New creates a PathNormalizer, it returns nil if spec is nil.
+func New(spec *Spec) *PathNormalizer {
+	if spec == nil {
+		return nil
+	}
+	return &PathNormalizer{spec: spec}
+}
+
+// NormalizeRequest normalizes the path of the request, it returns false
+// if the request should be rejected. It is safe to call it on a nil
+// PathNormalizer, which does nothing.
+func (pn *PathNormalizer) NormalizeRequest(r *http.Request) bool {
+	if pn == nil {
+		return true
+	}
+
+	p, suspicious, err := Normalize(r.URL.Path)
+	if err != nil {
+		return false
+	}
+	if suspicious && pn.spec.Action == ActionReject {
+		return false
+	}
+
+	if p != r.URL.Path {
+		r.URL.Path = p
+		r.URL.RawPath = ""
+	}
+	return true
+}
+
+// Normalize normalizes the decoded path p: it decodes remaining percent
+// encodings and overlong UTF-8 sequences, replaces backslashes with slashes,
+// collapses duplicate slashes and resolves dot segments. It also reports
+// whether p is suspicious, that's, it contains something other than
+// duplicate slashes that has to be normalized. An error is returned if p
+// is malformed, e.g. it contains a NUL byte or invalid UTF-8.
+func Normalize(p string) (string, bool, error) {
+	suspicious := false
+
+	// Multiple encoded payloads, the path has already been decoded once.
+	for i := 0; i < maxDecodeRounds && hasPercentEncoding(p); i++ {
+		d, err := url.PathUnescape(p)
+		if err != nil {
+			break
+		}
+		p = d
+		suspicious = true
+	}
+	if hasPercentEncoding(p) {
+		return "", true, fmt.Errorf("too many rounds of percent encoding")
+	}
+
+	if !utf8.ValidString(p) {
+		d, err := decodeOverlongUTF8(p)
+		if err != nil {
+			return "", true, err
+		}
+		p = d
+		suspicious = true
+	}
+
+	if strings.IndexByte(p, 0) >= 0 {
+		return "", true, fmt.Errorf("NUL byte in path")
+	}
+
+	if strings.IndexByte(p, '\\') >= 0 {
+		p = strings.ReplaceAll(p, "\\", "/")
+		suspicious = true
+	}
+
+	np, dotSegments := clean(p)
+	return np, suspicious || dotSegments, nil
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func hasPercentEncoding(p string) bool {
+	for i := 0; i+2 < len(p); i++ {
+		if p[i] == '%' && isHex(p[i+1]) && isHex(p[i+2]) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeOverlongUTF8 decodes overlong UTF-8 sequences, like 0xC0 0xAE for
+// '.', which are invalid UTF-8 but are accepted by some backends. An error
+// is returned for other invalid sequences.
+func decodeOverlongUTF8(p string) (string, error) {
+	var sb strings.Builder
+
+	isCont := func(i int) bool {
+		return i < len(p) && p[i]&0xC0 == 0x80
+	}
+
+	for i := 0; i < len(p); {
+		r, size := utf8.DecodeRuneInString(p[i:])
+		if r != utf8.RuneError || size != 1 {
+			sb.WriteString(p[i : i+size])
+			i += size
+			continue
+		}
+
+		c := p[i]
+		switch {
+		case (c == 0xC0 || c == 0xC1) && isCont(i+1):
+			r = rune(c&0x1F)<<6 | rune(p[i+1]&0x3F)
+			size = 2
+		case c == 0xE0 && isCont(i+1) && p[i+1] < 0xA0 && isCont(i+2):
+			r = rune(p[i+1]&0x3F)<<6 | rune(p[i+2]&0x3F)
+			size = 3
+		case c == 0xF0 && isCont(i+1) && p[i+1] < 0x90 && isCont(i+2) && isCont(i+3):
+			r = rune(p[i+1]&0x3F)<<12 | rune(p[i+2]&0x3F)<<6 | rune(p[i+3]&0x3F)
+			size = 4
+		default:
+			return "", fmt.Errorf("invalid UTF-8 in path")
+		}
+
+		sb.WriteRune(r)
+		i += size
+	}
+
+	return sb.String(), nil
+}
+
+// clean collapses duplicate slashes and resolves dot segments, the trailing
+// slash is preserved. It also reports whether there are dot segments.
+func clean(p string) (string, bool) {
+	trailingSlash := strings.HasSuffix(p, "/")
+	dotSegments := false
+
+	segments := make([]string, 0, strings.Count(p, "/")+1)
+	for _, s := range strings.Split(p, "/") {
+		switch s {
+		case "":
+		case ".":
+			dotSegments = true
+		case "..":
+			dotSegments = true
+			if len(segments) > 0 {
+				segments = segments[:len(segments)-1]
+			}
+		default:
+			segments = append(segments, s)
+		}
+	}
+
+	if len(segments) == 0 {
+		return "/", dotSegments
+	}
+
+	np := "/" + strings.Join(segments, "/")
+	if trailingSlash {
+		np += "/"
+	}
+	return np, dotSegments
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pathnormalizer
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bypassPayloads are raw request URIs of known path traversal and router
+// bypass payloads, and the expected normalized paths.
+var bypassPayloads = []struct {
+	uri  string
+	path string
+}{
+	{"/static/../admin", "/admin"},
+	{"/static/./../admin", "/admin"},
+	{"/../../../etc/passwd", "/etc/passwd"},
+	{"/static/%2e%2e/admin", "/admin"},
+	{"/static/%2E%2E/admin", "/admin"},
+	{"/static/.%2e/admin", "/admin"},
+	{"/static/%2e%2e%2fadmin", "/admin"},
+	{"/static/..%2fadmin", "/admin"},
+	{"/static/%252e%252e/admin", "/admin"},
+	{"/static/%25252e%25252e/admin", "/admin"},
+	{"/static/..%252fadmin", "/admin"},
+	{"/static/%c0%ae%c0%ae/admin", "/admin"},
+	{"/static/%c0%ae%c0%ae%c0%afadmin", "/admin"},
+	{"/static/%e0%80%ae%e0%80%ae/admin", "/admin"},
+	{"/static/%f0%80%80%ae%f0%80%80%ae/admin", "/admin"},
+	{"/static/..%5cadmin", "/admin"},
+	{"/static/..\\admin", "/admin"},
+	{"/static/%255c..%255cadmin", "/admin"},
+	{"/static/./admin", "/static/admin"},
+	{"/./admin", "/admin"},
+	{"/admin/.", "/admin"},
+	{"/admin/..", "/"},
+}
+
+func newRequest(t *testing.T, uri string) *http.Request {
+	req, err := http.ReadRequest(bufioReader("GET " + uri + " HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	assert.Nil(t, err, uri)
+	return req
+}
+
+func TestBypassPayloads(t *testing.T) {
+	assert := assert.New(t)
+
+	normalizer := New(&Spec{Action: ActionNormalize})
+	rejecter := New(&Spec{Action: ActionReject})
+
+	for _, c := range bypassPayloads {
+		req := newRequest(t, c.uri)
+		assert.True(normalizer.NormalizeRequest(req), c.uri)
+		assert.Equal(c.path, req.URL.Path, c.uri)
+		assert.Equal("", req.URL.RawPath, c.uri)
+
+		req = newRequest(t, c.uri)
+		assert.False(rejecter.NormalizeRequest(req), c.uri)
+	}
+}
+
+func TestMalformedPaths(t *testing.T) {
+	assert := assert.New(t)
+
+	normalizer := New(&Spec{})
+	for _, uri := range []string{
+		"/static/%00/admin",
+		"/static/%2500/admin",
+		"/static/%ff/admin",
+		"/static/%c0/admin",
+		"/static/%252525252e%252525252e/admin",
+	} {
+		req := newRequest(t, uri)
+		assert.False(normalizer.NormalizeRequest(req), uri)
+	}
+}
+
+func TestNormalPaths(t *testing.T) {
+	assert := assert.New(t)
+
+	rejecter := New(&Spec{Action: ActionReject})
+	for _, c := range []struct {
+		uri  string
+		path string
+	}{
+		{"/", "/"},
+		{"/api/v1/users", "/api/v1/users"},
+		{"/api/v1/users/", "/api/v1/users/"},
+		{"//api///v1//users", "/api/v1/users"},
+		{"/files/a.b..c", "/files/a.b..c"},
+		{"/files/...", "/files/..."},
+		{"/%E4%BD%A0%E5%A5%BD", "/你好"},
+		{"/a%20b", "/a b"},
+	} {
+		req := newRequest(t, c.uri)
+		assert.True(rejecter.NormalizeRequest(req), c.uri)
+		assert.Equal(c.path, req.URL.Path, c.uri)
+	}
+
+	var pn *PathNormalizer
+	req := newRequest(t, "/static/../admin")
+	assert.True(pn.NormalizeRequest(req))
+	assert.Equal("/static/../admin", req.URL.Path)
+	assert.Nil(New(nil))
+}
+
+func bufioReader(s string) *bufio.Reader {
+	return bufio.NewReader(strings.NewReader(s))
+}
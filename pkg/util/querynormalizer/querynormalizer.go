@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package querynormalizer normalizes duplicate query parameters to prevent
+// parameter pollution attacks.
+package querynormalizer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// PolicyFirst keeps the first value of duplicate parameters.
+	PolicyFirst = "first"
+	// PolicyLast keeps the last value of duplicate parameters.
+	PolicyLast = "last"
+	// PolicyCombine combines the values of duplicate parameters into a
+	// comma-separated one.
+	PolicyCombine = "combine"
+	// PolicyReject rejects requests with duplicate parameters.
+	PolicyReject = "reject"
+	// PolicyAll keeps all values, for parameters which are multi-valued
+	// by design.
+	PolicyAll = "all"
+)
+
+type (
+	// Spec describes the QueryNormalizer.
+	Spec struct {
+		// Policy is the policy of duplicate parameters, default is first.
+		Policy string `json:"policy,omitempty" jsonschema:"enum=,enum=first,enum=last,enum=combine,enum=reject,enum=all"`
+		// Params are the policies of specific parameters, which override
+		// Policy.
+		Params map[string]string `json:"params,omitempty"`
+		// CaseInsensitive treats parameter names differ only in case as
+		// duplicates, as some backends do.
+		CaseInsensitive bool `json:"caseInsensitive,omitempty"`
+	}
+
+	// QueryNormalizer normalizes duplicate query parameters.
+	QueryNormalizer struct {
+		spec   *Spec
+		params map[string]string
+	}
+
+	param struct {
+		name   string
+		values []string
+		pairs  []string
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	for name, policy := range spec.Params {
+		if !validPolicy(policy) {
+			return fmt.Errorf("param %s: invalid policy %q", name, policy)
+		}
+	}
+	return nil
+}
+
+func validPolicy(policy string) bool {
+	switch policy {
+	case PolicyFirst, PolicyLast, PolicyCombine, PolicyReject, PolicyAll:
+		return true
+	}
+	return false
+}
+
+// New creates a QueryNormalizer, it returns nil if spec is nil.
+func New(spec *Spec) *QueryNormalizer {
+	if spec == nil {
+		return nil
+	}
+
+	qn := &QueryNormalizer{spec: spec, params: map[string]string{}}
+	for name, policy := range spec.Params {
+		qn.params[qn.foldName(name)] = policy
+	}
+	return qn
+}
+
+func (qn *QueryNormalizer) foldName(name string) string {
+	if qn.spec.CaseInsensitive {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+func (qn *QueryNormalizer) policy(name string) string {
+	if p := qn.params[name]; p != "" {
+		return p
+	}
+	if qn.spec.Policy != "" {
+		return qn.spec.Policy
+	}
+	return PolicyFirst
+}
+
+// NormalizeRequest normalizes the query of the request, it returns false
+// if the request should be rejected. It is safe to call it on a nil
+// QueryNormalizer, which does nothing.
+func (qn *QueryNormalizer) NormalizeRequest(r *http.Request) bool {
+	if qn == nil || r.URL.RawQuery == "" {
+		return true
+	}
+
+	q, err := qn.Normalize(r.URL.RawQuery)
+	if err != nil {
+		return false
+	}
+	r.URL.RawQuery = q
+	return true
+}
+
+// Normalize normalizes the raw query. The parameters are compared by their
+// decoded names, so encoded names like %61 can't bypass the policies, and
+// the parameters without duplicates are kept as they are. An error is
+// returned if the query is malformed, which includes semicolons, as they
+// are separators for some backends, or if a parameter with the reject
+// policy is duplicated.
+func (qn *QueryNormalizer) Normalize(rawQuery string) (string, error) {
+	var params []*param
+	index := map[string]*param{}
+	duplicated := false
+
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		if strings.Contains(pair, ";") {
+			return "", fmt.Errorf("semicolon in query")
+		}
+
+		rawName, rawValue, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			return "", fmt.Errorf("invalid param name %q", rawName)
+		}
+		if _, err = url.QueryUnescape(rawValue); err != nil {
+			return "", fmt.Errorf("invalid value of param %q", name)
+		}
+
+		key := qn.foldName(name)
+		p := index[key]
+		if p == nil {
+			p = &param{name: key}
+			index[key] = p
+			params = append(params, p)
+		} else {
+			duplicated = true
+		}
+		p.values = append(p.values, rawValue)
+		p.pairs = append(p.pairs, pair)
+	}
+
+	if !duplicated {
+		return rawQuery, nil
+	}
+
+	pairs := make([]string, 0, len(params))
+	for _, p := range params {
+		if len(p.pairs) == 1 {
+			pairs = append(pairs, p.pairs[0])
+			continue
+		}
+
+		switch qn.policy(p.name) {
+		case PolicyFirst:
+			pairs = append(pairs, p.pairs[0])
+		case PolicyLast:
+			pairs = append(pairs, p.pairs[len(p.pairs)-1])
+		case PolicyCombine:
+			rawName, _, _ := strings.Cut(p.pairs[0], "=")
+			pairs = append(pairs, rawName+"="+strings.Join(p.values, ","))
+		case PolicyReject:
+			return "", fmt.Errorf("duplicate param %q", p.name)
+		default:
+			pairs = append(pairs, p.pairs...)
+		}
+	}
+	return strings.Join(pairs, "&"), nil
+}
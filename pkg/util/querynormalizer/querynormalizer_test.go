@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package querynormalizer
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pollutionPayloads are raw queries of known parameter pollution payloads,
+// which try to make the gateway and the backend see different values of
+// the role parameter. After normalization, the backend should see exactly
+// the value expected.
+var pollutionPayloads = []struct {
+	query string
+	first string
+	last  string
+}{
+	{"role=user&role=admin", "user", "admin"},
+	{"role=user&%72ole=admin", "user", "admin"},
+	{"role=user&%72%6f%6c%65=admin", "user", "admin"},
+	{"role=user&&role=admin", "user", "admin"},
+	{"role=user&x=1&role=admin", "user", "admin"},
+	{"role=&role=admin", "", "admin"},
+	{"role=user&role", "user", ""},
+}
+
+func TestPollutionPayloads(t *testing.T) {
+	assert := assert.New(t)
+
+	first := New(&Spec{})
+	last := New(&Spec{Policy: PolicyLast})
+	rejecter := New(&Spec{Policy: PolicyReject})
+
+	for _, c := range pollutionPayloads {
+		q, err := first.Normalize(c.query)
+		assert.Nil(err, c.query)
+		values, _ := url.ParseQuery(q)
+		assert.Equal([]string{c.first}, values["role"], c.query)
+
+		q, err = last.Normalize(c.query)
+		assert.Nil(err, c.query)
+		values, _ = url.ParseQuery(q)
+		assert.Equal([]string{c.last}, values["role"], c.query)
+
+		_, err = rejecter.Normalize(c.query)
+		assert.Error(err, c.query)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	assert := assert.New(t)
+
+	qn := New(&Spec{Policy: PolicyAll})
+	for _, q := range []string{
+		// semicolons are separators for some backends.
+		"role=user;role=admin",
+		"role=user&x=1;role=admin",
+		"role=user&%zzole=admin",
+		"role=user%zz",
+	} {
+		_, err := qn.Normalize(q)
+		assert.Error(err, q)
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	assert := assert.New(t)
+
+	qn := New(&Spec{CaseInsensitive: true, Params: map[string]string{"Role": PolicyReject}})
+	_, err := qn.Normalize("role=user&ROLE=admin")
+	assert.Error(err)
+
+	q, err := qn.Normalize("id=1&ID=2")
+	assert.Nil(err)
+	assert.Equal("id=1", q)
+
+	// names differ in case are different parameters by default.
+	q, err = New(&Spec{Policy: PolicyReject}).Normalize("role=user&ROLE=admin")
+	assert.Nil(err)
+	assert.Equal("role=user&ROLE=admin", q)
+}
+
+func TestPolicies(t *testing.T) {
+	assert := assert.New(t)
+
+	qn := New(&Spec{
+		Policy: PolicyFirst,
+		Params: map[string]string{
+			"tag":  PolicyAll,
+			"sort": PolicyLast,
+			"ids":  PolicyCombine,
+		},
+	})
+
+	q, err := qn.Normalize("tag=a&ids=1&sort=name&tag=b&ids=2%2C3&sort=date&page=1&page=2&q=a+b")
+	assert.Nil(err)
+	assert.Equal("tag=a&tag=b&ids=1,2%2C3&sort=date&page=1&q=a+b", q)
+
+	// the query is untouched without duplicates.
+	q, err = qn.Normalize("b=%41&a=1&&")
+	assert.Nil(err)
+	assert.Equal("b=%41&a=1&&", q)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/?page=1&page=2", nil)
+	assert.True(qn.NormalizeRequest(req))
+	assert.Equal("page=1", req.URL.RawQuery)
+
+	var nilQN *QueryNormalizer
+	assert.True(nilQN.NormalizeRequest(req))
+	assert.Nil(New(nil))
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil((&Spec{Params: map[string]string{"a": PolicyCombine}}).Validate())
+	assert.Error((&Spec{Params: map[string]string{"a": "merge"}}).Validate())
+}
@@ -71,6 +71,11 @@ var stateStrings = []string{
 	"Disabled",
 }
 
+// String returns the string representation of the state.
+func (s State) String() string {
+	return stateStrings[s]
+}
+
 // NewPolicy create and initialize a policy
 func NewPolicy(timeout, refresh time.Duration, limit int) *Policy {
 	return &Policy{
@@ -109,6 +114,13 @@ func (rl *RateLimiter) SetState(state State) {
 	rl.state = state
 }
 
+// State returns the state of the rate limiter
+func (rl *RateLimiter) State() State {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	return rl.state
+}
+
 // SetStateListener sets a state listener for the RateLimiter
 func (rl *RateLimiter) SetStateListener(listener EventListenerFunc) {
 	rl.lock.Lock()
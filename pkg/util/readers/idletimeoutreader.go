@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package readers
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrIdleTimeout is returned by IdleTimeoutReader after it is aborted.
+var ErrIdleTimeout = errors.New("idle timeout")
+
+// IdleTimeoutReader wraps an io.Reader, and aborts it if a read is blocked
+// for longer than the timeout, i.e. no bytes flow for the timeout. The
+// underlying io.Reader must be an io.Closer, and unblock the pending read
+// when it is closed.
+//
+// The timer only runs when a read is pending, so a slow consumer doesn't
+// cause the reader to be aborted.
+type IdleTimeoutReader struct {
+	r       io.ReadCloser
+	timeout time.Duration
+	onAbort func(bytesRead int)
+
+	timer     *time.Timer
+	bytesRead int64
+	aborted   int32
+	closeOnce sync.Once
+}
+
+// NewIdleTimeoutReader creates an IdleTimeoutReader, onAbort is called with
+// the count of bytes has been read when the reader is aborted, it could be
+// nil.
+func NewIdleTimeoutReader(r io.ReadCloser, timeout time.Duration, onAbort func(bytesRead int)) *IdleTimeoutReader {
+	return &IdleTimeoutReader{r: r, timeout: timeout, onAbort: onAbort}
+}
+
+func (r *IdleTimeoutReader) abort() {
+	if !atomic.CompareAndSwapInt32(&r.aborted, 0, 1) {
+		return
+	}
+	r.closeOnce.Do(func() { r.r.Close() })
+	if r.onAbort != nil {
+		r.onAbort(int(atomic.LoadInt64(&r.bytesRead)))
+	}
+}
+
+// Read implements io.Reader.
+func (r *IdleTimeoutReader) Read(p []byte) (int, error) {
+	if r.Aborted() {
+		return 0, ErrIdleTimeout
+	}
+
+	if r.timer == nil {
+		r.timer = time.AfterFunc(r.timeout, r.abort)
+	} else {
+		r.timer.Reset(r.timeout)
+	}
+	n, err := r.r.Read(p)
+	r.timer.Stop()
+	atomic.AddInt64(&r.bytesRead, int64(n))
+
+	if r.Aborted() {
+		return n, ErrIdleTimeout
+	}
+	return n, err
+}
+
+// Aborted returns whether the reader is aborted for the idle timeout.
+func (r *IdleTimeoutReader) Aborted() bool {
+	return atomic.LoadInt32(&r.aborted) == 1
+}
+
+// Close implements io.Closer and closes the underlying io.Reader.
+func (r *IdleTimeoutReader) Close() error {
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	var err error
+	r.closeOnce.Do(func() { err = r.r.Close() })
+	return err
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package readers
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdleTimeoutReader(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewIdleTimeoutReader(io.NopCloser(strings.NewReader("123")), time.Second, nil)
+	data, err := io.ReadAll(r)
+	assert.Nil(err)
+	assert.Equal("123", string(data))
+	assert.False(r.Aborted())
+	assert.Nil(r.Close())
+
+	// the stream keeps flowing for longer than the timeout, but it is
+	// never idle for the timeout.
+	pr, pw := io.Pipe()
+	aborted := make(chan int, 1)
+	r = NewIdleTimeoutReader(pr, 50*time.Millisecond, func(n int) { aborted <- n })
+	go func() {
+		for i := 0; i < 5; i++ {
+			pw.Write([]byte("a"))
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+
+	buf := make([]byte, 10)
+	total := 0
+	for total < 5 {
+		n, err := r.Read(buf)
+		assert.Nil(err)
+		total += n
+	}
+
+	// then the stream is idle.
+	n, err := r.Read(buf)
+	assert.Equal(0, n)
+	assert.Equal(ErrIdleTimeout, err)
+	assert.True(r.Aborted())
+	assert.Equal(5, <-aborted)
+
+	_, err = r.Read(buf)
+	assert.Equal(ErrIdleTimeout, err)
+	assert.Nil(r.Close())
+}
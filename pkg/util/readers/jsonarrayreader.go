@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package readers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// JSONArrayTransformFunc transforms an element of a JSON array, the element
+// is decoded with json.Number for numbers. It returns the JSON of the new
+// element, the element is dropped if the result is empty.
+type JSONArrayTransformFunc func(index int, element interface{}) ([]byte, error)
+
+// JSONArrayTransformReader reads a top-level JSON array from an io.Reader,
+// and transforms its elements one by one, so that only one element is kept
+// in memory no matter how large the array is.
+type JSONArrayTransformReader struct {
+	r       io.Reader
+	dec     *json.Decoder
+	fn      JSONArrayTransformFunc
+	buf     bytes.Buffer
+	index   int
+	started bool
+	written bool
+	err     error
+}
+
+const jsonArrayPeekSize = 4096
+
+// NewJSONArrayTransformReader creates a reader which transforms the
+// elements of the top-level JSON array read from r by fn. If the data of r
+// doesn't start with an array, the returned reader returns the data as it
+// is, and ok is false.
+func NewJSONArrayTransformReader(r io.Reader, fn JSONArrayTransformFunc) (reader io.ReadCloser, ok bool) {
+	br := bufio.NewReaderSize(r, jsonArrayPeekSize)
+
+	// skip the leading white spaces without consuming them, so that the
+	// data could be returned as it is if it is not an array.
+	for n := 1; n <= jsonArrayPeekSize; n++ {
+		p, _ := br.Peek(n)
+		if len(p) < n {
+			break
+		}
+		c := p[n-1]
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+		if c == '[' {
+			dec := json.NewDecoder(br)
+			dec.UseNumber()
+			return &JSONArrayTransformReader{r: r, dec: dec, fn: fn}, true
+		}
+		break
+	}
+
+	return &passThroughReader{Reader: br, r: r}, false
+}
+
+// fill transforms the next element into the buffer.
+func (r *JSONArrayTransformReader) fill() {
+	if !r.started {
+		// the first token has been checked to be '['.
+		if _, r.err = r.dec.Token(); r.err != nil {
+			return
+		}
+		r.started = true
+		r.buf.WriteByte('[')
+		return
+	}
+
+	if !r.dec.More() {
+		tok, err := r.dec.Token()
+		if err != nil {
+			r.err = err
+		} else if tok != json.Delim(']') {
+			r.err = fmt.Errorf("unexpected token %v", tok)
+		} else {
+			r.buf.WriteByte(']')
+			r.err = io.EOF
+		}
+		return
+	}
+
+	var element interface{}
+	if r.err = r.dec.Decode(&element); r.err != nil {
+		return
+	}
+
+	out, err := r.fn(r.index, element)
+	r.index++
+	if err != nil {
+		r.err = err
+		return
+	}
+	if len(out) == 0 {
+		return
+	}
+	if r.written {
+		r.buf.WriteByte(',')
+	}
+	r.buf.Write(out)
+	r.written = true
+}
+
+// Read implements io.Reader.
+func (r *JSONArrayTransformReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.fill()
+	}
+	return r.buf.Read(p)
+}
+
+// Close closes the underlying reader if it is an io.Closer.
+func (r *JSONArrayTransformReader) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type passThroughReader struct {
+	io.Reader
+	r io.Reader
+}
+
+func (r *passThroughReader) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package readers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONArrayTransformReader(t *testing.T) {
+	assert := assert.New(t)
+
+	double := func(index int, element interface{}) ([]byte, error) {
+		m := element.(map[string]interface{})
+		n, _ := m["n"].(json.Number).Int64()
+		if n < 0 {
+			return nil, nil
+		}
+		return []byte(fmt.Sprintf(`{"i":%d,"n":%d}`, index, n*2)), nil
+	}
+
+	r, ok := NewJSONArrayTransformReader(strings.NewReader(` [{"n": 1}, {"n": -1}, {"n": 3}] `), double)
+	assert.True(ok)
+	data, err := io.ReadAll(r)
+	assert.NoError(err)
+	assert.Equal(`[{"i":0,"n":2},{"i":2,"n":6}]`, string(data))
+	assert.NoError(r.Close())
+
+	r, ok = NewJSONArrayTransformReader(strings.NewReader(`[]`), double)
+	assert.True(ok)
+	data, _ = io.ReadAll(r)
+	assert.Equal(`[]`, string(data))
+
+	// not an array.
+	for _, s := range []string{` {"n": 1}`, `hello`, ``, `   `} {
+		r, ok = NewJSONArrayTransformReader(strings.NewReader(s), double)
+		assert.False(ok)
+		data, _ = io.ReadAll(r)
+		assert.Equal(s, string(data))
+	}
+
+	// invalid JSON.
+	r, _ = NewJSONArrayTransformReader(strings.NewReader(`[{"n": 1}, {"n": `), double)
+	_, err = io.ReadAll(r)
+	assert.Error(err)
+
+	// error of the transform function.
+	r, _ = NewJSONArrayTransformReader(strings.NewReader(`[1]`), func(int, interface{}) ([]byte, error) {
+		return nil, fmt.Errorf("mocked error")
+	})
+	_, err = io.ReadAll(r)
+	assert.EqualError(err, "mocked error")
+}
+
+func TestJSONArrayTransformReaderLargeArray(t *testing.T) {
+	assert := assert.New(t)
+
+	// the array is generated on the fly, so the memory is bounded only
+	// if the reader doesn't buffer the whole array.
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("["))
+		for i := 0; i < 100000; i++ {
+			if i > 0 {
+				pw.Write([]byte(","))
+			}
+			pw.Write([]byte(`{"n":1}`))
+		}
+		pw.Write([]byte("]"))
+		pw.Close()
+	}()
+
+	count := 0
+	r, ok := NewJSONArrayTransformReader(pr, func(index int, element interface{}) ([]byte, error) {
+		count++
+		return []byte("1"), nil
+	})
+	assert.True(ok)
+	n, err := io.Copy(io.Discard, r)
+	assert.NoError(err)
+	assert.Equal(100000, count)
+	assert.Equal(int64(2*100000+1), n)
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package readers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// LineTransformFunc transforms a line, the line doesn't contain the line
+// ending. It returns the new line, which is dropped if it is empty.
+type LineTransformFunc func(index int, line []byte) ([]byte, error)
+
+// LineTransformReader reads lines from an io.Reader, and transforms them
+// one by one, so that only one line is kept in memory no matter how large
+// the data is. Blank lines are skipped, and every output line ends with
+// a "\n".
+type LineTransformReader struct {
+	r     io.Reader
+	br    *bufio.Reader
+	fn    LineTransformFunc
+	buf   bytes.Buffer
+	index int
+	err   error
+}
+
+// NewLineTransformReader creates a reader which transforms the lines read
+// from r by fn, a line longer than maxLineSize fails the reader.
+func NewLineTransformReader(r io.Reader, maxLineSize int, fn LineTransformFunc) *LineTransformReader {
+	return &LineTransformReader{
+		r:  r,
+		br: bufio.NewReaderSize(r, maxLineSize),
+		fn: fn,
+	}
+}
+
+// fill transforms the next line into the buffer.
+func (r *LineTransformReader) fill() {
+	line, err := r.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		r.err = fmt.Errorf("line %d is too long", r.index)
+		return
+	}
+	if err != nil && err != io.EOF {
+		r.err = err
+		return
+	}
+
+	line = bytes.TrimRight(line, "\r\n")
+	if len(bytes.TrimSpace(line)) > 0 {
+		out, fnErr := r.fn(r.index, line)
+		r.index++
+		if fnErr != nil {
+			r.err = fnErr
+			return
+		}
+		if len(out) > 0 {
+			r.buf.Write(out)
+			r.buf.WriteByte('\n')
+		}
+	}
+
+	// io.EOF is returned after the buffered lines are read out.
+	r.err = err
+}
+
+// Read implements io.Reader.
+func (r *LineTransformReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.fill()
+	}
+	return r.buf.Read(p)
+}
+
+// Close closes the underlying reader if it is an io.Closer.
+func (r *LineTransformReader) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package readers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineTransformReader(t *testing.T) {
+	assert := assert.New(t)
+
+	upper := func(index int, line []byte) ([]byte, error) {
+		if bytes.Equal(line, []byte("drop")) {
+			return nil, nil
+		}
+		if bytes.Equal(line, []byte("fail")) {
+			return nil, fmt.Errorf("line %d failed", index)
+		}
+		return []byte(fmt.Sprintf("%d:%s", index, bytes.ToUpper(line))), nil
+	}
+
+	r := NewLineTransformReader(strings.NewReader("a\r\n\n  \nb\ndrop\nc"), 16, upper)
+	data, err := io.ReadAll(r)
+	assert.NoError(err)
+	assert.Equal("0:A\n1:B\n3:C\n", string(data))
+	assert.NoError(r.Close())
+
+	r = NewLineTransformReader(strings.NewReader(""), 16, upper)
+	data, err = io.ReadAll(r)
+	assert.NoError(err)
+	assert.Equal("", string(data))
+
+	r = NewLineTransformReader(strings.NewReader("a\nfail\nb\n"), 16, upper)
+	data, err = io.ReadAll(r)
+	assert.EqualError(err, "line 1 failed")
+	assert.Equal("0:A\n", string(data))
+
+	r = NewLineTransformReader(strings.NewReader("a\n"+strings.Repeat("x", 32)+"\n"), 16, upper)
+	data, err = io.ReadAll(r)
+	assert.EqualError(err, "line 1 is too long")
+	assert.Equal("0:A\n", string(data))
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package routeheader provides the request header which carries the
+// decision made by a filter, e.g. a route or labels, to the proxy pools.
+package routeheader
+
+import (
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// Header is the request header to carry the decision of a filter, proxy
+// pools could select requests by it.
+type Header string
+
+// Validate validates the header name, an empty header is valid, it means
+// the decision is not carried by any header.
+func (h Header) Validate() error {
+	if h != "" && !httpguts.ValidHeaderFieldName(string(h)) {
+		return fmt.Errorf("invalid header %q", string(h))
+	}
+	return nil
+}
+
+// Reset removes the header sent by the client, so the decision can't be
+// forged. It must be called before the decision is made, even if no
+// decision is made at last. It does nothing if the header is empty.
+func (h Header) Reset(header http.Header) {
+	if h != "" {
+		header.Del(string(h))
+	}
+}
+
+// Set sets the decision to the header, it does nothing if the header is
+// empty.
+func (h Header) Set(header http.Header, value string) {
+	if h != "" {
+		header.Set(string(h), value)
+	}
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package routeheader
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(Header("X-Route").Validate())
+	assert.Nil(Header("").Validate())
+	assert.NotNil(Header("X Route").Validate())
+
+	header := http.Header{}
+	header.Set("X-Route", "forged")
+	h := Header("X-Route")
+	h.Reset(header)
+	assert.Empty(header.Get("X-Route"))
+	h.Set(header, "large")
+	assert.Equal("large", header.Get("X-Route"))
+
+	// empty header.
+	header.Set("X-Route", "forged")
+	Header("").Reset(header)
+	Header("").Set(header, "large")
+	assert.Equal("forged", header.Get("X-Route"))
+	assert.Len(header, 1)
+}
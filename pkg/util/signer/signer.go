@@ -113,6 +113,7 @@ type (
 		headerHoisting *HeaderHoisting
 		ttl            time.Duration
 		excludeBody    bool
+		escapePathOnce bool
 		// accessKeyID & accessKeySecret are for signing
 		accessKeyID     string
 		accessKeySecret string
@@ -176,13 +177,18 @@ func init() {
 	}
 }
 
-func buildCanonicalURI(u *url.URL) string {
+// buildCanonicalURI escapes the escaped path again by default, which is
+// required by most of the Amazon services, if escapeOnce is true, the
+// unescaped path is escaped only once, which is required by Amazon S3.
+func buildCanonicalURI(u *url.URL, escapeOnce bool) string {
 	const hex = "0123456789ABCDEF"
 
 	var uri string
 
 	if len(u.Opaque) > 0 {
 		uri = "/" + strings.Join(strings.Split(u.Opaque, "/")[3:], "/")
+	} else if escapeOnce {
+		uri = u.Path
 	} else {
 		uri = u.EscapedPath()
 	}
@@ -217,6 +223,13 @@ func (s *Signer) ExcludeBody(exclude bool) *Signer {
 	return s
 }
 
+// EscapePathOnce is an option function for Signer to escape the path only
+// once when build the canonical URI
+func (s *Signer) EscapePathOnce(once bool) *Signer {
+	s.escapePathOnce = once
+	return s
+}
+
 // IgnoreHeader is an option function for Signer to add ignored headers
 func (s *Signer) IgnoreHeader(headers ...string) *Signer {
 	for _, h := range headers {
@@ -561,7 +574,7 @@ func (ctx *Context) hashCanonicalRequest(req *http.Request) string {
 	buf.WriteString(req.Method)
 	buf.WriteByte('\n')
 
-	buf.WriteString(buildCanonicalURI(req.URL))
+	buf.WriteString(buildCanonicalURI(req.URL, ctx.signer.escapePathOnce))
 	buf.WriteByte('\n')
 
 	buf.WriteString(ctx.getCanonicalQuery(req.URL))
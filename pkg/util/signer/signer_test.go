@@ -20,6 +20,7 @@ package signer
 import (
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"testing"
@@ -151,6 +152,18 @@ func TestBuildCanonicalHeaderValue(t *testing.T) {
 	}
 }
 
+func TestBuildCanonicalURI(t *testing.T) {
+	u, _ := url.Parse("https://example.org/bucket/a%20b/c+d")
+
+	if e, a := "/bucket/a%2520b/c%2Bd", buildCanonicalURI(u, false); e != a {
+		t.Errorf("\nexpect: %v\nactual: %v\n", e, a)
+	}
+
+	if e, a := "/bucket/a%20b/c%2Bd", buildCanonicalURI(u, true); e != a {
+		t.Errorf("\nexpect: %v\nactual: %v\n", e, a)
+	}
+}
+
 func TestSignRequest(t *testing.T) {
 	req := buildRequest("dynamodb", "us-east-1", "{}")
 